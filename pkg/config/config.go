@@ -245,6 +245,45 @@ func initConfig(config Config) {
 	config.BindEnvAndSetDefault("docker_query_timeout", int64(5))
 	config.BindEnvAndSetDefault("docker_labels_as_tags", map[string]string{})
 	config.BindEnvAndSetDefault("docker_env_as_tags", map[string]string{})
+	config.BindEnvAndSetDefault("docker_max_close_wait_connections", 0) // 0 is disabled
+	config.BindEnvAndSetDefault("docker_max_kernel_cpu_percent", 50.0)
+	config.BindEnvAndSetDefault("docker_cilium_api_url", "unix:///var/run/cilium/cilium.sock")
+	config.BindEnvAndSetDefault("docker_kubernetes_api_url", "https://kubernetes.default.svc")
+	config.BindEnvAndSetDefault("docker_expected_service_account_annotations", map[string]string{})
+	config.BindEnvAndSetDefault("docker_hpa_metrics_enabled", false)
+	config.BindEnvAndSetDefault("docker_admission_webhook_url", "") // empty is disabled
+	config.BindEnvAndSetDefault("docker_fluentd_monitor_url", "http://127.0.0.1:24220")
+	config.BindEnvAndSetDefault("docker_fd_leak_slope_threshold", 10.0) // in FDs per minute
+	config.BindEnvAndSetDefault("docker_high_priority_nice_threshold", -10)
+	config.BindEnvAndSetDefault("docker_required_isolated_namespaces", []string{"ipc", "mnt", "net", "pid", "uts"})
+	config.BindEnvAndSetDefault("docker_require_rootless_mode", false)
+	config.BindEnvAndSetDefault("docker_seccomp_default_profile", "")
+	config.BindEnvAndSetDefault("docker_learned_seccomp_dir", "")
+	config.BindEnvAndSetDefault("docker_max_fragmentation_error_rate", 0.01)
+	config.BindEnvAndSetDefault("docker_jvm_profiling_enabled", false)
+	config.BindEnvAndSetDefault("docker_async_profiler_path", "/opt/async-profiler")
+	config.BindEnvAndSetDefault("docker_page_fault_metrics_enabled", false)
+	config.BindEnvAndSetDefault("docker_max_major_page_faults_per_sec", 100.0)
+	config.BindEnvAndSetDefault("docker_malloc_tracing_enabled", false)
+	config.BindEnvAndSetDefault("docker_verify_port_listening", false)
+	config.BindEnvAndSetDefault("docker_alert_root_processes", true)
+	config.BindEnvAndSetDefault("docker_allow_policy_reload", false)
+	config.BindEnvAndSetDefault("docker_allow_profile_augmentation", false)
+	config.BindEnvAndSetDefault("docker_allow_seccomp_audit_mode", false)
+	config.BindEnvAndSetDefault("docker_gpu_power_optimization", false)
+	config.BindEnvAndSetDefault("docker_gpu_memory_leak_threshold", 100.0) // in MB per hour
+	config.BindEnvAndSetDefault("docker_gpu_p2p_sample_interval", 1)       // in seconds
+	config.BindEnvAndSetDefault("docker_gpu_ecc_single_bit_threshold", 0)
+	config.BindEnvAndSetDefault("docker_allow_packet_capture", false)
+	config.BindEnvAndSetDefault("docker_max_capture_size_bytes", 10*1024*1024)
+	config.BindEnvAndSetDefault("docker_block_bpffs_mount", false)
+	config.BindEnvAndSetDefault("docker_falco_api_url", "")
+	config.BindEnvAndSetDefault("docker_death_report_dir", "")
+	config.BindEnvAndSetDefault("docker_probe_failure_lookback", 600) // in seconds
+	config.BindEnvAndSetDefault("docker_containerd_socket_path", "/run/containerd/containerd.sock")
+	config.BindEnvAndSetDefault("docker_containerd_namespace", "moby")
+	config.BindEnvAndSetDefault("docker_containerd_snapshotter", "overlayfs")
+	config.BindEnvAndSetDefault("docker_allowed_runtimes", []string{"runc"})
 	config.BindEnvAndSetDefault("kubernetes_pod_labels_as_tags", map[string]string{})
 	config.BindEnvAndSetDefault("kubernetes_pod_annotations_as_tags", map[string]string{})
 	config.BindEnvAndSetDefault("kubernetes_node_labels_as_tags", map[string]string{})
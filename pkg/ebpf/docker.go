@@ -0,0 +1,50 @@
+// +build linux_bpf,docker
+
+package ebpf
+
+import (
+	"net"
+
+	"github.com/DataDog/datadog-agent/pkg/util/docker"
+)
+
+func init() {
+	docker.RegisterConnectionTracer(newDockerConnectionTracer)
+}
+
+// dockerConnectionTracer exposes the TCP connections of a Tracer to
+// DockerUtil.StartConnectionTracing.
+type dockerConnectionTracer struct {
+	*Tracer
+}
+
+func newDockerConnectionTracer() (docker.ConnectionTracer, error) {
+	t, err := NewTracer(NewDefaultConfig())
+	if err != nil {
+		return nil, err
+	}
+	return dockerConnectionTracer{t}, nil
+}
+
+// Connections implements docker.ConnectionTracer.
+func (t dockerConnectionTracer) Connections() ([]docker.TracedConnection, error) {
+	conns, err := t.GetActiveConnections("docker")
+	if err != nil {
+		return nil, err
+	}
+	var traced []docker.TracedConnection
+	for _, c := range conns.Conns {
+		if c.Type != TCP {
+			continue
+		}
+		traced = append(traced, docker.TracedConnection{
+			Incoming: c.Direction == INCOMING,
+			SrcIP:    net.ParseIP(c.Source.String()),
+			DstIP:    net.ParseIP(c.Dest.String()),
+			SrcPort:  int(c.SPort),
+			DstPort:  int(c.DPort),
+			PID:      int(c.Pid),
+		})
+	}
+	return traced, nil
+}
@@ -8,13 +8,14 @@ import (
 
 	"github.com/DataDog/datadog-agent/pkg/trace/api"
 	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/cost"
 	"github.com/DataDog/datadog-agent/pkg/trace/event"
 	"github.com/DataDog/datadog-agent/pkg/trace/filters"
 	"github.com/DataDog/datadog-agent/pkg/trace/info"
 	"github.com/DataDog/datadog-agent/pkg/trace/metrics/timing"
-	"github.com/DataDog/datadog-agent/pkg/trace/obfuscate"
 	"github.com/DataDog/datadog-agent/pkg/trace/pb"
 	"github.com/DataDog/datadog-agent/pkg/trace/sampler"
+	"github.com/DataDog/datadog-agent/pkg/trace/servicemap"
 	"github.com/DataDog/datadog-agent/pkg/trace/stats"
 	"github.com/DataDog/datadog-agent/pkg/trace/traceutil"
 	"github.com/DataDog/datadog-agent/pkg/trace/writer"
@@ -23,6 +24,9 @@ import (
 
 const processStatsInterval = time.Minute
 
+// serviceGroupKey is the meta key holding the service map group of a root span.
+const serviceGroupKey = "_dd.service_group"
+
 // Agent struct holds all the sub-routines structs and make the data flow between them
 type Agent struct {
 	Receiver           *api.HTTPReceiver
@@ -36,11 +40,99 @@ type Agent struct {
 	TraceWriter        *writer.TraceWriter
 	StatsWriter        *writer.StatsWriter
 
-	// obfuscator is used to obfuscate sensitive data from various span
-	// tags based on their type.
-	obfuscator *obfuscate.Obfuscator
+	// HighValueWriter sends the APM events of high value services to their
+	// dedicated endpoint. It is nil when disabled.
+	HighValueWriter *writer.TraceWriter
+
+	// SyntheticsWriter sends the traces whose ID is reserved to synthetic
+	// monitoring to their dedicated endpoint. It is nil when disabled.
+	SyntheticsWriter *writer.TraceWriter
+
+	// TenantWriters sends the spans of tenants to their organization. It is
+	// nil when no tenant is configured.
+	TenantWriters *TenantWriters
+
+	// Aggregator merges spans of the same trace received across multiple
+	// payloads. It is nil when disabled.
+	Aggregator *SpanAggregator
+
+	// ParentResolver buffers spans until all the parents of their trace are
+	// received. It is nil when disabled.
+	ParentResolver *SpanParentResolver
+
+	// Coalescer merges identical error traces received in a short period of
+	// time. It is nil when disabled.
+	Coalescer *EventCoalescer
+
+	// configs holds the versions of the obfuscator, used to obfuscate
+	// sensitive data from various span tags based on their type.
+	configs *configVersionStore
+
+	// anomalies annotates traces which are anomalous for their service. It is
+	// nil when disabled.
+	anomalies *MultiDimAnomalyDetector
+
+	// topologies reports new and missing trace topologies. It is nil when
+	// disabled.
+	topologies *TopologyTracker
+
+	// contextLeaks detects request context leaking between traces. It is nil
+	// when disabled.
+	contextLeaks *ContextLeakDetector
+
+	// causal annotates error spans with the likely root cause of their errors.
+	// It is nil when disabled.
+	causal *CausalCorrelationAnalyzer
+
+	// spanConfig reads the configuration overridden by root spans. It is nil
+	// when disabled.
+	spanConfig *SpanConfigOverrideReader
+
+	// features enriches spans with values from a feature store. It is nil
+	// when disabled.
+	features *FeatureStoreEnricher
+
+	// contracts validates HTTP spans against the API specification of their
+	// service. It is nil when disabled.
+	contracts *APIContractValidator
+
+	// synthetics enriches synthetic monitoring traces with the test which
+	// generated them. It is nil when disabled.
+	synthetics *SyntheticMonitorEnricher
+
+	// fingerprinter removes the duplicate spans of sampled traces which have too
+	// many spans. It is nil when disabled.
+	fingerprinter *SpanDeduplicationFingerprinter
+
+	// reconstruction re-links traces reported by multiple agents. It is nil
+	// when disabled.
+	reconstruction *TraceReconstructionService
 
-	spansOut chan *writer.SampledSpans
+	// budgets lowers the sampling of the traces of customers exceeding their
+	// budget. It is nil when disabled.
+	budgets *CustomerBudgetEnforcer
+
+	// canary deprioritizes the traces of canary deployments during rollbacks.
+	// It is nil when disabled.
+	canary *CanaryRollbackSampler
+
+	// businessImpact weights the sample rates of traces by their business
+	// impact. It is nil when disabled.
+	businessImpact *BusinessImpactSampler
+
+	// holdout keeps a sample of the traces of each service unaffected by the
+	// other samplers. It is nil when disabled.
+	holdout *HoldoutSampler
+
+	// importance scores spans with the span importance model, and
+	// importanceSampler keeps the traces holding important spans more often.
+	// They are nil when disabled.
+	importance        *SpanImportanceScorer
+	importanceSampler *ImportanceSampler
+
+	spansOut          chan *writer.SampledSpans
+	highValueSpansOut chan *writer.SampledSpans
+	syntheticsOut     chan *writer.SampledSpans
 
 	// config
 	conf    *config.AgentConfig
@@ -51,9 +143,11 @@ type Agent struct {
 }
 
 // NewAgent returns a new Agent object, ready to be started. It takes a context
-// which may be cancelled in order to gracefully stop the agent.
+// which may be cancelled in order to gracefully stop the agent. conf must be
+// created with config.New, so that all of its sections are set.
 func NewAgent(ctx context.Context, conf *config.AgentConfig) *Agent {
 	dynConf := sampler.NewDynamicConfig(conf.DefaultEnv)
+	dynConf.Rollback.Set(conf.Sampler.RollbackActive)
 
 	// inter-component channels
 	rawTraceChan := make(chan pb.Trace, 5000)
@@ -67,16 +161,44 @@ func NewAgent(ctx context.Context, conf *config.AgentConfig) *Agent {
 		conf.BucketInterval.Nanoseconds(),
 		statsChan,
 	)
+	if conf.Stats.BackgroundFlushInterval > 0 {
+		c.UseBackgroundFlush(conf.Stats.BackgroundFlushInterval)
+	}
+	if conf.Stats.MaxUniqueKeysPerService > 0 {
+		c.UseMaxUniqueKeysPerService(conf.Stats.MaxUniqueKeysPerService)
+	}
 
-	obf := obfuscate.NewObfuscator(conf.Obfuscation)
+	configs := newConfigVersionStore(conf.Obfuscation, conf.ConfigVersionTTL)
+	if r.Pipeline != nil {
+		// obfuscation is handled by the receiver's pipeline
+		r.Pipeline.ConfigVersion = configs.ConfigVersion
+		r.Pipeline.Obfuscate = configs.Obfuscate
+	}
 	ss := NewScoreSampler(conf)
 	ess := NewErrorsSampler(conf)
 	ps := NewPrioritySampler(conf, dynConf)
 	ep := eventProcessorFromConf(conf)
 	tw := writer.NewTraceWriter(conf, spansOut)
+	if conf.AnnotationTTL > 0 {
+		annotations := writer.NewAnnotationStore(conf.AnnotationTTL)
+		r.Annotations = annotations
+		tw.UseAnnotations(annotations)
+	}
+	if conf.Cost.ComputePricePerHour > 0 {
+		r.Costs = cost.NewResourceCostAttributor(conf.Cost.ComputePricePerHour, conf.Cost.VCPUs)
+	}
+	if labels := conf.ServiceMap.HierarchyLabels; len(labels) > 0 {
+		r.ServiceHierarchy = servicemap.NewServiceHierarchyBuilder(labels)
+	}
+	if conf.Debug.SchemaDocumentation {
+		r.Schemas = servicemap.NewSchemaDocumentationExtractor()
+	}
+	if conf.Debug.PipelineProfiling {
+		r.Profiler = timing.NewPipelineProfiler()
+	}
 	sw := writer.NewStatsWriter(conf, statsChan)
 
-	return &Agent{
+	a := &Agent{
 		Receiver:           r,
 		Concentrator:       c,
 		Blacklister:        filters.NewBlacklister(conf.Ignore["resource"]),
@@ -87,12 +209,99 @@ func NewAgent(ctx context.Context, conf *config.AgentConfig) *Agent {
 		EventProcessor:     ep,
 		TraceWriter:        tw,
 		StatsWriter:        sw,
-		obfuscator:         obf,
+		configs:            configs,
 		spansOut:           spansOut,
 		conf:               conf,
 		dynConf:            dynConf,
 		ctx:                ctx,
 	}
+	if conf.AnalyzedEvents.Enabled() {
+		a.highValueSpansOut = make(chan *writer.SampledSpans, 1000)
+		a.HighValueWriter = writer.NewTraceWriter(highValueWriterConf(conf), a.highValueSpansOut)
+	}
+	if conf.Synthetics.Endpoint != "" && conf.Synthetics.TraceIDRangeEnd != 0 {
+		a.syntheticsOut = make(chan *writer.SampledSpans, 1000)
+		a.SyntheticsWriter = writer.NewTraceWriter(syntheticsWriterConf(conf), a.syntheticsOut)
+	}
+	if r.Tenants != nil {
+		a.TenantWriters = NewTenantWriters(conf, r.Tenants)
+	}
+	if conf.Coalescing.Enabled {
+		a.Coalescer = NewEventCoalescer(conf, func(tenant string, t pb.Trace) {
+			a.write(traceutil.GetRoot(t), tenant, &writer.SampledSpans{Trace: t})
+		})
+	}
+	process := a.Process
+	if conf.Reconstruction.RedisAddr != "" {
+		a.reconstruction = NewTraceReconstructionService(conf, process)
+		process = a.reconstruction.Add
+	}
+	if conf.Normalization.ParentResolution {
+		a.ParentResolver = NewSpanParentResolver(conf, process)
+		process = a.ParentResolver.Add
+	}
+	if conf.Aggregator.Enabled {
+		a.Aggregator = NewSpanAggregator(conf, process)
+		if conf.Aggregator.SuppressLateSpans {
+			r.ClosedTraces = api.NewClosedTraceCache(conf.Aggregator.ClosedTraceTTL, maxClosedTraces)
+			a.Aggregator.UseClosedTraceCache(r.ClosedTraces)
+		}
+	}
+	if threshold := conf.Debug.MahalanobisThreshold; threshold > 0 {
+		a.anomalies = NewMultiDimAnomalyDetector(threshold)
+	}
+	if conf.Debug.TopologyTracking {
+		a.topologies = NewTopologyTracker()
+	}
+	if conf.Debug.ContextLeakDetection {
+		a.contextLeaks = NewContextLeakDetector()
+	}
+	if conf.Debug.CausalCorrelation {
+		a.causal = NewCausalCorrelationAnalyzer()
+	}
+	if conf.SpanConfig.Enabled {
+		a.spanConfig = NewSpanConfigOverrideReader(conf)
+		ep.SetMaxEPSOverrides(a.spanConfig)
+	}
+	if conf.Enrichment.FeatureStore.URL != "" {
+		a.features = NewFeatureStoreEnricher(conf)
+	}
+	if conf.Synthetics.MetadataEndpoint != "" {
+		a.synthetics = NewSyntheticMonitorEnricher(conf)
+	}
+	if conf.Enrichment.ValidateAPIContracts && len(conf.Enrichment.OpenAPISpecs) > 0 {
+		a.contracts = NewAPIContractValidator(conf.Enrichment.OpenAPISpecs)
+	}
+	if conf.MaxSpansPerTrace > 0 {
+		a.fingerprinter = NewSpanDeduplicationFingerprinter(conf.MaxSpansPerTrace)
+	}
+	if conf.MultiTenant.TracesBudgetPerCustomerPerMinute > 0 {
+		a.budgets = NewCustomerBudgetEnforcer(conf.MultiTenant)
+	}
+	if pattern := conf.Sampler.CanaryVersionPattern; pattern != "" {
+		canary, err := NewCanaryRollbackSampler(conf.Sampler, &dynConf.Rollback)
+		if err != nil {
+			log.Errorf("Canary rollback sampling disabled, invalid version pattern %q: %v", pattern, err)
+		} else {
+			a.canary = canary
+		}
+	}
+	if conf.Sampler.BusinessImpactTag != "" && len(conf.Sampler.BusinessImpactWeights) > 0 {
+		a.businessImpact = NewBusinessImpactSampler(conf.Sampler)
+	}
+	if conf.Sampler.HoldoutSize > 0 {
+		a.holdout = NewHoldoutSampler(conf.Sampler.HoldoutSize, a.write)
+	}
+	if path := conf.Debug.SpanImportanceModel; path != "" {
+		scorer, err := NewSpanImportanceScorer(path)
+		if err != nil {
+			log.Errorf("Span importance scoring disabled, could not load model %q: %v", path, err)
+		} else {
+			a.importance = scorer
+			a.importanceSampler = NewImportanceSampler()
+		}
+	}
+	return a
 }
 
 // Run starts routers routines and individual pieces then stop them when the exit order is received
@@ -108,7 +317,41 @@ func (a *Agent) Run() {
 		starter.Start()
 	}
 
+	if a.Coalescer != nil {
+		a.Coalescer.Start()
+	}
+	if a.ParentResolver != nil {
+		a.ParentResolver.Start()
+	}
+	if a.Aggregator != nil {
+		a.Aggregator.Start()
+	}
+	if a.holdout != nil {
+		a.holdout.Start()
+	}
+	if a.reconstruction != nil {
+		a.reconstruction.Start()
+	}
+	if a.features != nil {
+		a.features.Start()
+	}
+	if a.synthetics != nil {
+		a.synthetics.Start()
+	}
+	if a.Receiver.Schemas != nil {
+		a.Receiver.Schemas.Start()
+	}
+	if a.Receiver.Profiler != nil {
+		a.Receiver.Profiler.Start()
+	}
+
 	go a.TraceWriter.Run()
+	if a.HighValueWriter != nil {
+		go a.HighValueWriter.Run()
+	}
+	if a.SyntheticsWriter != nil {
+		go a.SyntheticsWriter.Run()
+	}
 	go a.StatsWriter.Run()
 
 	for i := 0; i < runtime.NumCPU(); i++ {
@@ -125,7 +368,16 @@ func (a *Agent) work() {
 			if !ok {
 				return
 			}
-			a.Process(t)
+			switch {
+			case a.Aggregator != nil:
+				a.Aggregator.Add(t)
+			case a.ParentResolver != nil:
+				a.ParentResolver.Add(t)
+			case a.reconstruction != nil:
+				a.reconstruction.Add(t)
+			default:
+				a.Process(t)
+			}
 		}
 	}
 
@@ -139,8 +391,44 @@ func (a *Agent) loop() {
 			if err := a.Receiver.Stop(); err != nil {
 				log.Error(err)
 			}
+			if a.Aggregator != nil {
+				a.Aggregator.Stop()
+			}
+			if a.ParentResolver != nil {
+				a.ParentResolver.Stop()
+			}
+			if a.reconstruction != nil {
+				a.reconstruction.Stop()
+			}
+			if a.Coalescer != nil {
+				a.Coalescer.Stop()
+			}
 			a.Concentrator.Stop()
+			if a.holdout != nil {
+				a.holdout.Stop()
+			}
+			if a.features != nil {
+				a.features.Stop()
+			}
+			if a.synthetics != nil {
+				a.synthetics.Stop()
+			}
+			if a.Receiver.Schemas != nil {
+				a.Receiver.Schemas.Stop()
+			}
+			if a.Receiver.Profiler != nil {
+				a.Receiver.Profiler.Stop()
+			}
 			a.TraceWriter.Stop()
+			if a.HighValueWriter != nil {
+				a.HighValueWriter.Stop()
+			}
+			if a.SyntheticsWriter != nil {
+				a.SyntheticsWriter.Stop()
+			}
+			if a.TenantWriters != nil {
+				a.TenantWriters.Stop()
+			}
 			a.StatsWriter.Stop()
 			a.ScoreSampler.Stop()
 			a.ErrorsScoreSampler.Stop()
@@ -151,6 +439,13 @@ func (a *Agent) loop() {
 	}
 }
 
+// ReloadObfuscation replaces the obfuscation config of the agent and returns
+// the new config version. The traces received before keep being obfuscated
+// with the previous config until it expires, after apm_config.config_version_ttl_seconds.
+func (a *Agent) ReloadObfuscation(conf *config.ObfuscationConfig) uint64 {
+	return a.configs.reload(conf, time.Now())
+}
+
 // Process is the default work unit that receives a trace, transforms it and
 // passes it downstream.
 func (a *Agent) Process(t pb.Trace) {
@@ -161,6 +456,10 @@ func (a *Agent) Process(t pb.Trace) {
 
 	defer timing.Since("datadog.trace_agent.internal.process_trace_ms", time.Now())
 
+	// Stamp the trace with the current config version, so that it is processed
+	// with the same config even if it is reloaded meanwhile.
+	version := a.configs.ConfigVersion()
+
 	// Root span is used to carry some trace-level metadata, such as sampling rate and priority.
 	root := traceutil.GetRoot(t)
 
@@ -194,10 +493,18 @@ func (a *Agent) Process(t pb.Trace) {
 	}
 
 	// Extra sanitization steps of the trace.
+	obfuscated := a.Receiver.Pipeline != nil
+	obfuscator := a.configs.Obfuscator(version)
+	start := time.Now()
 	for _, span := range t {
-		a.obfuscator.Obfuscate(span)
+		if !obfuscated {
+			obfuscator.Obfuscate(span)
+		}
 		Truncate(span)
 	}
+	if a.Receiver.Profiler != nil {
+		a.Receiver.Profiler.Since(timing.StageObfuscate, start)
+	}
 	a.Replacer.Replace(&t)
 
 	// Extract the client sampling rate.
@@ -213,6 +520,55 @@ func (a *Agent) Process(t pb.Trace) {
 	// which is not thread-safe while samplers and Concentrator might modify it too.
 	traceutil.ComputeTopLevel(t)
 
+	if a.anomalies != nil {
+		a.anomalies.Detect(root, t)
+	}
+	if a.topologies != nil {
+		a.topologies.Observe(root, t, time.Now())
+	}
+	if a.contextLeaks != nil {
+		a.contextLeaks.Check(root, t)
+	}
+	if a.causal != nil {
+		a.causal.Correlate(t, time.Now())
+	}
+	if a.spanConfig != nil {
+		a.spanConfig.Read(root, time.Now())
+	}
+	if a.features != nil {
+		a.features.Enrich(t)
+	}
+	if a.synthetics != nil {
+		a.synthetics.Enrich(t)
+	}
+	if a.contracts != nil {
+		a.contracts.Validate(t)
+	}
+	if a.importance != nil {
+		a.importance.Score(t)
+	}
+	if a.Receiver.Costs != nil {
+		a.Receiver.Costs.Attribute(t)
+	}
+	if a.Receiver.ServiceHierarchy != nil {
+		a.Receiver.ServiceHierarchy.Observe(root)
+	}
+	if a.Receiver.Schemas != nil {
+		a.Receiver.Schemas.Observe(t)
+	}
+	if a.conf.Enrichment.PropagateDeadlines {
+		traceutil.PropagateDeadline(t, root)
+	}
+	if a.reconstruction != nil {
+		a.reconstruction.Publish(t)
+	}
+	if group, ok := a.conf.ServiceMap.GroupOf(root.Service); ok {
+		if root.Meta == nil {
+			root.Meta = make(map[string]string)
+		}
+		root.Meta[serviceGroupKey] = group
+	}
+
 	subtraces := stats.ExtractTopLevelSubtraces(t, root)
 	sublayers := make(map[*pb.Span][]stats.SublayerValue)
 	for _, subtrace := range subtraces {
@@ -227,6 +583,8 @@ func (a *Agent) Process(t pb.Trace) {
 		Root:          root,
 		Env:           a.conf.DefaultEnv,
 		Sublayers:     sublayers,
+		Tenant:        tenantOf(t),
+		ConfigVersion: version,
 	}
 	if tenv := traceutil.GetEnv(t); tenv != "" {
 		// this trace has a user defined env.
@@ -249,23 +607,82 @@ func (a *Agent) Process(t pb.Trace) {
 func (a *Agent) sample(ts *info.TagStats, pt ProcessedTrace) {
 	var ss writer.SampledSpans
 
+	start := time.Now()
 	sampled, rate := a.runSamplers(pt)
+	// traces kept by users are never sampled out
+	priority, hasPriority := pt.GetSamplingPriority()
+	userKept := hasPriority && priority >= sampler.PriorityUserKeep
+	if a.budgets != nil && !userKept {
+		if budgetRate := a.budgets.Rate(pt.Root, time.Now()); budgetRate < 1 {
+			sampled = sampled && sampler.SampleByRate(pt.Root.TraceID, budgetRate)
+			rate *= budgetRate
+		}
+	}
+	if a.canary != nil && !userKept {
+		if canaryRate := a.canary.Rate(pt.Root); canaryRate < 1 {
+			sampled = sampled && sampler.SampleByRate(pt.Root.TraceID, canaryRate)
+			rate *= canaryRate
+		}
+	}
+	if a.Receiver.Profiler != nil {
+		a.Receiver.Profiler.Since(timing.StageSample, start)
+	}
+	if a.holdout != nil {
+		a.holdout.Add(pt.Root, pt.Tenant, pt.Trace, sampled)
+	}
 	if sampled {
 		sampler.AddGlobalRate(pt.Root, rate)
 		ss.Trace = pt.Trace
+		if a.fingerprinter != nil {
+			ss.Trace = a.fingerprinter.Deduplicate(pt.Trace)
+		}
+		if a.Coalescer != nil {
+			// the stats of the trace are computed, only its write is coalesced
+			a.Coalescer.Add(pt.Tenant, ss.Trace)
+			ss.Trace = nil
+		}
 	}
 
-	events, numExtracted := a.EventProcessor.Process(pt.Root, pt.Trace)
+	events, highValueEvents, numExtracted := a.EventProcessor.Process(pt.Root, pt.Trace)
 	ss.Events = events
 
 	atomic.AddInt64(&ts.EventsExtracted, int64(numExtracted))
-	atomic.AddInt64(&ts.EventsSampled, int64(len(events)))
+	atomic.AddInt64(&ts.EventsSampled, int64(len(events)+len(highValueEvents)))
 
 	if !ss.Empty() {
-		a.spansOut <- &ss
+		if a.Receiver.Profiler != nil {
+			defer a.Receiver.Profiler.Since(timing.StageWrite, time.Now())
+		}
+		a.write(pt.Root, pt.Tenant, &ss)
+	}
+	if len(highValueEvents) > 0 {
+		hv := &writer.SampledSpans{Events: highValueEvents}
+		// the events of tenants are sent to their organization like their traces
+		if !a.writeTenant(pt.Tenant, hv) {
+			a.highValueSpansOut <- hv
+		}
 	}
 }
 
+// write sends ss, belonging to the trace of the given root and tenant, to the
+// writer of this trace. The traces of tenants are only sent to the writer of
+// their organization, even when their ID is reserved to synthetic monitoring.
+func (a *Agent) write(root *pb.Span, tenant string, ss *writer.SampledSpans) {
+	switch {
+	case a.writeTenant(tenant, ss):
+	case tenant == "" && a.SyntheticsWriter != nil && a.conf.Synthetics.InReservedRange(root.TraceID):
+		a.syntheticsOut <- ss
+	default:
+		a.spansOut <- ss
+	}
+}
+
+// writeTenant sends ss to the organization of tenant, if any. It returns false
+// if ss was not sent.
+func (a *Agent) writeTenant(tenant string, ss *writer.SampledSpans) bool {
+	return tenant != "" && a.TenantWriters != nil && a.TenantWriters.Send(tenant, ss)
+}
+
 // runSamplers runs all the agent's samplers on pt and returns the sampling decision
 // along with the sampling rate.
 func (a *Agent) runSamplers(pt ProcessedTrace) (sampled bool, rate float64) {
@@ -282,7 +699,17 @@ func (a *Agent) runSamplers(pt ProcessedTrace) (sampled bool, rate float64) {
 		sampledScore, rateScore = a.ScoreSampler.Add(pt)
 	}
 
-	return sampledScore || sampledPriority, sampler.CombineRates(ratePriority, rateScore)
+	sampled, rate = sampledScore || sampledPriority, sampler.CombineRates(ratePriority, rateScore)
+	if a.businessImpact != nil {
+		// traces kept by users are never sampled down
+		if priority, ok := pt.GetSamplingPriority(); !ok || priority < sampler.PriorityUserKeep {
+			sampled, rate = a.businessImpact.Sample(pt.Root, sampled, rate)
+		}
+	}
+	if a.importanceSampler != nil {
+		sampled, rate = a.importanceSampler.Sample(pt.Root, pt.Trace, sampled, rate)
+	}
+	return sampled, rate
 }
 
 func traceContainsError(trace pb.Trace) bool {
@@ -304,5 +731,36 @@ func eventProcessorFromConf(conf *config.AgentConfig) *event.Processor {
 		extractors = append(extractors, event.NewLegacyExtractor(conf.AnalyzedRateByServiceLegacy))
 	}
 
-	return event.NewProcessor(extractors, conf.MaxEPS)
+	p := event.NewProcessor(extractors, conf.MaxEPS)
+	if conf.AnalyzedEvents.Enabled() {
+		p.SetHighValueServices(conf.AnalyzedEvents.HighValueServices)
+	}
+	return p
+}
+
+// syntheticsWriterConf returns the configuration of the writer of the traces
+// reserved to synthetic monitoring, which only sends to the synthetics endpoint.
+func syntheticsWriterConf(conf *config.AgentConfig) *config.AgentConfig {
+	sConf := *conf
+	endpoint := &config.Endpoint{Host: conf.Synthetics.Endpoint}
+	if len(conf.Endpoints) > 0 {
+		endpoint.APIKey = conf.Endpoints[0].APIKey
+	}
+	sConf.Endpoints = []*config.Endpoint{endpoint}
+	return &sConf
+}
+
+// highValueWriterConf returns the configuration of the writer of high value
+// APM events, which only sends to the high value endpoint.
+func highValueWriterConf(conf *config.AgentConfig) *config.AgentConfig {
+	hvConf := *conf
+	endpoint := &config.Endpoint{
+		Host:   conf.AnalyzedEvents.HighValueEndpoint,
+		APIKey: conf.AnalyzedEvents.HighValueAPIKey,
+	}
+	if endpoint.APIKey == "" && len(conf.Endpoints) > 0 {
+		endpoint.APIKey = conf.Endpoints[0].APIKey
+	}
+	hvConf.Endpoints = []*config.Endpoint{endpoint}
+	return &hvConf
 }
@@ -174,6 +174,29 @@ func TestProcess(t *testing.T) {
 		assert.EqualValues(t, 4, stats.TracesPriority1)
 		assert.EqualValues(t, 5, stats.TracesPriority2)
 	})
+
+	t.Run("ServiceGroup", func(t *testing.T) {
+		cfg := config.New()
+		cfg.Endpoints[0].APIKey = "test"
+		cfg.ServiceMap.GroupingRules = []*config.ServiceGroupingRule{{
+			Re:        regexp.MustCompile("^payment-"),
+			GroupName: "payments",
+		}}
+		ctx, cancel := context.WithCancel(context.Background())
+		agnt := NewAgent(ctx, cfg)
+		defer cancel()
+
+		now := time.Now()
+		for service, group := range map[string]string{"payment-api": "payments", "web": ""} {
+			root := &pb.Span{
+				Service:  service,
+				Start:    now.Add(-time.Second).UnixNano(),
+				Duration: (500 * time.Millisecond).Nanoseconds(),
+			}
+			agnt.Process(pb.Trace{root})
+			assert.Equal(t, group, root.Meta[serviceGroupKey], service)
+		}
+	})
 }
 
 func TestSampling(t *testing.T) {
@@ -309,6 +332,122 @@ func TestSampling(t *testing.T) {
 	}
 }
 
+func TestHighValueEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := config.New()
+	cfg.Endpoints[0].APIKey = "test"
+	cfg.AnalyzedEvents.HighValueServices = []string{"checkout"}
+	cfg.AnalyzedEvents.HighValueEndpoint = "https://analytics.example.com"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	agnt := NewAgent(ctx, cfg)
+	if !assert.NotNil(agnt.HighValueWriter) {
+		return
+	}
+
+	now := time.Now()
+	newSpan := func(id uint64, service string) *pb.Span {
+		return &pb.Span{
+			TraceID:  1,
+			SpanID:   id,
+			ParentID: id - 1,
+			Service:  service,
+			Name:     "http.request",
+			Resource: "GET /",
+			Start:    now.Add(-time.Second).UnixNano(),
+			Duration: (500 * time.Millisecond).Nanoseconds(),
+			Metrics:  map[string]float64{sampler.KeySamplingRateEventExtraction: 1},
+		}
+	}
+	agnt.Process(pb.Trace{newSpan(1, "web"), newSpan(2, "checkout")})
+
+	ss := <-agnt.spansOut
+	if assert.Len(ss.Events, 1) {
+		assert.Equal("web", ss.Events[0].Service)
+	}
+	hv := <-agnt.highValueSpansOut
+	assert.Empty(hv.Trace)
+	if assert.Len(hv.Events, 1) {
+		assert.Equal("checkout", hv.Events[0].Service)
+	}
+
+	t.Run("writer", func(t *testing.T) {
+		hvConf := highValueWriterConf(cfg)
+		assert.Equal([]*config.Endpoint{{Host: "https://analytics.example.com", APIKey: "test"}}, hvConf.Endpoints)
+		cfg.AnalyzedEvents.HighValueAPIKey = "hv"
+		hvConf = highValueWriterConf(cfg)
+		assert.Equal("hv", hvConf.Endpoints[0].APIKey)
+		assert.Equal("test", cfg.Endpoints[0].APIKey, "the main endpoint is left untouched")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		cfg := config.New()
+		cfg.Endpoints[0].APIKey = "test"
+		cfg.AnalyzedEvents.HighValueServices = []string{"checkout"}
+		assert.Nil(NewAgent(ctx, cfg).HighValueWriter)
+	})
+}
+
+func TestReservedSyntheticsWriter(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := config.New()
+	cfg.Endpoints[0].APIKey = "test"
+	cfg.Synthetics.TraceIDRangeStart = 0xDEAD0000
+	cfg.Synthetics.TraceIDRangeEnd = 0xDEADFFFF
+	cfg.Synthetics.Endpoint = "https://synthetics.example.com"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	agnt := NewAgent(ctx, cfg)
+	if !assert.NotNil(agnt.SyntheticsWriter) {
+		return
+	}
+
+	now := time.Now()
+	newTrace := func(traceID uint64, origin string) pb.Trace {
+		root := &pb.Span{
+			TraceID:  traceID,
+			SpanID:   1,
+			Service:  "web",
+			Name:     "http.request",
+			Resource: "GET /",
+			Start:    now.Add(-time.Second).UnixNano(),
+			Duration: (500 * time.Millisecond).Nanoseconds(),
+			Metrics:  map[string]float64{sampler.KeySamplingPriority: 2},
+		}
+		if origin != "" {
+			root.Meta = map[string]string{"_dd.origin": origin}
+		}
+		return pb.Trace{root}
+	}
+	agnt.Process(newTrace(0xDEAD0001, ""))
+	// the origin set by clients doesn't route traces to the synthetics intake
+	agnt.Process(newTrace(42, "synthetics"))
+
+	ss := <-agnt.syntheticsOut
+	if assert.Len(ss.Trace, 1) {
+		assert.Equal(uint64(0xDEAD0001), ss.Trace[0].TraceID)
+	}
+	ss = <-agnt.spansOut
+	if assert.Len(ss.Trace, 1) {
+		assert.Equal(uint64(42), ss.Trace[0].TraceID)
+	}
+
+	t.Run("writer", func(t *testing.T) {
+		sConf := syntheticsWriterConf(cfg)
+		assert.Equal([]*config.Endpoint{{Host: "https://synthetics.example.com", APIKey: "test"}}, sConf.Endpoints)
+		assert.Equal(config.New().Endpoints[0].Host, cfg.Endpoints[0].Host, "the main endpoint is left untouched")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		cfg := config.New()
+		cfg.Endpoints[0].APIKey = "test"
+		cfg.Synthetics.Endpoint = "https://synthetics.example.com"
+		assert.Nil(NewAgent(ctx, cfg).SyntheticsWriter, "no reserved range")
+	})
+}
+
 func TestEventProcessorFromConf(t *testing.T) {
 	if _, ok := os.LookupEnv("INTEGRATION"); !ok {
 		t.Skip("set INTEGRATION environment variable to run")
@@ -354,6 +493,7 @@ func TestEventProcessorFromConf(t *testing.T) {
 			MaxEPS:                      testMaxEPS,
 			AnalyzedSpansByService:      rateByServiceAndName,
 			AnalyzedRateByServiceLegacy: rateByService,
+			AnalyzedEvents:              new(config.AnalyzedEventsConfig),
 		}, testCase)
 	}
 }
@@ -386,6 +526,7 @@ func TestEventProcessorFromConfLegacy(t *testing.T) {
 		testEventProcessorFromConf(t, &config.AgentConfig{
 			MaxEPS:                      testMaxEPS,
 			AnalyzedRateByServiceLegacy: rateByService,
+			AnalyzedEvents:              new(config.AnalyzedEventsConfig),
 		}, testCase)
 	}
 }
@@ -447,7 +588,7 @@ Loop:
 			sampler.SetSamplingPriority(root, priority)
 		}
 
-		events, _ := processor.Process(root, spans)
+		events, _, _ := processor.Process(root, spans)
 		totalSampled += len(events)
 
 		<-eventTicker.C
@@ -0,0 +1,208 @@
+package agent
+
+import (
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/api"
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/sampler"
+	"github.com/DataDog/datadog-agent/pkg/trace/watchdog"
+)
+
+const (
+	// maxAggregatorTick is the maximum delay between two checks for expired traces.
+	maxAggregatorTick = time.Second
+
+	// maxClosedTraces is the maximum number of flushed traces remembered to
+	// drop their late spans.
+	maxClosedTraces = 100000
+
+	// maxAggregatorSpans is the maximum number of spans buffered by an
+	// aggregator. Past it, traces are flushed as soon as they get new spans.
+	maxAggregatorSpans = 100000
+)
+
+// bufferedTrace holds the spans received so far for a trace.
+type bufferedTrace struct {
+	spans     pb.Trace
+	firstSeen time.Time
+}
+
+// SpanAggregator merges the spans of a trace which are received across multiple
+// payloads. Spans are buffered until the root span of their trace is received, or
+// until the flush timeout expires, after which they are passed on as a single trace.
+// The root span of a distributed trace is the root of its local part, whose parent
+// is remote. At most maxAggregatorSpans spans are buffered.
+type SpanAggregator struct {
+	mu           sync.Mutex
+	tracesBuffer map[uint64]bufferedTrace
+	size         int // number of buffered spans
+	maxSize      int
+
+	flushTimeout time.Duration
+	complete     func(pb.Trace) bool // reports whether a trace is complete
+	metric       string              // name of the metric counting flushed traces
+	out          func(pb.Trace)
+
+	// closed, when set, remembers the flushed traces so that their late
+	// spans are dropped by the receiver.
+	closed *api.ClosedTraceCache
+
+	exit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewSpanAggregator returns a new SpanAggregator which calls out with every
+// complete or expired trace.
+func NewSpanAggregator(conf *config.AgentConfig, out func(pb.Trace)) *SpanAggregator {
+	return newSpanAggregator(conf.Aggregator.FlushTimeout, hasRootSpan, "datadog.trace_agent.aggregator.flushed", out)
+}
+
+func newSpanAggregator(flushTimeout time.Duration, complete func(pb.Trace) bool, metric string, out func(pb.Trace)) *SpanAggregator {
+	return &SpanAggregator{
+		tracesBuffer: make(map[uint64]bufferedTrace),
+		maxSize:      maxAggregatorSpans,
+		flushTimeout: flushTimeout,
+		complete:     complete,
+		metric:       metric,
+		out:          out,
+		exit:         make(chan struct{}),
+	}
+}
+
+// UseClosedTraceCache makes the aggregator declare the traces it flushes closed
+// in c. It must be called before Start.
+func (a *SpanAggregator) UseClosedTraceCache(c *api.ClosedTraceCache) {
+	a.closed = c
+}
+
+// Start starts flushing expired traces periodically.
+func (a *SpanAggregator) Start() {
+	tick := a.flushTimeout
+	if tick <= 0 || tick > maxAggregatorTick {
+		tick = maxAggregatorTick
+	}
+	a.wg.Add(1)
+	go func() {
+		defer watchdog.LogOnPanic()
+		defer a.wg.Done()
+		t := time.NewTicker(tick)
+		defer t.Stop()
+		for {
+			select {
+			case now := <-t.C:
+				a.flush(a.expired(now), "timeout")
+			case <-a.exit:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the aggregator and flushes all the buffered traces. It can be
+// called even if the aggregator was not started.
+func (a *SpanAggregator) Stop() {
+	close(a.exit)
+	a.wg.Wait()
+	a.flush(a.expired(time.Time{}), "shutdown")
+}
+
+// Add adds the spans of t to the buffer of their trace. Once the trace is complete,
+// which for a SpanAggregator means it holds its root span, it is flushed along with
+// the previously buffered spans.
+func (a *SpanAggregator) Add(t pb.Trace) {
+	if len(t) == 0 {
+		return
+	}
+	id := t[0].TraceID
+
+	a.mu.Lock()
+	bt, ok := a.tracesBuffer[id]
+	if !ok && a.complete(t) {
+		// nothing buffered, pass it on as is
+		a.mu.Unlock()
+		a.flush([]pb.Trace{t}, "complete")
+		return
+	}
+	if !ok {
+		bt.firstSeen = time.Now()
+	}
+	bt.spans = append(bt.spans, t...)
+	reason := ""
+	switch {
+	case a.complete(bt.spans):
+		reason = "complete"
+	case a.size+len(t) > a.maxSize:
+		reason = "buffer_full"
+	}
+	if reason != "" {
+		delete(a.tracesBuffer, id)
+		a.size -= len(bt.spans) - len(t)
+	} else {
+		a.tracesBuffer[id] = bt
+		a.size += len(t)
+	}
+	a.mu.Unlock()
+
+	if reason != "" {
+		a.flush([]pb.Trace{bt.spans}, reason)
+	}
+}
+
+// expired removes and returns the traces which were first seen before now,
+// minus the flush timeout. Passing a zero time returns all the traces.
+func (a *SpanAggregator) expired(now time.Time) []pb.Trace {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var traces []pb.Trace
+	for id, bt := range a.tracesBuffer {
+		if !now.IsZero() && now.Sub(bt.firstSeen) < a.flushTimeout {
+			continue
+		}
+		traces = append(traces, bt.spans)
+		delete(a.tracesBuffer, id)
+		a.size -= len(bt.spans)
+	}
+	return traces
+}
+
+// flush passes the given traces on, reporting the reason for which they were flushed.
+func (a *SpanAggregator) flush(traces []pb.Trace, reason string) {
+	if len(traces) == 0 {
+		return
+	}
+	metrics.Count(a.metric, int64(len(traces)), []string{"reason:" + reason}, 1)
+	if a.closed != nil {
+		now := time.Now()
+		for _, t := range traces {
+			a.closed.Close(t[0].TraceID, now)
+		}
+	}
+	for _, t := range traces {
+		a.out(t)
+	}
+}
+
+// hasRootSpan reports whether t contains its root span.
+func hasRootSpan(t pb.Trace) bool {
+	for _, s := range t {
+		if isRootSpan(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRootSpan reports whether s is the root of its trace, or the root of the part
+// of a distributed trace reported by a tracer, whose parent is remote. Tracers
+// only set the sampling priority on such spans.
+func isRootSpan(s *pb.Span) bool {
+	if s.ParentID == 0 {
+		return true
+	}
+	_, ok := s.Metrics[sampler.KeySamplingPriority]
+	return ok
+}
@@ -0,0 +1,164 @@
+package agent
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/api"
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/sampler"
+	"github.com/stretchr/testify/assert"
+)
+
+// traceRecorder records the traces it receives.
+type traceRecorder struct {
+	mu     sync.Mutex
+	traces []pb.Trace
+}
+
+func (r *traceRecorder) record(t pb.Trace) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.traces = append(r.traces, t)
+}
+
+func (r *traceRecorder) get() []pb.Trace {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.traces
+}
+
+func newTestSpanAggregator(timeout time.Duration) (*SpanAggregator, *traceRecorder) {
+	cfg := config.New()
+	cfg.Aggregator.FlushTimeout = timeout
+	var r traceRecorder
+	return NewSpanAggregator(cfg, r.record), &r
+}
+
+func TestSpanAggregator(t *testing.T) {
+	t.Run("complete", func(t *testing.T) {
+		agg, r := newTestSpanAggregator(time.Minute)
+		agg.Add(pb.Trace{{TraceID: 1, SpanID: 1, ParentID: 0}})
+		assert.Len(t, r.get(), 1)
+		assert.Len(t, agg.tracesBuffer, 0)
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		agg, r := newTestSpanAggregator(time.Minute)
+		agg.Add(pb.Trace{{TraceID: 1, SpanID: 3, ParentID: 2}})
+		agg.Add(pb.Trace{{TraceID: 2, SpanID: 5, ParentID: 4}})
+		agg.Add(pb.Trace{{TraceID: 1, SpanID: 2, ParentID: 1}})
+		assert.Len(t, r.get(), 0)
+		assert.Len(t, agg.tracesBuffer, 2)
+
+		agg.Add(pb.Trace{{TraceID: 1, SpanID: 1, ParentID: 0}})
+		traces := r.get()
+		if assert.Len(t, traces, 1) {
+			assert.Len(t, traces[0], 3)
+			for _, s := range traces[0] {
+				assert.Equal(t, uint64(1), s.TraceID)
+			}
+		}
+		assert.Len(t, agg.tracesBuffer, 1)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		agg, r := newTestSpanAggregator(time.Second)
+		agg.Add(pb.Trace{{TraceID: 1, SpanID: 2, ParentID: 1}})
+		agg.Add(pb.Trace{{TraceID: 1, SpanID: 3, ParentID: 1}})
+
+		agg.flush(agg.expired(time.Now()), "timeout")
+		assert.Len(t, r.get(), 0)
+
+		agg.flush(agg.expired(time.Now().Add(2*time.Second)), "timeout")
+		traces := r.get()
+		if assert.Len(t, traces, 1) {
+			assert.Len(t, traces[0], 2)
+		}
+		assert.Len(t, agg.tracesBuffer, 0)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		agg, r := newTestSpanAggregator(10 * time.Millisecond)
+		agg.Start()
+		defer agg.Stop()
+		agg.Add(pb.Trace{{TraceID: 1, SpanID: 2, ParentID: 1}})
+		for deadline := time.Now().Add(time.Second); len(r.get()) == 0 && time.Now().Before(deadline); {
+			time.Sleep(5 * time.Millisecond)
+		}
+		assert.Len(t, r.get(), 1)
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		agg, r := newTestSpanAggregator(time.Minute)
+		agg.Start()
+		agg.Add(pb.Trace{{TraceID: 1, SpanID: 2, ParentID: 1}})
+		agg.Add(pb.Trace{{TraceID: 2, SpanID: 3, ParentID: 1}})
+		agg.Stop()
+		assert.Len(t, r.get(), 2)
+	})
+
+	t.Run("stop-not-started", func(t *testing.T) {
+		agg, r := newTestSpanAggregator(time.Minute)
+		agg.Add(pb.Trace{{TraceID: 1, SpanID: 2, ParentID: 1}})
+		agg.Stop()
+		assert.Len(t, r.get(), 1)
+	})
+
+	t.Run("remote-parent", func(t *testing.T) {
+		agg, r := newTestSpanAggregator(time.Minute)
+		agg.Add(pb.Trace{{TraceID: 1, SpanID: 3, ParentID: 2}})
+		assert.Len(t, r.get(), 0)
+
+		// the local root of a distributed trace has a remote parent
+		agg.Add(pb.Trace{{TraceID: 1, SpanID: 2, ParentID: 1, Metrics: map[string]float64{sampler.KeySamplingPriority: 1}}})
+		traces := r.get()
+		if assert.Len(t, traces, 1) {
+			assert.Len(t, traces[0], 2)
+		}
+		assert.Len(t, agg.tracesBuffer, 0)
+	})
+
+	t.Run("buffer-full", func(t *testing.T) {
+		agg, r := newTestSpanAggregator(time.Minute)
+		agg.maxSize = 3
+		agg.Add(pb.Trace{{TraceID: 1, SpanID: 2, ParentID: 1}, {TraceID: 1, SpanID: 3, ParentID: 1}})
+		agg.Add(pb.Trace{{TraceID: 2, SpanID: 5, ParentID: 4}})
+		assert.Len(t, r.get(), 0)
+		assert.Equal(t, 3, agg.size)
+
+		agg.Add(pb.Trace{{TraceID: 1, SpanID: 6, ParentID: 1}})
+		traces := r.get()
+		if assert.Len(t, traces, 1) {
+			assert.Len(t, traces[0], 3)
+		}
+		assert.Len(t, agg.tracesBuffer, 1)
+		assert.Equal(t, 1, agg.size)
+
+		agg.flush(agg.expired(time.Now().Add(2*time.Minute)), "timeout")
+		assert.Len(t, r.get(), 2)
+		assert.Equal(t, 0, agg.size)
+	})
+}
+
+func TestSpanAggregatorClosedTraces(t *testing.T) {
+	agg, r := newTestSpanAggregator(time.Second)
+	closed := api.NewClosedTraceCache(30*time.Second, 100)
+	agg.UseClosedTraceCache(closed)
+
+	agg.Add(pb.Trace{{TraceID: 1, SpanID: 2, ParentID: 1}})
+	agg.Add(pb.Trace{{TraceID: 2, SpanID: 4, ParentID: 3}})
+	assert.False(t, closed.Closed(1, time.Now()))
+
+	// complete traces are closed
+	agg.Add(pb.Trace{{TraceID: 1, SpanID: 1, ParentID: 0}})
+	assert.True(t, closed.Closed(1, time.Now()))
+	assert.False(t, closed.Closed(2, time.Now()))
+
+	// and so are the ones which timed out
+	agg.flush(agg.expired(time.Now().Add(2*time.Second)), "timeout")
+	assert.True(t, closed.Closed(2, time.Now()))
+	assert.Len(t, r.get(), 2)
+}
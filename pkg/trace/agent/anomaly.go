@@ -0,0 +1,222 @@
+package agent
+
+import (
+	"math"
+	"sync"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+)
+
+const (
+	// mahalanobisMetricKey is the metric set on the root span of anomalous traces.
+	mahalanobisMetricKey = "_dd.mahalanobis_distance"
+
+	// anomalyFeatures is the number of features describing a trace.
+	anomalyFeatures = 4
+
+	// minAnomalySamples is the number of traces a service must have reported
+	// before its traces are checked for anomalies.
+	minAnomalySamples = 10
+
+	// covarianceRidge is added to the variance of each feature so that the
+	// covariance matrix can be inverted when a feature is constant.
+	covarianceRidge = 1e-6
+
+	// maxAnomalyServices is the maximum number of services whose traces are
+	// checked for anomalies. The traces of the services seen past it are not.
+	maxAnomalyServices = 1000
+)
+
+// anomalyVector holds the features describing a trace: its duration in seconds,
+// its error rate, its number of spans and the size of its meta, in bytes.
+type anomalyVector [anomalyFeatures]float64
+
+// featureStats holds the running mean and co-moments of the feature vectors of
+// a service's traces.
+type featureStats struct {
+	mu sync.Mutex // guards the fields below when shared
+
+	n    float64
+	mean anomalyVector
+	m2   [anomalyFeatures][anomalyFeatures]float64
+}
+
+// add updates the running statistics with x.
+func (s *featureStats) add(x anomalyVector) {
+	s.n++
+	var delta anomalyVector
+	for i := range x {
+		delta[i] = x[i] - s.mean[i]
+		s.mean[i] += delta[i] / s.n
+	}
+	for i := range x {
+		for j := range x {
+			s.m2[i][j] += delta[i] * (x[j] - s.mean[j])
+		}
+	}
+}
+
+// distance returns the Mahalanobis distance between x and the distribution of
+// the previously added vectors. It returns false if the covariance matrix can't
+// be inverted.
+func (s *featureStats) distance(x anomalyVector) (float64, bool) {
+	var cov [anomalyFeatures][anomalyFeatures]float64
+	for i := range cov {
+		for j := range cov[i] {
+			cov[i][j] = s.m2[i][j] / (s.n - 1)
+		}
+		cov[i][i] += covarianceRidge
+	}
+	inv, ok := invert(cov)
+	if !ok {
+		return 0, false
+	}
+	var diff anomalyVector
+	for i := range x {
+		diff[i] = x[i] - s.mean[i]
+	}
+	var d2 float64
+	for i := range diff {
+		for j := range diff {
+			d2 += diff[i] * inv[i][j] * diff[j]
+		}
+	}
+	if d2 < 0 {
+		return 0, false
+	}
+	return math.Sqrt(d2), true
+}
+
+// MultiDimAnomalyDetector detects traces which are anomalous with regards to the
+// other traces of their service, using the Mahalanobis distance of their feature
+// vector. Unlike single-dimensional outlier detection, it takes into account the
+// correlations between features. Services are tracked independently, up to
+// maxAnomalyServices of them.
+type MultiDimAnomalyDetector struct {
+	threshold float64
+
+	mu        sync.RWMutex
+	byService map[string]*featureStats
+}
+
+// NewMultiDimAnomalyDetector returns a new MultiDimAnomalyDetector annotating
+// traces whose distance is above threshold.
+func NewMultiDimAnomalyDetector(threshold float64) *MultiDimAnomalyDetector {
+	return &MultiDimAnomalyDetector{
+		threshold: threshold,
+		byService: make(map[string]*featureStats),
+	}
+}
+
+// Detect computes the distance of t to the usual traces of the service of its
+// root, then adds t to them. When the distance is above the threshold, it is set
+// as the "_dd.mahalanobis_distance" metric of the root and Detect returns true.
+func (d *MultiDimAnomalyDetector) Detect(root *pb.Span, t pb.Trace) bool {
+	stats, ok := d.stats(root.Service)
+	if !ok {
+		return false
+	}
+	x := traceFeatures(root, t)
+
+	stats.mu.Lock()
+	var dist float64
+	var hasDist bool
+	if stats.n >= minAnomalySamples {
+		dist, hasDist = stats.distance(x)
+	}
+	stats.add(x)
+	stats.mu.Unlock()
+
+	if !hasDist || dist <= d.threshold {
+		return false
+	}
+	if root.Metrics == nil {
+		root.Metrics = make(map[string]float64)
+	}
+	root.Metrics[mahalanobisMetricKey] = dist
+	return true
+}
+
+// stats returns the statistics of the traces of service. It returns false when
+// the service is not tracked because too many services already are.
+func (d *MultiDimAnomalyDetector) stats(service string) (*featureStats, bool) {
+	d.mu.RLock()
+	stats, ok := d.byService[service]
+	d.mu.RUnlock()
+	if ok {
+		return stats, true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if stats, ok := d.byService[service]; ok {
+		return stats, true
+	}
+	if len(d.byService) >= maxAnomalyServices {
+		return nil, false
+	}
+	stats = &featureStats{}
+	d.byService[service] = stats
+	return stats, true
+}
+
+// traceFeatures returns the feature vector of t.
+func traceFeatures(root *pb.Span, t pb.Trace) anomalyVector {
+	var errors, metaSize int
+	for _, s := range t {
+		if s.Error != 0 {
+			errors++
+		}
+		for k, v := range s.Meta {
+			metaSize += len(k) + len(v)
+		}
+	}
+	var errorRate float64
+	if len(t) > 0 {
+		errorRate = float64(errors) / float64(len(t))
+	}
+	return anomalyVector{
+		float64(root.Duration) / 1e9,
+		errorRate,
+		float64(len(t)),
+		float64(metaSize),
+	}
+}
+
+// invert returns the inverse of m using Gauss-Jordan elimination with partial
+// pivoting. It returns false if m is singular.
+func invert(m [anomalyFeatures][anomalyFeatures]float64) ([anomalyFeatures][anomalyFeatures]float64, bool) {
+	var inv [anomalyFeatures][anomalyFeatures]float64
+	for i := range inv {
+		inv[i][i] = 1
+	}
+	for col := 0; col < anomalyFeatures; col++ {
+		pivot := col
+		for row := col + 1; row < anomalyFeatures; row++ {
+			if math.Abs(m[row][col]) > math.Abs(m[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(m[pivot][col]) < 1e-12 {
+			return inv, false
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		inv[col], inv[pivot] = inv[pivot], inv[col]
+
+		p := m[col][col]
+		for j := 0; j < anomalyFeatures; j++ {
+			m[col][j] /= p
+			inv[col][j] /= p
+		}
+		for row := 0; row < anomalyFeatures; row++ {
+			if row == col {
+				continue
+			}
+			f := m[row][col]
+			for j := 0; j < anomalyFeatures; j++ {
+				m[row][j] -= f * m[col][j]
+				inv[row][j] -= f * inv[col][j]
+			}
+		}
+	}
+	return inv, true
+}
@@ -0,0 +1,109 @@
+package agent
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+// anomalyTestTrace returns a trace of the given service, made of n spans and
+// lasting the given amount of seconds.
+func anomalyTestTrace(service string, seconds float64, n int) (*pb.Span, pb.Trace) {
+	t := make(pb.Trace, n)
+	for i := range t {
+		t[i] = &pb.Span{TraceID: 1, SpanID: uint64(i + 1), ParentID: uint64(i), Service: service, Duration: 1e6}
+	}
+	t[0].Duration = int64(seconds * 1e9)
+	return t[0], t
+}
+
+func TestMultiDimAnomalyDetector(t *testing.T) {
+	t.Run("warmup", func(t *testing.T) {
+		d := NewMultiDimAnomalyDetector(3)
+		for i := 0; i < minAnomalySamples; i++ {
+			root, trace := anomalyTestTrace("web", 100, 1+i)
+			assert.False(t, d.Detect(root, trace))
+			assert.NotContains(t, root.Metrics, mahalanobisMetricKey)
+		}
+	})
+
+	t.Run("correlated", func(t *testing.T) {
+		// the duration of traces grows with their number of spans
+		d := NewMultiDimAnomalyDetector(3)
+		for i := 0; i < 50; i++ {
+			n := 2 + i%10
+			noise := float64((i*7)%5-2) * 0.005
+			root, trace := anomalyTestTrace("web", float64(n)*0.1+noise, n)
+			d.Detect(root, trace)
+		}
+
+		root, trace := anomalyTestTrace("web", 0.6, 6)
+		assert.False(t, d.Detect(root, trace), "follows the correlation")
+
+		// both the duration and the number of spans are within their usual
+		// ranges, but they break the correlation
+		root, trace = anomalyTestTrace("web", 0.2, 11)
+		assert.True(t, d.Detect(root, trace))
+		assert.True(t, root.Metrics[mahalanobisMetricKey] > 3)
+
+		root, trace = anomalyTestTrace("db", 0.2, 11)
+		assert.False(t, d.Detect(root, trace), "services are independent")
+	})
+
+	t.Run("uncorrelated", func(t *testing.T) {
+		d := NewMultiDimAnomalyDetector(3)
+		for i := 0; i < 70; i++ {
+			n := 2 + i%10
+			root, trace := anomalyTestTrace("web", 0.2+float64(i%7)*0.1, n)
+			d.Detect(root, trace)
+		}
+
+		root, trace := anomalyTestTrace("web", 0.2, 11)
+		assert.False(t, d.Detect(root, trace))
+
+		root, trace = anomalyTestTrace("web", 5, 6)
+		assert.True(t, d.Detect(root, trace), "duration is way above usual")
+	})
+
+	t.Run("services", func(t *testing.T) {
+		d := NewMultiDimAnomalyDetector(3)
+		for i := 0; i < maxAnomalyServices+10; i++ {
+			root, trace := anomalyTestTrace(strconv.Itoa(i), 1, 2)
+			d.Detect(root, trace)
+		}
+		assert.Len(t, d.byService, maxAnomalyServices)
+		_, ok := d.stats("0")
+		assert.True(t, ok, "known services are still tracked")
+		_, ok = d.stats("new")
+		assert.False(t, ok)
+	})
+}
+
+func TestInvert(t *testing.T) {
+	m := [anomalyFeatures][anomalyFeatures]float64{
+		{4, 1, 0, 0},
+		{1, 3, 0, 0},
+		{0, 0, 2, 0},
+		{0, 0, 0, 1},
+	}
+	inv, ok := invert(m)
+	assert.True(t, ok)
+	for i := 0; i < anomalyFeatures; i++ {
+		for j := 0; j < anomalyFeatures; j++ {
+			var v float64
+			for k := 0; k < anomalyFeatures; k++ {
+				v += m[i][k] * inv[k][j]
+			}
+			if i == j {
+				assert.InDelta(t, 1, v, 1e-9)
+			} else {
+				assert.InDelta(t, 0, v, 1e-9)
+			}
+		}
+	}
+
+	_, ok = invert([anomalyFeatures][anomalyFeatures]float64{{1, 2}, {2, 4}})
+	assert.False(t, ok)
+}
@@ -0,0 +1,225 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// apiContractViolationKey is the meta key holding the reason an HTTP span
+// violates the API contract of its service.
+const apiContractViolationKey = "_dd.api_contract_violation"
+
+// apiSpec holds the part of an OpenAPI specification used to validate spans.
+type apiSpec struct {
+	basePaths []string
+	paths     []apiPath
+}
+
+// apiPath is a path of an API and its operations.
+type apiPath struct {
+	template   string
+	segments   []string
+	operations map[string]apiOperation // by upper case method
+}
+
+// apiOperation holds the documented responses of an operation, by status code
+// such as 404, range such as 2XX, or "default".
+type apiOperation map[string]bool
+
+// openAPIDocument is the part of an OpenAPI 3 or Swagger 2 document read.
+type openAPIDocument struct {
+	BasePath string `yaml:"basePath"`
+	Servers  []struct {
+		URL string `yaml:"url"`
+	} `yaml:"servers"`
+	Paths map[string]map[string]interface{} `yaml:"paths"`
+}
+
+// apiMethods are the operations of an OpenAPI path item.
+var apiMethods = map[string]bool{
+	"get": true, "put": true, "post": true, "delete": true,
+	"options": true, "head": true, "patch": true, "trace": true,
+}
+
+// APIContractValidator validates the HTTP spans of services against their
+// OpenAPI specification. The path of the http.url tag must match a path of the
+// specification, along with the http.method and http.status_code tags. Spans
+// violating the specification are tagged with the reason, using the
+// "_dd.api_contract_violation" meta.
+type APIContractValidator struct {
+	specs map[string]*apiSpec // by service
+}
+
+// NewAPIContractValidator returns a new APIContractValidator using the OpenAPI
+// specifications at the given paths, by service. Specifications which can't be
+// loaded are logged and ignored.
+func NewAPIContractValidator(specs map[string]string) *APIContractValidator {
+	v := &APIContractValidator{specs: make(map[string]*apiSpec, len(specs))}
+	for service, path := range specs {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Errorf("Could not read the OpenAPI specification of service %q: %v", service, err)
+			continue
+		}
+		spec, err := parseAPISpec(data)
+		if err != nil {
+			log.Errorf("Invalid OpenAPI specification %s of service %q: %v", path, service, err)
+			continue
+		}
+		v.specs[service] = spec
+	}
+	return v
+}
+
+// Validate tags the HTTP spans of t violating the API contract of their
+// service with the reason.
+func (v *APIContractValidator) Validate(t pb.Trace) {
+	for _, s := range t {
+		spec, ok := v.specs[s.Service]
+		if !ok {
+			continue
+		}
+		rawURL, ok := s.Meta["http.url"]
+		if !ok {
+			continue
+		}
+		if reason := spec.validate(s.Meta["http.method"], rawURL, s.Meta["http.status_code"]); reason != "" {
+			s.Meta[apiContractViolationKey] = reason
+		}
+	}
+}
+
+// validate returns why the given request violates the specification, or an
+// empty string if it doesn't. Empty methods and status codes are not checked.
+func (spec *apiSpec) validate(method, rawURL, status string) string {
+	path := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		path = u.Path
+	} else if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	for _, base := range spec.basePaths {
+		if strings.HasPrefix(path, base+"/") {
+			path = strings.TrimPrefix(path, base)
+			break
+		}
+	}
+	p, ok := spec.match(path)
+	if !ok {
+		return fmt.Sprintf("undocumented path %s", path)
+	}
+	if method == "" {
+		return ""
+	}
+	method = strings.ToUpper(method)
+	op, ok := p.operations[method]
+	if !ok {
+		return fmt.Sprintf("undocumented method %s for %s", method, p.template)
+	}
+	if status == "" || op[status] || op[status[:1]+"XX"] || op["default"] {
+		return ""
+	}
+	return fmt.Sprintf("undocumented status code %s for %s %s", status, method, p.template)
+}
+
+// match returns the path of the specification matching path. Literal segments
+// are preferred to templated ones, so that /users/me is matched before
+// /users/{id}.
+func (spec *apiSpec) match(path string) (apiPath, bool) {
+	segments := splitAPIPath(path)
+	var (
+		best     apiPath
+		bestLits = -1
+	)
+	for _, p := range spec.paths {
+		if len(p.segments) != len(segments) {
+			continue
+		}
+		lits := 0
+		for i, seg := range p.segments {
+			if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+				if segments[i] == "" {
+					lits = -1
+					break
+				}
+				continue
+			}
+			if seg != segments[i] {
+				lits = -1
+				break
+			}
+			lits++
+		}
+		if lits > bestLits {
+			best, bestLits = p, lits
+		}
+	}
+	return best, bestLits >= 0
+}
+
+// splitAPIPath splits path into its segments, ignoring a trailing slash.
+func splitAPIPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+// parseAPISpec parses an OpenAPI 3 or Swagger 2 document, in YAML or JSON.
+func parseAPISpec(data []byte) (*apiSpec, error) {
+	var doc openAPIDocument
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Paths) == 0 {
+		return nil, fmt.Errorf("no paths")
+	}
+	spec := &apiSpec{}
+	bases := []string{doc.BasePath}
+	for _, s := range doc.Servers {
+		if u, err := url.Parse(s.URL); err == nil {
+			bases = append(bases, u.Path)
+		}
+	}
+	for _, base := range bases {
+		if base = strings.TrimSuffix(base, "/"); base != "" {
+			spec.basePaths = append(spec.basePaths, base)
+		}
+	}
+	for template, item := range doc.Paths {
+		p := apiPath{
+			template:   template,
+			segments:   splitAPIPath(template),
+			operations: make(map[string]apiOperation),
+		}
+		for method, operation := range item {
+			if !apiMethods[strings.ToLower(method)] {
+				// parameters, summary, etc.
+				continue
+			}
+			op := make(apiOperation)
+			if fields, ok := operation.(map[interface{}]interface{}); ok {
+				if responses, ok := fields["responses"].(map[interface{}]interface{}); ok {
+					for code := range responses {
+						c := fmt.Sprint(code)
+						if c != "default" {
+							// ranges such as 2xx
+							c = strings.ToUpper(c)
+						}
+						op[c] = true
+					}
+				}
+			}
+			p.operations[strings.ToUpper(method)] = op
+		}
+		spec.paths = append(spec.paths, p)
+	}
+	// sorted, so that the first of equally matching paths is chosen
+	sort.Slice(spec.paths, func(i, j int) bool { return spec.paths[i].template < spec.paths[j].template })
+	return spec, nil
+}
@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+const testUsersSpec = `openapi: 3.0.0
+info:
+  title: Users
+  version: 1.0.0
+servers:
+  - url: https://users.example.com/v1
+paths:
+  /users:
+    get:
+      responses:
+        200:
+          description: the users
+    post:
+      responses:
+        "201":
+          description: created
+        4XX:
+          description: invalid user
+  /users/{id}:
+    parameters:
+      - name: id
+        in: path
+        required: true
+    get:
+      responses:
+        "200":
+          description: the user
+        "404":
+          description: not found
+  /users/me:
+    get:
+      responses:
+        default:
+          description: the current user
+`
+
+const testBillingSpec = `{
+  "swagger": "2.0",
+  "basePath": "/api",
+  "paths": {
+    "/invoices/{id}": {
+      "get": {"responses": {"200": {"description": "the invoice"}}}
+    }
+  }
+}`
+
+func TestAPIContractValidator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "openapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	specs := map[string]string{
+		"users":   filepath.Join(dir, "users.yaml"),
+		"billing": filepath.Join(dir, "billing.json"),
+		"missing": filepath.Join(dir, "missing.yaml"),
+	}
+	if err := ioutil.WriteFile(specs["users"], []byte(testUsersSpec), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(specs["billing"], []byte(testBillingSpec), 0644); err != nil {
+		t.Fatal(err)
+	}
+	v := NewAPIContractValidator(specs)
+	assert.Len(t, v.specs, 2)
+
+	for _, tt := range []struct {
+		service, method, url, status string
+		violation                    string
+	}{
+		{"users", "GET", "https://users.example.com/v1/users?page=2", "200", ""},
+		{"users", "GET", "/v1/users/42", "404", ""},
+		{"users", "get", "/v1/users/42/", "200", ""},
+		{"users", "POST", "/v1/users", "422", ""},
+		{"users", "GET", "/v1/users/me", "500", ""},
+		{"users", "GET", "/v1/users/42", "", ""},
+		{"users", "GET", "/v1/users/42", "500", "undocumented status code 500 for GET /users/{id}"},
+		{"users", "DELETE", "/v1/users/42", "204", "undocumented method DELETE for /users/{id}"},
+		{"users", "GET", "/v1/accounts/42", "200", "undocumented path /accounts/42"},
+		{"users", "GET", "/v1/users/42/friends", "200", "undocumented path /users/42/friends"},
+		{"billing", "GET", "http://billing/api/invoices/7", "200", ""},
+		{"billing", "PUT", "http://billing/api/invoices/7", "200", "undocumented method PUT for /invoices/{id}"},
+		{"missing", "GET", "/anything", "500", ""},
+		{"web", "GET", "/anything", "500", ""},
+	} {
+		span := &pb.Span{Service: tt.service, Meta: map[string]string{"http.url": tt.url}}
+		if tt.method != "" {
+			span.Meta["http.method"] = tt.method
+		}
+		if tt.status != "" {
+			span.Meta["http.status_code"] = tt.status
+		}
+		v.Validate(pb.Trace{span})
+		assert.Equal(t, tt.violation, span.Meta[apiContractViolationKey], "%s %s %s", tt.method, tt.url, tt.status)
+	}
+
+	// spans which are not HTTP spans are not validated
+	span := &pb.Span{Service: "users"}
+	v.Validate(pb.Trace{span})
+	assert.Nil(t, span.Meta)
+}
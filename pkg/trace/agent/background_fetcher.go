@@ -0,0 +1,280 @@
+package agent
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/watchdog"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+const (
+	// backgroundFetchTimeout is the maximum time spent waiting for a response
+	// of the API of a backgroundFetcher.
+	backgroundFetchTimeout = 500 * time.Millisecond
+
+	// backgroundFetchQueueSize is the number of keys waiting for their value to
+	// be fetched above which new keys are skipped.
+	backgroundFetchQueueSize = 1000
+)
+
+// fetcherConfig specifies how a backgroundFetcher fetches and caches values.
+type fetcherConfig struct {
+	// name prefixes the datadog.trace_agent.<name>.dropped, throttled and
+	// errors counts.
+	name string
+	// url is the API values are fetched from, with the key passed in the
+	// query parameter param.
+	url   string
+	param string
+	// decode decodes the body of a successful response.
+	decode func(r io.Reader) (interface{}, error)
+	// ttl is how long values are cached. Errors are cached as nil values for
+	// errorTTL, or not cached when it is 0.
+	ttl      time.Duration
+	errorTTL time.Duration
+	// maxRPS is the maximum number of requests per second, or 0 for no limit.
+	maxRPS float64
+	// maxEntries is the maximum number of cached values.
+	maxEntries int
+	// workers is the number of goroutines fetching values.
+	workers int
+}
+
+// fetchedValue is a cached value of a backgroundFetcher.
+type fetchedValue struct {
+	value   interface{}
+	expires time.Time
+}
+
+// backgroundFetcher fetches values by key from an HTTP API and caches them. Values
+// are fetched in the background, at most once at a time per key and within a
+// rate limit, so that getting a value never waits for the API longer than its
+// caller allows: it is returned once it is cached. Keys unknown to the API,
+// answering 404, are cached as having a nil value.
+type backgroundFetcher struct {
+	conf   fetcherConfig
+	client *http.Client
+
+	queue chan interface{}
+	exit  chan struct{}
+	wg    sync.WaitGroup
+
+	mu          sync.Mutex
+	cache       map[interface{}]fetchedValue
+	pending     map[interface{}]chan struct{} // keys queued or being fetched, closed once fetched
+	sweepAt     time.Time                     // time at which expired values are next removed
+	windowStart time.Time                     // start of the current rate limiting window
+	windowCount float64                       // number of requests made in the current window
+}
+
+// newBackgroundFetcher returns a new backgroundFetcher configured with conf.
+func newBackgroundFetcher(conf fetcherConfig) *backgroundFetcher {
+	return &backgroundFetcher{
+		conf:    conf,
+		client:  &http.Client{Timeout: backgroundFetchTimeout},
+		queue:   make(chan interface{}, backgroundFetchQueueSize),
+		exit:    make(chan struct{}),
+		cache:   make(map[interface{}]fetchedValue),
+		pending: make(map[interface{}]chan struct{}),
+	}
+}
+
+// Start starts fetching the values of the keys queued by get.
+func (f *backgroundFetcher) Start() {
+	for i := 0; i < f.conf.workers; i++ {
+		f.wg.Add(1)
+		go func() {
+			defer watchdog.LogOnPanic()
+			defer f.wg.Done()
+			f.run()
+		}()
+	}
+}
+
+// Stop fetches the values of the queued keys and stops the workers.
+func (f *backgroundFetcher) Stop() {
+	close(f.exit)
+	f.wg.Wait()
+}
+
+func (f *backgroundFetcher) run() {
+	for {
+		select {
+		case key := <-f.queue:
+			f.load(key)
+		case <-f.exit:
+			for {
+				select {
+				case key := <-f.queue:
+					f.load(key)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// get returns the cached value of key. It returns false if it is unknown, in
+// which case it is queued to be fetched unless it already is.
+func (f *backgroundFetcher) get(key interface{}) (interface{}, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v, ok, _ := f.lookup(key)
+	return v, ok
+}
+
+// wait returns the value of key like get, but waits up to timeout for it to be
+// fetched when it is unknown.
+func (f *backgroundFetcher) wait(key interface{}, timeout time.Duration) (interface{}, bool) {
+	f.mu.Lock()
+	v, ok, done := f.lookup(key)
+	f.mu.Unlock()
+	if ok || done == nil {
+		return v, ok
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		return nil, false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if v, ok := f.cache[key]; ok && time.Now().Before(v.expires) {
+		return v.value, true
+	}
+	return nil, false
+}
+
+// lookup returns the cached value of key. When it is unknown, it is queued to
+// be fetched unless it already is, and lookup returns a channel closed once it
+// is fetched, or nil if the queue is full. It must be called with f.mu held.
+func (f *backgroundFetcher) lookup(key interface{}) (interface{}, bool, <-chan struct{}) {
+	now := time.Now()
+	f.sweep(now)
+	if v, ok := f.cache[key]; ok && now.Before(v.expires) {
+		return v.value, true, nil
+	}
+	if done, ok := f.pending[key]; ok {
+		return nil, false, done
+	}
+	select {
+	case f.queue <- key:
+		done := make(chan struct{})
+		f.pending[key] = done
+		return nil, false, done
+	default:
+		metrics.Count("datadog.trace_agent."+f.conf.name+".dropped", 1, nil, 1)
+		return nil, false, nil
+	}
+}
+
+// load fetches the value of key and caches it.
+func (f *backgroundFetcher) load(key interface{}) {
+	f.mu.Lock()
+	allowed := f.allow(time.Now())
+	f.mu.Unlock()
+	if !allowed {
+		metrics.Count("datadog.trace_agent."+f.conf.name+".throttled", 1, nil, 1)
+		f.mu.Lock()
+		f.done(key)
+		f.mu.Unlock()
+		return
+	}
+
+	ttl := f.conf.ttl
+	value, err := f.fetch(key)
+	if err != nil {
+		log.Debugf("Error fetching %v from %s: %v", key, f.conf.url, err)
+		metrics.Count("datadog.trace_agent."+f.conf.name+".errors", 1, nil, 1)
+		value, ttl = nil, f.conf.errorTTL
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	defer f.done(key)
+	if ttl <= 0 {
+		return
+	}
+	if _, ok := f.cache[key]; !ok && len(f.cache) >= f.conf.maxEntries {
+		// make room by evicting any value
+		for k := range f.cache {
+			delete(f.cache, k)
+			break
+		}
+	}
+	f.cache[key] = fetchedValue{value: value, expires: time.Now().Add(ttl)}
+}
+
+// done removes key from the pending keys and releases its waiters. It must be
+// called with f.mu held.
+func (f *backgroundFetcher) done(key interface{}) {
+	if done, ok := f.pending[key]; ok {
+		close(done)
+		delete(f.pending, key)
+	}
+}
+
+// sweep removes the expired values of the cache, at most once per second. It
+// must be called with f.mu held.
+func (f *backgroundFetcher) sweep(now time.Time) {
+	if now.Before(f.sweepAt) {
+		return
+	}
+	f.sweepAt = now.Add(time.Second)
+	for k, v := range f.cache {
+		if !now.Before(v.expires) {
+			delete(f.cache, k)
+		}
+	}
+}
+
+// allow reports whether a request can be made to the API without going over
+// the maximum number of requests per second. It must be called with f.mu held.
+func (f *backgroundFetcher) allow(now time.Time) bool {
+	if f.conf.maxRPS <= 0 {
+		return true
+	}
+	if now.Sub(f.windowStart) >= time.Second {
+		f.windowStart = now
+		f.windowCount = 0
+	}
+	if f.windowCount >= f.conf.maxRPS {
+		return false
+	}
+	f.windowCount++
+	return true
+}
+
+// fetch queries the API for the value of key.
+func (f *backgroundFetcher) fetch(key interface{}) (interface{}, error) {
+	u, err := url.Parse(f.conf.url)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set(f.conf.param, fmt.Sprint(key))
+	u.RawQuery = q.Encode()
+
+	resp, err := f.client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		// unknown key, cache it as having no value
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	return f.conf.decode(resp.Body)
+}
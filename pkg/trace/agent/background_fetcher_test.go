@@ -0,0 +1,116 @@
+package agent
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// drainFetchQueue fetches the values of the keys queued by f.
+func drainFetchQueue(f *backgroundFetcher) {
+	for {
+		select {
+		case key := <-f.queue:
+			f.load(key)
+		default:
+			return
+		}
+	}
+}
+
+func TestBackgroundFetcher(t *testing.T) {
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		switch r.URL.Query().Get("key") {
+		case "7":
+			w.Write([]byte("seven"))
+		case "500":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	newFetcher := func(errorTTL time.Duration) *backgroundFetcher {
+		return newBackgroundFetcher(fetcherConfig{
+			name:  "test",
+			url:   srv.URL,
+			param: "key",
+			decode: func(r io.Reader) (interface{}, error) {
+				b, err := ioutil.ReadAll(r)
+				return string(b), err
+			},
+			ttl:        time.Minute,
+			errorTTL:   errorTTL,
+			maxEntries: 10,
+			workers:    1,
+		})
+	}
+
+	t.Run("get", func(t *testing.T) {
+		atomic.StoreInt64(&hits, 0)
+		f := newFetcher(0)
+		_, ok := f.get(7)
+		assert.False(t, ok)
+		drainFetchQueue(f)
+		v, ok := f.get(7)
+		assert.True(t, ok)
+		assert.Equal(t, "seven", v)
+
+		_, ok = f.get(8)
+		assert.False(t, ok)
+		drainFetchQueue(f)
+		v, ok = f.get(8)
+		assert.True(t, ok)
+		assert.Nil(t, v, "unknown keys are cached as having no value")
+		assert.EqualValues(t, 2, atomic.LoadInt64(&hits))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		atomic.StoreInt64(&hits, 0)
+		f := newFetcher(0)
+		f.get(500)
+		drainFetchQueue(f)
+		_, ok := f.get(500)
+		assert.False(t, ok, "errors are not cached without errorTTL")
+
+		f = newFetcher(time.Second)
+		f.get(500)
+		drainFetchQueue(f)
+		v, ok := f.get(500)
+		assert.True(t, ok, "errors are cached for errorTTL")
+		assert.Nil(t, v)
+	})
+
+	t.Run("wait", func(t *testing.T) {
+		atomic.StoreInt64(&hits, 0)
+		f := newFetcher(0)
+		f.Start()
+		defer f.Stop()
+		v, ok := f.wait(7, time.Second)
+		assert.True(t, ok)
+		assert.Equal(t, "seven", v)
+		_, ok = f.wait(500, time.Second)
+		assert.False(t, ok, "waiters are released on errors")
+		assert.EqualValues(t, 2, atomic.LoadInt64(&hits))
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		atomic.StoreInt64(&hits, 0)
+		f := newFetcher(0)
+		f.get(7)
+		f.get(8)
+		f.Start()
+		f.Stop()
+		assert.Empty(t, f.queue, "queued keys are fetched before stopping")
+		assert.EqualValues(t, 2, atomic.LoadInt64(&hits))
+	})
+}
@@ -0,0 +1,64 @@
+package agent
+
+import (
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/sampler"
+)
+
+// BusinessImpactSampler weights the sample rate of traces by their business
+// impact, read from a tag of their root span, so that revenue-critical traces
+// are kept more often than the ones of health checks.
+type BusinessImpactSampler struct {
+	tag     string
+	weights map[string]float64
+}
+
+// NewBusinessImpactSampler returns a new BusinessImpactSampler weighting the
+// traces by the values of conf.BusinessImpactTag.
+func NewBusinessImpactSampler(conf *config.SamplerConfig) *BusinessImpactSampler {
+	return &BusinessImpactSampler{
+		tag:     conf.BusinessImpactTag,
+		weights: conf.BusinessImpactWeights,
+	}
+}
+
+// Weight returns the weight of the business impact of the trace of the given
+// root span, or 1 if it has none or an unknown one.
+func (s *BusinessImpactSampler) Weight(root *pb.Span) float64 {
+	if root == nil {
+		return 1
+	}
+	impact, ok := root.Meta[s.tag]
+	if !ok {
+		return 1
+	}
+	weight, ok := s.weights[impact]
+	if !ok || weight < 0 {
+		return 1
+	}
+	return weight
+}
+
+// Sample returns the sampling decision and rate of the trace of the given root
+// span once its rate is multiplied by its weight, capped at 1. Traces with
+// weights above 1 are kept if they were sampled or if they are sampled with
+// the weighted rate, so that they are always kept once it reaches 1, and the
+// ones with weights below 1 are only kept if they were sampled and sampled
+// again with their weight.
+func (s *BusinessImpactSampler) Sample(root *pb.Span, sampled bool, rate float64) (bool, float64) {
+	weight := s.Weight(root)
+	if weight == 1 {
+		return sampled, rate
+	}
+	metrics.Count("datadog.trace_agent.sampler.business_impact", 1, []string{"impact:" + root.Meta[s.tag]}, 1)
+	if weight > 1 {
+		rate *= weight
+		if rate > 1 {
+			rate = 1
+		}
+		return sampled || sampler.SampleByRate(root.TraceID, rate), rate
+	}
+	return sampled && sampler.SampleByRate(root.TraceID, weight), rate * weight
+}
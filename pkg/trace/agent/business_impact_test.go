@@ -0,0 +1,57 @@
+package agent
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBusinessImpactSampler(t *testing.T) {
+	assert := assert.New(t)
+	s := NewBusinessImpactSampler(&config.SamplerConfig{
+		BusinessImpactTag:     "business.impact",
+		BusinessImpactWeights: map[string]float64{"critical": 10, "high": 5, "low": 0.1},
+	})
+	root := func(impact string) *pb.Span {
+		return &pb.Span{Service: "checkout", TraceID: rand.Uint64(), Meta: map[string]string{"business.impact": impact}}
+	}
+
+	assert.Equal(10.0, s.Weight(root("critical")))
+	assert.Equal(0.1, s.Weight(root("low")))
+	assert.Equal(1.0, s.Weight(root("unknown")))
+	assert.Equal(1.0, s.Weight(&pb.Span{Service: "checkout"}))
+	assert.Equal(1.0, s.Weight(nil))
+
+	const n = 10000
+	var critical, low int
+	for i := 0; i < n; i++ {
+		// critical traces are kept even when dropped by the samplers
+		sampled, rate := s.Sample(root("critical"), false, 0.2)
+		assert.Equal(1.0, rate)
+		if sampled {
+			critical++
+		}
+		sampled, rate = s.Sample(root("low"), true, 1)
+		assert.Equal(0.1, rate)
+		if sampled {
+			low++
+		}
+	}
+	assert.Equal(n, critical)
+	assert.InDelta(0.1*n, low, 0.02*n)
+
+	// weighted rates are capped at 1
+	sampled, rate := s.Sample(root("high"), true, 0.5)
+	assert.True(sampled)
+	assert.Equal(1.0, rate)
+	// dropped traces stay dropped
+	sampled, rate = s.Sample(root("low"), false, 0.5)
+	assert.False(sampled)
+	assert.Equal(0.05, rate)
+	sampled, rate = s.Sample(root("unknown"), false, 0.5)
+	assert.False(sampled)
+	assert.Equal(0.5, rate)
+}
@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"regexp"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/sampler"
+)
+
+// versionTagKey is the tag holding the version of the application which
+// reported a span.
+const versionTagKey = "version"
+
+// CanaryRollbackSampler deprioritizes the traces of canary deployments while a
+// rollback is in progress, so that sampling focuses on the main deployment.
+// Canary traces are recognized by the version tag of their root span matching
+// a regular expression.
+type CanaryRollbackSampler struct {
+	pattern  *regexp.Regexp
+	rate     float64
+	rollback *sampler.RollbackState
+}
+
+// NewCanaryRollbackSampler returns a new CanaryRollbackSampler, whose rollback
+// mode is read from rollback.
+func NewCanaryRollbackSampler(conf *config.SamplerConfig, rollback *sampler.RollbackState) (*CanaryRollbackSampler, error) {
+	pattern, err := regexp.Compile(conf.CanaryVersionPattern)
+	if err != nil {
+		return nil, err
+	}
+	return &CanaryRollbackSampler{
+		pattern:  pattern,
+		rate:     conf.CanaryRollbackRate,
+		rollback: rollback,
+	}, nil
+}
+
+// Rate returns the rate to multiply the sample rate of the trace of the given
+// root span with: the canary rollback rate when a rollback is in progress and
+// the trace comes from a canary deployment, and 1 otherwise.
+func (s *CanaryRollbackSampler) Rate(root *pb.Span) float64 {
+	if root == nil || !s.rollback.Active() {
+		return 1
+	}
+	version, ok := root.Meta[versionTagKey]
+	if !ok || !s.pattern.MatchString(version) {
+		return 1
+	}
+	metrics.Count("datadog.trace_agent.sampler.canary_rollback", 1, []string{"service:" + root.Service}, 1)
+	return s.rate
+}
@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/sampler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanaryRollbackSampler(t *testing.T) {
+	assert := assert.New(t)
+	var rollback sampler.RollbackState
+	s, err := NewCanaryRollbackSampler(&config.SamplerConfig{
+		CanaryVersionPattern: `-canary(\.\d+)?$`,
+		CanaryRollbackRate:   0.01,
+	}, &rollback)
+	require.NoError(t, err)
+	root := func(version string) *pb.Span {
+		return &pb.Span{Service: "web", Meta: map[string]string{"version": version}}
+	}
+
+	// canary traces are sampled normally outside of rollbacks
+	assert.Equal(1.0, s.Rate(root("1.4.0-canary")))
+
+	rollback.Set(true)
+	assert.Equal(0.01, s.Rate(root("1.4.0-canary")))
+	assert.Equal(0.01, s.Rate(root("1.4.0-canary.2")))
+	assert.Equal(1.0, s.Rate(root("1.3.9")))
+	assert.Equal(1.0, s.Rate(root("1.4.0-canary-fix")))
+	assert.Equal(1.0, s.Rate(&pb.Span{Service: "web"}))
+	assert.Equal(1.0, s.Rate(nil))
+
+	rollback.Set(false)
+	assert.Equal(1.0, s.Rate(root("1.4.0-canary")))
+
+	_, err = NewCanaryRollbackSampler(&config.SamplerConfig{CanaryVersionPattern: "canary("}, &rollback)
+	assert.Error(err)
+}
+
+func TestCanaryRollbackUserKeep(t *testing.T) {
+	cfg := config.New()
+	cfg.Endpoints[0].APIKey = "test"
+	cfg.Sampler.CanaryVersionPattern = `-canary$`
+	cfg.Sampler.RollbackActive = true
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	agnt := NewAgent(ctx, cfg)
+	if !assert.NotNil(t, agnt.canary) {
+		return
+	}
+
+	now := time.Now()
+	for id := uint64(1); id <= 3; id++ {
+		root := &pb.Span{
+			TraceID:  id,
+			SpanID:   1,
+			Service:  "web",
+			Name:     "http.request",
+			Resource: "GET /",
+			Start:    now.Add(-time.Second).UnixNano(),
+			Duration: (500 * time.Millisecond).Nanoseconds(),
+			Meta:     map[string]string{"version": "1.4.0-canary"},
+		}
+		sampler.SetSamplingPriority(root, sampler.PriorityUserKeep)
+		agnt.Process(pb.Trace{root})
+		select {
+		case ss := <-agnt.spansOut:
+			assert.Len(t, ss.Trace, 1, "user kept traces are kept during rollbacks")
+		case <-time.After(5 * time.Second):
+			t.Fatalf("trace %d was not kept", id)
+		}
+	}
+}
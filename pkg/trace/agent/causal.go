@@ -0,0 +1,252 @@
+package agent
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+)
+
+const (
+	// causalRootServiceKey is the meta key holding the service whose errors
+	// predict the errors of the service of an error span.
+	causalRootServiceKey = "_dd.causal_root_service"
+
+	// causalWindow is the duration of the windows errors are counted in.
+	causalWindow = 10 * time.Second
+
+	// causalWindows is the number of windows errors are kept for.
+	causalWindows = 30
+
+	// minCausalWindows is the number of completed windows needed before
+	// correlating errors.
+	minCausalWindows = 10
+
+	// grangerFThreshold is the F statistic above which the errors of a service
+	// are considered to predict the errors of another, close to the critical
+	// value of F(1, n) at the 5% level for the number of windows used.
+	grangerFThreshold = 4.2
+
+	// maxCausalServices is the maximum number of services whose errors are
+	// counted, so that the analysis stays bounded.
+	maxCausalServices = 200
+)
+
+// errorSeries holds the number of error traces of a service in the last
+// windows, indexed by window modulo causalWindows.
+type errorSeries [causalWindows]float64
+
+// CausalCorrelationAnalyzer finds the likely root cause of the errors of a
+// service among other failing services. It counts the traces in error of each
+// service in windows of 10s and, each time a window completes, runs a Granger
+// causality test between each pair of services: the errors of A predict the
+// errors of B when the errors of A in the previous window significantly improve
+// the prediction of the errors of B made from its own previous window.
+//
+// Error spans of a service are then annotated with the service whose errors
+// predict its errors best, if any, using the "_dd.causal_root_service" meta.
+type CausalCorrelationAnalyzer struct {
+	mu        sync.Mutex
+	current   int64 // index of the current window
+	completed int64 // number of windows completed since the first
+	errors    map[string]*errorSeries
+	roots     map[string]string // causal root by service
+}
+
+// NewCausalCorrelationAnalyzer returns a new CausalCorrelationAnalyzer.
+func NewCausalCorrelationAnalyzer() *CausalCorrelationAnalyzer {
+	return &CausalCorrelationAnalyzer{
+		errors: make(map[string]*errorSeries),
+		roots:  make(map[string]string),
+	}
+}
+
+// Correlate counts the services in error in t at the given time, and
+// annotates the error spans of t with the causal root of their service.
+func (a *CausalCorrelationAnalyzer) Correlate(t pb.Trace, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	idx := a.advance(now.UnixNano() / int64(causalWindow))
+	counted := make(map[string]bool)
+	for _, span := range t {
+		if span.Error == 0 {
+			continue
+		}
+		if !counted[span.Service] {
+			counted[span.Service] = true
+			s, ok := a.errors[span.Service]
+			if !ok && len(a.errors) < maxCausalServices {
+				s = new(errorSeries)
+				a.errors[span.Service] = s
+			}
+			if s != nil {
+				s[idx%causalWindows]++
+			}
+		}
+		if root, ok := a.roots[span.Service]; ok {
+			if span.Meta == nil {
+				span.Meta = make(map[string]string)
+			}
+			span.Meta[causalRootServiceKey] = root
+		}
+	}
+}
+
+// advance moves the current window to idx, computing the causal roots when a
+// window completes, and returns the window errors are counted in.
+func (a *CausalCorrelationAnalyzer) advance(idx int64) int64 {
+	if a.current == 0 {
+		a.current = idx
+	}
+	if idx <= a.current {
+		// errors reported late are counted in the current window
+		return a.current
+	}
+	for i := a.current + 1; i <= idx && i <= a.current+causalWindows; i++ {
+		for _, s := range a.errors {
+			s[i%causalWindows] = 0
+		}
+	}
+	a.completed += idx - a.current
+	a.current = idx
+	for service, s := range a.errors {
+		if *s == (errorSeries{}) {
+			delete(a.errors, service)
+		}
+	}
+	a.roots = a.computeRoots()
+	return idx
+}
+
+// series returns the errors of s in the completed windows, oldest first.
+func (a *CausalCorrelationAnalyzer) series(s *errorSeries) []float64 {
+	n := a.completed
+	if n > causalWindows-1 {
+		n = causalWindows - 1
+	}
+	values := make([]float64, 0, n)
+	for i := a.current - n; i < a.current; i++ {
+		values = append(values, s[i%causalWindows])
+	}
+	return values
+}
+
+// computeRoots returns the causal root of the services whose errors are
+// predicted by the errors of another service.
+func (a *CausalCorrelationAnalyzer) computeRoots() map[string]string {
+	roots := make(map[string]string)
+	if a.completed < minCausalWindows {
+		return roots
+	}
+	services := make([]string, 0, len(a.errors))
+	series := make(map[string][]float64, len(a.errors))
+	for service, s := range a.errors {
+		services = append(services, service)
+		series[service] = a.series(s)
+	}
+	// sorted, so that the first of equally predictive services is chosen
+	sort.Strings(services)
+	for _, effect := range services {
+		best := grangerFThreshold
+		for _, cause := range services {
+			if cause == effect {
+				continue
+			}
+			if f := grangerF(series[effect], series[cause]); f > best {
+				best = f
+				roots[effect] = cause
+			}
+		}
+	}
+	return roots
+}
+
+// grangerF returns the F statistic of the Granger causality test of x on y with
+// a lag of one window: it compares the regression of y on its previous value
+// with the regression of y on its previous value and the previous value of x.
+func grangerF(y, x []float64) float64 {
+	n := len(y) - 1
+	df := float64(n - 3)
+	if df <= 0 {
+		return 0
+	}
+	yt, ylag, xlag := y[1:], y[:n], x[:n]
+	restricted := leastSquaresRSS(yt, ylag)
+	unrestricted := leastSquaresRSS(yt, ylag, xlag)
+	if unrestricted < 1e-9 {
+		if restricted-unrestricted > 1e-9 {
+			return math.Inf(1)
+		}
+		return 0
+	}
+	return (restricted - unrestricted) / (unrestricted / df)
+}
+
+// leastSquaresRSS returns the residual sum of squares of the ordinary least
+// squares regression of y on xs, with an intercept. Columns linearly dependent
+// on the previous ones are ignored.
+func leastSquaresRSS(y []float64, xs ...[]float64) float64 {
+	k := len(xs) + 1
+	col := func(j, i int) float64 {
+		if j == 0 {
+			return 1
+		}
+		return xs[j-1][i]
+	}
+	// normal equations, as an augmented matrix
+	m := make([][]float64, k)
+	for r := range m {
+		m[r] = make([]float64, k+1)
+		for i := range y {
+			for c := 0; c < k; c++ {
+				m[r][c] += col(r, i) * col(c, i)
+			}
+			m[r][k] += col(r, i) * y[i]
+		}
+	}
+	// Gauss-Jordan elimination, with partial pivoting
+	pivots := make([]int, k)
+	used := make([]bool, k)
+	for c := 0; c < k; c++ {
+		pivots[c] = -1
+		pivot := -1
+		for r := 0; r < k; r++ {
+			if !used[r] && (pivot < 0 || math.Abs(m[r][c]) > math.Abs(m[pivot][c])) {
+				pivot = r
+			}
+		}
+		if pivot < 0 || math.Abs(m[pivot][c]) < 1e-9 {
+			// dependent column
+			continue
+		}
+		used[pivot] = true
+		pivots[c] = pivot
+		for r := 0; r < k; r++ {
+			if r == pivot || m[r][c] == 0 {
+				continue
+			}
+			f := m[r][c] / m[pivot][c]
+			for cc := c; cc <= k; cc++ {
+				m[r][cc] -= f * m[pivot][cc]
+			}
+		}
+	}
+	beta := make([]float64, k)
+	for c, r := range pivots {
+		if r >= 0 {
+			beta[c] = m[r][k] / m[r][c]
+		}
+	}
+	var rss float64
+	for i := range y {
+		e := y[i]
+		for c := 0; c < k; c++ {
+			e -= beta[c] * col(c, i)
+		}
+		rss += e * e
+	}
+	return rss
+}
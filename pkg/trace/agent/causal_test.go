@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCausalCorrelationAnalyzer(t *testing.T) {
+	assert := assert.New(t)
+	a := NewCausalCorrelationAnalyzer()
+	start := time.Unix(1500000000, 0)
+	fail := func(window int, service string, n int) {
+		for i := 0; i < n; i++ {
+			a.Correlate(pb.Trace{{Service: service, Error: 1}}, start.Add(time.Duration(window)*causalWindow))
+		}
+	}
+	// failures annotated at the given window, by service
+	annotations := func(window int) map[string]string {
+		trace := pb.Trace{
+			{Service: "checkout", Error: 1},
+			{Service: "cart", Error: 1},
+			{Service: "db", Error: 1},
+			{Service: "search", Error: 1},
+			{Service: "web"},
+		}
+		a.Correlate(trace, start.Add(time.Duration(window)*causalWindow))
+		roots := make(map[string]string)
+		for _, span := range trace {
+			if root, ok := span.Meta[causalRootServiceKey]; ok {
+				roots[span.Service] = root
+			}
+		}
+		return roots
+	}
+
+	// db failures cascade to checkout and cart in the next window, while
+	// search fails independently
+	rng := rand.New(rand.NewSource(42))
+	var dbErrors int
+	for w := 0; w < causalWindows; w++ {
+		if w == minCausalWindows-1 {
+			assert.Empty(annotations(w), "not enough windows")
+		}
+		if dbErrors > 0 {
+			fail(w, "checkout", dbErrors+rng.Intn(2))
+			fail(w, "cart", dbErrors-rng.Intn(2))
+		}
+		dbErrors = 0
+		if rng.Float64() < 0.4 {
+			dbErrors = 3 + rng.Intn(4)
+			fail(w, "db", dbErrors)
+		}
+		if rng.Float64() < 0.3 {
+			fail(w, "search", 1+rng.Intn(5))
+		}
+	}
+
+	assert.Equal(map[string]string{"checkout": "db", "cart": "db"}, annotations(causalWindows))
+
+	// the roots are forgotten once errors stop
+	assert.Empty(annotations(3 * causalWindows))
+}
+
+func TestLeastSquaresRSS(t *testing.T) {
+	assert := assert.New(t)
+	x := []float64{1, 2, 3, 4, 5}
+	assert.InDelta(0, leastSquaresRSS([]float64{3, 5, 7, 9, 11}, x), 1e-9)
+	// residuals of the mean
+	assert.InDelta(1, leastSquaresRSS([]float64{1, 2, 1, 2, 1.5}, []float64{0, 0, 0, 0, 0}), 1e-9)
+	// dependent columns are ignored
+	assert.InDelta(0, leastSquaresRSS([]float64{3, 5, 7, 9, 11}, x, x), 1e-9)
+}
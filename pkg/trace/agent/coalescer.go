@@ -0,0 +1,162 @@
+package agent
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/traceutil"
+	"github.com/DataDog/datadog-agent/pkg/trace/watchdog"
+)
+
+// coalescedCountKey is the meta key holding the number of traces a representative
+// trace stands for.
+const coalescedCountKey = "_dd.coalesced_count"
+
+// coalescingKey identifies traces considered identical by the EventCoalescer.
+type coalescingKey struct {
+	tenant    string
+	service   string
+	resource  string
+	errorType string
+}
+
+// coalescedTrace is the representative of a group of identical traces.
+type coalescedTrace struct {
+	tenant    string
+	trace     pb.Trace
+	root      *pb.Span
+	count     int
+	firstSeen time.Time
+}
+
+// EventCoalescer merges rapid-fire identical error traces about to be written,
+// once their stats are computed. The first error trace of a service with a given
+// root resource and error type is held for the duration of the coalescing window,
+// during which identical traces are dropped and counted. Once the window expires,
+// the first trace is passed on with the number of traces it stands for, set on a
+// copy of its root. Traces without errors are passed on immediately.
+type EventCoalescer struct {
+	mu      sync.Mutex
+	pending map[coalescingKey]*coalescedTrace
+
+	window time.Duration
+	out    func(tenant string, t pb.Trace)
+	exit   chan struct{}
+}
+
+// NewEventCoalescer returns a new EventCoalescer which calls out with every trace
+// it does not drop, along with its tenant.
+func NewEventCoalescer(conf *config.AgentConfig, out func(tenant string, t pb.Trace)) *EventCoalescer {
+	return &EventCoalescer{
+		pending: make(map[coalescingKey]*coalescedTrace),
+		window:  conf.Coalescing.Window,
+		out:     out,
+		exit:    make(chan struct{}),
+	}
+}
+
+// Start starts flushing the traces of expired windows periodically.
+func (c *EventCoalescer) Start() {
+	tick := c.window
+	if tick <= 0 || tick > maxAggregatorTick {
+		tick = maxAggregatorTick
+	}
+	go func() {
+		defer watchdog.LogOnPanic()
+		t := time.NewTicker(tick)
+		defer t.Stop()
+		for {
+			select {
+			case now := <-t.C:
+				c.flush(c.expired(now))
+			case <-c.exit:
+				close(c.exit)
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the coalescer and flushes all the pending traces.
+func (c *EventCoalescer) Stop() {
+	c.exit <- struct{}{}
+	<-c.exit
+	c.flush(c.expired(time.Time{}))
+}
+
+// Add coalesces t, belonging to tenant, with the identical traces of the current
+// window, or passes it on when it does not hold an error.
+func (c *EventCoalescer) Add(tenant string, t pb.Trace) {
+	root := traceutil.GetRoot(t)
+	if root == nil || root.Error == 0 {
+		c.out(tenant, t)
+		return
+	}
+	key := coalescingKey{
+		tenant:    tenant,
+		service:   root.Service,
+		resource:  root.Resource,
+		errorType: root.Meta["error.type"],
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ct, ok := c.pending[key]; ok {
+		ct.count++
+		return
+	}
+	c.pending[key] = &coalescedTrace{tenant: tenant, trace: t, root: root, count: 1, firstSeen: time.Now()}
+}
+
+// expired removes and returns the traces whose window started before now, minus
+// the coalescing window. Passing a zero time returns all the traces.
+func (c *EventCoalescer) expired(now time.Time) []*coalescedTrace {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var traces []*coalescedTrace
+	for key, ct := range c.pending {
+		if !now.IsZero() && now.Sub(ct.firstSeen) < c.window {
+			continue
+		}
+		traces = append(traces, ct)
+		delete(c.pending, key)
+	}
+	return traces
+}
+
+// flush passes the representatives of the given groups on, annotated with the
+// size of their group.
+func (c *EventCoalescer) flush(traces []*coalescedTrace) {
+	for _, ct := range traces {
+		t := ct.trace
+		if ct.count > 1 {
+			t = withCoalescedCount(t, ct.root, ct.count)
+			metrics.Count("datadog.trace_agent.coalescer.dropped", int64(ct.count-1), []string{"service:" + ct.root.Service}, 1)
+		}
+		c.out(ct.tenant, t)
+	}
+}
+
+// withCoalescedCount returns a copy of t where root is replaced by a copy of it
+// annotated with count. The spans of t are shared with the stats computation,
+// so they can't be modified.
+func withCoalescedCount(t pb.Trace, root *pb.Span, count int) pb.Trace {
+	annotated := *root
+	annotated.Meta = make(map[string]string, len(root.Meta)+1)
+	for k, v := range root.Meta {
+		annotated.Meta[k] = v
+	}
+	annotated.Meta[coalescedCountKey] = strconv.Itoa(count)
+	cp := make(pb.Trace, len(t))
+	for i, s := range t {
+		if s == root {
+			s = &annotated
+		}
+		cp[i] = s
+	}
+	return cp
+}
@@ -0,0 +1,144 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/sampler"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestEventCoalescer(window time.Duration) (*EventCoalescer, *traceRecorder) {
+	cfg := config.New()
+	cfg.Coalescing.Window = window
+	var r traceRecorder
+	return NewEventCoalescer(cfg, func(_ string, t pb.Trace) { r.record(t) }), &r
+}
+
+func errorTrace(id uint64, service, resource, errType string) pb.Trace {
+	return pb.Trace{
+		{TraceID: id, SpanID: 1, Service: service, Resource: resource, Error: 1, Meta: map[string]string{"error.type": errType}},
+		{TraceID: id, SpanID: 2, ParentID: 1, Service: service},
+	}
+}
+
+func TestEventCoalescer(t *testing.T) {
+	t.Run("burst", func(t *testing.T) {
+		c, r := newTestEventCoalescer(time.Minute)
+		first := errorTrace(1, "web", "GET /users", "TimeoutError")
+		c.Add("", first)
+		for i := uint64(2); i <= 100; i++ {
+			c.Add("", errorTrace(i, "web", "GET /users", "TimeoutError"))
+		}
+		for i := uint64(101); i <= 110; i++ {
+			c.Add("", errorTrace(i, "web", "GET /users", "ConnectionError"))
+		}
+		c.Add("", errorTrace(111, "web", "GET /orders", "TimeoutError"))
+		c.Add("", errorTrace(112, "db", "GET /users", "TimeoutError"))
+		assert.Len(t, r.get(), 0, "error traces are held for the window")
+		assert.Len(t, c.pending, 4)
+
+		c.flush(c.expired(time.Now().Add(time.Minute)))
+		counts := make(map[uint64]string)
+		for _, trace := range r.get() {
+			counts[trace[0].TraceID] = trace[0].Meta[coalescedCountKey]
+		}
+		assert.Equal(t, map[uint64]string{1: "100", 101: "10", 111: "", 112: ""}, counts)
+		assert.Len(t, c.pending, 0)
+		assert.NotContains(t, first[0].Meta, coalescedCountKey, "the count is set on a copy of the root")
+	})
+
+	t.Run("tenant", func(t *testing.T) {
+		cfg := config.New()
+		cfg.Coalescing.Window = time.Minute
+		tenants := make(map[string]int)
+		c := NewEventCoalescer(cfg, func(tenant string, _ pb.Trace) { tenants[tenant]++ })
+		c.Add("org-1", errorTrace(1, "web", "GET /users", "TimeoutError"))
+		c.Add("org-1", errorTrace(2, "web", "GET /users", "TimeoutError"))
+		c.Add("org-2", errorTrace(3, "web", "GET /users", "TimeoutError"))
+		c.flush(c.expired(time.Time{}))
+		assert.Equal(t, map[string]int{"org-1": 1, "org-2": 1}, tenants, "traces of different tenants are not coalesced")
+	})
+
+	t.Run("no-error", func(t *testing.T) {
+		c, r := newTestEventCoalescer(time.Minute)
+		for i := uint64(1); i <= 3; i++ {
+			c.Add("", pb.Trace{{TraceID: i, SpanID: 1, Service: "web", Resource: "GET /users"}})
+		}
+		assert.Len(t, r.get(), 3)
+		assert.Len(t, c.pending, 0)
+	})
+
+	t.Run("window", func(t *testing.T) {
+		c, r := newTestEventCoalescer(time.Minute)
+		c.Add("", errorTrace(1, "web", "GET /users", "TimeoutError"))
+		c.Add("", errorTrace(2, "web", "GET /users", "TimeoutError"))
+		c.flush(c.expired(time.Now()))
+		assert.Len(t, r.get(), 0, "window has not expired")
+
+		c.flush(c.expired(time.Now().Add(time.Minute)))
+		c.Add("", errorTrace(3, "web", "GET /users", "TimeoutError"))
+		c.flush(c.expired(time.Now().Add(time.Minute)))
+		traces := r.get()
+		if assert.Len(t, traces, 2) {
+			assert.Equal(t, "2", traces[0][0].Meta[coalescedCountKey])
+			assert.EqualValues(t, 3, traces[1][0].TraceID, "a new window starts")
+			assert.NotContains(t, traces[1][0].Meta, coalescedCountKey)
+		}
+	})
+
+	t.Run("start-stop", func(t *testing.T) {
+		c, r := newTestEventCoalescer(10 * time.Millisecond)
+		c.Start()
+		for i := uint64(1); i <= 5; i++ {
+			c.Add("", errorTrace(i, "web", "GET /users", "TimeoutError"))
+		}
+		for i := 0; i < 100 && len(r.get()) == 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		c.Add("", errorTrace(6, "db", "query", "DeadlockError"))
+		c.Stop()
+
+		traces := r.get()
+		if assert.Len(t, traces, 2) {
+			assert.Equal(t, "5", traces[0][0].Meta[coalescedCountKey])
+			assert.EqualValues(t, 6, traces[1][0].TraceID, "pending traces are flushed on stop")
+		}
+	})
+}
+
+func TestAgentCoalescing(t *testing.T) {
+	cfg := config.New()
+	cfg.Endpoints[0].APIKey = "test"
+	cfg.Coalescing.Enabled = true
+	cfg.Coalescing.Window = time.Minute
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	agnt := NewAgent(ctx, cfg)
+
+	now := time.Now()
+	for i := uint64(1); i <= 3; i++ {
+		agnt.Process(pb.Trace{{
+			TraceID:  i,
+			SpanID:   1,
+			Service:  "web",
+			Name:     "http.request",
+			Resource: "GET /users",
+			Error:    1,
+			Start:    now.Add(-time.Second).UnixNano(),
+			Duration: (500 * time.Millisecond).Nanoseconds(),
+			Metrics:  map[string]float64{sampler.KeySamplingPriority: 2},
+		}})
+	}
+	assert.Len(t, agnt.Concentrator.In, 3, "the stats of all the traces are computed")
+	assert.Len(t, agnt.spansOut, 0, "the write of the traces is coalesced")
+
+	agnt.Coalescer.flush(agnt.Coalescer.expired(time.Time{}))
+	ss := <-agnt.spansOut
+	if assert.Len(t, ss.Trace, 1) {
+		assert.Equal(t, "3", ss.Trace[0].Meta[coalescedCountKey])
+	}
+}
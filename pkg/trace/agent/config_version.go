@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/obfuscate"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+)
+
+// configVersion is a version of the obfuscation config.
+type configVersion struct {
+	obfuscator *obfuscate.Obfuscator
+	// retired is when the version was replaced by a newer one. It is zero for
+	// the current version.
+	retired time.Time
+}
+
+// configVersionStore holds the versions of the obfuscation config, so that the
+// spans of a trace are all obfuscated with the version the trace was stamped
+// with when it entered the agent, even when the config is reloaded meanwhile.
+// Replaced versions expire after the TTL of the store.
+type configVersionStore struct {
+	ttl time.Duration
+
+	mu       sync.RWMutex
+	current  uint64
+	versions map[uint64]*configVersion
+}
+
+// newConfigVersionStore returns a store whose first version uses conf.
+func newConfigVersionStore(conf *config.ObfuscationConfig, ttl time.Duration) *configVersionStore {
+	return &configVersionStore{
+		ttl:      ttl,
+		current:  1,
+		versions: map[uint64]*configVersion{1: {obfuscator: obfuscate.NewObfuscator(conf)}},
+	}
+}
+
+// ConfigVersion returns the current version, to stamp traces with.
+func (s *configVersionStore) ConfigVersion() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// Obfuscator returns the obfuscator of the given version, or the one of the
+// current version when it expired.
+func (s *configVersionStore) Obfuscator(version uint64) *obfuscate.Obfuscator {
+	return s.obfuscator(version, time.Now())
+}
+
+func (s *configVersionStore) obfuscator(version uint64, now time.Time) *obfuscate.Obfuscator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.versions[version]
+	if !ok || (!v.retired.IsZero() && now.Sub(v.retired) > s.ttl) {
+		v = s.versions[s.current]
+	}
+	return v.obfuscator
+}
+
+// Obfuscate obfuscates span with the obfuscator of the given version.
+func (s *configVersionStore) Obfuscate(version uint64, span *pb.Span) {
+	s.Obfuscator(version).Obfuscate(span)
+}
+
+// reload adds a version using conf, which becomes the current one, and returns
+// it. The expired versions are removed.
+func (s *configVersionStore) reload(conf *config.ObfuscationConfig, now time.Time) uint64 {
+	obfuscator := obfuscate.NewObfuscator(conf)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for version, v := range s.versions {
+		if !v.retired.IsZero() && now.Sub(v.retired) > s.ttl {
+			delete(s.versions, version)
+		}
+	}
+	s.versions[s.current].retired = now
+	s.current++
+	s.versions[s.current] = &configVersion{obfuscator: obfuscator}
+	return s.current
+}
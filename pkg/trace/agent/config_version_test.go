@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/obfuscate"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+// obfuscatedURL returns the URL of an HTTP span obfuscated with o.
+func obfuscatedURL(o *obfuscate.Obfuscator) string {
+	span := &pb.Span{Type: "http", Meta: map[string]string{"http.url": "http://shop/cart?token=secret"}}
+	o.Obfuscate(span)
+	return span.Meta["http.url"]
+}
+
+func TestConfigVersionStore(t *testing.T) {
+	assert := assert.New(t)
+	s := newConfigVersionStore(nil, time.Minute)
+	assert.EqualValues(1, s.ConfigVersion())
+
+	now := time.Now()
+	removeQuery := &config.ObfuscationConfig{HTTP: config.HTTPObfuscationConfig{RemoveQueryString: true}}
+	assert.EqualValues(2, s.reload(removeQuery, now))
+	assert.EqualValues(2, s.ConfigVersion())
+
+	// traces stamped before the reload keep their config
+	assert.Equal("http://shop/cart?token=secret", obfuscatedURL(s.obfuscator(1, now)))
+	assert.Equal("http://shop/cart?", obfuscatedURL(s.obfuscator(2, now)))
+	assert.Equal("http://shop/cart?", obfuscatedURL(s.obfuscator(42, now)), "unknown versions use the current config")
+
+	// replaced versions expire after the TTL
+	later := now.Add(2 * time.Minute)
+	assert.Equal("http://shop/cart?", obfuscatedURL(s.obfuscator(1, later)))
+	assert.EqualValues(3, s.reload(nil, later))
+	assert.Len(s.versions, 2)
+	assert.NotContains(s.versions, uint64(1))
+	assert.Equal("http://shop/cart?", obfuscatedURL(s.obfuscator(2, later)))
+	assert.Equal("http://shop/cart?token=secret", obfuscatedURL(s.obfuscator(3, later)))
+}
+
+func TestAgentReloadObfuscation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := config.New()
+	cfg.Endpoints[0].APIKey = "test"
+	a := NewAgent(ctx, cfg)
+	assert.EqualValues(t, 2, a.ReloadObfuscation(&config.ObfuscationConfig{}))
+	assert.EqualValues(t, 2, a.configs.ConfigVersion())
+}
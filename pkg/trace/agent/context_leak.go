@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"sync"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/traceutil"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// ContextLeakDetector compares each trace with the previous trace of the same
+// root service using traceutil.DetectContextLeak, to find request context such
+// as user or session IDs leaking between requests.
+type ContextLeakDetector struct {
+	mu sync.Mutex
+	// previous holds, by root service, the spans of the previous trace holding
+	// request context, reduced to their context.
+	previous map[string]pb.Trace
+}
+
+// NewContextLeakDetector returns a new ContextLeakDetector.
+func NewContextLeakDetector() *ContextLeakDetector {
+	return &ContextLeakDetector{previous: make(map[string]pb.Trace)}
+}
+
+// Check reports the request context of the previous trace of the service of
+// root leaking into t, as the datadog.trace_agent.context_leak count. The
+// leaked values, such as user IDs, are only logged at the debug level.
+func (d *ContextLeakDetector) Check(root *pb.Span, t pb.Trace) []traceutil.ContextLeak {
+	current := contextSpans(t)
+	if len(current) == 0 {
+		return nil
+	}
+	d.mu.Lock()
+	previous := d.previous[root.Service]
+	d.previous[root.Service] = current
+	d.mu.Unlock()
+
+	leaks := traceutil.DetectContextLeak(current, previous)
+	for _, l := range leaks {
+		log.Warnf("Request context leak: %s of trace %d found in trace %d of service %q", l.Key, l.PreviousTraceID, l.TraceID, l.Service)
+		log.Debugf("Request context leak: %s=%q of trace %d found in trace %d", l.Key, l.Value, l.PreviousTraceID, l.TraceID)
+		metrics.Count("datadog.trace_agent.context_leak", 1, []string{"service:" + l.Service, "key:" + l.Key}, 1)
+	}
+	return leaks
+}
+
+// contextSpans returns copies of the spans of t holding request context, with
+// only the fields used to detect context leaks, so that they can be kept after
+// t is processed.
+func contextSpans(t pb.Trace) pb.Trace {
+	var spans pb.Trace
+	for _, s := range t {
+		var meta map[string]string
+		for _, k := range traceutil.ContextKeys {
+			if v := s.Meta[k]; v != "" {
+				if meta == nil {
+					meta = make(map[string]string, len(traceutil.ContextKeys))
+				}
+				meta[k] = v
+			}
+		}
+		if meta == nil {
+			continue
+		}
+		spans = append(spans, &pb.Span{
+			Service: s.Service,
+			TraceID: s.TraceID,
+			SpanID:  s.SpanID,
+			Start:   s.Start,
+			Meta:    meta,
+		})
+	}
+	return spans
+}
@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/test/testutil"
+	ddlog "github.com/DataDog/datadog-agent/pkg/util/log"
+	"github.com/cihub/seelog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContextLeakDetector(t *testing.T) {
+	assert := assert.New(t)
+	stats := &testutil.TestStatsClient{}
+	defer func(old metrics.StatsClient) { metrics.Client = old }(metrics.Client)
+	metrics.Client = stats
+
+	d := NewContextLeakDetector()
+	check := func(traceID uint64, users ...string) int {
+		root := &pb.Span{TraceID: traceID, SpanID: 1, Service: "web", Meta: map[string]string{"user.id": users[0]}}
+		trace := pb.Trace{root}
+		for i, u := range users[1:] {
+			trace = append(trace, &pb.Span{
+				TraceID:  traceID,
+				SpanID:   uint64(i + 2),
+				ParentID: 1,
+				Service:  "web",
+				Start:    int64(i + 1),
+				Meta:     map[string]string{"user.id": u},
+			})
+		}
+		return len(d.Check(root, trace))
+	}
+
+	assert.Equal(0, check(1, "alice", "alice"))
+	assert.Equal(0, check(2, "alice"))
+	// traces without context do not replace the previous one
+	assert.Equal(0, len(d.Check(&pb.Span{TraceID: 3, Service: "web"}, pb.Trace{{TraceID: 3, Service: "web"}})))
+	assert.Equal(1, check(4, "bob", "alice"))
+	assert.Equal(1, check(5, "carol", "bob"))
+	assert.Equal(0, check(6, "dave", "alice"), "alice is not in the previous trace anymore")
+
+	if assert.Len(stats.CountCalls, 2) {
+		assert.Equal("datadog.trace_agent.context_leak", stats.CountCalls[0].Name)
+		assert.Equal([]string{"service:web", "key:user.id"}, stats.CountCalls[0].Tags)
+	}
+	assert.Len(d.previous["web"], 2)
+	assert.Len(d.previous["web"][0].Meta, 1)
+}
+
+func TestContextLeakDetectorLogs(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := seelog.LoggerFromWriterWithMinLevelAndFormat(&buf, seelog.DebugLvl, "[%Level] %Msg%n")
+	require.NoError(t, err)
+	ddlog.SetupDatadogLogger(logger, "warn")
+	defer ddlog.SetupDatadogLogger(seelog.Disabled, "")
+
+	d := NewContextLeakDetector()
+	d.Check(&pb.Span{TraceID: 1, Service: "web"}, pb.Trace{{TraceID: 1, SpanID: 1, Service: "web", Meta: map[string]string{"user.id": "alice"}}})
+	leaks := d.Check(&pb.Span{TraceID: 2, Service: "web"}, pb.Trace{
+		{TraceID: 2, SpanID: 1, Service: "web", Meta: map[string]string{"user.id": "bob"}},
+		{TraceID: 2, SpanID: 2, ParentID: 1, Service: "web", Start: 1, Meta: map[string]string{"user.id": "alice"}},
+	})
+	require.Len(t, leaks, 1)
+	logger.Flush()
+
+	assert.Contains(t, buf.String(), "[Warn] Request context leak: user.id of trace 1 found in trace 2")
+	assert.NotContains(t, buf.String(), "alice", "leaked values are not logged at the warning level")
+}
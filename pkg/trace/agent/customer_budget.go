@@ -0,0 +1,114 @@
+package agent
+
+import (
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+)
+
+const (
+	// overBudgetRate is the sampling rate applied to the traces of customers
+	// exceeding their budget.
+	overBudgetRate = 0.01
+
+	// maxBudgetCustomers is the maximum number of customers budgets are
+	// tracked for.
+	maxBudgetCustomers = 10000
+)
+
+// customerBucket is the token bucket of a customer, holding the number of
+// traces the customer can still send.
+type customerBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// CustomerBudgetEnforcer gives each customer of a multi-tenant platform a
+// budget of traces per minute, so that the traffic spike of a customer does not
+// lower the sampling of the others. The customer of a trace is read from a tag
+// of its root span, and its budget is a token bucket refilled continuously.
+// Traces of customers exceeding their budget are sampled at a rate of 1%.
+type CustomerBudgetEnforcer struct {
+	budget float64 // traces per minute
+	tag    string
+
+	mu      sync.Mutex
+	buckets map[string]*customerBucket // by customer ID
+}
+
+// NewCustomerBudgetEnforcer returns a new CustomerBudgetEnforcer.
+func NewCustomerBudgetEnforcer(conf *config.MultiTenantConfig) *CustomerBudgetEnforcer {
+	return &CustomerBudgetEnforcer{
+		budget:  float64(conf.TracesBudgetPerCustomerPerMinute),
+		tag:     conf.CustomerIDTag,
+		buckets: make(map[string]*customerBucket),
+	}
+}
+
+// Rate returns the sampling rate to apply to the trace of the given root span
+// received at now: 1 when its customer is within budget, or when it has no
+// customer, and overBudgetRate otherwise.
+func (e *CustomerBudgetEnforcer) Rate(root *pb.Span, now time.Time) float64 {
+	if root == nil {
+		return 1
+	}
+	customer := root.Meta[e.tag]
+	if customer == "" {
+		return 1
+	}
+	if e.take(customer, now) {
+		return 1
+	}
+	metrics.Count("datadog.trace_agent.customer_budget_exceeded", 1, []string{"customer_id:" + customer}, 1)
+	return overBudgetRate
+}
+
+// take takes a token from the bucket of customer, and returns false if there
+// is none left.
+func (e *CustomerBudgetEnforcer) take(customer string, now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	b, ok := e.buckets[customer]
+	if !ok {
+		if len(e.buckets) >= maxBudgetCustomers {
+			e.purge(now)
+		}
+		if len(e.buckets) >= maxBudgetCustomers {
+			// too many customers to enforce their budget
+			return true
+		}
+		b = &customerBucket{tokens: e.budget, last: now}
+		e.buckets[customer] = b
+	}
+	e.refill(b, now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// refill adds to b the tokens earned since it was last refilled.
+func (e *CustomerBudgetEnforcer) refill(b *customerBucket, now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += e.budget * elapsed.Minutes()
+		if b.tokens > e.budget {
+			b.tokens = e.budget
+		}
+		b.last = now
+	}
+}
+
+// purge removes the buckets which are full, as their customers did not send
+// traces for a while.
+func (e *CustomerBudgetEnforcer) purge(now time.Time) {
+	for customer, b := range e.buckets {
+		e.refill(b, now)
+		if b.tokens >= e.budget {
+			delete(e.buckets, customer)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/sampler"
+	"github.com/DataDog/datadog-agent/pkg/trace/test/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCustomerBudgetEnforcer(t *testing.T) {
+	assert := assert.New(t)
+	stats := &testutil.TestStatsClient{}
+	defer func(old metrics.StatsClient) { metrics.Client = old }(metrics.Client)
+	metrics.Client = stats
+
+	e := NewCustomerBudgetEnforcer(&config.MultiTenantConfig{
+		TracesBudgetPerCustomerPerMinute: 60,
+		CustomerIDTag:                    "customer.id",
+	})
+	root := func(customer string) *pb.Span {
+		return &pb.Span{Service: "web", Meta: map[string]string{"customer.id": customer}}
+	}
+	now := time.Now()
+
+	for i := 0; i < 60; i++ {
+		assert.Equal(1.0, e.Rate(root("acme"), now))
+	}
+	assert.Equal(overBudgetRate, e.Rate(root("acme"), now))
+	assert.Equal(overBudgetRate, e.Rate(root("acme"), now))
+	// other customers are not affected by the spike of acme
+	assert.Equal(1.0, e.Rate(root("initech"), now))
+	// traces without customer are never limited
+	assert.Equal(1.0, e.Rate(&pb.Span{Service: "web"}, now))
+	assert.Equal(1.0, e.Rate(nil, now))
+
+	// the budget is refilled continuously, up to a minute of traces
+	now = now.Add(2 * time.Second)
+	assert.Equal(1.0, e.Rate(root("acme"), now))
+	assert.Equal(1.0, e.Rate(root("acme"), now))
+	assert.Equal(overBudgetRate, e.Rate(root("acme"), now))
+	now = now.Add(time.Hour)
+	for i := 0; i < 60; i++ {
+		assert.Equal(1.0, e.Rate(root("acme"), now))
+	}
+	assert.Equal(overBudgetRate, e.Rate(root("acme"), now))
+
+	if assert.Len(stats.CountCalls, 4) {
+		assert.Equal("datadog.trace_agent.customer_budget_exceeded", stats.CountCalls[0].Name)
+		assert.Equal([]string{"customer_id:acme"}, stats.CountCalls[0].Tags)
+	}
+}
+
+func TestCustomerBudgetEnforcerTag(t *testing.T) {
+	e := NewCustomerBudgetEnforcer(&config.MultiTenantConfig{
+		TracesBudgetPerCustomerPerMinute: 1,
+		CustomerIDTag:                    "tenant.id",
+	})
+	now := time.Now()
+	root := &pb.Span{Meta: map[string]string{"tenant.id": "acme", "customer.id": "initech"}}
+	assert.Equal(t, 1.0, e.Rate(root, now))
+	assert.Equal(t, overBudgetRate, e.Rate(root, now))
+	assert.Equal(t, 1.0, e.Rate(&pb.Span{Meta: map[string]string{"customer.id": "acme"}}, now))
+}
+
+func TestCustomerBudgetEnforcerPurge(t *testing.T) {
+	e := NewCustomerBudgetEnforcer(&config.MultiTenantConfig{
+		TracesBudgetPerCustomerPerMinute: 10,
+		CustomerIDTag:                    "customer.id",
+	})
+	now := time.Now()
+	for i := 0; i < maxBudgetCustomers; i++ {
+		e.Rate(&pb.Span{Meta: map[string]string{"customer.id": fmt.Sprint(i)}}, now)
+	}
+	assert.Len(t, e.buckets, maxBudgetCustomers)
+
+	// full buckets are purged to make room for new customers
+	now = now.Add(time.Minute)
+	e.Rate(&pb.Span{Meta: map[string]string{"customer.id": "new"}}, now)
+	assert.Len(t, e.buckets, 1)
+}
+
+func TestCustomerBudgetUserKeep(t *testing.T) {
+	cfg := config.New()
+	cfg.Endpoints[0].APIKey = "test"
+	cfg.MultiTenant.TracesBudgetPerCustomerPerMinute = 1
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	agnt := NewAgent(ctx, cfg)
+	if !assert.NotNil(t, agnt.budgets) {
+		return
+	}
+
+	now := time.Now()
+	for id := uint64(1); id <= 3; id++ {
+		root := &pb.Span{
+			TraceID:  id,
+			SpanID:   1,
+			Service:  "web",
+			Name:     "http.request",
+			Resource: "GET /",
+			Start:    now.Add(-time.Second).UnixNano(),
+			Duration: (500 * time.Millisecond).Nanoseconds(),
+			Meta:     map[string]string{"customer.id": "acme"},
+		}
+		sampler.SetSamplingPriority(root, sampler.PriorityUserKeep)
+		agnt.Process(pb.Trace{root})
+		select {
+		case ss := <-agnt.spansOut:
+			assert.Len(t, ss.Trace, 1, "user kept traces are kept over budget")
+		case <-time.After(5 * time.Second):
+			t.Fatalf("trace %d was not kept", id)
+		}
+	}
+}
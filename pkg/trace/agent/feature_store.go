@@ -0,0 +1,99 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+)
+
+const (
+	// userIDTagKey is the span tag holding the ID of the user features are fetched for.
+	userIDTagKey = "user.id"
+
+	// featureMetricPrefix prefixes the metrics set from feature store values.
+	featureMetricPrefix = "feature."
+
+	// featureStoreWorkers is the number of goroutines fetching features.
+	featureStoreWorkers = 4
+
+	// featureStoreMaxUsers is the maximum number of users whose features are cached.
+	featureStoreMaxUsers = 100000
+)
+
+// FeatureStoreEnricher sets the features of the user of a span, as found in a
+// feature store, as metrics of the span. Features are fetched by user ID, found
+// in the "user.id" tag, and cached for a configurable duration. They are fetched
+// in the background, so the spans of a user are enriched once the features of
+// this user are cached.
+type FeatureStoreEnricher struct {
+	fetcher *backgroundFetcher
+}
+
+// NewFeatureStoreEnricher returns a new FeatureStoreEnricher using the feature
+// store configured in conf.
+func NewFeatureStoreEnricher(conf *config.AgentConfig) *FeatureStoreEnricher {
+	fs := conf.Enrichment.FeatureStore
+	return &FeatureStoreEnricher{
+		fetcher: newBackgroundFetcher(fetcherConfig{
+			name:       "feature_store",
+			url:        fs.URL,
+			param:      "user_id",
+			decode:     decodeFeatures,
+			ttl:        fs.CacheTTL,
+			maxRPS:     fs.MaxRPS,
+			maxEntries: featureStoreMaxUsers,
+			workers:    featureStoreWorkers,
+		}),
+	}
+}
+
+// Start starts fetching the features of the users queued by Enrich.
+func (e *FeatureStoreEnricher) Start() {
+	e.fetcher.Start()
+}
+
+// Stop fetches the features of the queued users and stops the workers.
+func (e *FeatureStoreEnricher) Stop() {
+	e.fetcher.Stop()
+}
+
+// Enrich sets the features of their user on the spans of t which have one.
+func (e *FeatureStoreEnricher) Enrich(t pb.Trace) {
+	for _, s := range t {
+		userID, ok := s.Meta[userIDTagKey]
+		if !ok || userID == "" {
+			continue
+		}
+		features, ok := e.features(userID)
+		if !ok || len(features) == 0 {
+			continue
+		}
+		if s.Metrics == nil {
+			s.Metrics = make(map[string]float64, len(features))
+		}
+		for k, v := range features {
+			s.Metrics[featureMetricPrefix+k] = v
+		}
+	}
+}
+
+// features returns the cached features of the given user. It returns false if
+// they are unknown, in which case they are queued to be fetched unless they
+// already are.
+func (e *FeatureStoreEnricher) features(userID string) (map[string]float64, bool) {
+	v, ok := e.fetcher.get(userID)
+	features, _ := v.(map[string]float64)
+	return features, ok
+}
+
+// decodeFeatures decodes the features of a user returned by the feature store.
+func decodeFeatures(r io.Reader) (interface{}, error) {
+	var features map[string]float64
+	if err := json.NewDecoder(r).Decode(&features); err != nil {
+		return nil, fmt.Errorf("error decoding features: %v", err)
+	}
+	return features, nil
+}
@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+// newMockFeatureStore returns a feature store knowing users "1" and "2", along
+// with the number of requests it received.
+func newMockFeatureStore() (*httptest.Server, *int64) {
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		switch r.URL.Query().Get("user_id") {
+		case "1":
+			w.Write([]byte(`{"tier": 2, "ab_group": 1}`))
+		case "2":
+			w.Write([]byte(`{"tier": 0}`))
+		case "broken":
+			w.Write([]byte(`{"tier": "gold"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return srv, &hits
+}
+
+func newTestFeatureStoreEnricher(url string, ttl time.Duration, maxRPS float64) *FeatureStoreEnricher {
+	cfg := config.New()
+	cfg.Enrichment.FeatureStore = config.FeatureStoreConfig{URL: url, CacheTTL: ttl, MaxRPS: maxRPS}
+	return NewFeatureStoreEnricher(cfg)
+}
+
+func TestFeatureStoreEnricher(t *testing.T) {
+	srv, hits := newMockFeatureStore()
+	defer srv.Close()
+
+	t.Run("enrich", func(t *testing.T) {
+		atomic.StoreInt64(hits, 0)
+		e := newTestFeatureStoreEnricher(srv.URL, time.Minute, 0)
+		newTrace := func() pb.Trace {
+			return pb.Trace{
+				{SpanID: 1, Meta: map[string]string{"user.id": "1"}},
+				{SpanID: 2, Meta: map[string]string{"user.id": "2"}, Metrics: map[string]float64{"a": 1}},
+				{SpanID: 3, Meta: map[string]string{"user.id": "1"}},
+				{SpanID: 4, Meta: map[string]string{"user.id": "unknown"}},
+				{SpanID: 5, Meta: map[string]string{"user.id": "broken"}},
+				{SpanID: 6},
+			}
+		}
+		trace := newTrace()
+		e.Enrich(trace)
+		assert.Equal(t, map[string]float64{"a": 1}, trace[1].Metrics, "features are fetched in the background")
+		assert.Len(t, e.fetcher.queue, 4, "users are queued once")
+		drainFetchQueue(e.fetcher)
+
+		trace = newTrace()
+		e.Enrich(trace)
+		assert.Equal(t, map[string]float64{"feature.tier": 2, "feature.ab_group": 1}, trace[0].Metrics)
+		assert.Equal(t, map[string]float64{"a": 1, "feature.tier": 0}, trace[1].Metrics)
+		assert.Equal(t, trace[0].Metrics, trace[2].Metrics)
+		assert.Empty(t, trace[3].Metrics)
+		assert.Empty(t, trace[4].Metrics)
+		assert.Empty(t, trace[5].Metrics)
+		drainFetchQueue(e.fetcher)
+		assert.EqualValues(t, 5, atomic.LoadInt64(hits), "only the broken user is fetched again")
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		atomic.StoreInt64(hits, 0)
+		e := newTestFeatureStoreEnricher(srv.URL, time.Minute, 0)
+		e.features("1")
+		drainFetchQueue(e.fetcher)
+		_, ok := e.features("1")
+		assert.True(t, ok)
+		assert.EqualValues(t, 1, atomic.LoadInt64(hits))
+
+		e.fetcher.mu.Lock()
+		entry := e.fetcher.cache["1"]
+		entry.expires = time.Now().Add(-time.Second)
+		e.fetcher.cache["1"] = entry
+		e.fetcher.sweepAt = time.Time{}
+		e.fetcher.mu.Unlock()
+
+		_, ok = e.features("1")
+		assert.False(t, ok)
+		assert.NotContains(t, e.fetcher.cache, "1", "expired entries are removed")
+		drainFetchQueue(e.fetcher)
+		assert.EqualValues(t, 2, atomic.LoadInt64(hits), "expired entries are fetched again")
+	})
+
+	t.Run("bounded", func(t *testing.T) {
+		e := newTestFeatureStoreEnricher(srv.URL, time.Minute, 0)
+		for i := 0; i < featureStoreMaxUsers; i++ {
+			e.fetcher.cache[strconv.Itoa(i)] = fetchedValue{expires: time.Now().Add(time.Minute)}
+		}
+		e.features("1")
+		drainFetchQueue(e.fetcher)
+		assert.Len(t, e.fetcher.cache, featureStoreMaxUsers)
+		_, ok := e.features("1")
+		assert.True(t, ok)
+	})
+
+	t.Run("rate-limit", func(t *testing.T) {
+		atomic.StoreInt64(hits, 0)
+		e := newTestFeatureStoreEnricher(srv.URL, time.Minute, 2)
+		var trace pb.Trace
+		for _, id := range []string{"a", "b", "c", "d"} {
+			trace = append(trace, &pb.Span{Meta: map[string]string{"user.id": id}})
+		}
+		e.Enrich(trace)
+		drainFetchQueue(e.fetcher)
+		assert.EqualValues(t, 2, atomic.LoadInt64(hits))
+		assert.Empty(t, e.fetcher.pending, "throttled users can be queued again")
+
+		e.fetcher.mu.Lock()
+		e.fetcher.windowStart = e.fetcher.windowStart.Add(-time.Second)
+		e.fetcher.mu.Unlock()
+		e.features("1")
+		drainFetchQueue(e.fetcher)
+		_, ok := e.features("1")
+		assert.True(t, ok, "budget is renewed every second")
+	})
+
+	t.Run("unavailable", func(t *testing.T) {
+		e := newTestFeatureStoreEnricher("http://127.0.0.1:0", time.Minute, 0)
+		span := &pb.Span{Meta: map[string]string{"user.id": "1"}}
+		e.Enrich(pb.Trace{span})
+		drainFetchQueue(e.fetcher)
+		e.Enrich(pb.Trace{span})
+		assert.Empty(t, span.Metrics)
+	})
+
+	t.Run("start", func(t *testing.T) {
+		e := newTestFeatureStoreEnricher(srv.URL, time.Minute, 0)
+		e.Start()
+		e.features("1")
+		e.Stop()
+		_, ok := e.features("1")
+		assert.True(t, ok, "queued users are fetched before stopping")
+	})
+}
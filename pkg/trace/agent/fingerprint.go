@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"strconv"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/traceutil"
+)
+
+// tagSpansDeduplicated holds the number of spans removed from a trace by the
+// SpanDeduplicationFingerprinter.
+const tagSpansDeduplicated = "_dd.spans_deduplicated"
+
+// SpanDeduplicationFingerprinter reduces traces having more than a maximum number
+// of spans by keeping a single span per fingerprint. Spans sharing a service,
+// operation name and resource share a fingerprint.
+//
+// The span kept for a fingerprint is selected with consistent hashing: it is the
+// one whose ID hashes the lowest along with the fingerprint. The selection thus
+// does not depend on the order spans are received in, and is the same every
+// time a trace is processed, while remaining fair across spans.
+type SpanDeduplicationFingerprinter struct {
+	maxSpans int
+}
+
+// NewSpanDeduplicationFingerprinter returns a new SpanDeduplicationFingerprinter
+// deduplicating traces of more than maxSpans spans.
+func NewSpanDeduplicationFingerprinter(maxSpans int) *SpanDeduplicationFingerprinter {
+	return &SpanDeduplicationFingerprinter{maxSpans: maxSpans}
+}
+
+// Fingerprint returns the fingerprint of s, computed from its service, operation
+// name and resource.
+func Fingerprint(s *pb.Span) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s.Service))
+	h.Write([]byte{0})
+	h.Write([]byte(s.Name))
+	h.Write([]byte{0})
+	h.Write([]byte(s.Resource))
+	return h.Sum64()
+}
+
+// selectionHash returns the hash used to select the span kept for a fingerprint.
+func selectionHash(fingerprint uint64, s *pb.Span) uint64 {
+	var b [16]byte
+	binary.LittleEndian.PutUint64(b[:8], fingerprint)
+	binary.LittleEndian.PutUint64(b[8:], s.SpanID)
+	h := fnv.New64a()
+	h.Write(b[:])
+	return h.Sum64()
+}
+
+// Deduplicate returns t with a single span per fingerprint when it has more spans
+// than allowed. The root span is always kept. The children of removed spans are
+// attached to the span kept for the same fingerprint. The spans of t are left
+// untouched, as they are shared with the stats and the event extraction: the
+// spans which change are copied.
+func (f *SpanDeduplicationFingerprinter) Deduplicate(t pb.Trace) pb.Trace {
+	if len(t) <= f.maxSpans {
+		return t
+	}
+	root := traceutil.GetRoot(t)
+	fingerprints := make([]uint64, len(t))
+	kept := make(map[uint64]*pb.Span)
+	for i, s := range t {
+		fp := Fingerprint(s)
+		fingerprints[i] = fp
+		k, ok := kept[fp]
+		switch {
+		case !ok, s == root:
+			kept[fp] = s
+		case k != root && selectionHash(fp, s) < selectionHash(fp, k):
+			kept[fp] = s
+		}
+	}
+	if len(kept) == len(t) {
+		return t
+	}
+
+	// removed span ID -> ID of the span kept in its place
+	replacements := make(map[uint64]uint64, len(t)-len(kept))
+	parents := make(map[uint64]uint64, len(t))
+	for i, s := range t {
+		parents[s.SpanID] = s.ParentID
+		if k := kept[fingerprints[i]]; k != s {
+			replacements[s.SpanID] = k.SpanID
+		}
+	}
+	removed := len(t) - len(kept)
+	deduplicated := make(pb.Trace, 0, len(kept))
+	for i, s := range t {
+		if kept[fingerprints[i]] != s {
+			continue
+		}
+		parentID := resolveParent(s, parents, replacements)
+		switch {
+		case s == root:
+			cp := *s
+			cp.Meta = make(map[string]string, len(s.Meta)+1)
+			for k, v := range s.Meta {
+				cp.Meta[k] = v
+			}
+			cp.Meta[tagSpansDeduplicated] = strconv.Itoa(removed)
+			cp.ParentID = parentID
+			s = &cp
+		case parentID != s.ParentID:
+			cp := *s
+			cp.ParentID = parentID
+			s = &cp
+		}
+		deduplicated = append(deduplicated, s)
+	}
+	metrics.Count("datadog.trace_agent.fingerprinter.spans_removed", int64(removed), nil, 1)
+	return deduplicated
+}
+
+// resolveParent returns the ID of the closest ancestor of s which is kept, or
+// replaced by a span other than s itself.
+func resolveParent(s *pb.Span, parents, replacements map[uint64]uint64) uint64 {
+	id := parents[s.SpanID]
+	for i := 0; i < len(parents); i++ {
+		r, ok := replacements[id]
+		if !ok {
+			return id
+		}
+		if r != s.SpanID {
+			return r
+		}
+		// the parent was replaced by s, as in recursive calls
+		id = parents[id]
+	}
+	return id
+}
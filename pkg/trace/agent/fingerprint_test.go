@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprint(t *testing.T) {
+	s := &pb.Span{Service: "web", Name: "redis.command", Resource: "GET", SpanID: 1}
+	assert.Equal(t, Fingerprint(s), Fingerprint(&pb.Span{Service: "web", Name: "redis.command", Resource: "GET", SpanID: 2}))
+	assert.NotEqual(t, Fingerprint(s), Fingerprint(&pb.Span{Service: "web", Name: "redis.command", Resource: "SET"}))
+	assert.NotEqual(t, Fingerprint(s), Fingerprint(&pb.Span{Service: "web", Name: "redis.commandGET"}), "fields are separated")
+}
+
+// newDuplicatedTrace returns a trace whose root calls n times redis and a
+// recursive function, twice nested.
+func newDuplicatedTrace(n int) pb.Trace {
+	t := pb.Trace{
+		{SpanID: 1, Service: "web", Name: "http.request", Resource: "GET /"},
+		{SpanID: 2, ParentID: 1, Service: "web", Name: "fib", Resource: "fib"},
+		{SpanID: 3, ParentID: 2, Service: "web", Name: "fib", Resource: "fib"},
+		{SpanID: 4, ParentID: 3, Service: "web", Name: "sql.query", Resource: "SELECT"},
+	}
+	for i := 0; i < n; i++ {
+		t = append(t, &pb.Span{SpanID: uint64(100 + i), ParentID: 1, Service: "redis", Name: "redis.command", Resource: "GET"})
+	}
+	return t
+}
+
+func TestSpanDeduplicationFingerprinter(t *testing.T) {
+	t.Run("under-limit", func(t *testing.T) {
+		f := NewSpanDeduplicationFingerprinter(100)
+		trace := newDuplicatedTrace(10)
+		assert.Equal(t, newDuplicatedTrace(10), f.Deduplicate(trace))
+	})
+
+	t.Run("deduplicate", func(t *testing.T) {
+		f := NewSpanDeduplicationFingerprinter(5)
+		trace := newDuplicatedTrace(10)
+		dedup := f.Deduplicate(trace)
+		if !assert.Len(t, dedup, 4) {
+			return
+		}
+		assert.EqualValues(t, 1, dedup[0].SpanID, "the root is kept")
+		assert.Equal(t, "10", dedup[0].Meta[tagSpansDeduplicated])
+
+		ids := make(map[uint64]*pb.Span)
+		for _, s := range dedup {
+			ids[s.SpanID] = s
+		}
+		for _, s := range dedup[1:] {
+			assert.Contains(t, ids, s.ParentID, "span %d is attached to a kept span", s.SpanID)
+			assert.NotEqual(t, s.SpanID, s.ParentID)
+		}
+		var fib *pb.Span
+		for _, s := range dedup {
+			if s.Name == "fib" {
+				fib = s
+			}
+		}
+		assert.EqualValues(t, 1, fib.ParentID, "recursive calls are collapsed")
+
+		assert.Equal(t, newDuplicatedTrace(10), trace, "the spans of the original trace are untouched")
+	})
+
+	t.Run("consistent", func(t *testing.T) {
+		f := NewSpanDeduplicationFingerprinter(5)
+		kept := func(trace pb.Trace) uint64 {
+			for _, s := range f.Deduplicate(trace) {
+				if s.Service == "redis" {
+					return s.SpanID
+				}
+			}
+			return 0
+		}
+		want := kept(newDuplicatedTrace(50))
+		for i := 0; i < 10; i++ {
+			trace := newDuplicatedTrace(50)
+			rand.Shuffle(len(trace), func(i, j int) { trace[i], trace[j] = trace[j], trace[i] })
+			assert.Equal(t, want, kept(trace), "the selection does not depend on the order of spans")
+		}
+	})
+
+	t.Run("fair", func(t *testing.T) {
+		// across many traces, the kept span is not always the same position
+		f := NewSpanDeduplicationFingerprinter(5)
+		positions := make(map[uint64]bool)
+		for i := 0; i < 50; i++ {
+			trace := newDuplicatedTrace(10)
+			for j, s := range trace[4:] {
+				s.SpanID = uint64(1000*(i+1) + j)
+			}
+			for _, s := range f.Deduplicate(trace) {
+				if s.Service == "redis" {
+					positions[s.SpanID%1000] = true
+				}
+			}
+		}
+		assert.True(t, len(positions) > 1)
+	})
+}
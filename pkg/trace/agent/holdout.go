@@ -0,0 +1,174 @@
+package agent
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/traceutil"
+	"github.com/DataDog/datadog-agent/pkg/trace/watchdog"
+	"github.com/DataDog/datadog-agent/pkg/trace/writer"
+)
+
+const (
+	// holdoutKey is the meta key set on the root span of holdout traces.
+	holdoutKey = "_dd.holdout"
+
+	// holdoutFlushInterval is the interval at which the holdout traces are
+	// sent.
+	holdoutFlushInterval = time.Hour
+
+	// holdoutMaxServices is the maximum number of services holding traces.
+	// The traces of other services are not held until the next flush.
+	holdoutMaxServices = 1000
+)
+
+// HoldoutSampler keeps a uniform random sample of the traces of each service,
+// regardless of the decisions of the other samplers, so that analytics can be
+// computed on traces free of sampling bias. It holds at most size traces per
+// service, picked with reservoir sampling, which are sent every hour with the
+// _dd.holdout tag set to true on their root span. The held traces which were
+// also kept by the other samplers are not sent twice.
+type HoldoutSampler struct {
+	mu            sync.Mutex
+	holdoutBuffer map[string][]holdoutTrace // by service
+	seen          map[string]int            // number of traces seen by service
+
+	size  int
+	rand  *rand.Rand
+	write func(root *pb.Span, tenant string, ss *writer.SampledSpans)
+	exit  chan struct{}
+	wg    sync.WaitGroup // waits for the flush loop
+}
+
+// holdoutTrace is a trace of the holdout set.
+type holdoutTrace struct {
+	tenant string
+	// trace is a copy of the held trace, nil when it was kept by the other
+	// samplers and was already written.
+	trace pb.Trace
+}
+
+// NewHoldoutSampler returns a HoldoutSampler holding size traces per service,
+// sent with write.
+func NewHoldoutSampler(size int, write func(root *pb.Span, tenant string, ss *writer.SampledSpans)) *HoldoutSampler {
+	return &HoldoutSampler{
+		holdoutBuffer: make(map[string][]holdoutTrace),
+		seen:          make(map[string]int),
+		size:          size,
+		rand:          rand.New(rand.NewSource(time.Now().UnixNano())),
+		write:         write,
+		exit:          make(chan struct{}),
+	}
+}
+
+// Start starts sending the holdout traces every hour.
+func (h *HoldoutSampler) Start() {
+	h.wg.Add(1)
+	go func() {
+		defer watchdog.LogOnPanic()
+		defer h.wg.Done()
+		t := time.NewTicker(holdoutFlushInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				h.flush()
+			case <-h.exit:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops sending the holdout traces every hour, and sends the current
+// holdout set.
+func (h *HoldoutSampler) Stop() {
+	close(h.exit)
+	h.wg.Wait()
+	h.flush()
+}
+
+// Add offers the trace of the given root and tenant to the holdout set of its
+// service, kept telling whether the other samplers kept it. A trace entering the
+// set is copied, as it may be modified once sent by the other samplers.
+func (h *HoldoutSampler) Add(root *pb.Span, tenant string, trace pb.Trace, kept bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	service := root.Service
+	if _, ok := h.seen[service]; !ok && len(h.seen) >= holdoutMaxServices {
+		metrics.Count("datadog.trace_agent.holdout.dropped", 1, nil, 1)
+		return
+	}
+	h.seen[service]++
+	buffer := h.holdoutBuffer[service]
+	if len(buffer) < h.size {
+		h.holdoutBuffer[service] = append(buffer, newHoldoutTrace(tenant, trace, kept))
+		return
+	}
+	// the trace replaces a random one with probability size/seen, so that each
+	// trace seen has the same chance of being held
+	if i := h.rand.Intn(h.seen[service]); i < h.size {
+		buffer[i] = newHoldoutTrace(tenant, trace, kept)
+	}
+}
+
+// newHoldoutTrace returns the holdout trace of the given trace.
+func newHoldoutTrace(tenant string, trace pb.Trace, kept bool) holdoutTrace {
+	if kept {
+		return holdoutTrace{tenant: tenant}
+	}
+	return holdoutTrace{tenant: tenant, trace: copyTrace(trace)}
+}
+
+// flush sends the holdout traces and starts a new holdout set.
+func (h *HoldoutSampler) flush() {
+	h.mu.Lock()
+	holdout := h.holdoutBuffer
+	h.holdoutBuffer = make(map[string][]holdoutTrace)
+	h.seen = make(map[string]int)
+	h.mu.Unlock()
+
+	for service, buffer := range holdout {
+		var kept int64
+		for _, ht := range buffer {
+			if ht.trace == nil {
+				kept++
+				continue
+			}
+			root := traceutil.GetRoot(ht.trace)
+			if root.Meta == nil {
+				root.Meta = make(map[string]string)
+			}
+			root.Meta[holdoutKey] = "true"
+			h.write(root, ht.tenant, &writer.SampledSpans{Trace: ht.trace})
+		}
+		tags := []string{"service:" + service}
+		metrics.Count("datadog.trace_agent.holdout.traces", int64(len(buffer))-kept, tags, 1)
+		metrics.Count("datadog.trace_agent.holdout.already_kept", kept, tags, 1)
+	}
+}
+
+// copyTrace returns a deep copy of trace.
+func copyTrace(trace pb.Trace) pb.Trace {
+	cp := make(pb.Trace, len(trace))
+	for i, span := range trace {
+		s := *span
+		if span.Meta != nil {
+			s.Meta = make(map[string]string, len(span.Meta))
+			for k, v := range span.Meta {
+				s.Meta[k] = v
+			}
+		}
+		if span.Metrics != nil {
+			s.Metrics = make(map[string]float64, len(span.Metrics))
+			for k, v := range span.Metrics {
+				s.Metrics[k] = v
+			}
+		}
+		cp[i] = &s
+	}
+	return cp
+}
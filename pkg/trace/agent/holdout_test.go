@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/traceutil"
+	"github.com/DataDog/datadog-agent/pkg/trace/writer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHoldoutSampler(size, capacity int) (*HoldoutSampler, chan *writer.SampledSpans) {
+	out := make(chan *writer.SampledSpans, capacity)
+	h := NewHoldoutSampler(size, func(root *pb.Span, tenant string, ss *writer.SampledSpans) {
+		if root != traceutil.GetRoot(ss.Trace) {
+			panic("the root doesn't belong to the trace")
+		}
+		out <- ss
+	})
+	h.rand = rand.New(rand.NewSource(1))
+	return h, out
+}
+
+func newHoldoutTestTrace(id uint64, service string) pb.Trace {
+	return pb.Trace{
+		{TraceID: id, SpanID: 2, ParentID: 1, Service: service},
+		{TraceID: id, SpanID: 1, Service: service, Metrics: map[string]float64{"_sampling_priority_v1": 1}},
+	}
+}
+
+func TestHoldoutSampler(t *testing.T) {
+	assert := assert.New(t)
+	h, out := newTestHoldoutSampler(10, 100)
+
+	var kept pb.Trace
+	for i := uint64(1); i <= 1000; i++ {
+		trace := newHoldoutTestTrace(i, "web")
+		h.Add(trace[1], "", trace, false)
+		if i == 1 {
+			kept = trace
+		}
+	}
+	for i := uint64(1); i <= 5; i++ {
+		trace := newHoldoutTestTrace(i, "db")
+		h.Add(trace[1], "", trace, false)
+	}
+	assert.Len(h.holdoutBuffer["web"], 10)
+	assert.Len(h.holdoutBuffer["db"], 5)
+	assert.Equal(1000, h.seen["web"])
+
+	// the traces are copied, so that other samplers don't change them
+	kept[1].Metrics["_sample_rate"] = 0.1
+	kept[1].Service = "changed"
+
+	h.flush()
+	close(out)
+	byService := make(map[string][]uint64)
+	for ss := range out {
+		root := ss.Trace[1]
+		assert.Equal("true", root.Meta[holdoutKey], "the root is tagged")
+		assert.NotContains(ss.Trace[0].Meta, holdoutKey)
+		assert.NotContains(root.Metrics, "_sample_rate")
+		byService[root.Service] = append(byService[root.Service], root.TraceID)
+	}
+	assert.Len(byService["web"], 10)
+	assert.Equal([]uint64{1, 2, 3, 4, 5}, byService["db"])
+	assert.Empty(h.holdoutBuffer, "a new holdout set is started")
+	assert.Empty(h.seen)
+	assert.Nil(kept[1].Meta)
+}
+
+func TestHoldoutSamplerUniform(t *testing.T) {
+	// a holdout set of 1 trace out of 4 holds each trace a quarter of the time
+	h, out := newTestHoldoutSampler(1, 1)
+	held := make(map[uint64]int)
+	const runs = 4000
+	for run := 0; run < runs; run++ {
+		for i := uint64(1); i <= 4; i++ {
+			trace := newHoldoutTestTrace(i, "web")
+			h.Add(trace[1], "", trace, false)
+		}
+		h.flush()
+		held[(<-out).Trace[1].TraceID]++
+	}
+	assert.Len(t, held, 4)
+	for id, n := range held {
+		assert.InDelta(t, runs/4, n, runs/20, "trace %d", id)
+	}
+}
+
+func TestHoldoutSamplerKept(t *testing.T) {
+	h, out := newTestHoldoutSampler(10, 10)
+	for i := uint64(1); i <= 4; i++ {
+		trace := newHoldoutTestTrace(i, "web")
+		h.Add(trace[1], "", trace, i%2 == 0)
+	}
+	assert.Equal(t, 4, h.seen["web"], "kept traces count in the holdout set")
+	h.flush()
+	close(out)
+	var written []uint64
+	for ss := range out {
+		written = append(written, ss.Trace[1].TraceID)
+	}
+	assert.Equal(t, []uint64{1, 3}, written, "kept traces are not written twice")
+}
+
+func TestHoldoutSamplerMaxServices(t *testing.T) {
+	h, _ := newTestHoldoutSampler(1, 0)
+	for i := 0; i < holdoutMaxServices+10; i++ {
+		trace := newHoldoutTestTrace(1, fmt.Sprintf("service-%d", i))
+		h.Add(trace[1], "", trace, false)
+	}
+	assert.Len(t, h.holdoutBuffer, holdoutMaxServices)
+	assert.Len(t, h.seen, holdoutMaxServices)
+	trace := newHoldoutTestTrace(2, "service-0")
+	h.Add(trace[1], "", trace, false)
+	assert.Equal(t, 2, h.seen["service-0"], "known services keep being sampled")
+}
+
+func TestHoldoutSamplerStop(t *testing.T) {
+	h, out := newTestHoldoutSampler(10, 10)
+	trace := newHoldoutTestTrace(1, "web")
+	h.Add(trace[1], "tenant", trace, false)
+
+	done := make(chan struct{})
+	go func() {
+		h.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stopping a holdout sampler which was not started blocked")
+	}
+	require.Len(t, out, 1, "the holdout set is sent when stopping")
+	assert.EqualValues(t, 1, (<-out).Trace[1].TraceID)
+
+	h, _ = newTestHoldoutSampler(10, 10)
+	h.Start()
+	h.Stop()
+}
+
+func TestHoldoutSamplerAgent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := config.New()
+	cfg.Endpoints[0].APIKey = "test"
+	assert.Nil(t, NewAgent(ctx, cfg).holdout, "the holdout set is opt-in")
+	cfg.Sampler.HoldoutSize = 100
+	assert.NotNil(t, NewAgent(ctx, cfg).holdout)
+}
@@ -0,0 +1,49 @@
+package agent
+
+import (
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/sampler"
+)
+
+// ImportanceSampler keeps the traces holding spans scored as important by the
+// SpanImportanceScorer more often than the other samplers would: a trace is
+// sampled at least at the highest importance of its spans.
+type ImportanceSampler struct{}
+
+// NewImportanceSampler returns a new ImportanceSampler.
+func NewImportanceSampler() *ImportanceSampler {
+	return &ImportanceSampler{}
+}
+
+// Importance returns the highest importance of the spans of t. It returns
+// false if none of them were scored.
+func (s *ImportanceSampler) Importance(t pb.Trace) (float64, bool) {
+	var importance float64
+	var ok bool
+	for _, span := range t {
+		if score, scored := span.Metrics[importanceMetricKey]; scored {
+			if !ok || score > importance {
+				importance = score
+			}
+			ok = true
+		}
+	}
+	return importance, ok
+}
+
+// Sample returns the sampling decision and rate of the trace t, of the given
+// root span, once sampled at its importance. Traces are kept if they were
+// sampled or if they are sampled at their importance, so that their rate is
+// the highest of both.
+func (s *ImportanceSampler) Sample(root *pb.Span, t pb.Trace, sampled bool, rate float64) (bool, float64) {
+	importance, ok := s.Importance(t)
+	if !ok || importance <= rate {
+		return sampled, rate
+	}
+	if !sampled && sampler.SampleByRate(root.TraceID, importance) {
+		metrics.Count("datadog.trace_agent.sampler.importance", 1, nil, 1)
+		sampled = true
+	}
+	return sampled, importance
+}
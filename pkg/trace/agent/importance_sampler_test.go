@@ -0,0 +1,59 @@
+package agent
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportanceSampler(t *testing.T) {
+	assert := assert.New(t)
+	s := NewImportanceSampler()
+	trace := func(scores ...float64) pb.Trace {
+		spans := pb.Trace{{TraceID: rand.Uint64(), SpanID: 1}}
+		for i, score := range scores {
+			spans = append(spans, &pb.Span{TraceID: spans[0].TraceID, SpanID: uint64(i + 2), Metrics: map[string]float64{importanceMetricKey: score}})
+		}
+		return spans
+	}
+
+	importance, ok := s.Importance(trace(0.2, 0.9, 0.4))
+	assert.True(ok)
+	assert.Equal(0.9, importance)
+	importance, ok = s.Importance(trace(0))
+	assert.True(ok)
+	assert.Equal(0.0, importance)
+	_, ok = s.Importance(trace())
+	assert.False(ok)
+
+	const n = 10000
+	var kept int
+	for i := 0; i < n; i++ {
+		tr := trace(0.1, 0.8)
+		// important traces are kept more often than by the samplers
+		sampled, rate := s.Sample(tr[0], tr, false, 0.2)
+		assert.Equal(0.8, rate)
+		if sampled {
+			kept++
+		}
+
+		// they are never sampled out
+		sampled, rate = s.Sample(tr[0], tr, true, 0.2)
+		assert.True(sampled)
+		assert.Equal(0.8, rate)
+
+		// nor are the sampler rates lowered
+		tr = trace(0.1)
+		sampled, rate = s.Sample(tr[0], tr, false, 0.2)
+		assert.False(sampled)
+		assert.Equal(0.2, rate)
+	}
+	assert.InDelta(0.8, float64(kept)/n, 0.03)
+
+	tr := trace()
+	sampled, rate := s.Sample(tr[0], tr, false, 0.2)
+	assert.False(sampled)
+	assert.Equal(0.2, rate)
+}
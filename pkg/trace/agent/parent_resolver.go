@@ -0,0 +1,43 @@
+package agent
+
+import (
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+)
+
+// SpanParentResolver handles spans delivered out of order, typically by
+// asynchronous systems where children are reported before their parents. Spans
+// are buffered until all the parents of a trace are available, or until the
+// parent resolution window expires, so that top-level spans are computed on the
+// complete set of spans.
+type SpanParentResolver struct {
+	*SpanAggregator
+}
+
+// NewSpanParentResolver returns a new SpanParentResolver which calls out with every
+// resolved or expired trace.
+func NewSpanParentResolver(conf *config.AgentConfig, out func(pb.Trace)) *SpanParentResolver {
+	return &SpanParentResolver{
+		newSpanAggregator(conf.Normalization.ParentResolutionWindow, hasAllParents, "datadog.trace_agent.parent_resolver.flushed", out),
+	}
+}
+
+// hasAllParents reports whether t has a root span and holds the parents of all
+// its other spans.
+func hasAllParents(t pb.Trace) bool {
+	ids := make(map[uint64]struct{}, len(t))
+	for _, s := range t {
+		ids[s.SpanID] = struct{}{}
+	}
+	root := false
+	for _, s := range t {
+		if isRootSpan(s) {
+			root = true
+			continue
+		}
+		if _, ok := ids[s.ParentID]; !ok {
+			return false
+		}
+	}
+	return root
+}
@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/sampler"
+	"github.com/DataDog/datadog-agent/pkg/trace/traceutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSpanParentResolver(window time.Duration) (*SpanParentResolver, *traceRecorder) {
+	cfg := config.New()
+	cfg.Normalization.ParentResolutionWindow = window
+	var r traceRecorder
+	return NewSpanParentResolver(cfg, r.record), &r
+}
+
+func TestSpanParentResolver(t *testing.T) {
+	// web -> web -> db -> db, delivered in reverse topological order
+	spans := func() []*pb.Span {
+		return []*pb.Span{
+			{TraceID: 1, SpanID: 1, ParentID: 0, Service: "web"},
+			{TraceID: 1, SpanID: 2, ParentID: 1, Service: "web"},
+			{TraceID: 1, SpanID: 3, ParentID: 2, Service: "db"},
+			{TraceID: 1, SpanID: 4, ParentID: 3, Service: "db"},
+		}
+	}
+
+	t.Run("reverse-order", func(t *testing.T) {
+		res, r := newTestSpanParentResolver(time.Minute)
+		s := spans()
+		for i := len(s) - 1; i > 0; i-- {
+			res.Add(pb.Trace{s[i]})
+			assert.Len(t, r.get(), 0)
+		}
+		res.Add(pb.Trace{s[0]})
+		traces := r.get()
+		if !assert.Len(t, traces, 1) {
+			return
+		}
+		assert.Len(t, traces[0], 4)
+		assert.Len(t, res.tracesBuffer, 0)
+
+		traceutil.ComputeTopLevel(traces[0])
+		for _, span := range s {
+			assert.Equal(t, span.SpanID == 1 || span.SpanID == 3, traceutil.HasTopLevel(span), "span %d", span.SpanID)
+		}
+	})
+
+	t.Run("missing-parent", func(t *testing.T) {
+		res, r := newTestSpanParentResolver(time.Minute)
+		s := spans()
+		res.Add(pb.Trace{s[3]})
+		res.Add(pb.Trace{s[0], s[2]})
+		assert.Len(t, r.get(), 0, "the root span is not enough, span 2 is missing")
+		res.Add(pb.Trace{s[1]})
+		assert.Len(t, r.get(), 1)
+	})
+
+	t.Run("in-order", func(t *testing.T) {
+		res, r := newTestSpanParentResolver(time.Minute)
+		res.Add(spans())
+		assert.Len(t, r.get(), 1)
+		assert.Len(t, res.tracesBuffer, 0)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		res, r := newTestSpanParentResolver(time.Second)
+		s := spans()
+		res.Add(pb.Trace{s[3]})
+		res.Add(pb.Trace{s[2]})
+		res.flush(res.expired(time.Now()), "timeout")
+		assert.Len(t, r.get(), 0)
+
+		res.flush(res.expired(time.Now().Add(2*time.Second)), "timeout")
+		traces := r.get()
+		if assert.Len(t, traces, 1) {
+			assert.Len(t, traces[0], 2)
+		}
+	})
+	t.Run("remote-parent", func(t *testing.T) {
+		res, r := newTestSpanParentResolver(time.Minute)
+		s := spans()[1:]
+		s[0].Metrics = map[string]float64{sampler.KeySamplingPriority: 1}
+		res.Add(pb.Trace{s[2], s[1]})
+		assert.Len(t, r.get(), 0)
+		res.Add(pb.Trace{s[0]})
+		assert.Len(t, r.get(), 1, "the local root of a distributed trace has a remote parent")
+	})
+}
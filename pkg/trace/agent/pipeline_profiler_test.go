@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics/timing"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/sampler"
+	"github.com/DataDog/datadog-agent/pkg/trace/writer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// TestPipelineProfilerLoad sends 10k traces per second to the receiver for a
+// second, and reports the latency breakdown of the pipeline stages.
+func TestPipelineProfilerLoad(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping load test in short mode")
+	}
+	const (
+		tps      = 10000
+		payloads = 100
+		perBatch = tps / payloads
+	)
+
+	// pick a free port for the receiver
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	cfg := config.New()
+	cfg.Endpoints[0].APIKey = "test"
+	cfg.ReceiverHost = "127.0.0.1"
+	cfg.ReceiverPort = port
+	cfg.Debug.PipelineProfiling = true
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	agnt := NewAgent(ctx, cfg)
+	// keep the sampled traces away from the writers
+	agnt.spansOut = make(chan *writer.SampledSpans, tps)
+	go agnt.Run()
+	go func() {
+		for {
+			select {
+			case <-agnt.Concentrator.Out:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	url := fmt.Sprintf("http://127.0.0.1:%d/v0.4/traces", port)
+	for i := 0; ; i++ {
+		if resp, err := http.Get(url); err == nil {
+			resp.Body.Close()
+			break
+		}
+		require.True(t, i < 1000, "the receiver did not start")
+		time.Sleep(time.Millisecond)
+	}
+
+	tick := time.NewTicker(time.Second / payloads)
+	defer tick.Stop()
+	for i := 0; i < payloads; i++ {
+		traces := make(pb.Traces, perBatch)
+		for j := range traces {
+			span := &pb.Span{
+				Service:  "web",
+				Name:     "http.request",
+				Resource: "GET /users/?",
+				TraceID:  rand.Uint64(),
+				SpanID:   rand.Uint64(),
+				Start:    time.Now().Add(-time.Millisecond).UnixNano(),
+				Duration: int64(time.Millisecond),
+				Meta:     map[string]string{"http.url": "/users/42"},
+			}
+			// make sure every trace goes through all the stages
+			sampler.SetSamplingPriority(span, sampler.PriorityUserKeep)
+			traces[j] = pb.Trace{span}
+		}
+		var buf bytes.Buffer
+		require.NoError(t, msgp.Encode(&buf, traces))
+		resp, err := http.Post(url, "application/msgpack", &buf)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		<-tick.C
+	}
+
+	timeout := time.After(10 * time.Second)
+	for got := 0; got < tps; got++ {
+		select {
+		case <-agnt.spansOut:
+		case <-timeout:
+			t.Fatalf("timed out after %d/%d traces", got, tps)
+		}
+	}
+
+	latencies := agnt.Receiver.Profiler.Report()
+	for _, stage := range []string{timing.StageDecode, timing.StageNormalize, timing.StageObfuscate, timing.StageSample, timing.StageWrite} {
+		l := latencies[stage]
+		t.Logf("%-9s count=%-5d p50=%.3fms p95=%.3fms p99=%.3fms", stage, l.Count, l.P50, l.P95, l.P99)
+		assert.True(t, l.P50 <= l.P95 && l.P95 <= l.P99, stage)
+	}
+	assert.Equal(t, payloads, latencies[timing.StageDecode].Count)
+	assert.Equal(t, tps, latencies[timing.StageNormalize].Count)
+	assert.Equal(t, tps, latencies[timing.StageObfuscate].Count)
+	assert.Equal(t, tps, latencies[timing.StageSample].Count)
+	assert.Equal(t, tps, latencies[timing.StageWrite].Count)
+}
@@ -13,6 +13,12 @@ type ProcessedTrace struct {
 	Root          *pb.Span
 	Env           string
 	Sublayers     stats.SublayerMap
+
+	// Tenant is the organization of the tenant the trace was received from.
+	Tenant string
+
+	// ConfigVersion is the version of the config the trace is processed with.
+	ConfigVersion uint64
 }
 
 // Weight returns the weight at the root span.
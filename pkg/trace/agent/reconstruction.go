@@ -0,0 +1,391 @@
+package agent
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/watchdog"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+const (
+	// reconstructionKeyPrefix prefixes the Redis keys of published traces.
+	reconstructionKeyPrefix = "dd:trace:"
+
+	// reconstructionTimeout is the maximum duration of a call to Redis.
+	reconstructionTimeout = 100 * time.Millisecond
+
+	// reconstructionBackoff is the time during which the lookups are skipped
+	// after Redis failed to answer one.
+	reconstructionBackoff = 5 * time.Second
+
+	// reconstructionQueueSize is the number of traces waiting to be published,
+	// or waiting for the lookup of their remote parents, above which new traces
+	// are not published, or passed on without lookup.
+	reconstructionQueueSize = 1000
+
+	// reconstructionWorkers is the number of concurrent lookups.
+	reconstructionWorkers = 4
+
+	// reconstructionBatchSize is the maximum number of traces published in a
+	// single round trip.
+	reconstructionBatchSize = 100
+
+	// reconstructionFlushInterval is the maximum time a trace waits to be published.
+	reconstructionFlushInterval = 100 * time.Millisecond
+
+	parentServiceKey  = "_dd.parent.service"
+	parentEndpointKey = "_dd.parent.endpoint"
+	relinkedKey       = "_dd.reconstructed"
+)
+
+// remoteParent is a span published as the potential parent of remote spans.
+type remoteParent struct {
+	spanID   uint64
+	service  string
+	endpoint string
+	start    int64
+	end      int64
+}
+
+// encode returns the Redis hash value of p.
+func (p remoteParent) encode() string {
+	return p.service + "\n" + p.endpoint + "\n" + strconv.FormatInt(p.start, 10) + "\n" + strconv.FormatInt(p.end, 10)
+}
+
+// decodeRemoteParent decodes the Redis hash field and value of a remote parent.
+func decodeRemoteParent(field, value string) (remoteParent, bool) {
+	parts := strings.Split(value, "\n")
+	if len(parts) != 4 {
+		return remoteParent{}, false
+	}
+	id, err1 := strconv.ParseUint(field, 10, 64)
+	start, err2 := strconv.ParseInt(parts[2], 10, 64)
+	end, err3 := strconv.ParseInt(parts[3], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return remoteParent{}, false
+	}
+	return remoteParent{spanID: id, service: parts[0], endpoint: parts[1], start: start, end: end}, true
+}
+
+// publication holds the potential remote parents of a trace, waiting to be published.
+type publication struct {
+	traceID uint64
+	parents []remoteParent
+}
+
+// TraceReconstructionService re-links the fragments of traces which are reported
+// by different agents. Each agent publishes asynchronously to a shared Redis the
+// spans of its traces which may be the parent of a remote span: the spans without
+// local children, in a hash keyed by trace ID. Before a trace having orphaned
+// spans is processed, the published spans of its trace are looked up in a single
+// call, in the background. An orphan whose parent is found gets its parent's
+// context. When enabled, the root of a fragment which lost its parent ID is
+// re-linked to the published span enclosing it in time.
+type TraceReconstructionService struct {
+	pool        *redis.Pool
+	ttl         time.Duration
+	relinkRoots bool
+	next        func(pb.Trace)
+
+	in      chan publication
+	lookups chan pb.Trace
+	wg      sync.WaitGroup
+	exit    chan struct{}
+
+	// retryAt is the time, in nanoseconds, before which lookups are skipped
+	// because Redis failed.
+	retryAt int64
+}
+
+// NewTraceReconstructionService returns a new TraceReconstructionService using
+// the Redis configured in conf, which calls next with every trace once it is
+// reconstructed.
+func NewTraceReconstructionService(conf *config.AgentConfig, next func(pb.Trace)) *TraceReconstructionService {
+	addr := conf.Reconstruction.RedisAddr
+	return &TraceReconstructionService{
+		pool: &redis.Pool{
+			MaxIdle:     4,
+			IdleTimeout: time.Minute,
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial("tcp", addr,
+					redis.DialConnectTimeout(reconstructionTimeout),
+					redis.DialReadTimeout(reconstructionTimeout),
+					redis.DialWriteTimeout(reconstructionTimeout),
+				)
+			},
+		},
+		ttl:         conf.Reconstruction.TTL,
+		relinkRoots: conf.Reconstruction.RelinkRoots,
+		next:        next,
+		in:          make(chan publication, reconstructionQueueSize),
+		lookups:     make(chan pb.Trace, reconstructionQueueSize),
+		exit:        make(chan struct{}),
+	}
+}
+
+// Start starts publishing the queued traces and looking up the remote parents
+// of the added traces.
+func (s *TraceReconstructionService) Start() {
+	s.wg.Add(1 + reconstructionWorkers)
+	go func() {
+		defer watchdog.LogOnPanic()
+		defer s.wg.Done()
+		s.run()
+	}()
+	for i := 0; i < reconstructionWorkers; i++ {
+		go func() {
+			defer watchdog.LogOnPanic()
+			defer s.wg.Done()
+			s.work()
+		}()
+	}
+}
+
+// Stop publishes the queued traces, passes on the traces waiting for a lookup
+// and closes the connections to Redis.
+func (s *TraceReconstructionService) Stop() {
+	close(s.exit)
+	s.wg.Wait()
+	s.pool.Close()
+}
+
+func (s *TraceReconstructionService) run() {
+	ticker := time.NewTicker(reconstructionFlushInterval)
+	defer ticker.Stop()
+	batch := make([]publication, 0, reconstructionBatchSize)
+	for {
+		select {
+		case p := <-s.in:
+			batch = append(batch, p)
+			if len(batch) < reconstructionBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-s.exit:
+			for {
+				select {
+				case p := <-s.in:
+					batch = append(batch, p)
+				default:
+					s.publish(batch)
+					return
+				}
+			}
+		}
+		s.publish(batch)
+		batch = batch[:0]
+	}
+}
+
+// publish writes the potential parents of the given traces to Redis, pipelining
+// the commands.
+func (s *TraceReconstructionService) publish(batch []publication) {
+	if len(batch) == 0 {
+		return
+	}
+	conn := s.pool.Get()
+	defer conn.Close()
+	px := int64(s.ttl / time.Millisecond)
+	for _, p := range batch {
+		key := reconstructionKey(p.traceID)
+		args := make(redis.Args, 0, 1+2*len(p.parents)).Add(key)
+		for _, parent := range p.parents {
+			args = args.Add(strconv.FormatUint(parent.spanID, 10), parent.encode())
+		}
+		conn.Send("HMSET", args...)
+		conn.Send("PEXPIRE", key, px)
+	}
+	if err := conn.Flush(); err != nil {
+		log.Debugf("Cannot publish spans for trace reconstruction: %v", err)
+		return
+	}
+	for i := 0; i < 2*len(batch); i++ {
+		if _, err := conn.Receive(); err != nil {
+			log.Debugf("Cannot publish spans for trace reconstruction: %v", err)
+			return
+		}
+	}
+}
+
+// work looks up the remote parents of the added traces, until the service is
+// stopped.
+func (s *TraceReconstructionService) work() {
+	for {
+		select {
+		case t := <-s.lookups:
+			s.Reconstruct(t)
+			s.next(t)
+		case <-s.exit:
+			for {
+				select {
+				case t := <-s.lookups:
+					s.next(t)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Add passes t to next once its orphaned spans are re-linked to their remote
+// parents. The traces without orphans, and the traces added while lookups back
+// off or while too many are waiting, are passed on right away.
+func (s *TraceReconstructionService) Add(t pb.Trace) {
+	if len(t) == 0 || len(s.orphans(t, spanIDs(t))) == 0 || time.Now().UnixNano() < atomic.LoadInt64(&s.retryAt) {
+		s.next(t)
+		return
+	}
+	select {
+	case s.lookups <- t:
+	default:
+		metrics.Count("datadog.trace_agent.reconstruction.skipped", 1, nil, 1)
+		s.next(t)
+	}
+}
+
+// spanIDs returns the IDs of the spans of t.
+func spanIDs(t pb.Trace) map[uint64]struct{} {
+	ids := make(map[uint64]struct{}, len(t))
+	for _, span := range t {
+		ids[span.SpanID] = struct{}{}
+	}
+	return ids
+}
+
+// orphans returns the spans of t whose parent is not in ids, the IDs of the
+// spans of t. Spans without parent ID are true roots unless roots are
+// re-linked.
+func (s *TraceReconstructionService) orphans(t pb.Trace, ids map[uint64]struct{}) []*pb.Span {
+	var orphans []*pb.Span
+	for _, span := range t {
+		if span.ParentID == 0 && !s.relinkRoots {
+			continue
+		}
+		if _, ok := ids[span.ParentID]; !ok {
+			orphans = append(orphans, span)
+		}
+	}
+	return orphans
+}
+
+// Reconstruct re-links the orphaned spans of t to their remote parents. It
+// blocks until the lookup of the parents completes.
+func (s *TraceReconstructionService) Reconstruct(t pb.Trace) {
+	ids := spanIDs(t)
+	orphans := s.orphans(t, ids)
+	if len(orphans) == 0 || time.Now().UnixNano() < atomic.LoadInt64(&s.retryAt) {
+		return
+	}
+	parents, err := s.lookup(t[0].TraceID)
+	if err != nil {
+		log.Debugf("Cannot look up the remote parents of trace %d: %v", t[0].TraceID, err)
+		atomic.StoreInt64(&s.retryAt, time.Now().Add(reconstructionBackoff).UnixNano())
+		return
+	}
+	for _, span := range orphans {
+		parent, ok := findRemoteParent(span, parents, ids)
+		if !ok {
+			metrics.Count("datadog.trace_agent.reconstruction.lookups", 1, []string{"found:false"}, 1)
+			continue
+		}
+		metrics.Count("datadog.trace_agent.reconstruction.lookups", 1, []string{"found:true"}, 1)
+		if span.Meta == nil {
+			span.Meta = make(map[string]string)
+		}
+		if span.ParentID == 0 {
+			span.ParentID = parent.spanID
+			span.Meta[relinkedKey] = "true"
+		}
+		span.Meta[parentServiceKey] = parent.service
+		span.Meta[parentEndpointKey] = parent.endpoint
+	}
+}
+
+// lookup returns the potential parents published for the given trace.
+func (s *TraceReconstructionService) lookup(traceID uint64) ([]remoteParent, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+	fields, err := redis.StringMap(conn.Do("HGETALL", reconstructionKey(traceID)))
+	if err != nil {
+		return nil, err
+	}
+	parents := make([]remoteParent, 0, len(fields))
+	for field, value := range fields {
+		if p, ok := decodeRemoteParent(field, value); ok {
+			parents = append(parents, p)
+		}
+	}
+	return parents, nil
+}
+
+// findRemoteParent returns the parent of the orphaned span among the published
+// spans of its trace, ignoring the local spans. A span with a parent ID has the
+// published span with this ID as parent. A span without parent ID, the root of
+// a fragment which lost its parent's ID, has the shortest published span
+// enclosing it in time as parent.
+func findRemoteParent(span *pb.Span, parents []remoteParent, local map[uint64]struct{}) (remoteParent, bool) {
+	var (
+		best  remoteParent
+		found bool
+	)
+	for _, p := range parents {
+		if _, ok := local[p.spanID]; ok {
+			continue
+		}
+		if span.ParentID != 0 {
+			if p.spanID == span.ParentID {
+				return p, true
+			}
+			continue
+		}
+		if p.start > span.Start || p.end < span.Start+span.Duration {
+			continue
+		}
+		if !found || p.end-p.start < best.end-best.start {
+			best, found = p, true
+		}
+	}
+	return best, found
+}
+
+// Publish queues for publication the spans of t which may have remote children:
+// the spans without local children. The trace is not published if the queue is full.
+func (s *TraceReconstructionService) Publish(t pb.Trace) {
+	hasChildren := make(map[uint64]struct{}, len(t))
+	for _, span := range t {
+		hasChildren[span.ParentID] = struct{}{}
+	}
+	p := publication{traceID: t[0].TraceID}
+	for _, span := range t {
+		if _, ok := hasChildren[span.SpanID]; ok {
+			continue
+		}
+		p.parents = append(p.parents, remoteParent{
+			spanID:   span.SpanID,
+			service:  span.Service,
+			endpoint: span.Resource,
+			start:    span.Start,
+			end:      span.Start + span.Duration,
+		})
+	}
+	select {
+	case s.in <- p:
+	default:
+		metrics.Count("datadog.trace_agent.reconstruction.dropped", 1, nil, 1)
+	}
+}
+
+// reconstructionKey returns the Redis key of the given trace.
+func reconstructionKey(traceID uint64) string {
+	return reconstructionKeyPrefix + strconv.FormatUint(traceID, 10)
+}
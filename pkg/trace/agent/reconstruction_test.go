@@ -0,0 +1,279 @@
+package agent
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockRedis is an in-memory Redis server supporting the hash commands used by
+// the reconstruction service.
+type mockRedis struct {
+	ln net.Listener
+
+	mu      sync.Mutex
+	hashes  map[string]map[string]string
+	expires map[string]time.Time
+	lookups int // HGETALL calls
+}
+
+func newMockRedis(t *testing.T) *mockRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	r := &mockRedis{ln: ln, hashes: make(map[string]map[string]string), expires: make(map[string]time.Time)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+func (r *mockRedis) Close() { r.ln.Close() }
+
+// lookupCount returns the number of HGETALL calls received.
+func (r *mockRedis) lookupCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lookups
+}
+
+// fields returns the number of fields of the hash stored at key.
+func (r *mockRedis) fields(key string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.hashes[key])
+}
+
+func (r *mockRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	for {
+		cmd, err := readMockRedisCommand(rd)
+		if err != nil {
+			return
+		}
+		fmt.Fprint(conn, r.exec(cmd))
+	}
+}
+
+func (r *mockRedis) exec(cmd []string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case strings.EqualFold(cmd[0], "HGETALL") && len(cmd) == 2:
+		r.lookups++
+		h := r.hashes[cmd[1]]
+		if time.Now().After(r.expires[cmd[1]]) {
+			h = nil
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "*%d\r\n", 2*len(h))
+		for k, v := range h {
+			fmt.Fprintf(&b, "$%d\r\n%s\r\n$%d\r\n%s\r\n", len(k), k, len(v), v)
+		}
+		return b.String()
+	case strings.EqualFold(cmd[0], "HMSET") && len(cmd) >= 4 && len(cmd)%2 == 0:
+		h, ok := r.hashes[cmd[1]]
+		if !ok {
+			h = make(map[string]string)
+			r.hashes[cmd[1]] = h
+		}
+		for i := 2; i < len(cmd); i += 2 {
+			h[cmd[i]] = cmd[i+1]
+		}
+		return "+OK\r\n"
+	case strings.EqualFold(cmd[0], "PEXPIRE") && len(cmd) == 3:
+		ms, err := strconv.Atoi(cmd[2])
+		if err != nil {
+			return "-ERR value is not an integer\r\n"
+		}
+		r.expires[cmd[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		return ":1\r\n"
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func readMockRedisCommand(rd *bufio.Reader) ([]string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(line, "*"), "\r\n"))
+	if err != nil {
+		return nil, err
+	}
+	cmd := make([]string, n)
+	for i := range cmd {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(line, "$"), "\r\n"))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		cmd[i] = string(buf[:size])
+	}
+	return cmd, nil
+}
+
+func newTestReconstructionService(addr string, ttl time.Duration, relinkRoots bool, next func(pb.Trace)) *TraceReconstructionService {
+	cfg := config.New()
+	cfg.Reconstruction.RedisAddr = addr
+	cfg.Reconstruction.TTL = ttl
+	cfg.Reconstruction.RelinkRoots = relinkRoots
+	return NewTraceReconstructionService(cfg, next)
+}
+
+// publishNow publishes t with a new service sharing the given Redis.
+func publishNow(addr string, ttl time.Duration, t pb.Trace) {
+	s := newTestReconstructionService(addr, ttl, false, nil)
+	s.Start()
+	s.Publish(t)
+	s.Stop()
+}
+
+func TestTraceReconstructionService(t *testing.T) {
+	redis := newMockRedis(t)
+	defer redis.Close()
+	addr := redis.ln.Addr().String()
+	agent2 := newTestReconstructionService(addr, time.Minute, false, nil)
+	defer agent2.pool.Close()
+
+	// service A, traced by agent-1, calls service B, traced by agent-2
+	traceA := pb.Trace{
+		{TraceID: 1, SpanID: 1, Service: "a", Resource: "GET /a", Start: 0, Duration: 100},
+		{TraceID: 1, SpanID: 2, ParentID: 1, Service: "a", Resource: "GET /b", Start: 10, Duration: 50},
+		{TraceID: 1, SpanID: 3, ParentID: 1, Service: "a-db", Resource: "SELECT", Start: 70, Duration: 20},
+	}
+	publishNow(addr, time.Minute, traceA)
+	assert.Equal(t, 2, redis.fields(reconstructionKey(1)), "only spans without local children are published")
+
+	t.Run("parent", func(t *testing.T) {
+		traceB := pb.Trace{
+			{TraceID: 1, SpanID: 4, ParentID: 2, Service: "b", Resource: "GET /b", Start: 20, Duration: 30},
+			{TraceID: 1, SpanID: 5, ParentID: 4, Service: "b", Resource: "compute", Start: 25, Duration: 10},
+		}
+		agent2.Reconstruct(traceB)
+		assert.EqualValues(t, 2, traceB[0].ParentID)
+		assert.Equal(t, "a", traceB[0].Meta[parentServiceKey])
+		assert.Equal(t, "GET /b", traceB[0].Meta[parentEndpointKey])
+		assert.Empty(t, traceB[0].Meta[relinkedKey])
+		assert.Empty(t, traceB[1].Meta, "spans with a local parent are left untouched")
+	})
+
+	t.Run("relink", func(t *testing.T) {
+		// the parent ID was lost between A and B
+		traceB := pb.Trace{
+			{TraceID: 1, SpanID: 4, Service: "b", Resource: "GET /b", Start: 20, Duration: 30},
+			{TraceID: 1, SpanID: 5, ParentID: 4, Service: "b", Resource: "compute", Start: 25, Duration: 10},
+		}
+		agent2.Reconstruct(traceB)
+		assert.EqualValues(t, 0, traceB[0].ParentID, "roots are not re-linked by default")
+		assert.Empty(t, traceB[0].Meta)
+
+		relinking := newTestReconstructionService(addr, time.Minute, true, nil)
+		defer relinking.pool.Close()
+		relinking.Reconstruct(traceB)
+		assert.EqualValues(t, 2, traceB[0].ParentID, "the root is re-linked to the remote span enclosing it")
+		assert.Equal(t, "true", traceB[0].Meta[relinkedKey])
+		assert.Equal(t, "GET /b", traceB[0].Meta[parentEndpointKey])
+		assert.EqualValues(t, 4, traceB[1].ParentID)
+	})
+
+	t.Run("root", func(t *testing.T) {
+		// the remote spans don't enclose the real root of a trace
+		root := pb.Trace{{TraceID: 1, SpanID: 1, Service: "a", Resource: "GET /a", Start: 0, Duration: 100}}
+		lookups := redis.lookupCount()
+		agent2.Reconstruct(root)
+		assert.EqualValues(t, 0, root[0].ParentID)
+		assert.Empty(t, root[0].Meta)
+		assert.Equal(t, lookups, redis.lookupCount(), "roots are not looked up by default")
+
+		relinking := newTestReconstructionService(addr, time.Minute, true, nil)
+		defer relinking.pool.Close()
+		relinking.Reconstruct(root)
+		assert.EqualValues(t, 0, root[0].ParentID)
+		assert.Empty(t, root[0].Meta)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		orphan := pb.Trace{{TraceID: 2, SpanID: 6, ParentID: 7, Service: "c"}}
+		agent2.Reconstruct(orphan)
+		assert.EqualValues(t, 7, orphan[0].ParentID)
+		assert.Empty(t, orphan[0].Meta)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		publishNow(addr, time.Millisecond, pb.Trace{{TraceID: 3, SpanID: 8, Service: "d", Resource: "GET /d"}})
+		time.Sleep(5 * time.Millisecond)
+		orphan := pb.Trace{{TraceID: 3, SpanID: 9, ParentID: 8, Service: "e"}}
+		agent2.Reconstruct(orphan)
+		assert.Empty(t, orphan[0].Meta)
+	})
+
+	t.Run("unavailable", func(t *testing.T) {
+		s := newTestReconstructionService("127.0.0.1:0", time.Minute, false, nil)
+		s.Start()
+		s.Publish(traceA)
+		orphan := pb.Trace{{TraceID: 1, SpanID: 4, ParentID: 2, Service: "b"}}
+		s.Reconstruct(orphan)
+		assert.Empty(t, orphan[0].Meta)
+		assert.True(t, s.retryAt > time.Now().UnixNano(), "lookups back off while Redis is unavailable")
+		s.Stop()
+	})
+}
+
+func TestTraceReconstructionServiceAdd(t *testing.T) {
+	redis := newMockRedis(t)
+	defer redis.Close()
+	addr := redis.ln.Addr().String()
+	publishNow(addr, time.Minute, pb.Trace{{TraceID: 1, SpanID: 2, Service: "a", Resource: "GET /b"}})
+
+	out := make(chan pb.Trace, 10)
+	s := newTestReconstructionService(addr, time.Minute, false, func(t pb.Trace) { out <- t })
+	s.Start()
+	defer s.Stop()
+
+	// traces without orphans are passed on right away, without lookup
+	root := pb.Trace{{TraceID: 1, SpanID: 4, Service: "b"}}
+	s.Add(root)
+	select {
+	case got := <-out:
+		assert.EqualValues(t, 4, got[0].SpanID)
+	default:
+		t.Fatal("the trace should be passed on right away")
+	}
+	assert.Equal(t, 0, redis.lookupCount())
+
+	// orphans are looked up in the background
+	orphan := pb.Trace{{TraceID: 1, SpanID: 4, ParentID: 2, Service: "b"}}
+	s.Add(orphan)
+	select {
+	case got := <-out:
+		assert.Equal(t, "a", got[0].Meta[parentServiceKey])
+	case <-time.After(time.Second):
+		t.Fatal("the trace was not passed on")
+	}
+	assert.Equal(t, 1, redis.lookupCount())
+}
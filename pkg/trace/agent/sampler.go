@@ -29,30 +29,76 @@ type Sampler struct {
 
 // NewScoreSampler creates a new empty sampler ready to be started
 func NewScoreSampler(conf *config.AgentConfig) *Sampler {
+	if ab := conf.Sampler.ABTest; ab.Enabled {
+		// both groups share the max TPS according to their share of the traffic
+		control := newScoreEngine(conf, conf.MaxTPS*(1-ab.TreatmentFraction))
+		engine, err := sampler.NewSamplerABTest(control, ab.Algorithm, ab.TreatmentFraction, conf.ExtraSampleRate, conf.MaxTPS*ab.TreatmentFraction)
+		if err == nil {
+			return &Sampler{
+				engine: safeEngine(conf, engine),
+				exit:   make(chan struct{}),
+			}
+		}
+		log.Errorf("Invalid sampler A/B test, disabling it: %v", err)
+	}
 	return &Sampler{
-		engine: sampler.NewScoreEngine(conf.ExtraSampleRate, conf.MaxTPS),
+		engine: safeEngine(conf, newScoreEngine(conf, conf.MaxTPS)),
 		exit:   make(chan struct{}),
 	}
 }
 
+// newScoreEngine returns the engine of the score sampler, keeping at most
+// maxTPS traces per second.
+func newScoreEngine(conf *config.AgentConfig, maxTPS float64) sampler.Engine {
+	if ensemble := conf.Sampler.Ensemble; len(ensemble.Algorithms) > 0 {
+		engine, err := sampler.NewEnsembleSampler(ensemble.Algorithms, ensemble.Weights, conf.ExtraSampleRate, maxTPS)
+		if err == nil {
+			return engine
+		}
+		log.Errorf("Invalid sampler ensemble, falling back to the score sampler: %v", err)
+	}
+	engine := sampler.NewScoreEngine(conf.ExtraSampleRate, maxTPS)
+	if conf.Sampler.UseBudgetDistribution {
+		engine.UseBudgetDistribution()
+	}
+	if conf.Sampler.InverseFrequency {
+		engine.UseInverseFrequency()
+	}
+	if conf.Sampler.MaxRateChangePerSecond > 0 {
+		engine.UseOscillationDamper(conf.Sampler.MaxRateChangePerSecond, conf.Sampler.DampingFactor)
+	}
+	return engine
+}
+
 // NewErrorsSampler creates a new sampler dedicated to traces containing errors
 // to isolate them from the global max tps. It behaves exactly like the normal
 // ScoreSampler except that its statistics are reported under a different name.
 func NewErrorsSampler(conf *config.AgentConfig) *Sampler {
 	return &Sampler{
-		engine: sampler.NewErrorsEngine(conf.ExtraSampleRate, conf.MaxTPS),
+		engine: safeEngine(conf, sampler.NewErrorsEngine(conf.ExtraSampleRate, conf.MaxTPS)),
 		exit:   make(chan struct{}),
 	}
 }
 
 // NewPrioritySampler creates a new empty distributed sampler ready to be started
 func NewPrioritySampler(conf *config.AgentConfig, dynConf *sampler.DynamicConfig) *Sampler {
+	engine := sampler.NewPriorityEngine(conf.ExtraSampleRate, conf.MaxTPS, &dynConf.RateByService)
+	engine.ReportTraffic(&dynConf.Traffic)
 	return &Sampler{
-		engine: sampler.NewPriorityEngine(conf.ExtraSampleRate, conf.MaxTPS, &dynConf.RateByService),
+		engine: safeEngine(conf, engine),
 		exit:   make(chan struct{}),
 	}
 }
 
+// safeEngine wraps engine so that it is disabled after repeated panics, unless
+// disabled by the configuration.
+func safeEngine(conf *config.AgentConfig, engine sampler.Engine) sampler.Engine {
+	if conf.Sampler.MaxPanicsBeforeDisable <= 0 {
+		return engine
+	}
+	return sampler.NewSafeSampler(engine, conf.Sampler.MaxPanicsBeforeDisable)
+}
+
 // Start starts sampling traces
 func (s *Sampler) Start() {
 	go func() {
@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+const (
+	// spanConfigPrefix prefixes the root span tags overriding the configuration.
+	spanConfigPrefix = "_dd.config."
+
+	// spanConfigMaxEPSKey is the root span tag overriding the max EPS of its service.
+	spanConfigMaxEPSKey = spanConfigPrefix + "max_eps"
+
+	// maxSpanConfigOverrides is the maximum number of services overriding the
+	// configuration at once, so that spans can't make the agent grow unbounded.
+	maxSpanConfigOverrides = 1000
+)
+
+// spanConfigOverride holds the configuration overridden by a service.
+type spanConfigOverride struct {
+	maxEPS  float64
+	expires time.Time
+}
+
+// SpanConfigOverrideReader reads the configuration overridden by services
+// through "_dd.config.*" tags on their root spans. An override applies to the
+// service of the root span until it is not seen for the configured TTL.
+//
+// Only "_dd.config.max_eps" is supported, overriding the max events per second
+// of the service. Its value must be positive and at most the configured limit,
+// which never exceeds the global max events per second.
+type SpanConfigOverrideReader struct {
+	ttl         time.Duration
+	maxEPSLimit float64
+
+	mu        sync.RWMutex
+	overrides map[string]spanConfigOverride // by service
+}
+
+// NewSpanConfigOverrideReader returns a new SpanConfigOverrideReader using the
+// TTL and limits configured in conf.
+func NewSpanConfigOverrideReader(conf *config.AgentConfig) *SpanConfigOverrideReader {
+	limit := conf.SpanConfig.MaxEPSLimit
+	if limit <= 0 || limit > conf.MaxEPS {
+		limit = conf.MaxEPS
+	}
+	return &SpanConfigOverrideReader{
+		ttl:         conf.SpanConfig.TTL,
+		maxEPSLimit: limit,
+		overrides:   make(map[string]spanConfigOverride),
+	}
+}
+
+// Read records the overrides found in the tags of root, at time now. Invalid
+// overrides are ignored and counted as datadog.trace_agent.span_config.rejected.
+func (r *SpanConfigOverrideReader) Read(root *pb.Span, now time.Time) {
+	for k, v := range root.Meta {
+		if !strings.HasPrefix(k, spanConfigPrefix) {
+			continue
+		}
+		if k != spanConfigMaxEPSKey {
+			r.reject(root.Service, k, "unknown key")
+			continue
+		}
+		eps, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(eps) || math.IsInf(eps, 0) || eps <= 0 || eps > r.maxEPSLimit {
+			r.reject(root.Service, k, "invalid value "+strconv.Quote(v))
+			continue
+		}
+		if !r.set(root.Service, eps, now) {
+			r.reject(root.Service, k, "too many overrides")
+		}
+	}
+}
+
+// set overrides the max EPS of service. It returns false if the maximum number
+// of overrides is reached.
+func (r *SpanConfigOverrideReader) set(service string, eps float64, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.overrides[service]; !ok && len(r.overrides) >= maxSpanConfigOverrides {
+		for s, o := range r.overrides {
+			if now.After(o.expires) {
+				delete(r.overrides, s)
+			}
+		}
+		if len(r.overrides) >= maxSpanConfigOverrides {
+			return false
+		}
+	}
+	r.overrides[service] = spanConfigOverride{maxEPS: eps, expires: now.Add(r.ttl)}
+	return true
+}
+
+func (r *SpanConfigOverrideReader) reject(service, key, reason string) {
+	log.Debugf("Rejected configuration override %s of service %q: %s", key, service, reason)
+	metrics.Count("datadog.trace_agent.span_config.rejected", 1, []string{"key:" + key}, 1)
+}
+
+// MaxEPS implements event.MaxEPSOverrides.
+func (r *SpanConfigOverrideReader) MaxEPS(service string) (float64, bool) {
+	return r.maxEPS(service, time.Now())
+}
+
+func (r *SpanConfigOverrideReader) maxEPS(service string, now time.Time) (float64, bool) {
+	r.mu.RLock()
+	o, ok := r.overrides[service]
+	r.mu.RUnlock()
+	if !ok || now.After(o.expires) {
+		return 0, false
+	}
+	return o.maxEPS, true
+}
@@ -0,0 +1,90 @@
+package agent
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/test/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpanConfigOverrideReader(t *testing.T) {
+	assert := assert.New(t)
+	stats := &testutil.TestStatsClient{}
+	defer func(old metrics.StatsClient) { metrics.Client = old }(metrics.Client)
+	metrics.Client = stats
+
+	conf := config.New()
+	r := NewSpanConfigOverrideReader(conf)
+	now := time.Now()
+	read := func(service string, meta map[string]string) {
+		r.Read(&pb.Span{Service: service, Meta: meta}, now)
+	}
+
+	read("checkout", map[string]string{"_dd.config.max_eps": "50", "env": "prod"})
+	eps, ok := r.maxEPS("checkout", now)
+	assert.True(ok)
+	assert.Equal(50.0, eps)
+	_, ok = r.maxEPS("web", now)
+	assert.False(ok)
+	assert.Empty(stats.CountCalls)
+
+	// the override expires unless seen again
+	_, ok = r.maxEPS("checkout", now.Add(4*time.Minute))
+	assert.True(ok)
+	_, ok = r.maxEPS("checkout", now.Add(6*time.Minute))
+	assert.False(ok)
+	now = now.Add(4 * time.Minute)
+	read("checkout", map[string]string{"_dd.config.max_eps": "20"})
+	eps, ok = r.maxEPS("checkout", now.Add(4*time.Minute))
+	assert.True(ok)
+	assert.Equal(20.0, eps)
+
+	// abusive and unknown overrides are rejected
+	for _, v := range []string{"0", "-5", "NaN", "+Inf", "1e9", "many", "201"} {
+		read("web", map[string]string{"_dd.config.max_eps": v})
+	}
+	read("web", map[string]string{"_dd.config.max_tps": "10"})
+	_, ok = r.maxEPS("web", now)
+	assert.False(ok)
+	if assert.Len(stats.CountCalls, 8) {
+		assert.Equal("datadog.trace_agent.span_config.rejected", stats.CountCalls[0].Name)
+		assert.Equal([]string{"key:_dd.config.max_tps"}, stats.CountCalls[7].Tags)
+	}
+}
+
+func TestSpanConfigOverrideReaderMaxEPSLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.New()
+	assert.Equal(conf.MaxEPS, NewSpanConfigOverrideReader(conf).maxEPSLimit, "the global max EPS by default")
+	conf.SpanConfig.MaxEPSLimit = 50
+	assert.Equal(50.0, NewSpanConfigOverrideReader(conf).maxEPSLimit)
+	conf.SpanConfig.MaxEPSLimit = 1000
+	assert.Equal(conf.MaxEPS, NewSpanConfigOverrideReader(conf).maxEPSLimit, "overrides can't raise the max EPS")
+}
+
+func TestSpanConfigOverrideReaderLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	r := NewSpanConfigOverrideReader(config.New())
+	now := time.Now()
+	for i := 0; i < maxSpanConfigOverrides+1; i++ {
+		r.Read(&pb.Span{
+			Service: "service-" + strconv.Itoa(i),
+			Meta:    map[string]string{"_dd.config.max_eps": "10"},
+		}, now)
+	}
+	assert.Len(r.overrides, maxSpanConfigOverrides)
+
+	// expired overrides make room for new ones
+	now = now.Add(10 * time.Minute)
+	r.Read(&pb.Span{Service: "new", Meta: map[string]string{"_dd.config.max_eps": "10"}}, now)
+	_, ok := r.maxEPS("new", now)
+	assert.True(ok)
+	assert.Len(r.overrides, 1)
+}
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"errors"
+	"math"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+const (
+	// importanceMetricKey is the metric holding the importance of a span, from
+	// 0 to 1, predicted by the span importance model.
+	importanceMetricKey = "_dd.ai_importance"
+
+	// importanceFeatures is the number of features describing a span.
+	importanceFeatures = 4
+)
+
+// importanceModel predicts the importance of spans from their features.
+type importanceModel interface {
+	// Predict returns the importance of n spans, given their features as the
+	// rows of a n×importanceFeatures matrix.
+	Predict(features []float32, n int) ([]float32, error)
+}
+
+// loadImportanceModel loads the span importance model found at a path. It is
+// nil when the agent is built without the onnx tag.
+var loadImportanceModel func(path string) (importanceModel, error)
+
+// SpanImportanceScorer annotates spans with their importance, predicted by a
+// small neural network from their duration, whether they are errors, their
+// depth in the trace and the entropy of the services of the trace. The model is
+// an ONNX file run by the ONNX runtime, which the agent loads when built with
+// the onnx tag.
+type SpanImportanceScorer struct {
+	model importanceModel
+}
+
+// NewSpanImportanceScorer returns a new SpanImportanceScorer running the ONNX
+// model found at path.
+func NewSpanImportanceScorer(path string) (*SpanImportanceScorer, error) {
+	if loadImportanceModel == nil {
+		return nil, errors.New("the agent was built without ONNX support")
+	}
+	model, err := loadImportanceModel(path)
+	if err != nil {
+		return nil, err
+	}
+	return &SpanImportanceScorer{model: model}, nil
+}
+
+// Score sets the importance of each span of t as its "_dd.ai_importance"
+// metric. Spans are left untouched when the model fails.
+func (s *SpanImportanceScorer) Score(t pb.Trace) {
+	if len(t) == 0 {
+		return
+	}
+	scores, err := s.model.Predict(spanFeatures(t), len(t))
+	if err == nil && len(scores) != len(t) {
+		err = errors.New("wrong number of predictions")
+	}
+	if err != nil {
+		log.Debugf("Error predicting the importance of spans: %v", err)
+		metrics.Count("datadog.trace_agent.span_importance.errors", 1, nil, 1)
+		return
+	}
+	for i, span := range t {
+		score := float64(scores[i])
+		if math.IsNaN(score) {
+			continue
+		}
+		if span.Metrics == nil {
+			span.Metrics = make(map[string]float64)
+		}
+		span.Metrics[importanceMetricKey] = math.Max(0, math.Min(1, score))
+	}
+}
+
+// spanFeatures returns the features of the spans of t as the rows of a matrix:
+// the logarithm of their duration in milliseconds, 1 for errors and 0
+// otherwise, their depth, 0 for roots, and the entropy of the services of t, in
+// bits.
+func spanFeatures(t pb.Trace) []float32 {
+	parents := make(map[uint64]uint64, len(t))
+	services := make(map[string]float64)
+	for _, s := range t {
+		parents[s.SpanID] = s.ParentID
+		services[s.Service]++
+	}
+	var entropy float64
+	for _, n := range services {
+		p := n / float64(len(t))
+		entropy -= p * math.Log2(p)
+	}
+
+	features := make([]float32, 0, len(t)*importanceFeatures)
+	for _, s := range t {
+		var isError float32
+		if s.Error != 0 {
+			isError = 1
+		}
+		// the depth is bounded by the number of spans in case of cycles
+		depth := 0
+		for id := s.ParentID; depth < len(t); depth++ {
+			parent, ok := parents[id]
+			if !ok {
+				break
+			}
+			id = parent
+		}
+		features = append(features,
+			float32(math.Log1p(math.Max(0, float64(s.Duration)/1e6))),
+			isError,
+			float32(depth),
+			float32(entropy),
+		)
+	}
+	return features
+}
@@ -0,0 +1,66 @@
+// +build onnx
+
+package agent
+
+import (
+	"fmt"
+	"sync"
+
+	ort "github.com/yalue/onnxruntime_go"
+)
+
+// onnxRuntimeLibrary is the shared library of the ONNX runtime, loaded with the
+// first model.
+const onnxRuntimeLibrary = "libonnxruntime.so"
+
+var (
+	onnxInit    sync.Once
+	onnxInitErr error
+)
+
+func init() {
+	loadImportanceModel = loadONNXImportanceModel
+}
+
+// onnxImportanceModel is a span importance model run by the ONNX runtime. The
+// model takes the features of the spans as a float tensor named "features", of
+// shape [spans, 4], and returns their importance as a float tensor named
+// "importance", of shape [spans, 1].
+type onnxImportanceModel struct {
+	session *ort.DynamicAdvancedSession
+}
+
+// loadONNXImportanceModel loads the ONNX model found at path, loading the ONNX
+// runtime first if needed.
+func loadONNXImportanceModel(path string) (importanceModel, error) {
+	onnxInit.Do(func() {
+		ort.SetSharedLibraryPath(onnxRuntimeLibrary)
+		onnxInitErr = ort.InitializeEnvironment()
+	})
+	if onnxInitErr != nil {
+		return nil, fmt.Errorf("could not load the ONNX runtime: %v", onnxInitErr)
+	}
+	session, err := ort.NewDynamicAdvancedSession(path, []string{"features"}, []string{"importance"}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &onnxImportanceModel{session: session}, nil
+}
+
+// Predict implements importanceModel.
+func (m *onnxImportanceModel) Predict(features []float32, n int) ([]float32, error) {
+	input, err := ort.NewTensor(ort.NewShape(int64(n), importanceFeatures), features)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Destroy()
+	output, err := ort.NewTensor(ort.NewShape(int64(n), 1), make([]float32, n))
+	if err != nil {
+		return nil, err
+	}
+	defer output.Destroy()
+	if err := m.session.Run([]ort.Value{input}, []ort.Value{output}); err != nil {
+		return nil, err
+	}
+	return output.GetData(), nil
+}
@@ -0,0 +1,29 @@
+// +build onnx
+
+package agent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestONNXImportanceModel(t *testing.T) {
+	model, err := loadONNXImportanceModel("testdata/span_importance.onnx")
+	if err != nil && strings.HasPrefix(err.Error(), "could not load the ONNX runtime") {
+		t.Skip("the ONNX runtime is not available")
+	}
+	require.NoError(t, err)
+
+	// the model predicts the importance of spans like its pre-trained stub
+	features := spanFeatures(testImportanceTrace())
+	scores, err := model.Predict(features, 4)
+	require.NoError(t, err)
+	want, _ := (&testImportanceModel{}).Predict(features, 4)
+	assert.InDeltaSlice(t, want, scores, 1e-5)
+
+	_, err = loadONNXImportanceModel("testdata/missing.onnx")
+	assert.Error(t, err)
+}
@@ -0,0 +1,165 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/sampler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testImportanceModel is a pre-trained span importance model: a logistic
+// regression of the features of spans, with the weights of the model of
+// testdata/span_importance.onnx.
+type testImportanceModel struct {
+	scores []float32 // returned instead of the predictions when set
+	err    error
+}
+
+var (
+	testImportanceWeights = [importanceFeatures]float64{0.5, 2, -0.25, 0.5}
+	testImportanceBias    = -2.0
+)
+
+// Predict implements importanceModel.
+func (m *testImportanceModel) Predict(features []float32, n int) ([]float32, error) {
+	if m.err != nil || m.scores != nil {
+		return m.scores, m.err
+	}
+	scores := make([]float32, n)
+	for i := range scores {
+		z := testImportanceBias
+		for j, w := range testImportanceWeights {
+			z += w * float64(features[i*importanceFeatures+j])
+		}
+		scores[i] = float32(1 / (1 + math.Exp(-z)))
+	}
+	return scores, nil
+}
+
+// useTestImportanceModel makes the agent load m as its span importance model,
+// and returns a function restoring the loader.
+func useTestImportanceModel(m importanceModel) func() {
+	old := loadImportanceModel
+	loadImportanceModel = func(string) (importanceModel, error) { return m, nil }
+	return func() { loadImportanceModel = old }
+}
+
+// testImportanceTrace returns a trace of web calling db twice, the second call
+// failing after a second.
+func testImportanceTrace() pb.Trace {
+	return pb.Trace{
+		{TraceID: 1, SpanID: 1, Service: "web", Duration: int64(1200 * time.Millisecond)},
+		{TraceID: 1, SpanID: 2, ParentID: 1, Service: "db", Duration: int64(time.Millisecond)},
+		{TraceID: 1, SpanID: 3, ParentID: 1, Service: "db", Duration: int64(time.Second), Error: 1},
+		{TraceID: 1, SpanID: 4, ParentID: 3, Service: "db", Duration: int64(999 * time.Millisecond)},
+	}
+}
+
+func TestSpanFeatures(t *testing.T) {
+	features := spanFeatures(testImportanceTrace())
+	require.Len(t, features, 4*importanceFeatures)
+	entropy := -(0.25*math.Log2(0.25) + 0.75*math.Log2(0.75))
+	for i, want := range [][importanceFeatures]float64{
+		{math.Log1p(1200), 0, 0, entropy},
+		{math.Log1p(1), 0, 1, entropy},
+		{math.Log1p(1000), 1, 1, entropy},
+		{math.Log1p(999), 0, 2, entropy},
+	} {
+		for j := range want {
+			assert.InDelta(t, want[j], features[i*importanceFeatures+j], 1e-5, "span %d, feature %d", i, j)
+		}
+	}
+
+	// cycles don't make the depth unbounded
+	features = spanFeatures(pb.Trace{{SpanID: 1, ParentID: 2}, {SpanID: 2, ParentID: 1}})
+	assert.Equal(t, float32(2), features[2])
+}
+
+func TestSpanImportanceScorer(t *testing.T) {
+	assert := assert.New(t)
+	model := &testImportanceModel{}
+	defer useTestImportanceModel(model)()
+	s, err := NewSpanImportanceScorer("model.onnx")
+	require.NoError(t, err)
+
+	trace := testImportanceTrace()
+	s.Score(trace)
+	for _, span := range trace {
+		assert.Contains(span.Metrics, importanceMetricKey)
+	}
+	// the failing call is the most important span
+	assert.True(trace[2].Metrics[importanceMetricKey] > trace[0].Metrics[importanceMetricKey])
+	assert.True(trace[0].Metrics[importanceMetricKey] > trace[1].Metrics[importanceMetricKey])
+
+	// scores are kept between 0 and 1
+	model.scores = []float32{1.5, -0.5, float32(math.NaN())}
+	trace = pb.Trace{{SpanID: 1}, {SpanID: 2}, {SpanID: 3}}
+	s.Score(trace)
+	assert.Equal(1.0, trace[0].Metrics[importanceMetricKey])
+	assert.Equal(0.0, trace[1].Metrics[importanceMetricKey])
+	assert.Nil(trace[2].Metrics)
+
+	// spans are left untouched when the model fails
+	model.scores, model.err = nil, errors.New("model failure")
+	trace = testImportanceTrace()
+	s.Score(trace)
+	model.scores, model.err = []float32{1}, nil
+	s.Score(trace)
+	for _, span := range trace {
+		assert.Nil(span.Metrics)
+	}
+	s.Score(pb.Trace{})
+}
+
+func TestNewSpanImportanceScorer(t *testing.T) {
+	defer func(old func(string) (importanceModel, error)) { loadImportanceModel = old }(loadImportanceModel)
+	loadImportanceModel = nil
+	_, err := NewSpanImportanceScorer("model.onnx")
+	assert.EqualError(t, err, "the agent was built without ONNX support")
+
+	loadImportanceModel = func(string) (importanceModel, error) { return nil, errors.New("invalid model") }
+	_, err = NewSpanImportanceScorer("model.onnx")
+	assert.EqualError(t, err, "invalid model")
+}
+
+func TestSpanImportanceAgent(t *testing.T) {
+	defer useTestImportanceModel(&testImportanceModel{})()
+	cfg := config.New()
+	cfg.Endpoints[0].APIKey = "test"
+	cfg.Debug.SpanImportanceModel = "model.onnx"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	agnt := NewAgent(ctx, cfg)
+	if !assert.NotNil(t, agnt.importance) || !assert.NotNil(t, agnt.importanceSampler) {
+		return
+	}
+
+	trace := testImportanceTrace()
+	for _, span := range trace {
+		span.Name = "http.request"
+		span.Resource = "GET /"
+		span.Start = time.Now().Add(-2 * time.Second).UnixNano()
+	}
+	sampler.SetSamplingPriority(trace[0], sampler.PriorityUserKeep)
+	agnt.Process(trace)
+	select {
+	case ss := <-agnt.spansOut:
+		if assert.Len(t, ss.Trace, 4) {
+			for _, span := range ss.Trace {
+				assert.Contains(t, span.Metrics, importanceMetricKey)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the trace was not written")
+	}
+
+	cfg.Debug.SpanImportanceModel = ""
+	assert.Nil(t, NewAgent(ctx, cfg).importance)
+}
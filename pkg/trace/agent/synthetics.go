@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+)
+
+const (
+	// originTagKey is the span tag holding the origin of a trace.
+	originTagKey = "_dd.origin"
+
+	// syntheticsOrigin is the origin of the traces generated by synthetic tests.
+	syntheticsOrigin = "synthetics"
+
+	// syntheticsErrorTTL specifies for how long a trace whose test could not be
+	// retrieved is cached as having none.
+	syntheticsErrorTTL = 10 * time.Second
+
+	// syntheticsMaxRPS is the maximum number of requests per second made to the
+	// Synthetics API.
+	syntheticsMaxRPS = 20
+
+	// syntheticsWorkers is the number of goroutines fetching synthetic tests.
+	syntheticsWorkers = 2
+
+	// syntheticsMaxTraces is the maximum number of traces whose test is cached.
+	syntheticsMaxTraces = 100000
+
+	// syntheticsMaxWait is the maximum time a synthetic trace is held waiting
+	// for its test to be fetched. It covers the timeout of a request.
+	syntheticsMaxWait = 2 * backgroundFetchTimeout
+)
+
+// syntheticTest describes the synthetic test a trace was generated by.
+type syntheticTest struct {
+	TestName     string `json:"test_name"`
+	Location     string `json:"location"`
+	DeploymentID string `json:"deployment_id"`
+}
+
+// SyntheticMonitorEnricher tags the spans of the traces generated by synthetic
+// tests, having the "synthetics" origin, with the name, location and deployment
+// targeted by their test, as returned by the Synthetics API. Tests are fetched
+// at most once at a time per trace and within a rate limit, and cached by trace
+// ID, so that the chunks of a trace only result in a single request. Synthetic
+// traces are held until their test is fetched, up to syntheticsMaxWait, since
+// they usually arrive in a single payload. Traces whose test can't be retrieved
+// are cached as having none for a short while.
+type SyntheticMonitorEnricher struct {
+	fetcher *backgroundFetcher
+}
+
+// NewSyntheticMonitorEnricher returns a new SyntheticMonitorEnricher using the
+// Synthetics API configured in conf.
+func NewSyntheticMonitorEnricher(conf *config.AgentConfig) *SyntheticMonitorEnricher {
+	return &SyntheticMonitorEnricher{
+		fetcher: newBackgroundFetcher(fetcherConfig{
+			name:       "synthetics",
+			url:        conf.Synthetics.MetadataEndpoint,
+			param:      "trace_id",
+			decode:     decodeSyntheticTest,
+			ttl:        conf.Synthetics.CacheTTL,
+			errorTTL:   syntheticsErrorTTL,
+			maxRPS:     syntheticsMaxRPS,
+			maxEntries: syntheticsMaxTraces,
+			workers:    syntheticsWorkers,
+		}),
+	}
+}
+
+// Start starts fetching the tests of the traces seen by Enrich.
+func (e *SyntheticMonitorEnricher) Start() {
+	e.fetcher.Start()
+}
+
+// Stop fetches the tests of the queued traces and stops the workers.
+func (e *SyntheticMonitorEnricher) Stop() {
+	e.fetcher.Stop()
+}
+
+// Enrich tags the spans of t with the synthetic test which generated it, if any.
+func (e *SyntheticMonitorEnricher) Enrich(t pb.Trace) {
+	if !isSynthetic(t) {
+		return
+	}
+	test, ok := e.test(t[0].TraceID)
+	if !ok || test == nil {
+		return
+	}
+	for _, s := range t {
+		if s.Meta == nil {
+			s.Meta = make(map[string]string, 3)
+		}
+		if test.TestName != "" {
+			s.Meta["synthetics.test_name"] = test.TestName
+		}
+		if test.Location != "" {
+			s.Meta["synthetics.location"] = test.Location
+		}
+		if test.DeploymentID != "" {
+			s.Meta["synthetics.deployment_id"] = test.DeploymentID
+		}
+	}
+}
+
+// isSynthetic reports whether t was generated by a synthetic test.
+func isSynthetic(t pb.Trace) bool {
+	for _, s := range t {
+		if s.Meta[originTagKey] == syntheticsOrigin {
+			return true
+		}
+	}
+	return false
+}
+
+// test returns the synthetic test of the given trace, waiting up to
+// syntheticsMaxWait for it to be fetched when it is not cached. It returns false
+// if it is still unknown.
+func (e *SyntheticMonitorEnricher) test(traceID uint64) (*syntheticTest, bool) {
+	v, ok := e.fetcher.wait(traceID, syntheticsMaxWait)
+	test, _ := v.(*syntheticTest)
+	return test, ok
+}
+
+// decodeSyntheticTest decodes a synthetic test returned by the Synthetics API.
+func decodeSyntheticTest(r io.Reader) (interface{}, error) {
+	var test syntheticTest
+	if err := json.NewDecoder(r).Decode(&test); err != nil {
+		return nil, fmt.Errorf("error decoding synthetic test: %v", err)
+	}
+	return &test, nil
+}
@@ -0,0 +1,166 @@
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+// newMockSyntheticsAPI returns a Synthetics API knowing traces 1 and 2, along
+// with the number of requests it received.
+func newMockSyntheticsAPI() (*httptest.Server, *int64) {
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		switch r.URL.Query().Get("trace_id") {
+		case "1":
+			w.Write([]byte(`{"test_name": "checkout flow", "location": "aws:eu-west-1", "deployment_id": "v42"}`))
+		case "2":
+			w.Write([]byte(`{"test_name": "login"}`))
+		case "3":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return srv, &hits
+}
+
+func newTestSyntheticMonitorEnricher(url string, ttl time.Duration) *SyntheticMonitorEnricher {
+	cfg := config.New()
+	cfg.Synthetics = &config.SyntheticsConfig{MetadataEndpoint: url, CacheTTL: ttl}
+	return NewSyntheticMonitorEnricher(cfg)
+}
+
+func syntheticTrace(traceID uint64, origin string) pb.Trace {
+	return pb.Trace{
+		{TraceID: traceID, SpanID: 1, Meta: map[string]string{"_dd.origin": origin}},
+		{TraceID: traceID, SpanID: 2, ParentID: 1},
+	}
+}
+
+func TestSyntheticMonitorEnricher(t *testing.T) {
+	srv, hits := newMockSyntheticsAPI()
+	defer srv.Close()
+
+	t.Run("enrich", func(t *testing.T) {
+		atomic.StoreInt64(hits, 0)
+		e := newTestSyntheticMonitorEnricher(srv.URL, time.Minute)
+		e.Start()
+		defer e.Stop()
+		for i := 0; i < 2; i++ {
+			trace := syntheticTrace(1, "synthetics")
+			e.Enrich(trace)
+			for _, s := range trace {
+				assert.Equal(t, "checkout flow", s.Meta["synthetics.test_name"], "the first payload is tagged")
+				assert.Equal(t, "aws:eu-west-1", s.Meta["synthetics.location"])
+				assert.Equal(t, "v42", s.Meta["synthetics.deployment_id"])
+			}
+		}
+
+		trace := syntheticTrace(2, "synthetics")
+		e.Enrich(trace)
+		assert.Equal(t, map[string]string{"synthetics.test_name": "login"}, trace[1].Meta)
+		assert.EqualValues(t, 2, atomic.LoadInt64(hits), "traces are fetched once")
+	})
+
+	t.Run("not synthetic", func(t *testing.T) {
+		atomic.StoreInt64(hits, 0)
+		e := newTestSyntheticMonitorEnricher(srv.URL, time.Minute)
+		for _, trace := range []pb.Trace{syntheticTrace(1, "ciapp-test"), {{TraceID: 1, SpanID: 1}}} {
+			e.Enrich(trace)
+			assert.Empty(t, e.fetcher.queue)
+			for _, s := range trace {
+				assert.NotContains(t, s.Meta, "synthetics.test_name")
+			}
+		}
+		assert.EqualValues(t, 0, atomic.LoadInt64(hits))
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		atomic.StoreInt64(hits, 0)
+		e := newTestSyntheticMonitorEnricher(srv.URL, time.Minute)
+		e.Start()
+		defer e.Stop()
+		for i := 0; i < 2; i++ {
+			trace := syntheticTrace(4, "synthetics")
+			e.Enrich(trace)
+			assert.Equal(t, map[string]string{"_dd.origin": "synthetics"}, trace[0].Meta)
+			assert.Empty(t, trace[1].Meta)
+		}
+		assert.EqualValues(t, 1, atomic.LoadInt64(hits), "unknown traces are cached")
+	})
+
+	t.Run("error", func(t *testing.T) {
+		atomic.StoreInt64(hits, 0)
+		e := newTestSyntheticMonitorEnricher(srv.URL, time.Minute)
+		e.Start()
+		defer e.Stop()
+		for i := 0; i < 2; i++ {
+			trace := syntheticTrace(3, "synthetics")
+			e.Enrich(trace)
+			assert.Empty(t, trace[1].Meta)
+		}
+		assert.EqualValues(t, 1, atomic.LoadInt64(hits), "errors are cached")
+		e.fetcher.mu.Lock()
+		assert.WithinDuration(t, time.Now().Add(syntheticsErrorTTL), e.fetcher.cache[uint64(3)].expires, time.Second, "errors are cached for a short while")
+		e.fetcher.mu.Unlock()
+	})
+
+	t.Run("wait", func(t *testing.T) {
+		e := newTestSyntheticMonitorEnricher(srv.URL, time.Minute)
+		trace := syntheticTrace(1, "synthetics")
+		start := time.Now()
+		e.Enrich(trace)
+		assert.WithinDuration(t, start.Add(syntheticsMaxWait), time.Now(), syntheticsMaxWait/2, "traces are held for a bounded time")
+		assert.Empty(t, trace[1].Meta)
+		assert.Len(t, e.fetcher.queue, 1)
+	})
+
+	t.Run("rate-limit", func(t *testing.T) {
+		atomic.StoreInt64(hits, 0)
+		e := newTestSyntheticMonitorEnricher(srv.URL, time.Minute)
+		for id := uint64(100); id < 100+syntheticsMaxRPS+5; id++ {
+			e.fetcher.get(id)
+		}
+		drainFetchQueue(e.fetcher)
+		assert.EqualValues(t, syntheticsMaxRPS, atomic.LoadInt64(hits))
+		assert.Empty(t, e.fetcher.pending, "throttled traces can be queued again")
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		atomic.StoreInt64(hits, 0)
+		e := newTestSyntheticMonitorEnricher(srv.URL, time.Minute)
+		e.Start()
+		defer e.Stop()
+		_, ok := e.test(1)
+		assert.True(t, ok)
+		assert.EqualValues(t, 1, atomic.LoadInt64(hits))
+
+		e.fetcher.mu.Lock()
+		entry := e.fetcher.cache[uint64(1)]
+		entry.expires = time.Now().Add(-time.Second)
+		e.fetcher.cache[uint64(1)] = entry
+		e.fetcher.sweepAt = time.Time{}
+		e.fetcher.mu.Unlock()
+
+		_, ok = e.test(1)
+		assert.True(t, ok)
+		assert.EqualValues(t, 2, atomic.LoadInt64(hits), "expired tests are fetched again")
+	})
+	t.Run("start", func(t *testing.T) {
+		e := newTestSyntheticMonitorEnricher(srv.URL, time.Minute)
+		e.fetcher.get(uint64(1))
+		e.Start()
+		e.Stop()
+		test, ok := e.fetcher.get(uint64(1))
+		assert.True(t, ok, "queued traces are fetched before stopping")
+		assert.Equal(t, "checkout flow", test.(*syntheticTest).TestName)
+	})
+}
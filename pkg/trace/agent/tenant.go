@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/api"
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/writer"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// tenantWriter is the writer of the spans of a tenant.
+type tenantWriter struct {
+	in chan *writer.SampledSpans
+	w  *writer.TraceWriter
+}
+
+// TenantWriters sends the sampled spans of tenants to the intake of their
+// organization, through a writer per organization created on first use with
+// the API key configured for the organization.
+type TenantWriters struct {
+	conf   *config.AgentConfig
+	router *api.TenantRouter
+
+	mu      sync.Mutex
+	writers map[string]*tenantWriter // by organization
+	stopped bool
+}
+
+// NewTenantWriters returns new writers for the tenants known to router.
+func NewTenantWriters(conf *config.AgentConfig, router *api.TenantRouter) *TenantWriters {
+	return &TenantWriters{
+		conf:    conf,
+		router:  router,
+		writers: make(map[string]*tenantWriter),
+	}
+}
+
+// Send sends ss to the organization orgID. It returns false when the spans
+// could not be routed to the organization, in which case they should be sent
+// to the default writer.
+func (tw *TenantWriters) Send(orgID string, ss *writer.SampledSpans) bool {
+	w, err := tw.writer(orgID)
+	if err != nil {
+		log.Debugf("Could not route spans of organization %q: %v", orgID, err)
+		return false
+	}
+	w.in <- ss
+	metrics.Count("datadog.trace_agent.multitenant.routes", 1, []string{"org_id:" + orgID}, 1)
+	return true
+}
+
+// writer returns the writer of the organization orgID, starting it if needed.
+func (tw *TenantWriters) writer(orgID string) (*tenantWriter, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.stopped {
+		return nil, fmt.Errorf("writers stopped")
+	}
+	if w, ok := tw.writers[orgID]; ok {
+		return w, nil
+	}
+	tenant, ok := tw.router.Tenant(orgID)
+	if !ok {
+		return nil, fmt.Errorf("unknown organization")
+	}
+	if _, err := url.Parse(tenant.Endpoint); err != nil || tenant.Endpoint == "" {
+		return nil, fmt.Errorf("invalid endpoint %q", tenant.Endpoint)
+	}
+	if tenant.APIKey == "" {
+		return nil, fmt.Errorf("no API key configured")
+	}
+	conf := *tw.conf
+	conf.Endpoints = []*config.Endpoint{{Host: tenant.Endpoint, APIKey: tenant.APIKey}}
+	w := &tenantWriter{in: make(chan *writer.SampledSpans, 1000)}
+	w.w = writer.NewTraceWriter(&conf, w.in)
+	go w.w.Run()
+	tw.writers[orgID] = w
+	return w, nil
+}
+
+// Stop flushes and stops all the writers.
+func (tw *TenantWriters) Stop() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.stopped = true
+	for _, w := range tw.writers {
+		w.w.Stop()
+	}
+}
+
+// tenantOf removes the organization tag the receiver set on the spans of t,
+// returning its value.
+func tenantOf(t pb.Trace) string {
+	var orgID string
+	for _, span := range t {
+		if org, ok := span.Meta[api.TenantOrgKey]; ok {
+			orgID = org
+			delete(span.Meta, api.TenantOrgKey)
+		}
+	}
+	return orgID
+}
@@ -0,0 +1,178 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/api"
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/sampler"
+	"github.com/DataDog/datadog-agent/pkg/trace/test/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantWriters(t *testing.T) {
+	assert := assert.New(t)
+	stats := &testutil.TestStatsClient{}
+	defer func(old metrics.StatsClient) { metrics.Client = old }(metrics.Client)
+	metrics.Client = stats
+
+	var (
+		mu       sync.Mutex
+		received = make(map[string][]string) // API keys received by organization
+	)
+	newIntake := func(orgID string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			mu.Lock()
+			received[orgID] = append(received[orgID], req.Header.Get("DD-Api-Key"))
+			mu.Unlock()
+		}))
+	}
+	intakes := map[string]*httptest.Server{
+		"1001": newIntake("1001"),
+		"1002": newIntake("1002"),
+		"1003": newIntake("1003"),
+	}
+	for _, srv := range intakes {
+		defer srv.Close()
+	}
+
+	cfg := config.New()
+	cfg.Endpoints[0].APIKey = "test"
+	cfg.MultiTenant.TenantMapping = []config.TenantMapping{
+		{APIKeyPrefix: "aaaa", OrgID: "1001", Endpoint: intakes["1001"].URL, APIKey: "key1001"},
+		{APIKeyPrefix: "bbbb", OrgID: "1002", Endpoint: intakes["1002"].URL, APIKey: "key1002"},
+		{APIKeyPrefix: "cccc", OrgID: "1003", Endpoint: intakes["1003"].URL, APIKey: "key1003"},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	agnt := NewAgent(ctx, cfg)
+	if !assert.NotNil(agnt.TenantWriters) {
+		return
+	}
+
+	process := func(apiKey string, meta map[string]string) {
+		root := &pb.Span{
+			TraceID:  1,
+			SpanID:   1,
+			Service:  "web",
+			Name:     "http.request",
+			Resource: "GET /",
+			Start:    time.Now().Add(-time.Second).UnixNano(),
+			Duration: (500 * time.Millisecond).Nanoseconds(),
+			Meta:     meta,
+		}
+		sampler.SetSamplingPriority(root, sampler.PriorityUserKeep)
+		traces := pb.Traces{{root}}
+		header := http.Header{}
+		header.Set("DD-API-KEY", apiKey)
+		agnt.Receiver.Tenants.Route(header, traces)
+		agnt.Process(traces[0])
+		assert.NotContains(root.Meta, api.TenantOrgKey)
+	}
+	process("aaaa0001", nil)
+	process("bbbb0002", nil)
+	process("cccc0003", nil)
+	process("cccc0003", nil)
+	process("dddd0004", nil)
+	// traces claiming the organization of another tenant are not routed to it
+	process("dddd0004", map[string]string{api.TenantOrgKey: "1002"})
+	process("", map[string]string{api.TenantOrgKey: "1002"})
+
+	// the traces of unknown tenants go to the default writer
+	for i := 0; i < 3; i++ {
+		ss := <-agnt.spansOut
+		assert.Len(ss.Trace, 1)
+	}
+	assert.Len(agnt.spansOut, 0)
+
+	agnt.TenantWriters.Stop()
+	mu.Lock()
+	assert.Equal(map[string][]string{
+		"1001": {"key1001"},
+		"1002": {"key1002"},
+		"1003": {"key1003"},
+	}, received)
+	mu.Unlock()
+
+	routes := make(map[string]int)
+	for _, c := range stats.CountCalls {
+		if c.Name == "datadog.trace_agent.multitenant.routes" {
+			for _, tag := range c.Tags {
+				routes[tag] += int(c.Value)
+			}
+		}
+	}
+	assert.Equal(map[string]int{"org_id:1001": 1, "org_id:1002": 1, "org_id:1003": 2}, routes)
+}
+
+func TestTenantHighValueEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		mu       sync.Mutex
+		received []string // API keys received by the intake of the tenant
+	)
+	intake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		received = append(received, req.Header.Get("DD-Api-Key"))
+		mu.Unlock()
+	}))
+	defer intake.Close()
+
+	cfg := config.New()
+	cfg.Endpoints[0].APIKey = "test"
+	cfg.MultiTenant.TenantMapping = []config.TenantMapping{
+		{APIKeyPrefix: "aaaa", OrgID: "1001", Endpoint: intake.URL, APIKey: "key1001"},
+	}
+	cfg.AnalyzedEvents.HighValueServices = []string{"checkout"}
+	cfg.AnalyzedEvents.HighValueEndpoint = "https://analytics.example.com"
+	cfg.Synthetics.TraceIDRangeStart = 0xDEAD0000
+	cfg.Synthetics.TraceIDRangeEnd = 0xDEADFFFF
+	cfg.Synthetics.Endpoint = "https://synthetics.example.com"
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	agnt := NewAgent(ctx, cfg)
+	if !assert.NotNil(agnt.TenantWriters) || !assert.NotNil(agnt.HighValueWriter) || !assert.NotNil(agnt.SyntheticsWriter) {
+		return
+	}
+
+	// a trace of the tenant, holding a high value event and whose ID is
+	// reserved to synthetic monitoring
+	now := time.Now()
+	root := &pb.Span{
+		TraceID:  0xDEAD0001,
+		SpanID:   1,
+		Service:  "checkout",
+		Name:     "http.request",
+		Resource: "GET /",
+		Start:    now.Add(-time.Second).UnixNano(),
+		Duration: (500 * time.Millisecond).Nanoseconds(),
+		Metrics:  map[string]float64{sampler.KeySamplingRateEventExtraction: 1},
+	}
+	sampler.SetSamplingPriority(root, sampler.PriorityUserKeep)
+	traces := pb.Traces{{root}}
+	header := http.Header{}
+	header.Set("DD-API-KEY", "aaaa0001")
+	agnt.Receiver.Tenants.Route(header, traces)
+	agnt.Process(traces[0])
+
+	assert.Len(agnt.highValueSpansOut, 0, "the events of tenants are not sent to the high value intake")
+	assert.Len(agnt.syntheticsOut, 0, "the traces of tenants are not sent to the synthetics intake")
+	assert.Len(agnt.spansOut, 0)
+
+	agnt.TenantWriters.Stop()
+	mu.Lock()
+	defer mu.Unlock()
+	if assert.NotEmpty(received) {
+		for _, key := range received {
+			assert.Equal("key1001", key)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/traceutil"
+)
+
+const (
+	// commonTopologyShare is the share of the traces of a service above which
+	// a topology is considered common.
+	commonTopologyShare = 0.1
+	// minCommonTopologyCount is the number of times a topology must have been
+	// seen to be considered common.
+	minCommonTopologyCount = 10
+	// topologyMissingAfter is the delay after which a topology which was not
+	// seen anymore is forgotten, and reported as missing if it was common.
+	topologyMissingAfter = 5 * time.Minute
+	// topologyCheckInterval is the interval at which the topologies of a
+	// service are checked for missing ones.
+	topologyCheckInterval = time.Minute
+	// maxTopologiesPerService is the maximum number of topologies tracked by
+	// service.
+	maxTopologiesPerService = 100
+)
+
+// topologyStats holds how often a topology was seen.
+type topologyStats struct {
+	count    float64
+	lastSeen time.Time
+}
+
+// serviceTopologies holds the topologies of the traces of a service.
+type serviceTopologies struct {
+	byHash      map[uint64]*topologyStats
+	total       float64
+	lastChecked time.Time
+}
+
+// TopologyTracker tracks the topologies of the traces of each service, as
+// given by traceutil.TopologyHash, to detect deployments changing how services
+// call each other. It reports new topologies and common topologies which
+// stopped appearing.
+type TopologyTracker struct {
+	mu        sync.Mutex
+	byService map[string]*serviceTopologies
+}
+
+// NewTopologyTracker returns a new TopologyTracker.
+func NewTopologyTracker() *TopologyTracker {
+	return &TopologyTracker{byService: make(map[string]*serviceTopologies)}
+}
+
+// Observe counts the topology of t for the service of its root. The first
+// time a topology is seen, datadog.trace_agent.new_trace_topology is emitted.
+// Common topologies of the service which were not seen for a while are
+// reported with datadog.trace_agent.topology_missing, then forgotten.
+func (tt *TopologyTracker) Observe(root *pb.Span, t pb.Trace, now time.Time) {
+	hash := traceutil.TopologyHash(t)
+	tags := []string{"service:" + root.Service}
+
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	st, ok := tt.byService[root.Service]
+	if !ok {
+		st = &serviceTopologies{byHash: make(map[uint64]*topologyStats), lastChecked: now}
+		tt.byService[root.Service] = st
+	}
+	if now.Sub(st.lastChecked) >= topologyCheckInterval {
+		st.lastChecked = now
+		tt.expire(st, tags, now)
+	}
+
+	st.total++
+	topology, ok := st.byHash[hash]
+	if !ok {
+		if len(st.byHash) >= maxTopologiesPerService {
+			return
+		}
+		topology = &topologyStats{}
+		st.byHash[hash] = topology
+		metrics.Count("datadog.trace_agent.new_trace_topology", 1, append(tags, "topology:"+strconv.FormatUint(hash, 16)), 1)
+	}
+	topology.count++
+	topology.lastSeen = now
+}
+
+// expire forgets the topologies of st which were not seen recently, reporting
+// the common ones as missing. It must be called with the lock held.
+func (tt *TopologyTracker) expire(st *serviceTopologies, tags []string, now time.Time) {
+	for hash, topology := range st.byHash {
+		if now.Sub(topology.lastSeen) < topologyMissingAfter {
+			continue
+		}
+		if topology.count >= minCommonTopologyCount && topology.count >= commonTopologyShare*st.total {
+			metrics.Count("datadog.trace_agent.topology_missing", 1, append(tags, "topology:"+strconv.FormatUint(hash, 16)), 1)
+		}
+		st.total -= topology.count
+		delete(st.byHash, hash)
+	}
+}
@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/test/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// testTopologyTrace returns a trace of the web service calling the given services.
+func testTopologyTrace(callees ...string) (*pb.Span, pb.Trace) {
+	root := &pb.Span{SpanID: 1, Service: "web"}
+	t := pb.Trace{root}
+	for i, s := range callees {
+		t = append(t, &pb.Span{SpanID: uint64(i + 2), ParentID: 1, Service: s})
+	}
+	return root, t
+}
+
+func TestTopologyTracker(t *testing.T) {
+	assert := assert.New(t)
+	stats := &testutil.TestStatsClient{}
+	defer func(old metrics.StatsClient) { metrics.Client = old }(metrics.Client)
+	metrics.Client = stats
+
+	tt := NewTopologyTracker()
+	now := time.Now()
+	observe := func(callees ...string) {
+		root, trace := testTopologyTrace(callees...)
+		tt.Observe(root, trace, now)
+	}
+	count := func(name string) int64 {
+		if summary, ok := stats.GetCountSummaries()[name]; ok {
+			return summary.Sum
+		}
+		return 0
+	}
+
+	// 2 usual topologies, and a rare one
+	for i := 0; i < 100; i++ {
+		observe("api", "db")
+		observe("api")
+		if i%50 == 0 {
+			observe("legacy")
+		}
+	}
+	assert.EqualValues(3, count("datadog.trace_agent.new_trace_topology"))
+	assert.EqualValues(0, count("datadog.trace_agent.topology_missing"))
+
+	// a deployment removes the db calls, and the rare topology stops appearing
+	for i := 0; i < 10; i++ {
+		now = now.Add(time.Minute)
+		observe("api")
+	}
+	assert.EqualValues(3, count("datadog.trace_agent.new_trace_topology"))
+	assert.EqualValues(1, count("datadog.trace_agent.topology_missing"))
+	missing := stats.GetCountSummaries()["datadog.trace_agent.topology_missing"].Calls[0]
+	assert.Contains(missing.Tags, "service:web")
+
+	// the db calls come back, as a new topology
+	observe("db", "api")
+	assert.EqualValues(4, count("datadog.trace_agent.new_trace_topology"))
+}
+
+func TestTopologyTrackerLimit(t *testing.T) {
+	tt := NewTopologyTracker()
+	now := time.Now()
+	for i := 0; i < 2*maxTopologiesPerService; i++ {
+		callees := make([]string, 0, i)
+		for j := 0; j <= i; j++ {
+			callees = append(callees, string(rune('a'+j%26))+string(rune('a'+j/26)))
+		}
+		root, trace := testTopologyTrace(callees...)
+		tt.Observe(root, trace, now)
+	}
+	assert.Len(t, tt.byService["web"].byHash, maxTopologiesPerService)
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/watchdog"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+const (
+	// admissionOverloadRatio is the fill ratio of the receiver output queue
+	// above which the agent is considered overloaded.
+	admissionOverloadRatio = 0.9
+
+	// admissionWebhookPath is the path the admission requests are sent to.
+	admissionWebhookPath = "/validate"
+)
+
+// admissionReview is the part of a Kubernetes admission.k8s.io/v1beta1
+// AdmissionReview used by the webhook.
+type admissionReview struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Request    *admissionRequest  `json:"request,omitempty"`
+	Response   *admissionResponse `json:"response,omitempty"`
+}
+
+// admissionRequest is the part of an admission request used by the webhook.
+type admissionRequest struct {
+	UID       string `json:"uid"`
+	Operation string `json:"operation"`
+	Kind      struct {
+		Kind string `json:"kind"`
+	} `json:"kind"`
+	Object struct {
+		Spec struct {
+			NodeName     string            `json:"nodeName"`
+			NodeSelector map[string]string `json:"nodeSelector"`
+		} `json:"spec"`
+	} `json:"object"`
+}
+
+// admissionResponse is the response to an admission request.
+type admissionResponse struct {
+	UID     string           `json:"uid"`
+	Allowed bool             `json:"allowed"`
+	Status  *admissionStatus `json:"status,omitempty"`
+}
+
+// admissionStatus holds the reason of a denied admission.
+type admissionStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// KubernetesAdmissionWebhook is a Kubernetes validating admission webhook
+// denying the creation of pods on the nodes running this agent while it is
+// overloaded, that is while the receiver output queue is more than 90% full.
+// Pods target the nodes running the agent when their node selector holds the
+// configured node label, or when they are assigned to the node of the agent.
+//
+// The webhook is served over TLS with the configured certificate, which the
+// caBundle of the webhook configuration must trust.
+type KubernetesAdmissionWebhook struct {
+	overloaded func() bool
+	conf       *config.KubernetesConfig
+	nodeName   string
+
+	cert   tls.Certificate
+	server *http.Server
+	addr   net.Addr
+}
+
+// newKubernetesAdmissionWebhook returns a new webhook denying pods while
+// overloaded returns true.
+func newKubernetesAdmissionWebhook(conf *config.AgentConfig, overloaded func() bool) (*KubernetesAdmissionWebhook, error) {
+	if conf.Kubernetes.WebhookCertFile == "" || conf.Kubernetes.WebhookKeyFile == "" {
+		return nil, errors.New("no certificate configured, set apm_config.kubernetes.webhook_cert_file and webhook_key_file")
+	}
+	cert, err := tls.LoadX509KeyPair(conf.Kubernetes.WebhookCertFile, conf.Kubernetes.WebhookKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading the webhook certificate: %v", err)
+	}
+	return &KubernetesAdmissionWebhook{
+		overloaded: overloaded,
+		conf:       conf.Kubernetes,
+		nodeName:   conf.Hostname,
+		cert:       cert,
+	}, nil
+}
+
+// Start starts serving admission requests on the configured port.
+func (wh *KubernetesAdmissionWebhook) Start() error {
+	ln, err := tls.Listen("tcp", fmt.Sprintf(":%d", wh.conf.WebhookPort), &tls.Config{
+		Certificates: []tls.Certificate{wh.cert},
+	})
+	if err != nil {
+		return err
+	}
+	wh.addr = ln.Addr()
+	mux := http.NewServeMux()
+	mux.HandleFunc(admissionWebhookPath, wh.handleAdmission)
+	wh.server = &http.Server{
+		Handler:      mux,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+	}
+	go func() {
+		defer watchdog.LogOnPanic()
+		wh.server.Serve(ln)
+	}()
+	log.Infof("Listening for Kubernetes admission requests at https://%s%s", wh.addr, admissionWebhookPath)
+	return nil
+}
+
+// Stop stops the webhook.
+func (wh *KubernetesAdmissionWebhook) Stop() error {
+	if wh.server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return wh.server.Shutdown(ctx)
+}
+
+func (wh *KubernetesAdmissionWebhook) handleAdmission(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var review admissionReview
+	if err := json.NewDecoder(req.Body).Decode(&review); err != nil || review.Request == nil {
+		http.Error(w, "invalid admission review", http.StatusBadRequest)
+		return
+	}
+	review.Response = wh.review(review.Request)
+	review.Request = nil
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review)
+}
+
+// review decides whether the pod of the given request is admitted.
+func (wh *KubernetesAdmissionWebhook) review(req *admissionRequest) *admissionResponse {
+	resp := &admissionResponse{UID: req.UID, Allowed: true}
+	if req.Kind.Kind != "Pod" || req.Operation != "CREATE" || !wh.targetsAgentNode(req) || !wh.overloaded() {
+		return resp
+	}
+	metrics.Count("datadog.trace_agent.kubernetes.admission_denied", 1, nil, 1)
+	resp.Allowed = false
+	resp.Status = &admissionStatus{
+		Code:    http.StatusTooManyRequests,
+		Message: "the Datadog trace agent of the node is overloaded",
+	}
+	return resp
+}
+
+// targetsAgentNode reports whether the pod of req is to run on a node running
+// this agent.
+func (wh *KubernetesAdmissionWebhook) targetsAgentNode(req *admissionRequest) bool {
+	spec := req.Object.Spec
+	if spec.NodeName != "" {
+		return spec.NodeName == wh.nodeName
+	}
+	_, ok := spec.NodeSelector[wh.conf.NodeLabel]
+	return ok
+}
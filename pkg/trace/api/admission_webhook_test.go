@@ -0,0 +1,163 @@
+package api
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testAdmissionReview returns an AdmissionReview for the creation of a pod
+// assigned to nodeName, or selecting nodeSelector.
+func testAdmissionReview(uid, nodeName string, nodeSelector map[string]string) []byte {
+	spec := map[string]interface{}{"containers": []interface{}{map[string]string{"name": "app", "image": "app:1"}}}
+	if nodeName != "" {
+		spec["nodeName"] = nodeName
+	}
+	if nodeSelector != nil {
+		spec["nodeSelector"] = nodeSelector
+	}
+	b, _ := json.Marshal(map[string]interface{}{
+		"apiVersion": "admission.k8s.io/v1beta1",
+		"kind":       "AdmissionReview",
+		"request": map[string]interface{}{
+			"uid":       uid,
+			"kind":      map[string]string{"group": "", "version": "v1", "kind": "Pod"},
+			"operation": "CREATE",
+			"namespace": "default",
+			"object": map[string]interface{}{
+				"metadata": map[string]string{"name": "app"},
+				"spec":     spec,
+			},
+		},
+	})
+	return b
+}
+
+// writeTestWebhookCertificate writes a self-signed certificate valid for
+// localhost and its key to dir, returning their paths along with the PEM
+// encoding of the certificate.
+func writeTestWebhookCertificate(t *testing.T, dir string) (certFile, keyFile string, certPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "datadog-trace-agent-webhook"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	certFile = filepath.Join(dir, "tls.crt")
+	keyFile = filepath.Join(dir, "tls.key")
+	require.NoError(t, ioutil.WriteFile(certFile, certPEM, 0644))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile, certPEM
+}
+
+func TestKubernetesAdmissionWebhook(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "webhook")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile, certPEM := writeTestWebhookCertificate(t, dir)
+
+	conf := newTestReceiverConfig()
+	conf.Hostname = "node-1"
+	conf.Kubernetes.WebhookEnabled = true
+	conf.Kubernetes.WebhookPort = 0
+	conf.Kubernetes.WebhookCertFile = certFile
+	conf.Kubernetes.WebhookKeyFile = keyFile
+	receiver := NewHTTPReceiver(conf, nil, make(chan pb.Trace, 10))
+	wh := receiver.admissionWebhook
+	require.NotNil(t, wh)
+	require.NoError(t, wh.Start())
+	defer wh.Stop()
+
+	// the API server trusts the certificate of the webhook through its caBundle
+	pool := x509.NewCertPool()
+	require.True(t, pool.AppendCertsFromPEM(certPEM))
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	url := fmt.Sprintf("https://localhost:%d%s", wh.addr.(*net.TCPAddr).Port, admissionWebhookPath)
+
+	admit := func(uid, nodeName string, nodeSelector map[string]string) *admissionResponse {
+		resp, err := client.Post(url, "application/json", bytes.NewReader(testAdmissionReview(uid, nodeName, nodeSelector)))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var review admissionReview
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&review))
+		assert.Equal("AdmissionReview", review.Kind)
+		require.NotNil(t, review.Response)
+		assert.Equal(uid, review.Response.UID)
+		return review.Response
+	}
+	agentNode := map[string]string{"datadoghq.com/trace-agent": "true"}
+
+	// pods are admitted while the agent keeps up
+	assert.True(admit("1", "", agentNode).Allowed)
+	assert.True(admit("2", "node-1", nil).Allowed)
+
+	for i := 0; i < 10; i++ {
+		receiver.Out <- pb.Trace{}
+	}
+	resp := admit("3", "", agentNode)
+	assert.False(resp.Allowed)
+	if assert.NotNil(resp.Status) {
+		assert.Equal(http.StatusTooManyRequests, resp.Status.Code)
+	}
+	assert.False(admit("4", "node-1", nil).Allowed)
+	// pods on other nodes are admitted
+	assert.True(admit("5", "node-2", agentNode).Allowed)
+	assert.True(admit("6", "", map[string]string{"disktype": "ssd"}).Allowed)
+	assert.True(admit("7", "", nil).Allowed)
+
+	// invalid reviews are refused
+	r, err := client.Post(url, "application/json", bytes.NewReader([]byte(`{"kind": "AdmissionReview"}`)))
+	require.NoError(t, err)
+	r.Body.Close()
+	assert.Equal(http.StatusBadRequest, r.StatusCode)
+}
+
+func TestKubernetesAdmissionWebhookDisabled(t *testing.T) {
+	receiver := newTestReceiverFromConfig(newTestReceiverConfig())
+	assert.Nil(t, receiver.admissionWebhook)
+
+	// the webhook requires a certificate
+	conf := newTestReceiverConfig()
+	conf.Kubernetes.WebhookEnabled = true
+	receiver = newTestReceiverFromConfig(conf)
+	assert.Nil(t, receiver.admissionWebhook)
+
+	conf.Kubernetes.WebhookCertFile = "/does/not/exist.crt"
+	conf.Kubernetes.WebhookKeyFile = "/does/not/exist.key"
+	receiver = newTestReceiverFromConfig(conf)
+	assert.Nil(t, receiver.admissionWebhook)
+}
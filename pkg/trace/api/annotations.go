@@ -0,0 +1,55 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+)
+
+const (
+	// annotationsPathPrefix is the prefix of the path of the annotation API,
+	// which is /v0.4/traces/{traceID}/annotations.
+	annotationsPathPrefix = "/v0.4/traces/"
+	annotationsPathSuffix = "/annotations"
+
+	tagAnnotationHandler = "handler:annotations"
+)
+
+// handleAnnotations stores the annotations posted for a trace as a JSON object
+// of string values, to be merged into the meta of the root of the trace when
+// it is written.
+func (r *HTTPReceiver) handleAnnotations(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path := req.URL.Path
+	if !strings.HasPrefix(path, annotationsPathPrefix) || !strings.HasSuffix(path, annotationsPathSuffix) {
+		http.NotFound(w, req)
+		return
+	}
+	id := strings.TrimSuffix(strings.TrimPrefix(path, annotationsPathPrefix), annotationsPathSuffix)
+	traceID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil || traceID == 0 {
+		http.Error(w, fmt.Sprintf("invalid trace ID: %q", id), http.StatusBadRequest)
+		return
+	}
+	var annotations map[string]string
+	if err := json.NewDecoder(req.Body).Decode(&annotations); err != nil {
+		httpDecodingError(err, []string{tagAnnotationHandler}, w)
+		return
+	}
+	if !r.Annotations.Add(traceID, annotations, time.Now()) {
+		metrics.Count("datadog.trace_agent.receiver.annotations_dropped", int64(len(annotations)), nil, 1)
+		http.Error(w, "too many annotations", http.StatusTooManyRequests)
+		return
+	}
+	metrics.Count("datadog.trace_agent.receiver.annotations", int64(len(annotations)), nil, 1)
+	httpOK(w)
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/writer"
+)
+
+func TestHandleAnnotations(t *testing.T) {
+	r := newTestReceiverFromConfig(newTestReceiverConfig())
+	r.Annotations = writer.NewAnnotationStore(time.Minute)
+	handler := r.httpHandle(r.handleAnnotations)
+	annotations := make([]string, 101)
+	for i := range annotations {
+		annotations[i] = fmt.Sprintf(`"key%d": "value"`, i)
+	}
+	tooMany := "{" + strings.Join(annotations, ", ") + "}"
+
+	for _, tt := range []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{"ok", "POST", "/v0.4/traces/1234/annotations", `{"incident": "INC-42", "owner": "sre"}`, http.StatusOK},
+		{"method", "GET", "/v0.4/traces/1234/annotations", "", http.StatusMethodNotAllowed},
+		{"path", "POST", "/v0.4/traces/1234/tags", `{}`, http.StatusNotFound},
+		{"trace-id", "POST", "/v0.4/traces/abc/annotations", `{}`, http.StatusBadRequest},
+		{"zero-trace-id", "POST", "/v0.4/traces/0/annotations", `{}`, http.StatusBadRequest},
+		{"body", "POST", "/v0.4/traces/1235/annotations", `{"incident": 42}`, http.StatusBadRequest},
+		{"too-many", "POST", "/v0.4/traces/1236/annotations", tooMany, http.StatusTooManyRequests},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			handler(rr, req)
+			assert.Equal(t, tt.code, rr.Code)
+		})
+	}
+
+	assert.Equal(t, map[string]string{"incident": "INC-42", "owner": "sre"}, r.Annotations.Get(1234, time.Now()))
+	assert.Nil(t, r.Annotations.Get(1235, time.Now()))
+	assert.Equal(t, 1, r.Annotations.Len())
+}
+
+func TestAnnotationsRoute(t *testing.T) {
+	conf := newTestReceiverConfig()
+	conf.ReceiverPort = 8326
+	r := newTestReceiverFromConfig(conf)
+	r.Annotations = writer.NewAnnotationStore(time.Minute)
+	r.Start()
+	defer r.Stop()
+
+	resp, err := http.Post("http://localhost:8326/v0.4/traces/99/annotations", "application/json", strings.NewReader(`{"deploy": "v2"}`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, map[string]string{"deploy": "v2"}, r.Annotations.Get(99, time.Now()))
+}
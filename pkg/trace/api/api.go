@@ -25,13 +25,16 @@ import (
 	"github.com/tinylib/msgp/msgp"
 
 	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/cost"
 	"github.com/DataDog/datadog-agent/pkg/trace/info"
 	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
 	"github.com/DataDog/datadog-agent/pkg/trace/metrics/timing"
 	"github.com/DataDog/datadog-agent/pkg/trace/osutil"
 	"github.com/DataDog/datadog-agent/pkg/trace/pb"
 	"github.com/DataDog/datadog-agent/pkg/trace/sampler"
+	"github.com/DataDog/datadog-agent/pkg/trace/servicemap"
 	"github.com/DataDog/datadog-agent/pkg/trace/watchdog"
+	"github.com/DataDog/datadog-agent/pkg/trace/writer"
 	"github.com/DataDog/datadog-agent/pkg/util/log"
 )
 
@@ -74,6 +77,62 @@ type HTTPReceiver struct {
 	RateLimiter *rateLimiter
 	Out         chan pb.Trace
 
+	// Pipeline processes received payloads asynchronously. It is nil when
+	// disabled, in which case each payload is processed in its own goroutine.
+	Pipeline *AsyncProcessingPipeline
+
+	// flushAdvisor recommends tracers a flush interval in the responses. It
+	// is nil when disabled.
+	flushAdvisor *flushIntervalAdvisor
+
+	// negotiator recommends tracers the encoding their payloads are decoded
+	// the fastest with. It is nil when disabled.
+	negotiator *contentNegotiator
+
+	// earlyTermination drops low priority traces on receipt during high load.
+	// It is nil when disabled.
+	earlyTermination *EarlyTerminationSampler
+
+	// liveTraces keeps the recently received traces, viewed on
+	// /debug/traces/{traceID}/view. It is nil unless in debug mode.
+	liveTraces *liveTraceStore
+
+	// admissionWebhook denies the creation of pods on the node of the agent
+	// while it is overloaded. It is nil when disabled.
+	admissionWebhook *KubernetesAdmissionWebhook
+
+	// gateway receives traces over other protocols than the HTTP API. It is
+	// nil when disabled.
+	gateway *MultiProtocolGateway
+
+	// Annotations stores the annotations added to traces through the
+	// annotation API. The API is disabled when nil.
+	Annotations *writer.AnnotationStore
+
+	// ClosedTraces holds the traces recently declared complete, whose late
+	// spans are dropped. It is nil when disabled.
+	ClosedTraces *ClosedTraceCache
+
+	// Costs estimates the cost of spans, served by service on
+	// /debug/cost/by_service. It is nil when disabled.
+	Costs *cost.ResourceCostAttributor
+
+	// ServiceHierarchy groups services by ownership, served on
+	// /debug/service_hierarchy. It is nil when disabled.
+	ServiceHierarchy *servicemap.ServiceHierarchyBuilder
+
+	// Schemas documents the HTTP endpoints of services, served on
+	// /debug/services/{service}/schema. It is nil when disabled.
+	Schemas *servicemap.SchemaDocumentationExtractor
+
+	// Profiler records the latencies of the stages of the trace processing
+	// pipeline, served on /debug/pipeline/latency. It is nil when disabled.
+	Profiler *timing.PipelineProfiler
+
+	// Tenants routes the traces of tenants to their organization. It is nil
+	// when no tenant is configured.
+	Tenants *TenantRouter
+
 	conf    *config.AgentConfig
 	dynConf *sampler.DynamicConfig
 	server  *http.Server
@@ -86,7 +145,8 @@ type HTTPReceiver struct {
 	exit chan struct{}
 }
 
-// NewHTTPReceiver returns a pointer to a new HTTPReceiver
+// NewHTTPReceiver returns a pointer to a new HTTPReceiver. conf must be created
+// with config.New, so that all of its sections are set.
 func NewHTTPReceiver(
 	conf *config.AgentConfig, dynConf *sampler.DynamicConfig, out chan pb.Trace) *HTTPReceiver {
 	rateLimiterResponse := http.StatusOK
@@ -94,7 +154,7 @@ func NewHTTPReceiver(
 		rateLimiterResponse = http.StatusTooManyRequests
 	}
 	// use buffered channels so that handlers are not waiting on downstream processing
-	return &HTTPReceiver{
+	r := &HTTPReceiver{
 		Stats:       info.NewReceiverStats(),
 		RateLimiter: newRateLimiter(),
 		Out:         out,
@@ -108,6 +168,49 @@ func NewHTTPReceiver(
 
 		exit: make(chan struct{}),
 	}
+	if conf.ReceiverPipeline.Enabled {
+		r.Pipeline = newAsyncProcessingPipeline(r, conf.ReceiverPipeline)
+	}
+	if conf.FlushFeedback.Enabled {
+		r.flushAdvisor = newFlushIntervalAdvisor(r.RateLimiter, conf.FlushFeedback)
+	}
+	if conf.ContentNegotiation.Enabled {
+		r.negotiator = newContentNegotiator(conf.ContentNegotiation)
+	}
+	if conf.EarlyTermination.Enabled {
+		r.earlyTermination = newEarlyTerminationSampler(r.RateLimiter, conf.EarlyTermination)
+	}
+	if len(conf.MultiTenant.TenantMapping) > 0 {
+		r.Tenants = newTenantRouter(conf.MultiTenant)
+	}
+	if r.debug {
+		r.liveTraces = newLiveTraceStore(maxLiveTraces)
+	}
+	if conf.Kubernetes.WebhookEnabled {
+		wh, err := newKubernetesAdmissionWebhook(conf, r.overloaded)
+		if err != nil {
+			log.Errorf("Kubernetes admission webhook disabled: %v", err)
+		} else {
+			r.admissionWebhook = wh
+		}
+	}
+	if len(conf.Gateway.Protocols) > 0 {
+		r.gateway = newMultiProtocolGateway(conf.Gateway, func(traces pb.Traces) {
+			ts := r.Stats.GetTagStats(info.Tags{})
+			if r.Tenants != nil {
+				// gateway payloads carry no API key: this only removes the
+				// organizations set by their senders
+				r.Tenants.Route(nil, traces)
+			}
+			if r.Pipeline != nil {
+				// the agent leaves obfuscation to the pipeline when it is enabled
+				r.Pipeline.AddTraces(ts, traces)
+				return
+			}
+			r.processTraces(ts, traces)
+		})
+	}
+	return r
 }
 
 // Start starts doing the HTTP server and is ready to receive traces
@@ -126,6 +229,13 @@ func (r *HTTPReceiver) Start() {
 	mux.HandleFunc("/v0.3/services", r.httpHandleWithVersion(v03, r.handleServices))
 	mux.HandleFunc("/v0.4/traces", r.httpHandleWithVersion(v04, r.handleTraces))
 	mux.HandleFunc("/v0.4/services", r.httpHandleWithVersion(v04, r.handleServices))
+	if r.conf.Sampler.CanaryVersionPattern != "" {
+		// the rollback is only triggered by the canary version monitor
+		mux.HandleFunc(rollbackPath, r.httpHandle(r.handleRollback))
+	}
+	if r.Annotations != nil {
+		mux.HandleFunc(annotationsPathPrefix, r.httpHandle(r.handleAnnotations))
+	}
 
 	timeout := 5 * time.Second
 	if r.conf.ReceiverTimeout > 0 {
@@ -163,6 +273,20 @@ func (r *HTTPReceiver) Start() {
 
 	go r.RateLimiter.Run()
 
+	if r.Pipeline != nil {
+		r.Pipeline.Start()
+	}
+
+	if r.admissionWebhook != nil {
+		if err := r.admissionWebhook.Start(); err != nil {
+			log.Errorf("Error starting the Kubernetes admission webhook: %v", err)
+		}
+	}
+
+	if r.gateway != nil {
+		r.gateway.Start()
+	}
+
 	go func() {
 		defer watchdog.LogOnPanic()
 		r.loop()
@@ -200,6 +324,27 @@ func (r *HTTPReceiver) attachDebugHandlers(mux *http.ServeMux) {
 	})
 
 	mux.Handle("/debug/vars", expvar.Handler())
+
+	mux.HandleFunc("/debug/sampler/flamegraph", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write(r.dynConf.Traffic.FlameGraph())
+	})
+
+	if r.Costs != nil {
+		mux.Handle("/debug/cost/by_service", r.Costs)
+	}
+	if r.ServiceHierarchy != nil {
+		mux.Handle("/debug/service_hierarchy", r.ServiceHierarchy)
+	}
+	if r.Schemas != nil {
+		mux.Handle(servicemap.SchemaPathPrefix, r.Schemas)
+	}
+	if r.Profiler != nil {
+		mux.Handle(timing.PipelineLatencyPath, r.Profiler)
+	}
+	if r.liveTraces != nil {
+		mux.Handle(traceViewPrefix, r.liveTraces)
+	}
 }
 
 // listenUnix returns a net.Listener listening on the given "unix" socket path.
@@ -252,6 +397,18 @@ func (r *HTTPReceiver) Stop() error {
 		return err
 	}
 	r.wg.Wait()
+	if r.gateway != nil {
+		// the gateway feeds the pipeline, so it is stopped first
+		r.gateway.Stop()
+	}
+	if r.Pipeline != nil {
+		r.Pipeline.Stop()
+	}
+	if r.admissionWebhook != nil {
+		if err := r.admissionWebhook.Stop(); err != nil {
+			log.Errorf("Error stopping the Kubernetes admission webhook: %v", err)
+		}
+	}
 	close(r.Out)
 	return nil
 }
@@ -300,21 +457,35 @@ func (r *HTTPReceiver) tagStats(req *http.Request) *info.TagStats {
 }
 
 func (r *HTTPReceiver) decodeTraces(v Version, req *http.Request) (pb.Traces, error) {
+	var traces pb.Traces
 	if v == v01 {
 		var spans []pb.Span
 		if err := json.NewDecoder(req.Body).Decode(&spans); err != nil {
 			return nil, err
 		}
-		return tracesFromSpans(spans), nil
+		traces = tracesFromSpans(spans)
+	} else {
+		start := time.Now()
+		if err := decodeRequest(req, &traces); err != nil {
+			return nil, err
+		}
+		if r.negotiator != nil {
+			r.negotiator.observe(req, time.Since(start), spanCount(traces))
+		}
 	}
-	var traces pb.Traces
-	if err := decodeRequest(req, &traces); err != nil {
-		return nil, err
+	if r.Tenants != nil {
+		r.Tenants.Route(req.Header, traces)
 	}
 	return traces, nil
 }
 
-func (r *HTTPReceiver) replyOK(v Version, w http.ResponseWriter) {
+func (r *HTTPReceiver) replyOK(v Version, w http.ResponseWriter, req *http.Request) {
+	if r.flushAdvisor != nil {
+		r.flushAdvisor.setHeader(w)
+	}
+	if r.negotiator != nil {
+		r.negotiator.setHeader(w, req)
+	}
 	switch v {
 	case v01, v02, v03:
 		httpOK(w)
@@ -328,13 +499,25 @@ func (r *HTTPReceiver) handleTraces(v Version, w http.ResponseWriter, req *http.
 	traceCount := traceCount(req)
 	if !r.RateLimiter.Permits(traceCount) {
 		io.Copy(ioutil.Discard, req.Body)
+		// the headers must be set before writing the status code
+		if r.flushAdvisor != nil {
+			r.flushAdvisor.setHeader(w)
+		}
+		if r.negotiator != nil {
+			r.negotiator.setHeader(w, req)
+		}
 		w.WriteHeader(r.rateLimiterResponse)
-		r.replyOK(v, w)
+		r.replyOK(v, w, req)
 		metrics.Count("datadog.trace_agent.receiver.payload_refused", 1, nil, 1)
 		return
 	}
 
 	ts := r.tagStats(req)
+	if r.Pipeline != nil {
+		r.queueTraces(v, w, req, ts, traceCount)
+		return
+	}
+	start := time.Now()
 	traces, err := r.decodeTraces(v, req)
 	if err != nil {
 		httpDecodingError(err, []string{tagTraceHandler, fmt.Sprintf("v:%s", v)}, w)
@@ -342,7 +525,10 @@ func (r *HTTPReceiver) handleTraces(v Version, w http.ResponseWriter, req *http.
 		log.Errorf("Cannot decode %s traces payload: %v", v, err)
 		return
 	}
-	r.replyOK(v, w)
+	if r.Profiler != nil {
+		r.Profiler.Since(timing.StageDecode, start)
+	}
+	r.replyOK(v, w, req)
 
 	atomic.AddInt64(&ts.TracesReceived, int64(len(traces)))
 	atomic.AddInt64(&ts.TracesBytes, int64(req.Body.(*LimitedReader).Count))
@@ -358,22 +544,85 @@ func (r *HTTPReceiver) handleTraces(v Version, w http.ResponseWriter, req *http.
 	}()
 }
 
+// queueTraces reads the payload of req and queues it into the pipeline for processing.
+func (r *HTTPReceiver) queueTraces(v Version, w http.ResponseWriter, req *http.Request, ts *info.TagStats, traceCount int64) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		httpDecodingError(err, []string{tagTraceHandler, fmt.Sprintf("v:%s", v)}, w)
+		atomic.AddInt64(&ts.TracesDropped.DecodingError, traceCount)
+		log.Errorf("Cannot read %s traces payload: %v", v, err)
+		return
+	}
+	r.Pipeline.Add(&rawPayload{
+		version:    v,
+		header:     req.Header,
+		body:       body,
+		traceCount: traceCount,
+		ts:         ts,
+	})
+	r.replyOK(v, w, req)
+
+	atomic.AddInt64(&ts.TracesBytes, int64(len(body)))
+	atomic.AddInt64(&ts.PayloadAccepted, 1)
+}
+
 func (r *HTTPReceiver) processTraces(ts *info.TagStats, traces pb.Traces) {
 	defer timing.Since("datadog.trace_agent.internal.normalize_ms", time.Now())
 	for _, trace := range traces {
-		spans := len(trace)
+		if r.receiveTrace(ts, trace) {
+			r.send(trace)
+		}
+	}
+}
 
-		atomic.AddInt64(&ts.SpansReceived, int64(spans))
+// receiveTrace counts, filters and normalizes a received trace, and reports
+// whether it must be passed downstream. It is shared by the synchronous path
+// and the normalization stage of the pipeline.
+func (r *HTTPReceiver) receiveTrace(ts *info.TagStats, trace pb.Trace) bool {
+	spans := len(trace)
+	atomic.AddInt64(&ts.SpansReceived, int64(spans))
+
+	if r.ClosedTraces != nil && spans > 0 && r.ClosedTraces.Closed(trace[0].TraceID, time.Now()) {
+		log.Debugf("Dropping %d late spans of closed trace %d", spans, trace[0].TraceID)
+		atomic.AddInt64(&ts.TracesDropped.ClosedTrace, 1)
+		atomic.AddInt64(&ts.SpansDropped, int64(spans))
+		return false
+	}
 
-		err := normalizeTrace(ts, trace)
-		if err != nil {
-			log.Debug("Dropping invalid trace: %s", err)
-			atomic.AddInt64(&ts.SpansDropped, int64(spans))
-			continue
-		}
+	if r.earlyTermination != nil && r.earlyTermination.Terminate(trace) {
+		atomic.AddInt64(&ts.TracesDropped.EarlyTermination, 1)
+		atomic.AddInt64(&ts.SpansDropped, int64(spans))
+		return false
+	}
+
+	start := time.Now()
+	err := normalizeTrace(ts, trace)
+	if err == nil {
+		err = checkTraceAge(ts, trace, time.Duration(r.conf.MaxTraceAgeMinutes)*time.Minute, start)
+	}
+	if r.Profiler != nil {
+		r.Profiler.Since(timing.StageNormalize, start)
+	}
+	if err != nil {
+		log.Debugf("Dropping invalid trace: %s", err)
+		atomic.AddInt64(&ts.SpansDropped, int64(spans))
+		return false
+	}
+	tagReservedSynthetics(r.conf.Synthetics, trace)
+	return true
+}
 
-		r.Out <- trace
+// send passes a received trace, ready to be processed, to the output channel.
+func (r *HTTPReceiver) send(trace pb.Trace) {
+	if r.liveTraces != nil {
+		r.liveTraces.Add(trace)
 	}
+	r.Out <- trace
+}
+
+// overloaded reports whether the output queue of the receiver is nearly full.
+func (r *HTTPReceiver) overloaded() bool {
+	return cap(r.Out) > 0 && float64(len(r.Out)) > admissionOverloadRatio*float64(cap(r.Out))
 }
 
 // handleServices handle a request with a list of several services
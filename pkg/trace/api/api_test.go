@@ -634,6 +634,20 @@ func TestExpvar(t *testing.T) {
 	}
 }
 
+func TestSamplerFlameGraphHandler(t *testing.T) {
+	r := newTestReceiverFromConfig(newTestReceiverConfig())
+	mux := http.NewServeMux()
+	r.attachDebugHandlers(mux)
+
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/debug/sampler/flamegraph", nil)
+	mux.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "image/svg+xml", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), "<svg")
+}
+
 func TestWatchdog(t *testing.T) {
 	t.Run("rate-limit", func(t *testing.T) {
 		if testing.Short() {
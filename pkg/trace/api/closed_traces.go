@@ -0,0 +1,91 @@
+package api
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// closedTrace is an entry of the ClosedTraceCache.
+type closedTrace struct {
+	traceID  uint64
+	closedAt time.Time
+}
+
+// ClosedTraceCache remembers the traces recently declared complete, either
+// because their root span was received or because they timed out, so that the
+// spans arriving late for them can be dropped instead of being processed as
+// orphan traces. Traces are forgotten after a TTL, and the least recently
+// closed ones are evicted when the cache is full.
+type ClosedTraceCache struct {
+	ttl  time.Duration
+	size int
+
+	mu      sync.Mutex
+	entries *list.List               // of *closedTrace, most recently closed first
+	byID    map[uint64]*list.Element // by trace ID
+}
+
+// NewClosedTraceCache returns a new ClosedTraceCache remembering at most size
+// traces, for ttl.
+func NewClosedTraceCache(ttl time.Duration, size int) *ClosedTraceCache {
+	return &ClosedTraceCache{
+		ttl:     ttl,
+		size:    size,
+		entries: list.New(),
+		byID:    make(map[uint64]*list.Element),
+	}
+}
+
+// Close declares the trace of the given ID closed at now.
+func (c *ClosedTraceCache) Close(traceID uint64, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.byID[traceID]; ok {
+		e.Value.(*closedTrace).closedAt = now
+		c.entries.MoveToFront(e)
+		return
+	}
+	c.byID[traceID] = c.entries.PushFront(&closedTrace{traceID: traceID, closedAt: now})
+	c.expire(now)
+	for c.entries.Len() > c.size {
+		c.remove(c.entries.Back())
+	}
+}
+
+// Closed reports whether the trace of the given ID was closed less than the TTL
+// before now.
+func (c *ClosedTraceCache) Closed(traceID uint64, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.byID[traceID]
+	if !ok {
+		return false
+	}
+	if now.Sub(e.Value.(*closedTrace).closedAt) >= c.ttl {
+		c.remove(e)
+		return false
+	}
+	return true
+}
+
+// Len returns the number of traces in the cache, including the expired ones
+// which were not removed yet.
+func (c *ClosedTraceCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries.Len()
+}
+
+// expire removes the traces closed more than the TTL before now, which are at
+// the back of the list.
+func (c *ClosedTraceCache) expire(now time.Time) {
+	for e := c.entries.Back(); e != nil && now.Sub(e.Value.(*closedTrace).closedAt) >= c.ttl; e = c.entries.Back() {
+		c.remove(e)
+	}
+}
+
+func (c *ClosedTraceCache) remove(e *list.Element) {
+	c.entries.Remove(e)
+	delete(c.byID, e.Value.(*closedTrace).traceID)
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClosedTraceCache(t *testing.T) {
+	assert := assert.New(t)
+	c := NewClosedTraceCache(30*time.Second, 3)
+	now := time.Now()
+
+	assert.False(c.Closed(1, now))
+	c.Close(1, now)
+	assert.True(c.Closed(1, now.Add(15*time.Second)))
+	assert.False(c.Closed(1, now.Add(45*time.Second)), "closed traces are forgotten after the TTL")
+	assert.Equal(0, c.Len())
+
+	// closing a trace again refreshes it
+	c.Close(1, now)
+	c.Close(1, now.Add(20*time.Second))
+	assert.True(c.Closed(1, now.Add(45*time.Second)))
+	assert.Equal(1, c.Len())
+
+	// the least recently closed traces are evicted when the cache is full
+	c.Close(2, now.Add(21*time.Second))
+	c.Close(3, now.Add(22*time.Second))
+	c.Close(1, now.Add(23*time.Second))
+	c.Close(4, now.Add(24*time.Second))
+	assert.Equal(3, c.Len())
+	assert.False(c.Closed(2, now.Add(25*time.Second)))
+	for _, id := range []uint64{1, 3, 4} {
+		assert.True(c.Closed(id, now.Add(25*time.Second)), id)
+	}
+
+	// expired traces are removed when traces are closed
+	c.Close(5, now.Add(53*time.Second))
+	assert.Equal(2, c.Len())
+}
+
+func TestProcessTracesClosedTrace(t *testing.T) {
+	assert := assert.New(t)
+	receiver := newTestReceiverFromConfig(newTestReceiverConfig())
+	receiver.ClosedTraces = NewClosedTraceCache(30*time.Second, 100)
+	now := time.Now()
+	receiver.ClosedTraces.Close(1, now.Add(-15*time.Second))
+	receiver.ClosedTraces.Close(2, now.Add(-45*time.Second))
+
+	newLateSpans := func(traceID uint64) pb.Trace {
+		return pb.Trace{
+			{TraceID: traceID, SpanID: 10, ParentID: 1, Service: "web", Name: "db.query", Resource: "SELECT", Duration: 1},
+			{TraceID: traceID, SpanID: 11, ParentID: 1, Service: "web", Name: "db.query", Resource: "SELECT", Duration: 1},
+		}
+	}
+	ts := newTagStats()
+	receiver.processTraces(ts, pb.Traces{newLateSpans(1), newLateSpans(2), newLateSpans(3)})
+
+	var received []uint64
+	for len(receiver.Out) > 0 {
+		received = append(received, (<-receiver.Out)[0].TraceID)
+	}
+	// spans arriving 15s after their trace was closed are dropped, not the ones
+	// arriving after 45s
+	assert.Equal([]uint64{2, 3}, received)
+	assert.EqualValues(1, ts.TracesDropped.ClosedTrace)
+	assert.EqualValues(2, ts.SpansDropped)
+	assert.EqualValues(6, ts.SpansReceived)
+}
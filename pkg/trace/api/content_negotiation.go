@@ -0,0 +1,132 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+)
+
+const (
+	// headerPreferredContentType is the response header recommending tracers
+	// the content type to encode their payloads with.
+	headerPreferredContentType = "X-Datadog-Preferred-Content-Type"
+
+	// minNegotiationSamples is the number of payloads of each encoding which
+	// must be decoded before recommending one.
+	minNegotiationSamples = 10
+
+	// maxNegotiationClients is the maximum number of client versions decoding
+	// times are tracked for.
+	maxNegotiationClients = 1000
+)
+
+// negotiatedContentTypes are the encodings compared, by media type.
+var negotiatedContentTypes = map[string]string{
+	"application/msgpack": "application/msgpack",
+	"application/json":    "application/json",
+	"text/json":           "application/json",
+	"":                    "application/json",
+}
+
+// decodeTimeAverage is an exponentially weighted moving average of the time
+// taken to decode a span.
+type decodeTimeAverage struct {
+	value   float64 // in nanoseconds per span
+	samples int
+}
+
+// contentNegotiator recommends tracers the encoding their payloads are decoded
+// the fastest with. It keeps a moving average of the time taken to decode a
+// span of each encoding, by client language and tracer version, and recommends
+// the faster encoding once both were decoded a few times.
+type contentNegotiator struct {
+	alpha float64
+
+	mu      sync.Mutex
+	clients map[string]map[string]*decodeTimeAverage // by client, then media type
+}
+
+// newContentNegotiator returns a new contentNegotiator.
+func newContentNegotiator(conf *config.ContentNegotiationConfig) *contentNegotiator {
+	return &contentNegotiator{
+		alpha:   conf.Alpha,
+		clients: make(map[string]map[string]*decodeTimeAverage),
+	}
+}
+
+// clientVersion returns the client version sending req, such as python/0.20.0.
+func clientVersion(req *http.Request) string {
+	return req.Header.Get("Datadog-Meta-Lang") + "/" + req.Header.Get("Datadog-Meta-Tracer-Version")
+}
+
+// spanCount returns the number of spans of traces.
+func spanCount(traces pb.Traces) int {
+	n := 0
+	for _, t := range traces {
+		n += len(t)
+	}
+	return n
+}
+
+// observe records that decoding spans spans of the payload of req took d.
+func (n *contentNegotiator) observe(req *http.Request, d time.Duration, spans int) {
+	mediaType, ok := negotiatedContentTypes[getMediaType(req)]
+	if !ok || spans == 0 {
+		return
+	}
+	perSpan := float64(d) / float64(spans)
+	client := clientVersion(req)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	averages, ok := n.clients[client]
+	if !ok {
+		if len(n.clients) >= maxNegotiationClients {
+			return
+		}
+		averages = make(map[string]*decodeTimeAverage, len(negotiatedContentTypes))
+		n.clients[client] = averages
+	}
+	avg, ok := averages[mediaType]
+	if !ok {
+		averages[mediaType] = &decodeTimeAverage{value: perSpan, samples: 1}
+		return
+	}
+	avg.value = n.alpha*perSpan + (1-n.alpha)*avg.value
+	avg.samples++
+}
+
+// preferred returns the content type recommended to the client sending req, or
+// an empty string when there is not enough data to recommend one.
+func (n *contentNegotiator) preferred(req *http.Request) string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var (
+		best     string
+		bestTime float64
+	)
+	averages := n.clients[clientVersion(req)]
+	if len(averages) < 2 {
+		return ""
+	}
+	for mediaType, avg := range averages {
+		if avg.samples < minNegotiationSamples {
+			return ""
+		}
+		if best == "" || avg.value < bestTime || (avg.value == bestTime && mediaType < best) {
+			best, bestTime = mediaType, avg.value
+		}
+	}
+	return best
+}
+
+// setHeader sets the preferred content type header on w for the client sending
+// req, if any. It must be called before the response header is written.
+func (n *contentNegotiator) setHeader(w http.ResponseWriter, req *http.Request) {
+	if contentType := n.preferred(req); contentType != "" {
+		w.Header().Set(headerPreferredContentType, contentType)
+	}
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/test/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/tinylib/msgp/msgp"
+)
+
+func newNegotiationRequest(contentType, lang, version string) *http.Request {
+	req := httptest.NewRequest("POST", "/v0.4/traces", nil)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Datadog-Meta-Lang", lang)
+	req.Header.Set("Datadog-Meta-Tracer-Version", version)
+	return req
+}
+
+func TestContentNegotiator(t *testing.T) {
+	assert := assert.New(t)
+	n := newContentNegotiator(&config.ContentNegotiationConfig{Alpha: 0.1})
+
+	python := func(contentType string) *http.Request {
+		return newNegotiationRequest(contentType, "python", "0.20.0")
+	}
+	ruby := func(contentType string) *http.Request {
+		return newNegotiationRequest(contentType, "ruby", "0.16.0")
+	}
+
+	for i := 0; i < minNegotiationSamples-1; i++ {
+		n.observe(python("application/msgpack"), 10*time.Microsecond, 10)
+		n.observe(python("application/json"), 50*time.Microsecond, 10)
+	}
+	assert.Empty(n.preferred(python("application/json")), "not enough samples")
+
+	n.observe(python("application/msgpack"), 10*time.Microsecond, 10)
+	assert.Empty(n.preferred(python("application/json")), "not enough json samples")
+
+	n.observe(python("text/json"), 50*time.Microsecond, 10)
+	assert.Equal("application/msgpack", n.preferred(python("application/json")))
+	assert.Equal("application/msgpack", n.preferred(python("application/msgpack")))
+
+	// decoding times are per span and per client version
+	for i := 0; i < minNegotiationSamples; i++ {
+		n.observe(ruby("application/msgpack"), 30*time.Microsecond, 10)
+		n.observe(ruby(""), 20*time.Microsecond, 20)
+	}
+	assert.Equal("application/json", n.preferred(ruby("application/msgpack")))
+	assert.Empty(n.preferred(newNegotiationRequest("application/msgpack", "ruby", "0.17.0")))
+
+	// the recommendation follows the moving average
+	for i := 0; i < 50; i++ {
+		n.observe(ruby("application/msgpack"), 5*time.Microsecond, 10)
+	}
+	assert.Equal("application/msgpack", n.preferred(ruby("application/json")))
+
+	// unknown encodings and empty payloads are ignored
+	n.observe(python("application/x-protobuf"), time.Nanosecond, 10)
+	n.observe(python("application/json"), time.Nanosecond, 0)
+	assert.Len(n.clients[clientVersion(python(""))], 2)
+	assert.Equal(minNegotiationSamples, n.clients[clientVersion(python(""))]["application/json"].samples)
+
+	rr := httptest.NewRecorder()
+	n.setHeader(rr, python("application/json"))
+	assert.Equal("application/msgpack", rr.Header().Get(headerPreferredContentType))
+}
+
+func TestContentNegotiationHeader(t *testing.T) {
+	traces := testutil.GetTestTraces(5, 5, true)
+	var msgpBuf bytes.Buffer
+	assert.NoError(t, msgp.Encode(&msgpBuf, traces))
+	jsonData, err := json.Marshal(traces)
+	assert.NoError(t, err)
+
+	post := func(receiver *HTTPReceiver, contentType string, body []byte) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v0.4/traces", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Datadog-Meta-Lang", "python")
+		req.Header.Set("Datadog-Meta-Tracer-Version", "0.20.0")
+		receiver.httpHandleWithVersion(v04, receiver.handleTraces)(rr, req)
+		return rr
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		receiver := newTestReceiverFromConfig(newTestReceiverConfig())
+		assert.Nil(t, receiver.negotiator)
+		for i := 0; i < minNegotiationSamples; i++ {
+			post(receiver, "application/msgpack", msgpBuf.Bytes())
+			rr := post(receiver, "application/json", jsonData)
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Empty(t, rr.Header().Get(headerPreferredContentType))
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		conf := newTestReceiverConfig()
+		conf.ContentNegotiation.Enabled = true
+		receiver := newTestReceiverFromConfig(conf)
+		assert.NotNil(t, receiver.negotiator)
+
+		for i := 0; i < minNegotiationSamples-1; i++ {
+			post(receiver, "application/msgpack", msgpBuf.Bytes())
+			rr := post(receiver, "application/json", jsonData)
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Empty(t, rr.Header().Get(headerPreferredContentType))
+		}
+		post(receiver, "application/msgpack", msgpBuf.Bytes())
+		rr := post(receiver, "application/json", jsonData)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, []string{"application/msgpack", "application/json"}, rr.Header().Get(headerPreferredContentType))
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		conf := newTestReceiverConfig()
+		conf.ContentNegotiation.Enabled = true
+		receiver := newTestReceiverFromConfig(conf)
+		for i := 0; i < minNegotiationSamples; i++ {
+			post(receiver, "application/msgpack", msgpBuf.Bytes())
+			post(receiver, "application/json", jsonData)
+		}
+		receiver.RateLimiter.SetTargetRate(0.1)
+		setLoad(receiver.RateLimiter, 0.6)
+
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v0.4/traces", bytes.NewReader(msgpBuf.Bytes()))
+		req.Header.Set("Content-Type", "application/msgpack")
+		req.Header.Set("Datadog-Meta-Lang", "python")
+		req.Header.Set("Datadog-Meta-Tracer-Version", "0.20.0")
+		req.Header.Set(headerTraceCount, "5")
+		receiver.httpHandleWithVersion(v04, receiver.handleTraces)(rr, req)
+		// the header is sent along with the status code
+		assert.Contains(t, []string{"application/msgpack", "application/json"}, rr.Result().Header.Get(headerPreferredContentType))
+	})
+}
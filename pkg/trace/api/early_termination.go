@@ -0,0 +1,47 @@
+package api
+
+import (
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/sampler"
+	"github.com/DataDog/datadog-agent/pkg/trace/traceutil"
+)
+
+// EarlyTerminationSampler drops low priority traces as soon as they are
+// received when the agent is saturated, so that no time is spent processing
+// traces which would most likely be dropped anyway.
+//
+// The agent is considered saturated when the share of traces dropped by the
+// rate limiter is above the configured high load. The decision only depends
+// on the trace ID, so that all the parts of a trace get the same one.
+type EarlyTerminationSampler struct {
+	limiter  *rateLimiter
+	highLoad float64
+	keepRate float64
+}
+
+// newEarlyTerminationSampler returns a new EarlyTerminationSampler following
+// the load of the given rate limiter.
+func newEarlyTerminationSampler(limiter *rateLimiter, conf *config.EarlyTerminationConfig) *EarlyTerminationSampler {
+	return &EarlyTerminationSampler{
+		limiter:  limiter,
+		highLoad: conf.HighLoad,
+		keepRate: conf.KeepRate,
+	}
+}
+
+// Terminate reports whether the trace should be dropped without processing.
+// Only traces with a sampling priority lower than PriorityAutoKeep can be.
+func (s *EarlyTerminationSampler) Terminate(t pb.Trace) bool {
+	if len(t) == 0 {
+		return false
+	}
+	if load := 1 - s.limiter.RealRate(); load <= s.highLoad {
+		return false
+	}
+	priority, ok := sampler.GetSamplingPriority(traceutil.GetRoot(t))
+	if !ok || priority >= sampler.PriorityAutoKeep {
+		return false
+	}
+	return !sampler.SampleByRate(t[0].TraceID, s.keepRate)
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/sampler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEarlyTerminationSampler(t *testing.T) {
+	assert := assert.New(t)
+	conf := newTestReceiverConfig()
+	conf.EarlyTermination.Enabled = true
+	receiver := newTestReceiverFromConfig(conf)
+	if !assert.NotNil(receiver.earlyTermination) {
+		return
+	}
+
+	newTraces := func(priority sampler.SamplingPriority) pb.Traces {
+		traces := make(pb.Traces, 1000)
+		for i := range traces {
+			root := &pb.Span{TraceID: uint64(i + 1), SpanID: 1, Service: "web", Name: "http.request", Resource: "GET /", Duration: 1}
+			if priority != sampler.PriorityNone {
+				sampler.SetSamplingPriority(root, priority)
+			}
+			traces[i] = pb.Trace{root}
+		}
+		return traces
+	}
+	process := func(traces pb.Traces) (received int, dropped int64) {
+		ts := newTagStats()
+		receiver.processTraces(ts, traces)
+		for len(receiver.Out) > 0 {
+			<-receiver.Out
+			received++
+		}
+		return received, ts.TracesDropped.EarlyTermination
+	}
+
+	// no early termination below the high load
+	setLoad(receiver.RateLimiter, 0.5)
+	received, dropped := process(newTraces(sampler.PriorityAutoDrop))
+	assert.Equal(1000, received)
+	assert.EqualValues(0, dropped)
+
+	// low priority traces are dropped during high load
+	setLoad(receiver.RateLimiter, 0.9)
+	received, dropped = process(newTraces(sampler.PriorityAutoDrop))
+	assert.InDelta(100, received, 40)
+	assert.EqualValues(1000-received, dropped)
+	_, dropped = process(newTraces(sampler.PriorityUserDrop))
+	assert.EqualValues(1000-received, dropped, "the decision only depends on the trace ID")
+
+	// other traces are always processed
+	for _, priority := range []sampler.SamplingPriority{sampler.PriorityNone, sampler.PriorityAutoKeep, sampler.PriorityUserKeep} {
+		received, dropped = process(newTraces(priority))
+		assert.Equal(1000, received)
+		assert.EqualValues(0, dropped)
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+)
+
+const (
+	// headerFlushInterval is the response header recommending tracers an
+	// interval at which to flush their traces.
+	headerFlushInterval = "X-Datadog-Recommended-Flush-Interval"
+
+	// flushIntervalHighLoad is the flush interval recommended when the rate
+	// limiter drops a large share of the traces.
+	flushIntervalHighLoad = 5 * time.Second
+
+	// flushIntervalLowLoad is the flush interval recommended otherwise.
+	flushIntervalLowLoad = time.Second
+)
+
+// flushIntervalAdvisor recommends tracers a flush interval based on the share
+// of traces dropped by the rate limiter, so that tracers flushing very often
+// slow down during traffic spikes.
+//
+// The recommendation is only raised above the high load threshold and lowered
+// back below the low load threshold, so that it doesn't flap when the load
+// stays in between.
+type flushIntervalAdvisor struct {
+	limiter *rateLimiter
+	conf    *config.FlushFeedbackConfig
+
+	mu       sync.Mutex
+	interval time.Duration
+}
+
+// newFlushIntervalAdvisor returns a new flushIntervalAdvisor following the
+// load of the given rate limiter.
+func newFlushIntervalAdvisor(limiter *rateLimiter, conf *config.FlushFeedbackConfig) *flushIntervalAdvisor {
+	return &flushIntervalAdvisor{
+		limiter:  limiter,
+		conf:     conf,
+		interval: flushIntervalLowLoad,
+	}
+}
+
+// Interval returns the currently recommended flush interval.
+func (a *flushIntervalAdvisor) Interval() time.Duration {
+	load := 1 - a.limiter.RealRate()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch {
+	case load > a.conf.HighLoad:
+		a.interval = flushIntervalHighLoad
+	case load < a.conf.LowLoad:
+		a.interval = flushIntervalLowLoad
+	}
+	return a.interval
+}
+
+// setHeader sets the recommended flush interval header on w. It must be
+// called before the response header is written.
+func (a *flushIntervalAdvisor) setHeader(w http.ResponseWriter) {
+	w.Header().Set(headerFlushInterval, a.Interval().String())
+}
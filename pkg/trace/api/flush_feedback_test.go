@@ -0,0 +1,84 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/test/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// setLoad sets the share of traces dropped by the rate limiter.
+func setLoad(limiter *rateLimiter, load float64) {
+	limiter.mu.Lock()
+	limiter.stats.RecentTracesSeen = 100
+	limiter.stats.RecentTracesDropped = 100 * load
+	limiter.mu.Unlock()
+}
+
+func TestFlushIntervalAdvisor(t *testing.T) {
+	conf := newTestReceiverConfig()
+	conf.FlushFeedback.Enabled = true
+	receiver := newTestReceiverFromConfig(conf)
+	advisor := receiver.flushAdvisor
+	if !assert.NotNil(t, advisor) {
+		return
+	}
+
+	for _, tt := range []struct {
+		load     float64
+		interval string
+	}{
+		{0, "1s"},
+		{0.3, "1s"},
+		{0.49, "1s"},
+		{0.51, "5s"},
+		{0.3, "5s"}, // kept until the load is low again
+		{0.21, "5s"},
+		{0.19, "1s"},
+		{0.4, "1s"},
+		{0.9, "5s"},
+	} {
+		setLoad(receiver.RateLimiter, tt.load)
+		rr := httptest.NewRecorder()
+		receiver.replyOK(v04, rr, httptest.NewRequest("POST", "/v0.4/traces", nil))
+		assert.Equal(t, tt.interval, rr.Header().Get(headerFlushInterval), "load: %.2f", tt.load)
+	}
+}
+
+func TestFlushIntervalHeader(t *testing.T) {
+	var buf bytes.Buffer
+	msgp.Encode(&buf, testutil.GetTestTraces(10, 10, true))
+
+	post := func(receiver *HTTPReceiver) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v0.4/traces", bytes.NewReader(buf.Bytes()))
+		req.Header.Set("Content-Type", "application/msgpack")
+		req.Header.Set(headerTraceCount, strconv.Itoa(10))
+		receiver.httpHandleWithVersion(v04, receiver.handleTraces)(rr, req)
+		return rr
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		receiver := newTestReceiverFromConfig(newTestReceiverConfig())
+		assert.Nil(t, receiver.flushAdvisor)
+		rr := post(receiver)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Empty(t, rr.Header().Get(headerFlushInterval))
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		conf := newTestReceiverConfig()
+		conf.FlushFeedback.Enabled = true
+		receiver := newTestReceiverFromConfig(conf)
+		receiver.RateLimiter.SetTargetRate(0.1)
+		setLoad(receiver.RateLimiter, 0.6)
+
+		rr := post(receiver)
+		assert.Equal(t, "5s", rr.Header().Get(headerFlushInterval))
+	})
+}
@@ -0,0 +1,300 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/types"
+	"github.com/tinylib/msgp/msgp"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/watchdog"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+const (
+	// gatewayPath is the path traces are sent to over HTTP.
+	gatewayPath = "/v0.4/traces"
+
+	// gatewayGRPCMethod is the full name of the gRPC method receiving traces.
+	gatewayGRPCMethod = "/datadog.trace.Gateway/SendTraces"
+)
+
+// gatewayProtocols are the protocols supported by the gateway.
+var gatewayProtocols = map[string]bool{
+	"http":  true,
+	"grpc":  true,
+	"kafka": true,
+	"nats":  true,
+}
+
+// messageConsumer consumes the messages of a message queue.
+type messageConsumer interface {
+	// Messages returns the channel the payloads of the messages are sent to.
+	// It is closed when the consumer is closed.
+	Messages() <-chan []byte
+	// Close stops consuming messages.
+	Close() error
+}
+
+// MultiProtocolGateway receives msgpack encoded traces over HTTP and gRPC, and
+// consumes them from Kafka and NATS, passing them to the receiver as if they
+// were received by its HTTP API. Each protocol has its own authentication token
+// and rate limit.
+type MultiProtocolGateway struct {
+	conf     *config.GatewayConfig
+	process  func(pb.Traces)
+	limiters map[string]*rate.Limiter // by protocol, nil when unlimited
+
+	maxRequestBodyLength int64
+
+	httpServer *http.Server
+	httpAddr   net.Addr
+	grpcServer *grpc.Server
+	grpcAddr   net.Addr
+	consumers  []messageConsumer
+	wg         sync.WaitGroup // waits for the consumers
+}
+
+// newMultiProtocolGateway returns a new gateway passing the traces it receives
+// to process.
+func newMultiProtocolGateway(conf *config.GatewayConfig, process func(pb.Traces)) *MultiProtocolGateway {
+	g := &MultiProtocolGateway{
+		conf:     conf,
+		process:  process,
+		limiters: make(map[string]*rate.Limiter),
+
+		maxRequestBodyLength: maxRequestBodyLength,
+	}
+	for protocol, limit := range conf.RateLimits {
+		if limit > 0 {
+			g.limiters[protocol] = rate.NewLimiter(rate.Limit(limit), int(limit)+1)
+		}
+	}
+	return g
+}
+
+// Start starts serving and consuming traces over the enabled protocols. The
+// protocols which can't be started are logged and skipped.
+func (g *MultiProtocolGateway) Start() {
+	for _, protocol := range g.conf.Protocols {
+		if !gatewayProtocols[protocol] {
+			log.Errorf("Unknown gateway protocol %q, it must be one of http, grpc, kafka or nats", protocol)
+			continue
+		}
+		if err := g.startProtocol(protocol); err != nil {
+			log.Errorf("Error starting the %s gateway: %v", protocol, err)
+		}
+	}
+}
+
+func (g *MultiProtocolGateway) startProtocol(protocol string) error {
+	switch protocol {
+	case "http":
+		ln, err := net.Listen("tcp", g.conf.HTTPAddr)
+		if err != nil {
+			return err
+		}
+		g.httpAddr = ln.Addr()
+		mux := http.NewServeMux()
+		mux.HandleFunc(gatewayPath, g.handleHTTP)
+		g.httpServer = &http.Server{
+			Handler:      mux,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 5 * time.Second,
+		}
+		go func() {
+			defer watchdog.LogOnPanic()
+			g.httpServer.Serve(ln)
+		}()
+		log.Infof("Listening for traces at http://%s%s", g.httpAddr, gatewayPath)
+	case "grpc":
+		ln, err := net.Listen("tcp", g.conf.GRPCAddr)
+		if err != nil {
+			return err
+		}
+		g.grpcAddr = ln.Addr()
+		g.grpcServer = grpc.NewServer()
+		g.grpcServer.RegisterService(&gatewayServiceDesc, g)
+		go func() {
+			defer watchdog.LogOnPanic()
+			g.grpcServer.Serve(ln)
+		}()
+		log.Infof("Listening for traces over gRPC at %s", g.grpcAddr)
+	case "kafka":
+		c, err := newKafkaConsumer(g.conf)
+		if err != nil {
+			return err
+		}
+		g.consume(protocol, c)
+		log.Infof("Consuming traces from Kafka topic %s", g.conf.KafkaTopic)
+	case "nats":
+		c, err := newNATSConsumer(g.conf.NATSURL, g.conf.NATSSubject, g.conf.NATSQueue, g.conf.AuthTokens["nats"])
+		if err != nil {
+			return err
+		}
+		g.consume(protocol, c)
+		log.Infof("Consuming traces from NATS subject %s", g.conf.NATSSubject)
+	}
+	return nil
+}
+
+// Stop stops all the protocols.
+func (g *MultiProtocolGateway) Stop() {
+	if g.httpServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := g.httpServer.Shutdown(ctx); err != nil {
+			log.Errorf("Error stopping the HTTP gateway: %v", err)
+		}
+	}
+	if g.grpcServer != nil {
+		g.grpcServer.GracefulStop()
+	}
+	for _, c := range g.consumers {
+		if err := c.Close(); err != nil {
+			log.Errorf("Error stopping a gateway consumer: %v", err)
+		}
+	}
+	g.wg.Wait()
+}
+
+// consume processes the messages of c until it is closed.
+func (g *MultiProtocolGateway) consume(protocol string, c messageConsumer) {
+	g.consumers = append(g.consumers, c)
+	g.wg.Add(1)
+	go func() {
+		defer watchdog.LogOnPanic()
+		defer g.wg.Done()
+		for msg := range c.Messages() {
+			var traces pb.Traces
+			if err := msgp.Decode(bytes.NewReader(msg), &traces); err != nil {
+				log.Debugf("Dropping invalid %s message: %v", protocol, err)
+				metrics.Count("datadog.trace_agent.gateway.invalid_messages", 1, []string{"protocol:" + protocol}, 1)
+				continue
+			}
+			g.accept(protocol, traces)
+		}
+	}()
+}
+
+// accept passes the traces allowed by the rate limit of protocol to the
+// receiver, and returns how many were accepted.
+func (g *MultiProtocolGateway) accept(protocol string, traces pb.Traces) int {
+	tags := []string{"protocol:" + protocol}
+	if limiter := g.limiters[protocol]; limiter != nil {
+		allowed := traces[:0]
+		for _, t := range traces {
+			if limiter.Allow() {
+				allowed = append(allowed, t)
+			}
+		}
+		if dropped := len(traces) - len(allowed); dropped > 0 {
+			metrics.Count("datadog.trace_agent.gateway.rate_limited", int64(dropped), tags, 1)
+		}
+		traces = allowed
+	}
+	if len(traces) > 0 {
+		g.process(traces)
+	}
+	metrics.Count("datadog.trace_agent.gateway.traces", int64(len(traces)), tags, 1)
+	return len(traces)
+}
+
+// authorized reports whether the given Authorization header or metadata value
+// holds the token of protocol.
+func (g *MultiProtocolGateway) authorized(protocol, authorization string) bool {
+	token := g.conf.AuthTokens[protocol]
+	if token == "" {
+		return true
+	}
+	const prefix = "Bearer "
+	if !strings.HasPrefix(authorization, prefix) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(authorization, prefix)), []byte(token)) == 1
+}
+
+// handleHTTP handles POST /v0.4/traces, with traces encoded as in the v0.4 API
+// of the receiver.
+func (g *MultiProtocolGateway) handleHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost && req.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !g.authorized("http", req.Header.Get("Authorization")) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	req.Body = NewLimitedReader(req.Body, g.maxRequestBodyLength)
+	defer req.Body.Close()
+	var traces pb.Traces
+	if err := decodeRequest(req, &traces); err != nil {
+		http.Error(w, fmt.Sprintf("invalid payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	if g.accept("http", traces) == 0 && len(traces) > 0 {
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+		return
+	}
+	httpOK(w)
+}
+
+// gatewayServer is the interface of the gRPC service of the gateway.
+type gatewayServer interface {
+	sendTraces(ctx context.Context, payload *pb.TracePayload) error
+}
+
+// gatewayServiceDesc describes the datadog.trace.Gateway gRPC service, whose
+// SendTraces method receives a TracePayload and replies with an Empty message.
+var gatewayServiceDesc = grpc.ServiceDesc{
+	ServiceName: "datadog.trace.Gateway",
+	HandlerType: (*gatewayServer)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "SendTraces",
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+			var payload pb.TracePayload
+			if err := dec(&payload); err != nil {
+				return nil, err
+			}
+			return &types.Empty{}, srv.(gatewayServer).sendTraces(ctx, &payload)
+		},
+	}},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "gateway.proto",
+}
+
+// sendTraces implements gatewayServer.
+func (g *MultiProtocolGateway) sendTraces(ctx context.Context, payload *pb.TracePayload) error {
+	var authorization string
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md["authorization"]) > 0 {
+		authorization = md["authorization"][0]
+	}
+	if !g.authorized("grpc", authorization) {
+		return status.Error(codes.Unauthenticated, "invalid token")
+	}
+	traces := make(pb.Traces, 0, len(payload.Traces))
+	for _, t := range payload.Traces {
+		if len(t.Spans) > 0 {
+			traces = append(traces, t.Spans)
+		}
+	}
+	if g.accept("grpc", traces) == 0 && len(traces) > 0 {
+		return status.Error(codes.ResourceExhausted, "rate limited")
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/watchdog"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// kafkaRetryInterval is the delay before joining the consumer group again
+// after a session ended with an error.
+const kafkaRetryInterval = 2 * time.Second
+
+// kafkaConsumer consumes the messages of a Kafka topic, as part of a consumer
+// group. The offset of a message is committed once it is passed on, so that
+// the messages left when the agent stops are consumed by the next one.
+type kafkaConsumer struct {
+	group sarama.ConsumerGroup
+	topic string
+
+	out    chan []byte
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// newKafkaConsumer returns a consumer of the Kafka topic configured in conf,
+// whose brokers are first connected to before returning. The agent
+// authenticates with SASL/PLAIN as KafkaUser when a kafka token is set.
+func newKafkaConsumer(conf *config.GatewayConfig) (*kafkaConsumer, error) {
+	if len(conf.KafkaBrokers) == 0 {
+		return nil, errors.New("no Kafka brokers configured")
+	}
+	if conf.KafkaTopic == "" {
+		return nil, errors.New("no Kafka topic configured")
+	}
+	cfg := sarama.NewConfig()
+	cfg.ClientID = "datadog-trace-agent"
+	// consumer groups need brokers running Kafka 0.10.2 or later
+	cfg.Version = sarama.V0_10_2_0
+	if token := conf.AuthTokens["kafka"]; token != "" {
+		cfg.Net.SASL.Enable = true
+		cfg.Net.SASL.User = conf.KafkaUser
+		cfg.Net.SASL.Password = token
+	}
+	group, err := sarama.NewConsumerGroup(conf.KafkaBrokers, conf.KafkaGroup, cfg)
+	if err != nil {
+		return nil, err
+	}
+	c := &kafkaConsumer{
+		group: group,
+		topic: conf.KafkaTopic,
+		out:   make(chan []byte),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.wg.Add(1)
+	go func() {
+		defer watchdog.LogOnPanic()
+		defer c.wg.Done()
+		c.loop()
+	}()
+	return c, nil
+}
+
+// Messages implements messageConsumer.
+func (c *kafkaConsumer) Messages() <-chan []byte { return c.out }
+
+// Close implements messageConsumer. It commits the offsets of the messages
+// passed on.
+func (c *kafkaConsumer) Close() error {
+	c.cancel()
+	c.wg.Wait()
+	close(c.out)
+	return c.group.Close()
+}
+
+// loop consumes the topic until the consumer is closed, joining the group
+// again after each rebalance.
+func (c *kafkaConsumer) loop() {
+	for {
+		if err := c.group.Consume(c.ctx, []string{c.topic}, c); err != nil {
+			log.Warnf("Error consuming Kafka topic %s: %v", c.topic, err)
+			select {
+			case <-time.After(kafkaRetryInterval):
+			case <-c.ctx.Done():
+			}
+		}
+		if c.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// Setup implements sarama.ConsumerGroupHandler.
+func (c *kafkaConsumer) Setup(sarama.ConsumerGroupSession) error { return nil }
+
+// Cleanup implements sarama.ConsumerGroupHandler.
+func (c *kafkaConsumer) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+// ConsumeClaim implements sarama.ConsumerGroupHandler. It passes the payloads
+// of the messages of claim to out until the session ends.
+func (c *kafkaConsumer) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		select {
+		case c.out <- msg.Value:
+			sess.MarkMessage(msg, "")
+		case <-sess.Context().Done():
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	nats "github.com/nats-io/go-nats"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/watchdog"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+const (
+	// natsDialTimeout is the timeout of the connection to the NATS server.
+	natsDialTimeout = 5 * time.Second
+	// natsReconnectWait is the delay between two attempts to reconnect to the
+	// NATS server.
+	natsReconnectWait = 2 * time.Second
+	// natsPendingMessages is the number of messages buffered before they are
+	// consumed. The messages received while it is full are dropped.
+	natsPendingMessages = 100
+)
+
+// natsConsumer consumes the messages of a NATS subject, as part of a queue
+// group. The client reconnects when the connection is lost.
+type natsConsumer struct {
+	conn *nats.Conn
+	in   chan *nats.Msg
+
+	out  chan []byte
+	exit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newNATSConsumer returns a consumer of the messages of subject on the server
+// found at url, which is first connected to before returning.
+func newNATSConsumer(url, subject, queue, token string) (*natsConsumer, error) {
+	if subject == "" {
+		return nil, errors.New("no NATS subject configured")
+	}
+	conn, err := nats.Connect(url,
+		nats.Name("datadog-trace-agent"),
+		nats.Token(token),
+		nats.Timeout(natsDialTimeout),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(natsReconnectWait),
+		nats.DisconnectHandler(func(nc *nats.Conn) {
+			log.Warnf("Lost the connection to the NATS server %s: %v", url, nc.LastError())
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			log.Infof("Reconnected to the NATS server %s", nc.ConnectedUrl())
+		}),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			log.Warnf("Error consuming NATS messages: %v", err)
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	c := &natsConsumer{
+		conn: conn,
+		in:   make(chan *nats.Msg, natsPendingMessages),
+		out:  make(chan []byte),
+		exit: make(chan struct{}),
+	}
+	if _, err := conn.ChanQueueSubscribe(subject, queue, c.in); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.wg.Add(1)
+	go func() {
+		defer watchdog.LogOnPanic()
+		defer c.wg.Done()
+		c.loop()
+	}()
+	return c, nil
+}
+
+// Messages implements messageConsumer.
+func (c *natsConsumer) Messages() <-chan []byte { return c.out }
+
+// Close implements messageConsumer.
+func (c *natsConsumer) Close() error {
+	c.conn.Close()
+	close(c.exit)
+	c.wg.Wait()
+	close(c.out)
+	return nil
+}
+
+// loop passes the payloads of the received messages to out until the consumer
+// is closed.
+func (c *natsConsumer) loop() {
+	for {
+		select {
+		case msg := <-c.in:
+			select {
+			case c.out <- msg.Data:
+			case <-c.exit:
+				return
+			}
+		case <-c.exit:
+			return
+		}
+	}
+}
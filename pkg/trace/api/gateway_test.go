@@ -0,0 +1,403 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/gogo/protobuf/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tinylib/msgp/msgp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+)
+
+// testGatewayTraces returns n traces of a single span, with IDs starting at 1.
+func testGatewayTraces(n int) pb.Traces {
+	traces := make(pb.Traces, n)
+	for i := range traces {
+		traces[i] = pb.Trace{{TraceID: uint64(i + 1), SpanID: 1, Service: "web", Name: "http.request", Resource: "GET /"}}
+	}
+	return traces
+}
+
+// msgpackTraces returns the msgpack encoding of traces.
+func msgpackTraces(t *testing.T, traces pb.Traces) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, msgp.Encode(&buf, traces))
+	return buf.Bytes()
+}
+
+// testGatewaySink collects the traces processed by a gateway.
+type testGatewaySink struct {
+	mu     sync.Mutex
+	traces pb.Traces
+}
+
+func (s *testGatewaySink) process(traces pb.Traces) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.traces = append(s.traces, traces...)
+}
+
+// wait waits until n traces were processed, and returns their IDs.
+func (s *testGatewaySink) wait(t *testing.T, n int) []uint64 {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		count := len(s.traces)
+		s.mu.Unlock()
+		if count >= n || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]uint64, len(s.traces))
+	for i, t := range s.traces {
+		ids[i] = t[0].TraceID
+	}
+	return ids
+}
+
+func newTestGatewayConfig(protocol string) *config.GatewayConfig {
+	conf := config.New().Gateway
+	conf.Protocols = []string{protocol}
+	conf.HTTPAddr = "localhost:0"
+	conf.GRPCAddr = "localhost:0"
+	return conf
+}
+
+func TestGatewayHTTP(t *testing.T) {
+	conf := newTestGatewayConfig("http")
+	conf.AuthTokens = map[string]string{"http": "secret"}
+	conf.RateLimits = map[string]float64{"http": 1}
+	sink := &testGatewaySink{}
+	g := newMultiProtocolGateway(conf, sink.process)
+	g.Start()
+	defer g.Stop()
+	require.NotNil(t, g.httpAddr)
+	url := fmt.Sprintf("http://%s/v0.4/traces", g.httpAddr)
+
+	post := func(token string, traces pb.Traces) int {
+		req, err := http.NewRequest("POST", url, bytes.NewReader(msgpackTraces(t, traces)))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/msgpack")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, post("", testGatewayTraces(1)))
+	assert.Equal(t, http.StatusUnauthorized, post("wrong", testGatewayTraces(1)))
+	assert.Equal(t, http.StatusOK, post("secret", testGatewayTraces(2)))
+	assert.Equal(t, []uint64{1, 2}, sink.wait(t, 2))
+
+	// the burst of the limit is used up
+	assert.Equal(t, http.StatusTooManyRequests, post("secret", testGatewayTraces(5)))
+	assert.Len(t, sink.wait(t, 2), 2)
+
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestGatewayHTTPMaxBodyLength(t *testing.T) {
+	sink := &testGatewaySink{}
+	g := newMultiProtocolGateway(newTestGatewayConfig("http"), sink.process)
+	g.maxRequestBodyLength = 2
+	g.Start()
+	defer g.Stop()
+	require.NotNil(t, g.httpAddr)
+
+	resp, err := http.Post(fmt.Sprintf("http://%s/v0.4/traces", g.httpAddr), "application/msgpack", bytes.NewReader(msgpackTraces(t, testGatewayTraces(1))))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Empty(t, sink.wait(t, 0))
+}
+
+func TestGatewayGRPC(t *testing.T) {
+	conf := newTestGatewayConfig("grpc")
+	conf.AuthTokens = map[string]string{"grpc": "secret"}
+	sink := &testGatewaySink{}
+	g := newMultiProtocolGateway(conf, sink.process)
+	g.Start()
+	defer g.Stop()
+	require.NotNil(t, g.grpcAddr)
+
+	conn, err := grpc.Dial(g.grpcAddr.String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	payload := &pb.TracePayload{}
+	for _, trace := range testGatewayTraces(3) {
+		payload.Traces = append(payload.Traces, &pb.APITrace{TraceID: trace[0].TraceID, Spans: trace})
+	}
+	send := func(token string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if token != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+		}
+		return conn.Invoke(ctx, gatewayGRPCMethod, payload, &types.Empty{})
+	}
+
+	assert.Equal(t, codes.Unauthenticated, status.Code(send("")))
+	require.NoError(t, send("secret"))
+	assert.Equal(t, []uint64{1, 2, 3}, sink.wait(t, 3))
+	if assert.Len(t, sink.traces, 3) {
+		assert.Equal(t, "http.request", sink.traces[0][0].Name)
+	}
+}
+
+// natsConnectOptions are the options of the CONNECT message of the NATS
+// protocol checked by the tests.
+type natsConnectOptions struct {
+	Name      string `json:"name"`
+	AuthToken string `json:"auth_token"`
+}
+
+// mockNATSServer is a NATS server accepting a single connection, which sends
+// the given messages to the first subscription.
+type mockNATSServer struct {
+	ln       net.Listener
+	token    string
+	messages [][]byte
+
+	connect chan natsConnectOptions
+	sub     chan string
+	pong    chan struct{}
+}
+
+func newMockNATSServer(t *testing.T, token string, messages ...[]byte) *mockNATSServer {
+	ln, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	s := &mockNATSServer{
+		ln:       ln,
+		token:    token,
+		messages: messages,
+		connect:  make(chan natsConnectOptions, 1),
+		sub:      make(chan string, 1),
+		pong:     make(chan struct{}, 1),
+	}
+	go s.serve()
+	return s
+}
+
+func (s *mockNATSServer) url() string { return "nats://" + s.ln.Addr().String() }
+
+func (s *mockNATSServer) serve() {
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n")
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch {
+		case strings.HasPrefix(line, "CONNECT "):
+			var opts natsConnectOptions
+			json.Unmarshal([]byte(strings.TrimPrefix(line, "CONNECT ")), &opts)
+			s.connect <- opts
+			if opts.AuthToken != s.token {
+				fmt.Fprint(conn, "-ERR 'Authorization Violation'\r\n")
+				return
+			}
+		case strings.HasPrefix(line, "SUB "):
+			fields := strings.Fields(line)
+			s.sub <- strings.Join(fields, " ")
+			// ask the client whether it is alive, then send the messages
+			fmt.Fprint(conn, "PING\r\n")
+			for _, msg := range s.messages {
+				fmt.Fprintf(conn, "MSG datadog.traces %s %d\r\n%s\r\n", fields[len(fields)-1], len(msg), msg)
+			}
+		case strings.HasPrefix(line, "PING"):
+			fmt.Fprint(conn, "PONG\r\n")
+		case strings.HasPrefix(line, "PONG"):
+			s.pong <- struct{}{}
+		}
+	}
+}
+
+func TestGatewayNATS(t *testing.T) {
+	srv := newMockNATSServer(t, "secret", msgpackTraces(t, testGatewayTraces(2)), []byte("invalid"), msgpackTraces(t, testGatewayTraces(1)))
+	defer srv.ln.Close()
+
+	conf := newTestGatewayConfig("nats")
+	conf.NATSURL = srv.url()
+	conf.AuthTokens = map[string]string{"nats": "secret"}
+	sink := &testGatewaySink{}
+	g := newMultiProtocolGateway(conf, sink.process)
+	g.Start()
+	defer g.Stop()
+
+	assert.Equal(t, natsConnectOptions{Name: "datadog-trace-agent", AuthToken: "secret"}, <-srv.connect)
+	assert.Equal(t, "SUB datadog.traces datadog-trace-agent 1", <-srv.sub)
+	assert.Equal(t, []uint64{1, 2, 1}, sink.wait(t, 3), "invalid messages are skipped")
+	select {
+	case <-srv.pong:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server PING was not answered")
+	}
+}
+
+func TestGatewayNATSUnauthorized(t *testing.T) {
+	srv := newMockNATSServer(t, "secret")
+	defer srv.ln.Close()
+
+	_, err := newNATSConsumer(srv.url(), "datadog.traces", "datadog-trace-agent", "wrong")
+	if assert.Error(t, err) {
+		assert.Contains(t, strings.ToLower(err.Error()), "authorization violation")
+	}
+
+	_, err = newNATSConsumer(srv.url(), "", "", "secret")
+	assert.EqualError(t, err, "no NATS subject configured")
+	for _, url := range []string{"nats://", "%"} {
+		_, err := newNATSConsumer(url, "datadog.traces", "", "")
+		assert.Error(t, err, url)
+	}
+}
+
+// testKafkaSession is a consumer group session recording the offsets of the
+// marked messages.
+type testKafkaSession struct {
+	ctx    context.Context
+	marked []int64
+}
+
+func (s *testKafkaSession) Claims() map[string][]int32               { return nil }
+func (s *testKafkaSession) MemberID() string                         { return "agent" }
+func (s *testKafkaSession) GenerationID() int32                      { return 1 }
+func (s *testKafkaSession) MarkOffset(string, int32, int64, string)  {}
+func (s *testKafkaSession) ResetOffset(string, int32, int64, string) {}
+func (s *testKafkaSession) Context() context.Context                 { return s.ctx }
+func (s *testKafkaSession) MarkMessage(m *sarama.ConsumerMessage, _ string) {
+	s.marked = append(s.marked, m.Offset)
+}
+
+// testKafkaClaim is a claim of the first partition of the datadog-traces topic.
+type testKafkaClaim chan *sarama.ConsumerMessage
+
+func (c testKafkaClaim) Topic() string                            { return "datadog-traces" }
+func (c testKafkaClaim) Partition() int32                         { return 0 }
+func (c testKafkaClaim) InitialOffset() int64                     { return 0 }
+func (c testKafkaClaim) HighWaterMarkOffset() int64               { return 0 }
+func (c testKafkaClaim) Messages() <-chan *sarama.ConsumerMessage { return c }
+
+func TestGatewayKafka(t *testing.T) {
+	t.Run("config", func(t *testing.T) {
+		conf := newTestGatewayConfig("kafka")
+		_, err := newKafkaConsumer(conf)
+		assert.EqualError(t, err, "no Kafka brokers configured")
+		conf.KafkaBrokers = []string{"localhost:9092"}
+		conf.KafkaTopic = ""
+		_, err = newKafkaConsumer(conf)
+		assert.EqualError(t, err, "no Kafka topic configured")
+		conf.KafkaTopic = "datadog-traces"
+		conf.AuthTokens = map[string]string{"kafka": "secret"}
+		_, err = newKafkaConsumer(conf)
+		assert.EqualError(t, err, "kafka: invalid configuration (Net.SASL.User must not be empty when SASL is enabled)")
+	})
+
+	t.Run("claim", func(t *testing.T) {
+		c := &kafkaConsumer{out: make(chan []byte)}
+		ctx, cancel := context.WithCancel(context.Background())
+		sess := &testKafkaSession{ctx: ctx}
+		claim := make(testKafkaClaim, 3)
+		claim <- &sarama.ConsumerMessage{Offset: 10, Value: []byte("a")}
+		claim <- &sarama.ConsumerMessage{Offset: 11, Value: []byte("b")}
+		done := make(chan error)
+		go func() { done <- c.ConsumeClaim(sess, claim) }()
+		assert.Equal(t, []byte("a"), <-c.out)
+		assert.Equal(t, []byte("b"), <-c.out)
+
+		// the session ends while a message waits to be passed on
+		claim <- &sarama.ConsumerMessage{Offset: 12, Value: []byte("c")}
+		cancel()
+		select {
+		case err := <-done:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the claim was not released")
+		}
+		assert.Equal(t, []int64{10, 11}, sess.marked, "messages are marked once passed on")
+	})
+}
+
+func TestGatewayReceiver(t *testing.T) {
+	conf := newTestReceiverConfig()
+	conf.Gateway.Protocols = []string{"http", "unknown"}
+	conf.Gateway.HTTPAddr = "localhost:0"
+	r := newTestReceiverFromConfig(conf)
+	require.NotNil(t, r.gateway)
+	r.gateway.Start()
+	defer r.gateway.Stop()
+
+	resp, err := http.Post(fmt.Sprintf("http://%s/v0.4/traces", r.gateway.httpAddr), "application/msgpack", bytes.NewReader(msgpackTraces(t, testGatewayTraces(1))))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	select {
+	case trace := <-r.Out:
+		assert.EqualValues(t, 1, trace[0].TraceID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the trace was not received")
+	}
+
+	assert.Nil(t, newTestReceiverFromConfig(newTestReceiverConfig()).gateway)
+}
+
+func TestGatewayReceiverPipeline(t *testing.T) {
+	conf := newTestReceiverConfig()
+	conf.ReceiverPipeline.Enabled = true
+	conf.Gateway.Protocols = []string{"http"}
+	conf.Gateway.HTTPAddr = "localhost:0"
+	r := newTestReceiverFromConfig(conf)
+	require.NotNil(t, r.gateway)
+	var obfuscated int64
+	r.Pipeline.Obfuscate = func(_ uint64, _ *pb.Span) { atomic.AddInt64(&obfuscated, 1) }
+	r.Pipeline.Start()
+	r.gateway.Start()
+
+	resp, err := http.Post(fmt.Sprintf("http://%s/v0.4/traces", r.gateway.httpAddr), "application/msgpack", bytes.NewReader(msgpackTraces(t, testGatewayTraces(1))))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	select {
+	case trace := <-r.Out:
+		assert.EqualValues(t, 1, trace[0].TraceID)
+		assert.EqualValues(t, len(trace), atomic.LoadInt64(&obfuscated), "gateway traces are obfuscated by the pipeline")
+	case <-time.After(5 * time.Second):
+		t.Fatal("the trace was not received")
+	}
+	r.gateway.Stop()
+	r.Pipeline.Stop()
+}
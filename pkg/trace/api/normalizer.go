@@ -11,6 +11,7 @@ import (
 	"unicode"
 	"unicode/utf8"
 
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
 	"github.com/DataDog/datadog-agent/pkg/trace/info"
 	"github.com/DataDog/datadog-agent/pkg/trace/pb"
 	"github.com/DataDog/datadog-agent/pkg/trace/traceutil"
@@ -28,6 +29,8 @@ const (
 	DefaultServiceName = "service"
 	// DefaultSpanName is the default name we assign a span if it's missing and we have no reasonable fallback
 	DefaultSpanName = "service.trace"
+	// ReservedSyntheticsKey is the meta key set on the spans of the traces whose ID is reserved to synthetic monitoring
+	ReservedSyntheticsKey = "_dd.synthetics.reserved"
 )
 
 var (
@@ -186,6 +189,46 @@ func normalizeTrace(ts *info.TagStats, t pb.Trace) error {
 	return nil
 }
 
+// checkTraceAge rejects the trace if its oldest span started more than maxAge
+// before now, such as the traces submitted late by batch processing systems.
+// A zero maxAge disables the check.
+func checkTraceAge(ts *info.TagStats, t pb.Trace, maxAge time.Duration, now time.Time) error {
+	if maxAge <= 0 || len(t) == 0 {
+		return nil
+	}
+	start := t[0].Start
+	for _, span := range t[1:] {
+		if span.Start < start {
+			start = span.Start
+		}
+	}
+	if age := now.Sub(time.Unix(0, start)); age > maxAge {
+		atomic.AddInt64(&ts.TracesDropped.TooOld, 1)
+		return fmt.Errorf("trace is %s old, more than %s (reason:too_old)", age.Round(time.Second), maxAge)
+	}
+	return nil
+}
+
+// tagReservedSynthetics sets the ReservedSyntheticsKey tag of the spans of t
+// when its trace ID is in the range reserved to synthetic monitoring by conf,
+// and removes it otherwise. The origin of the spans is left untouched.
+func tagReservedSynthetics(conf *config.SyntheticsConfig, t pb.Trace) {
+	if len(t) == 0 || conf.TraceIDRangeEnd == 0 {
+		return
+	}
+	reserved := conf.InReservedRange(t[0].TraceID)
+	for _, s := range t {
+		if !reserved {
+			delete(s.Meta, ReservedSyntheticsKey)
+			continue
+		}
+		if s.Meta == nil {
+			s.Meta = make(map[string]string, 1)
+		}
+		s.Meta[ReservedSyntheticsKey] = "true"
+	}
+}
+
 func isValidStatusCode(sc string) bool {
 	if code, err := strconv.ParseUint(sc, 10, 64); err == nil {
 		return 100 <= code && code < 600
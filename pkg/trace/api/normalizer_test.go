@@ -6,8 +6,10 @@ import (
 	"math/rand"
 	"strings"
 	"testing"
+	"time"
 	"unicode"
 
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
 	"github.com/DataDog/datadog-agent/pkg/trace/info"
 	"github.com/DataDog/datadog-agent/pkg/trace/pb"
 	"github.com/stretchr/testify/assert"
@@ -391,6 +393,82 @@ func TestNormalizeTrace(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestCheckTraceAge(t *testing.T) {
+	now := time.Now()
+	maxAge := 90 * time.Minute
+	for _, tt := range []struct {
+		age     time.Duration
+		dropped bool
+	}{
+		{0, false},
+		{60 * time.Minute, false},
+		{120 * time.Minute, true},
+	} {
+		ts := newTagStats()
+		span1, span2 := newTestSpan(), newTestSpan()
+		span2.SpanID++
+		span1.Start = now.Add(-time.Minute).UnixNano()
+		span2.Start = now.Add(-tt.age).UnixNano()
+		err := checkTraceAge(ts, pb.Trace{span1, span2}, maxAge, now)
+		if tt.dropped {
+			assert.EqualError(t, err, "trace is 2h0m0s old, more than 1h30m0s (reason:too_old)")
+			assert.Equal(t, tsDropped(&info.TracesDropped{TooOld: 1}), ts)
+		} else {
+			assert.NoError(t, err, "%s", tt.age)
+			assert.Equal(t, newTagStats(), ts)
+		}
+
+		// disabled
+		assert.NoError(t, checkTraceAge(ts, pb.Trace{span1, span2}, 0, now))
+	}
+}
+
+func TestTagReservedSynthetics(t *testing.T) {
+	conf := &config.SyntheticsConfig{TraceIDRangeStart: 0xDEAD0000, TraceIDRangeEnd: 0xDEADFFFF}
+	for _, tt := range []struct {
+		traceID  uint64
+		reserved bool
+	}{
+		{0, false},
+		{0xDEAD0000 - 1, false},
+		{0xDEAD0000, true},
+		{0xDEAD8000, true},
+		{0xDEADFFFF, true},
+		{0xDEADFFFF + 1, false},
+		{math.MaxUint64, false},
+	} {
+		span1, span2 := newTestSpan(), newTestSpan()
+		span1.TraceID, span2.TraceID = tt.traceID, tt.traceID
+		span1.Meta["_dd.origin"] = "synthetics"
+		span1.Meta[ReservedSyntheticsKey] = "true" // set by the client
+		span2.Meta = nil
+		tagReservedSynthetics(conf, pb.Trace{span1, span2})
+		assert.Equal(t, "synthetics", span1.Meta["_dd.origin"], "the origin is kept")
+		for _, s := range []*pb.Span{span1, span2} {
+			reserved, ok := s.Meta[ReservedSyntheticsKey]
+			if tt.reserved {
+				assert.Equal(t, "true", reserved, "%#x", tt.traceID)
+			} else {
+				assert.False(t, ok, "%#x", tt.traceID)
+			}
+		}
+	}
+
+	// disabled range
+	span := newTestSpan()
+	span.TraceID = 0
+	tagReservedSynthetics(&config.SyntheticsConfig{}, pb.Trace{span})
+	assert.NotContains(t, span.Meta, ReservedSyntheticsKey)
+
+	// single trace ID range
+	conf = &config.SyntheticsConfig{TraceIDRangeStart: 42, TraceIDRangeEnd: 42}
+	assert.True(t, conf.InReservedRange(42))
+	assert.False(t, conf.InReservedRange(41))
+	assert.False(t, conf.InReservedRange(43))
+
+	tagReservedSynthetics(conf, pb.Trace{})
+}
+
 func TestIsValidStatusCode(t *testing.T) {
 	assert := assert.New(t)
 	assert.True(isValidStatusCode("100"))
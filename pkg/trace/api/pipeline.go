@@ -0,0 +1,174 @@
+package api
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/info"
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics/timing"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/watchdog"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// rawPayload is a trace payload read from a request and waiting to be decoded.
+type rawPayload struct {
+	version    Version
+	header     http.Header
+	body       []byte
+	traceCount int64
+	ts         *info.TagStats
+	// configVersion is the config version the payload was stamped with when
+	// added to the pipeline.
+	configVersion uint64
+}
+
+// statsTrace is a trace along with the stats of the payload it was received in.
+type statsTrace struct {
+	ts            *info.TagStats
+	trace         pb.Trace
+	configVersion uint64
+}
+
+// AsyncProcessingPipeline decouples receiving payloads from processing them. Raw
+// payloads go through three stages, each backed by a buffered queue and a fixed
+// pool of workers: decoding, normalization and obfuscation, before being sent
+// to the receiver's output channel.
+type AsyncProcessingPipeline struct {
+	// Obfuscate, when set, is called on every span in the obfuscation stage,
+	// with the config version of its trace.
+	Obfuscate func(version uint64, s *pb.Span)
+	// ConfigVersion, when set, returns the config version the payloads are
+	// stamped with when added, so that all the stages process their traces
+	// with the same config.
+	ConfigVersion func() uint64
+
+	r       *HTTPReceiver
+	workers int
+
+	decodeQueue    chan *rawPayload
+	normalizeQueue chan statsTrace
+	obfuscateQueue chan statsTrace
+
+	decodeWG    sync.WaitGroup
+	normalizeWG sync.WaitGroup
+	obfuscateWG sync.WaitGroup
+}
+
+// newAsyncProcessingPipeline returns a new pipeline for the receiver r.
+func newAsyncProcessingPipeline(r *HTTPReceiver, conf *config.PipelineConfig) *AsyncProcessingPipeline {
+	workers := conf.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return &AsyncProcessingPipeline{
+		r:              r,
+		workers:        workers,
+		decodeQueue:    make(chan *rawPayload, conf.DecodeQueueSize),
+		normalizeQueue: make(chan statsTrace, conf.NormalizeQueueSize),
+		obfuscateQueue: make(chan statsTrace, conf.ObfuscateQueueSize),
+	}
+}
+
+// Start starts the workers of all the stages.
+func (p *AsyncProcessingPipeline) Start() {
+	for i := 0; i < p.workers; i++ {
+		p.startWorker(&p.decodeWG, p.decodeWorker)
+		p.startWorker(&p.normalizeWG, p.normalizeWorker)
+		p.startWorker(&p.obfuscateWG, p.obfuscateWorker)
+	}
+}
+
+func (p *AsyncProcessingPipeline) startWorker(wg *sync.WaitGroup, fn func()) {
+	wg.Add(1)
+	go func() {
+		defer func() {
+			wg.Done()
+			watchdog.LogOnPanic()
+		}()
+		fn()
+	}()
+}
+
+// Stop drains the pipeline, stage by stage. No payloads must be added after it is called.
+func (p *AsyncProcessingPipeline) Stop() {
+	close(p.decodeQueue)
+	p.decodeWG.Wait()
+	close(p.normalizeQueue)
+	p.normalizeWG.Wait()
+	close(p.obfuscateQueue)
+	p.obfuscateWG.Wait()
+}
+
+// Add queues a payload for decoding. It blocks when the decode queue is full.
+func (p *AsyncProcessingPipeline) Add(payload *rawPayload) {
+	if p.ConfigVersion != nil {
+		payload.configVersion = p.ConfigVersion()
+	}
+	p.decodeQueue <- payload
+}
+
+// AddTraces queues already decoded traces for normalization, so that they go
+// through the same stages as the payloads. It blocks when the normalize queue
+// is full.
+func (p *AsyncProcessingPipeline) AddTraces(ts *info.TagStats, traces pb.Traces) {
+	var version uint64
+	if p.ConfigVersion != nil {
+		version = p.ConfigVersion()
+	}
+	for _, t := range traces {
+		p.normalizeQueue <- statsTrace{ts: ts, trace: t, configVersion: version}
+	}
+}
+
+func (p *AsyncProcessingPipeline) decodeWorker() {
+	for payload := range p.decodeQueue {
+		req := &http.Request{Header: payload.header, Body: ioutil.NopCloser(bytes.NewReader(payload.body))}
+		start := time.Now()
+		traces, err := p.r.decodeTraces(payload.version, req)
+		if err != nil {
+			atomic.AddInt64(&payload.ts.TracesDropped.DecodingError, payload.traceCount)
+			log.Errorf("Cannot decode %s traces payload: %v", payload.version, err)
+			continue
+		}
+		if p.r.Profiler != nil {
+			p.r.Profiler.Since(timing.StageDecode, start)
+		}
+		atomic.AddInt64(&payload.ts.TracesReceived, int64(len(traces)))
+		for _, t := range traces {
+			p.normalizeQueue <- statsTrace{ts: payload.ts, trace: t, configVersion: payload.configVersion}
+		}
+	}
+}
+
+func (p *AsyncProcessingPipeline) normalizeWorker() {
+	for st := range p.normalizeQueue {
+		now := time.Now()
+		ok := p.r.receiveTrace(st.ts, st.trace)
+		timing.Since("datadog.trace_agent.internal.normalize_ms", now)
+		if ok {
+			p.obfuscateQueue <- st
+		}
+	}
+}
+
+func (p *AsyncProcessingPipeline) obfuscateWorker() {
+	for st := range p.obfuscateQueue {
+		if p.Obfuscate != nil {
+			start := time.Now()
+			for _, s := range st.trace {
+				p.Obfuscate(st.configVersion, s)
+			}
+			if p.r.Profiler != nil {
+				p.r.Profiler.Since(timing.StageObfuscate, start)
+			}
+		}
+		p.r.send(st.trace)
+	}
+}
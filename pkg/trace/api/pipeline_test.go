@@ -0,0 +1,110 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/info"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/test/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/tinylib/msgp/msgp"
+)
+
+func newTestPipelineReceiver(workers int) *HTTPReceiver {
+	conf := newTestReceiverConfig()
+	conf.ReceiverPipeline.Enabled = true
+	conf.ReceiverPipeline.Workers = workers
+	return newTestReceiverFromConfig(conf)
+}
+
+func TestAsyncProcessingPipeline(t *testing.T) {
+	assert := assert.New(t)
+
+	receiver := newTestPipelineReceiver(2)
+	if !assert.NotNil(receiver.Pipeline) {
+		return
+	}
+	var obfuscated int64
+	receiver.Pipeline.ConfigVersion = func() uint64 { return 7 }
+	receiver.Pipeline.Obfuscate = func(version uint64, _ *pb.Span) {
+		assert.EqualValues(7, version, "spans are obfuscated with the config version of their payload")
+		atomic.AddInt64(&obfuscated, 1)
+	}
+	receiver.Pipeline.Start()
+
+	var buf bytes.Buffer
+	msgp.Encode(&buf, testutil.GetTestTraces(10, 10, true))
+	handler := http.HandlerFunc(receiver.httpHandleWithVersion(v04, receiver.handleTraces))
+
+	for _, body := range [][]byte{buf.Bytes(), []byte("not msgpack")} {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v0.4/traces", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/msgpack")
+		req.Header.Set(headerTraceCount, "10")
+		req.Header.Set("Datadog-Meta-Lang", "python")
+		handler.ServeHTTP(rr, req)
+		assert.Equal(http.StatusOK, rr.Code, "payloads are decoded asynchronously")
+	}
+
+	receiver.Pipeline.Stop()
+	close(receiver.Out)
+	var traces, spans int
+	for trace := range receiver.Out {
+		traces++
+		spans += len(trace)
+	}
+	assert.Equal(10, traces)
+	assert.EqualValues(spans, atomic.LoadInt64(&obfuscated))
+
+	ts := receiver.Stats.GetTagStats(info.Tags{Lang: "python"})
+	assert.EqualValues(10, ts.TracesReceived)
+	assert.EqualValues(spans, ts.SpansReceived)
+	assert.EqualValues(10, ts.TracesDropped.DecodingError)
+	assert.EqualValues(2, ts.PayloadAccepted)
+}
+
+// benchmarkEndToEnd measures the time it takes for payloads to go from the traces
+// handler to the receiver's output channel.
+func benchmarkEndToEnd(b *testing.B, pipeline bool) {
+	var buf bytes.Buffer
+	msgp.Encode(&buf, testutil.GetTestTraces(10, 10, true))
+
+	conf := newTestReceiverConfig()
+	conf.ReceiverPipeline.Enabled = pipeline
+	receiver := newTestReceiverFromConfig(conf)
+	if pipeline {
+		receiver.Pipeline.Start()
+	}
+	handler := http.HandlerFunc(receiver.httpHandleWithVersion(v04, receiver.handleTraces))
+
+	done := make(chan struct{})
+	go func() {
+		for n := 0; n < 10*b.N; n++ {
+			<-receiver.Out
+		}
+		close(done)
+	}()
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v0.4/traces", bytes.NewReader(buf.Bytes()))
+		req.Header.Set("Content-Type", "application/msgpack")
+		handler.ServeHTTP(rr, req)
+	}
+	<-done
+	b.StopTimer()
+
+	if pipeline {
+		receiver.Pipeline.Stop()
+	}
+}
+
+func BenchmarkEndToEndGoroutinePerRequest(b *testing.B) { benchmarkEndToEnd(b, false) }
+
+func BenchmarkEndToEndAsyncPipeline(b *testing.B) { benchmarkEndToEnd(b, true) }
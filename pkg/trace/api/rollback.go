@@ -0,0 +1,50 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+const (
+	// rollbackPath is the path of the endpoint toggling the rollback mode,
+	// during which the traces of canary deployments are deprioritized.
+	rollbackPath = "/v0.4/rollback"
+
+	tagRollbackHandler = "handler:rollback"
+)
+
+// rollbackState is the body of the requests and responses of the rollback
+// endpoint.
+type rollbackState struct {
+	Active *bool `json:"active"`
+}
+
+// handleRollback sets whether a rollback is in progress from a JSON object such
+// as {"active": true}, and replies with the new state.
+func (r *HTTPReceiver) handleRollback(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPut {
+		w.Header().Set("Allow", http.MethodPut)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var state rollbackState
+	if err := json.NewDecoder(req.Body).Decode(&state); err != nil {
+		httpDecodingError(err, []string{tagRollbackHandler}, w)
+		return
+	}
+	if state.Active == nil {
+		httpDecodingError(errors.New(`missing "active" field`), []string{tagRollbackHandler}, w)
+		return
+	}
+	if active := *state.Active; active != r.dynConf.Rollback.Active() {
+		r.dynConf.Rollback.Set(active)
+		log.Infof("Rollback mode set to %t", active)
+		metrics.Count("datadog.trace_agent.receiver.rollback_toggled", 1, nil, 1)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
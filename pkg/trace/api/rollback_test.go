@@ -0,0 +1,73 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleRollback(t *testing.T) {
+	r := newTestReceiverFromConfig(newTestReceiverConfig())
+	handler := r.httpHandle(r.handleRollback)
+
+	for _, tt := range []struct {
+		name   string
+		method string
+		body   string
+		code   int
+		active bool
+	}{
+		{"start", "PUT", `{"active": true}`, http.StatusOK, true},
+		{"again", "PUT", `{"active": true}`, http.StatusOK, true},
+		{"method", "POST", `{"active": false}`, http.StatusMethodNotAllowed, true},
+		{"missing", "PUT", `{}`, http.StatusBadRequest, true},
+		{"invalid", "PUT", `{"active": "no"}`, http.StatusBadRequest, true},
+		{"stop", "PUT", `{"active": false}`, http.StatusOK, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, rollbackPath, strings.NewReader(tt.body))
+			handler(rr, req)
+			assert.Equal(t, tt.code, rr.Code)
+			assert.Equal(t, tt.active, r.dynConf.Rollback.Active())
+		})
+	}
+}
+
+func TestRollbackRoute(t *testing.T) {
+	put := func(t *testing.T, pattern string) (*HTTPReceiver, *http.Response, []byte) {
+		conf := newTestReceiverConfig()
+		conf.ReceiverPort = 8327
+		conf.Sampler.CanaryVersionPattern = pattern
+		r := newTestReceiverFromConfig(conf)
+		r.Start()
+		defer r.Stop()
+
+		req, _ := http.NewRequest("PUT", "http://localhost:8327/v0.4/rollback", strings.NewReader(`{"active": true}`))
+		req.Close = true
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return r, resp, body
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		r, resp, body := put(t, "-canary$")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.JSONEq(t, `{"active": true}`, string(body))
+		assert.True(t, r.dynConf.Rollback.Active())
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		r, resp, _ := put(t, "")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.False(t, r.dynConf.Rollback.Active())
+	})
+}
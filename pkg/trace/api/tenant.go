@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+)
+
+const (
+	// TenantOrgKey is the meta key holding the organization of the tenant a
+	// span was received from.
+	TenantOrgKey = "_dd.tenant.org_id"
+
+	// headerAPIKey is the header holding the API key of the sender of a payload.
+	headerAPIKey = "DD-API-KEY"
+)
+
+// TenantRouter routes the traces received from tenants to their organization,
+// based on the API key of the payloads they are received in. The spans of the
+// traces of a tenant are tagged with its organization, using TenantOrgKey.
+type TenantRouter struct {
+	conf *config.MultiTenantConfig
+}
+
+// newTenantRouter returns a new router for the tenants of conf.
+func newTenantRouter(conf *config.MultiTenantConfig) *TenantRouter {
+	return &TenantRouter{conf: conf}
+}
+
+// Route tags the spans of traces with the organization of the tenant whose API
+// key is in header. Any organization set by the sender is removed first, so
+// that traces of unknown tenants, or without an API key, are never routed.
+func (tr *TenantRouter) Route(header http.Header, traces pb.Traces) {
+	tenant, ok := tr.conf.Match(header.Get(headerAPIKey))
+	for _, trace := range traces {
+		for _, span := range trace {
+			if span == nil {
+				continue
+			}
+			if !ok {
+				delete(span.Meta, TenantOrgKey)
+				continue
+			}
+			if span.Meta == nil {
+				span.Meta = make(map[string]string)
+			}
+			span.Meta[TenantOrgKey] = tenant.OrgID
+		}
+	}
+}
+
+// Tenant returns the mapping of the tenant of the given organization.
+func (tr *TenantRouter) Tenant(orgID string) (config.TenantMapping, bool) {
+	for _, m := range tr.conf.TenantMapping {
+		if m.OrgID == orgID {
+			return m, true
+		}
+	}
+	return config.TenantMapping{}, false
+}
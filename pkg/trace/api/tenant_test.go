@@ -0,0 +1,80 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantRouter(t *testing.T) {
+	assert := assert.New(t)
+	conf := newTestReceiverConfig()
+	conf.MultiTenant.TenantMapping = []config.TenantMapping{
+		{APIKeyPrefix: "aaaa", OrgID: "1001", Endpoint: "https://a.example.com"},
+		{APIKeyPrefix: "bbbb", OrgID: "1002", Endpoint: "https://b.example.com"},
+		{APIKeyPrefix: "cccc", OrgID: "1003", Endpoint: "https://c.example.com"},
+	}
+	receiver := newTestReceiverFromConfig(conf)
+	if !assert.NotNil(receiver.Tenants) {
+		return
+	}
+	handler := http.HandlerFunc(receiver.httpHandleWithVersion(v04, receiver.handleTraces))
+
+	send := func(apiKey string, meta string) pb.Trace {
+		body := []byte(`[[{"trace_id":1,"span_id":1,"service":"web","name":"http.request","resource":"GET /","duration":1` + meta + `}]]`)
+		req, err := http.NewRequest("POST", "/v0.4/traces", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if apiKey != "" {
+			req.Header.Set(headerAPIKey, apiKey)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(http.StatusOK, rr.Code)
+		return <-receiver.Out
+	}
+
+	for key, org := range map[string]string{
+		"aaaa0001": "1001",
+		"bbbb0002": "1002",
+		"cccc0003": "1003",
+	} {
+		trace := send(key, "")
+		if assert.Len(trace, 1) {
+			assert.Equal(org, trace[0].Meta[TenantOrgKey])
+		}
+	}
+
+	for _, key := range []string{"dddd0004", ""} {
+		trace := send(key, "")
+		if assert.Len(trace, 1) {
+			assert.NotContains(trace[0].Meta, TenantOrgKey)
+		}
+	}
+
+	// the organization set by the sender is never trusted
+	spoofed := `,"meta":{"_dd.tenant.org_id":"1002"}`
+	for _, key := range []string{"dddd0004", ""} {
+		trace := send(key, spoofed)
+		if assert.Len(trace, 1) {
+			assert.NotContains(trace[0].Meta, TenantOrgKey)
+		}
+	}
+	trace := send("aaaa0001", spoofed)
+	if assert.Len(trace, 1) {
+		assert.Equal("1001", trace[0].Meta[TenantOrgKey])
+	}
+
+	tenant, ok := receiver.Tenants.Tenant("1002")
+	assert.True(ok)
+	assert.Equal("https://b.example.com", tenant.Endpoint)
+	_, ok = receiver.Tenants.Tenant("1004")
+	assert.False(ok)
+}
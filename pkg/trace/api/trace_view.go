@@ -0,0 +1,241 @@
+package api
+
+import (
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/traceutil"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+const (
+	// traceViewPrefix prefixes the path of the trace viewer, which is
+	// /debug/traces/{traceID}/view.
+	traceViewPrefix = "/debug/traces/"
+
+	// maxLiveTraces is the number of recently received traces kept in debug
+	// mode to be viewed.
+	maxLiveTraces = 1000
+)
+
+var traceViewTemplate = template.Must(template.New("trace_view").Parse(traceViewTmplSrc))
+
+// liveTraceStore keeps the most recently received traces, by trace ID, so
+// that they can be viewed in debug mode. The spans of a trace received in
+// multiple payloads are gathered.
+type liveTraceStore struct {
+	mu     sync.Mutex
+	traces map[uint64]pb.Trace
+	order  []uint64 // trace IDs, in the order they were received
+	next   int      // index of the next ID to replace in order, once full
+}
+
+// newLiveTraceStore returns a new liveTraceStore keeping up to size traces.
+func newLiveTraceStore(size int) *liveTraceStore {
+	return &liveTraceStore{
+		traces: make(map[uint64]pb.Trace, size),
+		order:  make([]uint64, 0, size),
+	}
+}
+
+// Add stores a copy of t, evicting the oldest trace if the store is full. The
+// spans are copied as they are modified further down the pipeline.
+func (s *liveTraceStore) Add(t pb.Trace) {
+	if len(t) == 0 {
+		return
+	}
+	id := t[0].TraceID
+	spans := make(pb.Trace, len(t))
+	for i, span := range t {
+		cp := *span
+		cp.Meta = make(map[string]string, len(span.Meta))
+		for k, v := range span.Meta {
+			cp.Meta[k] = v
+		}
+		cp.Metrics = nil
+		spans[i] = &cp
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if previous, ok := s.traces[id]; ok {
+		s.traces[id] = append(previous, spans...)
+		return
+	}
+	if len(s.order) < cap(s.order) {
+		s.order = append(s.order, id)
+	} else {
+		delete(s.traces, s.order[s.next])
+		s.order[s.next] = id
+		s.next = (s.next + 1) % len(s.order)
+	}
+	s.traces[id] = spans
+}
+
+// Get returns a copy of the spans of the trace identified by id.
+func (s *liveTraceStore) Get(id uint64) (pb.Trace, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.traces[id]
+	if !ok {
+		return nil, false
+	}
+	return append(pb.Trace(nil), t...), true
+}
+
+// traceViewSpan is a span as rendered by the trace viewer. IDs are strings as
+// they don't fit in JavaScript numbers.
+type traceViewSpan struct {
+	SpanID   string            `json:"span_id"`
+	ParentID string            `json:"parent_id"`
+	Service  string            `json:"service"`
+	Name     string            `json:"name"`
+	Resource string            `json:"resource"`
+	Start    int64             `json:"start"`
+	Duration int64             `json:"duration"`
+	Error    bool              `json:"error"`
+	Meta     map[string]string `json:"meta,omitempty"`
+}
+
+// ServeHTTP serves GET /debug/traces/{traceID}/view, an HTML page rendering
+// the spans of the trace as a waterfall chart.
+func (s *liveTraceStore) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.TrimPrefix(req.URL.Path, traceViewPrefix)
+	if !strings.HasSuffix(path, "/view") {
+		http.NotFound(w, req)
+		return
+	}
+	id, err := strconv.ParseUint(strings.TrimSuffix(path, "/view"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid trace ID", http.StatusBadRequest)
+		return
+	}
+	t, ok := s.Get(id)
+	if !ok {
+		http.Error(w, "unknown trace, it may not have been received recently", http.StatusNotFound)
+		return
+	}
+
+	spans := make([]traceViewSpan, len(t))
+	for i, span := range t {
+		spans[i] = traceViewSpan{
+			SpanID:   strconv.FormatUint(span.SpanID, 10),
+			ParentID: strconv.FormatUint(span.ParentID, 10),
+			Service:  span.Service,
+			Name:     span.Name,
+			Resource: span.Resource,
+			Start:    span.Start,
+			Duration: span.Duration,
+			Error:    span.Error != 0,
+			Meta:     make(map[string]string, len(span.Meta)),
+		}
+		for k, v := range span.Meta {
+			// show binary values re-encoded by the writer in their original form
+			spans[i].Meta[k] = traceutil.DecompressBinaryTag(v)
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = traceViewTemplate.Execute(w, struct {
+		TraceID string
+		Spans   []traceViewSpan
+	}{strconv.FormatUint(id, 10), spans})
+	if err != nil {
+		log.Errorf("Error rendering trace %d: %v", id, err)
+	}
+}
+
+const traceViewTmplSrc = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Trace {{.TraceID}}</title>
+<style>
+  body { font-family: sans-serif; font-size: 13px; margin: 16px; }
+  .row { display: flex; align-items: center; height: 22px; cursor: pointer; }
+  .row:hover { background: #f2f2f2; }
+  .label { width: 360px; flex: none; overflow: hidden; white-space: nowrap; text-overflow: ellipsis; }
+  .timeline { position: relative; flex: 1; height: 14px; }
+  .bar { position: absolute; height: 100%; min-width: 1px; background: #632ca6; }
+  .bar.error { background: #e03c3c; }
+  .duration { width: 90px; flex: none; text-align: right; color: #666; }
+  pre { background: #f7f7f7; padding: 8px; }
+</style>
+</head>
+<body>
+<h1>Trace {{.TraceID}}</h1>
+<div id="waterfall"></div>
+<pre id="details">Click a span to see its tags.</pre>
+<script>
+  var spans = {{.Spans}};
+
+  function formatDuration(ns) {
+    if (ns >= 1e9) return (ns / 1e9).toFixed(2) + " s";
+    if (ns >= 1e6) return (ns / 1e6).toFixed(2) + " ms";
+    return (ns / 1e3).toFixed(0) + " µs";
+  }
+
+  // order the spans depth first, children by start time
+  var byID = {}, children = {}, roots = [];
+  spans.forEach(function (s) { byID[s.span_id] = s; });
+  spans.forEach(function (s) {
+    if (s.parent_id !== "0" && byID[s.parent_id] && s.parent_id !== s.span_id) {
+      (children[s.parent_id] = children[s.parent_id] || []).push(s);
+    } else {
+      roots.push(s);
+    }
+  });
+  var ordered = [], visited = {};
+  function visit(s, depth) {
+    if (visited[s.span_id]) return;
+    visited[s.span_id] = true;
+    ordered.push({ span: s, depth: depth });
+    (children[s.span_id] || []).sort(function (a, b) { return a.start - b.start; })
+      .forEach(function (c) { visit(c, depth + 1); });
+  }
+  roots.sort(function (a, b) { return a.start - b.start; }).forEach(function (s) { visit(s, 0); });
+
+  var min = Infinity, max = -Infinity;
+  spans.forEach(function (s) {
+    min = Math.min(min, s.start);
+    max = Math.max(max, s.start + s.duration);
+  });
+  var total = Math.max(max - min, 1);
+
+  var waterfall = document.getElementById("waterfall");
+  var details = document.getElementById("details");
+  ordered.forEach(function (e) {
+    var s = e.span;
+    var row = document.createElement("div");
+    row.className = "row";
+    var label = document.createElement("div");
+    label.className = "label";
+    label.style.paddingLeft = (e.depth * 12) + "px";
+    label.textContent = s.service + " " + s.name + " " + s.resource;
+    label.title = label.textContent;
+    var timeline = document.createElement("div");
+    timeline.className = "timeline";
+    var bar = document.createElement("div");
+    bar.className = s.error ? "bar error" : "bar";
+    bar.style.left = ((s.start - min) / total * 100) + "%";
+    bar.style.width = (s.duration / total * 100) + "%";
+    timeline.appendChild(bar);
+    var duration = document.createElement("div");
+    duration.className = "duration";
+    duration.textContent = formatDuration(s.duration);
+    row.appendChild(label);
+    row.appendChild(timeline);
+    row.appendChild(duration);
+    row.onclick = function () { details.textContent = JSON.stringify(s, null, 2); };
+    waterfall.appendChild(row);
+  });
+</script>
+</body>
+</html>
+`
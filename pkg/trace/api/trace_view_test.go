@@ -0,0 +1,85 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceView(t *testing.T) {
+	assert := assert.New(t)
+	conf := newTestReceiverConfig()
+	conf.LogLevel = "debug"
+	receiver := newTestReceiverFromConfig(conf)
+	if !assert.NotNil(receiver.liveTraces) {
+		return
+	}
+	mux := http.NewServeMux()
+	receiver.attachDebugHandlers(mux)
+
+	receiver.processTraces(newTagStats(), pb.Traces{
+		{
+			{TraceID: 42, SpanID: 1, Service: "web", Name: "http.request", Resource: "GET /users", Start: 1e9, Duration: 5e6},
+			{TraceID: 42, SpanID: 2, ParentID: 1, Service: "db", Name: "postgres.query", Resource: "SELECT * FROM users", Start: 1e9 + 1e6, Duration: 2e6, Error: 1},
+		},
+	})
+	// the second part of the trace is gathered with the first one
+	receiver.processTraces(newTagStats(), pb.Traces{
+		{{TraceID: 42, SpanID: 3, ParentID: 1, Service: "cache", Name: "redis.command", Resource: "GET <script>", Start: 1e9 + 3e6, Duration: 1e6, Meta: map[string]string{"tls.peer_id": `<~hQ=N\~>`}}},
+	})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	rec := get("/debug/traces/42/view")
+	assert.Equal(http.StatusOK, rec.Code)
+	assert.Equal("text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	body := rec.Body.String()
+	assert.Contains(body, "<title>Trace 42</title>")
+	for _, s := range []string{`"span_id":"2"`, `"parent_id":"1"`, `"service":"db"`, `"resource":"SELECT * FROM users"`, `"error":true`, `"service":"cache"`, `"tls.peer_id":"deadbeef"`} {
+		assert.Contains(body, s)
+	}
+	assert.False(strings.Contains(body, "GET <script>"), "span data is escaped")
+
+	assert.Equal(http.StatusNotFound, get("/debug/traces/43/view").Code)
+	assert.Equal(http.StatusBadRequest, get("/debug/traces/abc/view").Code)
+	assert.Equal(http.StatusNotFound, get("/debug/traces/42").Code)
+}
+
+func TestTraceViewDisabled(t *testing.T) {
+	receiver := newTestReceiverFromConfig(newTestReceiverConfig())
+	assert.Nil(t, receiver.liveTraces)
+
+	mux := http.NewServeMux()
+	receiver.attachDebugHandlers(mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/traces/42/view", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestLiveTraceStore(t *testing.T) {
+	assert := assert.New(t)
+	s := newLiveTraceStore(2)
+	for id := uint64(1); id <= 3; id++ {
+		s.Add(pb.Trace{{TraceID: id, SpanID: 1}})
+	}
+	_, ok := s.Get(1)
+	assert.False(ok, "the oldest trace is evicted")
+	for _, id := range []uint64{2, 3} {
+		_, ok := s.Get(id)
+		assert.True(ok)
+	}
+	s.Add(pb.Trace{{TraceID: 4, SpanID: 1}})
+	_, ok = s.Get(2)
+	assert.False(ok)
+	trace, ok := s.Get(4)
+	assert.True(ok)
+	assert.Len(trace, 1)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -85,6 +86,101 @@ type ReplaceRule struct {
 	Repl string `mapstructure:"repl"`
 }
 
+// ServiceMapConfig specifies the configuration of the service map.
+type ServiceMapConfig struct {
+	// GroupingRules specifies the rules used to group services into logical
+	// groups, to simplify the service map. The first matching rule applies.
+	GroupingRules []*ServiceGroupingRule
+
+	// HierarchyLabels lists the root span tags services are grouped by on the
+	// service hierarchy, outermost first (e.g. ["domain", "team"]). An empty
+	// list disables the hierarchy.
+	HierarchyLabels []string
+}
+
+// Group returns the name of the group of service, or service itself when it
+// matches no grouping rule.
+func (c *ServiceMapConfig) Group(service string) string {
+	if g, ok := c.GroupOf(service); ok {
+		return g
+	}
+	return service
+}
+
+// GroupOf returns the name of the group of service, and whether it matches a
+// grouping rule.
+func (c *ServiceMapConfig) GroupOf(service string) (string, bool) {
+	for _, r := range c.GroupingRules {
+		if r.Re.MatchString(service) {
+			return r.GroupName, true
+		}
+	}
+	return "", false
+}
+
+// ServiceGroupingRule specifies a rule grouping services on the service map.
+type ServiceGroupingRule struct {
+	// Pattern specifies the regexp pattern services are matched against. It must compile.
+	Pattern string `mapstructure:"pattern"`
+
+	// Re holds the compiled Pattern and is only used internally.
+	Re *regexp.Regexp `mapstructure:"-"`
+
+	// GroupName specifies the name of the group matching services are replaced by.
+	GroupName string `mapstructure:"group_name"`
+}
+
+// MultiTenantConfig specifies the routing of the traces of tenants to their
+// own Datadog organizations.
+type MultiTenantConfig struct {
+	// TenantMapping lists the tenants, by the prefix of their API key.
+	TenantMapping []TenantMapping
+
+	// TracesBudgetPerCustomerPerMinute is the number of traces each customer
+	// can send per minute before their traces are sampled at a low rate. It
+	// is disabled when 0.
+	TracesBudgetPerCustomerPerMinute int64
+
+	// CustomerIDTag is the tag of root spans holding the ID of the customer
+	// a trace belongs to.
+	CustomerIDTag string
+}
+
+// Match returns the tenant using apiKey, and whether there is one. The tenant
+// with the longest matching prefix wins.
+func (c *MultiTenantConfig) Match(apiKey string) (TenantMapping, bool) {
+	var (
+		match TenantMapping
+		found bool
+	)
+	for _, m := range c.TenantMapping {
+		if m.APIKeyPrefix == "" || !strings.HasPrefix(apiKey, m.APIKeyPrefix) {
+			continue
+		}
+		if !found || len(m.APIKeyPrefix) > len(match.APIKeyPrefix) {
+			match, found = m, true
+		}
+	}
+	return match, found
+}
+
+// TenantMapping routes the traces sent with an API key to the organization of
+// a tenant.
+type TenantMapping struct {
+	// APIKeyPrefix is the prefix of the API keys of the tenant.
+	APIKeyPrefix string `mapstructure:"api_key_prefix"`
+
+	// OrgID is the ID of the Datadog organization of the tenant.
+	OrgID string `mapstructure:"org_id"`
+
+	// Endpoint is the intake the traces of the tenant are sent to.
+	Endpoint string `mapstructure:"endpoint"`
+
+	// APIKey is the API key of the organization of the tenant, which its
+	// traces are sent to the intake with.
+	APIKey string `mapstructure:"api_key"`
+}
+
 // WriterConfig specifies configuration for an API writer.
 type WriterConfig struct {
 	// ConnectionLimit specifies the maximum number of concurrent outgoing
@@ -98,6 +194,431 @@ type WriterConfig struct {
 	// FlushPeriodSeconds specifies the frequency at which the writer's buffer
 	// will be flushed to the sender, in seconds. Fractions are permitted.
 	FlushPeriodSeconds float64 `mapstructure:"flush_period_seconds"`
+
+	// MaxBytesPerTrace specifies the maximum estimated size of a trace, in bytes.
+	// Traces above it will have their least important spans removed. A value of
+	// 0 means unlimited.
+	MaxBytesPerTrace int64 `mapstructure:"max_bytes_per_trace"`
+
+	// Base85EncodeBinaryTags lists the tags holding hex encoded binary values,
+	// such as certificates, which are re-encoded using base-85 to reduce their
+	// size.
+	Base85EncodeBinaryTags []string `mapstructure:"base85_encode_binary_tags"`
+
+	// DeadLetterReplayPriority holds the priority of the traces of each
+	// service. When set, the traces which can't be written because the
+	// sender queue is full are kept, and replayed by decreasing priority.
+	DeadLetterReplayPriority map[string]int `mapstructure:"dead_letter_replay_priority"`
+}
+
+// SamplerConfig specifies additional configuration of the samplers.
+type SamplerConfig struct {
+	// UseBudgetDistribution specifies whether the score sampler should share
+	// the max TPS fairly across services instead of sampling by signature.
+	UseBudgetDistribution bool
+
+	// Ensemble specifies the sampling algorithms whose decisions are combined
+	// by the score sampler.
+	Ensemble EnsembleConfig
+
+	// MaxRateChangePerSecond is the maximum change of a sample rate per second
+	// above which the score sampler smooths the rate. Zero disables damping.
+	MaxRateChangePerSecond float64
+
+	// DampingFactor is the weight given to the new rate by the exponential
+	// smoothing applied to rates changing too fast.
+	DampingFactor float64
+
+	// ABTest specifies the sampling algorithm evaluated against the score
+	// sampler on a fraction of the traffic.
+	ABTest ABTestConfig
+
+	// InverseFrequency specifies whether the score sampler should weight the
+	// sample rates of services inversely to their request rate, so that rare
+	// services are always sampled.
+	InverseFrequency bool
+
+	// CanaryVersionPattern is a regular expression matching the versions of
+	// canary deployments, read from the "version" tag of root spans.
+	CanaryVersionPattern string
+
+	// RollbackActive specifies whether a rollback is in progress at startup.
+	// It can be changed at runtime through the /v0.4/rollback endpoint.
+	RollbackActive bool
+
+	// CanaryRollbackRate is the rate the sample rate of canary traces is
+	// multiplied by during rollbacks.
+	CanaryRollbackRate float64
+
+	// MaxPanicsBeforeDisable is the number of panics after which a sampler is
+	// disabled and replaced by one keeping all traces. Zero never disables
+	// samplers.
+	MaxPanicsBeforeDisable int
+
+	// HoldoutSize is the number of traces per service kept every hour
+	// regardless of the other samplers, for unbiased analytics. Zero disables
+	// the holdout set.
+	HoldoutSize int
+
+	// BusinessImpactTag is the tag of root spans holding the business impact
+	// of their trace, e.g. "business.impact".
+	BusinessImpactTag string
+
+	// BusinessImpactWeights are the weights the sample rates of traces are
+	// multiplied by, capped at 1, by business impact. Traces with other
+	// impacts are sampled normally.
+	BusinessImpactWeights map[string]float64
+}
+
+// ABTestConfig specifies the configuration of the sampler A/B test.
+type ABTestConfig struct {
+	// Enabled specifies whether the A/B test is running.
+	Enabled bool
+
+	// TreatmentFraction is the share of the traces sampled by the evaluated
+	// algorithm.
+	TreatmentFraction float64
+
+	// Algorithm is the evaluated sampling algorithm, out of "score", "tps"
+	// and "hash".
+	Algorithm string
+}
+
+// EnsembleConfig specifies the configuration of the ensemble sampler.
+type EnsembleConfig struct {
+	// Algorithms lists the combined sampling algorithms, out of "score", "tps"
+	// and "hash". An empty list disables the ensemble sampler.
+	Algorithms []string `mapstructure:"algorithms"`
+
+	// Weights holds the weight of the vote of each algorithm.
+	Weights []float64 `mapstructure:"weights"`
+}
+
+// PipelineConfig specifies the configuration of the receiver's asynchronous
+// processing pipeline.
+type PipelineConfig struct {
+	// Enabled specifies whether payloads should be decoded, normalized and
+	// obfuscated by the pipeline instead of a goroutine per request.
+	Enabled bool `mapstructure:"enabled"`
+
+	// Workers specifies the number of workers of each stage. It defaults to
+	// the number of CPUs.
+	Workers int `mapstructure:"workers"`
+
+	// DecodeQueueSize specifies the maximum number of payloads waiting to be decoded.
+	DecodeQueueSize int `mapstructure:"decode_queue_size"`
+
+	// NormalizeQueueSize specifies the maximum number of traces waiting to be normalized.
+	NormalizeQueueSize int `mapstructure:"normalize_queue_size"`
+
+	// ObfuscateQueueSize specifies the maximum number of traces waiting to be obfuscated.
+	ObfuscateQueueSize int `mapstructure:"obfuscate_queue_size"`
+}
+
+// FlushFeedbackConfig specifies the configuration of the flush interval
+// recommended to tracers in the responses of the receiver.
+type FlushFeedbackConfig struct {
+	// Enabled specifies whether a flush interval should be recommended to tracers.
+	Enabled bool
+
+	// HighLoad is the share of traces dropped by the rate limiter above which
+	// tracers are asked to flush less often.
+	HighLoad float64
+
+	// LowLoad is the share of traces dropped by the rate limiter below which
+	// tracers are asked to flush at the usual interval again.
+	LowLoad float64
+}
+
+// ContentNegotiationConfig specifies the configuration of the payload encoding
+// recommended to tracers in the responses of the receiver.
+type ContentNegotiationConfig struct {
+	// Enabled specifies whether the encoding decoded the fastest should be
+	// recommended to tracers.
+	Enabled bool
+
+	// Alpha is the weight of the latest decoding time in the moving averages
+	// of the decoding times of each encoding.
+	Alpha float64
+}
+
+// EarlyTerminationConfig specifies the configuration of the early termination
+// of low priority traces during high load.
+type EarlyTerminationConfig struct {
+	// Enabled specifies whether low priority traces can be dropped on receipt.
+	Enabled bool
+
+	// HighLoad is the share of traces dropped by the rate limiter above which
+	// low priority traces are dropped on receipt.
+	HighLoad float64
+
+	// KeepRate is the share of low priority traces kept during high load.
+	KeepRate float64
+}
+
+// KubernetesConfig specifies the configuration of the Kubernetes integration.
+type KubernetesConfig struct {
+	// WebhookEnabled specifies whether the admission webhook denying pods on
+	// the node of an overloaded agent should be served.
+	WebhookEnabled bool
+
+	// WebhookPort is the port the admission webhook listens on.
+	WebhookPort int
+
+	// WebhookCertFile and WebhookKeyFile are the paths to the PEM encoded
+	// certificate and key the admission webhook is served with, such as the
+	// ones of a Kubernetes TLS secret mounted in the agent container.
+	WebhookCertFile string
+	WebhookKeyFile  string
+
+	// NodeLabel is the label of the nodes running the agent. Pods selecting
+	// it are denied while the agent is overloaded.
+	NodeLabel string
+}
+
+// GatewayConfig specifies the configuration of the multi-protocol ingestion
+// gateway, receiving msgpack encoded traces over other protocols than the
+// receiver.
+type GatewayConfig struct {
+	// Protocols lists the enabled protocols, among http, grpc, kafka and
+	// nats. The gateway is disabled when empty.
+	Protocols []string `mapstructure:"protocols"`
+
+	// HTTPAddr and GRPCAddr are the addresses the HTTP and gRPC servers
+	// listen on.
+	HTTPAddr string `mapstructure:"http_addr"`
+	GRPCAddr string `mapstructure:"grpc_addr"`
+
+	// KafkaBrokers and KafkaTopic are the brokers and topic traces are
+	// consumed from, as part of the KafkaGroup consumer group. KafkaUser is
+	// the SASL user the agent authenticates as.
+	KafkaBrokers []string `mapstructure:"kafka_brokers"`
+	KafkaTopic   string   `mapstructure:"kafka_topic"`
+	KafkaGroup   string   `mapstructure:"kafka_group"`
+	KafkaUser    string   `mapstructure:"kafka_user"`
+
+	// NATSURL and NATSSubject are the server and subject traces are consumed
+	// from, as part of the NATSQueue queue group.
+	NATSURL     string `mapstructure:"nats_url"`
+	NATSSubject string `mapstructure:"nats_subject"`
+	NATSQueue   string `mapstructure:"nats_queue"`
+
+	// AuthTokens holds the token authenticating each protocol. HTTP and gRPC
+	// clients send it as a bearer token, while it authenticates the agent to
+	// the Kafka brokers and NATS server. No token disables authentication.
+	AuthTokens map[string]string `mapstructure:"auth_tokens"`
+
+	// RateLimits holds the maximum number of traces per second accepted over
+	// each protocol. No limit means unlimited.
+	RateLimits map[string]float64 `mapstructure:"rate_limits"`
+}
+
+// StatsConfig specifies the configuration of the aggregation keys of the stats.
+type StatsConfig struct {
+	// MaxUniqueKeysPerService is the maximum number of unique aggregation
+	// keys of a service in a stats bucket, beyond which the resource of its
+	// new keys is collapsed. Zero means unlimited.
+	MaxUniqueKeysPerService int
+
+	// BackgroundFlushInterval is the idle time after which the stats buckets
+	// are flushed without waiting for them to be complete. 0 disables it.
+	BackgroundFlushInterval time.Duration
+}
+
+// AggregatorConfig specifies the configuration of the span aggregator.
+type AggregatorConfig struct {
+	// Enabled specifies whether spans belonging to the same trace should be
+	// buffered until the trace is complete, before being processed.
+	Enabled bool
+
+	// FlushTimeout specifies the maximum amount of time spans are buffered
+	// while waiting for the root span of their trace.
+	FlushTimeout time.Duration
+
+	// SuppressLateSpans specifies whether the spans received after their trace
+	// was flushed should be dropped, instead of being processed as a new trace.
+	SuppressLateSpans bool
+
+	// ClosedTraceTTL specifies for how long the flushed traces are remembered
+	// to drop their late spans.
+	ClosedTraceTTL time.Duration
+}
+
+// NormalizationConfig specifies the configuration of trace normalization.
+type NormalizationConfig struct {
+	// ParentResolution specifies whether spans should be buffered until all the
+	// parents of their trace are received, before being processed.
+	ParentResolution bool
+
+	// ParentResolutionWindow specifies the maximum amount of time spans are
+	// buffered while waiting for their parents.
+	ParentResolutionWindow time.Duration
+}
+
+// CoalescingConfig specifies the configuration of the event coalescer.
+type CoalescingConfig struct {
+	// Enabled specifies whether identical error traces should be merged.
+	Enabled bool
+
+	// Window specifies the period during which identical error traces are
+	// merged into a single one.
+	Window time.Duration
+}
+
+// AnalyzedEventsConfig specifies the configuration of the routing of APM events.
+type AnalyzedEventsConfig struct {
+	// HighValueServices lists the services whose APM events are sent to the
+	// high value endpoint instead of the main endpoint.
+	HighValueServices []string
+
+	// HighValueEndpoint is the intake host high value APM events are sent to.
+	// An empty value disables the routing.
+	HighValueEndpoint string
+
+	// HighValueAPIKey is the API key used with the high value endpoint. It
+	// defaults to the API key of the main endpoint.
+	HighValueAPIKey string `json:"-"` // never marshal this
+}
+
+// Enabled reports whether high value APM events are routed to their own endpoint.
+func (c *AnalyzedEventsConfig) Enabled() bool {
+	return c.HighValueEndpoint != "" && len(c.HighValueServices) > 0
+}
+
+// DebugConfig specifies the configuration of experimental trace annotations.
+type DebugConfig struct {
+	// MahalanobisThreshold is the Mahalanobis distance from the usual traces of
+	// a service above which a trace is annotated as anomalous. A value of 0
+	// disables the detection.
+	MahalanobisThreshold float64
+
+	// TopologyTracking specifies whether new and missing trace topologies
+	// should be reported for each service.
+	TopologyTracking bool
+
+	// ContextLeakDetection specifies whether request context values, such as
+	// user IDs, should be checked for leaks between successive traces of a
+	// service.
+	ContextLeakDetection bool
+
+	// CausalCorrelation specifies whether error spans should be annotated with
+	// the service whose errors predict the errors of their own service.
+	CausalCorrelation bool
+
+	// SchemaDocumentation specifies whether the HTTP endpoints of services
+	// should be documented from their spans, and served as OpenAPI documents.
+	SchemaDocumentation bool
+
+	// PipelineProfiling specifies whether the latency distribution of each
+	// stage of the trace processing pipeline should be recorded and served
+	// on /debug/pipeline/latency.
+	PipelineProfiling bool
+
+	// SpanImportanceModel is the path to the ONNX model predicting the
+	// importance of spans, which traces holding important spans are sampled
+	// by. An empty value disables the scoring.
+	SpanImportanceModel string
+}
+
+// EnrichmentConfig specifies the configuration of span enrichment.
+type EnrichmentConfig struct {
+	// FeatureStore holds the configuration of the feature store spans are
+	// enriched from.
+	FeatureStore FeatureStoreConfig
+
+	// PropagateDeadlines specifies whether the time remaining before the
+	// deadline of a request, found in the headers of the root span, should be
+	// set on the child spans.
+	PropagateDeadlines bool
+
+	// ValidateAPIContracts specifies whether HTTP spans should be validated
+	// against the OpenAPI specification of their service.
+	ValidateAPIContracts bool
+
+	// OpenAPISpecs holds the paths to the OpenAPI specifications of services,
+	// by service.
+	OpenAPISpecs map[string]string
+}
+
+// FeatureStoreConfig specifies the configuration of the feature store enricher.
+type FeatureStoreConfig struct {
+	// URL is the address of the feature store. An empty value disables the
+	// enrichment.
+	URL string
+
+	// CacheTTL specifies for how long the features of a user are cached.
+	CacheTTL time.Duration
+
+	// MaxRPS is the maximum number of requests per second made to the feature store.
+	MaxRPS float64
+}
+
+// SyntheticsConfig specifies the configuration of the enrichment of synthetic
+// monitoring traces.
+type SyntheticsConfig struct {
+	// MetadataEndpoint is the address of the Synthetics API endpoint returning
+	// the test a trace was generated by. An empty value disables the enrichment.
+	MetadataEndpoint string
+
+	// CacheTTL specifies for how long the test of a trace is cached.
+	CacheTTL time.Duration
+
+	// TraceIDRangeStart and TraceIDRangeEnd delimit, inclusively, the trace
+	// IDs reserved to synthetic monitoring. The range is disabled when its end
+	// is 0.
+	TraceIDRangeStart uint64
+	TraceIDRangeEnd   uint64
+
+	// Endpoint is the intake the traces of the reserved range are sent to. When
+	// empty, they are sent along with the other traces.
+	Endpoint string
+}
+
+// InReservedRange reports whether traceID is reserved to synthetic monitoring.
+func (c *SyntheticsConfig) InReservedRange(traceID uint64) bool {
+	return c.TraceIDRangeEnd != 0 && c.TraceIDRangeStart <= traceID && traceID <= c.TraceIDRangeEnd
+}
+
+// CostConfig specifies the configuration of the estimation of the cost of spans.
+type CostConfig struct {
+	// ComputePricePerHour is the hourly price of the cloud instance the traced
+	// services run on. A value of 0 disables the estimation.
+	ComputePricePerHour float64
+
+	// VCPUs is the number of vCPUs of the instance, a span being considered
+	// to use one of them unless it reports its CPU share.
+	VCPUs int
+}
+
+// SpanConfigOverrideConfig specifies the configuration of the overrides set by
+// root spans through "_dd.config.*" tags.
+type SpanConfigOverrideConfig struct {
+	// Enabled reports whether root spans can override the configuration.
+	Enabled bool
+
+	// TTL specifies for how long an override applies after it was last seen.
+	TTL time.Duration
+
+	// MaxEPSLimit is the highest max EPS a service can override. Services can
+	// only lower their max EPS: the limit is capped at MaxEPS, which is used
+	// when it is 0.
+	MaxEPSLimit float64
+}
+
+// ReconstructionConfig specifies the configuration of the trace reconstruction service.
+type ReconstructionConfig struct {
+	// RedisAddr is the address of the Redis shared by the agents. An empty value
+	// disables the reconstruction.
+	RedisAddr string
+
+	// TTL specifies for how long published spans are kept in Redis.
+	TTL time.Duration
+
+	// RelinkRoots enables the re-linking of the spans without parent ID to the
+	// remote span enclosing them in time. It is meant for deployments where a
+	// hop propagates the trace ID but drops the parent ID: otherwise these
+	// spans are the true roots of their traces and are never looked up.
+	RelinkRoots bool
 }
 
 func (c *AgentConfig) applyDatadogConfig() error {
@@ -177,9 +698,18 @@ func (c *AgentConfig) applyDatadogConfig() error {
 	if config.Datadog.IsSet("apm_config.receiver_socket") {
 		c.ReceiverSocket = config.Datadog.GetString("apm_config.receiver_socket")
 	}
+	if config.Datadog.IsSet("apm_config.max_trace_age_minutes") {
+		c.MaxTraceAgeMinutes = config.Datadog.GetInt("apm_config.max_trace_age_minutes")
+	}
 	if config.Datadog.IsSet("apm_config.connection_limit") {
 		c.ConnectionLimit = config.Datadog.GetInt("apm_config.connection_limit")
 	}
+	if config.Datadog.IsSet("apm_config.stats.max_unique_keys_per_service") {
+		c.Stats.MaxUniqueKeysPerService = config.Datadog.GetInt("apm_config.stats.max_unique_keys_per_service")
+	}
+	if config.Datadog.IsSet("apm_config.stats.background_flush_interval_seconds") {
+		c.Stats.BackgroundFlushInterval = time.Duration(config.Datadog.GetInt("apm_config.stats.background_flush_interval_seconds")) * time.Second
+	}
 	if config.Datadog.IsSet("apm_config.extra_sample_rate") {
 		c.ExtraSampleRate = config.Datadog.GetFloat64("apm_config.extra_sample_rate")
 	}
@@ -189,6 +719,10 @@ func (c *AgentConfig) applyDatadogConfig() error {
 	if config.Datadog.IsSet("apm_config.max_traces_per_second") {
 		c.MaxTPS = config.Datadog.GetFloat64("apm_config.max_traces_per_second")
 	}
+	// undocumented
+	if config.Datadog.IsSet("apm_config.max_spans_per_trace") {
+		c.MaxSpansPerTrace = config.Datadog.GetInt("apm_config.max_spans_per_trace")
+	}
 	if config.Datadog.IsSet("apm_config.ignore_resources") {
 		c.Ignore["resource"] = config.Datadog.GetStringSlice("apm_config.ignore_resources")
 	}
@@ -205,6 +739,33 @@ func (c *AgentConfig) applyDatadogConfig() error {
 		}
 	}
 
+	if config.Datadog.IsSet("apm_config.service_map.grouping_rules") {
+		var rules []*ServiceGroupingRule
+		err := config.Datadog.UnmarshalKey("apm_config.service_map.grouping_rules", &rules)
+		if err == nil {
+			if err := compileServiceGroupingRules(rules); err != nil {
+				osutil.Exitf("service_map.grouping_rules: %s", err)
+			}
+			c.ServiceMap.GroupingRules = rules
+		}
+	}
+	if config.Datadog.IsSet("apm_config.service_map.hierarchy_labels") {
+		c.ServiceMap.HierarchyLabels = config.Datadog.GetStringSlice("apm_config.service_map.hierarchy_labels")
+	}
+
+	if config.Datadog.IsSet("apm_config.multi_tenant.tenant_mapping") {
+		var mapping []TenantMapping
+		if err := config.Datadog.UnmarshalKey("apm_config.multi_tenant.tenant_mapping", &mapping); err == nil {
+			c.MultiTenant.TenantMapping = mapping
+		}
+	}
+	if config.Datadog.IsSet("apm_config.multi_tenant.traces_budget_per_customer_per_minute") {
+		c.MultiTenant.TracesBudgetPerCustomerPerMinute = config.Datadog.GetInt64("apm_config.multi_tenant.traces_budget_per_customer_per_minute")
+	}
+	if config.Datadog.IsSet("apm_config.multi_tenant.customer_id_tag") {
+		c.MultiTenant.CustomerIDTag = config.Datadog.GetString("apm_config.multi_tenant.customer_id_tag")
+	}
+
 	if config.Datadog.IsSet("bind_host") {
 		host := config.Datadog.GetString("bind_host")
 		c.StatsdHost = host
@@ -245,6 +806,250 @@ func (c *AgentConfig) applyDatadogConfig() error {
 		}
 	}
 
+	// undocumented
+	if config.Datadog.IsSet("apm_config.sampler.use_budget_distribution") {
+		c.Sampler.UseBudgetDistribution = config.Datadog.GetBool("apm_config.sampler.use_budget_distribution")
+	}
+	if err := config.Datadog.UnmarshalKey("apm_config.sampler.ensemble", &c.Sampler.Ensemble); err != nil {
+		log.Errorf("Error reading sampler ensemble config: %v", err)
+	}
+	if config.Datadog.IsSet("apm_config.sampler.max_rate_change_per_second") {
+		c.Sampler.MaxRateChangePerSecond = config.Datadog.GetFloat64("apm_config.sampler.max_rate_change_per_second")
+	}
+	if config.Datadog.IsSet("apm_config.sampler.damping_factor") {
+		c.Sampler.DampingFactor = config.Datadog.GetFloat64("apm_config.sampler.damping_factor")
+	}
+	if config.Datadog.IsSet("apm_config.sampler.inverse_frequency") {
+		c.Sampler.InverseFrequency = config.Datadog.GetBool("apm_config.sampler.inverse_frequency")
+	}
+	if config.Datadog.IsSet("apm_config.sampler.canary_version_pattern") {
+		c.Sampler.CanaryVersionPattern = config.Datadog.GetString("apm_config.sampler.canary_version_pattern")
+	}
+	if config.Datadog.IsSet("apm_config.sampler.rollback_active") {
+		c.Sampler.RollbackActive = config.Datadog.GetBool("apm_config.sampler.rollback_active")
+	}
+	if config.Datadog.IsSet("apm_config.sampler.canary_rollback_rate") {
+		c.Sampler.CanaryRollbackRate = config.Datadog.GetFloat64("apm_config.sampler.canary_rollback_rate")
+	}
+	if config.Datadog.IsSet("apm_config.sampler.max_panics_before_disable") {
+		c.Sampler.MaxPanicsBeforeDisable = config.Datadog.GetInt("apm_config.sampler.max_panics_before_disable")
+	}
+	if config.Datadog.IsSet("apm_config.sampler.holdout_size") {
+		c.Sampler.HoldoutSize = config.Datadog.GetInt("apm_config.sampler.holdout_size")
+	}
+	if config.Datadog.IsSet("apm_config.sampler.business_impact_tag") {
+		c.Sampler.BusinessImpactTag = config.Datadog.GetString("apm_config.sampler.business_impact_tag")
+	}
+	if config.Datadog.IsSet("apm_config.sampler.business_impact_weights") {
+		weights := make(map[string]float64)
+		if err := config.Datadog.UnmarshalKey("apm_config.sampler.business_impact_weights", &weights); err != nil {
+			return err
+		}
+		c.Sampler.BusinessImpactWeights = weights
+	}
+	if config.Datadog.IsSet("apm_config.sampler.ab_test.enabled") {
+		c.Sampler.ABTest.Enabled = config.Datadog.GetBool("apm_config.sampler.ab_test.enabled")
+	}
+	if config.Datadog.IsSet("apm_config.sampler.ab_test.treatment_fraction") {
+		c.Sampler.ABTest.TreatmentFraction = config.Datadog.GetFloat64("apm_config.sampler.ab_test.treatment_fraction")
+	}
+	if config.Datadog.IsSet("apm_config.sampler.ab_test.algorithm") {
+		c.Sampler.ABTest.Algorithm = config.Datadog.GetString("apm_config.sampler.ab_test.algorithm")
+	}
+	if err := config.Datadog.UnmarshalKey("apm_config.receiver_pipeline", c.ReceiverPipeline); err != nil {
+		log.Errorf("Error reading receiver pipeline config: %v", err)
+	}
+
+	// undocumented
+	if config.Datadog.IsSet("apm_config.flush_interval_feedback.enabled") {
+		c.FlushFeedback.Enabled = config.Datadog.GetBool("apm_config.flush_interval_feedback.enabled")
+	}
+	if config.Datadog.IsSet("apm_config.flush_interval_feedback.high_load") {
+		c.FlushFeedback.HighLoad = config.Datadog.GetFloat64("apm_config.flush_interval_feedback.high_load")
+	}
+	if config.Datadog.IsSet("apm_config.flush_interval_feedback.low_load") {
+		c.FlushFeedback.LowLoad = config.Datadog.GetFloat64("apm_config.flush_interval_feedback.low_load")
+	}
+	if config.Datadog.IsSet("apm_config.content_negotiation.enabled") {
+		c.ContentNegotiation.Enabled = config.Datadog.GetBool("apm_config.content_negotiation.enabled")
+	}
+	if config.Datadog.IsSet("apm_config.content_negotiation.alpha") {
+		c.ContentNegotiation.Alpha = config.Datadog.GetFloat64("apm_config.content_negotiation.alpha")
+	}
+
+	// undocumented
+	if config.Datadog.IsSet("apm_config.early_termination.enabled") {
+		c.EarlyTermination.Enabled = config.Datadog.GetBool("apm_config.early_termination.enabled")
+	}
+	if config.Datadog.IsSet("apm_config.early_termination.high_load") {
+		c.EarlyTermination.HighLoad = config.Datadog.GetFloat64("apm_config.early_termination.high_load")
+	}
+	if config.Datadog.IsSet("apm_config.early_termination.keep_rate") {
+		c.EarlyTermination.KeepRate = config.Datadog.GetFloat64("apm_config.early_termination.keep_rate")
+	}
+
+	// undocumented
+	if config.Datadog.IsSet("apm_config.kubernetes.webhook_enabled") {
+		c.Kubernetes.WebhookEnabled = config.Datadog.GetBool("apm_config.kubernetes.webhook_enabled")
+	}
+	if config.Datadog.IsSet("apm_config.kubernetes.webhook_port") {
+		c.Kubernetes.WebhookPort = config.Datadog.GetInt("apm_config.kubernetes.webhook_port")
+	}
+	if config.Datadog.IsSet("apm_config.kubernetes.webhook_cert_file") {
+		c.Kubernetes.WebhookCertFile = config.Datadog.GetString("apm_config.kubernetes.webhook_cert_file")
+	}
+	if config.Datadog.IsSet("apm_config.kubernetes.webhook_key_file") {
+		c.Kubernetes.WebhookKeyFile = config.Datadog.GetString("apm_config.kubernetes.webhook_key_file")
+	}
+	if config.Datadog.IsSet("apm_config.kubernetes.node_label") {
+		c.Kubernetes.NodeLabel = config.Datadog.GetString("apm_config.kubernetes.node_label")
+	}
+
+	// undocumented
+	if err := config.Datadog.UnmarshalKey("apm_config.gateway", c.Gateway); err != nil {
+		log.Errorf("Error reading gateway config: %v", err)
+	}
+
+	// undocumented
+	if config.Datadog.IsSet("apm_config.annotation_ttl_seconds") {
+		c.AnnotationTTL = getDuration(config.Datadog.GetInt("apm_config.annotation_ttl_seconds"))
+	}
+
+	// undocumented
+	if config.Datadog.IsSet("apm_config.config_version_ttl_seconds") {
+		c.ConfigVersionTTL = getDuration(config.Datadog.GetInt("apm_config.config_version_ttl_seconds"))
+	}
+
+	// undocumented
+	if config.Datadog.IsSet("apm_config.span_aggregator.enabled") {
+		c.Aggregator.Enabled = config.Datadog.GetBool("apm_config.span_aggregator.enabled")
+	}
+	if config.Datadog.IsSet("apm_config.span_aggregator.flush_timeout_seconds") {
+		s := config.Datadog.GetFloat64("apm_config.span_aggregator.flush_timeout_seconds")
+		c.Aggregator.FlushTimeout = time.Duration(s * float64(time.Second))
+	}
+	if config.Datadog.IsSet("apm_config.span_aggregator.suppress_late_spans") {
+		c.Aggregator.SuppressLateSpans = config.Datadog.GetBool("apm_config.span_aggregator.suppress_late_spans")
+	}
+	if config.Datadog.IsSet("apm_config.span_aggregator.closed_trace_ttl_seconds") {
+		c.Aggregator.ClosedTraceTTL = getDuration(config.Datadog.GetInt("apm_config.span_aggregator.closed_trace_ttl_seconds"))
+	}
+
+	// undocumented
+	if config.Datadog.IsSet("apm_config.normalization.parent_resolution") {
+		c.Normalization.ParentResolution = config.Datadog.GetBool("apm_config.normalization.parent_resolution")
+	}
+	if config.Datadog.IsSet("apm_config.normalization.parent_resolution_window_ms") {
+		ms := time.Duration(config.Datadog.GetInt("apm_config.normalization.parent_resolution_window_ms"))
+		c.Normalization.ParentResolutionWindow = ms * time.Millisecond
+	}
+
+	// undocumented
+	if config.Datadog.IsSet("apm_config.coalescing.enabled") {
+		c.Coalescing.Enabled = config.Datadog.GetBool("apm_config.coalescing.enabled")
+	}
+	if config.Datadog.IsSet("apm_config.coalescing.window_ms") {
+		ms := time.Duration(config.Datadog.GetInt("apm_config.coalescing.window_ms"))
+		c.Coalescing.Window = ms * time.Millisecond
+	}
+
+	// undocumented
+	if config.Datadog.IsSet("apm_config.debug.mahalanobis_threshold") {
+		c.Debug.MahalanobisThreshold = config.Datadog.GetFloat64("apm_config.debug.mahalanobis_threshold")
+	}
+	if config.Datadog.IsSet("apm_config.debug.topology_tracking") {
+		c.Debug.TopologyTracking = config.Datadog.GetBool("apm_config.debug.topology_tracking")
+	}
+	if config.Datadog.IsSet("apm_config.debug.context_leak_detection") {
+		c.Debug.ContextLeakDetection = config.Datadog.GetBool("apm_config.debug.context_leak_detection")
+	}
+	if config.Datadog.IsSet("apm_config.debug.causal_correlation") {
+		c.Debug.CausalCorrelation = config.Datadog.GetBool("apm_config.debug.causal_correlation")
+	}
+	if config.Datadog.IsSet("apm_config.debug.schema_documentation") {
+		c.Debug.SchemaDocumentation = config.Datadog.GetBool("apm_config.debug.schema_documentation")
+	}
+	if config.Datadog.IsSet("apm_config.debug.pipeline_profiling") {
+		c.Debug.PipelineProfiling = config.Datadog.GetBool("apm_config.debug.pipeline_profiling")
+	}
+	if config.Datadog.IsSet("apm_config.debug.span_importance_model") {
+		c.Debug.SpanImportanceModel = config.Datadog.GetString("apm_config.debug.span_importance_model")
+	}
+
+	// undocumented
+	if config.Datadog.IsSet("apm_config.enrichment.feature_store.url") {
+		c.Enrichment.FeatureStore.URL = config.Datadog.GetString("apm_config.enrichment.feature_store.url")
+	}
+	if config.Datadog.IsSet("apm_config.enrichment.feature_store.cache_ttl_seconds") {
+		d := time.Duration(config.Datadog.GetInt("apm_config.enrichment.feature_store.cache_ttl_seconds"))
+		c.Enrichment.FeatureStore.CacheTTL = d * time.Second
+	}
+	if config.Datadog.IsSet("apm_config.enrichment.feature_store.max_rps") {
+		c.Enrichment.FeatureStore.MaxRPS = config.Datadog.GetFloat64("apm_config.enrichment.feature_store.max_rps")
+	}
+	if config.Datadog.IsSet("apm_config.enrichment.propagate_deadlines") {
+		c.Enrichment.PropagateDeadlines = config.Datadog.GetBool("apm_config.enrichment.propagate_deadlines")
+	}
+	if config.Datadog.IsSet("apm_config.enrichment.validate_api_contracts") {
+		c.Enrichment.ValidateAPIContracts = config.Datadog.GetBool("apm_config.enrichment.validate_api_contracts")
+	}
+	if config.Datadog.IsSet("apm_config.enrichment.openapi_specs") {
+		c.Enrichment.OpenAPISpecs = config.Datadog.GetStringMapString("apm_config.enrichment.openapi_specs")
+	}
+	if config.Datadog.IsSet("apm_config.cost.compute_price_per_hour") {
+		c.Cost.ComputePricePerHour = config.Datadog.GetFloat64("apm_config.cost.compute_price_per_hour")
+	}
+	if config.Datadog.IsSet("apm_config.cost.vcpus") {
+		c.Cost.VCPUs = config.Datadog.GetInt("apm_config.cost.vcpus")
+	}
+	if config.Datadog.IsSet("apm_config.synthetics.metadata_endpoint") {
+		c.Synthetics.MetadataEndpoint = config.Datadog.GetString("apm_config.synthetics.metadata_endpoint")
+	}
+	if config.Datadog.IsSet("apm_config.synthetics.cache_ttl_seconds") {
+		d := time.Duration(config.Datadog.GetInt("apm_config.synthetics.cache_ttl_seconds"))
+		c.Synthetics.CacheTTL = d * time.Second
+	}
+	for key, v := range map[string]*uint64{
+		"apm_config.synthetics.trace_id_range_start": &c.Synthetics.TraceIDRangeStart,
+		"apm_config.synthetics.trace_id_range_end":   &c.Synthetics.TraceIDRangeEnd,
+	} {
+		if !config.Datadog.IsSet(key) {
+			continue
+		}
+		// decimal, or hexadecimal such as 0xDEAD0000
+		id, err := strconv.ParseUint(config.Datadog.GetString(key), 0, 64)
+		if err != nil {
+			log.Errorf("Invalid trace ID %s: %v", key, err)
+			continue
+		}
+		*v = id
+	}
+	if config.Datadog.IsSet("apm_config.synthetics.endpoint") {
+		c.Synthetics.Endpoint = config.Datadog.GetString("apm_config.synthetics.endpoint")
+	}
+	if config.Datadog.IsSet("apm_config.span_config.enabled") {
+		c.SpanConfig.Enabled = config.Datadog.GetBool("apm_config.span_config.enabled")
+	}
+	if config.Datadog.IsSet("apm_config.span_config.ttl_seconds") {
+		d := time.Duration(config.Datadog.GetInt("apm_config.span_config.ttl_seconds"))
+		c.SpanConfig.TTL = d * time.Second
+	}
+	if config.Datadog.IsSet("apm_config.span_config.max_eps_limit") {
+		c.SpanConfig.MaxEPSLimit = config.Datadog.GetFloat64("apm_config.span_config.max_eps_limit")
+	}
+
+	// undocumented
+	if config.Datadog.IsSet("apm_config.trace_reconstruction.redis_addr") {
+		c.Reconstruction.RedisAddr = config.Datadog.GetString("apm_config.trace_reconstruction.redis_addr")
+	}
+	if config.Datadog.IsSet("apm_config.trace_reconstruction.ttl_seconds") {
+		d := time.Duration(config.Datadog.GetInt("apm_config.trace_reconstruction.ttl_seconds"))
+		c.Reconstruction.TTL = d * time.Second
+	}
+	if config.Datadog.IsSet("apm_config.trace_reconstruction.relink_roots") {
+		c.Reconstruction.RelinkRoots = config.Datadog.GetBool("apm_config.trace_reconstruction.relink_roots")
+	}
+
 	// undocumented deprecated
 	if config.Datadog.IsSet("apm_config.analyzed_rate_by_service") {
 		rateByService := make(map[string]float64)
@@ -276,6 +1081,17 @@ func (c *AgentConfig) applyDatadogConfig() error {
 		}
 	}
 
+	// undocumented
+	if config.Datadog.IsSet("apm_config.analyzed_events.high_value_services") {
+		c.AnalyzedEvents.HighValueServices = config.Datadog.GetStringSlice("apm_config.analyzed_events.high_value_services")
+	}
+	if config.Datadog.IsSet("apm_config.analyzed_events.high_value_endpoint") {
+		c.AnalyzedEvents.HighValueEndpoint = config.Datadog.GetString("apm_config.analyzed_events.high_value_endpoint")
+	}
+	if config.Datadog.IsSet("apm_config.analyzed_events.high_value_api_key") {
+		c.AnalyzedEvents.HighValueAPIKey = config.Datadog.GetString("apm_config.analyzed_events.high_value_api_key")
+	}
+
 	// undocumented
 	if config.Datadog.IsSet("apm_config.dd_agent_bin") {
 		c.DDAgentBin = config.Datadog.GetString("apm_config.dd_agent_bin")
@@ -363,6 +1179,23 @@ func compileReplaceRules(rules []*ReplaceRule) error {
 	return nil
 }
 
+func compileServiceGroupingRules(rules []*ServiceGroupingRule) error {
+	for _, r := range rules {
+		if r.GroupName == "" {
+			return errors.New(`all rules must have a "group_name"`)
+		}
+		if r.Pattern == "" {
+			return errors.New(`all rules must have a "pattern"`)
+		}
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("group %q: %s", r.GroupName, err)
+		}
+		r.Re = re
+	}
+	return nil
+}
+
 // getDuration returns the duration of the provided value in seconds
 func getDuration(seconds int) time.Duration {
 	return time.Duration(seconds) * time.Second
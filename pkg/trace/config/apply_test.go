@@ -22,3 +22,23 @@ func TestParseRepaceRules(t *testing.T) {
 		assert.Equal(r.Pattern, r.Re.String())
 	}
 }
+
+func TestMultiTenantMatch(t *testing.T) {
+	assert := assert.New(t)
+	conf := &MultiTenantConfig{TenantMapping: []TenantMapping{
+		{APIKeyPrefix: "aaaa", OrgID: "1001", Endpoint: "https://a.example.com"},
+		{APIKeyPrefix: "bbbb", OrgID: "1002", Endpoint: "https://b.example.com"},
+		{APIKeyPrefix: "bbbbcc", OrgID: "1003", Endpoint: "https://c.example.com"},
+	}}
+	for key, org := range map[string]string{
+		"aaaa1234":   "1001",
+		"bbbb1234":   "1002",
+		"bbbbcc1234": "1003",
+		"cccc1234":   "",
+		"":           "",
+	} {
+		m, ok := conf.Match(key)
+		assert.Equal(org != "", ok, key)
+		assert.Equal(org, m.OrgID, key)
+	}
+}
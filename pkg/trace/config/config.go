@@ -62,11 +62,19 @@ type AgentConfig struct {
 	// Concentrator
 	BucketInterval   time.Duration // the size of our pre-aggregation per bucket
 	ExtraAggregators []string
+	// Stats holds the configuration of the aggregation and flushing of the
+	// stats.
+	Stats *StatsConfig
 
 	// Sampler configuration
 	ExtraSampleRate float64
 	MaxTPS          float64
 	MaxEPS          float64
+	Sampler         *SamplerConfig
+
+	// MaxSpansPerTrace is the number of spans above which the duplicate spans of
+	// sampled traces are removed. 0 disables the deduplication.
+	MaxSpansPerTrace int
 
 	// Receiver
 	ReceiverHost    string
@@ -74,11 +82,60 @@ type AgentConfig struct {
 	ReceiverSocket  string // if not empty, UDS will be enabled on unix://<receiver_socket>
 	ConnectionLimit int    // for rate-limiting, how many unique connections to allow in a lease period (30s)
 	ReceiverTimeout int
+	// MaxTraceAgeMinutes is the age of the oldest span of a trace above which
+	// the receiver drops the trace. 0 disables the check.
+	MaxTraceAgeMinutes int
+
+	// ReceiverPipeline holds the configuration of the asynchronous processing
+	// pipeline of the receiver.
+	ReceiverPipeline *PipelineConfig
+
+	// FlushFeedback holds the configuration of the flush interval recommended
+	// to tracers based on the load of the receiver.
+	FlushFeedback *FlushFeedbackConfig
+
+	// ContentNegotiation holds the configuration of the payload encoding
+	// recommended to tracers based on the time taken to decode it.
+	ContentNegotiation *ContentNegotiationConfig
+
+	// EarlyTermination holds the configuration of the early termination of
+	// low priority traces during high load.
+	EarlyTermination *EarlyTerminationConfig
+
+	// Kubernetes holds the configuration of the Kubernetes admission webhook.
+	Kubernetes *KubernetesConfig
+	// Gateway holds the configuration of the multi-protocol ingestion gateway.
+	Gateway *GatewayConfig
+
+	// MultiTenant holds the routing of the traces of tenants to their
+	// organizations, and the trace budgets of customers.
+	MultiTenant *MultiTenantConfig
+
+	// AnnotationTTL is how long the annotations added to traces through the
+	// receiver's annotation API are kept, waiting for their trace to be
+	// written. 0, the default, disables the annotation API.
+	AnnotationTTL time.Duration
+
+	// ConfigVersionTTL is how long a version of the obfuscation config is kept
+	// once reloaded, for the traces stamped with it to finish processing.
+	ConfigVersionTTL time.Duration
 
 	// Writers
 	StatsWriter *WriterConfig
 	TraceWriter *WriterConfig
 
+	// Aggregator holds the configuration of the span aggregator, which merges
+	// spans of a trace received across multiple payloads.
+	Aggregator *AggregatorConfig
+
+	// Normalization holds the configuration of the normalization of traces
+	// before they are processed.
+	Normalization *NormalizationConfig
+
+	// Coalescing holds the configuration of the event coalescer, which merges
+	// identical error traces received in a short period of time.
+	Coalescing *CoalescingConfig
+
 	// internal telemetry
 	StatsdHost string
 	StatsdPort int
@@ -108,11 +165,37 @@ type AgentConfig struct {
 	AnalyzedRateByServiceLegacy map[string]float64
 	AnalyzedSpansByService      map[string]map[string]float64
 
+	// AnalyzedEvents holds the configuration of the routing of APM events.
+	AnalyzedEvents *AnalyzedEventsConfig
+
 	// infrastructure agent binary
 	DDAgentBin string // DDAgentBin will be "" for Agent5 scenarios
 
 	// Obfuscation holds sensitive data obufscator's configuration.
 	Obfuscation *ObfuscationConfig
+
+	// Debug holds the configuration of experimental trace annotations.
+	Debug *DebugConfig
+
+	// Enrichment holds the configuration of span enrichment from external sources.
+	Enrichment *EnrichmentConfig
+
+	// Synthetics holds the configuration of the enrichment of synthetic
+	// monitoring traces.
+	Synthetics *SyntheticsConfig
+
+	// Cost holds the configuration of the estimation of the cost of spans.
+	Cost *CostConfig
+
+	// SpanConfig holds the configuration of the overrides set by root spans.
+	SpanConfig *SpanConfigOverrideConfig
+
+	// Reconstruction holds the configuration of the reconstruction of traces
+	// reported by multiple agents.
+	Reconstruction *ReconstructionConfig
+
+	// ServiceMap holds the configuration of the service map.
+	ServiceMap *ServiceMapConfig
 }
 
 // New returns a configuration with the default values.
@@ -125,17 +208,73 @@ func New() *AgentConfig {
 		BucketInterval:   time.Duration(10) * time.Second,
 		ExtraAggregators: []string{"http.status_code"},
 
+		Stats: &StatsConfig{
+			MaxUniqueKeysPerService: 10000,
+			BackgroundFlushInterval: 30 * time.Second,
+		},
+
 		ExtraSampleRate: 1.0,
 		MaxTPS:          10,
 		MaxEPS:          200,
+		Sampler: &SamplerConfig{
+			MaxRateChangePerSecond: 0.5,
+			DampingFactor:          0.3,
+			CanaryRollbackRate:     0.01,
+			MaxPanicsBeforeDisable: 3,
+			ABTest: ABTestConfig{
+				TreatmentFraction: 0.1,
+				Algorithm:         "tps",
+			},
+		},
 
 		ReceiverHost:    "localhost",
 		ReceiverPort:    8126,
 		ConnectionLimit: 2000,
+		ReceiverPipeline: &PipelineConfig{
+			DecodeQueueSize:    16,
+			NormalizeQueueSize: 1000,
+			ObfuscateQueueSize: 1000,
+		},
+		FlushFeedback:      &FlushFeedbackConfig{HighLoad: 0.5, LowLoad: 0.2},
+		ContentNegotiation: &ContentNegotiationConfig{Alpha: 0.1},
+		EarlyTermination:   &EarlyTerminationConfig{HighLoad: 0.8, KeepRate: 0.1},
+		Kubernetes: &KubernetesConfig{
+			WebhookPort: 8443,
+			NodeLabel:   "datadoghq.com/trace-agent",
+		},
+		Gateway: &GatewayConfig{
+			HTTPAddr:    "localhost:8127",
+			GRPCAddr:    "localhost:8128",
+			KafkaTopic:  "datadog-traces",
+			KafkaGroup:  "datadog-trace-agent",
+			NATSURL:     "nats://localhost:4222",
+			NATSSubject: "datadog.traces",
+			NATSQueue:   "datadog-trace-agent",
+		},
+		MultiTenant:      &MultiTenantConfig{CustomerIDTag: "customer.id"},
+		ConfigVersionTTL: 5 * time.Minute,
 
 		StatsWriter: new(WriterConfig),
 		TraceWriter: new(WriterConfig),
 
+		Aggregator: &AggregatorConfig{FlushTimeout: 5 * time.Second, ClosedTraceTTL: 30 * time.Second},
+		Coalescing: &CoalescingConfig{Window: 500 * time.Millisecond},
+		Normalization: &NormalizationConfig{
+			ParentResolutionWindow: 500 * time.Millisecond,
+		},
+		Debug: &DebugConfig{},
+		Enrichment: &EnrichmentConfig{
+			FeatureStore: FeatureStoreConfig{
+				CacheTTL: 5 * time.Minute,
+				MaxRPS:   100,
+			},
+		},
+		Synthetics:     &SyntheticsConfig{CacheTTL: time.Minute},
+		Cost:           &CostConfig{VCPUs: 1},
+		SpanConfig:     &SpanConfigOverrideConfig{TTL: 5 * time.Minute},
+		Reconstruction: &ReconstructionConfig{TTL: 30 * time.Second},
+		ServiceMap:     new(ServiceMapConfig),
+
 		StatsdHost: "localhost",
 		StatsdPort: 8125,
 
@@ -150,6 +289,7 @@ func New() *AgentConfig {
 		Ignore:                      make(map[string][]string),
 		AnalyzedRateByServiceLegacy: make(map[string]float64),
 		AnalyzedSpansByService:      make(map[string]map[string]float64),
+		AnalyzedEvents:              new(AnalyzedEventsConfig),
 	}
 }
 
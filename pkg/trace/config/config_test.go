@@ -110,6 +110,8 @@ func TestDefaultConfig(t *testing.T) {
 	assert.Equal("INFO", c.LogLevel)
 	assert.Equal(true, c.Enabled)
 
+	// the annotation API is opt-in
+	assert.Zero(c.AnnotationTTL)
 }
 
 func TestOnlyDDAgentConfig(t *testing.T) {
@@ -298,7 +300,11 @@ func TestUndocumentedYamlConfig(t *testing.T) {
 	assert.Equal(0.33, c.ExtraSampleRate)
 	assert.Equal(100.0, c.MaxTPS)
 	assert.Equal(1000.0, c.MaxEPS)
+	assert.Equal(500, c.MaxSpansPerTrace)
 	assert.Equal(25, c.ReceiverPort)
+	assert.Equal(90, c.MaxTraceAgeMinutes)
+	assert.Equal(500, c.Stats.MaxUniqueKeysPerService)
+	assert.Equal(time.Minute, c.Stats.BackgroundFlushInterval)
 	// watchdog
 	assert.Equal(0.07, c.MaxCPU)
 	assert.Equal(30e6, c.MaxMemory)
@@ -306,8 +312,122 @@ func TestUndocumentedYamlConfig(t *testing.T) {
 	// Assert Trace Writer
 	assert.Equal(1, c.TraceWriter.ConnectionLimit)
 	assert.Equal(2, c.TraceWriter.QueueSize)
+	assert.Equal([]string{"tls.certificate", "grpc.request.payload"}, c.TraceWriter.Base85EncodeBinaryTags)
+	assert.Equal(map[string]int{"billing": 10, "web": 5}, c.TraceWriter.DeadLetterReplayPriority)
 	assert.Equal(5, c.StatsWriter.ConnectionLimit)
 	assert.Equal(6, c.StatsWriter.QueueSize)
+	// sampler
+	assert.True(c.Sampler.UseBudgetDistribution)
+	assert.Equal([]string{"score", "tps", "hash"}, c.Sampler.Ensemble.Algorithms)
+	assert.Equal([]float64{0.5, 0.3, 0.2}, c.Sampler.Ensemble.Weights)
+	assert.Equal(0.25, c.Sampler.MaxRateChangePerSecond)
+	assert.Equal(0.4, c.Sampler.DampingFactor)
+	assert.True(c.Sampler.InverseFrequency)
+	assert.Equal("-canary$", c.Sampler.CanaryVersionPattern)
+	assert.True(c.Sampler.RollbackActive)
+	assert.Equal(0.05, c.Sampler.CanaryRollbackRate)
+	assert.Equal(5, c.Sampler.MaxPanicsBeforeDisable)
+	assert.Equal(50, c.Sampler.HoldoutSize)
+	assert.Equal("business.impact", c.Sampler.BusinessImpactTag)
+	assert.Equal(map[string]float64{"critical": 10, "low": 0.1}, c.Sampler.BusinessImpactWeights)
+	assert.True(c.Sampler.ABTest.Enabled)
+	assert.Equal(0.2, c.Sampler.ABTest.TreatmentFraction)
+	assert.Equal("hash", c.Sampler.ABTest.Algorithm)
+	// receiver pipeline
+	assert.True(c.ReceiverPipeline.Enabled)
+	assert.Equal(3, c.ReceiverPipeline.Workers)
+	assert.Equal(4, c.ReceiverPipeline.DecodeQueueSize)
+	assert.Equal(1000, c.ReceiverPipeline.NormalizeQueueSize)
+	// flush interval feedback
+	assert.True(c.FlushFeedback.Enabled)
+	assert.Equal(0.6, c.FlushFeedback.HighLoad)
+	assert.Equal(0.1, c.FlushFeedback.LowLoad)
+	assert.True(c.ContentNegotiation.Enabled)
+	assert.Equal(0.3, c.ContentNegotiation.Alpha)
+	assert.True(c.EarlyTermination.Enabled)
+	assert.Equal(0.9, c.EarlyTermination.HighLoad)
+	assert.Equal(0.05, c.EarlyTermination.KeepRate)
+	assert.True(c.Kubernetes.WebhookEnabled)
+	assert.Equal(9443, c.Kubernetes.WebhookPort)
+	assert.Equal("/etc/datadog-agent/webhook/tls.crt", c.Kubernetes.WebhookCertFile)
+	assert.Equal("/etc/datadog-agent/webhook/tls.key", c.Kubernetes.WebhookKeyFile)
+	assert.Equal("example.com/apm", c.Kubernetes.NodeLabel)
+	assert.Equal(&GatewayConfig{
+		Protocols:    []string{"http", "nats"},
+		HTTPAddr:     "0.0.0.0:9127",
+		GRPCAddr:     "localhost:8128",
+		KafkaBrokers: []string{"kafka-1:9092", "kafka-2:9092"},
+		KafkaTopic:   "datadog-traces",
+		KafkaGroup:   "datadog-trace-agent",
+		KafkaUser:    "datadog",
+		NATSURL:      "nats://nats.example.com:4222",
+		NATSSubject:  "apm.traces",
+		NATSQueue:    "datadog-trace-agent",
+		AuthTokens:   map[string]string{"http": "http-secret", "nats": "nats-secret"},
+		RateLimits:   map[string]float64{"nats": 500},
+	}, c.Gateway)
+	assert.Equal(time.Minute, c.AnnotationTTL)
+	assert.Equal(2*time.Minute, c.ConfigVersionTTL)
+	// span aggregator
+	assert.True(c.Aggregator.Enabled)
+	assert.Equal(2500*time.Millisecond, c.Aggregator.FlushTimeout)
+	assert.True(c.Aggregator.SuppressLateSpans)
+	assert.Equal(45*time.Second, c.Aggregator.ClosedTraceTTL)
+	// normalization
+	assert.True(c.Normalization.ParentResolution)
+	assert.Equal(750*time.Millisecond, c.Normalization.ParentResolutionWindow)
+	// event coalescer
+	assert.True(c.Coalescing.Enabled)
+	assert.Equal(250*time.Millisecond, c.Coalescing.Window)
+	// debug
+	assert.Equal(4.5, c.Debug.MahalanobisThreshold)
+	assert.True(c.Debug.TopologyTracking)
+	assert.True(c.Debug.ContextLeakDetection)
+	assert.True(c.Debug.CausalCorrelation)
+	assert.True(c.Debug.SchemaDocumentation)
+	assert.True(c.Debug.PipelineProfiling)
+	assert.Equal("/etc/datadog-agent/span_importance.onnx", c.Debug.SpanImportanceModel)
+	// enrichment
+	assert.Equal("http://localhost:8500/features", c.Enrichment.FeatureStore.URL)
+	assert.Equal(30*time.Second, c.Enrichment.FeatureStore.CacheTTL)
+	assert.Equal(20.0, c.Enrichment.FeatureStore.MaxRPS)
+	assert.True(c.Enrichment.PropagateDeadlines)
+	assert.True(c.Enrichment.ValidateAPIContracts)
+	assert.Equal(map[string]string{
+		"users":   "/etc/datadog-agent/openapi/users.yaml",
+		"billing": "/etc/datadog-agent/openapi/billing.json",
+	}, c.Enrichment.OpenAPISpecs)
+	// cost
+	assert.Equal(0.096, c.Cost.ComputePricePerHour)
+	assert.Equal(2, c.Cost.VCPUs)
+	// synthetics
+	assert.Equal("http://localhost:8600/synthetics/metadata", c.Synthetics.MetadataEndpoint)
+	assert.Equal(2*time.Minute, c.Synthetics.CacheTTL)
+	assert.Equal(uint64(0xDEAD0000), c.Synthetics.TraceIDRangeStart)
+	assert.Equal(uint64(0xDEADFFFF), c.Synthetics.TraceIDRangeEnd)
+	assert.Equal("https://synthetics.trace.agent.datadoghq.com", c.Synthetics.Endpoint)
+	// span config
+	assert.True(c.SpanConfig.Enabled)
+	assert.Equal(time.Minute, c.SpanConfig.TTL)
+	assert.Equal(500.0, c.SpanConfig.MaxEPSLimit)
+	// service map
+	if assert.Len(c.ServiceMap.GroupingRules, 2) {
+		assert.Equal("payments", c.ServiceMap.Group("payment-api"))
+		assert.Equal("databases", c.ServiceMap.Group("users-db"))
+		assert.Equal("web", c.ServiceMap.Group("web"))
+	}
+	assert.Equal([]string{"domain", "team"}, c.ServiceMap.HierarchyLabels)
+	// multi tenant
+	assert.Equal([]TenantMapping{
+		{APIKeyPrefix: "aaaa", OrgID: "1001", Endpoint: "https://trace.agent.datadoghq.com", APIKey: "1001key"},
+		{APIKeyPrefix: "bbbb", OrgID: "1002", Endpoint: "https://trace.agent.datadoghq.eu", APIKey: "1002key"},
+	}, c.MultiTenant.TenantMapping)
+	assert.Equal(int64(6000), c.MultiTenant.TracesBudgetPerCustomerPerMinute)
+	assert.Equal("tenant.id", c.MultiTenant.CustomerIDTag)
+	// trace reconstruction
+	assert.Equal("localhost:6379", c.Reconstruction.RedisAddr)
+	assert.Equal(10*time.Second, c.Reconstruction.TTL)
+	assert.True(c.Reconstruction.RelinkRoots)
 	// analysis legacy
 	assert.Equal(1.0, c.AnalyzedRateByServiceLegacy["db"])
 	assert.Equal(0.9, c.AnalyzedRateByServiceLegacy["web"])
@@ -319,6 +439,11 @@ func TestUndocumentedYamlConfig(t *testing.T) {
 	assert.Equal(0.8, c.AnalyzedSpansByService["web"]["request"])
 	assert.Equal(0.9, c.AnalyzedSpansByService["web"]["django.request"])
 	assert.Equal(0.05, c.AnalyzedSpansByService["db"]["intake"])
+	// analyzed events routing
+	assert.True(c.AnalyzedEvents.Enabled())
+	assert.Equal([]string{"checkout", "payment"}, c.AnalyzedEvents.HighValueServices)
+	assert.Equal("https://analytics.datadoghq.com", c.AnalyzedEvents.HighValueEndpoint)
+	assert.Equal("apikey_hv", c.AnalyzedEvents.HighValueAPIKey)
 }
 
 func TestAcquireHostname(t *testing.T) {
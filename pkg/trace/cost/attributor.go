@@ -0,0 +1,100 @@
+// Package cost estimates the compute cost of the spans received by the agent.
+package cost
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/traceutil"
+)
+
+const (
+	// costEstimateKey is the metric set on spans to their estimated cost.
+	costEstimateKey = "_dd.cost_estimate"
+	// cpuShareKey is the metric spans can report the share of the CPU of the
+	// instance they used in, between 0 and 1.
+	cpuShareKey = "_dd.cpu_share"
+)
+
+// ResourceCostAttributor estimates the cost of the compute time of spans from
+// the hourly price of the instance running them: a span costs its duration
+// times the share of the instance's CPU it used times the price. Spans can
+// report their CPU share with the _dd.cpu_share metric, otherwise they are
+// considered to use a single vCPU. The costs of the top-level spans are summed
+// by service.
+type ResourceCostAttributor struct {
+	pricePerSecond  float64
+	defaultCPUShare float64
+
+	mu        sync.Mutex
+	byService map[string]float64
+}
+
+// NewResourceCostAttributor returns a new ResourceCostAttributor for instances
+// with the given hourly price and number of vCPUs.
+func NewResourceCostAttributor(pricePerHour float64, vcpus int) *ResourceCostAttributor {
+	if vcpus < 1 {
+		vcpus = 1
+	}
+	return &ResourceCostAttributor{
+		pricePerSecond:  pricePerHour / 3600,
+		defaultCPUShare: 1 / float64(vcpus),
+		byService:       make(map[string]float64),
+	}
+}
+
+// Attribute sets the estimated cost of each span of t as its _dd.cost_estimate
+// metric, and adds the cost of its top-level spans to their services. Top-level
+// spans must have been computed.
+func (a *ResourceCostAttributor) Attribute(t pb.Trace) {
+	costs := make(map[string]float64)
+	for _, s := range t {
+		c := a.Estimate(s)
+		if s.Metrics == nil {
+			s.Metrics = make(map[string]float64, 1)
+		}
+		s.Metrics[costEstimateKey] = c
+		// the time of the other spans of a service is covered by its top-level
+		// spans
+		if traceutil.HasTopLevel(s) {
+			costs[s.Service] += c
+		}
+	}
+	a.mu.Lock()
+	for service, c := range costs {
+		a.byService[service] += c
+	}
+	a.mu.Unlock()
+}
+
+// Estimate returns the estimated cost of s.
+func (a *ResourceCostAttributor) Estimate(s *pb.Span) float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	share := a.defaultCPUShare
+	if v, ok := s.Metrics[cpuShareKey]; ok && v > 0 && v <= 1 {
+		share = v
+	}
+	return float64(s.Duration) / 1e9 * share * a.pricePerSecond
+}
+
+// ByService returns the estimated cost of the spans of each service since the
+// agent started.
+func (a *ResourceCostAttributor) ByService() map[string]float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	costs := make(map[string]float64, len(a.byService))
+	for service, c := range a.byService {
+		costs[service] = c
+	}
+	return costs
+}
+
+// ServeHTTP writes the estimated cost by service as JSON.
+func (a *ResourceCostAttributor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.ByService())
+}
@@ -0,0 +1,116 @@
+package cost
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/traceutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// EC2 on-demand prices in us-east-1.
+const (
+	m5LargePrice    = 0.096 // 2 vCPUs
+	c54XLargePrice  = 0.68  // 16 vCPUs
+	costTestEpsilon = 1e-12
+)
+
+func TestEstimate(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		price    float64
+		vcpus    int
+		span     *pb.Span
+		expected float64
+	}{
+		{
+			name:     "m5.large one hour",
+			price:    m5LargePrice,
+			vcpus:    2,
+			span:     &pb.Span{Duration: int64(time.Hour)},
+			expected: 0.048,
+		},
+		{
+			name:     "m5.large 250ms",
+			price:    m5LargePrice,
+			vcpus:    2,
+			span:     &pb.Span{Duration: int64(250 * time.Millisecond)},
+			expected: 0.25 * 0.5 * 0.096 / 3600,
+		},
+		{
+			name:     "c5.4xlarge 2s",
+			price:    c54XLargePrice,
+			vcpus:    16,
+			span:     &pb.Span{Duration: int64(2 * time.Second)},
+			expected: 2 * 0.68 / 16 / 3600,
+		},
+		{
+			name:     "c5.4xlarge 2s with reported CPU share",
+			price:    c54XLargePrice,
+			vcpus:    16,
+			span:     &pb.Span{Duration: int64(2 * time.Second), Metrics: map[string]float64{"_dd.cpu_share": 0.5}},
+			expected: 2 * 0.5 * 0.68 / 3600,
+		},
+		{
+			name:     "invalid CPU share",
+			price:    c54XLargePrice,
+			vcpus:    16,
+			span:     &pb.Span{Duration: int64(2 * time.Second), Metrics: map[string]float64{"_dd.cpu_share": 4}},
+			expected: 2 * 0.68 / 16 / 3600,
+		},
+		{
+			name:     "no vCPU count",
+			price:    m5LargePrice,
+			vcpus:    0,
+			span:     &pb.Span{Duration: int64(time.Minute)},
+			expected: 0.096 / 60,
+		},
+		{
+			name:     "negative duration",
+			price:    m5LargePrice,
+			vcpus:    2,
+			span:     &pb.Span{Duration: -1},
+			expected: 0,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewResourceCostAttributor(tt.price, tt.vcpus)
+			assert.InDelta(t, tt.expected, a.Estimate(tt.span), costTestEpsilon)
+		})
+	}
+}
+
+func TestAttribute(t *testing.T) {
+	assert := assert.New(t)
+	a := NewResourceCostAttributor(m5LargePrice, 2)
+
+	trace := pb.Trace{
+		{SpanID: 1, Service: "web", Duration: int64(2 * time.Second)},
+		{SpanID: 2, ParentID: 1, Service: "web", Duration: int64(time.Second)},
+		{SpanID: 3, ParentID: 2, Service: "db", Duration: int64(500 * time.Millisecond), Metrics: map[string]float64{"_dd.cpu_share": 1}},
+	}
+	traceutil.ComputeTopLevel(trace)
+	a.Attribute(trace)
+	a.Attribute(trace)
+
+	perSecond := m5LargePrice / 3600
+	assert.InDelta(2*0.5*perSecond, trace[0].Metrics["_dd.cost_estimate"], costTestEpsilon)
+	assert.InDelta(0.5*perSecond, trace[1].Metrics["_dd.cost_estimate"], costTestEpsilon)
+	assert.InDelta(0.5*perSecond, trace[2].Metrics["_dd.cost_estimate"], costTestEpsilon)
+
+	costs := a.ByService()
+	assert.Len(costs, 2)
+	assert.InDelta(2*perSecond, costs["web"], costTestEpsilon, "only top-level spans are summed")
+	assert.InDelta(perSecond, costs["db"], costTestEpsilon)
+
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/cost/by_service", nil))
+	assert.Equal("application/json", rec.Header().Get("Content-Type"))
+	var served map[string]float64
+	assert.NoError(json.NewDecoder(rec.Body).Decode(&served))
+	assert.Equal(costs, served)
+}
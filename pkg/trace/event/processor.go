@@ -1,6 +1,8 @@
 package event
 
 import (
+	"sync"
+
 	"github.com/DataDog/datadog-agent/pkg/trace/pb"
 	"github.com/DataDog/datadog-agent/pkg/trace/sampler"
 )
@@ -9,6 +11,24 @@ import (
 type Processor struct {
 	extractors    []Extractor
 	maxEPSSampler eventSampler
+
+	// highValueServices holds the services whose events are returned apart.
+	highValueServices map[string]struct{}
+
+	// overrides holds the max EPS of the services which override it, whose
+	// events are sampled apart. It is nil when overrides are disabled.
+	overrides      MaxEPSOverrides
+	newRateCounter func() rateCounter
+	mu             sync.Mutex
+	serviceRates   map[string]rateCounter // event rate of services with an override
+}
+
+// MaxEPSOverrides provides the max events per second of the services which
+// override the global one.
+type MaxEPSOverrides interface {
+	// MaxEPS returns the max EPS of service, and whether it overrides the
+	// global one.
+	MaxEPS(service string) (float64, bool)
 }
 
 // NewProcessor returns a new instance of Processor configured with the provided extractors and max eps limitation.
@@ -30,6 +50,27 @@ func newProcessor(extractors []Extractor, maxEPSSampler eventSampler) *Processor
 	return &Processor{
 		extractors:    extractors,
 		maxEPSSampler: maxEPSSampler,
+		newRateCounter: func() rateCounter {
+			return newSamplerBackendRateCounter()
+		},
+	}
+}
+
+// SetMaxEPSOverrides makes the processor sample the events of the services
+// overriding the max EPS at their own max EPS, before they are sampled with
+// the other events at the global max EPS. It must be called before Start.
+func (p *Processor) SetMaxEPSOverrides(overrides MaxEPSOverrides) {
+	p.overrides = overrides
+	p.serviceRates = make(map[string]rateCounter)
+}
+
+// SetHighValueServices makes the processor return the events of the given
+// services apart from the other events, so that they can be sent to a
+// dedicated endpoint. It must be called before Start.
+func (p *Processor) SetHighValueServices(services []string) {
+	p.highValueServices = make(map[string]struct{}, len(services))
+	for _, s := range services {
+		p.highValueServices[s] = struct{}{}
 	}
 }
 
@@ -41,11 +82,18 @@ func (p *Processor) Start() {
 // Stop stops the processor.
 func (p *Processor) Stop() {
 	p.maxEPSSampler.Stop()
+	p.mu.Lock()
+	for service, rc := range p.serviceRates {
+		rc.Stop()
+		delete(p.serviceRates, service)
+	}
+	p.mu.Unlock()
 }
 
 // Process takes a processed trace, extracts events from it and samples them, returning a collection of
-// sampled events along with the total count of extracted events.
-func (p *Processor) Process(root *pb.Span, t pb.Trace) (events []*pb.Span, numExtracted int64) {
+// sampled events along with the total count of extracted events. The sampled events of high value services
+// are returned in highValueEvents instead of events.
+func (p *Processor) Process(root *pb.Span, t pb.Trace) (events, highValueEvents []*pb.Span, numExtracted int64) {
 	if len(p.extractors) == 0 {
 		return
 	}
@@ -82,10 +130,14 @@ func (p *Processor) Process(root *pb.Span, t pb.Trace) (events []*pb.Span, numEx
 			sampler.SetSamplingPriority(span, priority)
 		}
 
+		if _, ok := p.highValueServices[span.Service]; ok {
+			highValueEvents = append(highValueEvents, span)
+			continue
+		}
 		events = append(events, span)
 	}
 
-	return events, numExtracted
+	return events, highValueEvents, numExtracted
 }
 
 func (p *Processor) extract(span *pb.Span, priority sampler.SamplingPriority) (float64, bool) {
@@ -101,9 +153,53 @@ func (p *Processor) maxEPSSample(event *pb.Span, priority sampler.SamplingPriori
 	if priority == sampler.PriorityUserKeep {
 		return true, 1
 	}
+	if p.overrides != nil {
+		if maxEPS, ok := p.overrides.MaxEPS(event.Service); ok {
+			sampled, rate = p.serviceMaxEPSSample(event, maxEPS)
+			if !sampled {
+				return false, rate
+			}
+			// the events of the service still count against the global max EPS
+			var globalRate float64
+			sampled, globalRate = p.maxEPSSampler.Sample(event)
+			return sampled, rate * globalRate
+		}
+		p.stopServiceRate(event.Service)
+	}
 	return p.maxEPSSampler.Sample(event)
 }
 
+// serviceMaxEPSSample samples the event of a service overriding the max EPS
+// so that no more than maxEPS of its events are sampled every second.
+func (p *Processor) serviceMaxEPSSample(event *pb.Span, maxEPS float64) (sampled bool, rate float64) {
+	p.mu.Lock()
+	rc, ok := p.serviceRates[event.Service]
+	if !ok {
+		rc = p.newRateCounter()
+		rc.Start()
+		p.serviceRates[event.Service] = rc
+	}
+	p.mu.Unlock()
+
+	rc.Count()
+	rate = 1.0
+	if currentEPS := rc.GetRate(); currentEPS > maxEPS {
+		rate = maxEPS / currentEPS
+	}
+	return sampler.SampleByRate(event.TraceID, rate), rate
+}
+
+// stopServiceRate stops counting the events of a service which does not
+// override the max EPS anymore.
+func (p *Processor) stopServiceRate(service string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if rc, ok := p.serviceRates[service]; ok {
+		rc.Stop()
+		delete(p.serviceRates, service)
+	}
+}
+
 type eventSampler interface {
 	Start()
 	Sample(event *pb.Span) (sampled bool, rate float64)
@@ -64,7 +64,7 @@ func TestProcessor(t *testing.T) {
 			}
 
 			p.Start()
-			events, extracted := p.Process(root, testTrace)
+			events, _, extracted := p.Process(root, testTrace)
 			p.Stop()
 			total := len(testTrace)
 			returned := len(events)
@@ -100,6 +100,69 @@ func TestProcessor(t *testing.T) {
 	}
 }
 
+func TestProcessorHighValueServices(t *testing.T) {
+	assert := assert.New(t)
+
+	p := newProcessor([]Extractor{&MockExtractor{Rate: 1}}, &MockEventSampler{Rate: 1})
+	p.SetHighValueServices([]string{"checkout", "payment"})
+
+	trace := pb.Trace{
+		{TraceID: 1, SpanID: 1, Service: "web"},
+		{TraceID: 1, SpanID: 2, ParentID: 1, Service: "checkout"},
+		{TraceID: 1, SpanID: 3, ParentID: 2, Service: "payment"},
+		{TraceID: 1, SpanID: 4, ParentID: 2, Service: "db"},
+	}
+	p.Start()
+	events, highValueEvents, extracted := p.Process(trace[0], trace)
+	p.Stop()
+
+	assert.EqualValues(4, extracted)
+	assert.Equal([]*pb.Span{trace[0], trace[3]}, events)
+	assert.Equal([]*pb.Span{trace[1], trace[2]}, highValueEvents)
+}
+
+type mockMaxEPSOverrides map[string]float64
+
+func (o mockMaxEPSOverrides) MaxEPS(service string) (float64, bool) {
+	eps, ok := o[service]
+	return eps, ok
+}
+
+func TestProcessorMaxEPSOverrides(t *testing.T) {
+	assert := assert.New(t)
+
+	overrides := mockMaxEPSOverrides{"checkout": 10}
+	testSampler := &MockEventSampler{Rate: 1}
+	p := newProcessor([]Extractor{&MockExtractor{Rate: 1}}, testSampler)
+	p.SetMaxEPSOverrides(overrides)
+	counter := &MockRateCounter{GetRateResult: 20}
+	p.newRateCounter = func() rateCounter { return counter }
+
+	trace := pb.Trace{
+		{TraceID: 1, SpanID: 1, Service: "web"},
+		{TraceID: 1, SpanID: 2, ParentID: 1, Service: "checkout"},
+	}
+	p.Start()
+	events, _, extracted := p.Process(trace[0], trace)
+	assert.EqualValues(2, extracted)
+	// the kept events of the service are also sampled at the global max EPS
+	assert.EqualValues(2, testSampler.SampleCalls)
+	assert.EqualValues(1, counter.CountCalls)
+	for _, event := range events {
+		if event.Service == "checkout" {
+			assert.EqualValues(0.5, sampler.GetMaxEPSRate(event))
+		}
+	}
+
+	// once the override is gone, the service is sampled with the others
+	delete(overrides, "checkout")
+	p.Process(trace[0], trace)
+	assert.EqualValues(4, testSampler.SampleCalls)
+	assert.EqualValues(1, counter.CountCalls)
+	assert.Empty(p.serviceRates)
+	p.Stop()
+}
+
 type MockExtractor struct {
 	Rate float64
 }
@@ -194,16 +194,25 @@ type TracesDropped struct {
 	SpanIDZero int64
 	// ForeignSpan is when a span in a trace has a TraceId that is different than the first span in the trace
 	ForeignSpan int64
+	// EarlyTermination is when a low priority trace is dropped on receipt during high load
+	EarlyTermination int64
+	// ClosedTrace is when spans are received for a trace which was already declared complete
+	ClosedTrace int64
+	// TooOld is when the spans of a trace started too long before it was received
+	TooOld int64
 }
 
 // tagValues converts TracesDropped into a map representation with keys matching standardized names for all reasons
 func (s *TracesDropped) tagValues() map[string]int64 {
 	return map[string]int64{
-		"decoding_error": atomic.LoadInt64(&s.DecodingError),
-		"empty_trace":    atomic.LoadInt64(&s.EmptyTrace),
-		"trace_id_zero":  atomic.LoadInt64(&s.TraceIDZero),
-		"span_id_zero":   atomic.LoadInt64(&s.SpanIDZero),
-		"foreign_span":   atomic.LoadInt64(&s.ForeignSpan),
+		"decoding_error":    atomic.LoadInt64(&s.DecodingError),
+		"empty_trace":       atomic.LoadInt64(&s.EmptyTrace),
+		"trace_id_zero":     atomic.LoadInt64(&s.TraceIDZero),
+		"span_id_zero":      atomic.LoadInt64(&s.SpanIDZero),
+		"foreign_span":      atomic.LoadInt64(&s.ForeignSpan),
+		"early_termination": atomic.LoadInt64(&s.EarlyTermination),
+		"closed_trace":      atomic.LoadInt64(&s.ClosedTrace),
+		"too_old":           atomic.LoadInt64(&s.TooOld),
 	}
 }
 
@@ -306,6 +315,9 @@ func (s *Stats) update(recent *Stats) {
 	atomic.AddInt64(&s.TracesDropped.TraceIDZero, atomic.LoadInt64(&recent.TracesDropped.TraceIDZero))
 	atomic.AddInt64(&s.TracesDropped.SpanIDZero, atomic.LoadInt64(&recent.TracesDropped.SpanIDZero))
 	atomic.AddInt64(&s.TracesDropped.ForeignSpan, atomic.LoadInt64(&recent.TracesDropped.ForeignSpan))
+	atomic.AddInt64(&s.TracesDropped.EarlyTermination, atomic.LoadInt64(&recent.TracesDropped.EarlyTermination))
+	atomic.AddInt64(&s.TracesDropped.ClosedTrace, atomic.LoadInt64(&recent.TracesDropped.ClosedTrace))
+	atomic.AddInt64(&s.TracesDropped.TooOld, atomic.LoadInt64(&recent.TracesDropped.TooOld))
 	atomic.AddInt64(&s.SpansMalformed.DuplicateSpanID, atomic.LoadInt64(&recent.SpansMalformed.DuplicateSpanID))
 	atomic.AddInt64(&s.SpansMalformed.ServiceEmpty, atomic.LoadInt64(&recent.SpansMalformed.ServiceEmpty))
 	atomic.AddInt64(&s.SpansMalformed.ServiceTruncate, atomic.LoadInt64(&recent.SpansMalformed.ServiceTruncate))
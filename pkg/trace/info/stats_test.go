@@ -16,11 +16,14 @@ func TestTracesDropped(t *testing.T) {
 
 	t.Run("tagValues", func(t *testing.T) {
 		assert.Equal(t, map[string]int64{
-			"empty_trace":    0,
-			"decoding_error": 1,
-			"foreign_span":   1,
-			"trace_id_zero":  1,
-			"span_id_zero":   1,
+			"empty_trace":       0,
+			"decoding_error":    1,
+			"foreign_span":      1,
+			"trace_id_zero":     1,
+			"span_id_zero":      1,
+			"early_termination": 0,
+			"closed_trace":      0,
+			"too_old":           0,
 		}, s.tagValues())
 	})
 
@@ -0,0 +1,48 @@
+package timing
+
+import (
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/stats/quantile"
+)
+
+// LocalHistogram aggregates durations in memory so that their quantiles can be
+// computed without sending every duration to statsd. It is safe for
+// concurrent use.
+type LocalHistogram struct {
+	mu      sync.Mutex
+	summary *quantile.SliceSummary
+}
+
+// NewLocalHistogram returns a new, empty LocalHistogram.
+func NewLocalHistogram() *LocalHistogram {
+	return &LocalHistogram{summary: quantile.NewSliceSummary()}
+}
+
+// Since records the time passed since start, in milliseconds.
+func (h *LocalHistogram) Since(start time.Time) {
+	h.Add(float64(time.Since(start)) / float64(time.Millisecond))
+}
+
+// Add records the given duration, in milliseconds.
+func (h *LocalHistogram) Add(ms float64) {
+	h.mu.Lock()
+	h.summary.Insert(ms, 0)
+	h.mu.Unlock()
+}
+
+// Flush returns the number of durations recorded and their quantiles qs, and
+// empties the histogram. The quantiles are 0 when nothing was recorded.
+func (h *LocalHistogram) Flush(qs ...float64) (count int, values []float64) {
+	h.mu.Lock()
+	summary := h.summary
+	h.summary = quantile.NewSliceSummary()
+	h.mu.Unlock()
+
+	values = make([]float64, len(qs))
+	for i, q := range qs {
+		values[i] = summary.Quantile(q)
+	}
+	return summary.N, values
+}
@@ -0,0 +1,140 @@
+package timing
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/watchdog"
+)
+
+// The stages of the trace processing pipeline profiled by PipelineProfiler.
+const (
+	// StageDecode is the decoding of trace payloads.
+	StageDecode = "decode"
+	// StageNormalize is the normalization of traces.
+	StageNormalize = "normalize"
+	// StageObfuscate is the obfuscation and truncation of the spans of traces.
+	StageObfuscate = "obfuscate"
+	// StageSample is the sampling of traces.
+	StageSample = "sample"
+	// StageWrite is the hand-off of sampled traces to the writers, which
+	// grows when they fall behind.
+	StageWrite = "write"
+)
+
+// PipelineLatencyPath is the path of the debug endpoint serving the latencies
+// of the pipeline stages.
+const PipelineLatencyPath = "/debug/pipeline/latency"
+
+// pipelineStages lists the profiled stages, in pipeline order.
+var pipelineStages = []string{StageDecode, StageNormalize, StageObfuscate, StageSample, StageWrite}
+
+// StageLatency is the latency distribution of a pipeline stage over a
+// reporting interval, in milliseconds.
+type StageLatency struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50_ms"`
+	P95   float64 `json:"p95_ms"`
+	P99   float64 `json:"p99_ms"`
+}
+
+// PipelineProfiler records the latency distribution of each stage of the
+// trace processing pipeline, to find out the minimum processing time of traces
+// for capacity planning. Every 10 seconds, the 50th, 95th and 99th percentiles
+// of each stage are reported as the
+// datadog.trace_agent.pipeline.{stage}_latency_ms.p50, .p95 and .p99 gauges,
+// and served on /debug/pipeline/latency. It is safe for concurrent use.
+type PipelineProfiler struct {
+	stages map[string]*LocalHistogram
+
+	mu        sync.Mutex
+	latencies map[string]StageLatency // reported last, by stage
+
+	exit chan struct{}
+}
+
+// NewPipelineProfiler returns a new PipelineProfiler.
+func NewPipelineProfiler() *PipelineProfiler {
+	stages := make(map[string]*LocalHistogram, len(pipelineStages))
+	for _, stage := range pipelineStages {
+		stages[stage] = NewLocalHistogram()
+	}
+	return &PipelineProfiler{
+		stages: stages,
+		exit:   make(chan struct{}),
+	}
+}
+
+// Start starts reporting the latencies every AutoreportInterval.
+func (p *PipelineProfiler) Start() {
+	go func() {
+		defer watchdog.LogOnPanic()
+		tick := time.NewTicker(AutoreportInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				p.Report()
+			case <-p.exit:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops reporting the latencies.
+func (p *PipelineProfiler) Stop() {
+	close(p.exit)
+}
+
+// Since records the time passed since start as a latency of the given stage.
+// Unknown stages are ignored.
+func (p *PipelineProfiler) Since(stage string, start time.Time) {
+	if h, ok := p.stages[stage]; ok {
+		h.Since(start)
+	}
+}
+
+// Report reports the latencies recorded since the previous report and starts
+// a new reporting interval. It returns the reported latencies, by stage.
+func (p *PipelineProfiler) Report() map[string]StageLatency {
+	latencies := make(map[string]StageLatency, len(p.stages))
+	for _, stage := range pipelineStages {
+		count, q := p.stages[stage].Flush(0.5, 0.95, 0.99)
+		l := StageLatency{Count: count, P50: q[0], P95: q[1], P99: q[2]}
+		latencies[stage] = l
+		if count == 0 {
+			continue
+		}
+		name := "datadog.trace_agent.pipeline." + stage + "_latency_ms"
+		metrics.Gauge(name+".p50", l.P50, nil, 1)
+		metrics.Gauge(name+".p95", l.P95, nil, 1)
+		metrics.Gauge(name+".p99", l.P99, nil, 1)
+	}
+	p.mu.Lock()
+	p.latencies = latencies
+	p.mu.Unlock()
+	return latencies
+}
+
+// Latencies returns the latencies reported last, by stage. It is nil until the
+// first report.
+func (p *PipelineProfiler) Latencies() map[string]StageLatency {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.latencies
+}
+
+// ServeHTTP writes the latencies reported last.
+func (p *PipelineProfiler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	latencies := p.Latencies()
+	if latencies == nil {
+		http.Error(w, "no pipeline latencies reported yet", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(latencies)
+}
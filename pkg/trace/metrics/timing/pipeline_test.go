@@ -0,0 +1,87 @@
+package timing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/test/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalHistogram(t *testing.T) {
+	assert := assert.New(t)
+	h := NewLocalHistogram()
+	for i := 1; i <= 1000; i++ {
+		h.Add(float64(i))
+	}
+	count, q := h.Flush(0.5, 0.95, 0.99)
+	assert.Equal(1000, count)
+	assert.InDelta(500, q[0], 20)
+	assert.InDelta(950, q[1], 20)
+	assert.InDelta(990, q[2], 20)
+
+	count, q = h.Flush(0.5)
+	assert.Equal(0, count, "the histogram is emptied by flushes")
+	assert.Equal([]float64{0}, q)
+
+	h.Since(time.Now().Add(-1500 * time.Microsecond))
+	_, q = h.Flush(0.5)
+	assert.True(q[0] >= 1.5, "durations are recorded with sub-millisecond precision")
+}
+
+func TestPipelineProfiler(t *testing.T) {
+	assert := assert.New(t)
+	stats := &testutil.TestStatsClient{}
+	defer func(old metrics.StatsClient) { metrics.Client = old }(metrics.Client)
+	metrics.Client = stats
+
+	p := NewPipelineProfiler()
+	assert.Nil(p.Latencies())
+	now := time.Now()
+	for i := 1; i <= 100; i++ {
+		p.Since(StageDecode, now.Add(-time.Duration(i)*time.Millisecond))
+		p.Since(StageSample, now.Add(-time.Millisecond))
+	}
+	p.Since("unknown", now)
+
+	latencies := p.Report()
+	assert.Equal(latencies, p.Latencies())
+	assert.Len(latencies, 5)
+	assert.Equal(100, latencies[StageDecode].Count)
+	assert.InDelta(50, latencies[StageDecode].P50, 3)
+	assert.InDelta(95, latencies[StageDecode].P95, 3)
+	assert.InDelta(99, latencies[StageDecode].P99, 3)
+	assert.True(latencies[StageDecode].P50 <= latencies[StageDecode].P95)
+	assert.Equal(StageLatency{}, latencies[StageWrite])
+
+	// only the stages with latencies are reported
+	assert.Len(stats.GaugeCalls, 6)
+	assert.InDelta(latencies[StageDecode].P99, findCall(assert, stats.GaugeCalls, "datadog.trace_agent.pipeline.decode_latency_ms.p99").Value, 0)
+	findCall(assert, stats.GaugeCalls, "datadog.trace_agent.pipeline.sample_latency_ms.p50")
+
+	// each report covers the latencies recorded since the previous one
+	assert.Equal(0, p.Report()[StageDecode].Count)
+}
+
+func TestPipelineProfilerServeHTTP(t *testing.T) {
+	p := NewPipelineProfiler()
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("GET", PipelineLatencyPath, nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	p.Since(StageNormalize, time.Now().Add(-2*time.Millisecond))
+	p.Report()
+	rr = httptest.NewRecorder()
+	p.ServeHTTP(rr, httptest.NewRequest("GET", PipelineLatencyPath, nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	var latencies map[string]StageLatency
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&latencies))
+	assert.Equal(t, 1, latencies[StageNormalize].Count)
+	assert.True(t, latencies[StageNormalize].P50 >= 2)
+}
@@ -0,0 +1,208 @@
+package sampler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/watchdog"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+const (
+	// abTestFlushInterval is the interval at which the number of traces seen
+	// and kept by each group is reported.
+	abTestFlushInterval = 10 * time.Second
+
+	// abTestReportInterval is the interval at which the significance of the
+	// difference between the groups is reported.
+	abTestReportInterval = time.Hour
+
+	// abTestSignificanceLevel is the p-value below which the difference
+	// between the groups is reported as significant.
+	abTestSignificanceLevel = 0.05
+)
+
+// A/B test groups.
+const (
+	abControl = iota
+	abTreatment
+)
+
+var abGroupTags = [2][]string{
+	abControl:   {"ab_group:control"},
+	abTreatment: {"ab_group:treatment"},
+}
+
+// abGroupCounts holds the number of traces seen and kept by a group.
+type abGroupCounts struct {
+	traces uint64
+	kept   uint64
+}
+
+// SamplerABTest is a sampler engine evaluating a sampling algorithm on a
+// fraction of the traffic, the treatment group, while the rest of the traces,
+// the control group, are sampled by the current engine. Traces are assigned to
+// a group by trace ID. The number of traces seen and kept by each group is
+// reported as metrics tagged with ab_group, and the significance of the
+// difference between their keep rates is reported every hour.
+type SamplerABTest struct {
+	control   Engine
+	treatment Engine
+	// threshold is the hash of the trace ID below which traces are in the
+	// treatment group.
+	threshold uint64
+
+	// window holds the counts since the last flush, updated atomically.
+	window [2]abGroupCounts
+	// total holds the counts since the beginning of the test.
+	total [2]abGroupCounts
+
+	exit chan struct{}
+}
+
+// NewSamplerABTest returns a SamplerABTest sampling the given fraction of the
+// traces with the named algorithm, out of "score", "tps" and "hash", and the
+// others with control. The max TPS of the treatment should be scaled to its
+// share of the traffic, like the one of control.
+func NewSamplerABTest(control Engine, algorithm string, treatmentFraction float64, extraRate float64, maxTPS float64) (*SamplerABTest, error) {
+	if treatmentFraction <= 0 || treatmentFraction >= 1 {
+		return nil, fmt.Errorf("treatment fraction must be between 0 and 1, got %f", treatmentFraction)
+	}
+	treatment, err := newAlgorithmEngine(algorithm, extraRate, maxTPS)
+	if err != nil {
+		return nil, err
+	}
+	return &SamplerABTest{
+		control:   control,
+		treatment: treatment,
+		threshold: uint64(treatmentFraction * maxTraceIDFloat),
+		exit:      make(chan struct{}),
+	}, nil
+}
+
+// Run runs the engines of both groups and reports their results until Stop is
+// called.
+func (s *SamplerABTest) Run() {
+	for _, e := range []Engine{s.control, s.treatment} {
+		go func(e Engine) {
+			defer watchdog.LogOnPanic()
+			e.Run()
+		}(e)
+	}
+	flush := time.NewTicker(abTestFlushInterval)
+	defer flush.Stop()
+	report := time.NewTicker(abTestReportInterval)
+	defer report.Stop()
+	for {
+		select {
+		case <-flush.C:
+			s.flush()
+		case <-report.C:
+			s.flush()
+			s.report()
+		case <-s.exit:
+			return
+		}
+	}
+}
+
+// Stop stops the engines of both groups.
+func (s *SamplerABTest) Stop() {
+	s.control.Stop()
+	s.treatment.Stop()
+	close(s.exit)
+}
+
+// Sample samples the trace with the engine of its group.
+func (s *SamplerABTest) Sample(trace pb.Trace, root *pb.Span, env string) (sampled bool, rate float64) {
+	if len(trace) == 0 {
+		return false, 0
+	}
+	group, engine := abControl, s.control
+	if s.inTreatment(root.TraceID) {
+		group, engine = abTreatment, s.treatment
+	}
+	sampled, rate = engine.Sample(trace, root, env)
+	atomic.AddUint64(&s.window[group].traces, 1)
+	if sampled {
+		atomic.AddUint64(&s.window[group].kept, 1)
+	}
+	return sampled, rate
+}
+
+// inTreatment reports whether the trace with the given ID is in the treatment
+// group. The trace ID is hashed independently of SampleByRate so that the
+// groups are not correlated with the decisions of the samplers.
+func (s *SamplerABTest) inTreatment(traceID uint64) bool {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], traceID)
+	h := fnv.New64a()
+	h.Write(b[:])
+	return h.Sum64() < s.threshold
+}
+
+// flush reports the counts of both groups since the last flush.
+func (s *SamplerABTest) flush() {
+	for group := range s.window {
+		traces := atomic.SwapUint64(&s.window[group].traces, 0)
+		kept := atomic.SwapUint64(&s.window[group].kept, 0)
+		s.total[group].traces += traces
+		s.total[group].kept += kept
+		metrics.Count("datadog.trace_agent.sampler.ab_test.traces", int64(traces), abGroupTags[group], 1)
+		metrics.Count("datadog.trace_agent.sampler.ab_test.kept", int64(kept), abGroupTags[group], 1)
+	}
+}
+
+// report reports the significance of the difference between the keep rates of
+// the groups since the beginning of the test.
+func (s *SamplerABTest) report() {
+	control, treatment := s.total[abControl], s.total[abTreatment]
+	z, p, ok := twoProportionZTest(control.kept, control.traces, treatment.kept, treatment.traces)
+	if !ok {
+		log.Infof("Sampler A/B test: not enough traces to compare the groups yet")
+		return
+	}
+	metrics.Gauge("datadog.trace_agent.sampler.ab_test.z_score", z, nil, 1)
+	metrics.Gauge("datadog.trace_agent.sampler.ab_test.p_value", p, nil, 1)
+	verdict := "not significant"
+	if p < abTestSignificanceLevel {
+		verdict = "significant"
+	}
+	log.Infof("Sampler A/B test: control kept %d/%d traces, treatment kept %d/%d traces, the difference is %s (z=%.2f, p=%.4f)",
+		control.kept, control.traces, treatment.kept, treatment.traces, verdict, z, p)
+}
+
+// twoProportionZTest compares the proportions k1/n1 and k2/n2 and returns the
+// z-score of their difference along with its two-sided p-value. It returns false
+// when the test can not be run.
+func twoProportionZTest(k1, n1, k2, n2 uint64) (z, p float64, ok bool) {
+	if n1 == 0 || n2 == 0 {
+		return 0, 0, false
+	}
+	p1 := float64(k1) / float64(n1)
+	p2 := float64(k2) / float64(n2)
+	pooled := float64(k1+k2) / float64(n1+n2)
+	se := math.Sqrt(pooled * (1 - pooled) * (1/float64(n1) + 1/float64(n2)))
+	if se == 0 {
+		// both groups kept all or none of their traces
+		return 0, 1, true
+	}
+	z = (p2 - p1) / se
+	return z, math.Erfc(math.Abs(z) / math.Sqrt2), true
+}
+
+// GetState returns the state of the control engine.
+func (s *SamplerABTest) GetState() interface{} {
+	return s.control.GetState()
+}
+
+// GetType returns the type of the control engine, whose place the A/B test takes.
+func (s *SamplerABTest) GetType() EngineType {
+	return s.control.GetType()
+}
@@ -0,0 +1,120 @@
+package sampler
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSamplerABTest(t *testing.T) {
+	assert := assert.New(t)
+
+	control := NewScoreEngine(1, 9)
+	s, err := NewSamplerABTest(control, "tps", 0.1, 1, 1)
+	assert.NoError(err)
+	assert.Equal(control, s.control)
+	assert.NotNil(s.treatment.(*ScoreEngine).budget)
+	assert.Equal(NormalScoreEngineType, s.GetType())
+
+	for _, tt := range []struct {
+		algorithm string
+		fraction  float64
+	}{
+		{"random", 0.1},
+		{"hash", 0},
+		{"hash", 1},
+		{"hash", -0.5},
+	} {
+		_, err := NewSamplerABTest(control, tt.algorithm, tt.fraction, 1, 1)
+		assert.Error(err, "%s %f", tt.algorithm, tt.fraction)
+	}
+}
+
+func TestSamplerABTestGroups(t *testing.T) {
+	assert := assert.New(t)
+
+	s, err := NewSamplerABTest(&fixedEngine{hashEngine{rate: 0.5}, true}, "hash", 0.1, 1, 1)
+	assert.NoError(err)
+	s.treatment = &fixedEngine{hashEngine{rate: 0.2}, false}
+
+	n := 10000
+	for i := 0; i < n; i++ {
+		root := &pb.Span{TraceID: uint64(i) * 7919, SpanID: 1}
+		sampled, rate := s.Sample(pb.Trace{root}, root, "")
+		if s.inTreatment(root.TraceID) {
+			assert.False(sampled)
+			assert.Equal(0.2, rate)
+		} else {
+			assert.True(sampled)
+			assert.Equal(0.5, rate)
+		}
+	}
+	sampled, _ := s.Sample(pb.Trace{}, nil, "")
+	assert.False(sampled)
+
+	s.flush()
+	control, treatment := s.total[abControl], s.total[abTreatment]
+	assert.EqualValues(n, control.traces+treatment.traces)
+	assert.Equal(control.traces, control.kept)
+	assert.EqualValues(0, treatment.kept)
+	share := float64(treatment.traces) / float64(n)
+	assert.True(share > 0.08 && share < 0.12, "treatment got %f of the traces", share)
+
+	// the window is reset by flushes
+	s.flush()
+	assert.Equal(control, s.total[abControl])
+}
+
+func TestTwoProportionZTest(t *testing.T) {
+	assert := assert.New(t)
+
+	_, _, ok := twoProportionZTest(1, 0, 1, 10)
+	assert.False(ok)
+
+	// same proportions
+	z, p, ok := twoProportionZTest(50, 100, 500, 1000)
+	assert.True(ok)
+	assert.Equal(0.0, z)
+	assert.Equal(1.0, p)
+
+	// all kept in both groups
+	z, p, ok = twoProportionZTest(100, 100, 10, 10)
+	assert.True(ok)
+	assert.Equal(0.0, z)
+	assert.Equal(1.0, p)
+
+	// 50% vs 60% over 1000 traces each: z ~ 4.49
+	z, p, ok = twoProportionZTest(500, 1000, 600, 1000)
+	assert.True(ok)
+	assert.InDelta(4.49, z, 0.01)
+	assert.True(p < abTestSignificanceLevel, "p=%f", p)
+
+	// 50% vs 52% over 100 traces each is not significant
+	z, p, ok = twoProportionZTest(50, 100, 52, 100)
+	assert.True(ok)
+	assert.True(z > 0 && z < 1, "z=%f", z)
+	assert.True(p > abTestSignificanceLevel, "p=%f", p)
+
+	z, _, _ = twoProportionZTest(600, 1000, 500, 1000)
+	assert.True(z < 0)
+	assert.False(math.IsNaN(z))
+}
+
+func TestSamplerABTestRunStop(t *testing.T) {
+	s, err := NewSamplerABTest(NewScoreEngine(1, 9), "score", 0.1, 1, 1)
+	assert.NoError(t, err)
+	done := make(chan struct{})
+	go func() {
+		s.Run()
+		close(done)
+	}()
+	s.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("A/B test did not stop")
+	}
+}
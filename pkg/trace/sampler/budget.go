@@ -0,0 +1,133 @@
+package sampler
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+)
+
+// defaultBudgetPeriod is the period over which traffic is observed before the
+// per-service rates are computed.
+const defaultBudgetPeriod = time.Minute
+
+// BudgetDistributor shares a global sampling budget, in traces per second, across
+// services. It uses max-min fairness: the budget is first split equally, then the
+// part of the budget low-traffic services don't need is reallocated to the
+// high-traffic ones.
+type BudgetDistributor struct {
+	budget float64
+	period time.Duration
+
+	mu     sync.RWMutex
+	counts map[string]float64 // traces seen by service during the current period
+	rates  map[string]float64 // sample rates by service
+
+	exit chan struct{}
+}
+
+// NewBudgetDistributor returns a new BudgetDistributor sharing budget traces per
+// second across services.
+func NewBudgetDistributor(budget float64) *BudgetDistributor {
+	return &BudgetDistributor{
+		budget: budget,
+		period: defaultBudgetPeriod,
+		counts: make(map[string]float64),
+		rates:  make(map[string]float64),
+		exit:   make(chan struct{}),
+	}
+}
+
+// Run computes the per-service rates every period until Stop is called.
+func (d *BudgetDistributor) Run() {
+	t := time.NewTicker(d.period)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			d.update()
+		case <-d.exit:
+			return
+		}
+	}
+}
+
+// Stop stops the distributor.
+func (d *BudgetDistributor) Stop() {
+	close(d.exit)
+}
+
+// Count counts a trace for the given service.
+func (d *BudgetDistributor) Count(service string) {
+	d.mu.Lock()
+	d.counts[service]++
+	d.mu.Unlock()
+}
+
+// Rate returns the sample rate of the given service. Services which were not
+// seen during the last period are sampled at 1.
+func (d *BudgetDistributor) Rate(service string) float64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if rate, ok := d.rates[service]; ok {
+		return rate
+	}
+	return 1
+}
+
+// update computes the rates of the services from their traffic during the last
+// period and starts a new period.
+func (d *BudgetDistributor) update() {
+	d.mu.Lock()
+	demand := make(map[string]float64, len(d.counts))
+	for service, n := range d.counts {
+		demand[service] = n / d.period.Seconds()
+	}
+	d.counts = make(map[string]float64, len(demand))
+	d.mu.Unlock()
+
+	shares := maxMinFairShares(d.budget, demand)
+	rates := make(map[string]float64, len(shares))
+	for service, share := range shares {
+		rate := 1.0
+		if demand[service] > share {
+			rate = share / demand[service]
+		}
+		rates[service] = rate
+		metrics.Gauge("datadog.trace_agent.sampler.budget_rate", rate, []string{"service:" + service}, 1)
+	}
+
+	d.mu.Lock()
+	d.rates = rates
+	d.mu.Unlock()
+}
+
+// maxMinFairShares returns the share of budget allocated to each of the given
+// demands, using max-min fairness. No demand receives more than it asks for, and
+// unsatisfied demands all receive the same share.
+func maxMinFairShares(budget float64, demand map[string]float64) map[string]float64 {
+	keys := make([]string, 0, len(demand))
+	for k := range demand {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if demand[keys[i]] == demand[keys[j]] {
+			return keys[i] < keys[j]
+		}
+		return demand[keys[i]] < demand[keys[j]]
+	})
+
+	shares := make(map[string]float64, len(keys))
+	remaining := budget
+	for i, k := range keys {
+		fair := remaining / float64(len(keys)-i)
+		share := demand[k]
+		if share > fair {
+			share = fair
+		}
+		shares[k] = share
+		remaining -= share
+	}
+	return shares
+}
@@ -0,0 +1,82 @@
+package sampler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxMinFairShares(t *testing.T) {
+	for name, tc := range map[string]struct {
+		budget float64
+		demand map[string]float64
+		shares map[string]float64
+	}{
+		"empty": {
+			budget: 10,
+			demand: map[string]float64{},
+			shares: map[string]float64{},
+		},
+		"under-budget": {
+			budget: 10,
+			demand: map[string]float64{"a": 2, "b": 3},
+			shares: map[string]float64{"a": 2, "b": 3},
+		},
+		"equal-split": {
+			budget: 10,
+			demand: map[string]float64{"a": 20, "b": 30},
+			shares: map[string]float64{"a": 5, "b": 5},
+		},
+		"reallocation": {
+			// a only needs 1 of its 4, the remaining 3 are split between b and c,
+			// c only needs 1 more of them, which goes to b.
+			budget: 12,
+			demand: map[string]float64{"a": 1, "b": 100, "c": 5},
+			shares: map[string]float64{"a": 1, "b": 6, "c": 5},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.shares, maxMinFairShares(tc.budget, tc.demand))
+		})
+	}
+}
+
+func TestBudgetDistributor(t *testing.T) {
+	d := NewBudgetDistributor(1)
+	assert.Equal(t, 1.0, d.Rate("web"), "unknown services are kept")
+
+	// over one minute: web sends 100 traces/s, db 0.5 traces/s
+	for i := 0; i < 6000; i++ {
+		d.Count("web")
+	}
+	for i := 0; i < 30; i++ {
+		d.Count("db")
+	}
+	d.update()
+
+	assert.Equal(t, 1.0, d.Rate("db"))
+	assert.InDelta(t, 0.005, d.Rate("web"), 1e-9)
+	assert.Equal(t, 1.0, d.Rate("search"))
+	assert.Len(t, d.counts, 0, "a new period starts")
+
+	d.Count("search")
+	d.update()
+	assert.Equal(t, 1.0, d.Rate("web"), "services not seen anymore are reset")
+}
+
+func TestScoreEngineBudgetDistribution(t *testing.T) {
+	e := NewScoreEngine(1, 1)
+	e.UseBudgetDistribution()
+	e.budget.period = time.Second
+
+	trace := pb.Trace{{TraceID: 1, SpanID: 1, Service: "web", Name: "http.request"}}
+	for i := 0; i < 100; i++ {
+		e.budget.Count("web")
+	}
+	e.budget.update()
+
+	_, rate := e.Sample(trace, trace[0], "none")
+	assert.InDelta(t, 0.01, rate, 1e-9)
+}
@@ -42,3 +42,17 @@ func (cat *serviceKeyCatalog) ratesByService(rates map[Signature]float64, totalS
 	rbs[ServiceSignature{}] = totalScore
 	return rbs
 }
+
+// volumesByService returns a map of service signatures mapping to the scores identified
+// using the signatures.
+func (cat *serviceKeyCatalog) volumesByService(scores map[Signature]float64) map[ServiceSignature]float64 {
+	vbs := make(map[ServiceSignature]float64, len(scores))
+	cat.mu.Lock()
+	defer cat.mu.Unlock()
+	for key, sig := range cat.lookup {
+		if score, ok := scores[sig]; ok {
+			vbs[key] = score
+		}
+	}
+	return vbs
+}
@@ -0,0 +1,97 @@
+package sampler
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// damperHold is how long rates keep being smoothed after the last time
+	// their change exceeded the limit.
+	damperHold = 10 * time.Second
+	// damperExpiry is the delay after which the state of a signature whose
+	// rate was not requested anymore is dropped.
+	damperExpiry = time.Minute
+)
+
+// OscillationDamper smooths the sample rates of signatures whose rate changes
+// faster than allowed, to prevent rates oscillating rapidly (e.g. from 0.1 to
+// 1 and back every few seconds) from causing bursty intake load.
+type OscillationDamper struct {
+	maxChange float64 // maximum rate change per second
+	factor    float64 // weight of the new rate in the exponential smoothing
+
+	mu        sync.Mutex
+	states    map[Signature]*damperState
+	lastPrune time.Time
+}
+
+type damperState struct {
+	raw   float64   // last raw rate checked against the limit
+	rawAt time.Time // time at which raw was checked
+
+	rate    float64   // damped rate
+	updated time.Time // time of the last smoothing step
+
+	exceeded time.Time // last time the raw rate changed faster than allowed
+	seen     time.Time
+}
+
+// NewOscillationDamper returns a damper smoothing the rates changing by more
+// than maxChangePerSecond per second, using the given exponential smoothing factor.
+func NewOscillationDamper(maxChangePerSecond, dampingFactor float64) *OscillationDamper {
+	return &OscillationDamper{
+		maxChange: maxChangePerSecond,
+		factor:    dampingFactor,
+		states:    make(map[Signature]*damperState),
+	}
+}
+
+// Damp returns the rate to apply to the given signature instead of rate. Rates
+// are returned as is, unless they changed faster than the limit during the
+// last seconds or the returned rate would. In that case, the returned rate
+// moves towards rate once per second, by dampingFactor of the difference.
+func (d *OscillationDamper) Damp(signature Signature, rate float64, now time.Time) float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if now.Sub(d.lastPrune) > damperExpiry {
+		for sig, s := range d.states {
+			if now.Sub(s.seen) > damperExpiry {
+				delete(d.states, sig)
+			}
+		}
+		d.lastPrune = now
+	}
+
+	s, ok := d.states[signature]
+	if !ok {
+		d.states[signature] = &damperState{raw: rate, rawAt: now, rate: rate, updated: now, seen: now}
+		return rate
+	}
+	s.seen = now
+
+	// Changes are measured over at least a second, so that traces coming in
+	// quick succession don't make small changes look fast.
+	if elapsed := now.Sub(s.rawAt).Seconds(); elapsed >= 1 {
+		if math.Abs(rate-s.raw) > d.maxChange*elapsed {
+			s.exceeded = now
+		}
+		s.raw, s.rawAt = rate, now
+	}
+
+	hold := now.Sub(s.exceeded) < damperHold
+	elapsed := now.Sub(s.updated)
+	switch {
+	case !hold && math.Abs(rate-s.rate) <= d.maxChange*elapsed.Seconds():
+		s.rate = rate
+	case elapsed >= time.Second:
+		s.rate += d.factor * (rate - s.rate)
+	default:
+		// keep the rate until the next smoothing step
+		return s.rate
+	}
+	s.updated = now
+	return s.rate
+}
@@ -0,0 +1,92 @@
+package sampler
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// amplitude returns the difference between the highest and lowest rates of rates.
+func amplitude(rates []float64) float64 {
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, r := range rates {
+		min = math.Min(min, r)
+		max = math.Max(max, r)
+	}
+	return max - min
+}
+
+func TestOscillationDamperOscillations(t *testing.T) {
+	for name, tc := range map[string]struct {
+		period time.Duration // time spent at each rate
+		step   time.Duration // interval between traces
+	}{
+		"high-traffic": {period: 3 * time.Second, step: 10 * time.Millisecond},
+		"fast":         {period: 2 * time.Second, step: 100 * time.Millisecond},
+		"low-traffic":  {period: 2 * time.Second, step: time.Second},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d := NewOscillationDamper(0.5, 0.3)
+			start := time.Now()
+			var raw, damped []float64
+			for elapsed := time.Duration(0); elapsed < time.Minute; elapsed += tc.step {
+				rate := 0.1
+				if (elapsed/tc.period)%2 == 1 {
+					rate = 1
+				}
+				raw = append(raw, rate)
+				damped = append(damped, d.Damp(Signature(1), rate, start.Add(elapsed)))
+			}
+			// skip the warm up
+			raw, damped = raw[len(raw)/2:], damped[len(damped)/2:]
+			assert.True(t, amplitude(damped) < amplitude(raw)/2, "damped amplitude %f, raw amplitude %f", amplitude(damped), amplitude(raw))
+		})
+	}
+}
+
+func TestOscillationDamperSlowChanges(t *testing.T) {
+	d := NewOscillationDamper(0.5, 0.3)
+	now := time.Now()
+	assert.Equal(t, 0.1, d.Damp(Signature(1), 0.1, now))
+	// changes within the limit are applied right away
+	for rate := 0.1; rate <= 1; rate += 0.01 {
+		now = now.Add(100 * time.Millisecond)
+		assert.Equal(t, rate, d.Damp(Signature(1), rate, now))
+	}
+	// signatures are damped independently
+	assert.Equal(t, 0.2, d.Damp(Signature(2), 0.2, now))
+}
+
+func TestOscillationDamperSteps(t *testing.T) {
+	assert := assert.New(t)
+	d := NewOscillationDamper(0.5, 0.3)
+	now := time.Now()
+	d.Damp(Signature(1), 1, now)
+
+	// the rate is held between smoothing steps
+	assert.Equal(1.0, d.Damp(Signature(1), 0.1, now.Add(500*time.Millisecond)))
+	assert.InDelta(0.73, d.Damp(Signature(1), 0.1, now.Add(time.Second)), 1e-9)
+	assert.InDelta(0.73, d.Damp(Signature(1), 0.1, now.Add(1500*time.Millisecond)), 1e-9)
+	assert.InDelta(0.541, d.Damp(Signature(1), 0.1, now.Add(2*time.Second)), 1e-9)
+
+	// the rate keeps being smoothed during the hold period, even if stable
+	now = now.Add(2 * time.Second)
+	for i := 1; i <= 8; i++ {
+		d.Damp(Signature(1), 0.1, now.Add(time.Duration(i)*time.Second))
+	}
+	assert.InDelta(0.1+0.441*math.Pow(0.7, 8), d.Damp(Signature(1), 0.1, now.Add(8*time.Second)), 1e-9)
+	// then applied as is
+	assert.Equal(0.1, d.Damp(Signature(1), 0.1, now.Add(9*time.Second)))
+}
+
+func TestOscillationDamperExpiry(t *testing.T) {
+	d := NewOscillationDamper(0.5, 0.3)
+	now := time.Now()
+	d.Damp(Signature(1), 1, now)
+	d.Damp(Signature(2), 1, now.Add(50*time.Second))
+	d.Damp(Signature(2), 1, now.Add(2*time.Minute))
+	assert.Len(t, d.states, 1)
+	assert.Contains(t, d.states, Signature(2))
+}
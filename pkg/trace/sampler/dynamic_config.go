@@ -2,6 +2,7 @@ package sampler
 
 import (
 	"sync"
+	"sync/atomic"
 )
 
 // DynamicConfig contains configuration items which may change
@@ -10,6 +11,14 @@ type DynamicConfig struct {
 	// RateByService contains the rate for each service/env tuple,
 	// used in priority sampling by client libs.
 	RateByService RateByService
+
+	// Traffic contains the throughput and sampling rate of each service/env
+	// tuple, used to visualize sampling decisions.
+	Traffic ServiceTraffic
+
+	// Rollback reports whether a rollback is in progress, during which the
+	// traces of canary deployments are deprioritized.
+	Rollback RollbackState
 }
 
 // NewDynamicConfig creates a new dynamic config object which maps service signatures
@@ -68,3 +77,22 @@ func (rbs *RateByService) GetAll() map[string]float64 {
 
 	return ret
 }
+
+// RollbackState reports whether a rollback is in progress. It is thread-safe.
+type RollbackState struct {
+	active int32
+}
+
+// Set sets whether a rollback is in progress.
+func (rs *RollbackState) Set(active bool) {
+	var v int32
+	if active {
+		v = 1
+	}
+	atomic.StoreInt32(&rs.active, v)
+}
+
+// Active reports whether a rollback is in progress.
+func (rs *RollbackState) Active() bool {
+	return atomic.LoadInt32(&rs.active) == 1
+}
@@ -127,6 +127,19 @@ func TestRateByServiceConcurrency(t *testing.T) {
 	}()
 }
 
+func TestRollbackState(t *testing.T) {
+	assert := assert.New(t)
+
+	dc := NewDynamicConfig("none")
+	assert.False(dc.Rollback.Active())
+	dc.Rollback.Set(true)
+	assert.True(dc.Rollback.Active())
+	dc.Rollback.Set(true)
+	assert.True(dc.Rollback.Active())
+	dc.Rollback.Set(false)
+	assert.False(dc.Rollback.Active())
+}
+
 func benchRBSGetAll(sigs map[ServiceSignature]float64) func(*testing.B) {
 	return func(b *testing.B) {
 		rbs := &RateByService{defaultEnv: "test"}
@@ -0,0 +1,156 @@
+package sampler
+
+import (
+	"fmt"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/watchdog"
+)
+
+// Algorithms which can be combined by the EnsembleSampler.
+const (
+	// EnsembleScore samples traces by signature, like the ScoreEngine.
+	EnsembleScore = "score"
+	// EnsembleTPS samples traces by sharing the max TPS fairly across services.
+	EnsembleTPS = "tps"
+	// EnsembleHash samples traces deterministically by trace ID, at the extra
+	// sample rate.
+	EnsembleHash = "hash"
+)
+
+// EnsembleSampler is a sampler engine combining the decisions of several
+// sampling algorithms using a weighted vote. Weights are normalized so that
+// they add up to 1, and a trace is kept when the weighted sum of the decisions
+// of the algorithms keeping it is above 0.5. The resulting rate is the weighted
+// average of the rates of the algorithms.
+type EnsembleSampler struct {
+	engines []Engine
+	weights []float64
+
+	exit chan struct{}
+}
+
+// NewEnsembleSampler returns an EnsembleSampler combining the given algorithms,
+// with the given weights. Both must have the same length.
+func NewEnsembleSampler(algorithms []string, weights []float64, extraRate float64, maxTPS float64) (*EnsembleSampler, error) {
+	if len(algorithms) == 0 {
+		return nil, fmt.Errorf("no sampling algorithm")
+	}
+	if len(algorithms) != len(weights) {
+		return nil, fmt.Errorf("got %d weights for %d sampling algorithms", len(weights), len(algorithms))
+	}
+	var total float64
+	for _, w := range weights {
+		if w < 0 {
+			return nil, fmt.Errorf("negative sampling algorithm weight: %f", w)
+		}
+		total += w
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("sampling algorithm weights add up to 0")
+	}
+
+	s := &EnsembleSampler{
+		engines: make([]Engine, len(algorithms)),
+		weights: make([]float64, len(weights)),
+		exit:    make(chan struct{}),
+	}
+	for i, name := range algorithms {
+		e, err := newAlgorithmEngine(name, extraRate, maxTPS)
+		if err != nil {
+			return nil, err
+		}
+		s.engines[i] = e
+		s.weights[i] = weights[i] / total
+	}
+	return s, nil
+}
+
+// newAlgorithmEngine returns the engine implementing the named sampling algorithm.
+func newAlgorithmEngine(name string, extraRate float64, maxTPS float64) (Engine, error) {
+	switch name {
+	case EnsembleScore:
+		return NewScoreEngine(extraRate, maxTPS), nil
+	case EnsembleTPS:
+		e := NewScoreEngine(extraRate, maxTPS)
+		e.UseBudgetDistribution()
+		return e, nil
+	case EnsembleHash:
+		return &hashEngine{rate: extraRate}, nil
+	default:
+		return nil, fmt.Errorf("unknown sampling algorithm %q", name)
+	}
+}
+
+// Run runs the combined engines until Stop is called.
+func (s *EnsembleSampler) Run() {
+	for _, e := range s.engines {
+		go func(e Engine) {
+			defer watchdog.LogOnPanic()
+			e.Run()
+		}(e)
+	}
+	<-s.exit
+}
+
+// Stop stops the combined engines.
+func (s *EnsembleSampler) Stop() {
+	for _, e := range s.engines {
+		e.Stop()
+	}
+	close(s.exit)
+}
+
+// Sample submits the trace to all the combined engines and tells whether the
+// weighted vote keeps it, along with the weighted average of their rates.
+func (s *EnsembleSampler) Sample(trace pb.Trace, root *pb.Span, env string) (sampled bool, rate float64) {
+	if len(trace) == 0 {
+		return false, 0
+	}
+	var vote float64
+	for i, e := range s.engines {
+		kept, r := e.Sample(trace, root, env)
+		if kept {
+			vote += s.weights[i]
+		}
+		rate += s.weights[i] * r
+	}
+	return vote > 0.5, rate
+}
+
+// GetState returns the state of the first combined engine reporting an
+// InternalState, or nil.
+func (s *EnsembleSampler) GetState() interface{} {
+	for _, e := range s.engines {
+		if state, ok := e.GetState().(InternalState); ok {
+			return state
+		}
+	}
+	return nil
+}
+
+// GetType returns the type of the sampler. The ensemble takes the place of
+// the normal score engine.
+func (s *EnsembleSampler) GetType() EngineType {
+	return NormalScoreEngineType
+}
+
+// hashEngine keeps a fixed share of the traces, chosen by trace ID so that
+// all the agents reporting a trace make the same decision.
+type hashEngine struct {
+	rate float64
+}
+
+func (e *hashEngine) Run()  {}
+func (e *hashEngine) Stop() {}
+
+func (e *hashEngine) Sample(trace pb.Trace, root *pb.Span, env string) (bool, float64) {
+	if len(trace) == 0 {
+		return false, 0
+	}
+	return applySampleRate(root, e.rate), e.rate
+}
+
+func (e *hashEngine) GetState() interface{} { return nil }
+
+func (e *hashEngine) GetType() EngineType { return NormalScoreEngineType }
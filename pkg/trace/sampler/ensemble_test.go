@@ -0,0 +1,134 @@
+package sampler
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+// fixedEngine is an engine always making the same decision.
+type fixedEngine struct {
+	hashEngine
+	kept bool
+}
+
+func (e *fixedEngine) Sample(pb.Trace, *pb.Span, string) (bool, float64) { return e.kept, e.rate }
+
+func TestNewEnsembleSampler(t *testing.T) {
+	assert := assert.New(t)
+
+	s, err := NewEnsembleSampler([]string{"score", "tps", "hash"}, []float64{2, 1, 1}, 1, 10)
+	assert.NoError(err)
+	assert.IsType(&ScoreEngine{}, s.engines[0])
+	assert.NotNil(s.engines[1].(*ScoreEngine).budget)
+	assert.IsType(&hashEngine{}, s.engines[2])
+	assert.Equal([]float64{0.5, 0.25, 0.25}, s.weights)
+
+	for _, tt := range []struct {
+		algorithms []string
+		weights    []float64
+	}{
+		{nil, nil},
+		{[]string{"score", "hash"}, []float64{1}},
+		{[]string{"score", "random"}, []float64{1, 1}},
+		{[]string{"score", "hash"}, []float64{1, -1}},
+		{[]string{"score", "hash"}, []float64{0, 0}},
+	} {
+		_, err := NewEnsembleSampler(tt.algorithms, tt.weights, 1, 10)
+		assert.Error(err, "%v %v", tt.algorithms, tt.weights)
+	}
+}
+
+func TestEnsembleSamplerVote(t *testing.T) {
+	trace, root := getTestTrace()
+	keep := &fixedEngine{hashEngine{rate: 0.8}, true}
+	drop := &fixedEngine{hashEngine{rate: 0.2}, false}
+
+	for name, tt := range map[string]struct {
+		engines []Engine
+		weights []float64
+		sampled bool
+		rate    float64
+	}{
+		"unanimous": {
+			engines: []Engine{keep, keep},
+			weights: []float64{0.5, 0.5},
+			sampled: true,
+			rate:    0.8,
+		},
+		"majority": {
+			engines: []Engine{keep, drop, keep},
+			weights: []float64{1.0 / 3, 1.0 / 3, 1.0 / 3},
+			sampled: true,
+			rate:    0.6,
+		},
+		"minority": {
+			engines: []Engine{keep, drop, drop},
+			weights: []float64{1.0 / 3, 1.0 / 3, 1.0 / 3},
+			sampled: false,
+			rate:    0.4,
+		},
+		"tie": {
+			engines: []Engine{keep, drop},
+			weights: []float64{0.5, 0.5},
+			sampled: false,
+			rate:    0.5,
+		},
+		"heavy keeper": {
+			engines: []Engine{keep, drop, drop},
+			weights: []float64{0.6, 0.2, 0.2},
+			sampled: true,
+			rate:    0.56,
+		},
+		"heavy dropper": {
+			engines: []Engine{keep, keep, drop},
+			weights: []float64{0.2, 0.2, 0.6},
+			sampled: false,
+			rate:    0.44,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := &EnsembleSampler{engines: tt.engines, weights: tt.weights}
+			sampled, rate := s.Sample(trace, root, defaultEnv)
+			assert.Equal(t, tt.sampled, sampled)
+			assert.InDelta(t, tt.rate, rate, 1e-9)
+		})
+	}
+}
+
+func TestEnsembleSamplerHash(t *testing.T) {
+	assert := assert.New(t)
+
+	// a hash only ensemble behaves like sampling by trace ID
+	s, err := NewEnsembleSampler([]string{"hash"}, []float64{1}, 0.5, 0)
+	assert.NoError(err)
+	var kept int
+	for i := 0; i < 1000; i++ {
+		trace, root := getTestTrace()
+		sampled, rate := s.Sample(trace, root, defaultEnv)
+		assert.Equal(0.5, rate)
+		assert.Equal(SampleByRate(root.TraceID, 0.5), sampled)
+		if sampled {
+			kept++
+		}
+	}
+	assert.InDelta(500, kept, 100)
+
+	sampled, rate := s.Sample(pb.Trace{}, nil, defaultEnv)
+	assert.False(sampled)
+	assert.Equal(0.0, rate)
+}
+
+func TestEnsembleSamplerRunStop(t *testing.T) {
+	s, err := NewEnsembleSampler([]string{"score", "tps", "hash"}, []float64{1, 1, 1}, 1, 10)
+	assert.NoError(t, err)
+	done := make(chan struct{})
+	go func() {
+		s.Run()
+		close(done)
+	}()
+	s.Stop()
+	<-done
+	assert.IsType(t, InternalState{}, s.GetState())
+}
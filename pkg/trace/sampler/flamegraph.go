@@ -0,0 +1,152 @@
+package sampler
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	flameGraphWidth     = 1200
+	flameGraphRowHeight = 20
+	// flameGraphMinLabelWidth is the width below which frames are not labelled.
+	flameGraphMinLabelWidth = 40
+)
+
+// ServiceTraffic stores the recent throughput and sampling rate of each
+// service/env tuple, to visualize the sampling decisions of the agent. It is
+// thread-safe.
+type ServiceTraffic struct {
+	mu      sync.RWMutex
+	volumes map[ServiceSignature]float64
+	rates   map[ServiceSignature]float64
+}
+
+// set replaces the throughput and sampling rates of all services.
+func (st *ServiceTraffic) set(volumes, rates map[ServiceSignature]float64) {
+	st.mu.Lock()
+	st.volumes = volumes
+	st.rates = rates
+	st.mu.Unlock()
+}
+
+// FlameGraph returns an SVG flame graph of the sampling rates of all services.
+func (st *ServiceTraffic) FlameGraph() []byte {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return (&samplerFlameGraph{volumes: st.volumes}).Generate(st.rates)
+}
+
+// samplerFlameGraph renders sampling rates as a flame graph. The root frame
+// spans all the traffic, its children are the envs and their children the
+// services of each env. The width of a frame is proportional to its share of
+// the traffic, and its color goes from red to green as its sampling rate goes
+// from 0 to 1.
+type samplerFlameGraph struct {
+	// volumes holds the throughput of each service. When unknown, all the
+	// services are given the same width.
+	volumes map[ServiceSignature]float64
+}
+
+// flameFrame is a frame of the flame graph.
+type flameFrame struct {
+	label  string
+	depth  int
+	x, w   float64
+	rate   float64
+	volume float64
+}
+
+// Generate returns the SVG flame graph of the given sampling rates.
+func (g *samplerFlameGraph) Generate(rates map[ServiceSignature]float64) []byte {
+	var total float64
+	envs := make(map[string][]ServiceSignature)
+	for svc := range rates {
+		if svc == (ServiceSignature{}) {
+			// the default rate, which is not a service
+			continue
+		}
+		envs[svc.Env] = append(envs[svc.Env], svc)
+		total += g.volumes[svc]
+	}
+	uniform := total == 0
+	if uniform {
+		for _, services := range envs {
+			total += float64(len(services))
+		}
+	}
+	envNames := make([]string, 0, len(envs))
+	for env := range envs {
+		envNames = append(envNames, env)
+	}
+	sort.Strings(envNames)
+
+	root := flameFrame{label: "all", w: flameGraphWidth}
+	frames := []flameFrame{}
+	x := 0.0
+	for _, env := range envNames {
+		services := envs[env]
+		sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+		envFrame := flameFrame{label: "env:" + env, depth: 1, x: x}
+		for _, svc := range services {
+			volume := 1.0
+			if !uniform {
+				volume = g.volumes[svc]
+			}
+			w := volume / total * flameGraphWidth
+			frames = append(frames, flameFrame{label: svc.Name, depth: 2, x: x, w: w, rate: rates[svc], volume: volume})
+			envFrame.w += w
+			envFrame.volume += volume
+			envFrame.rate += rates[svc] * volume
+			x += w
+		}
+		if envFrame.volume > 0 {
+			envFrame.rate /= envFrame.volume
+		}
+		root.volume += envFrame.volume
+		root.rate += envFrame.rate * envFrame.volume
+		frames = append(frames, envFrame)
+	}
+	if root.volume > 0 {
+		root.rate /= root.volume
+	} else {
+		root.rate = 1
+	}
+	frames = append([]flameFrame{root}, frames...)
+
+	var buf bytes.Buffer
+	height := 3 * flameGraphRowHeight
+	fmt.Fprintf(&buf, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="Verdana" font-size="12">`+"\n",
+		flameGraphWidth, height, flameGraphWidth, height)
+	for _, f := range frames {
+		// flame graphs grow upwards
+		y := height - (f.depth+1)*flameGraphRowHeight
+		buf.WriteString("<g>")
+		buf.WriteString("<title>")
+		xml.EscapeText(&buf, []byte(fmt.Sprintf("%s (rate: %.2f)", f.label, f.rate)))
+		buf.WriteString("</title>")
+		fmt.Fprintf(&buf, `<rect x="%.2f" y="%d" width="%.2f" height="%d" fill="%s" stroke="white"/>`,
+			f.x, y, f.w, flameGraphRowHeight, rateColor(f.rate))
+		if f.w >= flameGraphMinLabelWidth {
+			fmt.Fprintf(&buf, `<text x="%.2f" y="%d">`, f.x+3, y+flameGraphRowHeight-6)
+			xml.EscapeText(&buf, []byte(f.label))
+			buf.WriteString("</text>")
+		}
+		buf.WriteString("</g>\n")
+	}
+	buf.WriteString("</svg>\n")
+	return buf.Bytes()
+}
+
+// rateColor returns the fill color of a frame with the given sampling rate,
+// from red for 0 to green for 1.
+func rateColor(rate float64) string {
+	rate = capTo1(rate)
+	if rate < 0 {
+		rate = 0
+	}
+	return fmt.Sprintf("rgb(%d,%d,60)", int(220*(1-rate)), int(200*rate))
+}
@@ -0,0 +1,142 @@
+package sampler
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// svgRect is a rect element of an SVG document.
+type svgRect struct {
+	title string
+	x, w  float64
+	fill  string
+}
+
+// parseSVG decodes the given SVG document and returns its rect elements,
+// failing the test if it is not valid XML.
+func parseSVG(t *testing.T, svg []byte) []svgRect {
+	var rects []svgRect
+	var title string
+	var inTitle, root bool
+	dec := xml.NewDecoder(bytes.NewReader(svg))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			switch tok.Name.Local {
+			case "svg":
+				root = true
+				assert.Equal(t, "http://www.w3.org/2000/svg", tok.Name.Space)
+			case "title":
+				inTitle = true
+			case "rect":
+				r := svgRect{title: title}
+				for _, attr := range tok.Attr {
+					switch attr.Name.Local {
+					case "x":
+						r.x, _ = strconv.ParseFloat(attr.Value, 64)
+					case "width":
+						r.w, _ = strconv.ParseFloat(attr.Value, 64)
+					case "fill":
+						r.fill = attr.Value
+					}
+				}
+				rects = append(rects, r)
+			}
+		case xml.CharData:
+			if inTitle {
+				title = string(tok)
+			}
+		case xml.EndElement:
+			inTitle = false
+		}
+	}
+	assert.True(t, root, "no svg element")
+	return rects
+}
+
+func TestSamplerFlameGraph(t *testing.T) {
+	for name, tt := range map[string]struct {
+		volumes map[ServiceSignature]float64
+		rates   map[ServiceSignature]float64
+		rects   []svgRect
+	}{
+		"empty": {
+			rects: []svgRect{{title: "all (rate: 1.00)", w: 1200, fill: "rgb(0,200,60)"}},
+		},
+		"single service": {
+			volumes: map[ServiceSignature]float64{{"web", "prod"}: 10},
+			rates:   map[ServiceSignature]float64{{"web", "prod"}: 0.5, {}: 0.5},
+			rects: []svgRect{
+				{title: "all (rate: 0.50)", w: 1200, fill: "rgb(110,100,60)"},
+				{title: "web (rate: 0.50)", w: 1200, fill: "rgb(110,100,60)"},
+				{title: "env:prod (rate: 0.50)", w: 1200, fill: "rgb(110,100,60)"},
+			},
+		},
+		"weighted by volume": {
+			volumes: map[ServiceSignature]float64{{"web", "prod"}: 30, {"db", "prod"}: 10},
+			rates:   map[ServiceSignature]float64{{"web", "prod"}: 0, {"db", "prod"}: 1},
+			rects: []svgRect{
+				{title: "all (rate: 0.25)", w: 1200, fill: "rgb(165,50,60)"},
+				{title: "db (rate: 1.00)", w: 300, fill: "rgb(0,200,60)"},
+				{title: "web (rate: 0.00)", x: 300, w: 900, fill: "rgb(220,0,60)"},
+				{title: "env:prod (rate: 0.25)", w: 1200, fill: "rgb(165,50,60)"},
+			},
+		},
+		"multiple envs": {
+			volumes: map[ServiceSignature]float64{{"web", "prod"}: 2, {"web", "staging"}: 1, {"db", "staging"}: 1},
+			rates:   map[ServiceSignature]float64{{"web", "prod"}: 1, {"web", "staging"}: 1, {"db", "staging"}: 1},
+			rects: []svgRect{
+				{title: "all (rate: 1.00)", w: 1200, fill: "rgb(0,200,60)"},
+				{title: "web (rate: 1.00)", w: 600, fill: "rgb(0,200,60)"},
+				{title: "env:prod (rate: 1.00)", w: 600, fill: "rgb(0,200,60)"},
+				{title: "db (rate: 1.00)", x: 600, w: 300, fill: "rgb(0,200,60)"},
+				{title: "web (rate: 1.00)", x: 900, w: 300, fill: "rgb(0,200,60)"},
+				{title: "env:staging (rate: 1.00)", x: 600, w: 600, fill: "rgb(0,200,60)"},
+			},
+		},
+		"unknown volumes": {
+			rates: map[ServiceSignature]float64{{"a", "prod"}: 1, {"b", "prod"}: 0.5, {"<c&d>", "prod"}: 0},
+			rects: []svgRect{
+				{title: "all (rate: 0.50)", w: 1200, fill: "rgb(110,100,60)"},
+				{title: "<c&d> (rate: 0.00)", w: 400, fill: "rgb(220,0,60)"},
+				{title: "a (rate: 1.00)", x: 400, w: 400, fill: "rgb(0,200,60)"},
+				{title: "b (rate: 0.50)", x: 800, w: 400, fill: "rgb(110,100,60)"},
+				{title: "env:prod (rate: 0.50)", w: 1200, fill: "rgb(110,100,60)"},
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			svg := (&samplerFlameGraph{volumes: tt.volumes}).Generate(tt.rates)
+			rects := parseSVG(t, svg)
+			if !assert.Len(t, rects, len(tt.rects)) {
+				return
+			}
+			for i, r := range rects {
+				assert.Equal(t, tt.rects[i].title, r.title)
+				assert.InDelta(t, tt.rects[i].x, r.x, 0.01, r.title)
+				assert.InDelta(t, tt.rects[i].w, r.w, 0.01, r.title)
+				assert.Equal(t, tt.rects[i].fill, r.fill, r.title)
+			}
+		})
+	}
+}
+
+func TestServiceTrafficFlameGraph(t *testing.T) {
+	var st ServiceTraffic
+	assert.Len(t, parseSVG(t, st.FlameGraph()), 1)
+
+	st.set(map[ServiceSignature]float64{{"web", "prod"}: 1}, map[ServiceSignature]float64{{"web", "prod"}: 1})
+	assert.Len(t, parseSVG(t, st.FlameGraph()), 3)
+}
@@ -0,0 +1,153 @@
+package sampler
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+)
+
+const (
+	// defaultFrequencyPeriod is the period over which traffic is counted before
+	// the request rates of services are updated.
+	defaultFrequencyPeriod = 10 * time.Second
+
+	// frequencyAlpha is the weight of the last period in the moving average of
+	// the request rates.
+	frequencyAlpha = 0.3
+
+	// minFrequency is the request rate, in requests per minute, below which
+	// a service is forgotten.
+	minFrequency = 0.01
+)
+
+// InverseFrequencySampler weights the sample rates of services inversely to
+// their traffic, so that high-traffic services do not get most of the samples
+// and rare services are always sampled. It tracks the requests per minute of
+// each service with an exponentially weighted moving average. The weight of a
+// service is 1 / log(1 + requestsPerMinute), normalized so that the least busy
+// service gets a weight of 1. Services receiving less than a request per
+// minute always get a weight of 1.
+type InverseFrequencySampler struct {
+	period time.Duration
+
+	mu      sync.RWMutex
+	counts  map[string]float64 // traces seen by service during the current period
+	rpm     map[string]float64 // moving average of requests per minute by service
+	weights map[string]float64 // by service
+
+	exit chan struct{}
+}
+
+// NewInverseFrequencySampler returns a new InverseFrequencySampler.
+func NewInverseFrequencySampler() *InverseFrequencySampler {
+	return &InverseFrequencySampler{
+		period:  defaultFrequencyPeriod,
+		counts:  make(map[string]float64),
+		rpm:     make(map[string]float64),
+		weights: make(map[string]float64),
+		exit:    make(chan struct{}),
+	}
+}
+
+// Run updates the weights of the services every period until Stop is called.
+func (s *InverseFrequencySampler) Run() {
+	t := time.NewTicker(s.period)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			s.update()
+		case <-s.exit:
+			return
+		}
+	}
+}
+
+// Stop stops the sampler.
+func (s *InverseFrequencySampler) Stop() {
+	close(s.exit)
+}
+
+// Count counts a request to the given service.
+func (s *InverseFrequencySampler) Count(service string) {
+	s.mu.Lock()
+	s.counts[service]++
+	s.mu.Unlock()
+}
+
+// Weight returns the weight to multiply the sample rate of the given service
+// with. Services which were not seen yet get a weight of 1.
+func (s *InverseFrequencySampler) Weight(service string) float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if w, ok := s.weights[service]; ok {
+		return w
+	}
+	return 1
+}
+
+// update adds the traffic of the last period to the moving averages of the
+// request rates, recomputes the weights and starts a new period.
+func (s *InverseFrequencySampler) update() {
+	s.mu.Lock()
+	counts := s.counts
+	s.counts = make(map[string]float64, len(counts))
+	s.mu.Unlock()
+
+	perMinute := time.Minute.Seconds() / s.period.Seconds()
+	rpm := make(map[string]float64, len(s.rpm))
+	for service, avg := range s.rpm {
+		// services without traffic during the period decay
+		rpm[service] = (1 - frequencyAlpha) * avg
+	}
+	for service, n := range counts {
+		if avg, ok := s.rpm[service]; ok {
+			rpm[service] = frequencyAlpha*n*perMinute + (1-frequencyAlpha)*avg
+		} else {
+			rpm[service] = n * perMinute
+		}
+	}
+	for service, r := range rpm {
+		if r < minFrequency {
+			delete(rpm, service)
+		}
+	}
+
+	weights := inverseFrequencyWeights(rpm)
+	for service, w := range weights {
+		metrics.Gauge("datadog.trace_agent.sampler.inverse_frequency_weight", w, []string{"service:" + service}, 1)
+	}
+
+	s.mu.Lock()
+	s.rpm = rpm
+	s.weights = weights
+	s.mu.Unlock()
+}
+
+// inverseFrequencyWeights returns the weights of the services with the given
+// requests per minute.
+func inverseFrequencyWeights(rpm map[string]float64) map[string]float64 {
+	raw := make(map[string]float64, len(rpm))
+	var max float64
+	for service, r := range rpm {
+		if r < 1 {
+			continue
+		}
+		w := 1 / math.Log1p(r)
+		raw[service] = w
+		if w > max {
+			max = w
+		}
+	}
+	weights := make(map[string]float64, len(rpm))
+	for service := range rpm {
+		if w, ok := raw[service]; ok {
+			weights[service] = w / max
+		} else {
+			weights[service] = 1
+		}
+	}
+	return weights
+}
@@ -0,0 +1,106 @@
+package sampler
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInverseFrequencyWeights(t *testing.T) {
+	for name, tc := range map[string]struct {
+		rpm     map[string]float64
+		weights map[string]float64
+	}{
+		"empty": {
+			rpm:     map[string]float64{},
+			weights: map[string]float64{},
+		},
+		"single": {
+			rpm:     map[string]float64{"web": 10000},
+			weights: map[string]float64{"web": 1},
+		},
+		"rare and busy": {
+			rpm:     map[string]float64{"cron": 10, "web": 10000},
+			weights: map[string]float64{"cron": 1, "web": math.Log(11) / math.Log(10001)},
+		},
+		"below a request per minute": {
+			rpm:     map[string]float64{"batch": 0.5, "web": 10000},
+			weights: map[string]float64{"batch": 1, "web": 1},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			weights := inverseFrequencyWeights(tc.rpm)
+			assert.Len(t, weights, len(tc.weights))
+			for service, w := range tc.weights {
+				assert.InDelta(t, w, weights[service], 1e-9, service)
+			}
+		})
+	}
+}
+
+func TestInverseFrequencySampler(t *testing.T) {
+	assert := assert.New(t)
+	s := NewInverseFrequencySampler()
+	s.period = time.Minute
+	assert.Equal(1.0, s.Weight("web"), "unknown services are kept")
+
+	// over one minute: web receives 10000 requests, cron 10
+	for i := 0; i < 10000; i++ {
+		s.Count("web")
+	}
+	for i := 0; i < 10; i++ {
+		s.Count("cron")
+	}
+	s.update()
+	assert.Equal(1.0, s.Weight("cron"))
+	assert.InDelta(0.26, s.Weight("web"), 0.01)
+	assert.InDelta(10000, s.rpm["web"], 1e-9)
+
+	// the request rates are moving averages
+	for i := 0; i < 10; i++ {
+		s.Count("cron")
+	}
+	s.update()
+	assert.InDelta(7000, s.rpm["web"], 1e-9)
+	assert.InDelta(10, s.rpm["cron"], 1e-9)
+
+	// idle services are eventually forgotten
+	for i := 0; i < 50; i++ {
+		s.update()
+	}
+	assert.Empty(s.rpm)
+	assert.Equal(1.0, s.Weight("web"))
+}
+
+func TestInverseFrequencyEngine(t *testing.T) {
+	s := getTestScoreEngine()
+	s.UseInverseFrequency()
+	s.frequency.period = time.Minute
+
+	newRoot := func(service string) *pb.Span {
+		return &pb.Span{TraceID: randomTraceID(), SpanID: 1, Start: 42, Duration: 1000000, Service: service, Type: "web"}
+	}
+	sample := func(service string) (signatureRate, rate float64) {
+		root := newRoot(service)
+		trace := pb.Trace{root}
+		_, rate = s.Sample(trace, root, defaultEnv)
+		return s.Sampler.GetSampleRate(trace, root, computeSignatureWithRootAndEnv(trace, root, defaultEnv)), rate
+	}
+	for i := 0; i < 10000; i++ {
+		sample("web")
+	}
+	for i := 0; i < 10; i++ {
+		sample("cron")
+	}
+	s.frequency.update()
+
+	// the rate of the busy service is weighted down, not the one of the
+	// rare service
+	webSignatureRate, webRate := sample("web")
+	cronSignatureRate, cronRate := sample("cron")
+	assert.InDelta(t, webSignatureRate*math.Log(11)/math.Log(10001), webRate, 1e-3)
+	assert.InDelta(t, cronSignatureRate, cronRate, 1e-3)
+}
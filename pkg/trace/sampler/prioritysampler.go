@@ -35,6 +35,9 @@ type PriorityEngine struct {
 	rateByService *RateByService
 	catalog       *serviceKeyCatalog
 	exit          chan struct{}
+
+	// traffic, when set, receives the throughput and rate of each service.
+	traffic *ServiceTraffic
 }
 
 // NewPriorityEngine returns an initialized Sampler
@@ -49,6 +52,12 @@ func NewPriorityEngine(extraRate float64, maxTPS float64, rateByService *RateByS
 	return s
 }
 
+// ReportTraffic makes the engine report the throughput and sampling rate of each
+// service to traffic. It must be called before Run.
+func (s *PriorityEngine) ReportTraffic(traffic *ServiceTraffic) {
+	s.traffic = traffic
+}
+
 // Run runs and block on the Sampler main loop
 func (s *PriorityEngine) Run() {
 	var wg sync.WaitGroup
@@ -66,7 +75,11 @@ func (s *PriorityEngine) Run() {
 		for {
 			select {
 			case <-t.C:
-				s.rateByService.SetAll(s.ratesByService())
+				rates := s.ratesByService()
+				s.rateByService.SetAll(rates)
+				if s.traffic != nil {
+					s.traffic.set(s.catalog.volumesByService(s.Sampler.Backend.GetSignatureScores()), rates)
+				}
 			case <-s.exit:
 				wg.Done()
 				return
@@ -0,0 +1,128 @@
+package sampler
+
+import (
+	"runtime/debug"
+	"sync/atomic"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// engineTypeNames are the names of the engine types, used to tag metrics.
+var engineTypeNames = map[EngineType]string{
+	NormalScoreEngineType: "score",
+	ErrorsScoreEngineType: "errors",
+	PriorityEngineType:    "priority",
+}
+
+// SafeSampler is a sampler engine recovering from the panics of the engine it
+// wraps. A trace whose sampling panicked is kept. Once the engine panicked
+// maxPanics times, it is disabled and replaced by a NoopSampler, so that a
+// faulty engine doesn't cause trace loss.
+type SafeSampler struct {
+	engine    Engine
+	noop      *NoopSampler
+	maxPanics int64
+
+	panicCount int64 // atomic
+	disabled   int32 // atomic, 1 once the engine is replaced
+}
+
+// NewSafeSampler returns a SafeSampler disabling engine after maxPanics panics.
+func NewSafeSampler(engine Engine, maxPanics int) *SafeSampler {
+	return &SafeSampler{
+		engine:    engine,
+		noop:      NewNoopSampler(engine.GetType()),
+		maxPanics: int64(maxPanics),
+	}
+}
+
+// Run runs the wrapped engine.
+func (s *SafeSampler) Run() {
+	s.engine.Run()
+}
+
+// Stop stops the wrapped engine.
+func (s *SafeSampler) Stop() {
+	s.engine.Stop()
+}
+
+// Sample samples the trace with the wrapped engine, or with the NoopSampler
+// once the engine is disabled.
+func (s *SafeSampler) Sample(trace pb.Trace, root *pb.Span, env string) (sampled bool, rate float64) {
+	if s.Disabled() {
+		return s.noop.Sample(trace, root, env)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			s.recordPanic(r)
+			sampled, rate = true, 1
+		}
+	}()
+	return s.engine.Sample(trace, root, env)
+}
+
+// recordPanic counts a panic of the engine, disabling it once it panicked
+// too many times.
+func (s *SafeSampler) recordPanic(r interface{}) {
+	name := engineTypeNames[s.engine.GetType()]
+	count := atomic.AddInt64(&s.panicCount, 1)
+	log.Errorf("Sampler %s panicked (%d/%d): %v\n%s", name, count, s.maxPanics, r, debug.Stack())
+	if count < s.maxPanics || !atomic.CompareAndSwapInt32(&s.disabled, 0, 1) {
+		return
+	}
+	log.Errorf("Disabling sampler %s after %d panics, all its traces are now kept", name, count)
+	metrics.Count("datadog.trace_agent.sampler.disabled", 1, []string{"sampler:" + name}, 1)
+}
+
+// Disabled reports whether the wrapped engine was disabled.
+func (s *SafeSampler) Disabled() bool {
+	return atomic.LoadInt32(&s.disabled) == 1
+}
+
+// PanicCount returns the number of panics of the wrapped engine.
+func (s *SafeSampler) PanicCount() int64 {
+	return atomic.LoadInt64(&s.panicCount)
+}
+
+// GetState returns the state of the wrapped engine.
+func (s *SafeSampler) GetState() interface{} {
+	return s.engine.GetState()
+}
+
+// GetType returns the type of the wrapped engine.
+func (s *SafeSampler) GetType() EngineType {
+	return s.engine.GetType()
+}
+
+// NoopSampler is a sampler engine keeping all traces.
+type NoopSampler struct {
+	engineType EngineType
+}
+
+// NewNoopSampler returns a NoopSampler reporting the given type.
+func NewNoopSampler(engineType EngineType) *NoopSampler {
+	return &NoopSampler{engineType: engineType}
+}
+
+// Run does nothing.
+func (s *NoopSampler) Run() {}
+
+// Stop does nothing.
+func (s *NoopSampler) Stop() {}
+
+// Sample keeps the trace.
+func (s *NoopSampler) Sample(trace pb.Trace, root *pb.Span, env string) (bool, float64) {
+	return true, 1
+}
+
+// GetState returns nil, the NoopSampler having no state.
+func (s *NoopSampler) GetState() interface{} {
+	return nil
+}
+
+// GetType returns the type of the NoopSampler.
+func (s *NoopSampler) GetType() EngineType {
+	return s.engineType
+}
@@ -0,0 +1,126 @@
+package sampler
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+// panickingEngine is an engine panicking on traces whose root is named
+// "panic", and dropping the others.
+type panickingEngine struct {
+	hashEngine
+	calls int
+}
+
+func (e *panickingEngine) Sample(_ pb.Trace, root *pb.Span, _ string) (bool, float64) {
+	e.calls++
+	if root.Name == "panic" {
+		var m map[string]int
+		m["boom"]++
+	}
+	return false, 0.5
+}
+
+func (e *panickingEngine) GetType() EngineType { return PriorityEngineType }
+
+// countRecorder is a stats client recording the counts it receives.
+type countRecorder struct {
+	metrics.StatsClient
+	mu     sync.Mutex
+	counts map[string][]string
+}
+
+func (c *countRecorder) Count(name string, _ int64, tags []string, _ float64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[name] = append(c.counts[name], tags...)
+	return nil
+}
+
+func TestSafeSampler(t *testing.T) {
+	assert := assert.New(t)
+	stats := &countRecorder{counts: make(map[string][]string)}
+	defer func(old metrics.StatsClient) { metrics.Client = old }(metrics.Client)
+	metrics.Client = stats
+
+	engine := &panickingEngine{}
+	s := NewSafeSampler(engine, 3)
+	assert.Equal(PriorityEngineType, s.GetType())
+	sample := func(name string) (bool, float64) {
+		return s.Sample(pb.Trace{{Name: name}}, &pb.Span{Name: name}, "none")
+	}
+
+	sampled, rate := sample("ok")
+	assert.False(sampled)
+	assert.Equal(0.5, rate)
+
+	for i := 0; i < 2; i++ {
+		sampled, rate = sample("panic")
+		assert.True(sampled, "traces whose sampling panicked are kept")
+		assert.Equal(1.0, rate)
+		assert.False(s.Disabled())
+	}
+	sampled, _ = sample("ok")
+	assert.False(sampled, "the engine is still used below the limit")
+	assert.Empty(stats.counts["datadog.trace_agent.sampler.disabled"])
+
+	sample("panic")
+	assert.True(s.Disabled())
+	assert.EqualValues(3, s.PanicCount())
+	assert.Equal([]string{"sampler:priority"}, stats.counts["datadog.trace_agent.sampler.disabled"])
+
+	calls := engine.calls
+	sampled, rate = sample("ok")
+	assert.True(sampled, "the noop sampler keeps all traces")
+	assert.Equal(1.0, rate)
+	sample("panic")
+	assert.Equal(calls, engine.calls, "the disabled engine isn't called anymore")
+	assert.Len(stats.counts["datadog.trace_agent.sampler.disabled"], 1)
+}
+
+func TestSafeSamplerConcurrentPanics(t *testing.T) {
+	stats := &countRecorder{counts: make(map[string][]string)}
+	defer func(old metrics.StatsClient) { metrics.Client = old }(metrics.Client)
+	metrics.Client = stats
+
+	s := NewSafeSampler(engineFunc(func() { panic("boom") }), 5)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sampled, rate := s.Sample(pb.Trace{{}}, &pb.Span{}, "none")
+			assert.True(t, sampled)
+			assert.Equal(t, 1.0, rate)
+		}()
+	}
+	wg.Wait()
+	assert.True(t, s.Disabled())
+	assert.Equal(t, []string{"sampler:score"}, stats.counts["datadog.trace_agent.sampler.disabled"], "the sampler is disabled once")
+}
+
+// engineFunc is an engine calling itself when sampling traces.
+type engineFunc func()
+
+func (f engineFunc) Run()                  {}
+func (f engineFunc) Stop()                 {}
+func (f engineFunc) GetState() interface{} { return nil }
+func (f engineFunc) GetType() EngineType   { return NormalScoreEngineType }
+func (f engineFunc) Sample(pb.Trace, *pb.Span, string) (bool, float64) {
+	f()
+	return false, 0
+}
+
+func TestNoopSampler(t *testing.T) {
+	s := NewNoopSampler(ErrorsScoreEngineType)
+	sampled, rate := s.Sample(nil, nil, "")
+	assert.True(t, sampled)
+	assert.Equal(t, 1.0, rate)
+	assert.Equal(t, ErrorsScoreEngineType, s.GetType())
+	assert.Nil(t, s.GetState())
+}
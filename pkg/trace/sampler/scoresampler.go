@@ -1,12 +1,28 @@
 package sampler
 
-import "github.com/DataDog/datadog-agent/pkg/trace/pb"
+import (
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/watchdog"
+)
 
 // ScoreEngine is the main component of the sampling logic
 type ScoreEngine struct {
 	// Sampler is the underlying sampler used by this engine, sharing logic among various engines.
 	Sampler    *Sampler
 	engineType EngineType
+
+	// budget, when set, replaces the signature based sample rates with rates
+	// sharing the max TPS fairly across services.
+	budget *BudgetDistributor
+
+	// damper, when set, smooths the sample rates oscillating too fast.
+	damper *OscillationDamper
+
+	// frequency, when set, weights the sample rates inversely to the traffic
+	// of services.
+	frequency *InverseFrequencySampler
 }
 
 // NewScoreEngine returns an initialized Sampler
@@ -31,13 +47,49 @@ func NewErrorsEngine(extraRate float64, maxTPS float64) *ScoreEngine {
 	return s
 }
 
+// UseBudgetDistribution makes the engine compute sample rates by distributing its
+// max TPS across services, instead of by signature. It must be called before Run.
+func (s *ScoreEngine) UseBudgetDistribution() {
+	s.budget = NewBudgetDistributor(s.Sampler.maxTPS)
+}
+
+// UseOscillationDamper makes the engine smooth the sample rates changing by more
+// than maxChangePerSecond per second. It must be called before Run.
+func (s *ScoreEngine) UseOscillationDamper(maxChangePerSecond, dampingFactor float64) {
+	s.damper = NewOscillationDamper(maxChangePerSecond, dampingFactor)
+}
+
+// UseInverseFrequency makes the engine weight the sample rates of services
+// inversely to their traffic. It must be called before Run.
+func (s *ScoreEngine) UseInverseFrequency() {
+	s.frequency = NewInverseFrequencySampler()
+}
+
 // Run runs and block on the Sampler main loop
 func (s *ScoreEngine) Run() {
+	if s.budget != nil {
+		go func() {
+			defer watchdog.LogOnPanic()
+			s.budget.Run()
+		}()
+	}
+	if s.frequency != nil {
+		go func() {
+			defer watchdog.LogOnPanic()
+			s.frequency.Run()
+		}()
+	}
 	s.Sampler.Run()
 }
 
 // Stop stops the main Run loop
 func (s *ScoreEngine) Stop() {
+	if s.budget != nil {
+		s.budget.Stop()
+	}
+	if s.frequency != nil {
+		s.frequency.Stop()
+	}
 	s.Sampler.Stop()
 }
 
@@ -60,7 +112,19 @@ func (s *ScoreEngine) Sample(trace pb.Trace, root *pb.Span, env string) (sampled
 	// Update sampler state by counting this trace
 	s.Sampler.Backend.CountSignature(signature)
 
-	rate = s.Sampler.GetSampleRate(trace, root, signature)
+	if s.budget != nil {
+		s.budget.Count(root.Service)
+		rate = s.budget.Rate(root.Service) * s.Sampler.extraRate
+	} else {
+		rate = s.Sampler.GetSampleRate(trace, root, signature)
+	}
+	if s.frequency != nil {
+		s.frequency.Count(root.Service)
+		rate *= s.frequency.Weight(root.Service)
+	}
+	if s.damper != nil {
+		rate = s.damper.Damp(signature, rate, time.Now())
+	}
 
 	sampled = applySampleRate(root, rate)
 
@@ -122,3 +122,23 @@ func BenchmarkSampler(b *testing.B) {
 
 // Ensure ScoreEngine implements engine.
 var testScoreEngine Engine = &ScoreEngine{}
+
+func TestOscillationDamperEngine(t *testing.T) {
+	assert := assert.New(t)
+
+	s := getTestScoreEngine()
+	s.UseOscillationDamper(0.5, 0.3)
+	trace, root := getTestTrace()
+	signature := testComputeSignature(trace)
+
+	_, rate := s.Sample(trace, root, defaultEnv)
+	assert.Equal(1.0, rate)
+
+	// A burst of identical traces makes the signature rate drop right away,
+	// but the damper holds the rate until the next smoothing step.
+	for i := 0; i < int(1e5); i++ {
+		_, rate = s.Sample(trace, root, defaultEnv)
+	}
+	assert.True(s.Sampler.GetSampleRate(trace, root, signature) < 0.5)
+	assert.Equal(1.0, rate)
+}
@@ -0,0 +1,117 @@
+// Package servicemap builds views of the services reporting traces to the agent.
+package servicemap
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+)
+
+const (
+	// unknownLabel groups the services whose root spans lack a hierarchy label.
+	unknownLabel = "unknown"
+	// maxServices is the maximum number of services in the hierarchy.
+	maxServices = 10000
+)
+
+// Node is a level of the service hierarchy, holding either child nodes, or the
+// services at the bottom level.
+type Node struct {
+	// Label is the hierarchy label the node is a value of, empty for the root.
+	Label string `json:"label,omitempty"`
+	// Name is the value of the label.
+	Name     string   `json:"name"`
+	Children []*Node  `json:"children,omitempty"`
+	Services []string `json:"services,omitempty"`
+}
+
+// ServiceHierarchyBuilder groups services into a tree according to labels set
+// on their root spans, such as their domain and owning team, to make the
+// service map of large organizations navigable. The levels of the tree follow
+// the order of the labels, the first one being the top level, and services are
+// placed according to their most recent root span.
+type ServiceHierarchyBuilder struct {
+	labels []string
+
+	mu       sync.Mutex
+	services map[string][]string // label values by service
+}
+
+// NewServiceHierarchyBuilder returns a new ServiceHierarchyBuilder grouping
+// services by the given labels, outermost first.
+func NewServiceHierarchyBuilder(labels []string) *ServiceHierarchyBuilder {
+	return &ServiceHierarchyBuilder{
+		labels:   labels,
+		services: make(map[string][]string),
+	}
+}
+
+// Observe places the service of root in the hierarchy according to its labels.
+func (b *ServiceHierarchyBuilder) Observe(root *pb.Span) {
+	if root == nil || root.Service == "" {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	path, ok := b.services[root.Service]
+	if !ok {
+		if len(b.services) >= maxServices {
+			return
+		}
+		path = make([]string, len(b.labels))
+		b.services[root.Service] = path
+	}
+	for i, label := range b.labels {
+		v := root.Meta[label]
+		if v == "" {
+			v = unknownLabel
+		}
+		path[i] = v
+	}
+}
+
+// Tree returns the root of the service hierarchy.
+func (b *ServiceHierarchyBuilder) Tree() *Node {
+	root := &Node{Name: "all"}
+	b.mu.Lock()
+	for service, path := range b.services {
+		n := root
+		for i, v := range path {
+			n = n.child(b.labels[i], v)
+		}
+		n.Services = append(n.Services, service)
+	}
+	b.mu.Unlock()
+	root.sort()
+	return root
+}
+
+// child returns the child of n with the given label value, adding it if needed.
+func (n *Node) child(label, name string) *Node {
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	c := &Node{Label: label, Name: name}
+	n.Children = append(n.Children, c)
+	return c
+}
+
+// sort sorts the children and services of n and its descendants by name.
+func (n *Node) sort() {
+	sort.Strings(n.Services)
+	sort.Slice(n.Children, func(i, j int) bool { return n.Children[i].Name < n.Children[j].Name })
+	for _, c := range n.Children {
+		c.sort()
+	}
+}
+
+// ServeHTTP writes the service hierarchy as nested JSON.
+func (b *ServiceHierarchyBuilder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(b.Tree())
+}
@@ -0,0 +1,80 @@
+package servicemap
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+func testRoot(service, domain, team string) *pb.Span {
+	meta := make(map[string]string)
+	if domain != "" {
+		meta["domain"] = domain
+	}
+	if team != "" {
+		meta["team"] = team
+	}
+	return &pb.Span{Service: service, Meta: meta}
+}
+
+func TestServiceHierarchyBuilder(t *testing.T) {
+	assert := assert.New(t)
+	b := NewServiceHierarchyBuilder([]string{"domain", "team"})
+
+	for _, root := range []*pb.Span{
+		testRoot("checkout-api", "payments", "checkout"),
+		testRoot("cart", "payments", "checkout"),
+		testRoot("billing", "payments", "billing"),
+		testRoot("invoices", "payments", "billing"),
+		testRoot("search-api", "catalog", "search"),
+		testRoot("indexer", "catalog", "search"),
+		testRoot("products", "catalog", "products"),
+		testRoot("login", "identity", "auth"),
+		testRoot("users", "identity", "auth"),
+		testRoot("legacy-cron", "", ""),
+		// users moved to another team
+		testRoot("users", "identity", "accounts"),
+		testRoot("checkout-api", "payments", "checkout"),
+		nil,
+		testRoot("", "payments", "checkout"),
+	} {
+		b.Observe(root)
+	}
+
+	expected := &Node{Name: "all", Children: []*Node{
+		{Label: "domain", Name: "catalog", Children: []*Node{
+			{Label: "team", Name: "products", Services: []string{"products"}},
+			{Label: "team", Name: "search", Services: []string{"indexer", "search-api"}},
+		}},
+		{Label: "domain", Name: "identity", Children: []*Node{
+			{Label: "team", Name: "accounts", Services: []string{"users"}},
+			{Label: "team", Name: "auth", Services: []string{"login"}},
+		}},
+		{Label: "domain", Name: "payments", Children: []*Node{
+			{Label: "team", Name: "billing", Services: []string{"billing", "invoices"}},
+			{Label: "team", Name: "checkout", Services: []string{"cart", "checkout-api"}},
+		}},
+		{Label: "domain", Name: "unknown", Children: []*Node{
+			{Label: "team", Name: "unknown", Services: []string{"legacy-cron"}},
+		}},
+	}}
+	assert.Equal(expected, b.Tree())
+
+	rec := httptest.NewRecorder()
+	b.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/service_hierarchy", nil))
+	assert.Equal("application/json", rec.Header().Get("Content-Type"))
+	var served Node
+	assert.NoError(json.NewDecoder(rec.Body).Decode(&served))
+	assert.Equal(expected, &served)
+}
+
+func TestServiceHierarchyBuilderNoLabels(t *testing.T) {
+	b := NewServiceHierarchyBuilder(nil)
+	b.Observe(testRoot("web", "", ""))
+	b.Observe(testRoot("db", "", ""))
+	assert.Equal(t, &Node{Name: "all", Services: []string{"db", "web"}}, b.Tree())
+}
@@ -0,0 +1,329 @@
+package servicemap
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/watchdog"
+)
+
+const (
+	// SchemaPathPrefix prefixes the path of the schema documents, which is
+	// /debug/services/{service}/schema.
+	SchemaPathPrefix = "/debug/services/"
+	// maxEndpoints is the maximum number of endpoints documented by service.
+	maxEndpoints = 1000
+	// schemaPublishInterval is the period over which requests are observed
+	// before their schema documents are published.
+	schemaPublishInterval = time.Hour
+)
+
+var (
+	// requestHeaderPrefixes prefix the tags holding request headers.
+	requestHeaderPrefixes = []string{"http.request.headers.", "request.headers."}
+	// responseHeaderPrefixes prefix the tags holding response headers.
+	responseHeaderPrefixes = []string{"http.response.headers.", "response.headers."}
+)
+
+// OpenAPIDocument is an OpenAPI 3 document describing the HTTP endpoints of a
+// service.
+type OpenAPIDocument struct {
+	OpenAPI string                                  `json:"openapi"`
+	Info    OpenAPIInfo                             `json:"info"`
+	Paths   map[string]map[string]*OpenAPIOperation `json:"paths"`
+}
+
+// OpenAPIInfo is the metadata of an OpenAPIDocument.
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// OpenAPIOperation is an HTTP method of an endpoint.
+type OpenAPIOperation struct {
+	// Summary is the name of the spans of the operation.
+	Summary    string                      `json:"summary,omitempty"`
+	Parameters []OpenAPIParameter          `json:"parameters,omitempty"`
+	Responses  map[string]*OpenAPIResponse `json:"responses"`
+	// Observations is the number of requests the operation was observed in.
+	Observations int `json:"x-observations"`
+}
+
+// OpenAPIParameter is a path, query or header parameter of an operation.
+type OpenAPIParameter struct {
+	Name     string     `json:"name"`
+	In       string     `json:"in"`
+	Required bool       `json:"required,omitempty"`
+	Schema   JSONSchema `json:"schema"`
+}
+
+// OpenAPIResponse is a response of an operation.
+type OpenAPIResponse struct {
+	Description string                    `json:"description"`
+	Headers     map[string]*OpenAPIHeader `json:"headers,omitempty"`
+}
+
+// OpenAPIHeader is a header of a response.
+type OpenAPIHeader struct {
+	Schema JSONSchema `json:"schema"`
+}
+
+// JSONSchema is the JSON Schema of a value.
+type JSONSchema struct {
+	Type string `json:"type"`
+}
+
+// endpointObservations accumulates the requests observed for an endpoint.
+type endpointObservations struct {
+	operation       string
+	count           int
+	pathParams      int
+	statuses        map[string]int
+	queryParams     map[string]bool
+	requestHeaders  map[string]bool
+	responseHeaders map[string]bool
+}
+
+// SchemaDocumentationExtractor documents the HTTP endpoints of services from
+// the requests observed in their spans: their methods, URL paths, query
+// parameters, headers and status codes. Every hour, an OpenAPI document of the
+// requests observed during the hour is published for each service, and served
+// on /debug/services/{service}/schema. URL path segments containing digits are
+// documented as path parameters.
+type SchemaDocumentationExtractor struct {
+	mu        sync.Mutex
+	services  map[string]map[string]*endpointObservations // by service, then by method and path
+	start     time.Time
+	documents map[string]*OpenAPIDocument // published documents, by service
+
+	exit chan struct{}
+}
+
+// NewSchemaDocumentationExtractor returns a new SchemaDocumentationExtractor.
+func NewSchemaDocumentationExtractor() *SchemaDocumentationExtractor {
+	return &SchemaDocumentationExtractor{
+		services:  make(map[string]map[string]*endpointObservations),
+		start:     time.Now(),
+		documents: make(map[string]*OpenAPIDocument),
+		exit:      make(chan struct{}),
+	}
+}
+
+// Start starts publishing the documents every hour.
+func (e *SchemaDocumentationExtractor) Start() {
+	go func() {
+		defer watchdog.LogOnPanic()
+		ticker := time.NewTicker(schemaPublishInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				e.publish(now)
+			case <-e.exit:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops publishing the documents.
+func (e *SchemaDocumentationExtractor) Stop() {
+	close(e.exit)
+}
+
+// Observe records the HTTP requests found in the spans of t, which are the
+// spans with http.method and http.url tags.
+func (e *SchemaDocumentationExtractor) Observe(t pb.Trace) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, span := range t {
+		method := strings.ToLower(span.Meta["http.method"])
+		rawURL := span.Meta["http.url"]
+		if span.Service == "" || method == "" || rawURL == "" {
+			continue
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			continue
+		}
+		path, params := pathTemplate(u.Path)
+
+		endpoints, ok := e.services[span.Service]
+		if !ok {
+			if len(e.services) >= maxServices {
+				continue
+			}
+			endpoints = make(map[string]*endpointObservations)
+			e.services[span.Service] = endpoints
+		}
+		key := method + " " + path
+		obs, ok := endpoints[key]
+		if !ok {
+			if len(endpoints) >= maxEndpoints {
+				continue
+			}
+			obs = &endpointObservations{
+				pathParams:      params,
+				statuses:        make(map[string]int),
+				queryParams:     make(map[string]bool),
+				requestHeaders:  make(map[string]bool),
+				responseHeaders: make(map[string]bool),
+			}
+			endpoints[key] = obs
+		}
+		obs.operation = span.Name
+		obs.count++
+		if status := span.Meta["http.status_code"]; status != "" {
+			obs.statuses[status]++
+		}
+		for name := range u.Query() {
+			obs.queryParams[name] = true
+		}
+		for k := range span.Meta {
+			if name := trimPrefixes(k, requestHeaderPrefixes); name != "" {
+				obs.requestHeaders[name] = true
+			}
+			if name := trimPrefixes(k, responseHeaderPrefixes); name != "" {
+				obs.responseHeaders[name] = true
+			}
+		}
+	}
+}
+
+// Document returns the document of service published last, or nil if none was.
+func (e *SchemaDocumentationExtractor) Document(service string) *OpenAPIDocument {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.documents[service]
+}
+
+// publish replaces the published documents by the ones of the requests
+// observed since the previous publication, and starts a new observation period.
+func (e *SchemaDocumentationExtractor) publish(now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	documents := make(map[string]*OpenAPIDocument, len(e.services))
+	for service, endpoints := range e.services {
+		documents[service] = document(service, endpoints, e.start, now)
+	}
+	e.documents = documents
+	e.services = make(map[string]map[string]*endpointObservations)
+	e.start = now
+}
+
+// document returns the document of the endpoints of service observed between
+// start and end.
+func document(service string, endpoints map[string]*endpointObservations, start, end time.Time) *OpenAPIDocument {
+	doc := &OpenAPIDocument{
+		OpenAPI: "3.0.3",
+		Info: OpenAPIInfo{
+			Title:   service,
+			Version: end.UTC().Format(time.RFC3339),
+		},
+		Paths: make(map[string]map[string]*OpenAPIOperation),
+	}
+	requests := 0
+	for key, obs := range endpoints {
+		parts := strings.SplitN(key, " ", 2)
+		method, path := parts[0], parts[1]
+		op := &OpenAPIOperation{
+			Summary:      obs.operation,
+			Responses:    make(map[string]*OpenAPIResponse, len(obs.statuses)),
+			Observations: obs.count,
+		}
+		for i := 1; i <= obs.pathParams; i++ {
+			op.Parameters = append(op.Parameters, OpenAPIParameter{Name: "param" + strconv.Itoa(i), In: "path", Required: true, Schema: JSONSchema{Type: "string"}})
+		}
+		for _, name := range sortedKeys(obs.queryParams) {
+			op.Parameters = append(op.Parameters, OpenAPIParameter{Name: name, In: "query", Schema: JSONSchema{Type: "string"}})
+		}
+		for _, name := range sortedKeys(obs.requestHeaders) {
+			op.Parameters = append(op.Parameters, OpenAPIParameter{Name: name, In: "header", Schema: JSONSchema{Type: "string"}})
+		}
+		var headers map[string]*OpenAPIHeader
+		if len(obs.responseHeaders) > 0 {
+			headers = make(map[string]*OpenAPIHeader, len(obs.responseHeaders))
+			for name := range obs.responseHeaders {
+				headers[name] = &OpenAPIHeader{Schema: JSONSchema{Type: "string"}}
+			}
+		}
+		for status, n := range obs.statuses {
+			op.Responses[status] = &OpenAPIResponse{Description: fmt.Sprintf("Observed in %d requests", n), Headers: headers}
+		}
+		if len(op.Responses) == 0 {
+			op.Responses["default"] = &OpenAPIResponse{Description: "No status code observed", Headers: headers}
+		}
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*OpenAPIOperation)
+		}
+		doc.Paths[path][method] = op
+		requests += obs.count
+	}
+	doc.Info.Description = fmt.Sprintf("Generated from %d requests observed between %s and %s.",
+		requests, start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339))
+	return doc
+}
+
+// pathTemplate returns path with the segments containing digits replaced by
+// path parameters, along with the number of parameters.
+func pathTemplate(path string) (string, int) {
+	if path == "" {
+		return "/", 0
+	}
+	segments := strings.Split(path, "/")
+	params := 0
+	for i, seg := range segments {
+		if strings.ContainsAny(seg, "0123456789") {
+			params++
+			segments[i] = "{param" + strconv.Itoa(params) + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// trimPrefixes returns the lowercase rest of k after the first of the given
+// prefixes it starts with, or an empty string if none.
+func trimPrefixes(k string, prefixes []string) string {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(k, prefix) {
+			return strings.ToLower(strings.TrimPrefix(k, prefix))
+		}
+	}
+	return ""
+}
+
+// sortedKeys returns the keys of m, sorted.
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ServeHTTP writes the document of the service of /debug/services/{service}/schema.
+func (e *SchemaDocumentationExtractor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, SchemaPathPrefix)
+	if !strings.HasSuffix(rest, "/schema") {
+		http.NotFound(w, r)
+		return
+	}
+	service := strings.TrimSuffix(rest, "/schema")
+	doc := e.Document(service)
+	if doc == nil {
+		http.Error(w, fmt.Sprintf("no schema published for service %q yet", service), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(doc)
+}
@@ -0,0 +1,127 @@
+package servicemap
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+func testHTTPSpan(service, method, url, status string, meta ...string) *pb.Span {
+	m := map[string]string{"http.method": method, "http.url": url}
+	if status != "" {
+		m["http.status_code"] = status
+	}
+	for i := 0; i+1 < len(meta); i += 2 {
+		m[meta[i]] = meta[i+1]
+	}
+	return &pb.Span{Service: service, Name: "http.request", Meta: m}
+}
+
+func TestSchemaDocumentationExtractor(t *testing.T) {
+	assert := assert.New(t)
+	e := NewSchemaDocumentationExtractor()
+	start := time.Date(2019, 3, 1, 11, 0, 0, 0, time.UTC)
+	e.start = start
+
+	e.Observe(pb.Trace{
+		testHTTPSpan("users-api", "GET", "http://users/users/42?fields=name", "200", "http.request.headers.Authorization", "Bearer x"),
+		testHTTPSpan("users-api", "GET", "http://users/users/43?expand=true", "404", "http.response.headers.content-type", "text/plain"),
+		testHTTPSpan("users-api", "POST", "http://users/users", "201", "request.headers.x-request-id", "abc"),
+		{Service: "users-api", Name: "postgres.query", Meta: map[string]string{"sql.query": "SELECT 1"}},
+	})
+	e.Observe(pb.Trace{
+		testHTTPSpan("web", "GET", "/", ""),
+		testHTTPSpan("", "GET", "/", "200"),
+		testHTTPSpan("web", "GET", "%zz", "200"),
+	})
+	assert.Nil(e.Document("users-api"), "nothing is published before the hour")
+
+	end := start.Add(time.Hour)
+	e.publish(end)
+	assert.Empty(e.services, "a new observation period is started")
+	str := JSONSchema{Type: "string"}
+	assert.Equal(&OpenAPIDocument{
+		OpenAPI: "3.0.3",
+		Info: OpenAPIInfo{
+			Title:       "users-api",
+			Version:     "2019-03-01T12:00:00Z",
+			Description: "Generated from 3 requests observed between 2019-03-01T11:00:00Z and 2019-03-01T12:00:00Z.",
+		},
+		Paths: map[string]map[string]*OpenAPIOperation{
+			"/users/{param1}": {
+				"get": {
+					Summary: "http.request",
+					Parameters: []OpenAPIParameter{
+						{Name: "param1", In: "path", Required: true, Schema: str},
+						{Name: "expand", In: "query", Schema: str},
+						{Name: "fields", In: "query", Schema: str},
+						{Name: "authorization", In: "header", Schema: str},
+					},
+					Responses: map[string]*OpenAPIResponse{
+						"200": {Description: "Observed in 1 requests", Headers: map[string]*OpenAPIHeader{"content-type": {Schema: str}}},
+						"404": {Description: "Observed in 1 requests", Headers: map[string]*OpenAPIHeader{"content-type": {Schema: str}}},
+					},
+					Observations: 2,
+				},
+			},
+			"/users": {
+				"post": {
+					Summary:      "http.request",
+					Parameters:   []OpenAPIParameter{{Name: "x-request-id", In: "header", Schema: str}},
+					Responses:    map[string]*OpenAPIResponse{"201": {Description: "Observed in 1 requests"}},
+					Observations: 1,
+				},
+			},
+		},
+	}, e.Document("users-api"))
+	assert.Equal(map[string]*OpenAPIResponse{"default": {Description: "No status code observed"}}, e.Document("web").Paths["/"]["get"].Responses)
+
+	// the documents of the previous hour are replaced
+	e.Observe(pb.Trace{testHTTPSpan("web", "GET", "/health", "200")})
+	e.publish(end.Add(time.Hour))
+	assert.Nil(e.Document("users-api"))
+	assert.Contains(e.Document("web").Paths, "/health")
+}
+
+func TestSchemaDocumentationExtractorServeHTTP(t *testing.T) {
+	e := NewSchemaDocumentationExtractor()
+	e.Observe(pb.Trace{testHTTPSpan("users-api", "GET", "/users/42", "200")})
+	e.publish(time.Now())
+
+	rr := httptest.NewRecorder()
+	e.ServeHTTP(rr, httptest.NewRequest("GET", "/debug/services/users-api/schema", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	var doc OpenAPIDocument
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&doc))
+	assert.Equal(t, "users-api", doc.Info.Title)
+	assert.Contains(t, doc.Paths, "/users/{param1}")
+
+	for path, code := range map[string]int{
+		"/debug/services/web/schema": http.StatusNotFound,
+		"/debug/services/users-api":  http.StatusNotFound,
+	} {
+		rr := httptest.NewRecorder()
+		e.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, code, rr.Code, path)
+	}
+}
+
+func TestPathTemplate(t *testing.T) {
+	for path, expected := range map[string]string{
+		"":                           "/",
+		"/":                          "/",
+		"/users":                     "/users",
+		"/users/42/orders/a1b2":      "/users/{param1}/orders/{param2}",
+		"/v2/users/me":               "/{param1}/users/me",
+		"/files/report-2019.pdf/raw": "/files/{param1}/raw",
+	} {
+		template, _ := pathTemplate(path)
+		assert.Equal(t, expected, template, path)
+	}
+}
@@ -0,0 +1,48 @@
+package stats
+
+import "github.com/DataDog/datadog-agent/pkg/util/log"
+
+// OverflowResource replaces the resource of the stats of the spans of the
+// services exceeding their number of unique keys.
+const OverflowResource = "__overflow__"
+
+// CardinalityAwareKeySelector bounds the number of unique aggregation keys of
+// each service, so that services with high-cardinality resources don't bloat
+// the stats. Once a service reaches its maximum number of keys, the resource
+// of its new keys is collapsed to OverflowResource, while its known keys are
+// still aggregated as usual. It is not safe for concurrent use.
+type CardinalityAwareKeySelector struct {
+	max  int
+	keys map[string]map[string]struct{} // by service
+}
+
+// NewCardinalityAwareKeySelector returns a new CardinalityAwareKeySelector
+// allowing max unique keys per service.
+func NewCardinalityAwareKeySelector(max int) *CardinalityAwareKeySelector {
+	return &CardinalityAwareKeySelector{
+		max:  max,
+		keys: make(map[string]map[string]struct{}),
+	}
+}
+
+// Allow reports whether the stats of the given service can be aggregated by
+// key, which is the case for the keys already known and, as long as the
+// service has less than the maximum number of keys, for the new ones.
+func (s *CardinalityAwareKeySelector) Allow(service, key string) bool {
+	keys, ok := s.keys[service]
+	if !ok {
+		keys = make(map[string]struct{})
+		s.keys[service] = keys
+	}
+	if _, ok := keys[key]; ok {
+		return true
+	}
+	if len(keys) >= s.max {
+		return false
+	}
+	keys[key] = struct{}{}
+	if len(keys) == s.max {
+		log.Debugf("Service %q reached %d unique stats keys, the resource of its new keys is collapsed to %s", service, s.max, OverflowResource)
+	}
+	return true
+}
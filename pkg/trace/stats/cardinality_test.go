@@ -0,0 +1,66 @@
+package stats
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/traceutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCardinalityAwareKeySelector(t *testing.T) {
+	assert := assert.New(t)
+	s := NewCardinalityAwareKeySelector(3)
+
+	for i := 0; i < 3; i++ {
+		assert.True(s.Allow("web", fmt.Sprintf("key%d", i)))
+	}
+	// the limit is reached: new keys overflow, known ones don't
+	assert.False(s.Allow("web", "key3"))
+	assert.True(s.Allow("web", "key0"))
+	assert.True(s.Allow("web", "key2"))
+	assert.False(s.Allow("web", "key4"))
+
+	// the limit is per service
+	assert.True(s.Allow("db", "key3"))
+}
+
+func TestConcentratorMaxUniqueKeysPerService(t *testing.T) {
+	assert := assert.New(t)
+	c := NewConcentrator([]string{}, testBucketInterval, make(chan []Bucket))
+	c.UseMaxUniqueKeysPerService(2)
+
+	now := time.Now().UnixNano()
+	c.oldestTs = alignTs(now, c.bsize) - int64(c.bufferLen)*c.bsize
+
+	trace := pb.Trace{
+		testSpan(1, 0, 10, 0, "A1", "resource1", 0),
+		testSpan(2, 0, 20, 0, "A1", "resource2", 0),
+		testSpan(3, 0, 30, 0, "A1", "resource3", 0),
+		testSpan(4, 0, 40, 0, "A1", "resource4", 0),
+		testSpan(5, 0, 50, 0, "A1", "resource1", 0),
+		testSpan(6, 0, 60, 0, "A2", "resource3", 0),
+	}
+	traceutil.ComputeTopLevel(trace)
+	c.addNow(&Input{Env: "none", Trace: NewWeightedTrace(trace, traceutil.GetRoot(trace))}, now)
+
+	stats := c.flushNow(now + int64(c.bufferLen)*c.bsize)
+	if !assert.Len(stats, 1) {
+		return
+	}
+	hits := make(map[string]float64)
+	for key, count := range stats[0].Counts {
+		if count.Measure == HITS {
+			hits[key] = count.Value
+		}
+	}
+	assert.Equal(map[string]float64{
+		"query|hits|env:none,resource:resource1,service:A1":    2,
+		"query|hits|env:none,resource:resource2,service:A1":    1,
+		"query|hits|env:none,resource:__overflow__,service:A1": 2,
+		"query|hits|env:none,resource:resource3,service:A2":    1,
+	}, hits)
+}
@@ -32,6 +32,14 @@ type Concentrator struct {
 	// wait such time before flushing the stats.
 	// This only applies to past buckets. Stats buckets in the future are allowed with no restriction.
 	bufferLen int
+	// backgroundFlushInterval is the idle time after which the buckets are
+	// flushed without waiting for them to be complete. 0 disables it.
+	backgroundFlushInterval time.Duration
+	// lastAdd is the time the last input was added, in nanoseconds.
+	lastAdd int64
+	// maxKeysPerService is the maximum number of unique keys per service in
+	// a bucket. 0 means unlimited.
+	maxKeysPerService int
 
 	In  chan *Input
 	Out chan []Bucket
@@ -65,6 +73,20 @@ func NewConcentrator(aggregators []string, bsize int64, out chan []Bucket) *Conc
 	return &c
 }
 
+// UseBackgroundFlush makes the concentrator flush all its buckets, including
+// the partial ones, once no input was added for interval, so that the stats of
+// the last traces received before the traffic stops are not delayed.
+func (c *Concentrator) UseBackgroundFlush(interval time.Duration) {
+	c.backgroundFlushInterval = interval
+}
+
+// UseMaxUniqueKeysPerService bounds the number of unique keys of each service
+// in a bucket to max, the resource of the keys beyond being collapsed to
+// OverflowResource.
+func (c *Concentrator) UseMaxUniqueKeysPerService(max int) {
+	c.maxKeysPerService = max
+}
+
 // Start starts the concentrator.
 func (c *Concentrator) Start() {
 	go func() {
@@ -82,6 +104,12 @@ func (c *Concentrator) Run() {
 	// flush with the same period as stats buckets
 	flushTicker := time.NewTicker(time.Duration(c.bsize) * time.Nanosecond)
 	defer flushTicker.Stop()
+	var backgroundFlush <-chan time.Time
+	if c.backgroundFlushInterval > 0 {
+		backgroundTicker := time.NewTicker(c.backgroundFlushInterval)
+		defer backgroundTicker.Stop()
+		backgroundFlush = backgroundTicker.C
+	}
 
 	log.Debug("Starting concentrator")
 
@@ -99,6 +127,10 @@ func (c *Concentrator) Run() {
 		select {
 		case <-flushTicker.C:
 			c.Out <- c.Flush()
+		case now := <-backgroundFlush:
+			if sb := c.flushIdle(now.UnixNano()); len(sb) > 0 {
+				c.Out <- sb
+			}
 		case <-c.exit:
 			log.Info("Exiting concentrator, computing remaining stats")
 			c.Out <- c.Flush()
@@ -142,12 +174,16 @@ func (c *Concentrator) addNow(i *Input, now int64) {
 		b, ok := c.buckets[btime]
 		if !ok {
 			b = NewRawBucket(btime, c.bsize)
+			if c.maxKeysPerService > 0 {
+				b.UseKeySelector(NewCardinalityAwareKeySelector(c.maxKeysPerService))
+			}
 			c.buckets[btime] = b
 		}
 
 		subs, _ := i.Sublayers[s.Span]
 		b.HandleSpan(s, i.Env, c.aggregators, subs)
 	}
+	c.lastAdd = now
 
 	c.mu.Unlock()
 }
@@ -186,6 +222,34 @@ func (c *Concentrator) flushNow(now int64) []Bucket {
 	return sb
 }
 
+// flushIdle deletes and returns the buckets up to now, complete or not, when no
+// input was added for the background flush interval. The spans received later
+// for the flushed buckets are counted in the following bucket, so that no
+// bucket is flushed twice.
+func (c *Concentrator) flushIdle(now int64) []Bucket {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now-c.lastAdd < c.backgroundFlushInterval.Nanoseconds() {
+		return nil
+	}
+	var sb []Bucket
+	newOldestTs := c.oldestTs
+	for ts, srb := range c.buckets {
+		if ts > now {
+			// buckets in the future are not partial yet
+			continue
+		}
+		log.Debugf("flushing idle bucket %d", ts)
+		sb = append(sb, srb.Export())
+		delete(c.buckets, ts)
+		if ts+c.bsize > newOldestTs {
+			newOldestTs = ts + c.bsize
+		}
+	}
+	c.oldestTs = newOldestTs
+	return sb
+}
+
 // alignTs returns the provided timestamp truncated to the bucket size.
 // It gives us the start time of the time bucket in which such timestamp falls.
 func alignTs(ts int64, bsize int64) int64 {
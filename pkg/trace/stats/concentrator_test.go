@@ -415,3 +415,46 @@ func TestConcentratorSublayersStatsCounts(t *testing.T) {
 		assert.Equal(val, int64(count.Value), "Wrong value for count %s", key)
 	}
 }
+
+// TestConcentratorFlushIdle tests that partial buckets are flushed once no
+// input was added for the background flush interval, and only once.
+func TestConcentratorFlushIdle(t *testing.T) {
+	assert := assert.New(t)
+	statsChan := make(chan []Bucket)
+	c := NewConcentrator([]string{}, testBucketInterval, statsChan)
+	c.UseBackgroundFlush(time.Second)
+
+	input := func(trace pb.Trace) *Input {
+		traceutil.ComputeTopLevel(trace)
+		return &Input{Env: "none", Trace: NewWeightedTrace(trace, traceutil.GetRoot(trace))}
+	}
+	current := input(pb.Trace{testSpan(1, 0, 10, 0, "A1", "resource1", 0)})
+	now := time.Now().UnixNano()
+	future := input(pb.Trace{{SpanID: 2, Duration: 10, Start: now + 3*testBucketInterval, Service: "A1", Name: "query", Resource: "resource1"}})
+	c.addNow(current, now)
+	c.addNow(future, now)
+
+	// the current bucket is partial, so it isn't flushed by the regular flush
+	assert.Len(c.flushNow(now), 0)
+	assert.Len(c.flushIdle(now+time.Second.Nanoseconds()/2), 0, "input was added recently")
+
+	stats := c.flushIdle(now + time.Second.Nanoseconds())
+	if !assert.Len(stats, 1, "the partial bucket is flushed, the future one is kept") {
+		t.FailNow()
+	}
+	flushed := stats[0].Start
+	assert.Equal(alignTs(now, testBucketInterval), flushed)
+	assert.Equal(1, int(stats[0].Counts["query|hits|env:none,resource:resource1,service:A1"].Value))
+	assert.Len(c.buckets, 1)
+
+	// spans received later for the flushed bucket go to the next one
+	late := input(pb.Trace{testSpan(3, 0, 10, 1, "A1", "resource1", 0)})
+	now += 2 * time.Second.Nanoseconds()
+	c.addNow(late, now)
+	assert.Len(c.flushIdle(now), 0)
+	stats = c.flushIdle(now + time.Second.Nanoseconds())
+	for _, b := range stats {
+		assert.NotEqual(flushed, b.Start, "a bucket is flushed twice")
+	}
+	assert.Len(stats, 1)
+}
@@ -70,6 +70,10 @@ type RawBucket struct {
 
 	// internal buffer for aggregate strings - not threadsafe
 	keyBuf bytes.Buffer
+
+	// keys bounds the number of unique keys per service. It is nil when
+	// unbounded.
+	keys *CardinalityAwareKeySelector
 }
 
 // NewRawBucket opens a new calculation bucket for time ts and initializes it properly
@@ -83,6 +87,12 @@ func NewRawBucket(ts, d int64) *RawBucket {
 	}
 }
 
+// UseKeySelector makes the bucket collapse the resource of the new keys of the
+// services exceeding the maximum number of unique keys of keys.
+func (sb *RawBucket) UseKeySelector(keys *CardinalityAwareKeySelector) {
+	sb.keys = keys
+}
+
 // Export transforms a RawBucket into a Bucket, typically used
 // before communicating data to the API, as RawBucket is the internal
 // type while Bucket is the public, shared one.
@@ -200,6 +210,9 @@ func (sb *RawBucket) HandleSpan(s *WeightedSpan, env string, aggregators []strin
 	}
 
 	grain, tags := assembleGrain(&sb.keyBuf, env, s.Resource, s.Service, m)
+	if sb.keys != nil && !sb.keys.Allow(s.Service, grain) {
+		grain, tags = assembleGrain(&sb.keyBuf, env, OverflowResource, s.Service, m)
+	}
 	sb.add(s, grain, tags)
 
 	for _, sub := range sublayers {
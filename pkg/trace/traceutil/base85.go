@@ -0,0 +1,51 @@
+package traceutil
+
+import (
+	"encoding/ascii85"
+	"encoding/hex"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+)
+
+const (
+	// base85Prefix and base85Suffix delimit base-85 encoded tag values, as in
+	// Adobe's ASCII85 format.
+	base85Prefix = "<~"
+	base85Suffix = "~>"
+)
+
+// CompressBinaryTags re-encodes the hex values of the given tags of span using
+// base-85, which takes 1.25 characters per byte instead of 2. Encoded values
+// are delimited by "<~" and "~>", and can be decoded by DecompressBinaryTag.
+// Values which are not hex strings are left untouched.
+func CompressBinaryTags(span *pb.Span, tags []string) {
+	for _, tag := range tags {
+		v, ok := span.Meta[tag]
+		if !ok || v == "" {
+			continue
+		}
+		b, err := hex.DecodeString(v)
+		if err != nil {
+			continue
+		}
+		buf := make([]byte, ascii85.MaxEncodedLen(len(b)))
+		n := ascii85.Encode(buf, b)
+		span.Meta[tag] = base85Prefix + string(buf[:n]) + base85Suffix
+	}
+}
+
+// DecompressBinaryTag returns the lowercase hex value of a tag value encoded by
+// CompressBinaryTags. Other values are returned as is.
+func DecompressBinaryTag(v string) string {
+	if !strings.HasPrefix(v, base85Prefix) || !strings.HasSuffix(v, base85Suffix) || len(v) < len(base85Prefix)+len(base85Suffix) {
+		return v
+	}
+	src := v[len(base85Prefix) : len(v)-len(base85Suffix)]
+	buf := make([]byte, 4*len(src))
+	n, _, err := ascii85.Decode(buf, []byte(src), true)
+	if err != nil {
+		return v
+	}
+	return hex.EncodeToString(buf[:n])
+}
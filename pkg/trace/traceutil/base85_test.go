@@ -0,0 +1,83 @@
+package traceutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCertificate returns a self-signed DER certificate.
+func testCertificate(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "web.example.com"},
+		NotBefore:    time.Unix(1550000000, 0),
+		NotAfter:     time.Unix(1550000000, 0).Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	return der
+}
+
+func TestCompressBinaryTags(t *testing.T) {
+	assert := assert.New(t)
+	cert := testCertificate(t)
+	payload, err := proto.Marshal(&pb.Span{Service: "web", Name: "grpc.request", TraceID: 42, SpanID: 1, Meta: map[string]string{"user": "bob"}})
+	require.NoError(t, err)
+
+	span := &pb.Span{Meta: map[string]string{
+		"tls.certificate":      hex.EncodeToString(cert),
+		"grpc.request.payload": hex.EncodeToString(payload),
+		"http.url":             "http://example.com/",
+		"empty":                "",
+	}}
+	CompressBinaryTags(span, []string{"tls.certificate", "grpc.request.payload", "http.url", "empty", "missing"})
+
+	for tag, original := range map[string][]byte{"tls.certificate": cert, "grpc.request.payload": payload} {
+		v := span.Meta[tag]
+		assert.Regexp(`^<~.*~>$`, v, tag)
+		// 1.25 characters per byte instead of 2, give or take the delimiters
+		// and the padding of the last group
+		assert.True(len(v) <= len(original)*5/4+8, tag)
+		assert.Equal(hex.EncodeToString(original), DecompressBinaryTag(v), tag)
+	}
+	var decoded pb.Span
+	require.NoError(t, proto.Unmarshal(mustDecodeHex(t, DecompressBinaryTag(span.Meta["grpc.request.payload"])), &decoded))
+	assert.Equal("bob", decoded.Meta["user"])
+	_, err = x509.ParseCertificate(mustDecodeHex(t, DecompressBinaryTag(span.Meta["tls.certificate"])))
+	assert.NoError(err)
+
+	assert.Equal("http://example.com/", span.Meta["http.url"], "values which are not hex are left untouched")
+	assert.Equal("", span.Meta["empty"])
+	assert.NotContains(span.Meta, "missing")
+
+	CompressBinaryTags(&pb.Span{}, []string{"tls.certificate"})
+}
+
+func TestDecompressBinaryTag(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("deadbeef", DecompressBinaryTag(`<~hQ=N\~>`))
+	assert.Equal("", DecompressBinaryTag("<~~>"))
+	for _, v := range []string{"", "deadbeef", "<~", "~>", `<~hQ=N\`, "<~not base 85 {}~>"} {
+		assert.Equal(v, DecompressBinaryTag(v))
+	}
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
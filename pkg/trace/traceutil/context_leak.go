@@ -0,0 +1,91 @@
+package traceutil
+
+import (
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+)
+
+// ContextKeys lists the meta keys holding request context which must not leak
+// between requests.
+var ContextKeys = []string{"user.id", "usr.id", "session.id"}
+
+// ContextLeak is a request context value of a trace found in a later trace.
+type ContextLeak struct {
+	// Service is the service of the span the value leaked to.
+	Service string
+	// Key and Value are the meta key and value which leaked.
+	Key   string
+	Value string
+	// TraceID and SpanID identify the span the value leaked to.
+	TraceID uint64
+	SpanID  uint64
+	// PreviousTraceID is the ID of the trace the value leaked from.
+	PreviousTraceID uint64
+}
+
+// contextValue identifies a request context value set on the spans of a service.
+type contextValue struct {
+	service, key, value string
+}
+
+// DetectContextLeak returns the request context values, out of ContextKeys, of
+// the spans of previous which appear on spans of the same service in current,
+// a different trace, while current belongs to another context. The context of
+// current is given by the value of its earliest span holding the key, so that
+// successive requests from the same user or session are not reported.
+func DetectContextLeak(current pb.Trace, previous pb.Trace) []ContextLeak {
+	if len(current) == 0 || len(previous) == 0 || current[0].TraceID == previous[0].TraceID {
+		return nil
+	}
+	seen := make(map[contextValue]struct{})
+	for _, s := range previous {
+		for _, k := range ContextKeys {
+			if v, ok := s.Meta[k]; ok && v != "" {
+				seen[contextValue{s.Service, k, v}] = struct{}{}
+			}
+		}
+	}
+	if len(seen) == 0 {
+		return nil
+	}
+
+	var leaks []ContextLeak
+	for _, k := range ContextKeys {
+		owner := contextOwner(current, k)
+		if owner == nil {
+			continue
+		}
+		for _, s := range current {
+			v := s.Meta[k]
+			if v == "" || v == owner.Meta[k] {
+				continue
+			}
+			if _, ok := seen[contextValue{s.Service, k, v}]; !ok {
+				continue
+			}
+			leaks = append(leaks, ContextLeak{
+				Service:         s.Service,
+				Key:             k,
+				Value:           v,
+				TraceID:         s.TraceID,
+				SpanID:          s.SpanID,
+				PreviousTraceID: previous[0].TraceID,
+			})
+		}
+	}
+	return leaks
+}
+
+// contextOwner returns the earliest span of t holding the given context key, or
+// nil.
+func contextOwner(t pb.Trace, key string) *pb.Span {
+	var owner *pb.Span
+	for _, s := range t {
+		if s.Meta[key] == "" {
+			continue
+		}
+		if owner == nil || s.Start < owner.Start {
+			owner = s
+		}
+	}
+	return owner
+}
@@ -0,0 +1,60 @@
+package traceutil
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectContextLeak(t *testing.T) {
+	previous := pb.Trace{
+		{TraceID: 1, SpanID: 1, Service: "web", Start: 100, Meta: map[string]string{"user.id": "alice", "session.id": "s1"}},
+		{TraceID: 1, SpanID: 2, ParentID: 1, Service: "auth", Start: 110, Meta: map[string]string{"user.id": "alice"}},
+	}
+
+	t.Run("leak", func(t *testing.T) {
+		current := pb.Trace{
+			{TraceID: 2, SpanID: 1, Service: "web", Start: 200, Meta: map[string]string{"user.id": "bob", "session.id": "s2", "http.method": "GET"}},
+			{TraceID: 2, SpanID: 2, ParentID: 1, Service: "web", Start: 210, Meta: map[string]string{"user.id": "alice"}},
+			{TraceID: 2, SpanID: 3, ParentID: 1, Service: "cache", Start: 220, Meta: map[string]string{"user.id": "alice"}},
+			{TraceID: 2, SpanID: 4, ParentID: 1, Service: "web", Start: 230, Meta: map[string]string{"session.id": "s1"}},
+		}
+		assert.Equal(t, []ContextLeak{
+			{Service: "web", Key: "user.id", Value: "alice", TraceID: 2, SpanID: 2, PreviousTraceID: 1},
+			{Service: "web", Key: "session.id", Value: "s1", TraceID: 2, SpanID: 4, PreviousTraceID: 1},
+		}, DetectContextLeak(current, previous), "cache did not serve alice before")
+	})
+
+	t.Run("same user", func(t *testing.T) {
+		current := pb.Trace{
+			{TraceID: 2, SpanID: 1, Service: "web", Start: 200, Meta: map[string]string{"user.id": "alice", "session.id": "s1"}},
+			{TraceID: 2, SpanID: 2, ParentID: 1, Service: "auth", Start: 210, Meta: map[string]string{"user.id": "alice"}},
+		}
+		assert.Empty(t, DetectContextLeak(current, previous))
+	})
+
+	t.Run("owner is the earliest span", func(t *testing.T) {
+		current := pb.Trace{
+			{TraceID: 2, SpanID: 2, ParentID: 1, Service: "auth", Start: 210, Meta: map[string]string{"user.id": "alice"}},
+			{TraceID: 2, SpanID: 1, Service: "web", Start: 200, Meta: map[string]string{"user.id": "bob"}},
+		}
+		assert.Equal(t, []ContextLeak{
+			{Service: "auth", Key: "user.id", Value: "alice", TraceID: 2, SpanID: 2, PreviousTraceID: 1},
+		}, DetectContextLeak(current, previous))
+	})
+
+	t.Run("same trace", func(t *testing.T) {
+		current := pb.Trace{
+			{TraceID: 1, SpanID: 3, Service: "web", Start: 120, Meta: map[string]string{"user.id": "bob"}},
+			{TraceID: 1, SpanID: 4, Service: "web", Start: 130, Meta: map[string]string{"user.id": "alice"}},
+		}
+		assert.Empty(t, DetectContextLeak(current, previous))
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		assert.Empty(t, DetectContextLeak(nil, previous))
+		assert.Empty(t, DetectContextLeak(previous, nil))
+		assert.Empty(t, DetectContextLeak(pb.Trace{{TraceID: 2, Service: "web"}}, previous))
+	})
+}
@@ -0,0 +1,55 @@
+package traceutil
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+)
+
+const (
+	// deadlineKey is the meta key of the root span holding the deadline of
+	// the request, in milliseconds or as a duration (e.g. "500ms").
+	deadlineKey = "http.request.headers.x-request-deadline"
+	// remainingDeadlineKey is the meta key set on child spans to the time
+	// left before the deadline of the request when they started, in
+	// milliseconds. It is negative when the deadline was exceeded.
+	remainingDeadlineKey = "_dd.remaining_deadline_ms"
+)
+
+// PropagateDeadline sets on all the spans of t but root the time remaining
+// before the deadline found in the request headers of root, when they started.
+func PropagateDeadline(t pb.Trace, root *pb.Span) {
+	if root == nil {
+		return
+	}
+	deadline, ok := parseDeadline(root.Meta[deadlineKey])
+	if !ok {
+		return
+	}
+	for _, span := range t {
+		if span == root {
+			continue
+		}
+		elapsed := time.Duration(span.Start - root.Start)
+		remaining := (deadline - elapsed) / time.Millisecond
+		if span.Meta == nil {
+			span.Meta = make(map[string]string, 1)
+		}
+		span.Meta[remainingDeadlineKey] = strconv.FormatInt(int64(remaining), 10)
+	}
+}
+
+// parseDeadline parses a deadline given in milliseconds or as a duration.
+func parseDeadline(v string) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if ms, err := strconv.ParseFloat(v, 64); err == nil {
+		return time.Duration(ms * float64(time.Millisecond)), ms >= 0
+	}
+	d, err := time.ParseDuration(v)
+	return d, err == nil && d >= 0
+}
@@ -0,0 +1,46 @@
+package traceutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPropagateDeadline(t *testing.T) {
+	start := time.Now().UnixNano()
+	ms := int64(time.Millisecond)
+	newTrace := func(deadline string) pb.Trace {
+		root := &pb.Span{SpanID: 1, Start: start, Duration: 600 * ms, Meta: map[string]string{}}
+		if deadline != "" {
+			root.Meta[deadlineKey] = deadline
+		}
+		return pb.Trace{
+			root,
+			{SpanID: 2, ParentID: 1, Start: start + 300*ms, Duration: 100 * ms},
+			{SpanID: 3, ParentID: 2, Start: start + 350*ms, Duration: 10 * ms, Meta: map[string]string{"db.type": "redis"}},
+			{SpanID: 4, ParentID: 1, Start: start + 550*ms, Duration: 50 * ms},
+		}
+	}
+
+	for _, deadline := range []string{"500", "500ms", " 0.5s"} {
+		trace := newTrace(deadline)
+		PropagateDeadline(trace, trace[0])
+		assert.NotContains(t, trace[0].Meta, remainingDeadlineKey, deadline)
+		assert.Equal(t, "200", trace[1].Meta[remainingDeadlineKey], deadline)
+		assert.Equal(t, map[string]string{"db.type": "redis", remainingDeadlineKey: "150"}, trace[2].Meta, deadline)
+		// the deadline was exceeded
+		assert.Equal(t, "-50", trace[3].Meta[remainingDeadlineKey], deadline)
+	}
+
+	for _, deadline := range []string{"", "soon", "-100"} {
+		trace := newTrace(deadline)
+		PropagateDeadline(trace, trace[0])
+		for _, span := range trace {
+			assert.NotContains(t, span.Meta, remainingDeadlineKey, deadline)
+		}
+	}
+
+	PropagateDeadline(pb.Trace{}, nil)
+}
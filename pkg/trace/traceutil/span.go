@@ -42,3 +42,24 @@ func setMetric(s *pb.Span, key string, val float64) {
 	}
 	s.Metrics[key] = val
 }
+
+// SpanPriorityScore returns a relative measure of how valuable a span is when
+// some spans of a trace need to be discarded. Root spans score highest, followed
+// by spans with errors, top-level spans and finally spans by their duration.
+func SpanPriorityScore(s *pb.Span) float64 {
+	var score float64
+	if s.ParentID == 0 {
+		score += 1000
+	}
+	if s.Error != 0 {
+		score += 100
+	}
+	if HasTopLevel(s) {
+		score += 10
+	}
+	if s.Duration > 0 {
+		// grows with the duration but stays below the top-level bonus
+		score += float64(s.Duration) / float64(s.Duration+1e9) * 10
+	}
+	return score
+}
@@ -1,6 +1,9 @@
 package traceutil
 
 import (
+	"hash/fnv"
+	"sort"
+
 	"github.com/DataDog/datadog-agent/pkg/trace/pb"
 	"github.com/DataDog/datadog-agent/pkg/util/log"
 )
@@ -132,3 +135,62 @@ func ComputeTopLevel(t pb.Trace) {
 		SetTopLevel(span, true)
 	}
 }
+
+// ServiceEdge is a call from the Parent service to the Child service.
+type ServiceEdge struct {
+	Parent string
+	Child  string
+}
+
+// ExtractServiceGraph returns the distinct edges between the services of t, in
+// order of appearance. Services are first replaced by the value returned by
+// group, which allows merging services into logical groups; it may be nil.
+// Calls within a service or group are ignored.
+func ExtractServiceGraph(t pb.Trace, group func(service string) string) []ServiceEdge {
+	spanIDToService := make(map[uint64]string, len(t))
+	for _, span := range t {
+		service := span.Service
+		if group != nil {
+			service = group(service)
+		}
+		spanIDToService[span.SpanID] = service
+	}
+
+	var edges []ServiceEdge
+	seen := make(map[ServiceEdge]struct{})
+	for _, span := range t {
+		parent, ok := spanIDToService[span.ParentID]
+		if !ok {
+			continue
+		}
+		edge := ServiceEdge{Parent: parent, Child: spanIDToService[span.SpanID]}
+		if edge.Parent == edge.Child {
+			continue
+		}
+		if _, ok := seen[edge]; ok {
+			continue
+		}
+		seen[edge] = struct{}{}
+		edges = append(edges, edge)
+	}
+	return edges
+}
+
+// TopologyHash returns a hash of the service adjacency of t: the sorted list of
+// its distinct "parent->child" service edges. Traces going through the same
+// services in the same way have the same hash, whatever their spans.
+func TopologyHash(t pb.Trace) uint64 {
+	graph := ExtractServiceGraph(t, nil)
+	edges := make([]string, len(graph))
+	for i, e := range graph {
+		edges[i] = e.Parent + "->" + e.Child
+	}
+	sort.Strings(edges)
+
+	h := fnv.New64a()
+	for _, e := range edges {
+		h.Write([]byte(e))
+		h.Write([]byte{'\n'})
+	}
+	return h.Sum64()
+}
@@ -54,3 +54,99 @@ func TestTraceChildrenMap(t *testing.T) {
 	assert.Equal([]*pb.Span{}, childrenMap[5])
 	assert.Equal([]*pb.Span{}, childrenMap[6])
 }
+
+func TestExtractServiceGraph(t *testing.T) {
+	// 21 microservices, one frontend calling one service of each group
+	// which in turn calls the other services of its group
+	groups := map[string][]string{
+		"payments":  {"payment-api", "payment-ledger", "payment-fraud", "payment-refunds"},
+		"users":     {"user-api", "user-profile", "user-auth", "user-prefs"},
+		"catalog":   {"catalog-api", "catalog-search", "catalog-pricing", "catalog-images"},
+		"shipping":  {"shipping-api", "shipping-rates", "shipping-tracking", "shipping-labels"},
+		"databases": {"orders-db", "users-db", "catalog-db", "payments-db"},
+	}
+	groupOf := make(map[string]string)
+	for g, services := range groups {
+		for _, s := range services {
+			groupOf[s] = g
+		}
+	}
+	group := func(service string) string {
+		if g, ok := groupOf[service]; ok {
+			return g
+		}
+		return service
+	}
+
+	trace := pb.Trace{{SpanID: 1, Service: "frontend"}}
+	id := uint64(2)
+	for _, g := range []string{"payments", "users", "catalog", "shipping", "databases"} {
+		entry := id
+		for i, s := range groups[g] {
+			parent := entry
+			if i == 0 {
+				parent = 1
+			}
+			trace = append(trace, &pb.Span{SpanID: id, ParentID: parent, Service: s})
+			id++
+		}
+	}
+	// every group but the databases calls a database
+	trace = append(trace,
+		&pb.Span{SpanID: 100, ParentID: 2, Service: "payments-db"},
+		&pb.Span{SpanID: 101, ParentID: 6, Service: "users-db"},
+		&pb.Span{SpanID: 102, ParentID: 10, Service: "catalog-db"},
+		&pb.Span{SpanID: 103, ParentID: 14, Service: "orders-db"},
+		&pb.Span{SpanID: 104, ParentID: 3, Service: "payments-db"},
+	)
+
+	assert.Len(t, ExtractServiceGraph(trace, nil), 25)
+	assert.Equal(t, []ServiceEdge{
+		{Parent: "frontend", Child: "payments"},
+		{Parent: "frontend", Child: "users"},
+		{Parent: "frontend", Child: "catalog"},
+		{Parent: "frontend", Child: "shipping"},
+		{Parent: "frontend", Child: "databases"},
+		{Parent: "payments", Child: "databases"},
+		{Parent: "users", Child: "databases"},
+		{Parent: "catalog", Child: "databases"},
+		{Parent: "shipping", Child: "databases"},
+	}, ExtractServiceGraph(trace, group))
+}
+
+func TestTopologyHash(t *testing.T) {
+	assert := assert.New(t)
+
+	trace := pb.Trace{
+		{SpanID: 1, Service: "web"},
+		{SpanID: 2, ParentID: 1, Service: "web"},
+		{SpanID: 3, ParentID: 2, Service: "api"},
+		{SpanID: 4, ParentID: 3, Service: "db"},
+	}
+	// same topology: more spans, other order, repeated calls
+	same := pb.Trace{
+		{SpanID: 14, ParentID: 13, Service: "db", Resource: "SELECT"},
+		{SpanID: 11, Service: "web"},
+		{SpanID: 13, ParentID: 11, Service: "api"},
+		{SpanID: 15, ParentID: 11, Service: "api"},
+		{SpanID: 16, ParentID: 15, Service: "db"},
+	}
+	// the api now calls a cache
+	other := pb.Trace{
+		{SpanID: 1, Service: "web"},
+		{SpanID: 2, ParentID: 1, Service: "api"},
+		{SpanID: 3, ParentID: 2, Service: "db"},
+		{SpanID: 4, ParentID: 2, Service: "cache"},
+	}
+	// same edges, reversed
+	reversed := pb.Trace{
+		{SpanID: 1, Service: "db"},
+		{SpanID: 2, ParentID: 1, Service: "api"},
+		{SpanID: 3, ParentID: 2, Service: "web"},
+	}
+
+	assert.Equal(TopologyHash(trace), TopologyHash(same))
+	assert.NotEqual(TopologyHash(trace), TopologyHash(other))
+	assert.NotEqual(TopologyHash(trace), TopologyHash(reversed))
+	assert.Equal(TopologyHash(pb.Trace{{SpanID: 1, Service: "web"}}), TopologyHash(pb.Trace{{SpanID: 1, Service: "api"}}))
+}
@@ -0,0 +1,144 @@
+package writer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/traceutil"
+)
+
+const (
+	// maxAnnotatedTraces is the maximum number of traces annotations are
+	// held for.
+	maxAnnotatedTraces = 100000
+
+	// maxTraceAnnotations is the maximum number of annotations of a trace.
+	maxTraceAnnotations = 100
+)
+
+// AnnotationStore holds the annotations added to traces by external services,
+// until their trace is written or they expire.
+type AnnotationStore struct {
+	ttl time.Duration
+
+	mu              sync.Mutex
+	annotationStore map[uint64]map[string]string // annotations by trace ID
+	expires         map[uint64]time.Time         // expiration time by trace ID
+	lastExpire      time.Time
+}
+
+// NewAnnotationStore returns a store keeping annotations for the given duration.
+func NewAnnotationStore(ttl time.Duration) *AnnotationStore {
+	return &AnnotationStore{
+		ttl:             ttl,
+		annotationStore: make(map[uint64]map[string]string),
+		expires:         make(map[uint64]time.Time),
+	}
+}
+
+// Add adds the given annotations to the trace identified by traceID, replacing
+// existing annotations with the same keys. The annotations of the trace expire
+// after the TTL of the store. It returns false, leaving the trace untouched,
+// if the store holds too many traces or the trace would hold too many
+// annotations.
+func (s *AnnotationStore) Add(traceID uint64, annotations map[string]string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(now)
+
+	stored, ok := s.annotationStore[traceID]
+	if !ok {
+		if len(s.annotationStore) >= maxAnnotatedTraces {
+			s.purge(now)
+		}
+		if len(s.annotationStore) >= maxAnnotatedTraces || len(annotations) > maxTraceAnnotations {
+			return false
+		}
+		stored = make(map[string]string, len(annotations))
+		s.annotationStore[traceID] = stored
+	}
+	added := 0
+	for k := range annotations {
+		if _, ok := stored[k]; !ok {
+			added++
+		}
+	}
+	if len(stored)+added > maxTraceAnnotations {
+		return false
+	}
+	for k, v := range annotations {
+		stored[k] = v
+	}
+	s.expires[traceID] = now.Add(s.ttl)
+	return true
+}
+
+// Get returns a copy of the annotations of the trace identified by traceID.
+func (s *AnnotationStore) Get(traceID uint64, now time.Time) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.annotationStore[traceID]
+	if !ok || now.After(s.expires[traceID]) {
+		return nil
+	}
+	annotations := make(map[string]string, len(stored))
+	for k, v := range stored {
+		annotations[k] = v
+	}
+	return annotations
+}
+
+// Merge returns trace, having the annotations of the trace merged into the
+// meta of its root. As the spans of trace are still read by the concentrator,
+// trace is left untouched: the returned trace holds an annotated copy of the
+// root. Annotations are kept until they expire, so that they are also merged
+// into the parts of the trace flushed later.
+func (s *AnnotationStore) Merge(trace pb.Trace, now time.Time) pb.Trace {
+	if len(trace) == 0 {
+		return trace
+	}
+	annotations := s.Get(trace[0].TraceID, now)
+	if len(annotations) == 0 {
+		return trace
+	}
+	root := traceutil.GetRoot(trace)
+	merged := make(pb.Trace, len(trace))
+	for i, span := range trace {
+		if span == root {
+			span = withOwnMeta(root)
+			for k, v := range annotations {
+				span.Meta[k] = v
+			}
+		}
+		merged[i] = span
+	}
+	return merged
+}
+
+// Len returns the number of annotated traces.
+func (s *AnnotationStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.annotationStore)
+}
+
+// expire removes the expired annotations, at most once per second. It must be
+// called with the lock held.
+func (s *AnnotationStore) expire(now time.Time) {
+	if now.Sub(s.lastExpire) < time.Second {
+		return
+	}
+	s.purge(now)
+}
+
+// purge removes the expired annotations. It must be called with the lock held.
+func (s *AnnotationStore) purge(now time.Time) {
+	s.lastExpire = now
+	for traceID, t := range s.expires {
+		if now.After(t) {
+			delete(s.annotationStore, traceID)
+			delete(s.expires, traceID)
+		}
+	}
+}
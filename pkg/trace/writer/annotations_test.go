@@ -0,0 +1,109 @@
+package writer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnnotationStore(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	s := NewAnnotationStore(5 * time.Minute)
+
+	s.Add(42, map[string]string{"incident": "INC-1", "deploy": "v1"}, now)
+	s.Add(42, map[string]string{"deploy": "v2"}, now.Add(time.Minute))
+	s.Add(43, map[string]string{"incident": "INC-2"}, now)
+	assert.Equal(map[string]string{"incident": "INC-1", "deploy": "v2"}, s.Get(42, now.Add(time.Minute)))
+	assert.Nil(s.Get(44, now))
+
+	// the copy returned can't alter the store
+	s.Get(42, now)["deploy"] = "v3"
+	assert.Equal("v2", s.Get(42, now)["deploy"])
+
+	// trace 43 expires first, trace 42 was annotated a minute later
+	later := now.Add(5*time.Minute + time.Second)
+	assert.Nil(s.Get(43, later))
+	assert.NotNil(s.Get(42, later))
+
+	// expired annotations are removed when others are added
+	s.Add(44, map[string]string{"incident": "INC-3"}, now.Add(7*time.Minute))
+	assert.Equal(1, s.Len())
+}
+
+func TestAnnotationStoreBounds(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	s := NewAnnotationStore(time.Minute)
+
+	many := make(map[string]string, maxTraceAnnotations)
+	for i := 0; i < maxTraceAnnotations; i++ {
+		many[fmt.Sprintf("key%d", i)] = "value"
+	}
+	assert.True(s.Add(1, many, now))
+	assert.True(s.Add(1, map[string]string{"key0": "other"}, now), "existing keys can be replaced")
+	assert.False(s.Add(1, map[string]string{"incident": "INC-1"}, now))
+	many["incident"] = "INC-1"
+	assert.False(s.Add(2, many, now))
+	assert.Nil(s.Get(2, now))
+
+	for i := 3; s.Len() < maxAnnotatedTraces; i++ {
+		assert.True(s.Add(uint64(i), map[string]string{"incident": "INC-1"}, now))
+	}
+	assert.False(s.Add(0, map[string]string{"incident": "INC-2"}, now))
+	assert.True(s.Add(1, map[string]string{"key1": "other"}, now), "annotated traces can still be annotated")
+
+	// expired annotations make room for new traces
+	assert.True(s.Add(0, map[string]string{"incident": "INC-2"}, now.Add(2*time.Minute)))
+	assert.Equal(1, s.Len())
+}
+
+func TestAnnotationStoreMerge(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	s := NewAnnotationStore(time.Minute)
+	s.Add(1, map[string]string{"incident": "INC-1"}, now)
+
+	trace := pb.Trace{
+		{TraceID: 1, SpanID: 2, ParentID: 1},
+		{TraceID: 1, SpanID: 1, Meta: map[string]string{"env": "prod"}},
+	}
+	merged := s.Merge(trace, now)
+	assert.Equal(map[string]string{"env": "prod", "incident": "INC-1"}, merged[1].Meta)
+	assert.Nil(merged[0].Meta)
+	// the spans are still read by the concentrator, the root is annotated on a copy
+	assert.Equal(map[string]string{"env": "prod"}, trace[1].Meta)
+	assert.True(merged[0] == trace[0])
+
+	// roots without meta and traces without annotations
+	other := s.Merge(pb.Trace{{TraceID: 1, SpanID: 3}}, now)
+	assert.Equal(map[string]string{"incident": "INC-1"}, other[0].Meta)
+	unannotated := s.Merge(pb.Trace{{TraceID: 2, SpanID: 1}}, now)
+	assert.Nil(unannotated[0].Meta)
+	assert.Empty(s.Merge(pb.Trace{}, now))
+
+	// expired annotations are not merged
+	expired := s.Merge(pb.Trace{{TraceID: 1, SpanID: 4}}, now.Add(2*time.Minute))
+	assert.Nil(expired[0].Meta)
+}
+
+func TestTraceWriterAnnotations(t *testing.T) {
+	cfg := config.New()
+	cfg.Endpoints[0].APIKey = "123"
+	tw := NewTraceWriter(cfg, make(chan *SampledSpans))
+	store := NewAnnotationStore(time.Minute)
+	store.Add(1, map[string]string{"deployment": "canary"}, time.Now())
+	tw.UseAnnotations(store)
+
+	root := &pb.Span{TraceID: 1, SpanID: 1, Meta: map[string]string{"env": "prod"}}
+	tw.addSpans(&SampledSpans{Trace: pb.Trace{{TraceID: 1, SpanID: 2, ParentID: 1}, root}})
+	if assert.Len(t, tw.traces, 1) {
+		assert.Equal(t, map[string]string{"env": "prod", "deployment": "canary"}, tw.traces[0].Spans[1].Meta)
+	}
+	assert.Equal(t, map[string]string{"env": "prod"}, root.Meta)
+	stopSenders(tw.senders)
+}
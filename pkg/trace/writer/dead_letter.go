@@ -0,0 +1,130 @@
+package writer
+
+import (
+	"container/heap"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+)
+
+// maxDeadLetters is the maximum number of traces kept in the dead-letter queue
+// of the trace writer.
+const maxDeadLetters = 10000
+
+// deadLetter is a trace which could not be written, waiting to be replayed.
+type deadLetter struct {
+	trace     *pb.APITrace
+	priority  int
+	timestamp time.Time
+}
+
+// before reports whether l is replayed before other.
+func (l *deadLetter) before(other *deadLetter) bool {
+	if l.priority != other.priority {
+		return l.priority > other.priority
+	}
+	return l.timestamp.Before(other.timestamp)
+}
+
+// deadLetterHeap orders dead letters by decreasing priority, then by time of
+// arrival.
+type deadLetterHeap []*deadLetter
+
+func (h deadLetterHeap) Len() int { return len(h) }
+
+func (h deadLetterHeap) Less(i, j int) bool { return h[i].before(h[j]) }
+
+func (h deadLetterHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *deadLetterHeap) Push(x interface{}) { *h = append(*h, x.(*deadLetter)) }
+
+func (h *deadLetterHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return x
+}
+
+// PriorityDeadLetterQueue is the dead-letter queue of the trace writer: it
+// holds the traces which could not be written, so that the most valuable ones
+// are replayed first. It is only enabled when the replay priorities of
+// services are configured, through dead_letter_replay_priority.
+//
+// The priority of a trace is the highest of the priorities of the services of
+// its spans, 0 if none has a priority, plus one if it has an error. Traces of
+// equal priority are replayed in the order they were added. It is not safe for
+// concurrent use.
+type PriorityDeadLetterQueue struct {
+	priorities map[string]int
+	max        int
+	letters    deadLetterHeap
+}
+
+// NewPriorityDeadLetterQueue returns a queue holding up to max traces,
+// prioritized using the given priorities by service.
+func NewPriorityDeadLetterQueue(priorities map[string]int, max int) *PriorityDeadLetterQueue {
+	return &PriorityDeadLetterQueue{
+		priorities: priorities,
+		max:        max,
+	}
+}
+
+// Push adds t to the queue. When the queue is full, the lowest-priority and
+// most recent trace, which may be t, is dropped, in which case it returns
+// false.
+func (q *PriorityDeadLetterQueue) Push(t *pb.APITrace, now time.Time) bool {
+	letter := &deadLetter{trace: t, priority: q.priority(t), timestamp: now}
+	if len(q.letters) < q.max {
+		heap.Push(&q.letters, letter)
+		return true
+	}
+	if len(q.letters) == 0 {
+		return false
+	}
+	// the least valuable letter is one of the leaves of the heap
+	last := len(q.letters) / 2
+	for i := len(q.letters) / 2; i < len(q.letters); i++ {
+		if q.letters.Less(last, i) {
+			last = i
+		}
+	}
+	if !letter.before(q.letters[last]) {
+		return false
+	}
+	q.letters[last] = letter
+	heap.Fix(&q.letters, last)
+	return true
+}
+
+// Pop removes and returns the highest-priority trace of the queue, nil if it is
+// empty.
+func (q *PriorityDeadLetterQueue) Pop() *pb.APITrace {
+	if len(q.letters) == 0 {
+		return nil
+	}
+	return heap.Pop(&q.letters).(*deadLetter).trace
+}
+
+// Len returns the number of traces in the queue.
+func (q *PriorityDeadLetterQueue) Len() int {
+	return len(q.letters)
+}
+
+// priority returns the replay priority of t.
+func (q *PriorityDeadLetterQueue) priority(t *pb.APITrace) int {
+	var priority int
+	var found, hasError bool
+	for _, s := range t.Spans {
+		if p, ok := q.priorities[s.Service]; ok && (!found || p > priority) {
+			priority = p
+			found = true
+		}
+		hasError = hasError || s.Error != 0
+	}
+	if hasError {
+		priority++
+	}
+	return priority
+}
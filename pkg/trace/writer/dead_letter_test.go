@@ -0,0 +1,116 @@
+package writer
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testDeadLetterTrace returns a trace with the given ID, made of a root span of
+// service and of a child span of child.
+func testDeadLetterTrace(id uint64, service, child string, isError bool) *pb.APITrace {
+	root := &pb.Span{TraceID: id, SpanID: 1, Service: service}
+	if isError {
+		root.Error = 1
+	}
+	return &pb.APITrace{TraceID: id, Spans: []*pb.Span{root, {TraceID: id, SpanID: 2, ParentID: 1, Service: child}}}
+}
+
+func TestPriorityDeadLetterQueue(t *testing.T) {
+	assert := assert.New(t)
+	q := NewPriorityDeadLetterQueue(map[string]int{"billing": 10, "web": 5, "batch": -1}, 1000)
+	assert.Nil(q.Pop())
+
+	// 100 traces, added in a random order, one per second
+	services := []string{"billing", "web", "batch", "unknown"}
+	start := time.Unix(1550000000, 0)
+	added := make(map[uint64]time.Time)
+	for i, id := range rand.Perm(100) {
+		child := "cache"
+		if id%10 == 1 {
+			// the priority of all the services of the trace counts
+			child = "billing"
+		}
+		now := start.Add(time.Duration(i) * time.Second)
+		added[uint64(id)] = now
+		assert.True(q.Push(testDeadLetterTrace(uint64(id), services[id%len(services)], child, id%7 == 0), now))
+	}
+	assert.Equal(100, q.Len())
+
+	var previous *deadLetter
+	counts := make(map[int]int) // by priority
+	for i := 0; i < 100; i++ {
+		tr := q.Pop()
+		require.NotNil(t, tr)
+		letter := &deadLetter{trace: tr, priority: q.priority(tr), timestamp: added[tr.TraceID]}
+		delete(added, tr.TraceID)
+		if previous != nil {
+			assert.True(previous.before(letter), "trace %d is replayed after trace %d", tr.TraceID, previous.trace.TraceID)
+		}
+		previous = letter
+		counts[letter.priority]++
+	}
+	assert.Empty(added, "all traces are replayed once")
+	assert.Equal(0, q.Len())
+	assert.Nil(q.Pop())
+	// billing traces (25) and traces with a billing child (10), 6 of which
+	// have errors
+	assert.Equal(29, counts[10])
+	assert.Equal(6, counts[11])
+	assert.Equal(-1, previous.priority)
+}
+
+func TestPriorityDeadLetterQueueFull(t *testing.T) {
+	assert := assert.New(t)
+	q := NewPriorityDeadLetterQueue(map[string]int{"billing": 10}, 3)
+	now := time.Unix(1550000000, 0)
+	assert.True(q.Push(testDeadLetterTrace(1, "web", "cache", false), now))
+	assert.True(q.Push(testDeadLetterTrace(2, "web", "cache", false), now.Add(time.Second)))
+	assert.True(q.Push(testDeadLetterTrace(3, "billing", "cache", false), now.Add(2*time.Second)))
+
+	// the most recent of the least valuable traces is evicted
+	assert.True(q.Push(testDeadLetterTrace(4, "web", "cache", true), now.Add(3*time.Second)))
+	assert.False(q.Push(testDeadLetterTrace(5, "web", "cache", false), now.Add(4*time.Second)))
+	assert.Equal(3, q.Len())
+
+	var ids []uint64
+	for q.Len() > 0 {
+		ids = append(ids, q.Pop().TraceID)
+	}
+	assert.Equal([]uint64{3, 4, 1}, ids)
+}
+
+func TestTraceWriterDeadLetters(t *testing.T) {
+	assert := assert.New(t)
+	cfg := config.New()
+	cfg.Endpoints[0].APIKey = "123"
+	cfg.TraceWriter.DeadLetterReplayPriority = map[string]int{"billing": 10}
+	tw := NewTraceWriter(cfg, make(chan *SampledSpans))
+	stopSenders(tw.senders)
+	// a sender whose queue is full, and isn't drained
+	s := &sender{queue: make(chan *payload, 1)}
+	s.queue <- newPayload(nil)
+	tw.senders = []*sender{s}
+
+	tw.addSpans(&SampledSpans{Trace: pb.Trace{{TraceID: 1, SpanID: 1, Service: "web"}}})
+	tw.addSpans(&SampledSpans{Trace: pb.Trace{{TraceID: 2, SpanID: 1, Service: "billing"}}})
+	tw.flush()
+	assert.Equal(2, tw.deadLetters.Len(), "traces are kept while the sender is full")
+	assert.Empty(tw.traces)
+
+	<-s.queue
+	tw.addSpans(&SampledSpans{Trace: pb.Trace{{TraceID: 3, SpanID: 1, Service: "web"}}})
+	assert.False(tw.sendersFull())
+	tw.replayDeadLetters()
+	assert.Equal(0, tw.deadLetters.Len())
+	var ids []uint64
+	for _, tr := range tw.traces {
+		ids = append(ids, tr.TraceID)
+	}
+	assert.Equal([]uint64{3, 2, 1}, ids, "dead letters are replayed by priority after the buffered traces")
+}
@@ -214,6 +214,12 @@ func (s *sender) Push(p *payload) {
 	}
 }
 
+// full reports whether the queue of the sender is full, in which case pushing a
+// payload drops the oldest one.
+func (s *sender) full() bool {
+	return len(s.queue) == cap(s.queue)
+}
+
 // sendPayload sends the payload p to the destination URL.
 func (s *sender) sendPayload(p *payload) {
 	req, err := p.httpRequest(s.cfg.url)
@@ -0,0 +1,79 @@
+package writer
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/DataDog/datadog-agent/pkg/trace/traceutil"
+)
+
+const (
+	// tagSizeOptimized is set on the root span of traces which had spans removed
+	// by the TraceSizeOptimizer.
+	tagSizeOptimized = "_dd.size_optimized"
+	// tagSpansRemoved holds the number of spans removed by the TraceSizeOptimizer.
+	tagSpansRemoved = "_dd.spans_removed"
+)
+
+// TraceSizeOptimizer keeps traces within a size budget by removing their least
+// important spans, as scored by traceutil.SpanPriorityScore.
+type TraceSizeOptimizer struct {
+	maxBytes int64
+}
+
+// NewTraceSizeOptimizer returns a new TraceSizeOptimizer allowing traces of at most
+// maxBytes. A value of 0 or less disables the optimizer.
+func NewTraceSizeOptimizer(maxBytes int64) *TraceSizeOptimizer {
+	return &TraceSizeOptimizer{maxBytes: maxBytes}
+}
+
+// Optimize returns t, having removed its lowest-scored spans until the estimated
+// size of the trace fits the budget. The root span is never removed. As the
+// spans of t are still read by the concentrator, t is left untouched: the root
+// of the returned trace is tagged on a copy.
+func (o *TraceSizeOptimizer) Optimize(t pb.Trace) pb.Trace {
+	if o == nil || o.maxBytes <= 0 || len(t) == 0 {
+		return t
+	}
+	size := int64(t.Msgsize())
+	if size <= o.maxBytes {
+		return t
+	}
+	root := traceutil.GetRoot(t)
+
+	byScore := make(pb.Trace, len(t))
+	copy(byScore, t)
+	sort.SliceStable(byScore, func(i, j int) bool {
+		return traceutil.SpanPriorityScore(byScore[i]) < traceutil.SpanPriorityScore(byScore[j])
+	})
+
+	removed := make(map[*pb.Span]struct{})
+	for _, s := range byScore {
+		if size <= o.maxBytes {
+			break
+		}
+		if s == root {
+			continue
+		}
+		size -= int64(s.Msgsize())
+		removed[s] = struct{}{}
+	}
+	if len(removed) == 0 {
+		return t
+	}
+
+	kept := make(pb.Trace, 0, len(t)-len(removed))
+	for _, s := range t {
+		if _, ok := removed[s]; ok {
+			continue
+		}
+		if s == root {
+			s = withOwnMeta(root)
+			s.Meta[tagSizeOptimized] = "true"
+			s.Meta[tagSpansRemoved] = strconv.Itoa(len(removed))
+		}
+		kept = append(kept, s)
+	}
+	return kept
+}
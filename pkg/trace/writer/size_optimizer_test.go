@@ -0,0 +1,75 @@
+package writer
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+func sizeOptimizerTrace() pb.Trace {
+	return pb.Trace{
+		{TraceID: 1, SpanID: 1, ParentID: 0, Service: "web", Name: "http.request", Duration: 1e9},
+		{TraceID: 1, SpanID: 2, ParentID: 1, Service: "web", Name: "template.render", Duration: 1e6},
+		{TraceID: 1, SpanID: 3, ParentID: 1, Service: "db", Name: "sql.query", Duration: 5e8, Error: 1},
+		{TraceID: 1, SpanID: 4, ParentID: 1, Service: "web", Name: "cache.get", Duration: 1e3},
+		{TraceID: 1, SpanID: 5, ParentID: 1, Service: "web", Name: "cache.set", Duration: 1e4},
+	}
+}
+
+func TestTraceSizeOptimizer(t *testing.T) {
+	full := int64(sizeOptimizerTrace().Msgsize())
+
+	t.Run("disabled", func(t *testing.T) {
+		for _, max := range []int64{0, -1} {
+			trace := sizeOptimizerTrace()
+			out := NewTraceSizeOptimizer(max).Optimize(trace)
+			assert.Len(t, out, 5)
+			assert.NotContains(t, out[0].Meta, tagSizeOptimized)
+		}
+	})
+
+	t.Run("within-budget", func(t *testing.T) {
+		out := NewTraceSizeOptimizer(full).Optimize(sizeOptimizerTrace())
+		assert.Len(t, out, 5)
+		assert.NotContains(t, out[0].Meta, tagSizeOptimized)
+	})
+
+	t.Run("one-over", func(t *testing.T) {
+		trace := sizeOptimizerTrace()
+		out := NewTraceSizeOptimizer(full - 1).Optimize(trace)
+		assert.Len(t, out, 4)
+		for _, s := range out {
+			assert.NotEqual(t, uint64(4), s.SpanID, "shortest non-error span should go first")
+		}
+		assert.Equal(t, "true", out[0].Meta[tagSizeOptimized])
+		assert.Equal(t, "1", out[0].Meta[tagSpansRemoved])
+		// the spans are still read by the concentrator, the root is tagged on a copy
+		assert.Nil(t, trace[0].Meta)
+		assert.True(t, out[1] == trace[1])
+	})
+
+	t.Run("half", func(t *testing.T) {
+		out := NewTraceSizeOptimizer(full / 2).Optimize(sizeOptimizerTrace())
+		assert.Len(t, out, 2)
+		assert.Equal(t, "3", out[0].Meta[tagSpansRemoved])
+		ids := make(map[uint64]bool)
+		for _, s := range out {
+			ids[s.SpanID] = true
+		}
+		assert.True(t, ids[1], "root is kept")
+		assert.True(t, ids[3], "error span outlives the others")
+	})
+
+	t.Run("tiny", func(t *testing.T) {
+		out := NewTraceSizeOptimizer(1).Optimize(sizeOptimizerTrace())
+		assert.Len(t, out, 1)
+		assert.Equal(t, uint64(1), out[0].SpanID)
+		assert.Equal(t, "4", out[0].Meta[tagSpansRemoved])
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		var o *TraceSizeOptimizer
+		assert.Len(t, o.Optimize(sizeOptimizerTrace()), 5)
+	})
+}
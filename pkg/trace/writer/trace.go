@@ -58,6 +58,20 @@ type TraceWriter struct {
 	wg       sync.WaitGroup // waits for gzippers
 	tick     time.Duration  // flush frequency
 
+	// optimizer keeps traces within the configured size budget.
+	optimizer *TraceSizeOptimizer
+
+	// annotations, when set, holds the annotations merged into the roots of
+	// the written traces.
+	annotations *AnnotationStore
+
+	// deadLetters holds the traces which could not be written because the
+	// senders were full, to be replayed by priority. It is nil when disabled.
+	deadLetters *PriorityDeadLetterQueue
+
+	// binaryTags lists the tags whose hex values are re-encoded using base-85.
+	binaryTags []string
+
 	traces       []*pb.APITrace // traces buffered
 	events       []*pb.Span     // events buffered
 	bufferedSize int            // estimated buffer size
@@ -67,12 +81,17 @@ type TraceWriter struct {
 // will accept incoming spans via the in channel.
 func NewTraceWriter(cfg *config.AgentConfig, in <-chan *SampledSpans) *TraceWriter {
 	tw := &TraceWriter{
-		in:       in,
-		hostname: cfg.Hostname,
-		env:      cfg.DefaultEnv,
-		stats:    &info.TraceWriterInfo{},
-		stop:     make(chan struct{}),
-		tick:     5 * time.Second,
+		in:         in,
+		hostname:   cfg.Hostname,
+		env:        cfg.DefaultEnv,
+		stats:      &info.TraceWriterInfo{},
+		stop:       make(chan struct{}),
+		tick:       5 * time.Second,
+		optimizer:  NewTraceSizeOptimizer(cfg.TraceWriter.MaxBytesPerTrace),
+		binaryTags: cfg.TraceWriter.Base85EncodeBinaryTags,
+	}
+	if p := cfg.TraceWriter.DeadLetterReplayPriority; len(p) > 0 {
+		tw.deadLetters = NewPriorityDeadLetterQueue(p, maxDeadLetters)
 	}
 	climit := cfg.TraceWriter.ConnectionLimit
 	if climit == 0 {
@@ -92,6 +111,12 @@ func NewTraceWriter(cfg *config.AgentConfig, in <-chan *SampledSpans) *TraceWrit
 	return tw
 }
 
+// UseAnnotations makes the writer merge the annotations of the given store into
+// the roots of the traces it writes. It must be called before Run.
+func (w *TraceWriter) UseAnnotations(store *AnnotationStore) {
+	w.annotations = store
+}
+
 // Stop stops the TraceWriter and attempts to flush whatever is left in the senders buffers.
 func (w *TraceWriter) Stop() {
 	log.Debug("Exiting trace writer. Trying to flush whatever is left...")
@@ -134,6 +159,14 @@ func (w *TraceWriter) addSpans(pkg *SampledSpans) {
 	if pkg.Empty() {
 		return
 	}
+	if w.annotations != nil {
+		pkg.Trace = w.annotations.Merge(pkg.Trace, time.Now())
+	}
+	pkg.Trace = w.optimizer.Optimize(pkg.Trace)
+	if len(w.binaryTags) > 0 {
+		pkg.Trace = w.compressBinaryTags(pkg.Trace)
+		pkg.Events = w.compressBinaryTags(pkg.Events)
+	}
 
 	atomic.AddInt64(&w.stats.Spans, int64(len(pkg.Trace)))
 	atomic.AddInt64(&w.stats.Traces, 1)
@@ -151,6 +184,49 @@ func (w *TraceWriter) addSpans(pkg *SampledSpans) {
 	w.bufferedSize += size
 }
 
+// compressBinaryTags returns spans, having the binary tags re-encoded using
+// base-85 on copies of the spans holding them: the written spans are still
+// read by the concentrator, and must not be modified.
+func (w *TraceWriter) compressBinaryTags(spans []*pb.Span) []*pb.Span {
+	var out []*pb.Span
+	for i, span := range spans {
+		if !hasAnyMeta(span, w.binaryTags) {
+			continue
+		}
+		if out == nil {
+			out = make([]*pb.Span, len(spans))
+			copy(out, spans)
+		}
+		out[i] = withOwnMeta(span)
+		traceutil.CompressBinaryTags(out[i], w.binaryTags)
+	}
+	if out == nil {
+		return spans
+	}
+	return out
+}
+
+// hasAnyMeta reports whether span has a value for any of the given keys.
+func hasAnyMeta(span *pb.Span, keys []string) bool {
+	for _, k := range keys {
+		if span.Meta[k] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// withOwnMeta returns a copy of span holding a copy of its meta, which can be
+// modified while span is read concurrently.
+func withOwnMeta(span *pb.Span) *pb.Span {
+	cp := *span
+	cp.Meta = make(map[string]string, len(span.Meta))
+	for k, v := range span.Meta {
+		cp.Meta[k] = v
+	}
+	return &cp
+}
+
 func (w *TraceWriter) resetBuffer() {
 	w.bufferedSize = 0
 	w.traces = w.traces[:0]
@@ -160,6 +236,13 @@ func (w *TraceWriter) resetBuffer() {
 const headerLanguages = "X-Datadog-Reported-Languages"
 
 func (w *TraceWriter) flush() {
+	if w.deadLetters != nil {
+		if w.sendersFull() {
+			w.parkTraces()
+			return
+		}
+		w.replayDeadLetters()
+	}
 	if len(w.traces) == 0 && len(w.events) == 0 {
 		// nothing to do
 		return
@@ -209,6 +292,54 @@ func (w *TraceWriter) flush() {
 	}()
 }
 
+// sendersFull reports whether any of the senders has a full queue.
+func (w *TraceWriter) sendersFull() bool {
+	for _, s := range w.senders {
+		if s.full() {
+			return true
+		}
+	}
+	return false
+}
+
+// parkTraces moves the buffered traces to the dead-letter queue. The buffered
+// events, which can't be replayed, are dropped and counted.
+func (w *TraceWriter) parkTraces() {
+	defer w.resetBuffer()
+	if len(w.events) > 0 {
+		log.Debugf("Senders are full, %d APM events dropped.", len(w.events))
+		metrics.Count("datadog.trace_agent.trace_writer.events_dropped", int64(len(w.events)), nil, 1)
+	}
+	if len(w.traces) == 0 {
+		return
+	}
+	now := time.Now()
+	var dropped int64
+	for _, t := range w.traces {
+		if !w.deadLetters.Push(t, now) {
+			dropped++
+		}
+	}
+	log.Debugf("Senders are full, %d traces moved to the dead-letter queue (%d dropped).", len(w.traces), dropped)
+	metrics.Count("datadog.trace_agent.trace_writer.dead_letters", int64(len(w.traces)), nil, 1)
+	metrics.Count("datadog.trace_agent.trace_writer.dead_letters_dropped", dropped, nil, 1)
+}
+
+// replayDeadLetters adds the highest-priority traces of the dead-letter queue
+// to the buffer, up to the maximum payload size.
+func (w *TraceWriter) replayDeadLetters() {
+	var replayed int64
+	for w.deadLetters.Len() > 0 && w.bufferedSize < maxPayloadSize {
+		t := w.deadLetters.Pop()
+		w.traces = append(w.traces, t)
+		w.bufferedSize += pb.Trace(t.Spans).Msgsize()
+		replayed++
+	}
+	if replayed > 0 {
+		metrics.Count("datadog.trace_agent.trace_writer.dead_letters_replayed", replayed, nil, 1)
+	}
+}
+
 func (w *TraceWriter) report() {
 	metrics.Count("datadog.trace_agent.trace_writer.payloads", atomic.SwapInt64(&w.stats.Payloads, 0), nil, 1)
 	metrics.Count("datadog.trace_agent.trace_writer.bytes_uncompressed", atomic.SwapInt64(&w.stats.BytesUncompressed, 0), nil, 1)
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/metrics"
 	"github.com/DataDog/datadog-agent/pkg/trace/pb"
 	"github.com/DataDog/datadog-agent/pkg/trace/test/testutil"
 	"github.com/gogo/protobuf/proto"
@@ -48,6 +49,47 @@ func TestTraceWriter(t *testing.T) {
 	})
 }
 
+func TestTraceWriterBinaryTags(t *testing.T) {
+	cfg := config.New()
+	cfg.Endpoints[0].APIKey = "123"
+	cfg.TraceWriter.Base85EncodeBinaryTags = []string{"tls.certificate"}
+	tw := NewTraceWriter(cfg, make(chan *SampledSpans))
+	root := &pb.Span{TraceID: 1, SpanID: 1, Meta: map[string]string{"tls.certificate": "deadbeef", "http.method": "GET"}}
+	tw.addSpans(&SampledSpans{Trace: pb.Trace{root}, Events: []*pb.Span{root}})
+	if assert.Len(t, tw.traces, 1) {
+		assert.Equal(t, map[string]string{"tls.certificate": `<~hQ=N\~>`, "http.method": "GET"}, tw.traces[0].Spans[0].Meta)
+	}
+	if assert.Len(t, tw.events, 1) {
+		assert.Equal(t, `<~hQ=N\~>`, tw.events[0].Meta["tls.certificate"])
+	}
+	// the spans are still read by the concentrator, they are encoded on copies
+	assert.Equal(t, "deadbeef", root.Meta["tls.certificate"])
+	stopSenders(tw.senders)
+}
+
+func TestTraceWriterParkTraces(t *testing.T) {
+	stats := &testutil.TestStatsClient{}
+	defer func(old metrics.StatsClient) { metrics.Client = old }(metrics.Client)
+	metrics.Client = stats
+
+	cfg := config.New()
+	cfg.Endpoints[0].APIKey = "123"
+	cfg.TraceWriter.DeadLetterReplayPriority = map[string]int{"web": 1}
+	tw := NewTraceWriter(cfg, make(chan *SampledSpans))
+	tw.addSpans(randomSampledSpans(5, 2))
+	tw.addSpans(&SampledSpans{Events: testutil.GetTestTraces(1, 3, true)[0]})
+	tw.parkTraces()
+
+	assert.Equal(t, 1, tw.deadLetters.Len())
+	assert.Empty(t, tw.traces)
+	assert.Empty(t, tw.events)
+	counts := stats.GetCountSummaries()
+	if assert.Contains(t, counts, "datadog.trace_agent.trace_writer.events_dropped") {
+		assert.EqualValues(t, 5, counts["datadog.trace_agent.trace_writer.events_dropped"].Sum, "the events which can't be parked are counted")
+	}
+	stopSenders(tw.senders)
+}
+
 // useFlushThreshold sets n as the number of bytes to be used as the flush threshold
 // and returns a function to restore it.
 func useFlushThreshold(n int) func() {
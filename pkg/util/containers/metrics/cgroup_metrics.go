@@ -432,3 +432,27 @@ func (c ContainerCgroup) ParseSingleStat(target, file string) (uint64, error) {
 	}
 	return value, nil
 }
+
+// ParseDeviceStat reads and converts a per-device cgroup stat file content, made
+// of `major:minor value` lines, to a map of uint64 keyed by device ID. Other
+// lines, such as the `Total` one, are ignored.
+func (c ContainerCgroup) ParseDeviceStat(target, file string) (map[string]uint64, error) {
+	statFile := c.cgroupFilePath(target, file)
+	lines, err := readLines(statFile)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]uint64, len(lines))
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !strings.Contains(fields[0], ":") {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		values[fields[0]] = value
+	}
+	return values, nil
+}
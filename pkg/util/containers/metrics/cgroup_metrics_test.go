@@ -224,3 +224,26 @@ func TestThreadCount(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, value, uint64(123))
 }
+
+func TestParseDeviceStat(t *testing.T) {
+	tempFolder, err := newTempFolder("device-stat")
+	assert.Nil(t, err)
+	defer tempFolder.removeAll()
+
+	cgroup := newDummyContainerCgroup(tempFolder.RootPath, "blkio")
+
+	// No file
+	_, err = cgroup.ParseDeviceStat("blkio", "blkio.time_recursive")
+	assert.True(t, os.IsNotExist(err))
+
+	// Invalid file
+	tempFolder.add("blkio/blkio.time_recursive", "8:0 ab")
+	_, err = cgroup.ParseDeviceStat("blkio", "blkio.time_recursive")
+	assert.IsType(t, err, &strconv.NumError{})
+
+	// Valid file
+	tempFolder.add("blkio/blkio.time_recursive", "8:0 1200\n8:16 34\nTotal 1234")
+	values, err := cgroup.ParseDeviceStat("blkio", "blkio.time_recursive")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]uint64{"8:0": 1200, "8:16": 34}, values)
+}
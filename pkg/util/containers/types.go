@@ -51,6 +51,12 @@ type Container struct {
 	Health   string
 	Pids     []int32
 	Excluded bool
+	// AdmissionRejected is true when the container was rejected by the
+	// configured admission webhook.
+	AdmissionRejected bool
+	// UnreachablePorts lists the addresses of AddressList which are not
+	// listening, when port verification is enabled.
+	UnreachablePorts []NetworkAddress
 
 	CPULimit       float64
 	SoftMemLimit   uint64
@@ -66,6 +72,10 @@ type Container struct {
 	StartedAt      int64
 	ThreadCount    uint64
 	ThreadLimit    uint64
+	// MinorPageFaultsPerSec and MajorPageFaultsPerSec are the rates of page
+	// faults of the processes of the container since the previous collection.
+	MinorPageFaultsPerSec float64
+	MajorPageFaultsPerSec float64
 
 	// For internal use only
 	cgroup *metrics.ContainerCgroup
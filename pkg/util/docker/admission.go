@@ -0,0 +1,186 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// AdmissionResult is the decision of an admission webhook.
+type AdmissionResult struct {
+	Allowed bool
+	Reason  string
+}
+
+// admissionReview is the subset of the admission.k8s.io/v1beta1 AdmissionReview
+// object exchanged with admission webhooks.
+type admissionReview struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Request    *admissionRequest  `json:"request,omitempty"`
+	Response   *admissionResponse `json:"response,omitempty"`
+}
+
+type admissionRequest struct {
+	UID       string           `json:"uid"`
+	Kind      groupVersionKind `json:"kind"`
+	Resource  groupVersionKind `json:"resource"`
+	Name      string           `json:"name"`
+	Namespace string           `json:"namespace,omitempty"`
+	Operation string           `json:"operation"`
+	Object    admissionPod     `json:"object"`
+}
+
+type groupVersionKind struct {
+	Group    string `json:"group"`
+	Version  string `json:"version"`
+	Kind     string `json:"kind,omitempty"`
+	Resource string `json:"resource,omitempty"`
+}
+
+type admissionResponse struct {
+	UID     string `json:"uid"`
+	Allowed bool   `json:"allowed"`
+	Status  *struct {
+		Message string `json:"message"`
+	} `json:"status,omitempty"`
+}
+
+// admissionPod is the pod submitted for review, describing the container.
+type admissionPod struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name      string            `json:"name"`
+		Namespace string            `json:"namespace,omitempty"`
+		Labels    map[string]string `json:"labels,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		Containers []admissionContainer `json:"containers"`
+	} `json:"spec"`
+}
+
+type admissionContainer struct {
+	Name            string   `json:"name"`
+	Image           string   `json:"image"`
+	Env             []envVar `json:"env,omitempty"`
+	SecurityContext struct {
+		Privileged bool `json:"privileged"`
+	} `json:"securityContext"`
+}
+
+// envVar is an environment variable of the container. Only its name is sent:
+// values often hold secrets.
+type envVar struct {
+	Name string `json:"name"`
+}
+
+// RunAdmissionWebhookCheck submits containerSpec, as a pod creation, to the
+// Kubernetes admission webhook found at webhookURL and returns its decision.
+func (d *DockerUtil) RunAdmissionWebhookCheck(ctx context.Context, containerSpec types.ContainerJSON, webhookURL string) (*AdmissionResult, error) {
+	if containerSpec.ContainerJSONBase == nil || containerSpec.Config == nil {
+		return nil, errors.New("invalid container spec")
+	}
+	body, err := json.Marshal(newAdmissionReview(containerSpec))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{Timeout: d.queryTimeout}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error calling admission webhook: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected admission webhook response: %s", resp.Status)
+	}
+	var review admissionReview
+	if err := json.NewDecoder(resp.Body).Decode(&review); err != nil {
+		return nil, fmt.Errorf("error decoding admission review: %s", err)
+	}
+	if review.Response == nil {
+		return nil, errors.New("admission review has no response")
+	}
+	result := &AdmissionResult{Allowed: review.Response.Allowed}
+	if review.Response.Status != nil {
+		result.Reason = review.Response.Status.Message
+	}
+	return result, nil
+}
+
+// isRejectedByAdmissionWebhook reports whether the container identified by id is
+// rejected by the configured admission webhook. Errors are logged and count as
+// not rejected. It is run in the background, through admissionChecks.
+func (d *DockerUtil) isRejectedByAdmissionWebhook(id string) bool {
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		log.Debugf("Error inspecting container %s: %s", id, err)
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d.queryTimeout)
+	defer cancel()
+	result, err := d.RunAdmissionWebhookCheck(ctx, c, d.cfg.AdmissionWebhookURL)
+	if err != nil {
+		log.Debugf("Cannot run admission check for container %s: %s", id, err)
+		return false
+	}
+	rejected := 0.0
+	if !result.Allowed {
+		rejected = 1
+		log.Warnf("Container %s is rejected by the admission webhook: %s", id, result.Reason)
+	}
+	gauge("datadog.docker.container.admission_rejected", rejected, containerTags(id, c.Name))
+	return !result.Allowed
+}
+
+// newAdmissionReview returns the review of the creation of a pod running c.
+func newAdmissionReview(c types.ContainerJSON) admissionReview {
+	name := strings.TrimPrefix(c.Name, "/")
+	pod := admissionPod{APIVersion: "v1", Kind: "Pod"}
+	pod.Metadata.Name = name
+	pod.Metadata.Namespace = c.Config.Labels[podNamespaceLabel]
+	pod.Metadata.Labels = c.Config.Labels
+
+	container := admissionContainer{Name: name, Image: c.Config.Image}
+	for _, e := range c.Config.Env {
+		container.Env = append(container.Env, envVar{Name: strings.SplitN(e, "=", 2)[0]})
+	}
+	if c.HostConfig != nil {
+		container.SecurityContext.Privileged = c.HostConfig.Privileged
+	}
+	pod.Spec.Containers = []admissionContainer{container}
+
+	return admissionReview{
+		APIVersion: "admission.k8s.io/v1beta1",
+		Kind:       "AdmissionReview",
+		Request: &admissionRequest{
+			UID:       c.ID,
+			Kind:      groupVersionKind{Version: "v1", Kind: "Pod"},
+			Resource:  groupVersionKind{Version: "v1", Resource: "pods"},
+			Name:      name,
+			Namespace: pod.Metadata.Namespace,
+			Operation: "CREATE",
+			Object:    pod,
+		},
+	}
+}
@@ -0,0 +1,108 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newMockAdmissionWebhook returns a webhook rejecting privileged pods.
+func newMockAdmissionWebhook(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var review admissionReview
+		if !assert.NoError(t, json.NewDecoder(r.Body).Decode(&review)) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		req := review.Request
+		assert.Equal(t, "AdmissionReview", review.Kind)
+		assert.Equal(t, "CREATE", req.Operation)
+		assert.Equal(t, "Pod", req.Kind.Kind)
+		if req.Name == "broken" {
+			w.Write([]byte(`{"kind": "AdmissionReview"}`))
+			return
+		}
+		resp := &admissionResponse{UID: req.UID, Allowed: true}
+		if req.Object.Spec.Containers[0].SecurityContext.Privileged {
+			resp.Allowed = false
+			resp.Status = &struct {
+				Message string `json:"message"`
+			}{Message: "privileged containers are not allowed in " + req.Namespace}
+		}
+		json.NewEncoder(w).Encode(admissionReview{APIVersion: review.APIVersion, Kind: review.Kind, Response: resp})
+	}))
+}
+
+func newTestContainerJSON(name string, privileged bool) types.ContainerJSON {
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:         "abc",
+			Name:       "/" + name,
+			HostConfig: &container.HostConfig{Privileged: privileged},
+		},
+		Config: &container.Config{
+			Image:  "nginx:latest",
+			Labels: map[string]string{podNamespaceLabel: "prod"},
+			Env:    []string{"FOO=bar", "EMPTY"},
+		},
+	}
+}
+
+func TestRunAdmissionWebhookCheck(t *testing.T) {
+	srv := newMockAdmissionWebhook(t)
+	defer srv.Close()
+	d := &DockerUtil{cfg: &Config{}, queryTimeout: time.Second}
+	ctx := context.Background()
+
+	result, err := d.RunAdmissionWebhookCheck(ctx, newTestContainerJSON("web", false), srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, &AdmissionResult{Allowed: true}, result)
+
+	result, err = d.RunAdmissionWebhookCheck(ctx, newTestContainerJSON("web", true), srv.URL)
+	require.NoError(t, err)
+	assert.Equal(t, &AdmissionResult{Allowed: false, Reason: "privileged containers are not allowed in prod"}, result)
+
+	_, err = d.RunAdmissionWebhookCheck(ctx, newTestContainerJSON("broken", false), srv.URL)
+	assert.Error(t, err, "reviews without a response are invalid")
+
+	_, err = d.RunAdmissionWebhookCheck(ctx, types.ContainerJSON{}, srv.URL)
+	assert.Error(t, err)
+
+	_, err = d.RunAdmissionWebhookCheck(ctx, newTestContainerJSON("web", false), "http://127.0.0.1:0")
+	assert.Error(t, err)
+}
+
+func TestNewAdmissionReview(t *testing.T) {
+	review := newAdmissionReview(newTestContainerJSON("web", true))
+	req := review.Request
+	assert.Equal(t, "admission.k8s.io/v1beta1", review.APIVersion)
+	assert.Equal(t, "abc", req.UID)
+	assert.Equal(t, "web", req.Name)
+	assert.Equal(t, "prod", req.Namespace)
+
+	c := req.Object.Spec.Containers
+	require.Len(t, c, 1)
+	assert.Equal(t, "nginx:latest", c[0].Image)
+	assert.Equal(t, []envVar{{Name: "FOO"}, {Name: "EMPTY"}}, c[0].Env)
+	body, err := json.Marshal(review)
+	require.NoError(t, err)
+	assert.NotContains(t, string(body), "bar")
+	assert.True(t, c[0].SecurityContext.Privileged)
+}
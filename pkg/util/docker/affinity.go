@@ -0,0 +1,72 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+)
+
+// composeServiceLabel is the label set by Docker Compose with the name of the
+// service of a container.
+const composeServiceLabel = "com.docker.compose.service"
+
+// ServicePair is a pair of Compose services which must not run on the same host.
+type ServicePair struct {
+	ServiceA string
+	ServiceB string
+}
+
+// AntiAffinityViolation describes a pair of services running on the same host
+// despite an anti-affinity rule, with the IDs of their containers.
+type AntiAffinityViolation struct {
+	ServicePair
+	ContainersA []string
+	ContainersB []string
+}
+
+// ValidateAntiAffinity returns a violation for every pair of antiAffinityPairs
+// whose services both have running containers on this host. Each violation is
+// reported with the datadog.docker.container.antiaffinity_violation gauge.
+func (d *DockerUtil) ValidateAntiAffinity(ctx context.Context, antiAffinityPairs []ServicePair) ([]AntiAffinityViolation, error) {
+	cList, err := d.cli.ContainerList(ctx, types.ContainerListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers: %s", err)
+	}
+	return antiAffinityViolations(cList, antiAffinityPairs), nil
+}
+
+// antiAffinityViolations returns the anti-affinity violations of the given containers.
+func antiAffinityViolations(cList []types.Container, pairs []ServicePair) []AntiAffinityViolation {
+	byService := make(map[string][]string)
+	for _, c := range cList {
+		if service, ok := c.Labels[composeServiceLabel]; ok {
+			byService[service] = append(byService[service], c.ID)
+		}
+	}
+	var violations []AntiAffinityViolation
+	for _, p := range pairs {
+		a, b := byService[p.ServiceA], byService[p.ServiceB]
+		if len(a) == 0 || len(b) == 0 {
+			continue
+		}
+		if p.ServiceA == p.ServiceB && len(a) < 2 {
+			// a service anti-affine to itself needs two replicas to collide
+			continue
+		}
+		violations = append(violations, AntiAffinityViolation{
+			ServicePair: p,
+			ContainersA: a,
+			ContainersB: b,
+		})
+		gauge("datadog.docker.container.antiaffinity_violation", 1, []string{"service_a:" + p.ServiceA, "service_b:" + p.ServiceB})
+	}
+	return violations
+}
@@ -0,0 +1,64 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAntiAffinityViolations(t *testing.T) {
+	cList := []types.Container{
+		{ID: "db1", Labels: map[string]string{composeServiceLabel: "db"}},
+		{ID: "db2", Labels: map[string]string{composeServiceLabel: "db"}},
+		{ID: "cache1", Labels: map[string]string{composeServiceLabel: "cache"}},
+		{ID: "web1", Labels: map[string]string{composeServiceLabel: "web"}},
+		{ID: "standalone", Labels: map[string]string{"maintainer": "me"}},
+	}
+
+	for name, tc := range map[string]struct {
+		pairs      []ServicePair
+		violations []AntiAffinityViolation
+	}{
+		"none": {
+			pairs: []ServicePair{{"db", "queue"}, {"search", "web"}, {"web", "web"}},
+		},
+		"single": {
+			pairs: []ServicePair{{"db", "cache"}, {"db", "queue"}},
+			violations: []AntiAffinityViolation{
+				{ServicePair: ServicePair{"db", "cache"}, ContainersA: []string{"db1", "db2"}, ContainersB: []string{"cache1"}},
+			},
+		},
+		"multiple": {
+			pairs: []ServicePair{{"db", "cache"}, {"web", "cache"}, {"db", "db"}},
+			violations: []AntiAffinityViolation{
+				{ServicePair: ServicePair{"db", "cache"}, ContainersA: []string{"db1", "db2"}, ContainersB: []string{"cache1"}},
+				{ServicePair: ServicePair{"web", "cache"}, ContainersA: []string{"web1"}, ContainersB: []string{"cache1"}},
+				{ServicePair: ServicePair{"db", "db"}, ContainersA: []string{"db1", "db2"}, ContainersB: []string{"db1", "db2"}},
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			withTestStatsClient(func(c *testStatsClient) {
+				violations := antiAffinityViolations(cList, tc.pairs)
+				assert.Equal(t, tc.violations, violations)
+				if assert.Len(t, c.gauges, len(tc.violations)) {
+					for i, v := range tc.violations {
+						assert.Equal(t, testStatsSample{
+							Name:  "datadog.docker.container.antiaffinity_violation",
+							Value: 1,
+							Tags:  []string{"service_a:" + v.ServiceA, "service_b:" + v.ServiceB},
+						}, c.gauges[i])
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,128 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+var (
+	// appArmorParserPath is the apparmor_parser binary. It is a variable to be
+	// replaced in tests.
+	appArmorParserPath = "apparmor_parser"
+	// appArmorProfilesPath lists the AppArmor profiles loaded in the kernel.
+	appArmorProfilesPath = "/sys/kernel/security/apparmor/profiles"
+
+	// appArmorProfileRe matches the declarations of the profiles of a policy,
+	// such as profile docker-nginx flags=(attach_disconnected) { or /usr/bin/foo {.
+	appArmorProfileRe = regexp.MustCompile(`^\s*(?:profile\s+"?([^\s"{]+)"?|"?(/[^\s"{]+)"?)[^{]*\{\s*$`)
+)
+
+// ReloadAppArmorPolicy replaces the AppArmor profile of the container identified
+// by id with the policy at newProfilePath using apparmor_parser --replace, so
+// that it applies to the running container without restarting it. The policy
+// must declare the profile of the container, and the reload is verified by
+// checking that the profile is loaded in the kernel afterwards. Each reload
+// attempt is logged for audit. It requires docker_allow_policy_reload.
+func (d *DockerUtil) ReloadAppArmorPolicy(ctx context.Context, id string, newProfilePath string) error {
+	if !d.cfg.AllowPolicyReload {
+		return errors.New("policy reload is disabled, set docker_allow_policy_reload to enable it")
+	}
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return err
+	}
+	err = reloadAppArmorPolicy(ctx, c.AppArmorProfile, newProfilePath)
+	auditAppArmorReload(c.ID, c.AppArmorProfile, newProfilePath, err)
+	return err
+}
+
+func reloadAppArmorPolicy(ctx context.Context, profile, path string) error {
+	if profile == "" || profile == "unconfined" {
+		return errors.New("container is not confined by an AppArmor profile")
+	}
+	policy, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !declaresAppArmorProfile(policy, profile) {
+		return fmt.Errorf("policy %s does not declare profile %s", path, profile)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, appArmorParserPath, "--replace", path)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("apparmor_parser --replace %s: %s: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+
+	loaded, err := appArmorProfileLoaded(appArmorProfilesPath, profile)
+	if err != nil {
+		return fmt.Errorf("could not verify the reload: %s", err)
+	}
+	if !loaded {
+		return fmt.Errorf("profile %s is not loaded after the reload", profile)
+	}
+	return nil
+}
+
+// declaresAppArmorProfile reports whether the given policy declares profile.
+func declaresAppArmorProfile(policy []byte, profile string) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(policy))
+	for scanner.Scan() {
+		m := appArmorProfileRe.FindStringSubmatch(scanner.Text())
+		if m != nil && (m[1] == profile || m[2] == profile) {
+			return true
+		}
+	}
+	return false
+}
+
+// appArmorProfileLoaded reports whether profile is listed in the given list of
+// loaded profiles, where each line is such as docker-default (enforce).
+func appArmorProfileLoaded(path, profile string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), profile+" (") {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// auditAppArmorReload logs the outcome of the reload of the AppArmor profile
+// of a container, along with the checksum of the policy loaded.
+func auditAppArmorReload(id, profile, path string, err error) {
+	checksum := "unknown"
+	if policy, readErr := ioutil.ReadFile(path); readErr == nil {
+		checksum = fmt.Sprintf("%x", sha256.Sum256(policy))
+	}
+	if err != nil {
+		log.Warnf("Audit: AppArmor policy reload failed: container_id=%s profile=%s policy=%s sha256=%s error=%q", id, profile, path, checksum, err)
+		return
+	}
+	log.Infof("Audit: AppArmor policy reloaded: container_id=%s profile=%s policy=%s sha256=%s", id, profile, path, checksum)
+}
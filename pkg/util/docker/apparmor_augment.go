@@ -0,0 +1,244 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// appArmorDenyRulesBegin and appArmorDenyRulesEnd delimit the deny rules
+	// added to AppArmor profiles, so that they can be removed.
+	appArmorDenyRulesBegin = "# BEGIN datadog-agent deny rules"
+	appArmorDenyRulesEnd   = "# END datadog-agent deny rules"
+)
+
+// appArmorPolicyDir is the directory of the AppArmor policies. It is a variable
+// to be replaced in tests.
+var appArmorPolicyDir = "/etc/apparmor.d"
+
+// AugmentAppArmorProfile adds additionalDenyRules, such as
+// "deny /proc/sys/kernel/** w", to the AppArmor profile of the container
+// identified by id, and reloads it with apparmor_parser --replace. The policy of
+// the profile is read from /etc/apparmor.d, and the rules are added at the end
+// of the profile, between markers allowing UndoAppArmorProfileAugmentation to
+// remove them. Rules already added are kept. The policy is restored when it
+// can't be reloaded. Each attempt is logged for audit. It requires
+// docker_allow_profile_augmentation.
+func (d *DockerUtil) AugmentAppArmorProfile(ctx context.Context, id string, additionalDenyRules []string) error {
+	if !d.cfg.AllowProfileAugmentation {
+		return errors.New("profile augmentation is disabled, set docker_allow_profile_augmentation to enable it")
+	}
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return err
+	}
+	path, err := augmentAppArmorProfile(ctx, c.AppArmorProfile, appArmorPolicyDir, additionalDenyRules)
+	auditAppArmorReload(c.ID, c.AppArmorProfile, path, err)
+	return err
+}
+
+// UndoAppArmorProfileAugmentation removes the deny rules added by
+// AugmentAppArmorProfile to the AppArmor profile of the container identified by
+// id, and reloads it. It requires docker_allow_profile_augmentation.
+func (d *DockerUtil) UndoAppArmorProfileAugmentation(ctx context.Context, id string) error {
+	if !d.cfg.AllowProfileAugmentation {
+		return errors.New("profile augmentation is disabled, set docker_allow_profile_augmentation to enable it")
+	}
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return err
+	}
+	path, err := undoAppArmorProfileAugmentation(ctx, c.AppArmorProfile, appArmorPolicyDir)
+	auditAppArmorReload(c.ID, c.AppArmorProfile, path, err)
+	return err
+}
+
+func augmentAppArmorProfile(ctx context.Context, profile, dir string, rules []string) (string, error) {
+	if profile == "" || profile == "unconfined" {
+		return "", errors.New("container is not confined by an AppArmor profile")
+	}
+	if len(rules) == 0 {
+		return "", errors.New("no deny rules to add")
+	}
+	denyRules := make([]string, len(rules))
+	for i, rule := range rules {
+		rule = strings.TrimSuffix(strings.TrimSpace(rule), ",")
+		if !strings.HasPrefix(rule, "deny ") || strings.ContainsAny(rule, "{}\n#") {
+			return "", fmt.Errorf("invalid deny rule %q", rules[i])
+		}
+		denyRules[i] = rule + ","
+	}
+	path, err := appArmorPolicyPath(dir, profile)
+	if err != nil {
+		return "", err
+	}
+	policy, err := ioutil.ReadFile(path)
+	if err != nil {
+		return path, err
+	}
+	stripped, added := withoutAppArmorDenyRules(policy)
+	augmented, err := withAppArmorDenyRules(stripped, profile, mergeRules(added, denyRules))
+	if err != nil {
+		return path, err
+	}
+	return path, rewriteAppArmorPolicy(ctx, profile, path, policy, augmented)
+}
+
+func undoAppArmorProfileAugmentation(ctx context.Context, profile, dir string) (string, error) {
+	if profile == "" || profile == "unconfined" {
+		return "", errors.New("container is not confined by an AppArmor profile")
+	}
+	path, err := appArmorPolicyPath(dir, profile)
+	if err != nil {
+		return "", err
+	}
+	policy, err := ioutil.ReadFile(path)
+	if err != nil {
+		return path, err
+	}
+	stripped, added := withoutAppArmorDenyRules(policy)
+	if len(added) == 0 {
+		return path, fmt.Errorf("profile %s has no deny rules added", profile)
+	}
+	return path, rewriteAppArmorPolicy(ctx, profile, path, policy, stripped)
+}
+
+// appArmorPolicyPath returns the path of the policy declaring profile in dir:
+// the file named after the profile, or else the first file declaring it.
+func appArmorPolicyPath(dir, profile string) (string, error) {
+	path := filepath.Join(dir, filepath.Base(profile))
+	if policy, err := ioutil.ReadFile(path); err == nil && declaresAppArmorProfile(policy, profile) {
+		return path, nil
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range files {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		if policy, err := ioutil.ReadFile(path); err == nil && declaresAppArmorProfile(policy, profile) {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no policy declaring profile %s found in %s", profile, dir)
+}
+
+// rewriteAppArmorPolicy writes policy to path and reloads profile, restoring
+// the previous policy when the reload fails.
+func rewriteAppArmorPolicy(ctx context.Context, profile, path string, previous, policy []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, policy, info.Mode().Perm()); err != nil {
+		return err
+	}
+	err = reloadAppArmorPolicy(ctx, profile, path)
+	if err == nil {
+		return nil
+	}
+	if restoreErr := ioutil.WriteFile(path, previous, info.Mode().Perm()); restoreErr != nil {
+		return fmt.Errorf("%s, and the policy could not be restored: %s", err, restoreErr)
+	}
+	return err
+}
+
+// withAppArmorDenyRules returns policy with the given rules added at the end
+// of the block of profile.
+func withAppArmorDenyRules(policy []byte, profile string, rules []string) ([]byte, error) {
+	lines := strings.SplitAfter(string(policy), "\n")
+	start := -1
+	for i, line := range lines {
+		m := appArmorProfileRe.FindStringSubmatch(strings.TrimSuffix(line, "\n"))
+		if m != nil && (m[1] == profile || m[2] == profile) {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return nil, fmt.Errorf("policy does not declare profile %s", profile)
+	}
+	// the block ends with the brace closing the one of the declaration
+	end := -1
+	depth := 0
+	for i := start; i < len(lines) && end < 0; i++ {
+		code := lines[i]
+		if j := strings.IndexByte(code, '#'); j >= 0 {
+			code = code[:j]
+		}
+		if depth += strings.Count(code, "{") - strings.Count(code, "}"); depth == 0 {
+			end = i
+		}
+	}
+	if end < 0 {
+		return nil, fmt.Errorf("could not find the end of profile %s", profile)
+	}
+
+	var buf bytes.Buffer
+	for _, line := range lines[:end] {
+		buf.WriteString(line)
+	}
+	fmt.Fprintf(&buf, "  %s\n", appArmorDenyRulesBegin)
+	for _, rule := range rules {
+		fmt.Fprintf(&buf, "  %s\n", rule)
+	}
+	fmt.Fprintf(&buf, "  %s\n", appArmorDenyRulesEnd)
+	for _, line := range lines[end:] {
+		buf.WriteString(line)
+	}
+	return buf.Bytes(), nil
+}
+
+// withoutAppArmorDenyRules returns policy without the deny rules added by the
+// agent, along with these rules.
+func withoutAppArmorDenyRules(policy []byte) ([]byte, []string) {
+	var buf bytes.Buffer
+	var rules []string
+	added := false
+	for _, line := range strings.SplitAfter(string(policy), "\n") {
+		switch trimmed := strings.TrimSpace(line); {
+		case trimmed == appArmorDenyRulesBegin:
+			added = true
+		case trimmed == appArmorDenyRulesEnd:
+			added = false
+		case added:
+			if trimmed != "" {
+				rules = append(rules, trimmed)
+			}
+		default:
+			buf.WriteString(line)
+		}
+	}
+	return buf.Bytes(), rules
+}
+
+// mergeRules returns the rules of a followed by the rules of b not in a.
+func mergeRules(a, b []string) []string {
+	seen := make(map[string]bool, len(a))
+	merged := make([]string, 0, len(a)+len(b))
+	for _, rules := range [][]string{a, b} {
+		for _, rule := range rules {
+			if !seen[rule] {
+				seen[rule] = true
+				merged = append(merged, rule)
+			}
+		}
+	}
+	return merged
+}
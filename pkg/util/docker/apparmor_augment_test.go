@@ -0,0 +1,135 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAugmentedAppArmorPolicy = `#include <tunables/global>
+
+profile docker-nginx flags=(attach_disconnected,mediate_deleted) {
+  #include <abstractions/base>
+  network inet tcp,
+  deny /etc/shadow r,
+  # BEGIN datadog-agent deny rules
+  deny /proc/sys/kernel/** w,
+  deny mount,
+  # END datadog-agent deny rules
+}
+`
+
+// setupTestAppArmorAugmentation writes the nginx policy to a policy directory,
+// and replaces apparmor_parser by mockAppArmorParser. It returns the directory
+// of the policies.
+func setupTestAppArmorAugmentation(t *testing.T, tempFolder *tempFolder) string {
+	root := tempFolder.RootPath
+	require.NoError(t, tempFolder.add("profiles", "docker-default (enforce)\n"))
+	require.NoError(t, tempFolder.add("apparmor.d/docker-nginx", testAppArmorPolicy))
+	require.NoError(t, tempFolder.add("apparmor.d/redis", "profile docker-redis {\n  profile child {\n  }\n}\n\nprofile other {\n}\n"))
+	parser := filepath.Join(root, "apparmor_parser")
+	require.NoError(t, ioutil.WriteFile(parser, []byte(fmt.Sprintf(mockAppArmorParser, root)), 0755))
+
+	appArmorParserPath = parser
+	appArmorProfilesPath = filepath.Join(root, "profiles")
+	return filepath.Join(root, "apparmor.d")
+}
+
+func TestAugmentAppArmorProfile(t *testing.T) {
+	tempFolder, err := newTempFolder("test-apparmor-augment")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	defer func(parser, profiles string) {
+		appArmorParserPath, appArmorProfilesPath = parser, profiles
+	}(appArmorParserPath, appArmorProfilesPath)
+	dir := setupTestAppArmorAugmentation(t, tempFolder)
+	nginx := filepath.Join(dir, "docker-nginx")
+	read := func(path string) string {
+		policy, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		return string(policy)
+	}
+
+	path, err := augmentAppArmorProfile(context.Background(), "docker-nginx", dir, []string{"deny /proc/sys/kernel/** w", "deny mount,"})
+	require.NoError(t, err)
+	assert.Equal(t, nginx, path)
+	assert.Equal(t, testAugmentedAppArmorPolicy, read(nginx))
+	loaded, err := appArmorProfileLoaded(appArmorProfilesPath, "docker-nginx")
+	require.NoError(t, err)
+	assert.True(t, loaded)
+
+	// the rules already added are kept
+	_, err = augmentAppArmorProfile(context.Background(), "docker-nginx", dir, []string{"deny mount", "deny ptrace"})
+	require.NoError(t, err)
+	stripped, rules := withoutAppArmorDenyRules([]byte(read(nginx)))
+	assert.Equal(t, []string{"deny /proc/sys/kernel/** w,", "deny mount,", "deny ptrace,"}, rules)
+	assert.Equal(t, testAppArmorPolicy, string(stripped))
+
+	// the policy is restored when it can't be reloaded
+	before := read(nginx)
+	_, err = augmentAppArmorProfile(context.Background(), "docker-nginx", dir, []string{"deny /fail r"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "syntax error in")
+	}
+	assert.Equal(t, before, read(nginx))
+
+	path, err = undoAppArmorProfileAugmentation(context.Background(), "docker-nginx", dir)
+	require.NoError(t, err)
+	assert.Equal(t, nginx, path)
+	assert.Equal(t, testAppArmorPolicy, read(nginx))
+	_, err = undoAppArmorProfileAugmentation(context.Background(), "docker-nginx", dir)
+	assert.EqualError(t, err, "profile docker-nginx has no deny rules added")
+
+	// the policy is found by the profile it declares, and the rules are added
+	// to the profile only
+	path, err = augmentAppArmorProfile(context.Background(), "docker-redis", dir, []string{"deny ptrace"})
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "redis"), path)
+	assert.Equal(t, "profile docker-redis {\n  profile child {\n  }\n  # BEGIN datadog-agent deny rules\n  deny ptrace,\n  # END datadog-agent deny rules\n}\n\nprofile other {\n}\n", read(path))
+}
+
+func TestAugmentAppArmorProfileErrors(t *testing.T) {
+	tempFolder, err := newTempFolder("test-apparmor-augment-errors")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	defer func(parser, profiles string) {
+		appArmorParserPath, appArmorProfilesPath = parser, profiles
+	}(appArmorParserPath, appArmorProfilesPath)
+	dir := setupTestAppArmorAugmentation(t, tempFolder)
+
+	augment := func(profile string, rules ...string) error {
+		_, err := augmentAppArmorProfile(context.Background(), profile, dir, rules)
+		return err
+	}
+	assert.EqualError(t, augment("unconfined", "deny mount"), "container is not confined by an AppArmor profile")
+	assert.EqualError(t, augment("docker-nginx"), "no deny rules to add")
+	assert.EqualError(t, augment("docker-nginx", "allow mount"), `invalid deny rule "allow mount"`)
+	assert.EqualError(t, augment("docker-nginx", "deny mount, }\nprofile x {"), `invalid deny rule "deny mount, }\nprofile x {"`)
+	assert.EqualError(t, augment("docker-mysql", "deny mount"), fmt.Sprintf("no policy declaring profile docker-mysql found in %s", dir))
+	_, err = os.Stat(filepath.Join(tempFolder.RootPath, "parser.args"))
+	assert.True(t, os.IsNotExist(err), "the parser is not run for invalid augmentations")
+
+	_, err = withAppArmorDenyRules([]byte("profile docker-nginx {\n  deny mount,\n"), "docker-nginx", []string{"deny ptrace,"})
+	assert.EqualError(t, err, "could not find the end of profile docker-nginx")
+}
+
+func TestAugmentAppArmorProfileDisabled(t *testing.T) {
+	d := &DockerUtil{cfg: &Config{}}
+	err := d.AugmentAppArmorProfile(context.Background(), "nginx", []string{"deny mount"})
+	assert.EqualError(t, err, "profile augmentation is disabled, set docker_allow_profile_augmentation to enable it")
+	err = d.UndoAppArmorProfileAugmentation(context.Background(), "nginx")
+	assert.EqualError(t, err, "profile augmentation is disabled, set docker_allow_profile_augmentation to enable it")
+}
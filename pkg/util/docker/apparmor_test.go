@@ -0,0 +1,115 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAppArmorPolicy = `#include <tunables/global>
+
+profile docker-nginx flags=(attach_disconnected,mediate_deleted) {
+  #include <abstractions/base>
+  network inet tcp,
+  deny /etc/shadow r,
+}
+`
+
+// mockAppArmorParser is an apparmor_parser recording its arguments, and loading
+// the profiles declared with "profile" into the given list of loaded profiles.
+// It fails on policies containing "fail" and ignores profiles marked "skip".
+const mockAppArmorParser = `#!/bin/sh
+echo "$@" >> %[1]s/parser.args
+if grep -q "fail" "$2"; then
+  echo "syntax error in $2" >&2
+  exit 1
+fi
+grep -v skip "$2" | sed -n 's/^profile \([^ ]*\) .*/\1 (enforce)/p' >> %[1]s/profiles
+`
+
+func TestReloadAppArmorPolicy(t *testing.T) {
+	tempFolder, err := newTempFolder("test-apparmor")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	root := tempFolder.RootPath
+
+	require.NoError(t, tempFolder.add("profiles", "docker-default (enforce)\n"))
+	require.NoError(t, tempFolder.add("nginx.profile", testAppArmorPolicy))
+	require.NoError(t, tempFolder.add("broken.profile", "profile docker-nginx {\n  fail\n}\n"))
+	require.NoError(t, tempFolder.add("skipped.profile", "profile docker-nginx skip {\n}\n"))
+	require.NoError(t, tempFolder.add("other.profile", "profile docker-redis {\n}\n"))
+	// written and closed before being run, as tempFolder.add leaves files open
+	parser := filepath.Join(root, "apparmor_parser")
+	require.NoError(t, ioutil.WriteFile(parser, []byte(fmt.Sprintf(mockAppArmorParser, root)), 0755))
+
+	defer func(parser, profiles string) {
+		appArmorParserPath, appArmorProfilesPath = parser, profiles
+	}(appArmorParserPath, appArmorProfilesPath)
+	appArmorParserPath = parser
+	appArmorProfilesPath = filepath.Join(root, "profiles")
+
+	reload := func(profile, policy string) error {
+		return reloadAppArmorPolicy(context.Background(), profile, filepath.Join(root, policy))
+	}
+	parserArgs := func() string {
+		args, _ := ioutil.ReadFile(filepath.Join(root, "parser.args"))
+		return string(args)
+	}
+
+	require.NoError(t, reload("docker-nginx", "nginx.profile"))
+	assert.Equal(t, fmt.Sprintf("--replace %s/nginx.profile\n", root), parserArgs())
+	loaded, err := appArmorProfileLoaded(appArmorProfilesPath, "docker-nginx")
+	require.NoError(t, err)
+	assert.True(t, loaded)
+
+	// the parser is not run for invalid reloads
+	assert.EqualError(t, reload("", "nginx.profile"), "container is not confined by an AppArmor profile")
+	assert.EqualError(t, reload("unconfined", "nginx.profile"), "container is not confined by an AppArmor profile")
+	assert.EqualError(t, reload("docker-nginx", "other.profile"), fmt.Sprintf("policy %s/other.profile does not declare profile docker-nginx", root))
+	assert.Error(t, reload("docker-nginx", "missing.profile"))
+	assert.Equal(t, fmt.Sprintf("--replace %s/nginx.profile\n", root), parserArgs())
+
+	err = reload("docker-nginx", "broken.profile")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "syntax error in")
+	}
+
+	require.NoError(t, tempFolder.add("profiles", "docker-default (enforce)\n"))
+	assert.EqualError(t, reload("docker-nginx", "skipped.profile"), "profile docker-nginx is not loaded after the reload")
+}
+
+func TestReloadAppArmorPolicyDisabled(t *testing.T) {
+	d := &DockerUtil{cfg: &Config{}}
+	err := d.ReloadAppArmorPolicy(context.Background(), "nginx", "/etc/apparmor.d/docker-nginx")
+	assert.EqualError(t, err, "policy reload is disabled, set docker_allow_policy_reload to enable it")
+}
+
+func TestDeclaresAppArmorProfile(t *testing.T) {
+	for _, tt := range []struct {
+		policy  string
+		profile string
+		want    bool
+	}{
+		{testAppArmorPolicy, "docker-nginx", true},
+		{testAppArmorPolicy, "docker", false},
+		{"profile \"docker-nginx\" {\n}\n", "docker-nginx", true},
+		{"  profile docker-nginx /usr/sbin/nginx {\n}\n", "docker-nginx", true},
+		{"/usr/sbin/nginx flags=(complain) {\n}\n", "/usr/sbin/nginx", true},
+		{"# profile docker-nginx {\n", "docker-nginx", false},
+		{"profile docker-nginx,\n", "docker-nginx", false},
+	} {
+		assert.Equal(t, tt.want, declaresAppArmorProfile([]byte(tt.policy), tt.profile), tt.policy)
+	}
+}
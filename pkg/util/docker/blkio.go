@@ -0,0 +1,95 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/util/containers/metrics"
+)
+
+// blkioLatencyBounds are the upper bounds of the buckets of a LatencyHistogram.
+var blkioLatencyBounds = []time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// LatencyHistogram counts the block devices used by a container by their average
+// I/O time per sector.
+type LatencyHistogram struct {
+	// Bounds are the inclusive upper bounds of the buckets. Values above the
+	// last bound fall into an additional, unbounded, bucket.
+	Bounds []time.Duration
+	// Counts holds the number of devices of each bucket, it has one more
+	// element than Bounds.
+	Counts []int64
+}
+
+func newLatencyHistogram(bounds []time.Duration) *LatencyHistogram {
+	return &LatencyHistogram{Bounds: bounds, Counts: make([]int64, len(bounds)+1)}
+}
+
+// add counts v into its bucket.
+func (h *LatencyHistogram) add(v time.Duration) {
+	for i, b := range h.Bounds {
+		if v <= b {
+			h.Counts[i]++
+			return
+		}
+	}
+	h.Counts[len(h.Bounds)]++
+}
+
+// GetBlockIOLatencyHistogram returns the histogram of the average I/O time per
+// sector of the devices used by the container identified by id, computed from its
+// blkio.time_recursive and blkio.sectors_recursive cgroup files. The count of each
+// bucket is reported as the datadog.docker.container.blkio.latency_bucket gauge,
+// tagged by its upper bound in milliseconds.
+func (d *DockerUtil) GetBlockIOLatencyHistogram(ctx context.Context, id string) (*LatencyHistogram, error) {
+	cgroup, err := containerCgroup(id)
+	if err != nil {
+		return nil, err
+	}
+	return blockIOLatencyHistogram(cgroup)
+}
+
+func blockIOLatencyHistogram(cgroup *metrics.ContainerCgroup) (*LatencyHistogram, error) {
+	// disk time is accounted in milliseconds
+	times, err := cgroup.ParseDeviceStat("blkio", "blkio.time_recursive")
+	if err != nil {
+		return nil, err
+	}
+	sectors, err := cgroup.ParseDeviceStat("blkio", "blkio.sectors_recursive")
+	if err != nil {
+		return nil, err
+	}
+
+	h := newLatencyHistogram(blkioLatencyBounds)
+	for dev, ms := range times {
+		n := sectors[dev]
+		if n == 0 {
+			continue
+		}
+		h.add(time.Duration(ms) * time.Millisecond / time.Duration(n))
+	}
+
+	tags := []string{"container_id:" + cgroup.ContainerID}
+	for i, count := range h.Counts {
+		le := "+Inf"
+		if i < len(h.Bounds) {
+			le = strconv.FormatFloat(float64(h.Bounds[i])/float64(time.Millisecond), 'f', -1, 64)
+		}
+		gauge("datadog.docker.container.blkio.latency_bucket", float64(count), append(tags, "le:"+le))
+	}
+	return h, nil
+}
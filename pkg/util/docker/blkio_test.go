@@ -0,0 +1,75 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/util/containers/metrics"
+)
+
+func TestBlockIOLatencyHistogram(t *testing.T) {
+	tempFolder, err := newTempFolder("test-blkio")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+
+	cgroup := &metrics.ContainerCgroup{
+		ContainerID: "abc",
+		Mounts:      map[string]string{"blkio": tempFolder.RootPath},
+		Paths:       map[string]string{"blkio": "/abc"},
+	}
+
+	// missing files
+	_, err = blockIOLatencyHistogram(cgroup)
+	assert.Error(t, err)
+
+	// per-device milliseconds and sectors
+	require.NoError(t, tempFolder.add("abc/blkio.time_recursive", detab(`
+		8:0 10
+		8:16 200
+		8:32 3000
+		8:48 3000
+		253:0 5000
+		253:1 0
+		253:2 7
+		Total 11210
+	`)))
+	require.NoError(t, tempFolder.add("abc/blkio.sectors_recursive", detab(`
+		8:0 200000
+		8:16 400
+		8:32 60
+		8:48 3
+		253:0 2
+		253:1 10
+		Total 200475
+	`)))
+
+	withTestStatsClient(func(c *testStatsClient) {
+		h, err := blockIOLatencyHistogram(cgroup)
+		require.NoError(t, err)
+		// 8:0 is at 50µs, 8:16 at 0.5ms, 8:32 at 50ms, 8:48 at 1s and 253:0 at 2.5s.
+		// 253:1 took less than a millisecond, 253:2 has no sectors and is ignored.
+		assert.Equal(t, &LatencyHistogram{
+			Bounds: []time.Duration{100 * time.Microsecond, time.Millisecond, 10 * time.Millisecond, 100 * time.Millisecond, time.Second},
+			Counts: []int64{2, 1, 0, 1, 1, 1},
+		}, h)
+
+		require.Len(t, c.gauges, 6)
+		for i, le := range []string{"0.1", "1", "10", "100", "1000", "+Inf"} {
+			assert.Equal(t, testStatsSample{
+				Name:  "datadog.docker.container.blkio.latency_bucket",
+				Value: float64(h.Counts[i]),
+				Tags:  []string{"container_id:abc", "le:" + le},
+			}, c.gauges[i])
+		}
+	})
+}
@@ -0,0 +1,94 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// bpffsPath is where the BPF filesystem is mounted on the host.
+const bpffsPath = "/sys/fs/bpf"
+
+// CheckBPFFSMount reports whether the container identified by id mounts the
+// BPF filesystem of the host, through which it can pin and load eBPF programs.
+// It is sent as the datadog.docker.container.bpffs_mounted gauge. When
+// docker_block_bpffs_mount is set, a critical alert is logged once for such
+// containers, and their metrics are not collected.
+func (d *DockerUtil) CheckBPFFSMount(ctx context.Context, id string) (bool, error) {
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return false, err
+	}
+	return d.checkBPFFSMount(c), nil
+}
+
+func (d *DockerUtil) checkBPFFSMount(c types.ContainerJSON) bool {
+	mounted := mountsBPFFS(c.Mounts)
+	var value float64
+	if mounted {
+		value = 1
+		d.alertBPFFSMount(c.ID, c.Name)
+	}
+	gauge("datadog.docker.container.bpffs_mounted", value, containerTags(c.ID, c.Name))
+	return mounted
+}
+
+// blocksBPFFSMount returns true when the metrics of the listed container c
+// must not be collected, as it mounts the BPF filesystem.
+func (d *DockerUtil) blocksBPFFSMount(c types.Container) bool {
+	if !d.cfg.BlockBPFFSMount || !mountsBPFFS(c.Mounts) {
+		return false
+	}
+	name := ""
+	if len(c.Names) > 0 {
+		name = c.Names[0]
+	}
+	d.alertBPFFSMount(c.ID, name)
+	return true
+}
+
+// alertBPFFSMount logs a critical alert for the container id, mounting the BPF
+// filesystem, the first time it is seen. It returns false if it was already
+// alerted on.
+func (d *DockerUtil) alertBPFFSMount(id, name string) bool {
+	if !d.cfg.BlockBPFFSMount {
+		return false
+	}
+	d.Lock()
+	_, alerted := d.bpffsAlerts[id]
+	if !alerted {
+		d.bpffsAlerts[id] = struct{}{}
+	}
+	d.Unlock()
+	if !alerted {
+		log.Criticalf("SECURITY ALERT: container %s (%s) mounts the BPF filesystem %s and can load eBPF programs, its metrics are not collected", strings.TrimPrefix(name, "/"), id, bpffsPath)
+	}
+	return !alerted
+}
+
+// mountsBPFFS reports whether mounts bind the BPF filesystem of the host, or
+// one of its parent directories, into the container.
+func mountsBPFFS(mounts []types.MountPoint) bool {
+	for _, m := range mounts {
+		if m.Source == "" || (m.Type != "" && m.Type != mount.TypeBind) {
+			// volumes and tmpfs mounts are not backed by the host filesystem
+			continue
+		}
+		source := strings.TrimSuffix(m.Source, "/")
+		if strings.HasPrefix(source+"/", bpffsPath+"/") || strings.HasPrefix(bpffsPath, source+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMountsBPFFS(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		mounts []types.MountPoint
+		want   bool
+	}{
+		{"no mounts", nil, false},
+		{"bpffs", []types.MountPoint{{Type: "bind", Source: "/sys/fs/bpf", Destination: "/sys/fs/bpf"}}, true},
+		{"bpffs elsewhere", []types.MountPoint{{Type: "bind", Source: "/sys/fs/bpf/", Destination: "/bpf"}}, true},
+		{"pinned maps", []types.MountPoint{{Type: "bind", Source: "/sys/fs/bpf/tc/globals", Destination: "/maps"}}, true},
+		{"sysfs", []types.MountPoint{{Type: "bind", Source: "/sys", Destination: "/host/sys"}}, true},
+		{"host root", []types.MountPoint{{Source: "/", Destination: "/host"}}, true},
+		{"cgroups", []types.MountPoint{{Type: "bind", Source: "/sys/fs/cgroup", Destination: "/sys/fs/cgroup"}}, false},
+		{"similar name", []types.MountPoint{{Type: "bind", Source: "/sys/fs/bpf2", Destination: "/data"}}, false},
+		{"volume", []types.MountPoint{{Type: "volume", Source: "/var/lib/docker/volumes/bpf/_data", Destination: "/sys/fs/bpf"}}, false},
+		{"tmpfs", []types.MountPoint{{Type: "tmpfs", Destination: "/sys/fs/bpf"}}, false},
+		{"among others", []types.MountPoint{
+			{Type: "volume", Source: "/var/lib/docker/volumes/data/_data", Destination: "/data"},
+			{Type: "bind", Source: "/sys/fs/bpf", Destination: "/sys/fs/bpf"},
+		}, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, mountsBPFFS(tt.mounts))
+		})
+	}
+}
+
+func TestCheckBPFFSMount(t *testing.T) {
+	bpffs := []types.MountPoint{{Type: "bind", Source: "/sys/fs/bpf", Destination: "/sys/fs/bpf"}}
+	d := &DockerUtil{cfg: &Config{}}
+	withTestStatsClient(func(c *testStatsClient) {
+		mounted := d.checkBPFFSMount(types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{ID: "cilium", Name: "/cilium-agent"},
+			Mounts:            bpffs,
+		})
+		assert.True(t, mounted)
+		assert.False(t, d.checkBPFFSMount(types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{ID: "web", Name: "/web"},
+		}))
+		assert.Equal(t, []testStatsSample{
+			{Name: "datadog.docker.container.bpffs_mounted", Value: 1, Tags: []string{"container_id:cilium", "container_name:cilium-agent"}},
+			{Name: "datadog.docker.container.bpffs_mounted", Value: 0, Tags: []string{"container_id:web", "container_name:web"}},
+		}, c.gauges)
+	})
+}
+
+func TestBlocksBPFFSMount(t *testing.T) {
+	bpffs := []types.MountPoint{{Type: "bind", Source: "/sys/fs/bpf", Destination: "/sys/fs/bpf"}}
+
+	d := &DockerUtil{cfg: &Config{}, bpffsAlerts: make(map[string]struct{})}
+	assert.False(t, d.blocksBPFFSMount(types.Container{ID: "cilium", Names: []string{"/cilium-agent"}, Mounts: bpffs}))
+
+	d.cfg.BlockBPFFSMount = true
+	assert.True(t, d.blocksBPFFSMount(types.Container{ID: "cilium", Names: []string{"/cilium-agent"}, Mounts: bpffs}))
+	assert.True(t, d.blocksBPFFSMount(types.Container{ID: "cilium", Mounts: bpffs}))
+	assert.False(t, d.blocksBPFFSMount(types.Container{ID: "web", Names: []string{"/web"}}))
+}
+
+func TestAlertBPFFSMount(t *testing.T) {
+	d := &DockerUtil{
+		cfg:             &Config{BlockBPFFSMount: true},
+		bpffsAlerts:     make(map[string]struct{}),
+		admissionChecks: newContainerCheckCache(containerCheckTTL),
+		portChecks:      newContainerCheckCache(containerCheckTTL),
+	}
+	assert.True(t, d.alertBPFFSMount("cilium", "/cilium-agent"))
+	assert.False(t, d.alertBPFFSMount("cilium", "/cilium-agent"))
+	assert.True(t, d.alertBPFFSMount("bpftool", "/bpftool"))
+
+	// containers are alerted on again once forgotten
+	d.cleanupCaches([]types.Container{{ID: "bpftool"}})
+	assert.True(t, d.alertBPFFSMount("cilium", "/cilium-agent"))
+	assert.False(t, d.alertBPFFSMount("bpftool", "/bpftool"))
+
+	d.cfg.BlockBPFFSMount = false
+	assert.False(t, d.alertBPFFSMount("web", "/web"))
+}
@@ -0,0 +1,152 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/util/containers/metrics"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// ReconciledMetrics holds the resource usage of a container corrected for the
+// accounting of the systemd slice it runs in.
+type ReconciledMetrics struct {
+	// CorrectedCPUPercent is the share of the CPU time consumed by the
+	// workloads of the slice, excluding the overhead of the slice itself,
+	// spent by the container.
+	CorrectedCPUPercent float64
+	// CorrectedMemoryBytes is the memory used by the container, excluding the
+	// reclaimable page cache.
+	CorrectedMemoryBytes float64
+}
+
+// ReconcileCgroupAccounting reads the CPU and memory accounting of the cgroup
+// of the container identified by id, of its parent systemd slice and of the
+// other cgroups of the slice. The overhead of the slice, its usage not accounted
+// to any of its cgroups (systemd helpers, shims), is subtracted from the slice
+// usage the CPU usage of the container is compared to.
+func (d *DockerUtil) ReconcileCgroupAccounting(ctx context.Context, id string) (*ReconciledMetrics, error) {
+	cgroup, err := containerCgroup(id)
+	if err != nil {
+		return nil, err
+	}
+	return reconcileCgroupAccounting(cgroupDir(cgroup, "cpuacct"), cgroupDir(cgroup, "memory"))
+}
+
+// cgroupDir returns the directory of the cgroup for the given controller.
+func cgroupDir(cgroup *metrics.ContainerCgroup, controller string) string {
+	return filepath.Join(cgroup.Mounts[controller], cgroup.Paths[controller])
+}
+
+func reconcileCgroupAccounting(cpuDir, memDir string) (*ReconciledMetrics, error) {
+	if parent := filepath.Base(filepath.Dir(cpuDir)); !strings.HasSuffix(parent, ".slice") {
+		return nil, fmt.Errorf("the cgroup %s is not in a systemd slice", cpuDir)
+	}
+	usage, err := readCgroupUint(filepath.Join(cpuDir, "cpuacct.usage"))
+	if err != nil {
+		return nil, err
+	}
+	slice, children, err := sliceUsage(filepath.Dir(cpuDir), "cpuacct.usage")
+	if err != nil {
+		return nil, err
+	}
+	var overhead uint64
+	if slice > children {
+		overhead = slice - children
+	}
+	m := &ReconciledMetrics{}
+	if workload := slice - overhead; workload > 0 {
+		m.CorrectedCPUPercent = float64(usage) / float64(workload) * 100
+	}
+	log.Debugf("Slice of cgroup %s spent %d ns of CPU time, %d ns of overhead", cpuDir, slice, overhead)
+
+	memUsage, err := readCgroupUint(filepath.Join(memDir, "memory.usage_in_bytes"))
+	if err != nil {
+		return nil, err
+	}
+	inactiveFile, err := readCgroupStat(filepath.Join(memDir, "memory.stat"), "total_inactive_file")
+	if err != nil {
+		return nil, err
+	}
+	if memUsage > inactiveFile {
+		m.CorrectedMemoryBytes = float64(memUsage - inactiveFile)
+	}
+	return m, nil
+}
+
+// sliceUsage returns the value of the given cgroup file for the slice in dir,
+// and the sum of its values for the cgroups of the slice.
+func sliceUsage(dir, file string) (slice, children uint64, err error) {
+	slice, err = readCgroupUint(filepath.Join(dir, file))
+	if err != nil {
+		return 0, 0, err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		v, err := readCgroupUint(filepath.Join(dir, e.Name(), file))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		children += v
+	}
+	return slice, children, nil
+}
+
+// readCgroupUint reads a cgroup file holding a single integer.
+func readCgroupUint(path string) (uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value in %s: %s", path, err)
+	}
+	return v, nil
+}
+
+// readCgroupStat returns the value of key in a cgroup stat file, or 0 if it is
+// not set.
+func readCgroupStat(path, key string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[0] != key {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s in %s: %s", key, path, err)
+		}
+		return v, nil
+	}
+	return 0, scanner.Err()
+}
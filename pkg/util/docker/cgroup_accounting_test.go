@@ -0,0 +1,76 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/util/containers/metrics"
+)
+
+func TestReconcileCgroupAccounting(t *testing.T) {
+	tempFolder, err := newTempFolder("test-cgroup-accounting")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+
+	for file, contents := range map[string]string{
+		// the slice spent 100s of CPU time, 20s of which outside its cgroups
+		"cpuacct/system.slice/cpuacct.usage":                         "100000000000\n",
+		"cpuacct/system.slice/docker-abc.scope/cpuacct.usage":        "60000000000\n",
+		"cpuacct/system.slice/docker-def.scope/cpuacct.usage":        "15000000000\n",
+		"cpuacct/system.slice/containerd.service/cpuacct.usage":      "5000000000\n",
+		"memory/system.slice/memory.usage_in_bytes":                  "4000000000\n",
+		"memory/system.slice/docker-abc.scope/memory.usage_in_bytes": "1500000000\n",
+		"memory/system.slice/docker-abc.scope/memory.stat":           "cache 600000000\nrss 900000000\ntotal_inactive_file 400000000\n",
+	} {
+		require.NoError(t, tempFolder.add(file, contents))
+	}
+	// a cgroup without CPU accounting
+	require.NoError(t, tempFolder.add("cpuacct/system.slice/empty.scope/tasks", ""))
+
+	cgroup := &metrics.ContainerCgroup{
+		ContainerID: "abc",
+		Mounts: map[string]string{
+			"cpuacct": filepath.Join(tempFolder.RootPath, "cpuacct"),
+			"memory":  filepath.Join(tempFolder.RootPath, "memory"),
+		},
+		Paths: map[string]string{
+			"cpuacct": "/system.slice/docker-abc.scope",
+			"memory":  "/system.slice/docker-abc.scope",
+		},
+	}
+	m, err := reconcileCgroupAccounting(cgroupDir(cgroup, "cpuacct"), cgroupDir(cgroup, "memory"))
+	require.NoError(t, err)
+	assert.InDelta(t, 75.0, m.CorrectedCPUPercent, 1e-9)
+	assert.Equal(t, 1100000000.0, m.CorrectedMemoryBytes)
+}
+
+func TestReconcileCgroupAccountingErrors(t *testing.T) {
+	tempFolder, err := newTempFolder("test-cgroup-accounting-errors")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+
+	require.NoError(t, tempFolder.add("docker/abc/cpuacct.usage", "1\n"))
+	require.NoError(t, tempFolder.add("docker/cpuacct.usage", "1\n"))
+	_, err = reconcileCgroupAccounting(filepath.Join(tempFolder.RootPath, "docker/abc"), filepath.Join(tempFolder.RootPath, "docker/abc"))
+	assert.Error(t, err, "cgroupfs driver")
+
+	require.NoError(t, tempFolder.add("system.slice/docker-abc.scope/cpuacct.usage", "invalid\n"))
+	require.NoError(t, tempFolder.add("system.slice/cpuacct.usage", "1\n"))
+	dir := filepath.Join(tempFolder.RootPath, "system.slice/docker-abc.scope")
+	_, err = reconcileCgroupAccounting(dir, dir)
+	assert.Error(t, err)
+
+	require.NoError(t, tempFolder.add("system.slice/docker-abc.scope/cpuacct.usage", "1\n"))
+	_, err = reconcileCgroupAccounting(dir, dir)
+	assert.Error(t, err, "no memory accounting")
+}
@@ -0,0 +1,106 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// CiliumPolicyStatus holds the number of identities a container is allowed and
+// denied to communicate with, as enforced by Cilium network policies.
+type CiliumPolicyStatus struct {
+	AllowedIngress int
+	DeniedIngress  int
+	AllowedEgress  int
+	DeniedEgress   int
+}
+
+// ciliumEndpointPolicy is the subset of the Cilium API EndpointPolicy model
+// which is used to compute a CiliumPolicyStatus.
+type ciliumEndpointPolicy struct {
+	AllowedIngressIdentities []int64 `json:"allowed-ingress-identities"`
+	DeniedIngressIdentities  []int64 `json:"denied-ingress-identities"`
+	AllowedEgressIdentities  []int64 `json:"allowed-egress-identities"`
+	DeniedEgressIdentities   []int64 `json:"denied-egress-identities"`
+}
+
+// CheckCiliumPolicyCompliance queries the Cilium agent API for the policy enforced
+// on the endpoint of the container identified by id. Denied counts are reported as
+// the datadog.docker.container.cilium.denied_ingress and denied_egress gauges.
+func (d *DockerUtil) CheckCiliumPolicyCompliance(ctx context.Context, id string) (*CiliumPolicyStatus, error) {
+	client, baseURL, err := ciliumAPIClient(d.cfg.CiliumAPIURL, d.queryTimeout)
+	if err != nil {
+		return nil, err
+	}
+	// Cilium endpoints can be addressed by the ID of their container.
+	endpoint := url.PathEscape("container-id:" + id)
+	req, err := http.NewRequest("GET", baseURL+"/v1/endpoint/"+endpoint+"/policy", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error querying the Cilium API: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected Cilium API response for container %s: %s", id, resp.Status)
+	}
+	var policy ciliumEndpointPolicy
+	if err := json.NewDecoder(resp.Body).Decode(&policy); err != nil {
+		return nil, fmt.Errorf("error decoding Cilium endpoint policy: %s", err)
+	}
+
+	status := &CiliumPolicyStatus{
+		AllowedIngress: len(policy.AllowedIngressIdentities),
+		DeniedIngress:  len(policy.DeniedIngressIdentities),
+		AllowedEgress:  len(policy.AllowedEgressIdentities),
+		DeniedEgress:   len(policy.DeniedEgressIdentities),
+	}
+	tags := []string{"container_id:" + id}
+	gauge("datadog.docker.container.cilium.denied_ingress", float64(status.DeniedIngress), tags)
+	gauge("datadog.docker.container.cilium.denied_egress", float64(status.DeniedEgress), tags)
+	return status, nil
+}
+
+// ciliumAPIClient returns an HTTP client for the Cilium API found at apiURL,
+// along with the base URL requests should be made to.
+func ciliumAPIClient(apiURL string, timeout time.Duration) (*http.Client, string, error) {
+	if apiURL == "" {
+		return nil, "", errors.New("no Cilium API URL configured")
+	}
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid Cilium API URL %q: %s", apiURL, err)
+	}
+	switch u.Scheme {
+	case "unix":
+		socketPath := u.Path
+		return &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		}, "http://cilium", nil
+	case "http", "https":
+		return &http.Client{Timeout: timeout}, strings.TrimSuffix(apiURL, "/"), nil
+	default:
+		return nil, "", fmt.Errorf("unsupported Cilium API URL scheme %q", u.Scheme)
+	}
+}
@@ -0,0 +1,99 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newMockCiliumAPI returns a handler serving the policy of the endpoint of the
+// container "abc".
+func newMockCiliumAPI(t *testing.T) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/endpoint/container-id:abc/policy", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "GET", r.Method)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"id": 1234,
+			"allowed-ingress-identities": [1, 2, 3],
+			"denied-ingress-identities": [4],
+			"allowed-egress-identities": [5, 6],
+			"denied-egress-identities": [7, 8, 9, 10],
+			"build": 12
+		}`))
+	})
+	mux.HandleFunc("/v1/endpoint/container-id:bad/policy", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"allowed-ingress-identities": "oops"`))
+	})
+	return mux
+}
+
+func TestCheckCiliumPolicyCompliance(t *testing.T) {
+	srv := httptest.NewServer(newMockCiliumAPI(t))
+	defer srv.Close()
+	d := &DockerUtil{cfg: &Config{CiliumAPIURL: srv.URL}, queryTimeout: time.Second}
+
+	withTestStatsClient(func(c *testStatsClient) {
+		status, err := d.CheckCiliumPolicyCompliance(context.Background(), "abc")
+		require.NoError(t, err)
+		assert.Equal(t, &CiliumPolicyStatus{
+			AllowedIngress: 3,
+			DeniedIngress:  1,
+			AllowedEgress:  2,
+			DeniedEgress:   4,
+		}, status)
+		assert.Equal(t, []testStatsSample{
+			{Name: "datadog.docker.container.cilium.denied_ingress", Value: 1, Tags: []string{"container_id:abc"}},
+			{Name: "datadog.docker.container.cilium.denied_egress", Value: 4, Tags: []string{"container_id:abc"}},
+		}, c.gauges)
+	})
+
+	_, err := d.CheckCiliumPolicyCompliance(context.Background(), "unknown")
+	assert.Error(t, err)
+	_, err = d.CheckCiliumPolicyCompliance(context.Background(), "bad")
+	assert.Error(t, err)
+}
+
+func TestCheckCiliumPolicyComplianceUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cilium")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "cilium.sock")
+	l, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	srv := &http.Server{Handler: newMockCiliumAPI(t)}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	d := &DockerUtil{cfg: &Config{CiliumAPIURL: "unix://" + socketPath}, queryTimeout: time.Second}
+	status, err := d.CheckCiliumPolicyCompliance(context.Background(), "abc")
+	require.NoError(t, err)
+	assert.Equal(t, 1, status.DeniedIngress)
+	assert.Equal(t, 4, status.DeniedEgress)
+}
+
+func TestCiliumAPIClient(t *testing.T) {
+	for _, u := range []string{"", "ftp://cilium", "://"} {
+		_, _, err := ciliumAPIClient(u, time.Second)
+		assert.Error(t, err, u)
+	}
+	_, base, err := ciliumAPIClient("http://localhost:9876/", time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://localhost:9876", base)
+}
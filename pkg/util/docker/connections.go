@@ -0,0 +1,241 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+const (
+	// ConnectionConnect is the type of events for connections initiated by the container.
+	ConnectionConnect = "connect"
+	// ConnectionAccept is the type of events for connections accepted by the container.
+	ConnectionAccept = "accept"
+
+	connectionsDebugPrefix = "/debug/containers/"
+)
+
+// connectionPollInterval is the delay between two reads of the connection tracer.
+var connectionPollInterval = time.Second
+
+// newConnectionTracer creates the tracer used by StartConnectionTracing. It is
+// nil unless a tracer was registered with RegisterConnectionTracer.
+var newConnectionTracer func() (ConnectionTracer, error)
+
+// TracedConnection is a TCP connection reported by a ConnectionTracer.
+type TracedConnection struct {
+	// Incoming is true for accepted connections.
+	Incoming bool
+	SrcIP    net.IP
+	DstIP    net.IP
+	SrcPort  int
+	DstPort  int
+	PID      int
+}
+
+// ConnectionTracer reports the TCP connections of the host, as tracked by the
+// eBPF probes on tcp_v4_connect and inet_csk_accept.
+type ConnectionTracer interface {
+	// Connections returns the connections which were active since the last call.
+	Connections() ([]TracedConnection, error)
+	Stop()
+}
+
+// RegisterConnectionTracer sets the function creating the tracers used by
+// StartConnectionTracing. It is called by eBPF-enabled builds, as the docker
+// package cannot depend on the eBPF tracer.
+func RegisterConnectionTracer(factory func() (ConnectionTracer, error)) {
+	newConnectionTracer = factory
+}
+
+// ConnectionEvent is a TCP connection opened or accepted by a container.
+type ConnectionEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	Type      string    `json:"type"`
+	SrcIP     net.IP    `json:"src_ip"`
+	DstIP     net.IP    `json:"dst_ip"`
+	SrcPort   int       `json:"src_port"`
+	DstPort   int       `json:"dst_port"`
+	PID       int       `json:"pid"`
+}
+
+// connectionRing holds the last connection events of a container.
+type connectionRing struct {
+	mu     sync.RWMutex
+	events []ConnectionEvent
+	next   int
+	full   bool
+}
+
+func newConnectionRing(size int) *connectionRing {
+	return &connectionRing{events: make([]ConnectionEvent, size)}
+}
+
+func (r *connectionRing) add(e ConnectionEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events[r.next] = e
+	r.next = (r.next + 1) % len(r.events)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// list returns the events of the ring, oldest first.
+func (r *connectionRing) list() []ConnectionEvent {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if !r.full {
+		return append([]ConnectionEvent{}, r.events[:r.next]...)
+	}
+	return append(append([]ConnectionEvent{}, r.events[r.next:]...), r.events[:r.next]...)
+}
+
+// connectionHistory holds the connection events of the traced containers, by ID.
+var connectionHistory = struct {
+	sync.RWMutex
+	rings map[string]*connectionRing
+}{rings: make(map[string]*connectionRing)}
+
+func init() {
+	http.HandleFunc(connectionsDebugPrefix, connectionsDebugHandler)
+}
+
+// connectionsDebugHandler serves the connection history of a container on
+// /debug/containers/{id}/connections.
+func connectionsDebugHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, connectionsDebugPrefix)
+	if !strings.HasSuffix(path, "/connections") {
+		http.NotFound(w, r)
+		return
+	}
+	id := strings.TrimSuffix(path, "/connections")
+	connectionHistory.RLock()
+	ring, ok := connectionHistory.rings[id]
+	connectionHistory.RUnlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("no connection history for container %s", id), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ring.list())
+}
+
+// StartConnectionTracing traces the TCP connections opened and accepted by the
+// processes of the container identified by id, until ctx is done. The last
+// bufferSize events are kept for forensics and served on
+// /debug/containers/{id}/connections, even after tracing stops. Events are also
+// sent on the returned channel, and dropped when it is full.
+func (d *DockerUtil) StartConnectionTracing(ctx context.Context, id string, bufferSize int) (<-chan ConnectionEvent, error) {
+	if bufferSize <= 0 {
+		return nil, errors.New("buffer size must be positive")
+	}
+	// make sure the container runs before loading the eBPF probes
+	if _, err := containerCgroup(id); err != nil {
+		return nil, err
+	}
+	if newConnectionTracer == nil {
+		return nil, errors.New("connection tracing requires eBPF support")
+	}
+	tracer, err := newConnectionTracer()
+	if err != nil {
+		return nil, fmt.Errorf("could not start eBPF tracer: %s", err)
+	}
+	pids := func() (map[int]struct{}, error) {
+		cg, err := containerCgroup(id)
+		if err != nil {
+			return nil, err
+		}
+		set := make(map[int]struct{}, len(cg.Pids))
+		for _, pid := range cg.Pids {
+			set[int(pid)] = struct{}{}
+		}
+		return set, nil
+	}
+	return traceConnections(ctx, id, tracer, pids, bufferSize), nil
+}
+
+// traceConnections polls tracer for the new connections of the processes
+// returned by pids, recording them in the history of the container id.
+func traceConnections(ctx context.Context, id string, tracer ConnectionTracer, pids func() (map[int]struct{}, error), bufferSize int) <-chan ConnectionEvent {
+	ring := newConnectionRing(bufferSize)
+	connectionHistory.Lock()
+	connectionHistory.rings[id] = ring
+	connectionHistory.Unlock()
+
+	out := make(chan ConnectionEvent, bufferSize)
+	go func() {
+		defer close(out)
+		defer tracer.Stop()
+		t := time.NewTicker(connectionPollInterval)
+		defer t.Stop()
+		seen := make(map[string]struct{})
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-t.C:
+				procs, err := pids()
+				if err != nil {
+					log.Debugf("Stopping connection tracing of container %s: %s", id, err)
+					return
+				}
+				conns, err := tracer.Connections()
+				if err != nil {
+					log.Debugf("Cannot get connections of container %s: %s", id, err)
+					continue
+				}
+				current := make(map[string]struct{}, len(seen))
+				for _, c := range conns {
+					if _, ok := procs[c.PID]; !ok {
+						continue
+					}
+					key := fmt.Sprintf("%d %s:%d %s:%d", c.PID, c.SrcIP, c.SrcPort, c.DstIP, c.DstPort)
+					current[key] = struct{}{}
+					if _, ok := seen[key]; ok {
+						continue
+					}
+					e := newConnectionEvent(now, c)
+					ring.add(e)
+					select {
+					case out <- e:
+					default:
+					}
+				}
+				seen = current
+			}
+		}
+	}()
+	return out
+}
+
+func newConnectionEvent(ts time.Time, c TracedConnection) ConnectionEvent {
+	typ := ConnectionConnect
+	if c.Incoming {
+		typ = ConnectionAccept
+	}
+	return ConnectionEvent{
+		Timestamp: ts,
+		Type:      typ,
+		SrcIP:     c.SrcIP,
+		DstIP:     c.DstIP,
+		SrcPort:   c.SrcPort,
+		DstPort:   c.DstPort,
+		PID:       c.PID,
+	}
+}
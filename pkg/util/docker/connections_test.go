@@ -0,0 +1,144 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockConnectionTracer returns one set of connections per call, then errors.
+type mockConnectionTracer struct {
+	mu      sync.Mutex
+	polls   [][]TracedConnection
+	stopped bool
+}
+
+func (t *mockConnectionTracer) Connections() ([]TracedConnection, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.polls) == 0 {
+		return nil, errors.New("no more connections")
+	}
+	conns := t.polls[0]
+	t.polls = t.polls[1:]
+	return conns, nil
+}
+
+func (t *mockConnectionTracer) Stop() {
+	t.mu.Lock()
+	t.stopped = true
+	t.mu.Unlock()
+}
+
+func tcpConn(pid int, src string, sport int, dst string, dport int, incoming bool) TracedConnection {
+	return TracedConnection{
+		Incoming: incoming,
+		SrcIP:    net.ParseIP(src),
+		DstIP:    net.ParseIP(dst),
+		SrcPort:  sport,
+		DstPort:  dport,
+		PID:      pid,
+	}
+}
+
+func TestConnectionRing(t *testing.T) {
+	r := newConnectionRing(3)
+	assert.Empty(t, r.list())
+	for i := 1; i <= 5; i++ {
+		r.add(ConnectionEvent{PID: i})
+		if i == 2 {
+			assert.Equal(t, []ConnectionEvent{{PID: 1}, {PID: 2}}, r.list())
+		}
+	}
+	assert.Equal(t, []ConnectionEvent{{PID: 3}, {PID: 4}, {PID: 5}}, r.list(), "oldest events are overwritten")
+}
+
+func TestTraceConnections(t *testing.T) {
+	defer func(d time.Duration) { connectionPollInterval = d }(connectionPollInterval)
+	connectionPollInterval = 5 * time.Millisecond
+
+	outgoing := tcpConn(10, "172.17.0.2", 41234, "10.0.0.1", 5432, false)
+	incoming := tcpConn(11, "172.17.0.2", 80, "10.0.0.2", 51000, true)
+	other := tcpConn(99, "172.17.0.3", 41235, "10.0.0.1", 5432, false)
+	tracer := &mockConnectionTracer{polls: [][]TracedConnection{
+		{outgoing, other},
+		{outgoing, incoming}, // outgoing was already seen
+	}}
+	pids := func() (map[int]struct{}, error) {
+		return map[int]struct{}{10: {}, 11: {}}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := traceConnections(ctx, "abc", tracer, pids, 1)
+
+	var got []ConnectionEvent
+	for len(got) < 2 {
+		select {
+		case e := <-events:
+			got = append(got, e)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for connection events")
+		}
+	}
+	cancel()
+	for range events {
+	}
+	tracer.mu.Lock()
+	assert.True(t, tracer.stopped)
+	tracer.mu.Unlock()
+
+	assert.Equal(t, ConnectionConnect, got[0].Type)
+	assert.Equal(t, 10, got[0].PID)
+	assert.True(t, net.ParseIP("10.0.0.1").Equal(got[0].DstIP))
+	assert.Equal(t, 41234, got[0].SrcPort)
+	assert.Equal(t, 5432, got[0].DstPort)
+	assert.Equal(t, ConnectionAccept, got[1].Type)
+	assert.Equal(t, 11, got[1].PID)
+	assert.True(t, net.ParseIP("172.17.0.2").Equal(got[1].SrcIP))
+
+	// the history only holds the last event
+	srv := httptest.NewServer(http.HandlerFunc(connectionsDebugHandler))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/debug/containers/abc/connections")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var history []ConnectionEvent
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&history))
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, ConnectionAccept, history[0].Type)
+		assert.Equal(t, 51000, history[0].DstPort)
+	}
+
+	for _, path := range []string{"/debug/containers/unknown/connections", "/debug/containers/abc"} {
+		resp, err := http.Get(srv.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, path)
+	}
+}
+
+func TestStartConnectionTracingErrors(t *testing.T) {
+	d := &DockerUtil{cfg: &Config{}, queryTimeout: time.Second}
+	_, err := d.StartConnectionTracing(context.Background(), "abc", 0)
+	assert.Error(t, err, "buffer size must be positive")
+
+	_, err = d.StartConnectionTracing(context.Background(), "abc", 10)
+	assert.Error(t, err, "container abc does not exist")
+}
@@ -0,0 +1,92 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// containerCheckTTL is how long the result of a container check is used
+	// before the check is run again.
+	containerCheckTTL = 5 * time.Minute
+
+	// maxConcurrentContainerChecks is the number of checks of a
+	// containerCheckCache running at the same time.
+	maxConcurrentContainerChecks = 4
+)
+
+// containerCheckCache runs slow checks of containers, such as calls to external
+// services, in the background and keeps their results by container ID, so that
+// listing containers never waits for them.
+type containerCheckCache struct {
+	ttl time.Duration
+	sem chan struct{} // bounds the running checks
+
+	mu      sync.Mutex
+	results map[string]containerCheckResult
+	pending map[string]struct{}
+}
+
+// containerCheckResult is the result of the check of a container.
+type containerCheckResult struct {
+	value   interface{}
+	expires time.Time
+}
+
+// newContainerCheckCache returns a new cache keeping results for ttl.
+func newContainerCheckCache(ttl time.Duration) *containerCheckCache {
+	return &containerCheckCache{
+		ttl:     ttl,
+		sem:     make(chan struct{}, maxConcurrentContainerChecks),
+		results: make(map[string]containerCheckResult),
+		pending: make(map[string]struct{}),
+	}
+}
+
+// Get returns the last result of the check of the container id, and whether
+// there is one. When there is none, or it has expired, check is run in the
+// background and its result is returned by later calls.
+func (c *containerCheckCache) Get(id string, check func() interface{}) (interface{}, bool) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.results[id]
+	if ok && now.Before(r.expires) {
+		return r.value, true
+	}
+	if _, running := c.pending[id]; !running {
+		c.pending[id] = struct{}{}
+		go c.run(id, check)
+	}
+	return r.value, ok
+}
+
+// run runs check and stores its result for the container id.
+func (c *containerCheckCache) run(id string, check func() interface{}) {
+	c.sem <- struct{}{}
+	value := check()
+	<-c.sem
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, id)
+	c.results[id] = containerCheckResult{value: value, expires: time.Now().Add(c.ttl)}
+}
+
+// Retain forgets the results of the containers which are not in live.
+func (c *containerCheckCache) Retain(live map[string]struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id := range c.results {
+		if _, ok := live[id]; !ok {
+			delete(c.results, id)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitForCheck calls c.Get until cond holds for its result, for up to a second.
+func waitForCheck(t *testing.T, c *containerCheckCache, id string, check func() interface{}, cond func(v interface{}, ok bool) bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond(c.Get(id, check)) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the check of %s", id)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func hasResult(v interface{}, ok bool) bool { return ok }
+
+func TestContainerCheckCache(t *testing.T) {
+	c := newContainerCheckCache(time.Hour)
+	var runs int32
+	release := make(chan struct{})
+	check := func() interface{} {
+		atomic.AddInt32(&runs, 1)
+		<-release
+		return true
+	}
+
+	// the check runs in the background, once
+	_, ok := c.Get("abc", check)
+	assert.False(t, ok)
+	_, ok = c.Get("abc", check)
+	assert.False(t, ok)
+	close(release)
+	waitForCheck(t, c, "abc", check, hasResult)
+	v, _ := c.Get("abc", check)
+	assert.Equal(t, true, v)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&runs))
+
+	// results of dead containers are forgotten
+	c.Retain(map[string]struct{}{"def": {}})
+	_, ok = c.Get("abc", check)
+	assert.False(t, ok)
+}
+
+func TestContainerCheckCacheExpiry(t *testing.T) {
+	c := newContainerCheckCache(0)
+	var runs int32
+	check := func() interface{} { return atomic.AddInt32(&runs, 1) }
+
+	waitForCheck(t, c, "abc", check, hasResult)
+	// the expired result is still returned while the check runs again
+	waitForCheck(t, c, "abc", check, func(v interface{}, ok bool) bool {
+		return ok && v.(int32) > 1
+	})
+}
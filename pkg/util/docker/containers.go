@@ -18,6 +18,7 @@ import (
 
 	"github.com/docker/docker/api/types"
 
+	"github.com/DataDog/datadog-agent/pkg/config"
 	"github.com/DataDog/datadog-agent/pkg/util/containers"
 	"github.com/DataDog/datadog-agent/pkg/util/containers/metrics"
 	"github.com/DataDog/datadog-agent/pkg/util/log"
@@ -78,6 +79,16 @@ func (d *DockerUtil) UpdateContainerMetrics(cList []*containers.Container) error
 			continue
 		}
 
+		if d.cfg.PageFaultMetricsEnabled {
+			faults, err := pageFaultRate(container.ID, config.Datadog.GetString("container_proc_root"), container.Pids, time.Now())
+			if err != nil {
+				log.Debugf("Cannot get page faults of container %s: %s", container.ID[:12], err)
+			} else {
+				container.MinorPageFaultsPerSec = faults.MinorPageFaultsPerSec
+				container.MajorPageFaultsPerSec = faults.MajorPageFaultsPerSec
+			}
+		}
+
 		if d.cfg.CollectNetwork {
 			d.Lock()
 			networks := d.networkMappings[container.ID]
@@ -132,6 +143,9 @@ func (d *DockerUtil) dockerContainers(cfg *ContainerListConfig) ([]*containers.C
 		}
 
 		excluded := d.cfg.filter.IsExcluded(c.Names[0], image)
+		if !excluded && c.State == containers.ContainerRunningState && d.blocksBPFFSMount(c) {
+			excluded = true
+		}
 		if excluded && !cfg.FlagExcluded {
 			continue
 		}
@@ -150,6 +164,22 @@ func (d *DockerUtil) dockerContainers(cfg *ContainerListConfig) ([]*containers.C
 			Health:      parseContainerHealth(c.Status),
 			AddressList: parseContainerNetworkAddresses(c.Ports, c.NetworkSettings, c.Names[0]),
 		}
+		if d.cfg.AdmissionWebhookURL != "" && c.State == containers.ContainerRunningState {
+			id := c.ID
+			rejected, ok := d.admissionChecks.Get(id, func() interface{} {
+				return d.isRejectedByAdmissionWebhook(id)
+			})
+			container.AdmissionRejected = ok && rejected.(bool)
+		}
+		if d.cfg.VerifyPortListening && c.State == containers.ContainerRunningState {
+			addrs := container.AddressList
+			unreachable, ok := d.portChecks.Get(c.ID, func() interface{} {
+				return unreachablePorts(addrs)
+			})
+			if ok {
+				container.UnreachablePorts = unreachable.([]containers.NetworkAddress)
+			}
+		}
 
 		ret = append(ret, container)
 	}
@@ -263,5 +293,12 @@ func (d *DockerUtil) cleanupCaches(containers []types.Container) {
 			delete(d.imageNameBySha, image)
 		}
 	}
+	for cid := range d.bpffsAlerts {
+		if _, ok := liveContainers[cid]; !ok {
+			delete(d.bpffsAlerts, cid)
+		}
+	}
 	d.Unlock()
+	d.admissionChecks.Retain(liveContainers)
+	d.portChecks.Retain(liveContainers)
 }
@@ -0,0 +1,55 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+
+	"github.com/DataDog/datadog-agent/pkg/util/containers/metrics"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// KernelUserSplit holds the share of CPU time a container spent in user and
+// kernel space, in percent.
+type KernelUserSplit struct {
+	UserPercent   float64
+	KernelPercent float64
+}
+
+// GetKernelUserTimeSplit returns how the CPU time of the container identified by
+// id splits between user and kernel space, as accounted in its cpuacct.stat file.
+// The kernel share is reported as the datadog.docker.container.cpu.kernel_percent
+// gauge.
+func (d *DockerUtil) GetKernelUserTimeSplit(ctx context.Context, id string) (*KernelUserSplit, error) {
+	cgroup, err := containerCgroup(id)
+	if err != nil {
+		return nil, err
+	}
+	return d.kernelUserTimeSplit(cgroup)
+}
+
+// kernelUserTimeSplit computes the kernel/user CPU time split of the given cgroup.
+func (d *DockerUtil) kernelUserTimeSplit(cgroup *metrics.ContainerCgroup) (*KernelUserSplit, error) {
+	stat, err := cgroup.CPU()
+	if err != nil {
+		return nil, err
+	}
+	split := &KernelUserSplit{}
+	total := stat.User + stat.System
+	if total == 0 {
+		return split, nil
+	}
+	split.UserPercent = float64(stat.User) / float64(total) * 100
+	split.KernelPercent = float64(stat.System) / float64(total) * 100
+
+	gauge("datadog.docker.container.cpu.kernel_percent", split.KernelPercent, []string{"container_id:" + cgroup.ContainerID})
+	if max := d.cfg.MaxKernelCPUPercent; max > 0 && split.KernelPercent > max {
+		log.Warnf("Container %s spends %.1f%% of its CPU time in kernel space (max %.1f%%), it may have a high syscall overhead", cgroup.ContainerID, split.KernelPercent, max)
+	}
+	return split, nil
+}
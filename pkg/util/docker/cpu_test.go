@@ -0,0 +1,55 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/util/containers/metrics"
+)
+
+func TestKernelUserTimeSplit(t *testing.T) {
+	tempFolder, err := newTempFolder("test-cpuacct")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+
+	cgroup := &metrics.ContainerCgroup{
+		ContainerID: "abc",
+		Mounts:      map[string]string{"cpuacct": tempFolder.RootPath},
+		Paths:       map[string]string{"cpuacct": "/abc"},
+	}
+	d := &DockerUtil{cfg: &Config{MaxKernelCPUPercent: 50}}
+
+	for nb, tc := range []struct {
+		stat   string
+		user   float64
+		kernel float64
+	}{
+		{"user 300\nsystem 100\n", 75, 25},
+		{"user 10\nsystem 90\n", 10, 90},
+		{"user 0\nsystem 0\n", 0, 0},
+	} {
+		require.NoError(t, tempFolder.add("abc/cpuacct.stat", tc.stat))
+		withTestStatsClient(func(c *testStatsClient) {
+			split, err := d.kernelUserTimeSplit(cgroup)
+			require.NoError(t, err, "case %d", nb)
+			assert.Equal(t, &KernelUserSplit{UserPercent: tc.user, KernelPercent: tc.kernel}, split, "case %d", nb)
+			if tc.user+tc.kernel == 0 {
+				assert.Len(t, c.gauges, 0, "case %d", nb)
+				return
+			}
+			require.Len(t, c.gauges, 1, "case %d", nb)
+			assert.Equal(t, "datadog.docker.container.cpu.kernel_percent", c.gauges[0].Name)
+			assert.Equal(t, tc.kernel, c.gauges[0].Value)
+			assert.Equal(t, []string{"container_id:abc"}, c.gauges[0].Tags)
+		})
+	}
+}
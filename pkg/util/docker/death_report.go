@@ -0,0 +1,275 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// deathLogLines is the number of lines of logs captured when a container dies.
+const deathLogLines = 1000
+
+// deathActions are the events announcing the death of a container. The kill
+// and oom events are sent while the processes of the container are still
+// running, so that their state can still be read, unlike the die event.
+var deathActions = map[string]bool{
+	"kill": true,
+	"oom":  true,
+	"die":  true,
+}
+
+// processStateFields are the fields of /proc/{pid}/status describing the state
+// of a process.
+var processStateFields = map[string]bool{
+	"State":   true,
+	"PPid":    true,
+	"Threads": true,
+	"SigPnd":  true,
+	"ShdPnd":  true,
+	"SigBlk":  true,
+	"SigIgn":  true,
+	"SigCgt":  true,
+}
+
+// DeathReport is the state of a container captured when it died.
+type DeathReport struct {
+	ContainerID string    `json:"container_id"`
+	Time        time.Time `json:"time"`
+	// Event is the event which triggered the capture: kill, oom or die.
+	Event string `json:"event"`
+	// Logs holds the last lines of the logs of the container.
+	Logs []string `json:"logs"`
+	// PID is the PID of the main process of the container, 0 if it already
+	// exited.
+	PID int `json:"pid"`
+	// MemoryHeader holds the memory usage of the main process, as found in
+	// the header of its memory dumps: the Vm* and Rss* fields of its status.
+	MemoryHeader []string `json:"memory_header"`
+	// OpenFiles lists the files opened by the main process, sorted.
+	OpenFiles []string `json:"open_files"`
+	// ProcessState holds the state of the main process, such as its State,
+	// Threads and pending signals, from its status.
+	ProcessState map[string]string `json:"process_state"`
+	// Errors lists why parts of the state could not be captured.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// DeathStateCollector captures the state of a dying container.
+type DeathStateCollector interface {
+	Collect(id string) DeathReport
+}
+
+// AttachDeathHook watches the container identified by id in the background,
+// until it dies or ctx is done. When it is killed or dies, the state of the
+// container is captured with collector and written as JSON to a file of
+// docker_death_report_dir, named after the container and the time of death.
+func (d *DockerUtil) AttachDeathHook(ctx context.Context, id string, collector DeathStateCollector) error {
+	dir := d.cfg.DeathReportDir
+	if dir == "" {
+		return errors.New("no death report directory configured, set docker_death_report_dir to enable it")
+	}
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return err
+	}
+	if c.State == nil || !c.State.Running {
+		return fmt.Errorf("container %s is not running", id)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	filter := filters.NewArgs()
+	filter.Add("type", "container")
+	filter.Add("container", c.ID)
+	for action := range deathActions {
+		filter.Add("event", action)
+	}
+	msgs, errs := d.cli.Events(ctx, types.EventsOptions{Filters: filter})
+	go func() {
+		if _, err := watchDeath(ctx, c.ID, msgs, errs, collector, dir); err != nil && err != context.Canceled {
+			log.Warnf("Death hook of container %s stopped: %s", c.ID, err)
+		}
+	}()
+	return nil
+}
+
+// watchDeath waits for the death of the container identified by id and writes
+// the report of collector to dir, returning its path.
+func watchDeath(ctx context.Context, id string, msgs <-chan events.Message, errs <-chan error, collector DeathStateCollector, dir string) (string, error) {
+	for {
+		select {
+		case msg := <-msgs:
+			if msg.Actor.ID != id || !deathActions[msg.Action] {
+				continue
+			}
+			report := collector.Collect(id)
+			report.Event = msg.Action
+			path, err := writeDeathReport(dir, report)
+			if err != nil {
+				return "", err
+			}
+			log.Warnf("Container %s received a %s event, its state was written to %s", id, msg.Action, path)
+			return path, nil
+		case err := <-errs:
+			return "", err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// writeDeathReport writes report to dir and returns the path of the file.
+func writeDeathReport(dir string, report DeathReport) (string, error) {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%d.json", report.ContainerID, report.Time.Unix()))
+	return path, ioutil.WriteFile(path, data, 0600)
+}
+
+// NewDeathStateCollector returns a DeathStateCollector capturing the logs of
+// containers from the Docker daemon, and the state of their main process from
+// its /proc/{pid} directory.
+func (d *DockerUtil) NewDeathStateCollector() DeathStateCollector {
+	return &procDeathStateCollector{d: d}
+}
+
+type procDeathStateCollector struct {
+	d *DockerUtil
+}
+
+// Collect implements DeathStateCollector.
+func (c *procDeathStateCollector) Collect(id string) DeathReport {
+	report := DeathReport{ContainerID: id, Time: time.Now()}
+	// first read the state of the processes, which are about to exit
+	pid, err := c.d.containerPID(id)
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+	} else {
+		report.PID = pid
+		collectProcessState(&report, filepath.Join(config.Datadog.GetString("container_proc_root"), strconv.Itoa(pid)))
+	}
+	logs, err := c.d.containerLogTail(id, deathLogLines)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("could not read logs: %s", err))
+	}
+	report.Logs = logs
+	return report
+}
+
+// containerLogTail returns the last n lines of the logs of the container
+// identified by id.
+func (d *DockerUtil) containerLogTail(id string, n int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.queryTimeout)
+	defer cancel()
+	rc, err := d.cli.ContainerLogs(ctx, id, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       strconv.Itoa(n),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	tty := false
+	if c, err := d.Inspect(id, false); err == nil && c.Config != nil {
+		tty = c.Config.Tty
+	}
+	return logTail(rc, tty, n)
+}
+
+// logTail returns the last n lines of the log stream r, in which stdout and
+// stderr are multiplexed unless the container has a TTY.
+func logTail(r io.Reader, tty bool, n int) ([]string, error) {
+	var buf bytes.Buffer
+	var err error
+	if tty {
+		_, err = io.Copy(&buf, r)
+	} else {
+		_, err = stdcopy.StdCopy(&buf, &buf, r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return lastLines(buf.Bytes(), n), nil
+}
+
+// lastLines returns the last n lines of data.
+func lastLines(data []byte, n int) []string {
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		return []string{}
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// collectProcessState adds the state of the process found in pidDir to report.
+func collectProcessState(report *DeathReport, pidDir string) {
+	report.MemoryHeader = []string{}
+	report.ProcessState = make(map[string]string)
+	if f, err := os.Open(filepath.Join(pidDir, "status")); err != nil {
+		report.Errors = append(report.Errors, err.Error())
+	} else {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			kv := strings.SplitN(scanner.Text(), ":", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			key, value := kv[0], strings.TrimSpace(kv[1])
+			switch {
+			case strings.HasPrefix(key, "Vm") || strings.HasPrefix(key, "Rss"):
+				report.MemoryHeader = append(report.MemoryHeader, key+": "+value)
+			case processStateFields[key]:
+				report.ProcessState[key] = value
+			}
+		}
+		f.Close()
+	}
+
+	report.OpenFiles = []string{}
+	fdDir := filepath.Join(pidDir, "fd")
+	fds, err := ioutil.ReadDir(fdDir)
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return
+	}
+	for _, fd := range fds {
+		if target, err := os.Readlink(filepath.Join(fdDir, fd.Name())); err == nil {
+			report.OpenFiles = append(report.OpenFiles, target)
+		}
+	}
+	sort.Strings(report.OpenFiles)
+}
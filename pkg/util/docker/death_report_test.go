@@ -0,0 +1,144 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockDeathStateCollector records the containers it collects the state of.
+type mockDeathStateCollector struct {
+	collected []string
+}
+
+func (c *mockDeathStateCollector) Collect(id string) DeathReport {
+	c.collected = append(c.collected, id)
+	return DeathReport{
+		ContainerID: id,
+		Time:        time.Unix(1550000000, 0).UTC(),
+		Logs:        []string{"panic: runtime error: invalid memory address"},
+		PID:         4242,
+	}
+}
+
+func TestWatchDeath(t *testing.T) {
+	tempFolder, err := newTempFolder("test-death-report")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	dir := tempFolder.RootPath
+
+	msgs := make(chan events.Message, 3)
+	errs := make(chan error, 1)
+	msgs <- events.Message{Action: "die", Actor: events.Actor{ID: "other"}}
+	msgs <- events.Message{Action: "start", Actor: events.Actor{ID: "app"}}
+	msgs <- events.Message{Action: "kill", Actor: events.Actor{ID: "app"}}
+
+	collector := &mockDeathStateCollector{}
+	path, err := watchDeath(context.Background(), "app", msgs, errs, collector, dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app"}, collector.collected)
+	assert.Equal(t, filepath.Join(dir, "app-1550000000.json"), path)
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	var report DeathReport
+	require.NoError(t, json.Unmarshal(data, &report))
+	assert.Equal(t, "app", report.ContainerID)
+	assert.Equal(t, "kill", report.Event)
+	assert.Equal(t, 4242, report.PID)
+	assert.Equal(t, []string{"panic: runtime error: invalid memory address"}, report.Logs)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	errs <- errors.New("connection reset")
+	_, err = watchDeath(context.Background(), "app", msgs, errs, collector, dir)
+	assert.EqualError(t, err, "connection reset")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = watchDeath(ctx, "app", msgs, errs, collector, dir)
+	assert.Equal(t, context.Canceled, err)
+	assert.Len(t, collector.collected, 1)
+
+	msgs <- events.Message{Action: "die", Actor: events.Actor{ID: "app"}}
+	_, err = watchDeath(context.Background(), "app", msgs, errs, collector, filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestAttachDeathHookDisabled(t *testing.T) {
+	d := &DockerUtil{cfg: &Config{}}
+	err := d.AttachDeathHook(context.Background(), "app", &mockDeathStateCollector{})
+	assert.EqualError(t, err, "no death report directory configured, set docker_death_report_dir to enable it")
+}
+
+func TestCollectProcessState(t *testing.T) {
+	tempFolder, err := newTempFolder("test-death-process")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	root := tempFolder.RootPath
+
+	require.NoError(t, tempFolder.add("42/status", `Name:	java
+State:	S (sleeping)
+PPid:	1
+VmPeak:	 4521236 kB
+VmSize:	 4521236 kB
+VmRSS:	  812340 kB
+RssAnon:	  800000 kB
+Threads:	57
+SigPnd:	0000000000000000
+ShdPnd:	0000000000004000
+`))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "42/fd"), 0755))
+	require.NoError(t, os.Symlink("/var/log/app.log", filepath.Join(root, "42/fd/3")))
+	require.NoError(t, os.Symlink("/dev/null", filepath.Join(root, "42/fd/0")))
+	require.NoError(t, os.Symlink("socket:[12345]", filepath.Join(root, "42/fd/4")))
+
+	var report DeathReport
+	collectProcessState(&report, filepath.Join(root, "42"))
+	assert.Equal(t, []string{"VmPeak: 4521236 kB", "VmSize: 4521236 kB", "VmRSS: 812340 kB", "RssAnon: 800000 kB"}, report.MemoryHeader)
+	assert.Equal(t, []string{"/dev/null", "/var/log/app.log", "socket:[12345]"}, report.OpenFiles)
+	assert.Equal(t, map[string]string{
+		"State":   "S (sleeping)",
+		"PPid":    "1",
+		"Threads": "57",
+		"SigPnd":  "0000000000000000",
+		"ShdPnd":  "0000000000004000",
+	}, report.ProcessState)
+	assert.Empty(t, report.Errors)
+
+	// the process already exited
+	report = DeathReport{}
+	collectProcessState(&report, filepath.Join(root, "43"))
+	assert.Len(t, report.Errors, 2)
+	assert.Empty(t, report.OpenFiles)
+}
+
+func TestLastLines(t *testing.T) {
+	assert.Equal(t, []string{}, lastLines(nil, 10))
+	assert.Equal(t, []string{"a", "b"}, lastLines([]byte("a\nb\n"), 10))
+	assert.Equal(t, []string{"b", "c"}, lastLines([]byte("a\nb\nc"), 2))
+
+	var logs strings.Builder
+	for i := 0; i < 1500; i++ {
+		logs.WriteString("line\n")
+	}
+	assert.Len(t, lastLines([]byte(logs.String()), deathLogLines), deathLogLines)
+}
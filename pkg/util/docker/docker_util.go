@@ -11,6 +11,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
@@ -44,6 +45,16 @@ type DockerUtil struct {
 	imageNameBySha map[string]string
 	// event subscribers and state
 	eventState *eventStreamState
+	// admission webhook decisions by container id
+	admissionChecks *containerCheckCache
+	// unreachable ports by container id
+	portChecks *containerCheckCache
+	// containers mounting the BPF filesystem already alerted on, by id
+	bpffsAlerts map[string]struct{}
+	// client of the Kubernetes API, built on first use
+	kubernetesClientOnce sync.Once
+	kubernetesClient     *http.Client
+	kubernetesClientErr  error
 }
 
 // init makes an empty DockerUtil bootstrap itself.
@@ -63,6 +74,47 @@ func (d *DockerUtil) init() error {
 		// TODO: bind them to config entries if relevant
 		CollectNetwork: true,
 		CacheDuration:  10 * time.Second,
+
+		MaxCloseWaitConnections: config.Datadog.GetInt("docker_max_close_wait_connections"),
+		MaxKernelCPUPercent:     config.Datadog.GetFloat64("docker_max_kernel_cpu_percent"),
+		CiliumAPIURL:            config.Datadog.GetString("docker_cilium_api_url"),
+
+		KubernetesAPIURL:                  config.Datadog.GetString("docker_kubernetes_api_url"),
+		ExpectedServiceAccountAnnotations: config.Datadog.GetStringMapString("docker_expected_service_account_annotations"),
+		HPAMetricsEnabled:                 config.Datadog.GetBool("docker_hpa_metrics_enabled"),
+		AdmissionWebhookURL:               config.Datadog.GetString("docker_admission_webhook_url"),
+		FluentdMonitorURL:                 config.Datadog.GetString("docker_fluentd_monitor_url"),
+		FDLeakSlopeThreshold:              config.Datadog.GetFloat64("docker_fd_leak_slope_threshold"),
+		HighPriorityNiceThreshold:         config.Datadog.GetInt("docker_high_priority_nice_threshold"),
+		RequiredIsolatedNamespaces:        config.Datadog.GetStringSlice("docker_required_isolated_namespaces"),
+		RequireRootlessMode:               config.Datadog.GetBool("docker_require_rootless_mode"),
+		SeccompDefaultProfile:             config.Datadog.GetString("docker_seccomp_default_profile"),
+		LearnedSeccompDir:                 config.Datadog.GetString("docker_learned_seccomp_dir"),
+		MaxFragmentationErrorRate:         config.Datadog.GetFloat64("docker_max_fragmentation_error_rate"),
+		JVMProfilingEnabled:               config.Datadog.GetBool("docker_jvm_profiling_enabled"),
+		AsyncProfilerPath:                 config.Datadog.GetString("docker_async_profiler_path"),
+		PageFaultMetricsEnabled:           config.Datadog.GetBool("docker_page_fault_metrics_enabled"),
+		MaxMajorPageFaultsPerSec:          config.Datadog.GetFloat64("docker_max_major_page_faults_per_sec"),
+		MallocTracingEnabled:              config.Datadog.GetBool("docker_malloc_tracing_enabled"),
+		VerifyPortListening:               config.Datadog.GetBool("docker_verify_port_listening"),
+		AlertRootProcesses:                config.Datadog.GetBool("docker_alert_root_processes"),
+		AllowPolicyReload:                 config.Datadog.GetBool("docker_allow_policy_reload"),
+		AllowProfileAugmentation:          config.Datadog.GetBool("docker_allow_profile_augmentation"),
+		AllowSeccompAuditMode:             config.Datadog.GetBool("docker_allow_seccomp_audit_mode"),
+		GPUPowerOptimization:              config.Datadog.GetBool("docker_gpu_power_optimization"),
+		GPUMemoryLeakThreshold:            config.Datadog.GetFloat64("docker_gpu_memory_leak_threshold"),
+		GPUP2PSampleInterval:              config.Datadog.GetDuration("docker_gpu_p2p_sample_interval") * time.Second,
+		GPUECCSingleBitThreshold:          uint64(config.Datadog.GetInt64("docker_gpu_ecc_single_bit_threshold")),
+		AllowPacketCapture:                config.Datadog.GetBool("docker_allow_packet_capture"),
+		MaxCaptureSizeBytes:               config.Datadog.GetInt64("docker_max_capture_size_bytes"),
+		BlockBPFFSMount:                   config.Datadog.GetBool("docker_block_bpffs_mount"),
+		FalcoAPIURL:                       config.Datadog.GetString("docker_falco_api_url"),
+		DeathReportDir:                    config.Datadog.GetString("docker_death_report_dir"),
+		ProbeFailureLookback:              config.Datadog.GetDuration("docker_probe_failure_lookback") * time.Second,
+		ContainerdSocketPath:              config.Datadog.GetString("docker_containerd_socket_path"),
+		ContainerdNamespace:               config.Datadog.GetString("docker_containerd_namespace"),
+		ContainerdSnapshotter:             config.Datadog.GetString("docker_containerd_snapshotter"),
+		AllowedRuntimes:                   config.Datadog.GetStringSlice("docker_allowed_runtimes"),
 	}
 
 	cfg.filter, err = containers.GetSharedFilter()
@@ -70,12 +122,19 @@ func (d *DockerUtil) init() error {
 		return err
 	}
 
+	if err := configureStatsd(); err != nil {
+		log.Warnf("Docker metrics disabled, could not create the statsd client: %v", err)
+	}
+
 	d.cfg = cfg
 	d.cli = cli
 	d.networkMappings = make(map[string][]dockerNetwork)
 	d.imageNameBySha = make(map[string]string)
+	d.bpffsAlerts = make(map[string]struct{})
 	d.lastInvalidate = time.Now()
 	d.eventState = newEventStreamState()
+	d.admissionChecks = newContainerCheckCache(containerCheckTTL)
+	d.portChecks = newContainerCheckCache(containerCheckTTL)
 
 	return nil
 }
@@ -270,3 +329,65 @@ func (d *DockerUtil) AllContainerLabels() (map[string]map[string]string, error)
 
 	return labelMap, nil
 }
+
+// containerProcess identifies the main process of a running container.
+type containerProcess struct {
+	ID   string
+	Name string
+	PID  int
+}
+
+// runningContainerProcesses lists the running containers along with the PID of
+// their main process. Containers which can't be inspected are skipped.
+func (d *DockerUtil) runningContainerProcesses(ctx context.Context) ([]containerProcess, error) {
+	cList, err := d.cli.ContainerList(ctx, types.ContainerListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers: %s", err)
+	}
+	procs := make([]containerProcess, 0, len(cList))
+	for _, c := range cList {
+		i, err := d.Inspect(c.ID, false)
+		if err != nil {
+			log.Debugf("Error inspecting container %s: %s", c.ID, err)
+			continue
+		}
+		if i.State == nil || i.State.Pid == 0 {
+			continue
+		}
+		name := i.Name
+		if len(c.Names) > 0 {
+			name = c.Names[0]
+		}
+		procs = append(procs, containerProcess{
+			ID:   c.ID,
+			Name: strings.TrimPrefix(name, "/"),
+			PID:  i.State.Pid,
+		})
+	}
+	return procs, nil
+}
+
+// containerPID returns the PID of the main process of the container identified by id.
+func (d *DockerUtil) containerPID(id string) (int, error) {
+	i, err := d.Inspect(id, false)
+	if err != nil {
+		return 0, err
+	}
+	if i.State == nil || i.State.Pid == 0 {
+		return 0, fmt.Errorf("container %s is not running", id)
+	}
+	return i.State.Pid, nil
+}
+
+// containerCgroup returns the cgroups of the container identified by id.
+func containerCgroup(id string) (*metrics.ContainerCgroup, error) {
+	cgByContainer, err := metrics.ScrapeAllCgroups()
+	if err != nil {
+		return nil, fmt.Errorf("could not get cgroups: %s", err)
+	}
+	cgroup, ok := cgByContainer[id]
+	if !ok {
+		return nil, fmt.Errorf("no matching cgroups for container %s", id)
+	}
+	return cgroup, nil
+}
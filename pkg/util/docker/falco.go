@@ -0,0 +1,187 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// falcoObservationDuration is how long the syscalls of the processes of a
+// container are observed before validating them against Falco rules.
+const falcoObservationDuration = time.Second
+
+// FalcoReport lists the Falco rules violated by a container.
+type FalcoReport struct {
+	ViolationCount int
+	Violations     []FalcoViolation
+}
+
+// FalcoViolation is a Falco rule violated by a container.
+type FalcoViolation struct {
+	// Rule is the name of the violated rule.
+	Rule string `json:"rule"`
+	// Priority is the priority of the rule, such as Warning or Critical.
+	Priority string `json:"priority"`
+	// Output is the message of the rule describing the violation.
+	Output string `json:"output"`
+}
+
+// falcoValidationRequest is the profile of a container submitted to the rules
+// validation endpoint of the Falco API.
+type falcoValidationRequest struct {
+	RulesFile string         `json:"rules_file"`
+	Container falcoContainer `json:"container"`
+	Processes []falcoProcess `json:"processes"`
+	Syscalls  []string       `json:"syscalls"`
+}
+
+type falcoContainer struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Image      string `json:"image"`
+	Privileged bool   `json:"privileged"`
+}
+
+type falcoProcess struct {
+	PID     int    `json:"pid"`
+	Name    string `json:"name"`
+	Cmdline string `json:"cmdline,omitempty"`
+	Exe     string `json:"exe,omitempty"`
+	UID     int    `json:"uid"`
+}
+
+type falcoValidationResponse struct {
+	Violations []FalcoViolation `json:"violations"`
+}
+
+// CheckFalcoCompliance validates the current processes of the container
+// identified by id, and the syscalls they make, against the Falco rules of
+// rulesFile, using the /api/rules/validate endpoint of the Falco API found at
+// docker_falco_api_url. Syscalls are observed by sampling the threads of the
+// processes for a second. The number of violations is emitted as the
+// datadog.docker.container.falco_violations gauge.
+func (d *DockerUtil) CheckFalcoCompliance(ctx context.Context, id string, rulesFile string) (*FalcoReport, error) {
+	if d.cfg.FalcoAPIURL == "" {
+		return nil, errors.New("no Falco API configured, set docker_falco_api_url to enable it")
+	}
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return nil, err
+	}
+	if c.ContainerJSONBase == nil {
+		return nil, errors.New("invalid container")
+	}
+	cgroup, err := containerCgroup(id)
+	if err != nil {
+		return nil, err
+	}
+	pids := make([]int, len(cgroup.Pids))
+	for i, pid := range cgroup.Pids {
+		pids[i] = int(pid)
+	}
+	procRoot := config.Datadog.GetString("container_proc_root")
+	req := newFalcoValidationRequest(c, rulesFile, procRoot, pids)
+	used := observeSyscalls(ctx, procRoot, pids, falcoObservationDuration, seccompSampleInterval, syscallNames)
+	for syscall := range used {
+		req.Syscalls = append(req.Syscalls, syscall)
+	}
+	sort.Strings(req.Syscalls)
+	return d.falcoCompliance(ctx, c.Name, req)
+}
+
+func (d *DockerUtil) falcoCompliance(ctx context.Context, name string, profile *falcoValidationRequest) (*FalcoReport, error) {
+	body, err := json.Marshal(profile)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(d.cfg.FalcoAPIURL, "/")+"/api/rules/validate", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{Timeout: d.queryTimeout}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error calling the Falco API: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected Falco API response: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var validation falcoValidationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&validation); err != nil {
+		return nil, fmt.Errorf("error decoding the Falco API response: %s", err)
+	}
+
+	report := &FalcoReport{
+		ViolationCount: len(validation.Violations),
+		Violations:     validation.Violations,
+	}
+	gauge("datadog.docker.container.falco_violations", float64(report.ViolationCount), containerTags(profile.Container.ID, name))
+	if report.ViolationCount > 0 {
+		log.Warnf("Container %s violates %d Falco rules of %s, the first one being %q", profile.Container.ID, report.ViolationCount, profile.RulesFile, report.Violations[0].Rule)
+	}
+	return report, nil
+}
+
+// newFalcoValidationRequest returns the profile of c validated against the
+// rules of rulesFile, with the processes found in procRoot among pids.
+func newFalcoValidationRequest(c types.ContainerJSON, rulesFile, procRoot string, pids []int) *falcoValidationRequest {
+	req := &falcoValidationRequest{
+		RulesFile: rulesFile,
+		Container: falcoContainer{
+			ID:    c.ID,
+			Name:  strings.TrimPrefix(c.Name, "/"),
+			Image: c.Image,
+		},
+		Processes: []falcoProcess{},
+		Syscalls:  []string{},
+	}
+	if c.Config != nil {
+		req.Container.Image = c.Config.Image
+	}
+	if c.HostConfig != nil {
+		req.Container.Privileged = c.HostConfig.Privileged
+	}
+	for _, pid := range pids {
+		dir := filepath.Join(procRoot, strconv.Itoa(pid))
+		name, uid, err := processIdentity(filepath.Join(dir, "status"))
+		if err != nil {
+			// the process may have exited since the cgroup was read
+			log.Debugf("Cannot read status of process %d: %s", pid, err)
+			continue
+		}
+		proc := falcoProcess{PID: pid, Name: name, UID: uid}
+		if cmdline, err := ioutil.ReadFile(filepath.Join(dir, "cmdline")); err == nil && len(cmdline) > 0 {
+			proc.Cmdline = strings.Join(strings.Split(string(bytes.TrimRight(cmdline, "\x00")), "\x00"), " ")
+		}
+		if exe, err := os.Readlink(filepath.Join(dir, "exe")); err == nil {
+			proc.Exe = exe
+		}
+		req.Processes = append(req.Processes, proc)
+	}
+	return req
+}
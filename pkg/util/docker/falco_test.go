@@ -0,0 +1,150 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newMockFalcoAPI returns a Falco API whose rules forbid shells and ptrace,
+// and which only knows the falco_rules.yaml rules file.
+func newMockFalcoAPI(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/api/rules/validate", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var req falcoValidationRequest
+		if !assert.NoError(t, json.NewDecoder(r.Body).Decode(&req)) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if req.RulesFile != "/etc/falco/falco_rules.yaml" {
+			http.Error(w, "unknown rules file "+req.RulesFile, http.StatusNotFound)
+			return
+		}
+		resp := falcoValidationResponse{Violations: []FalcoViolation{}}
+		for _, p := range req.Processes {
+			if p.Name == "sh" || p.Name == "bash" {
+				resp.Violations = append(resp.Violations, FalcoViolation{
+					Rule:     "Terminal shell in container",
+					Priority: "Notice",
+					Output:   "A shell was spawned in a container (container=" + req.Container.Name + " shell=" + p.Name + ")",
+				})
+			}
+		}
+		for _, s := range req.Syscalls {
+			if s == "ptrace" {
+				resp.Violations = append(resp.Violations, FalcoViolation{
+					Rule:     "PTRACE attached to process",
+					Priority: "Warning",
+					Output:   "Detected ptrace in a container (container=" + req.Container.Name + ")",
+				})
+			}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func TestFalcoCompliance(t *testing.T) {
+	srv := newMockFalcoAPI(t)
+	defer srv.Close()
+	d := &DockerUtil{cfg: &Config{FalcoAPIURL: srv.URL + "/"}, queryTimeout: time.Second}
+
+	profile := func(rulesFile string, procs []falcoProcess, syscalls ...string) *falcoValidationRequest {
+		return &falcoValidationRequest{
+			RulesFile: rulesFile,
+			Container: falcoContainer{ID: "app", Name: "app", Image: "nginx:1.15"},
+			Processes: procs,
+			Syscalls:  syscalls,
+		}
+	}
+	nginx := falcoProcess{PID: 10, Name: "nginx", UID: 101}
+	shell := falcoProcess{PID: 11, Name: "sh", UID: 0}
+
+	withTestStatsClient(func(stats *testStatsClient) {
+		report, err := d.falcoCompliance(context.Background(), "/app", profile("/etc/falco/falco_rules.yaml", []falcoProcess{nginx}, "accept4", "read"))
+		require.NoError(t, err)
+		assert.Equal(t, &FalcoReport{ViolationCount: 0, Violations: []FalcoViolation{}}, report)
+
+		report, err = d.falcoCompliance(context.Background(), "/app", profile("/etc/falco/falco_rules.yaml", []falcoProcess{nginx, shell}, "ptrace", "read"))
+		require.NoError(t, err)
+		assert.Equal(t, 2, report.ViolationCount)
+		if assert.Len(t, report.Violations, 2) {
+			assert.Equal(t, FalcoViolation{
+				Rule:     "Terminal shell in container",
+				Priority: "Notice",
+				Output:   "A shell was spawned in a container (container=app shell=sh)",
+			}, report.Violations[0])
+			assert.Equal(t, "PTRACE attached to process", report.Violations[1].Rule)
+		}
+
+		tags := []string{"container_id:app", "container_name:app"}
+		assert.Equal(t, []testStatsSample{
+			{Name: "datadog.docker.container.falco_violations", Value: 0, Tags: tags},
+			{Name: "datadog.docker.container.falco_violations", Value: 2, Tags: tags},
+		}, stats.gauges)
+	})
+
+	_, err := d.falcoCompliance(context.Background(), "/app", profile("/etc/falco/missing.yaml", nil))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unknown rules file /etc/falco/missing.yaml")
+	}
+
+	srv.Close()
+	_, err = d.falcoCompliance(context.Background(), "/app", profile("/etc/falco/falco_rules.yaml", nil))
+	assert.Error(t, err)
+}
+
+func TestCheckFalcoComplianceDisabled(t *testing.T) {
+	d := &DockerUtil{cfg: &Config{}}
+	_, err := d.CheckFalcoCompliance(context.Background(), "app", "/etc/falco/falco_rules.yaml")
+	assert.EqualError(t, err, "no Falco API configured, set docker_falco_api_url to enable it")
+}
+
+func TestNewFalcoValidationRequest(t *testing.T) {
+	tempFolder, err := newTempFolder("test-falco")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+
+	require.NoError(t, tempFolder.add("10/status", testProcStatus("nginx", 101, 101)))
+	require.NoError(t, tempFolder.add("10/cmdline", "nginx: master process nginx\x00-g\x00daemon off;\x00"))
+	require.NoError(t, os.Symlink("/usr/sbin/nginx", filepath.Join(tempFolder.RootPath, "10/exe")))
+	require.NoError(t, tempFolder.add("11/status", testProcStatus("sh", 0, 0)))
+
+	c := types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:         "app",
+			Name:       "/app",
+			Image:      "sha256:abc",
+			HostConfig: &container.HostConfig{Privileged: true},
+		},
+		Config: &container.Config{Image: "nginx:1.15"},
+	}
+	req := newFalcoValidationRequest(c, "/etc/falco/falco_rules.yaml", tempFolder.RootPath, []int{10, 11, 12})
+	assert.Equal(t, &falcoValidationRequest{
+		RulesFile: "/etc/falco/falco_rules.yaml",
+		Container: falcoContainer{ID: "app", Name: "app", Image: "nginx:1.15", Privileged: true},
+		Processes: []falcoProcess{
+			{PID: 10, Name: "nginx", Cmdline: "nginx: master process nginx -g daemon off;", Exe: "/usr/sbin/nginx", UID: 101},
+			{PID: 11, Name: "sh", UID: 0},
+		},
+		Syscalls: []string{},
+	}, req)
+}
@@ -0,0 +1,147 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+const (
+	// fdLeakMinRSquared is the coefficient of determination above which the
+	// growth of the number of file descriptors is considered linear.
+	fdLeakMinRSquared = 0.9
+	// fdLeakMinSamples is the minimum number of samples needed to fit a line.
+	fdLeakMinSamples = 3
+)
+
+// fdSampleInterval is the delay between two counts of the open file descriptors.
+var fdSampleInterval = 5 * time.Second
+
+// FDLeakReport describes the steady growth of the number of open file
+// descriptors of a container.
+type FDLeakReport struct {
+	ContainerID string
+	// SlopePerMinute is the growth rate of the number of open file descriptors.
+	SlopePerMinute float64
+	// RSquared is the coefficient of determination of the linear regression the
+	// slope comes from.
+	RSquared float64
+	// FirstCount and LastCount are the numbers of open file descriptors at the
+	// start and the end of the observation period.
+	FirstCount int
+	LastCount  int
+}
+
+// fdSample is the number of open file descriptors at a point in time.
+type fdSample struct {
+	elapsed time.Duration
+	count   int
+}
+
+// DetectFDLeak counts the open file descriptors of the main process of the
+// container identified by id every 5 seconds during observationPeriod, and fits
+// a linear regression on the counts. A report is returned when the slope is above
+// the configured threshold and the growth is linear (R² above 0.9), in which case
+// the datadog.docker.container.fd_leak_detected count is incremented. The report
+// is nil otherwise.
+func (d *DockerUtil) DetectFDLeak(ctx context.Context, id string, observationPeriod time.Duration) (*FDLeakReport, error) {
+	pid, err := d.containerPID(id)
+	if err != nil {
+		return nil, err
+	}
+	fdDir := filepath.Join(config.Datadog.GetString("container_proc_root"), strconv.Itoa(pid), "fd")
+	samples, err := sampleFDCount(ctx, fdDir, observationPeriod)
+	if err != nil {
+		return nil, err
+	}
+	return d.fdLeakReport(id, samples), nil
+}
+
+// sampleFDCount counts the entries of fdDir every fdSampleInterval during period.
+func sampleFDCount(ctx context.Context, fdDir string, period time.Duration) ([]fdSample, error) {
+	n := int(period/fdSampleInterval) + 1
+	samples := make([]fdSample, 0, n)
+	t := time.NewTicker(fdSampleInterval)
+	defer t.Stop()
+	start := time.Now()
+	for {
+		fds, err := ioutil.ReadDir(fdDir)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, fdSample{elapsed: time.Since(start), count: len(fds)})
+		if len(samples) == n {
+			return samples, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// fdLeakReport returns a report when the samples show a file descriptor leak.
+func (d *DockerUtil) fdLeakReport(id string, samples []fdSample) *FDLeakReport {
+	if len(samples) < fdLeakMinSamples {
+		return nil
+	}
+	xs := make([]float64, len(samples))
+	ys := make([]float64, len(samples))
+	for i, s := range samples {
+		xs[i] = s.elapsed.Minutes()
+		ys[i] = float64(s.count)
+	}
+	slope, r2 := linearRegression(xs, ys)
+	if slope <= d.cfg.FDLeakSlopeThreshold || r2 <= fdLeakMinRSquared {
+		return nil
+	}
+
+	report := &FDLeakReport{
+		ContainerID:    id,
+		SlopePerMinute: slope,
+		RSquared:       r2,
+		FirstCount:     samples[0].count,
+		LastCount:      samples[len(samples)-1].count,
+	}
+	count("datadog.docker.container.fd_leak_detected", 1, []string{"container_id:" + id})
+	log.Warnf("Container %s may be leaking file descriptors: %d to %d open, growing by %.1f per minute", id, report.FirstCount, report.LastCount, slope)
+	return report
+}
+
+// linearRegression fits y = slope*x + intercept with the least squares method,
+// and returns the slope along with the coefficient of determination R².
+func linearRegression(xs, ys []float64) (slope, r2 float64) {
+	n := float64(len(xs))
+	var sumX, sumY float64
+	for i := range xs {
+		sumX += xs[i]
+		sumY += ys[i]
+	}
+	meanX, meanY := sumX/n, sumY/n
+	var sxx, sxy, syy float64
+	for i := range xs {
+		dx, dy := xs[i]-meanX, ys[i]-meanY
+		sxx += dx * dx
+		sxy += dx * dy
+		syy += dy * dy
+	}
+	if sxx == 0 || syy == 0 {
+		return 0, 0
+	}
+	slope = sxy / sxx
+	r2 = sxy * sxy / (sxx * syy)
+	return slope, r2
+}
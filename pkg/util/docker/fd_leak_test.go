@@ -0,0 +1,104 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinearRegression(t *testing.T) {
+	slope, r2 := linearRegression([]float64{0, 1, 2, 3}, []float64{5, 7, 9, 11})
+	assert.InDelta(t, 2, slope, 1e-9)
+	assert.InDelta(t, 1, r2, 1e-9)
+
+	slope, r2 = linearRegression([]float64{0, 1, 2, 3}, []float64{5, 5, 5, 5})
+	assert.Equal(t, 0.0, slope)
+	assert.Equal(t, 0.0, r2)
+}
+
+func TestFDLeakReport(t *testing.T) {
+	d := &DockerUtil{cfg: &Config{FDLeakSlopeThreshold: 10}}
+	samples := func(counts ...int) []fdSample {
+		s := make([]fdSample, len(counts))
+		for i, c := range counts {
+			s[i] = fdSample{elapsed: time.Duration(i) * 5 * time.Second, count: c}
+		}
+		return s
+	}
+
+	for name, tc := range map[string]struct {
+		samples []fdSample
+		leak    bool
+	}{
+		// one more FD every 5 seconds is 12 per minute
+		"leak":       {samples(100, 101, 102, 103, 104, 105, 106, 107, 108, 109, 110, 111, 112), true},
+		"slow":       {samples(100, 100, 101, 101, 102, 102, 103, 103, 104, 104, 105, 105, 106), false},
+		"stable":     {samples(100, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100), false},
+		"noisy":      {samples(100, 150, 90, 160, 95, 170, 100, 180, 90, 100, 200, 95, 190), false},
+		"decreasing": {samples(112, 111, 110, 109, 108, 107, 106, 105, 104, 103, 102, 101, 100), false},
+		"too-short":  {samples(100, 110), false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			withTestStatsClient(func(c *testStatsClient) {
+				report := d.fdLeakReport("abc", tc.samples)
+				if !tc.leak {
+					assert.Nil(t, report)
+					assert.Empty(t, c.counts)
+					return
+				}
+				require.NotNil(t, report)
+				assert.Equal(t, "abc", report.ContainerID)
+				assert.InDelta(t, 12, report.SlopePerMinute, 1e-9)
+				assert.InDelta(t, 1, report.RSquared, 1e-9)
+				assert.Equal(t, 100, report.FirstCount)
+				assert.Equal(t, 112, report.LastCount)
+				assert.Equal(t, []testStatsSample{
+					{Name: "datadog.docker.container.fd_leak_detected", Value: 1, Tags: []string{"container_id:abc"}},
+				}, c.counts)
+			})
+		})
+	}
+}
+
+func TestSampleFDCount(t *testing.T) {
+	defer func(d time.Duration) { fdSampleInterval = d }(fdSampleInterval)
+	fdSampleInterval = 10 * time.Millisecond
+
+	tempFolder, err := newTempFolder("test-fd")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, tempFolder.add(fmt.Sprintf("fd/%d", i), ""))
+	}
+	fdDir := filepath.Join(tempFolder.RootPath, "fd")
+
+	samples, err := sampleFDCount(context.Background(), fdDir, 40*time.Millisecond)
+	require.NoError(t, err)
+	require.Len(t, samples, 5)
+	for i, s := range samples {
+		assert.Equal(t, 3, s.count)
+		if i > 0 {
+			assert.True(t, s.elapsed > samples[i-1].elapsed)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = sampleFDCount(ctx, fdDir, time.Minute)
+	assert.Equal(t, context.Canceled, err)
+
+	_, err = sampleFDCount(context.Background(), filepath.Join(tempFolder.RootPath, "missing"), time.Minute)
+	assert.Error(t, err)
+}
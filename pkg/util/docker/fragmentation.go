@@ -0,0 +1,111 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// IPFragStats holds the IPv4 fragmentation counters of the network namespace
+// of a container.
+type IPFragStats struct {
+	// FragmentsReceived is the number of fragments received which needed to
+	// be reassembled (IpReasmReqds).
+	FragmentsReceived uint64
+	// FragmentationErrors is the number of datagrams discarded because of an
+	// invalid destination address (IpInAddrErrors).
+	FragmentationErrors uint64
+}
+
+// GetIPFragmentationStats returns the IPv4 fragmentation counters of the
+// network namespace of the container identified by id, read from the
+// /proc/{pid}/net/snmp file of its main process. They are emitted as the
+// datadog.docker.container.ip.fragments_received and fragmentation_errors
+// gauges, and a warning is logged when the ratio of errors to received
+// fragments exceeds the configured maximum.
+func (d *DockerUtil) GetIPFragmentationStats(ctx context.Context, id string) (*IPFragStats, error) {
+	pid, err := d.containerPID(id)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(config.Datadog.GetString("container_proc_root"), strconv.Itoa(pid), "net", "snmp")
+	return d.ipFragmentationStats(id, path)
+}
+
+func (d *DockerUtil) ipFragmentationStats(id, path string) (*IPFragStats, error) {
+	counters, err := snmpIPCounters(path)
+	if err != nil {
+		return nil, err
+	}
+	stats := &IPFragStats{
+		FragmentsReceived:   counters["ReasmReqds"],
+		FragmentationErrors: counters["InAddrErrors"],
+	}
+	tags := []string{"container_id:" + id}
+	gauge("datadog.docker.container.ip.fragments_received", float64(stats.FragmentsReceived), tags)
+	gauge("datadog.docker.container.ip.fragmentation_errors", float64(stats.FragmentationErrors), tags)
+
+	if stats.FragmentsReceived > 0 {
+		rate := float64(stats.FragmentationErrors) / float64(stats.FragmentsReceived)
+		if rate > d.cfg.MaxFragmentationErrorRate {
+			log.Warnf("Container %s has an IP fragmentation error rate of %.2f%%, its MTU may be misconfigured", id, rate*100)
+		}
+	}
+	return stats, nil
+}
+
+// snmpIPCounters returns the counters of the "Ip:" lines of the given
+// /proc/{pid}/net/snmp file, by name. The first line holds the names of the
+// counters and the second one their values.
+func snmpIPCounters(path string) (map[string]uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "Ip:" {
+			continue
+		}
+		if names == nil {
+			names = fields[1:]
+			continue
+		}
+		values := fields[1:]
+		if len(values) != len(names) {
+			return nil, fmt.Errorf("invalid %s: %d IP counters for %d names", path, len(values), len(names))
+		}
+		counters := make(map[string]uint64, len(names))
+		for i, name := range names {
+			// Forwarding and DefaultTTL are not counters, and may be negative
+			v, err := strconv.ParseUint(values[i], 10, 64)
+			if err != nil {
+				continue
+			}
+			counters[name] = v
+		}
+		return counters, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("no IP counters in %s", path)
+}
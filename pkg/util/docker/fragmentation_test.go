@@ -0,0 +1,87 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSNMP = `Ip: Forwarding DefaultTTL InReceives InHdrErrors InAddrErrors ForwDatagrams InUnknownProtos InDiscards InDelivers OutRequests OutDiscards OutNoRoutes ReasmTimeout ReasmReqds ReasmOKs ReasmFails FragOKs FragFails FragCreates
+Ip: 1 64 1524866 0 %s 0 0 0 1524860 1211345 0 12 0 %s 980 3 0 0 0
+Icmp: InMsgs InErrors InCsumErrors InDestUnreachs
+Icmp: 52 0 0 52
+`
+
+func TestIPFragmentationStats(t *testing.T) {
+	tempFolder, err := newTempFolder("test-fragmentation")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+
+	d := &DockerUtil{cfg: &Config{MaxFragmentationErrorRate: 0.01}}
+	for _, tt := range []struct {
+		name     string
+		snmp     string
+		expected *IPFragStats
+	}{
+		{
+			name:     "healthy",
+			snmp:     testSNMPWith("2", "2000"),
+			expected: &IPFragStats{FragmentsReceived: 2000, FragmentationErrors: 2},
+		},
+		{
+			name:     "misconfigured",
+			snmp:     testSNMPWith("150", "2000"),
+			expected: &IPFragStats{FragmentsReceived: 2000, FragmentationErrors: 150},
+		},
+		{
+			name:     "no fragments",
+			snmp:     testSNMPWith("0", "0"),
+			expected: &IPFragStats{},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, tempFolder.add("snmp", tt.snmp))
+			withTestStatsClient(func(c *testStatsClient) {
+				stats, err := d.ipFragmentationStats("frag", filepath.Join(tempFolder.RootPath, "snmp"))
+				require.NoError(t, err)
+				assert.Equal(t, tt.expected, stats)
+				tags := []string{"container_id:frag"}
+				assert.Equal(t, []testStatsSample{
+					{Name: "datadog.docker.container.ip.fragments_received", Value: float64(tt.expected.FragmentsReceived), Tags: tags},
+					{Name: "datadog.docker.container.ip.fragmentation_errors", Value: float64(tt.expected.FragmentationErrors), Tags: tags},
+				}, c.gauges)
+			})
+		})
+	}
+}
+
+func TestSNMPIPCountersErrors(t *testing.T) {
+	tempFolder, err := newTempFolder("test-fragmentation-errors")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+
+	_, err = snmpIPCounters(filepath.Join(tempFolder.RootPath, "missing"))
+	assert.Error(t, err)
+
+	require.NoError(t, tempFolder.add("no-ip", "Icmp: InMsgs\nIcmp: 52\n"))
+	_, err = snmpIPCounters(filepath.Join(tempFolder.RootPath, "no-ip"))
+	assert.Error(t, err)
+
+	require.NoError(t, tempFolder.add("truncated", "Ip: Forwarding InAddrErrors ReasmReqds\nIp: 1 2\n"))
+	_, err = snmpIPCounters(filepath.Join(tempFolder.RootPath, "truncated"))
+	assert.Error(t, err)
+}
+
+func testSNMPWith(addrErrors, reasmReqds string) string {
+	return fmt.Sprintf(testSNMP, addrErrors, reasmReqds)
+}
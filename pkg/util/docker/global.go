@@ -77,6 +77,127 @@ type Config struct {
 	// Blacklist is the same as whitelist but for exclusion.
 	Blacklist []string
 
+	// MaxCloseWaitConnections is the number of connections in the CLOSE_WAIT
+	// state above which a container is reported as leaking connections.
+	// 0 disables the check.
+	MaxCloseWaitConnections int
+	// MaxKernelCPUPercent is the share of CPU time spent in kernel space, in
+	// percent, above which a container is reported as having a high syscall
+	// overhead.
+	MaxKernelCPUPercent float64
+	// CiliumAPIURL is the address of the Cilium agent API, either a unix socket
+	// (unix:///path/to/cilium.sock) or an HTTP URL.
+	CiliumAPIURL string
+	// KubernetesAPIURL is the address of the Kubernetes API server.
+	KubernetesAPIURL string
+	// ExpectedServiceAccountAnnotations are the annotations the service
+	// accounts of containers are expected to have.
+	ExpectedServiceAccountAnnotations map[string]string
+	// HPAMetricsEnabled allows reporting custom metrics of deployments to the
+	// Kubernetes Custom Metrics API, for Horizontal Pod Autoscalers.
+	HPAMetricsEnabled bool
+	// AdmissionWebhookURL is the address of a Kubernetes admission webhook
+	// running containers are validated against. Empty disables the validation.
+	AdmissionWebhookURL string
+	// FluentdMonitorURL is the address of the Fluentd monitoring API, used to
+	// collect the metrics of the fluentd log driver.
+	FluentdMonitorURL string
+	// FDLeakSlopeThreshold is the growth rate of the number of open file
+	// descriptors, per minute, above which a container is reported as leaking
+	// file descriptors.
+	FDLeakSlopeThreshold float64
+	// HighPriorityNiceThreshold is the nice value below which a process is
+	// considered as having a high priority, and may starve other processes.
+	HighPriorityNiceThreshold int
+	// RequiredIsolatedNamespaces lists the namespaces containers are expected
+	// not to share with the host.
+	RequiredIsolatedNamespaces []string
+	// RequireRootlessMode reports the Docker daemon when it doesn't run in
+	// rootless mode.
+	RequireRootlessMode bool
+	// SeccompDefaultProfile is the path to the seccomp profile applied by the
+	// Docker daemon to the containers not configured with a custom profile.
+	SeccompDefaultProfile string
+	// LearnedSeccompDir is the directory the seccomp profiles learned from
+	// the syscalls of containers are written to.
+	LearnedSeccompDir string
+	// MaxFragmentationErrorRate is the ratio of IP fragmentation errors to
+	// received fragments above which a container is reported as likely
+	// suffering from an MTU misconfiguration.
+	MaxFragmentationErrorRate float64
+	// JVMProfilingEnabled allows profiling the JVMs running in containers
+	// with async-profiler.
+	JVMProfilingEnabled bool
+	// AsyncProfilerPath is the directory of the async-profiler distribution
+	// copied into the profiled containers.
+	AsyncProfilerPath string
+	// PageFaultMetricsEnabled enables collecting the page faults of the
+	// processes of containers, which reads the stat file of each process.
+	PageFaultMetricsEnabled bool
+	// MaxMajorPageFaultsPerSec is the rate of major page faults above which a
+	// container is reported as thrashing. 0 disables the check.
+	MaxMajorPageFaultsPerSec float64
+	// MallocTracingEnabled allows tracing the memory allocations of the
+	// processes of containers with eBPF.
+	MallocTracingEnabled bool
+	// VerifyPortListening enables checking that the ports of containers are
+	// actually listening by connecting to them.
+	VerifyPortListening bool
+	// AlertRootProcesses enables warning about the processes running as root
+	// in containers configured to run as another user.
+	AlertRootProcesses bool
+	// AllowPolicyReload allows replacing the security policies of running
+	// containers, such as their AppArmor profile.
+	AllowPolicyReload bool
+	// AllowProfileAugmentation allows adding deny rules to the AppArmor
+	// profiles of containers.
+	AllowProfileAugmentation bool
+	// AllowSeccompAuditMode allows switching the learned seccomp profiles of
+	// containers to audit mode.
+	AllowSeccompAuditMode bool
+	// GPUPowerOptimization allows lowering the power limit of the GPUs of
+	// containers while they are underused.
+	GPUPowerOptimization bool
+	// GPUMemoryLeakThreshold is the growth rate of the memory usage of a GPU,
+	// in MB per hour, above which a container is reported as leaking GPU
+	// memory.
+	GPUMemoryLeakThreshold float64
+	// GPUP2PSampleInterval is the delay between the two reads of the NVLink
+	// traffic of GPUs peer-to-peer transfer rates are computed from.
+	GPUP2PSampleInterval time.Duration
+	// GPUECCSingleBitThreshold is the number of single-bit ECC errors of a
+	// GPU since its driver was loaded above which its memory is reported as
+	// degrading. Zero disables the warning.
+	GPUECCSingleBitThreshold uint64
+	// AllowPacketCapture allows capturing the network traffic of containers
+	// with tcpdump.
+	AllowPacketCapture bool
+	// MaxCaptureSizeBytes is the size packet captures are cut short at.
+	MaxCaptureSizeBytes int64
+	// BlockBPFFSMount stops collecting the metrics of the containers mounting
+	// the BPF filesystem, as they can load eBPF programs in the kernel.
+	BlockBPFFSMount bool
+	// FalcoAPIURL is the base URL of the Falco API validating the processes
+	// and syscalls of containers against Falco rules.
+	FalcoAPIURL string
+	// DeathReportDir is the directory the state of containers is written to
+	// when they die, if a death hook is attached to them.
+	DeathReportDir string
+	// ProbeFailureLookback is how far back the events of containers are read
+	// when analyzing the failures of their liveness probe.
+	ProbeFailureLookback time.Duration
+	// ContainerdSocketPath is the path to the socket of the containerd daemon
+	// backing the Docker daemon.
+	ContainerdSocketPath string
+	// ContainerdNamespace is the containerd namespace of the Docker daemon.
+	ContainerdNamespace string
+	// ContainerdSnapshotter is the containerd snapshotter whose snapshots
+	// are tracked.
+	ContainerdSnapshotter string
+	// AllowedRuntimes lists the OCI runtimes containers are allowed to run
+	// with. Empty disables the validation.
+	AllowedRuntimes []string
+
 	// internal use only
 	filter *containers.Filter
 }
@@ -0,0 +1,135 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// gpuECCExpiry is the delay after which the errors seen last for a container
+// which was not checked anymore are forgotten.
+const gpuECCExpiry = time.Hour
+
+// gpuECCReader reads the ECC memory errors of the GPUs of the host.
+type gpuECCReader interface {
+	gpuPowerReader
+	// ECCErrors returns the number of single-bit (corrected) and double-bit
+	// (uncorrected) ECC errors of the GPU at index, since the driver was
+	// loaded when volatile is true, or over the lifetime of the GPU otherwise.
+	ECCErrors(index int, volatile bool) (single, double uint64, err error)
+}
+
+// gpuECC reads the ECC errors of GPUs. It is nil unless the agent is built
+// with NVML support.
+var gpuECC gpuECCReader
+
+// GPUECCErrors are the ECC memory errors of the GPU of a container.
+type GPUECCErrors struct {
+	// SingleBitErrors and DoubleBitErrors are the errors since the GPU driver
+	// was loaded.
+	SingleBitErrors, DoubleBitErrors uint64
+	// CumulativeSingleBitErrors and CumulativeDoubleBitErrors are the errors
+	// over the lifetime of the GPU.
+	CumulativeSingleBitErrors, CumulativeDoubleBitErrors uint64
+	DeviceIndex                                          int
+}
+
+// gpuECCObservation is the ECC errors of the GPU of a container seen last.
+type gpuECCObservation struct {
+	last           time.Time
+	single, double uint64
+}
+
+var gpuECCObservations = struct {
+	sync.Mutex
+	byContainer map[string]*gpuECCObservation
+}{byContainer: make(map[string]*gpuECCObservation)}
+
+// GetGPUECCErrors returns the ECC memory errors of the first GPU assigned to
+// the container identified by id by the NVIDIA container runtime, read with
+// NVML. The errors seen since the previous call for the container are emitted
+// as the datadog.docker.container.gpu.ecc_errors count. Double-bit errors
+// can't be corrected and mean the memory of the GPU is failing: an error is
+// logged when new ones are seen. A warning is logged when the single-bit
+// errors since the driver was loaded reach docker_gpu_ecc_single_bit_threshold,
+// unless it is 0. It requires an agent built with NVML support.
+func (d *DockerUtil) GetGPUECCErrors(ctx context.Context, id string) (*GPUECCErrors, error) {
+	if gpuECC == nil {
+		return nil, errors.New("GPU ECC errors require NVML support")
+	}
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return nil, err
+	}
+	return gpuECCErrors(c, gpuECC, d.cfg.GPUECCSingleBitThreshold, time.Now())
+}
+
+func gpuECCErrors(c types.ContainerJSON, reader gpuECCReader, singleBitThreshold uint64, now time.Time) (*GPUECCErrors, error) {
+	if c.ContainerJSONBase == nil || c.Config == nil {
+		return nil, errors.New("invalid container: no config")
+	}
+	index, err := gpuDeviceIndex(c.Config.Env, reader)
+	if err != nil {
+		return nil, err
+	}
+	e := &GPUECCErrors{DeviceIndex: index}
+	if e.SingleBitErrors, e.DoubleBitErrors, err = reader.ECCErrors(index, true); err != nil {
+		return nil, fmt.Errorf("could not get the ECC errors of GPU %d: %s", index, err)
+	}
+	if e.CumulativeSingleBitErrors, e.CumulativeDoubleBitErrors, err = reader.ECCErrors(index, false); err != nil {
+		return nil, fmt.Errorf("could not get the ECC errors of GPU %d: %s", index, err)
+	}
+
+	// all the errors are new when the counters were reset by a reload of the
+	// driver
+	prev := observeGPUECCErrors(c.ID, e, now)
+	newSingle, newDouble := e.SingleBitErrors, e.DoubleBitErrors
+	if prev != nil && e.SingleBitErrors >= prev.single && e.DoubleBitErrors >= prev.double {
+		newSingle -= prev.single
+		newDouble -= prev.double
+	}
+
+	gpuTag := "gpu_index:" + strconv.Itoa(index)
+	count("datadog.docker.container.gpu.ecc_errors", int64(newSingle), append(containerTags(c.ID, c.Name), gpuTag, "error_type:single_bit"))
+	count("datadog.docker.container.gpu.ecc_errors", int64(newDouble), append(containerTags(c.ID, c.Name), gpuTag, "error_type:double_bit"))
+	if newDouble > 0 {
+		log.Errorf("GPU %d of container %s had %d new uncorrectable double-bit ECC errors, %d since the driver was loaded: its memory is failing", index, c.ID, newDouble, e.DoubleBitErrors)
+	}
+	if singleBitThreshold > 0 && e.SingleBitErrors >= singleBitThreshold && e.SingleBitErrors-newSingle < singleBitThreshold {
+		log.Warnf("GPU %d of container %s had %d single-bit ECC errors since the driver was loaded, its memory may be degrading", index, c.ID, e.SingleBitErrors)
+	}
+	return e, nil
+}
+
+// observeGPUECCErrors records the errors e of the GPU of the container
+// identified by id, and returns the ones recorded previously, if any.
+func observeGPUECCErrors(id string, e *GPUECCErrors, now time.Time) *gpuECCObservation {
+	gpuECCObservations.Lock()
+	defer gpuECCObservations.Unlock()
+	for cid, o := range gpuECCObservations.byContainer {
+		if now.Sub(o.last) > gpuECCExpiry {
+			delete(gpuECCObservations.byContainer, cid)
+		}
+	}
+	prev := gpuECCObservations.byContainer[id]
+	gpuECCObservations.byContainer[id] = &gpuECCObservation{
+		last:   now,
+		single: e.SingleBitErrors,
+		double: e.DoubleBitErrors,
+	}
+	return prev
+}
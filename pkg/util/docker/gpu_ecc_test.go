@@ -0,0 +1,117 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testGPUECCReader reports the given ECC error counters by GPU index.
+type testGPUECCReader struct {
+	testGPUPowerReader
+	volatile, aggregate map[int][2]uint64
+	err                 error
+}
+
+func (r *testGPUECCReader) ECCErrors(index int, volatile bool) (uint64, uint64, error) {
+	if r.err != nil {
+		return 0, 0, r.err
+	}
+	counters := r.aggregate
+	if volatile {
+		counters = r.volatile
+	}
+	return counters[index][0], counters[index][1], nil
+}
+
+func TestGPUECCErrors(t *testing.T) {
+	reader := &testGPUECCReader{
+		testGPUPowerReader: testGPUPowerReader{uuids: map[string]int{"GPU-8a9c": 1}},
+		volatile:           map[int][2]uint64{1: {3, 0}},
+		aggregate:          map[int][2]uint64{1: {120, 2}},
+	}
+	c := newTestGPUContainer("ecc1", "NVIDIA_VISIBLE_DEVICES=GPU-8a9c")
+	now := time.Now()
+	tags := func(errorType string) []string {
+		return []string{"container_id:ecc1", "container_name:train", "gpu_index:1", "error_type:" + errorType}
+	}
+
+	withTestStatsClient(func(stats *testStatsClient) {
+		e, err := gpuECCErrors(c, reader, 10, now)
+		require.NoError(t, err)
+		assert.Equal(t, &GPUECCErrors{
+			SingleBitErrors:           3,
+			CumulativeSingleBitErrors: 120,
+			CumulativeDoubleBitErrors: 2,
+			DeviceIndex:               1,
+		}, e)
+		// the errors since the driver was loaded are new on the first call
+		assert.Equal(t, []testStatsSample{
+			{Name: "datadog.docker.container.gpu.ecc_errors", Value: 3, Tags: tags("single_bit")},
+			{Name: "datadog.docker.container.gpu.ecc_errors", Value: 0, Tags: tags("double_bit")},
+		}, stats.counts)
+	})
+
+	reader.volatile[1] = [2]uint64{12, 1}
+	reader.aggregate[1] = [2]uint64{129, 3}
+	withTestStatsClient(func(stats *testStatsClient) {
+		e, err := gpuECCErrors(c, reader, 10, now.Add(time.Minute))
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), e.DoubleBitErrors)
+		assert.Equal(t, uint64(3), e.CumulativeDoubleBitErrors)
+		assert.Equal(t, []testStatsSample{
+			{Name: "datadog.docker.container.gpu.ecc_errors", Value: 9, Tags: tags("single_bit")},
+			{Name: "datadog.docker.container.gpu.ecc_errors", Value: 1, Tags: tags("double_bit")},
+		}, stats.counts)
+	})
+
+	// the volatile counters are reset when the driver is reloaded
+	reader.volatile[1] = [2]uint64{2, 0}
+	withTestStatsClient(func(stats *testStatsClient) {
+		_, err := gpuECCErrors(c, reader, 10, now.Add(2*time.Minute))
+		require.NoError(t, err)
+		assert.Equal(t, []testStatsSample{
+			{Name: "datadog.docker.container.gpu.ecc_errors", Value: 2, Tags: tags("single_bit")},
+			{Name: "datadog.docker.container.gpu.ecc_errors", Value: 0, Tags: tags("double_bit")},
+		}, stats.counts)
+	})
+
+	// the errors seen last are forgotten once expired, and counted again
+	withTestStatsClient(func(stats *testStatsClient) {
+		_, err := gpuECCErrors(c, reader, 10, now.Add(2*time.Minute+gpuECCExpiry+time.Second))
+		require.NoError(t, err)
+		assert.Equal(t, 2.0, stats.counts[0].Value)
+		_, err = gpuECCErrors(newTestGPUContainer("ecc2", "NVIDIA_VISIBLE_DEVICES=1"), reader, 10, now.Add(3*gpuECCExpiry))
+		require.NoError(t, err)
+		gpuECCObservations.Lock()
+		assert.NotContains(t, gpuECCObservations.byContainer, "ecc1")
+		gpuECCObservations.Unlock()
+	})
+}
+
+func TestGPUECCErrorsErrors(t *testing.T) {
+	reader := &testGPUECCReader{err: errors.New("ECC is not enabled")}
+	_, err := gpuECCErrors(newTestGPUContainer("ecc3", "NVIDIA_VISIBLE_DEVICES=0"), reader, 0, time.Now())
+	assert.EqualError(t, err, "could not get the ECC errors of GPU 0: ECC is not enabled")
+	_, err = gpuECCErrors(newTestGPUContainer("ecc3", "NVIDIA_VISIBLE_DEVICES=none"), reader, 0, time.Now())
+	assert.EqualError(t, err, "no GPU assigned to the container")
+	_, err = gpuECCErrors(newTestGPUContainer("ecc3"), reader, 0, time.Now())
+	assert.EqualError(t, err, "no GPU assigned to the container")
+
+	old := gpuECC
+	defer func() { gpuECC = old }()
+	gpuECC = nil
+	_, err = (&DockerUtil{cfg: &Config{}}).GetGPUECCErrors(context.Background(), "ecc3")
+	assert.EqualError(t, err, "GPU ECC errors require NVML support")
+}
@@ -0,0 +1,157 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+const (
+	// gpuMemoryLeakMinRSquared is the coefficient of determination above which
+	// the growth of the GPU memory usage is considered linear.
+	gpuMemoryLeakMinRSquared = 0.9
+	// gpuMemoryLeakMinSamples is the minimum number of samples needed to fit a
+	// line.
+	gpuMemoryLeakMinSamples = 3
+)
+
+// gpuMemorySampleInterval is the delay between two reads of the memory usage
+// of a GPU.
+var gpuMemorySampleInterval = 10 * time.Second
+
+// gpuMemoryReader reads the memory usage of the GPUs of the host.
+type gpuMemoryReader interface {
+	gpuPowerReader
+	// MemoryInfo returns the used and total memory of the GPU at index, in
+	// bytes.
+	MemoryInfo(index int) (used, total uint64, err error)
+}
+
+// gpuMemory reads the memory usage of GPUs. It is nil unless the agent is built
+// with NVML support.
+var gpuMemory gpuMemoryReader
+
+// GPUMemoryLeakReport describes the growth of the memory usage of the GPU of a
+// container.
+type GPUMemoryLeakReport struct {
+	// GrowthRateMBPerHour is the slope of the linear regression of the memory
+	// usage over the observation period.
+	GrowthRateMBPerHour float64
+	// IsLeaking is true when the memory usage grows steadily faster than the
+	// configured threshold.
+	IsLeaking bool
+	// EstimatedOOMInHours is the time left before the GPU runs out of memory at
+	// the current growth rate. It is 0 when the memory usage is not growing.
+	EstimatedOOMInHours float64
+}
+
+// gpuMemorySample is the memory usage of a GPU at a point in time.
+type gpuMemorySample struct {
+	elapsed time.Duration
+	used    uint64
+}
+
+// DetectGPUMemoryLeak reads the memory usage of the first GPU assigned to the
+// container identified by id every 10 seconds during observationPeriod, and
+// fits a linear regression on the samples, to catch ML models loaded repeatedly
+// and never unloaded. The GPU is reported as leaking when its memory usage
+// grows linearly (R² above 0.9) by more than docker_gpu_memory_leak_threshold
+// MB per hour, in which case the
+// datadog.docker.container.gpu.memory_leak_detected count is incremented. The
+// growth rate is emitted as the datadog.docker.container.gpu.memory_growth
+// gauge. It requires an agent built with NVML support.
+func (d *DockerUtil) DetectGPUMemoryLeak(ctx context.Context, id string, observationPeriod time.Duration) (*GPUMemoryLeakReport, error) {
+	if gpuMemory == nil {
+		return nil, errors.New("GPU memory leak detection requires NVML support")
+	}
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return nil, err
+	}
+	return detectGPUMemoryLeak(ctx, c, gpuMemory, observationPeriod, d.cfg.GPUMemoryLeakThreshold)
+}
+
+func detectGPUMemoryLeak(ctx context.Context, c types.ContainerJSON, reader gpuMemoryReader, period time.Duration, thresholdMBPerHour float64) (*GPUMemoryLeakReport, error) {
+	if c.ContainerJSONBase == nil || c.Config == nil {
+		return nil, errors.New("invalid container: no config")
+	}
+	index, err := gpuDeviceIndex(c.Config.Env, reader)
+	if err != nil {
+		return nil, err
+	}
+	samples, total, err := sampleGPUMemory(ctx, reader, index, period)
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) < gpuMemoryLeakMinSamples {
+		return nil, fmt.Errorf("observation period too short, at least %s is needed", gpuMemorySampleInterval*(gpuMemoryLeakMinSamples-1))
+	}
+	report := gpuMemoryLeakReport(samples, total, thresholdMBPerHour)
+
+	tags := append(containerTags(c.ID, c.Name), "gpu_index:"+strconv.Itoa(index))
+	gauge("datadog.docker.container.gpu.memory_growth", report.GrowthRateMBPerHour, tags)
+	if report.IsLeaking {
+		count("datadog.docker.container.gpu.memory_leak_detected", 1, tags)
+		log.Warnf("Container %s may be leaking GPU memory on GPU %d: growing by %.0f MB per hour, out of memory in %.1f hours", c.ID, index, report.GrowthRateMBPerHour, report.EstimatedOOMInHours)
+	}
+	return report, nil
+}
+
+// sampleGPUMemory reads the memory usage of the GPU at index every
+// gpuMemorySampleInterval during period. It returns the samples along with the
+// total memory of the GPU, in bytes.
+func sampleGPUMemory(ctx context.Context, reader gpuMemoryReader, index int, period time.Duration) ([]gpuMemorySample, uint64, error) {
+	n := int(period/gpuMemorySampleInterval) + 1
+	samples := make([]gpuMemorySample, 0, n)
+	t := time.NewTicker(gpuMemorySampleInterval)
+	defer t.Stop()
+	start := time.Now()
+	for {
+		used, total, err := reader.MemoryInfo(index)
+		if err != nil {
+			return nil, 0, fmt.Errorf("could not get the memory usage of GPU %d: %s", index, err)
+		}
+		samples = append(samples, gpuMemorySample{elapsed: time.Since(start), used: used})
+		if len(samples) == n {
+			return samples, total, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// gpuMemoryLeakReport fits a line on the samples of a GPU of the given total
+// memory, in bytes.
+func gpuMemoryLeakReport(samples []gpuMemorySample, total uint64, thresholdMBPerHour float64) *GPUMemoryLeakReport {
+	xs := make([]float64, len(samples))
+	ys := make([]float64, len(samples))
+	for i, s := range samples {
+		xs[i] = s.elapsed.Hours()
+		ys[i] = float64(s.used) / (1 << 20)
+	}
+	slope, r2 := linearRegression(xs, ys)
+	report := &GPUMemoryLeakReport{
+		GrowthRateMBPerHour: slope,
+		IsLeaking:           slope > thresholdMBPerHour && r2 > gpuMemoryLeakMinRSquared,
+	}
+	if last := samples[len(samples)-1].used; slope > 0 && last < total {
+		report.EstimatedOOMInHours = float64(total-last) / (1 << 20) / slope
+	}
+	return report
+}
@@ -0,0 +1,149 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testGPUMemoryReader is a GPU of 16GB replaying the given memory usages, in
+// MB, repeating the last one.
+type testGPUMemoryReader struct {
+	testGPUPowerReader
+	used []uint64
+	err  error
+}
+
+func (r *testGPUMemoryReader) MemoryInfo(index int) (uint64, uint64, error) {
+	if r.err != nil {
+		return 0, 0, r.err
+	}
+	used := r.used[0]
+	if len(r.used) > 1 {
+		r.used = r.used[1:]
+	}
+	return used << 20, 16384 << 20, nil
+}
+
+// testGPUMemorySamples returns memory usages sampled every 10 seconds, in MB.
+func testGPUMemorySamples(used ...uint64) []gpuMemorySample {
+	samples := make([]gpuMemorySample, len(used))
+	for i, u := range used {
+		samples[i] = gpuMemorySample{elapsed: time.Duration(i) * 10 * time.Second, used: u << 20}
+	}
+	return samples
+}
+
+func TestGPUMemoryLeakReport(t *testing.T) {
+	for name, tc := range map[string]struct {
+		used      []uint64
+		threshold float64
+		leaking   bool
+		rate      float64
+		oom       float64
+	}{
+		"model loaded every 10 seconds": {
+			used:      []uint64{2048, 2112, 2176, 2240, 2304, 2368, 2432},
+			threshold: 100,
+			leaking:   true,
+			rate:      64 * 360,
+			oom:       (16384 - 2432) / (64 * 360.),
+		},
+		"stable with noise": {
+			used:      []uint64{4096, 4100, 4090, 4098, 4094, 4092},
+			threshold: 100,
+		},
+		"models loaded and unloaded": {
+			used:      []uint64{2048, 4096, 2048, 4096, 2048},
+			threshold: 100,
+		},
+		"slow leak below the threshold": {
+			used:      []uint64{8192, 8193, 8194, 8195, 8196},
+			threshold: 1000,
+			rate:      360,
+			oom:       (16384 - 8196) / 360.,
+		},
+		"shrinking": {
+			used:      []uint64{8192, 7168, 6144, 5120},
+			threshold: 100,
+			rate:      -1024 * 360,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			report := gpuMemoryLeakReport(testGPUMemorySamples(tc.used...), 16384<<20, tc.threshold)
+			assert.Equal(t, tc.leaking, report.IsLeaking)
+			if tc.rate != 0 {
+				assert.InDelta(t, tc.rate, report.GrowthRateMBPerHour, 1e-6)
+			} else {
+				assert.InDelta(t, 0, report.GrowthRateMBPerHour, 1000)
+			}
+			if tc.oom != 0 {
+				assert.InDelta(t, tc.oom, report.EstimatedOOMInHours, 1e-6)
+			} else if report.GrowthRateMBPerHour <= 0 {
+				assert.Zero(t, report.EstimatedOOMInHours)
+			}
+		})
+	}
+}
+
+func TestDetectGPUMemoryLeak(t *testing.T) {
+	defer func(d time.Duration) { gpuMemorySampleInterval = d }(gpuMemorySampleInterval)
+	gpuMemorySampleInterval = 10 * time.Millisecond
+	c := newTestGPUContainer("train", "NVIDIA_VISIBLE_DEVICES=1")
+
+	withTestStatsClient(func(stats *testStatsClient) {
+		reader := &testGPUMemoryReader{used: []uint64{2048, 2560, 3072, 3584, 4096, 4608}}
+		report, err := detectGPUMemoryLeak(context.Background(), c, reader, 50*time.Millisecond, 100)
+		require.NoError(t, err)
+		assert.True(t, report.IsLeaking)
+		assert.True(t, report.GrowthRateMBPerHour > 100)
+		assert.True(t, report.EstimatedOOMInHours > 0)
+		if assert.Len(t, stats.gauges, 1) {
+			assert.Equal(t, "datadog.docker.container.gpu.memory_growth", stats.gauges[0].Name)
+			assert.Equal(t, []string{"container_id:train", "container_name:train", "gpu_index:1"}, stats.gauges[0].Tags)
+		}
+		if assert.Len(t, stats.counts, 1) {
+			assert.Equal(t, "datadog.docker.container.gpu.memory_leak_detected", stats.counts[0].Name)
+		}
+	})
+
+	withTestStatsClient(func(stats *testStatsClient) {
+		reader := &testGPUMemoryReader{used: []uint64{4096}}
+		report, err := detectGPUMemoryLeak(context.Background(), c, reader, 50*time.Millisecond, 100)
+		require.NoError(t, err)
+		assert.Equal(t, &GPUMemoryLeakReport{}, report)
+		assert.Len(t, stats.gauges, 1)
+		assert.Empty(t, stats.counts)
+	})
+}
+
+func TestDetectGPUMemoryLeakErrors(t *testing.T) {
+	defer func(d time.Duration) { gpuMemorySampleInterval = d }(gpuMemorySampleInterval)
+	gpuMemorySampleInterval = 10 * time.Millisecond
+	c := newTestGPUContainer("train", "NVIDIA_VISIBLE_DEVICES=0")
+
+	_, err := detectGPUMemoryLeak(context.Background(), c, &testGPUMemoryReader{used: []uint64{4096}}, 10*time.Millisecond, 100)
+	assert.EqualError(t, err, "observation period too short, at least 20ms is needed")
+
+	_, err = detectGPUMemoryLeak(context.Background(), c, &testGPUMemoryReader{err: errors.New("GPU is lost")}, time.Second, 100)
+	assert.EqualError(t, err, "could not get the memory usage of GPU 0: GPU is lost")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = detectGPUMemoryLeak(ctx, c, &testGPUMemoryReader{used: []uint64{4096}}, time.Second, 100)
+	assert.Equal(t, context.Canceled, err)
+
+	_, err = detectGPUMemoryLeak(context.Background(), newTestGPUContainer("cpu"), &testGPUMemoryReader{used: []uint64{4096}}, time.Second, 100)
+	assert.EqualError(t, err, "no GPU assigned to the container")
+}
@@ -0,0 +1,159 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// migPartition is a MIG device of a GPU, that is a compute instance of one of
+// its GPU instances.
+type migPartition struct {
+	uuid            string
+	gpuInstance     int
+	computeInstance int
+	// utilization is the utilization of the partition, in percent. It is only
+	// set when hasUtilization is true, NVML not reporting it for every GPU.
+	utilization    uint32
+	hasUtilization bool
+	// memoryUsed is the memory used in the GPU instance, in bytes.
+	memoryUsed uint64
+}
+
+// gpuMIGReader reads the MIG partitions of the GPUs of the host.
+type gpuMIGReader interface {
+	gpuPowerReader
+	// DeviceCount returns the number of GPUs of the host.
+	DeviceCount() (int, error)
+	// MIGPartitions returns the MIG devices of the GPU at index. It returns none
+	// when MIG is disabled.
+	MIGPartitions(index int) ([]migPartition, error)
+}
+
+// gpuMIG reads the MIG partitions of GPUs. It is nil unless the agent is built
+// with NVML support.
+var gpuMIG gpuMIGReader
+
+// MIGPartitionMetric is the usage of a MIG partition of a GPU.
+type MIGPartitionMetric struct {
+	// InstanceID is the UUID of the MIG device, as listed in
+	// NVIDIA_VISIBLE_DEVICES.
+	InstanceID string
+	// GIIndex and CIIndex are the IDs of the GPU instance and of the compute
+	// instance of the partition.
+	GIIndex, CIIndex int
+	// UtilizationPercent is 0 when NVML can't report the utilization of MIG
+	// devices, as on A100 GPUs.
+	UtilizationPercent float64
+	MemoryUsedMB       float64
+}
+
+// GetMIGPartitionMetrics returns the usage of the MIG partitions assigned to
+// the container identified by id by the NVIDIA container runtime: the MIG
+// devices listed in its NVIDIA_VISIBLE_DEVICES variable, or all the partitions
+// of the GPUs listed there. The usage is emitted as the
+// datadog.docker.container.gpu.mig.utilization and
+// datadog.docker.container.gpu.mig.memory_used gauges. It requires an agent
+// built with NVML support.
+func (d *DockerUtil) GetMIGPartitionMetrics(ctx context.Context, id string) ([]MIGPartitionMetric, error) {
+	if gpuMIG == nil {
+		return nil, errors.New("MIG partition metrics require NVML support")
+	}
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return nil, err
+	}
+	return migPartitionMetrics(ctx, c, gpuMIG)
+}
+
+func migPartitionMetrics(ctx context.Context, c types.ContainerJSON, reader gpuMIGReader) ([]MIGPartitionMetric, error) {
+	if c.ContainerJSONBase == nil || c.Config == nil {
+		return nil, errors.New("invalid container: no config")
+	}
+	var devices string
+	for _, e := range c.Config.Env {
+		if strings.HasPrefix(e, nvidiaVisibleDevicesEnv+"=") {
+			devices = strings.TrimPrefix(e, nvidiaVisibleDevicesEnv+"=")
+		}
+	}
+	allGPUs := false
+	gpus := make(map[int]bool)
+	migDevices := make(map[string]bool)
+	for _, device := range strings.Split(devices, ",") {
+		switch device = strings.TrimSpace(device); {
+		case device == "", device == "none", device == "void":
+		case device == "all":
+			allGPUs = true
+		case strings.HasPrefix(device, "MIG-"):
+			migDevices[device] = true
+		default:
+			index, err := strconv.Atoi(device)
+			if err != nil {
+				if index, err = reader.Index(device); err != nil {
+					return nil, fmt.Errorf("could not get the index of GPU %s: %s", device, err)
+				}
+			}
+			gpus[index] = true
+		}
+	}
+	if !allGPUs && len(gpus) == 0 && len(migDevices) == 0 {
+		return nil, errors.New("no GPU assigned to the container")
+	}
+
+	count, err := reader.DeviceCount()
+	if err != nil {
+		return nil, fmt.Errorf("could not get the number of GPUs: %s", err)
+	}
+	var metrics []MIGPartitionMetric
+	for index := 0; index < count; index++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !allGPUs && !gpus[index] && len(migDevices) == 0 {
+			continue
+		}
+		partitions, err := reader.MIGPartitions(index)
+		if err != nil {
+			return nil, fmt.Errorf("could not get the MIG partitions of GPU %d: %s", index, err)
+		}
+		for _, p := range partitions {
+			if !allGPUs && !gpus[index] && !migDevices[p.uuid] {
+				continue
+			}
+			m := MIGPartitionMetric{
+				InstanceID:         p.uuid,
+				GIIndex:            p.gpuInstance,
+				CIIndex:            p.computeInstance,
+				UtilizationPercent: float64(p.utilization),
+				MemoryUsedMB:       float64(p.memoryUsed) / (1 << 20),
+			}
+			metrics = append(metrics, m)
+
+			tags := append(containerTags(c.ID, c.Name),
+				"gpu_index:"+strconv.Itoa(index),
+				"mig_instance:"+p.uuid,
+				"gpu_instance:"+strconv.Itoa(p.gpuInstance),
+				"compute_instance:"+strconv.Itoa(p.computeInstance),
+			)
+			if p.hasUtilization {
+				gauge("datadog.docker.container.gpu.mig.utilization", m.UtilizationPercent, tags)
+			}
+			gauge("datadog.docker.container.gpu.mig.memory_used", m.MemoryUsedMB, tags)
+		}
+	}
+	if len(metrics) == 0 {
+		return nil, errors.New("no MIG partition assigned to the container")
+	}
+	return metrics, nil
+}
@@ -0,0 +1,125 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testGPUMIGReader reports fixed MIG partitions by GPU index.
+type testGPUMIGReader struct {
+	testGPUPowerReader
+	partitions map[int][]migPartition
+	err        error
+}
+
+func (r *testGPUMIGReader) DeviceCount() (int, error) {
+	return 3, nil
+}
+
+func (r *testGPUMIGReader) MIGPartitions(index int) ([]migPartition, error) {
+	return r.partitions[index], r.err
+}
+
+// testMIGHost has an A100 split in a 3g.20gb and two 2g.10gb partitions, an
+// H100 split in two 3g.40gb partitions, and a GPU with MIG disabled.
+func testMIGHost() *testGPUMIGReader {
+	return &testGPUMIGReader{
+		testGPUPowerReader: testGPUPowerReader{uuids: map[string]int{"GPU-h100": 1}},
+		partitions: map[int][]migPartition{
+			0: {
+				{uuid: "MIG-a100-1", gpuInstance: 1, computeInstance: 0, memoryUsed: 10 << 30},
+				{uuid: "MIG-a100-5", gpuInstance: 5, computeInstance: 0, memoryUsed: 512 << 20},
+				{uuid: "MIG-a100-6", gpuInstance: 6, computeInstance: 0},
+			},
+			1: {
+				{uuid: "MIG-h100-1", gpuInstance: 1, computeInstance: 0, memoryUsed: 30 << 30, utilization: 87, hasUtilization: true},
+				{uuid: "MIG-h100-2", gpuInstance: 2, computeInstance: 0, memoryUsed: 1 << 30, utilization: 4, hasUtilization: true},
+			},
+		},
+	}
+}
+
+func TestMIGPartitionMetrics(t *testing.T) {
+	withTestStatsClient(func(c *testStatsClient) {
+		metrics, err := migPartitionMetrics(context.Background(), newTestGPUContainer("gpu1", "NVIDIA_VISIBLE_DEVICES=MIG-a100-5,MIG-h100-1"), testMIGHost())
+		require.NoError(t, err)
+		assert.Equal(t, []MIGPartitionMetric{
+			{InstanceID: "MIG-a100-5", GIIndex: 5, CIIndex: 0, MemoryUsedMB: 512},
+			{InstanceID: "MIG-h100-1", GIIndex: 1, CIIndex: 0, UtilizationPercent: 87, MemoryUsedMB: 30720},
+		}, metrics)
+		assert.Equal(t, []testStatsSample{
+			{
+				Name:  "datadog.docker.container.gpu.mig.memory_used",
+				Value: 512,
+				Tags:  []string{"container_id:gpu1", "container_name:train", "gpu_index:0", "mig_instance:MIG-a100-5", "gpu_instance:5", "compute_instance:0"},
+			},
+			{
+				Name:  "datadog.docker.container.gpu.mig.utilization",
+				Value: 87,
+				Tags:  []string{"container_id:gpu1", "container_name:train", "gpu_index:1", "mig_instance:MIG-h100-1", "gpu_instance:1", "compute_instance:0"},
+			},
+			{
+				Name:  "datadog.docker.container.gpu.mig.memory_used",
+				Value: 30720,
+				Tags:  []string{"container_id:gpu1", "container_name:train", "gpu_index:1", "mig_instance:MIG-h100-1", "gpu_instance:1", "compute_instance:0"},
+			},
+		}, c.gauges)
+	})
+
+	// GPUs are reported with all their partitions
+	for env, ids := range map[string][]string{
+		"NVIDIA_VISIBLE_DEVICES=all":                 {"MIG-a100-1", "MIG-a100-5", "MIG-a100-6", "MIG-h100-1", "MIG-h100-2"},
+		"NVIDIA_VISIBLE_DEVICES=GPU-h100":            {"MIG-h100-1", "MIG-h100-2"},
+		"NVIDIA_VISIBLE_DEVICES=0,MIG-h100-2":        {"MIG-a100-1", "MIG-a100-5", "MIG-a100-6", "MIG-h100-2"},
+		"NVIDIA_VISIBLE_DEVICES=MIG-h100-2, 1, none": {"MIG-h100-1", "MIG-h100-2"},
+	} {
+		metrics, err := migPartitionMetrics(context.Background(), newTestGPUContainer("gpu2", env), testMIGHost())
+		require.NoError(t, err, env)
+		var got []string
+		for _, m := range metrics {
+			got = append(got, m.InstanceID)
+		}
+		assert.Equal(t, ids, got, env)
+	}
+}
+
+func TestMIGPartitionMetricsErrors(t *testing.T) {
+	ctx := context.Background()
+	for env, msg := range map[string]string{
+		"PATH=/bin":                          "no GPU assigned to the container",
+		"NVIDIA_VISIBLE_DEVICES=void":        "no GPU assigned to the container",
+		"NVIDIA_VISIBLE_DEVICES=2":           "no MIG partition assigned to the container",
+		"NVIDIA_VISIBLE_DEVICES=MIG-unknown": "no MIG partition assigned to the container",
+		"NVIDIA_VISIBLE_DEVICES=GPU-unknown": "could not get the index of GPU GPU-unknown: not found",
+	} {
+		_, err := migPartitionMetrics(ctx, newTestGPUContainer("gpu1", env), testMIGHost())
+		assert.EqualError(t, err, msg, env)
+	}
+
+	reader := testMIGHost()
+	reader.err = errors.New("GPU is lost")
+	_, err := migPartitionMetrics(ctx, newTestGPUContainer("gpu1", "NVIDIA_VISIBLE_DEVICES=1"), reader)
+	assert.EqualError(t, err, "could not get the MIG partitions of GPU 1: GPU is lost")
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = migPartitionMetrics(canceled, newTestGPUContainer("gpu1", "NVIDIA_VISIBLE_DEVICES=all"), testMIGHost())
+	assert.Equal(t, context.Canceled, err)
+
+	old := gpuMIG
+	defer func() { gpuMIG = old }()
+	gpuMIG = nil
+	_, err = (&DockerUtil{cfg: &Config{}}).GetMIGPartitionMetrics(ctx, "gpu1")
+	assert.EqualError(t, err, "MIG partition metrics require NVML support")
+}
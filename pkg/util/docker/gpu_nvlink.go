@@ -0,0 +1,114 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// nvLinkBandwidthGBps is the bandwidth of a single NVLink in each direction,
+// in GB/s, by NVLink version.
+var nvLinkBandwidthGBps = map[uint32]float64{
+	1: 20,
+	2: 25,
+	3: 25,
+	4: 25,
+}
+
+// nvLink is an active NVLink of a GPU.
+type nvLink struct {
+	// remote is the index of the GPU at the other end of the link.
+	remote int
+	// version is the NVLink version of the link.
+	version uint32
+}
+
+// gpuNVLinkReader reads the NVLinks of the GPUs of the host.
+type gpuNVLinkReader interface {
+	// DeviceCount returns the number of GPUs of the host.
+	DeviceCount() (int, error)
+	// NVLinks returns the active NVLinks connecting the GPU at index to other
+	// GPUs. The links to NVSwitches are skipped.
+	NVLinks(index int) ([]nvLink, error)
+}
+
+// gpuNVLinks reads the NVLinks of GPUs. It is nil unless the agent is built
+// with NVML support.
+var gpuNVLinks gpuNVLinkReader
+
+// NVLinkTopology describes the GPUs of the host connected through NVLink.
+type NVLinkTopology struct {
+	Connections []NVLinkConnection
+}
+
+// NVLinkConnection is a pair of GPUs connected through one or more NVLinks.
+type NVLinkConnection struct {
+	// GPUA and GPUB are the indexes of the GPUs, GPUA being the lowest.
+	GPUA, GPUB int
+	// Bandwidth is the total bandwidth of the links between the GPUs in each
+	// direction, in GB/s.
+	Bandwidth float64
+}
+
+// GetNVLinkTopology returns the pairs of GPUs of the host connected through
+// NVLink, read with NVML, so that the GPUs of multi-GPU workloads can be picked
+// among the best connected ones. The bandwidth of each pair is emitted as the
+// datadog.docker.gpu.nvlink_bandwidth gauge. It requires an agent built with
+// NVML support.
+func (d *DockerUtil) GetNVLinkTopology(ctx context.Context) (*NVLinkTopology, error) {
+	if gpuNVLinks == nil {
+		return nil, errors.New("NVLink topology requires NVML support")
+	}
+	return nvLinkTopology(ctx, gpuNVLinks)
+}
+
+func nvLinkTopology(ctx context.Context, reader gpuNVLinkReader) (*NVLinkTopology, error) {
+	n, err := reader.DeviceCount()
+	if err != nil {
+		return nil, fmt.Errorf("could not get the number of GPUs: %s", err)
+	}
+	type pair struct{ a, b int }
+	bandwidths := make(map[pair]float64)
+	for index := 0; index < n; index++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		links, err := reader.NVLinks(index)
+		if err != nil {
+			return nil, fmt.Errorf("could not get the NVLinks of GPU %d: %s", index, err)
+		}
+		for _, l := range links {
+			// links are seen from both of their ends, count them from the
+			// GPU of lowest index only
+			if l.remote <= index {
+				continue
+			}
+			bandwidths[pair{index, l.remote}] += nvLinkBandwidthGBps[l.version]
+		}
+	}
+
+	topology := &NVLinkTopology{Connections: make([]NVLinkConnection, 0, len(bandwidths))}
+	for p, bandwidth := range bandwidths {
+		topology.Connections = append(topology.Connections, NVLinkConnection{GPUA: p.a, GPUB: p.b, Bandwidth: bandwidth})
+	}
+	sort.Slice(topology.Connections, func(i, j int) bool {
+		ci, cj := topology.Connections[i], topology.Connections[j]
+		if ci.GPUA != cj.GPUA {
+			return ci.GPUA < cj.GPUA
+		}
+		return ci.GPUB < cj.GPUB
+	})
+	for _, c := range topology.Connections {
+		gauge("datadog.docker.gpu.nvlink_bandwidth", c.Bandwidth, []string{"gpu_a:" + strconv.Itoa(c.GPUA), "gpu_b:" + strconv.Itoa(c.GPUB)})
+	}
+	return topology, nil
+}
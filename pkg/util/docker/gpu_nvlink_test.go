@@ -0,0 +1,96 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testNVLinkReader is a host whose GPUs have the given NVLinks, by index.
+type testNVLinkReader struct {
+	links map[int][]nvLink
+	count int
+	err   error
+}
+
+func (r *testNVLinkReader) DeviceCount() (int, error) { return r.count, nil }
+
+func (r *testNVLinkReader) NVLinks(index int) ([]nvLink, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.links[index], nil
+}
+
+// testNVLinkMesh returns 4 GPUs fully connected through NVLink 3, GPUs 0 and 1
+// as well as GPUs 2 and 3 being connected by 2 links, and the other pairs by a
+// single link.
+func testNVLinkMesh() *testNVLinkReader {
+	link := func(remote int) nvLink { return nvLink{remote: remote, version: 3} }
+	return &testNVLinkReader{
+		count: 4,
+		links: map[int][]nvLink{
+			0: {link(1), link(1), link(2), link(3)},
+			1: {link(0), link(0), link(2), link(3)},
+			2: {link(0), link(1), link(3), link(3)},
+			3: {link(0), link(1), link(2), link(2)},
+		},
+	}
+}
+
+func TestNVLinkTopology(t *testing.T) {
+	withTestStatsClient(func(stats *testStatsClient) {
+		topology, err := nvLinkTopology(context.Background(), testNVLinkMesh())
+		require.NoError(t, err)
+		assert.Equal(t, []NVLinkConnection{
+			{GPUA: 0, GPUB: 1, Bandwidth: 50},
+			{GPUA: 0, GPUB: 2, Bandwidth: 25},
+			{GPUA: 0, GPUB: 3, Bandwidth: 25},
+			{GPUA: 1, GPUB: 2, Bandwidth: 25},
+			{GPUA: 1, GPUB: 3, Bandwidth: 25},
+			{GPUA: 2, GPUB: 3, Bandwidth: 50},
+		}, topology.Connections)
+
+		if assert.Len(t, stats.gauges, 6) {
+			assert.Equal(t, testStatsSample{
+				Name:  "datadog.docker.gpu.nvlink_bandwidth",
+				Value: 50,
+				Tags:  []string{"gpu_a:0", "gpu_b:1"},
+			}, stats.gauges[0])
+		}
+	})
+}
+
+func TestNVLinkTopologyNoLinks(t *testing.T) {
+	topology, err := nvLinkTopology(context.Background(), &testNVLinkReader{count: 2})
+	require.NoError(t, err)
+	assert.Empty(t, topology.Connections)
+}
+
+func TestNVLinkTopologyErrors(t *testing.T) {
+	reader := testNVLinkMesh()
+	reader.err = errors.New("GPU is lost")
+	_, err := nvLinkTopology(context.Background(), reader)
+	assert.EqualError(t, err, "could not get the NVLinks of GPU 0: GPU is lost")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = nvLinkTopology(ctx, testNVLinkMesh())
+	assert.Equal(t, context.Canceled, err)
+
+	old := gpuNVLinks
+	defer func() { gpuNVLinks = old }()
+	gpuNVLinks = nil
+	_, err = (&DockerUtil{cfg: &Config{}}).GetNVLinkTopology(context.Background())
+	assert.EqualError(t, err, "NVLink topology requires NVML support")
+}
@@ -0,0 +1,338 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker,nvml
+
+package docker
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+func init() {
+	if ret := nvml.Init(); ret != nvml.SUCCESS {
+		log.Debugf("NVML not available, GPU power usage is disabled: %s", nvml.ErrorString(ret))
+		return
+	}
+	gpuPower = nvmlPowerReader{}
+	gpuPowerControls = nvmlPowerReader{}
+	gpuMemory = nvmlPowerReader{}
+	gpuNVLinks = nvmlPowerReader{}
+	gpuMIG = nvmlPowerReader{}
+	gpuP2P = nvmlPowerReader{}
+	gpuECC = nvmlPowerReader{}
+}
+
+// nvmlPowerReader reads the power usage of GPUs with NVML.
+type nvmlPowerReader struct{}
+
+// PowerUsage implements gpuPowerReader.
+func (nvmlPowerReader) PowerUsage(index int) (uint32, error) {
+	device, ret := nvml.DeviceGetHandleByIndex(index)
+	if ret != nvml.SUCCESS {
+		return 0, errors.New(nvml.ErrorString(ret))
+	}
+	usage, ret := device.GetPowerUsage()
+	if ret != nvml.SUCCESS {
+		return 0, errors.New(nvml.ErrorString(ret))
+	}
+	return usage, nil
+}
+
+// EnforcedPowerLimit implements gpuPowerReader.
+func (nvmlPowerReader) EnforcedPowerLimit(index int) (uint32, error) {
+	device, ret := nvml.DeviceGetHandleByIndex(index)
+	if ret != nvml.SUCCESS {
+		return 0, errors.New(nvml.ErrorString(ret))
+	}
+	limit, ret := device.GetEnforcedPowerLimit()
+	if ret != nvml.SUCCESS {
+		return 0, errors.New(nvml.ErrorString(ret))
+	}
+	return limit, nil
+}
+
+// Index implements gpuPowerReader.
+func (nvmlPowerReader) Index(uuid string) (int, error) {
+	device, ret := nvml.DeviceGetHandleByUUID(uuid)
+	if ret != nvml.SUCCESS {
+		return 0, errors.New(nvml.ErrorString(ret))
+	}
+	index, ret := device.GetIndex()
+	if ret != nvml.SUCCESS {
+		return 0, errors.New(nvml.ErrorString(ret))
+	}
+	return index, nil
+}
+
+// Utilization implements gpuPowerController.
+func (nvmlPowerReader) Utilization(index int) (uint32, error) {
+	device, ret := nvml.DeviceGetHandleByIndex(index)
+	if ret != nvml.SUCCESS {
+		return 0, errors.New(nvml.ErrorString(ret))
+	}
+	rates, ret := device.GetUtilizationRates()
+	if ret != nvml.SUCCESS {
+		return 0, errors.New(nvml.ErrorString(ret))
+	}
+	return rates.Gpu, nil
+}
+
+// DefaultPowerLimit implements gpuPowerController.
+func (nvmlPowerReader) DefaultPowerLimit(index int) (uint32, error) {
+	device, ret := nvml.DeviceGetHandleByIndex(index)
+	if ret != nvml.SUCCESS {
+		return 0, errors.New(nvml.ErrorString(ret))
+	}
+	limit, ret := device.GetPowerManagementDefaultLimit()
+	if ret != nvml.SUCCESS {
+		return 0, errors.New(nvml.ErrorString(ret))
+	}
+	return limit, nil
+}
+
+// MinPowerLimit implements gpuPowerController.
+func (nvmlPowerReader) MinPowerLimit(index int) (uint32, error) {
+	device, ret := nvml.DeviceGetHandleByIndex(index)
+	if ret != nvml.SUCCESS {
+		return 0, errors.New(nvml.ErrorString(ret))
+	}
+	min, _, ret := device.GetPowerManagementLimitConstraints()
+	if ret != nvml.SUCCESS {
+		return 0, errors.New(nvml.ErrorString(ret))
+	}
+	return min, nil
+}
+
+// SetPowerLimit implements gpuPowerController.
+func (nvmlPowerReader) SetPowerLimit(index int, limit uint32) error {
+	device, ret := nvml.DeviceGetHandleByIndex(index)
+	if ret != nvml.SUCCESS {
+		return errors.New(nvml.ErrorString(ret))
+	}
+	if ret := device.SetPowerManagementLimit(limit); ret != nvml.SUCCESS {
+		return errors.New(nvml.ErrorString(ret))
+	}
+	return nil
+}
+
+// MemoryInfo implements gpuMemoryReader.
+func (nvmlPowerReader) MemoryInfo(index int) (uint64, uint64, error) {
+	device, ret := nvml.DeviceGetHandleByIndex(index)
+	if ret != nvml.SUCCESS {
+		return 0, 0, errors.New(nvml.ErrorString(ret))
+	}
+	memory, ret := device.GetMemoryInfo()
+	if ret != nvml.SUCCESS {
+		return 0, 0, errors.New(nvml.ErrorString(ret))
+	}
+	return memory.Used, memory.Total, nil
+}
+
+// DeviceCount implements gpuNVLinkReader.
+func (nvmlPowerReader) DeviceCount() (int, error) {
+	count, ret := nvml.DeviceGetCount()
+	if ret != nvml.SUCCESS {
+		return 0, errors.New(nvml.ErrorString(ret))
+	}
+	return count, nil
+}
+
+// NVLinks implements gpuNVLinkReader.
+func (nvmlPowerReader) NVLinks(index int) ([]nvLink, error) {
+	device, ret := nvml.DeviceGetHandleByIndex(index)
+	if ret != nvml.SUCCESS {
+		return nil, errors.New(nvml.ErrorString(ret))
+	}
+	var links []nvLink
+	for link := 0; link < nvml.NVLINK_MAX_LINKS; link++ {
+		state, ret := device.GetNvLinkState(link)
+		if ret == nvml.ERROR_NOT_SUPPORTED || ret == nvml.ERROR_INVALID_ARGUMENT {
+			// the GPU has no more links
+			break
+		}
+		if ret != nvml.SUCCESS {
+			return nil, errors.New(nvml.ErrorString(ret))
+		}
+		if state != nvml.FEATURE_ENABLED {
+			continue
+		}
+		pci, ret := device.GetNvLinkRemotePciInfo(link)
+		if ret != nvml.SUCCESS {
+			return nil, errors.New(nvml.ErrorString(ret))
+		}
+		remote, ret := nvml.DeviceGetHandleByPciBusId(fmt.Sprintf("%08X:%02X:%02X.0", pci.Domain, pci.Bus, pci.Device))
+		if ret != nvml.SUCCESS {
+			// the link is connected to an NVSwitch
+			continue
+		}
+		remoteIndex, ret := remote.GetIndex()
+		if ret != nvml.SUCCESS {
+			return nil, errors.New(nvml.ErrorString(ret))
+		}
+		version, ret := device.GetNvLinkVersion(link)
+		if ret != nvml.SUCCESS {
+			return nil, errors.New(nvml.ErrorString(ret))
+		}
+		links = append(links, nvLink{remote: remoteIndex, version: version})
+	}
+	return links, nil
+}
+
+// MIGPartitions implements gpuMIGReader.
+func (nvmlPowerReader) MIGPartitions(index int) ([]migPartition, error) {
+	device, ret := nvml.DeviceGetHandleByIndex(index)
+	if ret != nvml.SUCCESS {
+		return nil, errors.New(nvml.ErrorString(ret))
+	}
+	mode, _, ret := device.GetMigMode()
+	if ret == nvml.ERROR_NOT_SUPPORTED || (ret == nvml.SUCCESS && mode != nvml.DEVICE_MIG_ENABLE) {
+		return nil, nil
+	}
+	if ret != nvml.SUCCESS {
+		return nil, errors.New(nvml.ErrorString(ret))
+	}
+	max, ret := device.GetMaxMigDeviceCount()
+	if ret != nvml.SUCCESS {
+		return nil, errors.New(nvml.ErrorString(ret))
+	}
+	var partitions []migPartition
+	for i := 0; i < max; i++ {
+		mig, ret := device.GetMigDeviceHandleByIndex(i)
+		if ret == nvml.ERROR_NOT_FOUND {
+			// no MIG device was created at this index
+			continue
+		}
+		if ret != nvml.SUCCESS {
+			return nil, errors.New(nvml.ErrorString(ret))
+		}
+		var p migPartition
+		if p.uuid, ret = mig.GetUUID(); ret != nvml.SUCCESS {
+			return nil, errors.New(nvml.ErrorString(ret))
+		}
+		if p.gpuInstance, ret = mig.GetGpuInstanceId(); ret != nvml.SUCCESS {
+			return nil, errors.New(nvml.ErrorString(ret))
+		}
+		if p.computeInstance, ret = mig.GetComputeInstanceId(); ret != nvml.SUCCESS {
+			return nil, errors.New(nvml.ErrorString(ret))
+		}
+		memory, ret := mig.GetMemoryInfo()
+		if ret != nvml.SUCCESS {
+			return nil, errors.New(nvml.ErrorString(ret))
+		}
+		p.memoryUsed = memory.Used
+		switch rates, ret := mig.GetUtilizationRates(); ret {
+		case nvml.SUCCESS:
+			p.utilization, p.hasUtilization = rates.Gpu, true
+		case nvml.ERROR_NOT_SUPPORTED:
+		default:
+			return nil, errors.New(nvml.ErrorString(ret))
+		}
+		partitions = append(partitions, p)
+	}
+	return partitions, nil
+}
+
+// P2PSupported implements gpuP2PReader.
+func (nvmlPowerReader) P2PSupported(a, b int) (bool, error) {
+	deviceA, ret := nvml.DeviceGetHandleByIndex(a)
+	if ret != nvml.SUCCESS {
+		return false, errors.New(nvml.ErrorString(ret))
+	}
+	deviceB, ret := nvml.DeviceGetHandleByIndex(b)
+	if ret != nvml.SUCCESS {
+		return false, errors.New(nvml.ErrorString(ret))
+	}
+	status, ret := deviceA.GetP2PStatus(deviceB, nvml.P2P_CAPS_INDEX_NVLINK)
+	if ret != nvml.SUCCESS {
+		return false, errors.New(nvml.ErrorString(ret))
+	}
+	return status == nvml.P2P_STATUS_OK, nil
+}
+
+// NVLinkTraffic implements gpuP2PReader. The first utilization counter of the
+// links is set to count the bytes of all packets.
+func (nvmlPowerReader) NVLinkTraffic(index int) (map[int]nvLinkTraffic, error) {
+	device, ret := nvml.DeviceGetHandleByIndex(index)
+	if ret != nvml.SUCCESS {
+		return nil, errors.New(nvml.ErrorString(ret))
+	}
+	control := &nvml.NvLinkUtilizationControl{
+		Units:     uint32(nvml.NVLINK_COUNTER_UNIT_BYTES),
+		Pktfilter: uint32(nvml.NVLINK_COUNTER_PKTFILTER_ALL),
+	}
+	traffic := make(map[int]nvLinkTraffic)
+	for link := 0; link < nvml.NVLINK_MAX_LINKS; link++ {
+		state, ret := device.GetNvLinkState(link)
+		if ret == nvml.ERROR_NOT_SUPPORTED || ret == nvml.ERROR_INVALID_ARGUMENT {
+			// the GPU has no more links
+			break
+		}
+		if ret != nvml.SUCCESS {
+			return nil, errors.New(nvml.ErrorString(ret))
+		}
+		if state != nvml.FEATURE_ENABLED {
+			continue
+		}
+		pci, ret := device.GetNvLinkRemotePciInfo(link)
+		if ret != nvml.SUCCESS {
+			return nil, errors.New(nvml.ErrorString(ret))
+		}
+		remote, ret := nvml.DeviceGetHandleByPciBusId(fmt.Sprintf("%08X:%02X:%02X.0", pci.Domain, pci.Bus, pci.Device))
+		if ret != nvml.SUCCESS {
+			// the link is connected to an NVSwitch
+			continue
+		}
+		remoteIndex, ret := remote.GetIndex()
+		if ret != nvml.SUCCESS {
+			return nil, errors.New(nvml.ErrorString(ret))
+		}
+		if ret := device.SetNvLinkUtilizationControl(link, 0, control, false); ret != nvml.SUCCESS {
+			return nil, errors.New(nvml.ErrorString(ret))
+		}
+		rx, tx, ret := device.GetNvLinkUtilizationCounter(link, 0)
+		if ret != nvml.SUCCESS {
+			return nil, errors.New(nvml.ErrorString(ret))
+		}
+		t := traffic[remoteIndex]
+		t.tx += tx
+		t.rx += rx
+		traffic[remoteIndex] = t
+	}
+	return traffic, nil
+}
+
+// ECCErrors implements gpuECCReader.
+func (nvmlPowerReader) ECCErrors(index int, volatile bool) (uint64, uint64, error) {
+	device, ret := nvml.DeviceGetHandleByIndex(index)
+	if ret != nvml.SUCCESS {
+		return 0, 0, errors.New(nvml.ErrorString(ret))
+	}
+	mode, _, ret := device.GetEccMode()
+	if ret == nvml.ERROR_NOT_SUPPORTED || (ret == nvml.SUCCESS && mode != nvml.FEATURE_ENABLED) {
+		return 0, 0, errors.New("ECC is not enabled")
+	}
+	if ret != nvml.SUCCESS {
+		return 0, 0, errors.New(nvml.ErrorString(ret))
+	}
+	counter := nvml.AGGREGATE_ECC
+	if volatile {
+		counter = nvml.VOLATILE_ECC
+	}
+	single, ret := device.GetTotalEccErrors(nvml.MEMORY_ERROR_TYPE_CORRECTED, counter)
+	if ret != nvml.SUCCESS {
+		return 0, 0, errors.New(nvml.ErrorString(ret))
+	}
+	double, ret := device.GetTotalEccErrors(nvml.MEMORY_ERROR_TYPE_UNCORRECTED, counter)
+	if ret != nvml.SUCCESS {
+		return 0, 0, errors.New(nvml.ErrorString(ret))
+	}
+	return single, double, nil
+}
@@ -0,0 +1,44 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker,nvml
+
+package docker
+
+import (
+	"testing"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/stretchr/testify/assert"
+)
+
+// the NVML reader backs all the GPU features
+var (
+	_ gpuPowerReader     = nvmlPowerReader{}
+	_ gpuPowerController = nvmlPowerReader{}
+	_ gpuMemoryReader    = nvmlPowerReader{}
+	_ gpuNVLinkReader    = nvmlPowerReader{}
+	_ gpuMIGReader       = nvmlPowerReader{}
+	_ gpuP2PReader       = nvmlPowerReader{}
+	_ gpuECCReader       = nvmlPowerReader{}
+)
+
+func TestNVMLPowerReader(t *testing.T) {
+	if gpuPower == nil {
+		t.Skip("NVML is not available")
+	}
+	count, ret := nvml.DeviceGetCount()
+	if ret != nvml.SUCCESS || count == 0 {
+		t.Skip("no GPU found")
+	}
+	_, err := gpuPower.PowerUsage(0)
+	assert.NoError(t, err)
+	limit, err := gpuPower.EnforcedPowerLimit(0)
+	assert.NoError(t, err)
+	assert.NotZero(t, limit)
+
+	_, err = gpuPower.PowerUsage(count)
+	assert.Error(t, err, "out of range")
+}
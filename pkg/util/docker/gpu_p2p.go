@@ -0,0 +1,203 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// nvLinkTraffic is the number of bytes sent and received by a GPU over the
+// NVLinks connecting it to another GPU.
+type nvLinkTraffic struct {
+	tx, rx uint64
+}
+
+// gpuP2PReader reads the peer-to-peer traffic between the GPUs of the host.
+type gpuP2PReader interface {
+	gpuPowerReader
+	// DeviceCount returns the number of GPUs of the host.
+	DeviceCount() (int, error)
+	// P2PSupported returns whether the GPUs at indexes a and b can access the
+	// memory of each other over NVLink.
+	P2PSupported(a, b int) (bool, error)
+	// NVLinkTraffic returns the bytes sent and received so far by the GPU at
+	// index over its NVLinks, by index of the GPU at their other end.
+	NVLinkTraffic(index int) (map[int]nvLinkTraffic, error)
+}
+
+// gpuP2P reads the peer-to-peer traffic between GPUs. It is nil unless the
+// agent is built with NVML support.
+var gpuP2P gpuP2PReader
+
+// P2PTransferMetric is the rate of the peer-to-peer memory transfers between
+// two GPUs of a container.
+type P2PTransferMetric struct {
+	// GPUA and GPUB are the indexes of the GPUs, GPUA being the lowest.
+	GPUA, GPUB int
+	// TxMBps is the rate of the transfers from GPUA to GPUB, and RxMBps the
+	// one of the transfers from GPUB to GPUA.
+	TxMBps, RxMBps float64
+}
+
+// GetGPUP2PTransferRate returns the rate of the peer-to-peer memory transfers
+// over NVLink between each pair of the GPUs assigned to the container
+// identified by id which support them, so that slow transfers between the GPUs
+// of distributed training jobs can be spotted. The traffic of the NVLinks of
+// the GPUs is read twice, docker_gpu_p2p_sample_interval apart, and the rates
+// are emitted as the datadog.docker.container.gpu.p2p.tx_rate and
+// datadog.docker.container.gpu.p2p.rx_rate gauges. Transfers over PCIe are not
+// measured. It requires an agent built with NVML support.
+func (d *DockerUtil) GetGPUP2PTransferRate(ctx context.Context, id string) ([]P2PTransferMetric, error) {
+	if gpuP2P == nil {
+		return nil, errors.New("GPU peer-to-peer transfer rates require NVML support")
+	}
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return nil, err
+	}
+	return gpuP2PTransferRates(ctx, c, gpuP2P, d.cfg.GPUP2PSampleInterval)
+}
+
+func gpuP2PTransferRates(ctx context.Context, c types.ContainerJSON, reader gpuP2PReader, interval time.Duration) ([]P2PTransferMetric, error) {
+	if c.ContainerJSONBase == nil || c.Config == nil {
+		return nil, errors.New("invalid container: no config")
+	}
+	indexes, err := gpuDeviceIndexes(c.Config.Env, reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(indexes) < 2 {
+		return nil, errors.New("peer-to-peer transfers need at least 2 GPUs assigned to the container")
+	}
+	type pair struct{ a, b int }
+	var pairs []pair
+	for i, a := range indexes {
+		for _, b := range indexes[i+1:] {
+			ok, err := reader.P2PSupported(a, b)
+			if err != nil {
+				return nil, fmt.Errorf("could not get the peer-to-peer status of GPUs %d and %d: %s", a, b, err)
+			}
+			if ok {
+				pairs = append(pairs, pair{a, b})
+			}
+		}
+	}
+	if len(pairs) == 0 {
+		return nil, errors.New("no GPUs of the container support peer-to-peer transfers over NVLink")
+	}
+
+	// the traffic between two GPUs is read from the one of lowest index
+	read := func() (map[int]map[int]nvLinkTraffic, error) {
+		traffic := make(map[int]map[int]nvLinkTraffic)
+		for _, p := range pairs {
+			if _, ok := traffic[p.a]; ok {
+				continue
+			}
+			t, err := reader.NVLinkTraffic(p.a)
+			if err != nil {
+				return nil, fmt.Errorf("could not get the NVLink traffic of GPU %d: %s", p.a, err)
+			}
+			traffic[p.a] = t
+		}
+		return traffic, nil
+	}
+	before, err := read()
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+	}
+	after, err := read()
+	if err != nil {
+		return nil, err
+	}
+	seconds := time.Since(start).Seconds()
+
+	metrics := make([]P2PTransferMetric, 0, len(pairs))
+	for _, p := range pairs {
+		m := P2PTransferMetric{
+			GPUA:   p.a,
+			GPUB:   p.b,
+			TxMBps: transferRate(before[p.a][p.b].tx, after[p.a][p.b].tx, seconds),
+			RxMBps: transferRate(before[p.a][p.b].rx, after[p.a][p.b].rx, seconds),
+		}
+		metrics = append(metrics, m)
+
+		tags := append(containerTags(c.ID, c.Name), "gpu_a:"+strconv.Itoa(p.a), "gpu_b:"+strconv.Itoa(p.b))
+		gauge("datadog.docker.container.gpu.p2p.tx_rate", m.TxMBps, tags)
+		gauge("datadog.docker.container.gpu.p2p.rx_rate", m.RxMBps, tags)
+	}
+	return metrics, nil
+}
+
+// transferRate returns the rate, in MB per second, of the transfers counted
+// by a byte counter going from before to after in the given number of seconds.
+// Counters reset in between give no rate.
+func transferRate(before, after uint64, seconds float64) float64 {
+	if after < before || seconds <= 0 {
+		return 0
+	}
+	return float64(after-before) / (1 << 20) / seconds
+}
+
+// gpuDeviceIndexes returns the sorted indexes of the GPUs listed in the
+// NVIDIA_VISIBLE_DEVICES variable of the given environment. MIG devices are
+// skipped.
+func gpuDeviceIndexes(env []string, reader gpuP2PReader) ([]int, error) {
+	var devices string
+	for _, e := range env {
+		if strings.HasPrefix(e, nvidiaVisibleDevicesEnv+"=") {
+			devices = strings.TrimPrefix(e, nvidiaVisibleDevicesEnv+"=")
+		}
+	}
+	gpus := make(map[int]bool)
+	for _, device := range strings.Split(devices, ",") {
+		switch device = strings.TrimSpace(device); {
+		case device == "", device == "none", device == "void", strings.HasPrefix(device, "MIG-"):
+		case device == "all":
+			count, err := reader.DeviceCount()
+			if err != nil {
+				return nil, fmt.Errorf("could not get the number of GPUs: %s", err)
+			}
+			for index := 0; index < count; index++ {
+				gpus[index] = true
+			}
+		default:
+			index, err := strconv.Atoi(device)
+			if err != nil {
+				if index, err = reader.Index(device); err != nil {
+					return nil, fmt.Errorf("could not get the index of GPU %s: %s", device, err)
+				}
+			}
+			gpus[index] = true
+		}
+	}
+	if len(gpus) == 0 {
+		return nil, errors.New("no GPU assigned to the container")
+	}
+	indexes := make([]int, 0, len(gpus))
+	for index := range gpus {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	return indexes, nil
+}
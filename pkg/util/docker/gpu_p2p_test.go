@@ -0,0 +1,140 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testGPUP2PReader is a host whose GPUs send and receive the given traffic
+// over their NVLinks between each read, by GPU index and remote GPU index.
+type testGPUP2PReader struct {
+	testGPUPowerReader
+	count   int
+	peers   map[[2]int]bool
+	traffic map[int]map[int]nvLinkTraffic
+	reads   map[int]uint64
+	err     error
+}
+
+func (r *testGPUP2PReader) DeviceCount() (int, error) { return r.count, nil }
+
+func (r *testGPUP2PReader) P2PSupported(a, b int) (bool, error) {
+	return r.peers[[2]int{a, b}], nil
+}
+
+func (r *testGPUP2PReader) NVLinkTraffic(index int) (map[int]nvLinkTraffic, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	r.reads[index]++
+	traffic := make(map[int]nvLinkTraffic)
+	for remote, t := range r.traffic[index] {
+		traffic[remote] = nvLinkTraffic{tx: t.tx * r.reads[index], rx: t.rx * r.reads[index]}
+	}
+	return traffic, nil
+}
+
+// testP2PHost has 4 GPUs, GPUs 0 and 1 as well as GPUs 2 and 3 supporting
+// peer-to-peer transfers.
+func testP2PHost() *testGPUP2PReader {
+	return &testGPUP2PReader{
+		testGPUPowerReader: testGPUPowerReader{uuids: map[string]int{"GPU-3": 3}},
+		count:              4,
+		peers:              map[[2]int]bool{{0, 1}: true, {2, 3}: true},
+		traffic: map[int]map[int]nvLinkTraffic{
+			0: {1: {tx: 400 << 20, rx: 100 << 20}},
+			2: {3: {tx: 0, rx: 200 << 20}},
+		},
+		reads: make(map[int]uint64),
+	}
+}
+
+func TestGPUP2PTransferRates(t *testing.T) {
+	interval := 20 * time.Millisecond
+	withTestStatsClient(func(c *testStatsClient) {
+		reader := testP2PHost()
+		metrics, err := gpuP2PTransferRates(context.Background(), newTestGPUContainer("gpu1", "NVIDIA_VISIBLE_DEVICES=all"), reader, interval)
+		require.NoError(t, err)
+		require.Len(t, metrics, 2)
+		assert.Equal(t, map[int]uint64{0: 2, 2: 2}, reader.reads, "each GPU is read twice")
+
+		// the rates can't exceed the traffic over the interval
+		assert.Equal(t, 0, metrics[0].GPUA)
+		assert.Equal(t, 1, metrics[0].GPUB)
+		assert.True(t, metrics[0].TxMBps > 0 && metrics[0].TxMBps <= 400/interval.Seconds(), metrics[0].TxMBps)
+		assert.InDelta(t, 4, metrics[0].TxMBps/metrics[0].RxMBps, 1e-9)
+		assert.Equal(t, 2, metrics[1].GPUA)
+		assert.Equal(t, 3, metrics[1].GPUB)
+		assert.Equal(t, 0.0, metrics[1].TxMBps)
+		assert.True(t, metrics[1].RxMBps > 0 && metrics[1].RxMBps <= 200/interval.Seconds(), metrics[1].RxMBps)
+
+		if assert.Len(t, c.gauges, 4) {
+			assert.Equal(t, testStatsSample{
+				Name:  "datadog.docker.container.gpu.p2p.tx_rate",
+				Value: metrics[0].TxMBps,
+				Tags:  []string{"container_id:gpu1", "container_name:train", "gpu_a:0", "gpu_b:1"},
+			}, c.gauges[0])
+			assert.Equal(t, testStatsSample{
+				Name:  "datadog.docker.container.gpu.p2p.rx_rate",
+				Value: metrics[1].RxMBps,
+				Tags:  []string{"container_id:gpu1", "container_name:train", "gpu_a:2", "gpu_b:3"},
+			}, c.gauges[3])
+		}
+	})
+
+	// only the pairs of GPUs of the container are measured
+	metrics, err := gpuP2PTransferRates(context.Background(), newTestGPUContainer("gpu1", "NVIDIA_VISIBLE_DEVICES=GPU-3,2,MIG-a100-1"), testP2PHost(), time.Millisecond)
+	require.NoError(t, err)
+	if assert.Len(t, metrics, 1) {
+		assert.Equal(t, 2, metrics[0].GPUA)
+		assert.Equal(t, 3, metrics[0].GPUB)
+	}
+}
+
+func TestGPUP2PTransferRatesErrors(t *testing.T) {
+	ctx := context.Background()
+	for env, msg := range map[string]string{
+		"PATH=/bin":                          "no GPU assigned to the container",
+		"NVIDIA_VISIBLE_DEVICES=MIG-a100-1":  "no GPU assigned to the container",
+		"NVIDIA_VISIBLE_DEVICES=1":           "peer-to-peer transfers need at least 2 GPUs assigned to the container",
+		"NVIDIA_VISIBLE_DEVICES=0,2":         "no GPUs of the container support peer-to-peer transfers over NVLink",
+		"NVIDIA_VISIBLE_DEVICES=GPU-unknown": "could not get the index of GPU GPU-unknown: not found",
+	} {
+		_, err := gpuP2PTransferRates(ctx, newTestGPUContainer("gpu1", env), testP2PHost(), time.Millisecond)
+		assert.EqualError(t, err, msg, env)
+	}
+
+	reader := testP2PHost()
+	reader.err = errors.New("GPU is lost")
+	_, err := gpuP2PTransferRates(ctx, newTestGPUContainer("gpu1", "NVIDIA_VISIBLE_DEVICES=0,1"), reader, time.Millisecond)
+	assert.EqualError(t, err, "could not get the NVLink traffic of GPU 0: GPU is lost")
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = gpuP2PTransferRates(canceled, newTestGPUContainer("gpu1", "NVIDIA_VISIBLE_DEVICES=0,1"), testP2PHost(), time.Hour)
+	assert.Equal(t, context.Canceled, err)
+
+	old := gpuP2P
+	defer func() { gpuP2P = old }()
+	gpuP2P = nil
+	_, err = (&DockerUtil{cfg: &Config{}}).GetGPUP2PTransferRate(ctx, "gpu1")
+	assert.EqualError(t, err, "GPU peer-to-peer transfer rates require NVML support")
+}
+
+func TestTransferRate(t *testing.T) {
+	assert.Equal(t, 50.0, transferRate(100<<20, 200<<20, 2))
+	assert.Equal(t, 0.0, transferRate(200<<20, 100<<20, 2), "counters were reset")
+	assert.Equal(t, 0.0, transferRate(100<<20, 200<<20, 0))
+}
@@ -0,0 +1,154 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+const (
+	// nvidiaVisibleDevicesEnv is the environment variable used by the NVIDIA
+	// container runtime to select the GPUs of a container.
+	nvidiaVisibleDevicesEnv = "NVIDIA_VISIBLE_DEVICES"
+	// gpuEnergyExpiry is the delay after which the energy of a container which
+	// was not sampled anymore is forgotten.
+	gpuEnergyExpiry = time.Hour
+)
+
+// gpuPowerReader reads the power usage of the GPUs of the host.
+type gpuPowerReader interface {
+	// PowerUsage returns the power usage of the GPU at index, in milliwatts.
+	PowerUsage(index int) (uint32, error)
+	// EnforcedPowerLimit returns the power limit of the GPU at index, in milliwatts.
+	EnforcedPowerLimit(index int) (uint32, error)
+	// Index returns the index of the GPU identified by uuid.
+	Index(uuid string) (int, error)
+}
+
+// gpuPower reads the power usage of GPUs. It is nil unless the agent is built
+// with NVML support.
+var gpuPower gpuPowerReader
+
+// GPUPowerInfo is the power usage of the GPU of a container.
+type GPUPowerInfo struct {
+	CurrentWatts float64
+	LimitWatts   float64
+	DeviceIndex  int
+	// WattHours is the energy consumed by the GPU since the first call to
+	// GetGPUPowerUsage for the container, integrated over the samples.
+	WattHours float64
+}
+
+// gpuEnergy integrates the power samples of the GPU of a container.
+type gpuEnergy struct {
+	last      time.Time
+	watts     float64
+	wattHours float64
+}
+
+var gpuEnergies = struct {
+	sync.Mutex
+	byContainer map[string]*gpuEnergy
+}{byContainer: make(map[string]*gpuEnergy)}
+
+// GetGPUPowerUsage returns the power usage and limit of the first GPU assigned
+// to the container identified by id by the NVIDIA container runtime, read with
+// NVML. The energy consumed by the GPU is integrated across calls using the
+// trapezoidal rule. The power usage is emitted as the
+// datadog.docker.container.gpu.power_watts gauge.
+func (d *DockerUtil) GetGPUPowerUsage(ctx context.Context, id string) (*GPUPowerInfo, error) {
+	if gpuPower == nil {
+		return nil, errors.New("GPU power usage requires NVML support")
+	}
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return nil, err
+	}
+	return gpuPowerUsage(c, gpuPower, time.Now())
+}
+
+func gpuPowerUsage(c types.ContainerJSON, reader gpuPowerReader, now time.Time) (*GPUPowerInfo, error) {
+	if c.ContainerJSONBase == nil || c.Config == nil {
+		return nil, errors.New("invalid container: no config")
+	}
+	index, err := gpuDeviceIndex(c.Config.Env, reader)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := reader.PowerUsage(index)
+	if err != nil {
+		return nil, fmt.Errorf("could not get the power usage of GPU %d: %s", index, err)
+	}
+	limit, err := reader.EnforcedPowerLimit(index)
+	if err != nil {
+		return nil, fmt.Errorf("could not get the power limit of GPU %d: %s", index, err)
+	}
+	info := &GPUPowerInfo{
+		CurrentWatts: float64(usage) / 1000,
+		LimitWatts:   float64(limit) / 1000,
+		DeviceIndex:  index,
+	}
+	info.WattHours = integrateGPUEnergy(c.ID, info.CurrentWatts, now)
+
+	tags := append(containerTags(c.ID, c.Name), "gpu_index:"+strconv.Itoa(index))
+	gauge("datadog.docker.container.gpu.power_watts", info.CurrentWatts, tags)
+	return info, nil
+}
+
+// gpuDeviceIndex returns the index of the first GPU listed in the
+// NVIDIA_VISIBLE_DEVICES variable of the given environment.
+func gpuDeviceIndex(env []string, reader gpuPowerReader) (int, error) {
+	var devices string
+	for _, e := range env {
+		if strings.HasPrefix(e, nvidiaVisibleDevicesEnv+"=") {
+			devices = strings.TrimPrefix(e, nvidiaVisibleDevicesEnv+"=")
+		}
+	}
+	device := strings.TrimSpace(strings.Split(devices, ",")[0])
+	switch device {
+	case "", "none", "void":
+		return 0, errors.New("no GPU assigned to the container")
+	case "all":
+		return 0, nil
+	}
+	if index, err := strconv.Atoi(device); err == nil {
+		return index, nil
+	}
+	return reader.Index(device)
+}
+
+// integrateGPUEnergy adds the energy consumed since the previous sample of the
+// container and returns the total energy consumed, in watt-hours.
+func integrateGPUEnergy(id string, watts float64, now time.Time) float64 {
+	gpuEnergies.Lock()
+	defer gpuEnergies.Unlock()
+	for cid, e := range gpuEnergies.byContainer {
+		if now.Sub(e.last) > gpuEnergyExpiry {
+			delete(gpuEnergies.byContainer, cid)
+		}
+	}
+	e, ok := gpuEnergies.byContainer[id]
+	if !ok {
+		gpuEnergies.byContainer[id] = &gpuEnergy{last: now, watts: watts}
+		return 0
+	}
+	if elapsed := now.Sub(e.last); elapsed > 0 {
+		e.wattHours += (e.watts + watts) / 2 * elapsed.Hours()
+	}
+	e.last = now
+	e.watts = watts
+	return e.wattHours
+}
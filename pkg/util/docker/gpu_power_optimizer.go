@@ -0,0 +1,121 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// gpuPowerLimitStep is the fraction of the power limit of an underused GPU
+// removed at each optimization.
+const gpuPowerLimitStep = 0.1
+
+// gpuPowerController changes the power limit of the GPUs of the host.
+type gpuPowerController interface {
+	gpuPowerReader
+	// Utilization returns the percentage of time the GPU at index was busy
+	// over the last sample period.
+	Utilization(index int) (uint32, error)
+	// DefaultPowerLimit returns the default power limit of the GPU at index,
+	// in milliwatts.
+	DefaultPowerLimit(index int) (uint32, error)
+	// MinPowerLimit returns the lowest power limit the GPU at index accepts,
+	// in milliwatts.
+	MinPowerLimit(index int) (uint32, error)
+	// SetPowerLimit sets the power limit of the GPU at index, in milliwatts.
+	SetPowerLimit(index int, limit uint32) error
+}
+
+// gpuPowerControls changes the power limit of GPUs. It is nil unless the agent
+// is built with NVML support.
+var gpuPowerControls gpuPowerController
+
+// OptimizeGPUPower lowers the power limit of the first GPU assigned to the
+// container identified by id by 10%, down to the lowest limit it accepts, when
+// its utilization is below targetUtilization, a ratio between 0 and 1. The
+// default power limit is restored as soon as the utilization reaches the
+// target. It is meant to be called periodically. Power limit changes are
+// logged for audit. It requires docker_gpu_power_optimization and an agent
+// built with NVML support.
+func (d *DockerUtil) OptimizeGPUPower(ctx context.Context, id string, targetUtilization float64) error {
+	if !d.cfg.GPUPowerOptimization {
+		return errors.New("GPU power optimization is disabled, set docker_gpu_power_optimization to enable it")
+	}
+	if gpuPowerControls == nil {
+		return errors.New("GPU power optimization requires NVML support")
+	}
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return err
+	}
+	return optimizeGPUPower(c, gpuPowerControls, targetUtilization)
+}
+
+func optimizeGPUPower(c types.ContainerJSON, controller gpuPowerController, targetUtilization float64) error {
+	if targetUtilization <= 0 || targetUtilization > 1 {
+		return fmt.Errorf("invalid target utilization %g, it must be between 0 and 1", targetUtilization)
+	}
+	if c.ContainerJSONBase == nil || c.Config == nil {
+		return errors.New("invalid container: no config")
+	}
+	index, err := gpuDeviceIndex(c.Config.Env, controller)
+	if err != nil {
+		return err
+	}
+	utilization, err := controller.Utilization(index)
+	if err != nil {
+		return fmt.Errorf("could not get the utilization of GPU %d: %s", index, err)
+	}
+	current, err := controller.EnforcedPowerLimit(index)
+	if err != nil {
+		return fmt.Errorf("could not get the power limit of GPU %d: %s", index, err)
+	}
+	full, err := controller.DefaultPowerLimit(index)
+	if err != nil {
+		return fmt.Errorf("could not get the default power limit of GPU %d: %s", index, err)
+	}
+
+	var limit uint32
+	if float64(utilization)/100 < targetUtilization {
+		min, err := controller.MinPowerLimit(index)
+		if err != nil {
+			return fmt.Errorf("could not get the minimum power limit of GPU %d: %s", index, err)
+		}
+		limit = uint32(float64(current) * (1 - gpuPowerLimitStep))
+		if limit < min {
+			limit = min
+		}
+	} else {
+		limit = full
+	}
+	if limit == current {
+		return nil
+	}
+	err = controller.SetPowerLimit(index, limit)
+	auditGPUPowerLimit(c.ID, index, utilization, current, limit, err)
+	if err != nil {
+		return fmt.Errorf("could not set the power limit of GPU %d: %s", index, err)
+	}
+	return nil
+}
+
+// auditGPUPowerLimit logs the outcome of a change of the power limit of the
+// GPU of a container.
+func auditGPUPowerLimit(id string, index int, utilization, previous, limit uint32, err error) {
+	if err != nil {
+		log.Warnf("Audit: GPU power limit change failed: container_id=%s gpu_index=%d utilization=%d%% previous_limit_mw=%d limit_mw=%d error=%q", id, index, utilization, previous, limit, err)
+		return
+	}
+	log.Infof("Audit: GPU power limit changed: container_id=%s gpu_index=%d utilization=%d%% previous_limit_mw=%d limit_mw=%d", id, index, utilization, previous, limit)
+}
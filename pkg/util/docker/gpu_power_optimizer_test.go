@@ -0,0 +1,98 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testGPUPowerController is a single GPU of a 300W default power limit, which
+// accepts limits down to 150W.
+type testGPUPowerController struct {
+	testGPUPowerReader
+	utilization uint32
+	setErr      error
+	set         []uint32 // power limits set, in milliwatts
+}
+
+func newTestGPUPowerController(utilization uint32) *testGPUPowerController {
+	return &testGPUPowerController{
+		testGPUPowerReader: testGPUPowerReader{usage: map[int]uint32{0: 100000}, limit: 300000},
+		utilization:        utilization,
+	}
+}
+
+func (c *testGPUPowerController) Utilization(index int) (uint32, error) {
+	return c.utilization, nil
+}
+
+func (c *testGPUPowerController) DefaultPowerLimit(index int) (uint32, error) {
+	return 300000, nil
+}
+
+func (c *testGPUPowerController) MinPowerLimit(index int) (uint32, error) {
+	return 150000, nil
+}
+
+func (c *testGPUPowerController) SetPowerLimit(index int, limit uint32) error {
+	if c.setErr != nil {
+		return c.setErr
+	}
+	c.set = append(c.set, limit)
+	c.limit = limit
+	return nil
+}
+
+func TestOptimizeGPUPower(t *testing.T) {
+	c := newTestGPUContainer("train", "NVIDIA_VISIBLE_DEVICES=0")
+	controller := newTestGPUPowerController(20)
+
+	// the GPU is idle: the limit is lowered by 10% at each call, down to the
+	// minimum
+	for i := 0; i < 8; i++ {
+		require.NoError(t, optimizeGPUPower(c, controller, 0.5))
+	}
+	assert.Equal(t, []uint32{270000, 243000, 218700, 196830, 177147, 159432, 150000}, controller.set)
+
+	// the GPU is busy again: full power is restored at once
+	controller.set = nil
+	controller.utilization = 80
+	require.NoError(t, optimizeGPUPower(c, controller, 0.5))
+	require.NoError(t, optimizeGPUPower(c, controller, 0.5))
+	assert.Equal(t, []uint32{300000}, controller.set)
+
+	// exactly at the target
+	controller.utilization = 50
+	require.NoError(t, optimizeGPUPower(c, controller, 0.5))
+	assert.Equal(t, []uint32{300000}, controller.set)
+}
+
+func TestOptimizeGPUPowerErrors(t *testing.T) {
+	controller := newTestGPUPowerController(20)
+	c := newTestGPUContainer("train", "NVIDIA_VISIBLE_DEVICES=0")
+
+	for _, target := range []float64{0, -0.5, 1.5} {
+		assert.Error(t, optimizeGPUPower(c, controller, target))
+	}
+	assert.EqualError(t, optimizeGPUPower(newTestGPUContainer("cpu"), controller, 0.5), "no GPU assigned to the container")
+
+	controller.setErr = errors.New("insufficient permissions")
+	assert.EqualError(t, optimizeGPUPower(c, controller, 0.5), "could not set the power limit of GPU 0: insufficient permissions")
+	assert.EqualValues(t, 300000, controller.limit)
+}
+
+func TestOptimizeGPUPowerDisabled(t *testing.T) {
+	d := &DockerUtil{cfg: &Config{}}
+	err := d.OptimizeGPUPower(context.Background(), "train", 0.5)
+	assert.EqualError(t, err, "GPU power optimization is disabled, set docker_gpu_power_optimization to enable it")
+}
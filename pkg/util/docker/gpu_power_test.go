@@ -0,0 +1,113 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testGPUPowerReader reports fixed power usages, in milliwatts, by GPU index.
+type testGPUPowerReader struct {
+	usage map[int]uint32
+	limit uint32
+	uuids map[string]int
+}
+
+func (r *testGPUPowerReader) PowerUsage(index int) (uint32, error) {
+	usage, ok := r.usage[index]
+	if !ok {
+		return 0, errors.New("invalid argument")
+	}
+	return usage, nil
+}
+
+func (r *testGPUPowerReader) EnforcedPowerLimit(index int) (uint32, error) {
+	return r.limit, nil
+}
+
+func (r *testGPUPowerReader) Index(uuid string) (int, error) {
+	index, ok := r.uuids[uuid]
+	if !ok {
+		return 0, errors.New("not found")
+	}
+	return index, nil
+}
+
+func newTestGPUContainer(id string, env ...string) types.ContainerJSON {
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{ID: id, Name: "/train"},
+		Config:            &container.Config{Env: env},
+	}
+}
+
+func TestGPUPowerUsage(t *testing.T) {
+	reader := &testGPUPowerReader{
+		usage: map[int]uint32{0: 70000, 1: 250000},
+		limit: 300000,
+		uuids: map[string]int{"GPU-8a9c": 1},
+	}
+	now := time.Now()
+
+	withTestStatsClient(func(c *testStatsClient) {
+		info, err := gpuPowerUsage(newTestGPUContainer("gpu1", "PATH=/bin", "NVIDIA_VISIBLE_DEVICES=1,0"), reader, now)
+		require.NoError(t, err)
+		assert.Equal(t, &GPUPowerInfo{CurrentWatts: 250, LimitWatts: 300, DeviceIndex: 1}, info)
+		assert.Equal(t, []testStatsSample{{
+			Name:  "datadog.docker.container.gpu.power_watts",
+			Value: 250,
+			Tags:  []string{"container_id:gpu1", "container_name:train", "gpu_index:1"},
+		}}, c.gauges)
+	})
+
+	for env, index := range map[string]int{
+		"NVIDIA_VISIBLE_DEVICES=all":      0,
+		"NVIDIA_VISIBLE_DEVICES=GPU-8a9c": 1,
+	} {
+		info, err := gpuPowerUsage(newTestGPUContainer("gpu2", env), reader, now)
+		require.NoError(t, err, env)
+		assert.Equal(t, index, info.DeviceIndex, env)
+	}
+
+	for _, env := range []string{"NVIDIA_VISIBLE_DEVICES=none", "NVIDIA_VISIBLE_DEVICES=GPU-0000", "NVIDIA_VISIBLE_DEVICES=3", "PATH=/bin"} {
+		_, err := gpuPowerUsage(newTestGPUContainer("gpu3", env), reader, now)
+		assert.Error(t, err, env)
+	}
+}
+
+func TestGPUEnergyIntegration(t *testing.T) {
+	start := time.Now()
+	reader := &testGPUPowerReader{usage: map[int]uint32{0: 100000}, limit: 300000}
+	c := newTestGPUContainer("energy", "NVIDIA_VISIBLE_DEVICES=0")
+
+	info, err := gpuPowerUsage(c, reader, start)
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, info.WattHours)
+
+	// 100W then 200W for 30 minutes: 75Wh
+	reader.usage[0] = 200000
+	info, err = gpuPowerUsage(c, reader, start.Add(30*time.Minute))
+	require.NoError(t, err)
+	assert.InDelta(t, 75, info.WattHours, 1e-9)
+
+	// 200W for 30 more minutes: 175Wh
+	info, err = gpuPowerUsage(c, reader, start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.InDelta(t, 175, info.WattHours, 1e-9)
+
+	// containers not sampled for a while are forgotten
+	info, err = gpuPowerUsage(c, reader, start.Add(3*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, info.WattHours)
+}
@@ -0,0 +1,207 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+const (
+	// podNameLabel is the label holding the name of the pod of a container.
+	podNameLabel = "io.kubernetes.pod.name"
+	// containerTypeLabel is the label set to podsandbox on the pause
+	// containers holding the namespaces of pods.
+	containerTypeLabel = "io.kubernetes.docker.type"
+)
+
+// ResourceHeadroom is the amount of resources of a node not reserved by the
+// containers of a namespace.
+type ResourceHeadroom struct {
+	AvailableCPUMillis   int64
+	AvailableMemoryBytes int64
+	// CanScheduleAdditionalReplicas is the number of additional pods of the
+	// namespace which would fit in the available resources, assuming they
+	// reserve as much as the average pod of the namespace on the node.
+	CanScheduleAdditionalReplicas int
+}
+
+// kubernetesNode is the part of a Kubernetes Node object holding its resources.
+type kubernetesNode struct {
+	Status struct {
+		Capacity    map[string]string `json:"capacity"`
+		Allocatable map[string]string `json:"allocatable"`
+	} `json:"status"`
+}
+
+// podReservation is the amount of resources reserved by the containers of a pod.
+type podReservation struct {
+	cpuMillis   int64
+	memoryBytes int64
+}
+
+// CalculateResourceHeadroom returns the resources of the node left once the
+// reservations of the running containers of the given Kubernetes namespace are
+// subtracted from its allocatable resources, as reported by the Kubernetes
+// API for the node named after the Docker host.
+//
+// The CPU reservation of a container is derived from its CPU shares, which the
+// kubelet sets from the CPU request. Its memory reservation is its memory
+// reservation when set, or its memory limit otherwise as Docker doesn't know
+// about memory requests.
+func (d *DockerUtil) CalculateResourceHeadroom(ctx context.Context, namespace string) (*ResourceHeadroom, error) {
+	nodeName, err := d.GetHostname()
+	if err != nil {
+		return nil, err
+	}
+	var node kubernetesNode
+	if _, err := d.queryKubernetesAPI(ctx, "/api/v1/nodes/"+url.PathEscape(nodeName), &node); err != nil {
+		return nil, fmt.Errorf("error getting node %s: %s", nodeName, err)
+	}
+
+	filter, err := buildDockerFilter("label", podNamespaceLabel+"="+namespace, "status", "running")
+	if err != nil {
+		return nil, err
+	}
+	cList, err := d.cli.ContainerList(ctx, types.ContainerListOptions{Filters: filter})
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers: %s", err)
+	}
+	pods := make(map[string]*podReservation)
+	for _, c := range cList {
+		if c.Labels[containerTypeLabel] == "podsandbox" {
+			continue
+		}
+		i, err := d.Inspect(c.ID, false)
+		if err != nil {
+			return nil, err
+		}
+		if i.ContainerJSONBase == nil || i.HostConfig == nil {
+			continue
+		}
+		pod, ok := pods[c.Labels[podNameLabel]]
+		if !ok {
+			pod = new(podReservation)
+			pods[c.Labels[podNameLabel]] = pod
+		}
+		cpu, memory := containerReservation(i.HostConfig.Resources)
+		pod.cpuMillis += cpu
+		pod.memoryBytes += memory
+	}
+	return resourceHeadroom(node, pods)
+}
+
+// containerReservation returns the CPU, in millicores, and memory, in bytes,
+// reserved by a container with the given resources.
+func containerReservation(r container.Resources) (cpuMillis, memoryBytes int64) {
+	// the kubelet sets 2 shares for containers without CPU request
+	if r.CPUShares > 2 {
+		cpuMillis = r.CPUShares * 1000 / 1024
+	}
+	memoryBytes = r.MemoryReservation
+	if memoryBytes == 0 {
+		memoryBytes = r.Memory
+	}
+	return cpuMillis, memoryBytes
+}
+
+// resourceHeadroom subtracts the reservations of the given pods from the
+// allocatable resources of node.
+func resourceHeadroom(node kubernetesNode, pods map[string]*podReservation) (*ResourceHeadroom, error) {
+	resources := node.Status.Allocatable
+	if len(resources) == 0 {
+		resources = node.Status.Capacity
+	}
+	cpu, err := parseKubernetesQuantity(resources["cpu"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid node CPU: %s", err)
+	}
+	memory, err := parseKubernetesQuantity(resources["memory"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid node memory: %s", err)
+	}
+
+	var reserved podReservation
+	for _, pod := range pods {
+		reserved.cpuMillis += pod.cpuMillis
+		reserved.memoryBytes += pod.memoryBytes
+	}
+	headroom := &ResourceHeadroom{
+		AvailableCPUMillis:   int64(cpu*1000) - reserved.cpuMillis,
+		AvailableMemoryBytes: int64(memory) - reserved.memoryBytes,
+	}
+	if len(pods) == 0 || headroom.AvailableCPUMillis < 0 || headroom.AvailableMemoryBytes < 0 {
+		return headroom, nil
+	}
+
+	replicas := int64(math.MaxInt32)
+	if perPod := reserved.cpuMillis / int64(len(pods)); perPod > 0 {
+		replicas = headroom.AvailableCPUMillis / perPod
+	}
+	if perPod := reserved.memoryBytes / int64(len(pods)); perPod > 0 && headroom.AvailableMemoryBytes/perPod < replicas {
+		replicas = headroom.AvailableMemoryBytes / perPod
+	}
+	if replicas == math.MaxInt32 {
+		// the pods reserve nothing, their number is not bounded by resources
+		replicas = 0
+	}
+	headroom.CanScheduleAdditionalReplicas = int(replicas)
+	return headroom, nil
+}
+
+// kubernetesQuantitySuffixes are the multipliers of the suffixes of
+// Kubernetes resource quantities.
+var kubernetesQuantitySuffixes = map[string]float64{
+	"n":  1e-9,
+	"u":  1e-6,
+	"m":  1e-3,
+	"k":  1e3,
+	"M":  1e6,
+	"G":  1e9,
+	"T":  1e12,
+	"P":  1e15,
+	"E":  1e18,
+	"Ki": 1 << 10,
+	"Mi": 1 << 20,
+	"Gi": 1 << 30,
+	"Ti": 1 << 40,
+	"Pi": 1 << 50,
+	"Ei": 1 << 60,
+}
+
+// parseKubernetesQuantity parses a Kubernetes resource quantity, such as
+// 3500m or 16Gi.
+func parseKubernetesQuantity(q string) (float64, error) {
+	i := strings.IndexFunc(q, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.' && r != '+' && r != '-' && r != 'e'
+	})
+	if i == -1 {
+		i = len(q)
+	}
+	number, suffix := q[:i], q[i:]
+	multiplier := 1.0
+	if suffix != "" {
+		m, ok := kubernetesQuantitySuffixes[suffix]
+		if !ok {
+			return 0, fmt.Errorf("invalid quantity %q", q)
+		}
+		multiplier = m
+	}
+	v, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid quantity %q", q)
+	}
+	return v * multiplier, nil
+}
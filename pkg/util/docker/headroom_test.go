@@ -0,0 +1,98 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKubernetesQuantity(t *testing.T) {
+	for q, expected := range map[string]float64{
+		"4":          4,
+		"3500m":      3.5,
+		"0.5":        0.5,
+		"16Gi":       16 << 30,
+		"15950236Ki": 15950236 << 10,
+		"1G":         1e9,
+		"128974848":  128974848,
+		"1e3":        1000,
+	} {
+		v, err := parseKubernetesQuantity(q)
+		assert.NoError(t, err, q)
+		assert.Equal(t, expected, v, q)
+	}
+	for _, q := range []string{"", "Gi", "4X", "abc"} {
+		_, err := parseKubernetesQuantity(q)
+		assert.Error(t, err, q)
+	}
+}
+
+func TestContainerReservation(t *testing.T) {
+	cpu, memory := containerReservation(container.Resources{CPUShares: 512, Memory: 256 << 20})
+	assert.EqualValues(t, 500, cpu)
+	assert.EqualValues(t, 256<<20, memory)
+
+	cpu, memory = containerReservation(container.Resources{CPUShares: 2, Memory: 256 << 20, MemoryReservation: 128 << 20})
+	assert.EqualValues(t, 0, cpu)
+	assert.EqualValues(t, 128<<20, memory)
+}
+
+func TestResourceHeadroom(t *testing.T) {
+	var node kubernetesNode
+	require.NoError(t, json.Unmarshal([]byte(`{"status": {
+		"capacity": {"cpu": "4", "memory": "8Gi"},
+		"allocatable": {"cpu": "3800m", "memory": "7Gi"}
+	}}`), &node))
+
+	headroom, err := resourceHeadroom(node, map[string]*podReservation{
+		"web-1": {cpuMillis: 500, memoryBytes: 1 << 30},
+		"web-2": {cpuMillis: 700, memoryBytes: 1 << 30},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &ResourceHeadroom{
+		AvailableCPUMillis:   2600,
+		AvailableMemoryBytes: 5 << 30,
+		// 2600m left for 600m per pod, and 5Gi for 1Gi
+		CanScheduleAdditionalReplicas: 4,
+	}, headroom)
+
+	// memory bound
+	headroom, err = resourceHeadroom(node, map[string]*podReservation{
+		"db-1": {cpuMillis: 100, memoryBytes: 3 << 30},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, headroom.CanScheduleAdditionalReplicas)
+
+	// overcommitted node
+	headroom, err = resourceHeadroom(node, map[string]*podReservation{
+		"batch-1": {cpuMillis: 4000},
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, -200, headroom.AvailableCPUMillis)
+	assert.Equal(t, 0, headroom.CanScheduleAdditionalReplicas)
+
+	// no pod in the namespace
+	headroom, err = resourceHeadroom(node, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &ResourceHeadroom{AvailableCPUMillis: 3800, AvailableMemoryBytes: 7 << 30}, headroom)
+
+	// capacity is used without allocatable resources
+	node.Status.Allocatable = nil
+	headroom, err = resourceHeadroom(node, nil)
+	require.NoError(t, err)
+	assert.EqualValues(t, 4000, headroom.AvailableCPUMillis)
+
+	node.Status.Capacity = map[string]string{"cpu": "four"}
+	_, err = resourceHeadroom(node, nil)
+	assert.Error(t, err)
+}
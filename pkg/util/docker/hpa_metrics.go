@@ -0,0 +1,107 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// customMetricsAPIPath is the path of the Kubernetes Custom Metrics API.
+const customMetricsAPIPath = "/apis/custom.metrics.k8s.io/v1beta1"
+
+// customMetricValue is the MetricValue object of the Custom Metrics API.
+type customMetricValue struct {
+	Kind            string                `json:"kind"`
+	APIVersion      string                `json:"apiVersion"`
+	DescribedObject customMetricObjectRef `json:"describedObject"`
+	MetricName      string                `json:"metricName"`
+	Timestamp       string                `json:"timestamp"`
+	Value           string                `json:"value"`
+}
+
+// customMetricObjectRef references the object a custom metric describes.
+type customMetricObjectRef struct {
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	APIVersion string `json:"apiVersion"`
+}
+
+// ReportHPAMetrics sends value as the metricName custom metric of the deployment
+// deploymentName of namespace to the Kubernetes Custom Metrics API, so that the
+// Horizontal Pod Autoscalers of the deployment can scale it on the metric. The
+// metric is posted as a MetricValue object to
+// /apis/custom.metrics.k8s.io/v1beta1/namespaces/<namespace>/deployments.apps/<deployment>/<metric>,
+// which requires the API to be served by a metrics adapter accepting pushed
+// values. It requires docker_hpa_metrics_enabled.
+func (d *DockerUtil) ReportHPAMetrics(ctx context.Context, deploymentName, namespace string, metricName string, value float64) error {
+	if !d.cfg.HPAMetricsEnabled {
+		return errors.New("HPA metrics are disabled, set docker_hpa_metrics_enabled to enable them")
+	}
+	return d.reportHPAMetrics(ctx, deploymentName, namespace, metricName, value, time.Now())
+}
+
+func (d *DockerUtil) reportHPAMetrics(ctx context.Context, deploymentName, namespace, metricName string, value float64, now time.Time) error {
+	if deploymentName == "" || namespace == "" || metricName == "" {
+		return errors.New("a deployment, a namespace and a metric name are required")
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Errorf("invalid value %v for metric %s", value, metricName)
+	}
+	body, err := json.Marshal(customMetricValue{
+		Kind:       "MetricValue",
+		APIVersion: "custom.metrics.k8s.io/v1beta1",
+		DescribedObject: customMetricObjectRef{
+			Kind:       "Deployment",
+			Namespace:  namespace,
+			Name:       deploymentName,
+			APIVersion: "apps/v1",
+		},
+		MetricName: metricName,
+		Timestamp:  now.UTC().Format(time.RFC3339),
+		Value:      metricQuantity(value),
+	})
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("%s/namespaces/%s/deployments.apps/%s/%s", customMetricsAPIPath,
+		url.PathEscape(namespace), url.PathEscape(deploymentName), url.PathEscape(metricName))
+	resp, err := d.kubernetesAPIRequest(ctx, "POST", path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusAccepted, http.StatusNoContent:
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("the Custom Metrics API is not served, a metrics adapter is required to report metric %s", metricName)
+	}
+	msg, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("error reporting metric %s of deployment %s/%s: %s: %s", metricName, namespace, deploymentName, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// metricQuantity formats value as a Kubernetes quantity, in thousandths as is
+// usual for custom metrics.
+func metricQuantity(value float64) string {
+	if value == math.Trunc(value) && math.Abs(value) < 1e15 {
+		return strconv.FormatInt(int64(value), 10)
+	}
+	return strconv.FormatInt(int64(math.Round(value*1000)), 10) + "m"
+}
@@ -0,0 +1,116 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportHPAMetrics(t *testing.T) {
+	tempFolder, err := newTempFolder("test-hpa-metrics")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	require.NoError(t, tempFolder.add("token", "secret-token\n"))
+	defer func(token, ca string) {
+		kubernetesTokenPath, kubernetesCAPath = token, ca
+	}(kubernetesTokenPath, kubernetesCAPath)
+	kubernetesTokenPath = filepath.Join(tempFolder.RootPath, "token")
+	kubernetesCAPath = filepath.Join(tempFolder.RootPath, "missing.crt")
+
+	// mock Custom Metrics API, served for the prod namespace only
+	var received []customMetricValue
+	mux := http.NewServeMux()
+	mux.HandleFunc("/apis/custom.metrics.k8s.io/v1beta1/namespaces/prod/", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "Bearer secret-token", r.Header.Get("Authorization"))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		if r.URL.Path == "/apis/custom.metrics.k8s.io/v1beta1/namespaces/prod/deployments.apps/checkout/forbidden" {
+			http.Error(w, "metric is read-only", http.StatusForbidden)
+			return
+		}
+		var v customMetricValue
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&v))
+		assert.Equal(t, "/apis/custom.metrics.k8s.io/v1beta1/namespaces/prod/deployments.apps/checkout/"+v.MetricName, r.URL.Path)
+		received = append(received, v)
+		w.WriteHeader(http.StatusCreated)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	d := &DockerUtil{queryTimeout: time.Second, cfg: &Config{KubernetesAPIURL: srv.URL, HPAMetricsEnabled: true}}
+	now := time.Date(2019, 3, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600))
+	report := func(namespace, metric string, value float64) error {
+		return d.reportHPAMetrics(context.Background(), "checkout", namespace, metric, value, now)
+	}
+
+	require.NoError(t, report("prod", "queue_length", 42))
+	require.NoError(t, report("prod", "requests_per_second", 12.5))
+	assert.Equal(t, []customMetricValue{
+		{
+			Kind:       "MetricValue",
+			APIVersion: "custom.metrics.k8s.io/v1beta1",
+			DescribedObject: customMetricObjectRef{
+				Kind:       "Deployment",
+				Namespace:  "prod",
+				Name:       "checkout",
+				APIVersion: "apps/v1",
+			},
+			MetricName: "queue_length",
+			Timestamp:  "2019-03-01T11:00:00Z",
+			Value:      "42",
+		},
+		{
+			Kind:       "MetricValue",
+			APIVersion: "custom.metrics.k8s.io/v1beta1",
+			DescribedObject: customMetricObjectRef{
+				Kind:       "Deployment",
+				Namespace:  "prod",
+				Name:       "checkout",
+				APIVersion: "apps/v1",
+			},
+			MetricName: "requests_per_second",
+			Timestamp:  "2019-03-01T11:00:00Z",
+			Value:      "12500m",
+		},
+	}, received)
+
+	assert.EqualError(t, report("prod", "forbidden", 1), "error reporting metric forbidden of deployment prod/checkout: 403 Forbidden: metric is read-only")
+	assert.EqualError(t, report("staging", "queue_length", 1), "the Custom Metrics API is not served, a metrics adapter is required to report metric queue_length")
+	assert.EqualError(t, report("prod", "", 1), "a deployment, a namespace and a metric name are required")
+	assert.EqualError(t, report("prod", "queue_length", math.NaN()), "invalid value NaN for metric queue_length")
+	assert.Len(t, received, 2)
+}
+
+func TestReportHPAMetricsDisabled(t *testing.T) {
+	d := &DockerUtil{cfg: &Config{}}
+	err := d.ReportHPAMetrics(context.Background(), "checkout", "prod", "queue_length", 42)
+	assert.EqualError(t, err, "HPA metrics are disabled, set docker_hpa_metrics_enabled to enable them")
+}
+
+func TestMetricQuantity(t *testing.T) {
+	for value, want := range map[float64]string{
+		0:      "0",
+		42:     "42",
+		-3:     "-3",
+		0.5:    "500m",
+		12.345: "12345m",
+		0.0004: "0m",
+	} {
+		assert.Equal(t, want, metricQuantity(value), "%v", value)
+	}
+}
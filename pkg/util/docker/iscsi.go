@@ -0,0 +1,175 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+)
+
+var (
+	// iscsiByPathRe matches the names of the links to iSCSI disks in
+	// /dev/disk/by-path, such as ip-10.0.0.1:3260-iscsi-iqn.2001-05.com.example:storage-lun-0.
+	iscsiByPathRe = regexp.MustCompile(`^ip-(.+?)-iscsi-(.+)-lun-\d+(?:-part\d+)?$`)
+	// iscsiSessionRe matches the lines of the output of iscsiadm -m session,
+	// such as tcp: [1] 10.0.0.1:3260,1 iqn.2001-05.com.example:storage (non-flash).
+	iscsiSessionRe = regexp.MustCompile(`^\S+: \[\d+\] (\S+),\d+ (\S+)`)
+)
+
+// iscsiByPathDir holds the links to the disks of the host by path.
+var iscsiByPathDir = "/dev/disk/by-path"
+
+// iscsiSessions returns the output of iscsiadm -m session. It is a variable
+// to be replaced in tests.
+var iscsiSessions = func(ctx context.Context) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "iscsiadm", "-m", "session")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// iscsiadm fails when there is no session
+		if strings.Contains(stderr.String(), "No active sessions") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("iscsiadm -m session: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// ISCSIStatus is the status of the session of an iSCSI target mounted in a container.
+type ISCSIStatus struct {
+	Target    string
+	Portal    string
+	Connected bool
+}
+
+// ValidateiSCSIMounts returns the status of the sessions of the iSCSI targets
+// mounted in the container identified by id, as found in the /proc/{pid}/mounts
+// file of its main process. A target is connected when iscsiadm -m session
+// lists a session to it through its portal. Disconnected targets are counted
+// as datadog.docker.container.iscsi_disconnected.
+func (d *DockerUtil) ValidateiSCSIMounts(ctx context.Context, id string) ([]ISCSIStatus, error) {
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return nil, err
+	}
+	return validateISCSIMounts(ctx, c, config.Datadog.GetString("container_proc_root"), iscsiByPathDir)
+}
+
+func validateISCSIMounts(ctx context.Context, c types.ContainerJSON, procRoot, byPathDir string) ([]ISCSIStatus, error) {
+	if c.ContainerJSONBase == nil || c.State == nil || c.State.Pid == 0 {
+		return nil, errors.New("invalid container: not running")
+	}
+	devices, err := mountedDevices(filepath.Join(procRoot, strconv.Itoa(c.State.Pid), "mounts"))
+	if err != nil {
+		return nil, err
+	}
+	statuses := iscsiTargets(devices, byPathDir)
+	if len(statuses) == 0 {
+		return nil, nil
+	}
+	out, err := iscsiSessions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sessions := parseISCSISessions(out)
+	for i, s := range statuses {
+		statuses[i].Connected = sessions[s]
+		if !statuses[i].Connected {
+			tags := append(containerTags(c.ID, c.Name), "iscsi_target:"+s.Target, "iscsi_portal:"+s.Portal)
+			count("datadog.docker.container.iscsi_disconnected", 1, tags)
+		}
+	}
+	return statuses, nil
+}
+
+// mountedDevices returns the devices mounted according to the given mounts file.
+func mountedDevices(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var devices []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// /dev/sdb /data ext4 rw,relatime 0 0
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 1 && strings.HasPrefix(fields[0], "/dev/") {
+			devices = append(devices, fields[0])
+		}
+	}
+	return devices, scanner.Err()
+}
+
+// iscsiTargets returns the iSCSI targets of the given devices, which are
+// either links of byPathDir or the disks they link to. Targets are returned
+// once, in the order of the devices, with Connected unset.
+func iscsiTargets(devices []string, byPathDir string) []ISCSIStatus {
+	// the iSCSI links by disk name, such as sdb
+	byDisk := make(map[string]string)
+	if entries, err := ioutil.ReadDir(byPathDir); err == nil {
+		for _, e := range entries {
+			if !iscsiByPathRe.MatchString(e.Name()) {
+				continue
+			}
+			if target, err := os.Readlink(filepath.Join(byPathDir, e.Name())); err == nil {
+				byDisk[filepath.Base(target)] = e.Name()
+			}
+		}
+	}
+
+	var targets []ISCSIStatus
+	seen := make(map[ISCSIStatus]bool)
+	for _, device := range devices {
+		name := filepath.Base(device)
+		if link, ok := byDisk[name]; ok {
+			name = link
+		}
+		m := iscsiByPathRe.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		s := ISCSIStatus{Portal: m[1], Target: m[2]}
+		if !seen[s] {
+			seen[s] = true
+			targets = append(targets, s)
+		}
+	}
+	return targets
+}
+
+// parseISCSISessions parses the output of iscsiadm -m session into the set
+// of the targets it lists a session to, with Connected unset.
+func parseISCSISessions(out []byte) map[ISCSIStatus]bool {
+	sessions := make(map[ISCSIStatus]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		m := iscsiSessionRe.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		sessions[ISCSIStatus{Portal: m[1], Target: m[2]}] = true
+	}
+	return sessions
+}
@@ -0,0 +1,74 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testISCSISessions = `tcp: [1] 10.0.0.1:3260,1 iqn.2001-05.com.example:storage (non-flash)
+tcp: [3] [fe80::1]:3260,1 iqn.2001-05.com.example:logs (non-flash)
+`
+
+func TestValidateISCSIMounts(t *testing.T) {
+	tempFolder, err := newTempFolder("test-iscsi")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+
+	require.NoError(t, tempFolder.add("proc/42/mounts", `overlay / overlay rw,relatime 0 0
+proc /proc proc rw,nosuid 0 0
+/dev/sdb /data ext4 rw,relatime 0 0
+/dev/disk/by-path/ip-10.0.0.2:3260-iscsi-iqn.2001-05.com.example:backup-lun-0 /backup xfs rw 0 0
+/dev/sdc1 /logs ext4 rw,relatime 0 0
+/dev/sdb /data2 ext4 rw,relatime 0 0
+/dev/sda1 /etc/hosts ext4 rw,relatime 0 0
+`))
+	byPath := filepath.Join(tempFolder.RootPath, "by-path")
+	require.NoError(t, os.MkdirAll(byPath, 0755))
+	require.NoError(t, os.Symlink("../../sdb", filepath.Join(byPath, "ip-10.0.0.1:3260-iscsi-iqn.2001-05.com.example:storage-lun-0")))
+	require.NoError(t, os.Symlink("../../sdc1", filepath.Join(byPath, "ip-[fe80::1]:3260-iscsi-iqn.2001-05.com.example:logs-lun-1-part1")))
+	require.NoError(t, os.Symlink("../../sda1", filepath.Join(byPath, "pci-0000:00:1f.2-ata-1-part1")))
+
+	defer func(old func(context.Context) ([]byte, error)) { iscsiSessions = old }(iscsiSessions)
+	iscsiSessions = func(context.Context) ([]byte, error) { return []byte(testISCSISessions), nil }
+
+	c := types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{
+		ID:    "db",
+		Name:  "/db",
+		State: &types.ContainerState{Pid: 42},
+	}}
+	withTestStatsClient(func(stats *testStatsClient) {
+		statuses, err := validateISCSIMounts(context.Background(), c, filepath.Join(tempFolder.RootPath, "proc"), byPath)
+		require.NoError(t, err)
+		assert.Equal(t, []ISCSIStatus{
+			{Target: "iqn.2001-05.com.example:storage", Portal: "10.0.0.1:3260", Connected: true},
+			{Target: "iqn.2001-05.com.example:backup", Portal: "10.0.0.2:3260"},
+			{Target: "iqn.2001-05.com.example:logs", Portal: "[fe80::1]:3260", Connected: true},
+		}, statuses)
+		assert.Equal(t, []testStatsSample{{
+			Name:  "datadog.docker.container.iscsi_disconnected",
+			Value: 1,
+			Tags:  []string{"container_id:db", "container_name:db", "iscsi_target:iqn.2001-05.com.example:backup", "iscsi_portal:10.0.0.2:3260"},
+		}}, stats.counts)
+	})
+
+	// iscsiadm is not run without iSCSI mounts
+	iscsiSessions = func(context.Context) ([]byte, error) { panic("unexpected call") }
+	require.NoError(t, tempFolder.add("proc/43/mounts", "/dev/sda1 /etc/hosts ext4 rw 0 0\n"))
+	c.State.Pid = 43
+	statuses, err := validateISCSIMounts(context.Background(), c, filepath.Join(tempFolder.RootPath, "proc"), byPath)
+	require.NoError(t, err)
+	assert.Empty(t, statuses)
+}
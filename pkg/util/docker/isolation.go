@@ -0,0 +1,148 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// namespaces lists the namespaces found in /proc/{pid}/ns.
+var namespaces = []string{"cgroup", "ipc", "mnt", "net", "pid", "user", "uts"}
+
+// SyscallIsolationReport describes how the processes of a container are
+// isolated from the host.
+type SyscallIsolationReport struct {
+	// SeccompEnabled is true when the syscalls of the container are filtered
+	// by a seccomp profile.
+	SeccompEnabled bool
+	// NamespacesIsolated lists the namespaces the container doesn't share
+	// with the host.
+	NamespacesIsolated []string
+	// SharedNamespaces lists the namespaces the container shares with the host.
+	SharedNamespaces []string
+}
+
+// VerifySyscallIsolation reports the seccomp mode and the namespaces of the
+// main process of the container identified by id. Namespaces are compared to
+// the ones of the host init process, and a warning is logged for each of the
+// configured required namespaces which is shared with the host. The number of
+// shared namespaces is emitted as the datadog.docker.container.shared_namespaces
+// gauge.
+func (d *DockerUtil) VerifySyscallIsolation(ctx context.Context, id string) (*SyscallIsolationReport, error) {
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return nil, err
+	}
+	return d.syscallIsolationReport(c)
+}
+
+func (d *DockerUtil) syscallIsolationReport(c types.ContainerJSON) (*SyscallIsolationReport, error) {
+	if c.ContainerJSONBase == nil || c.State == nil || c.State.Pid == 0 {
+		return nil, errors.New("invalid container: not running")
+	}
+	procRoot := config.Datadog.GetString("container_proc_root")
+	pidDir := filepath.Join(procRoot, strconv.Itoa(c.State.Pid))
+
+	mode, err := seccompMode(filepath.Join(pidDir, "status"))
+	if err != nil {
+		return nil, err
+	}
+	report := &SyscallIsolationReport{
+		NamespacesIsolated: []string{},
+		SharedNamespaces:   []string{},
+	}
+	profile := hasSeccompProfile(c.HostConfig)
+	switch {
+	case mode >= 0:
+		report.SeccompEnabled = mode > 0
+		if profile && !report.SeccompEnabled {
+			log.Warnf("Container %s has a seccomp profile but its syscalls are not filtered, seccomp may not be supported by the kernel", c.ID)
+		}
+	default:
+		// the kernel doesn't report the seccomp mode, trust the container configuration
+		report.SeccompEnabled = profile
+	}
+
+	for _, ns := range namespaces {
+		own, err := os.Readlink(filepath.Join(pidDir, "ns", ns))
+		if err != nil {
+			// the namespace is not supported by the kernel
+			continue
+		}
+		host, err := os.Readlink(filepath.Join(procRoot, "1", "ns", ns))
+		if err != nil {
+			continue
+		}
+		if own == host {
+			report.SharedNamespaces = append(report.SharedNamespaces, ns)
+		} else {
+			report.NamespacesIsolated = append(report.NamespacesIsolated, ns)
+		}
+	}
+
+	for _, ns := range d.cfg.RequiredIsolatedNamespaces {
+		for _, shared := range report.SharedNamespaces {
+			if ns == shared {
+				log.Warnf("Container %s shares the %s namespace with the host", c.ID, ns)
+			}
+		}
+	}
+	gauge("datadog.docker.container.shared_namespaces", float64(len(report.SharedNamespaces)), containerTags(c.ID, c.Name))
+	return report, nil
+}
+
+// seccompMode returns the seccomp mode reported in the given /proc/{pid}/status
+// file: 0 when disabled, 1 in strict mode and 2 in filter mode. It returns -1
+// when the kernel doesn't report it.
+func seccompMode(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[0] != "Seccomp:" {
+			continue
+		}
+		return strconv.Atoi(fields[1])
+	}
+	return -1, scanner.Err()
+}
+
+// hasSeccompProfile returns whether the container is configured to run with
+// a seccomp profile, which is the case unless it is privileged or the profile
+// is explicitly disabled.
+func hasSeccompProfile(hostConfig *container.HostConfig) bool {
+	if hostConfig == nil {
+		return true
+	}
+	if hostConfig.Privileged {
+		return false
+	}
+	for _, opt := range hostConfig.SecurityOpt {
+		if opt == "seccomp=unconfined" || opt == "seccomp:unconfined" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,166 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+)
+
+func TestSyscallIsolationReport(t *testing.T) {
+	tempFolder, err := newTempFolder("test-isolation")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	config.Datadog.SetDefault("container_proc_root", tempFolder.RootPath)
+	defer config.Datadog.SetDefault("container_proc_root", "/proc")
+
+	// links the namespaces of pid, those in shared are the ones of the host
+	linkNamespaces := func(pid int, shared ...string) {
+		dir := filepath.Join(tempFolder.RootPath, fmt.Sprint(pid), "ns")
+		require.NoError(t, os.MkdirAll(dir, 0777))
+		for i, ns := range []string{"ipc", "mnt", "net", "pid", "user", "uts"} {
+			inode := 4026531000 + i
+			if pid != 1 && !contains(shared, ns) {
+				inode += pid * 100
+			}
+			require.NoError(t, os.Symlink(fmt.Sprintf("%s:[%d]", ns, inode), filepath.Join(dir, ns)))
+		}
+	}
+	linkNamespaces(1)
+
+	d := &DockerUtil{cfg: &Config{RequiredIsolatedNamespaces: []string{"ipc", "mnt", "net", "pid", "uts"}}}
+	for _, tt := range []struct {
+		name       string
+		pid        int
+		status     string
+		shared     []string
+		hostConfig *container.HostConfig
+		report     *SyscallIsolationReport
+	}{
+		{
+			name:       "isolated",
+			pid:        10,
+			status:     "Name:\tnginx\nSeccomp:\t2\n",
+			hostConfig: &container.HostConfig{},
+			report: &SyscallIsolationReport{
+				SeccompEnabled:     true,
+				NamespacesIsolated: []string{"ipc", "mnt", "net", "pid", "uts"},
+				SharedNamespaces:   []string{"user"},
+			},
+		},
+		{
+			name:       "host network and pid",
+			pid:        11,
+			status:     "Name:\tnginx\nSeccomp:\t2\n",
+			shared:     []string{"net", "pid", "user"},
+			hostConfig: &container.HostConfig{NetworkMode: "host", PidMode: "host"},
+			report: &SyscallIsolationReport{
+				SeccompEnabled:     true,
+				NamespacesIsolated: []string{"ipc", "mnt", "uts"},
+				SharedNamespaces:   []string{"net", "pid", "user"},
+			},
+		},
+		{
+			name:       "unconfined",
+			pid:        12,
+			status:     "Name:\tnginx\nSeccomp:\t0\n",
+			hostConfig: &container.HostConfig{SecurityOpt: []string{"seccomp=unconfined"}},
+			report: &SyscallIsolationReport{
+				SeccompEnabled:     false,
+				NamespacesIsolated: []string{"ipc", "mnt", "net", "pid", "uts"},
+				SharedNamespaces:   []string{"user"},
+			},
+		},
+		{
+			name:       "privileged on the host",
+			pid:        13,
+			status:     "Name:\tnginx\nSeccomp:\t0\n",
+			shared:     []string{"ipc", "mnt", "net", "pid", "user", "uts"},
+			hostConfig: &container.HostConfig{Privileged: true},
+			report: &SyscallIsolationReport{
+				SeccompEnabled:     false,
+				NamespacesIsolated: []string{},
+				SharedNamespaces:   []string{"ipc", "mnt", "net", "pid", "user", "uts"},
+			},
+		},
+		{
+			name:       "user namespace",
+			pid:        14,
+			status:     "Name:\tnginx\nSeccomp:\t2\n",
+			shared:     []string{},
+			hostConfig: &container.HostConfig{UsernsMode: "private"},
+			report: &SyscallIsolationReport{
+				SeccompEnabled:     true,
+				NamespacesIsolated: []string{"ipc", "mnt", "net", "pid", "user", "uts"},
+				SharedNamespaces:   []string{},
+			},
+		},
+		{
+			name:       "seccomp not reported by the kernel",
+			pid:        15,
+			status:     "Name:\tnginx\n",
+			hostConfig: &container.HostConfig{},
+			report: &SyscallIsolationReport{
+				SeccompEnabled:     true,
+				NamespacesIsolated: []string{"ipc", "mnt", "net", "pid", "uts"},
+				SharedNamespaces:   []string{"user"},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			shared := tt.shared
+			if shared == nil {
+				// without user namespace remapping, the user namespace is the host one
+				shared = []string{"user"}
+			}
+			linkNamespaces(tt.pid, shared...)
+			require.NoError(t, tempFolder.add(fmt.Sprintf("%d/status", tt.pid), tt.status))
+
+			withTestStatsClient(func(c *testStatsClient) {
+				report, err := d.syscallIsolationReport(types.ContainerJSON{
+					ContainerJSONBase: &types.ContainerJSONBase{
+						ID:         "abc",
+						Name:       "/web",
+						State:      &types.ContainerState{Pid: tt.pid},
+						HostConfig: tt.hostConfig,
+					},
+				})
+				require.NoError(t, err)
+				assert.Equal(t, tt.report, report)
+				assert.Equal(t, []testStatsSample{{
+					Name:  "datadog.docker.container.shared_namespaces",
+					Value: float64(len(tt.report.SharedNamespaces)),
+					Tags:  []string{"container_id:abc", "container_name:web"},
+				}}, c.gauges)
+			})
+		})
+	}
+
+	_, err = d.syscallIsolationReport(types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{ID: "abc", State: &types.ContainerState{}},
+	})
+	assert.Error(t, err, "not running")
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
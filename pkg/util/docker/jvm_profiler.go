@@ -0,0 +1,391 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+const (
+	// asyncProfilerDir is the directory async-profiler is copied to in the
+	// profiled container.
+	asyncProfilerDir = "/tmp/datadog-async-profiler"
+	// asyncProfilerOutput is the file the collapsed stacks are written to.
+	asyncProfilerOutput = asyncProfilerDir + "/profile.collapsed"
+	// topMethodsCount is the number of methods returned in ProfilingReport.TopMethods.
+	topMethodsCount = 10
+	// execPollInterval is the interval at which the state of the profiler
+	// process is checked.
+	execPollInterval = 100 * time.Millisecond
+)
+
+// containerExecClient is the subset of the Docker client used to run
+// commands in containers.
+type containerExecClient interface {
+	CopyToContainer(ctx context.Context, container, path string, content io.Reader, options types.CopyToContainerOptions) error
+	CopyFromContainer(ctx context.Context, container, srcPath string) (io.ReadCloser, types.ContainerPathStat, error)
+	ContainerExecCreate(ctx context.Context, container string, config types.ExecConfig) (types.IDResponse, error)
+	ContainerExecStart(ctx context.Context, execID string, config types.ExecStartCheck) error
+	ContainerExecInspect(ctx context.Context, execID string) (types.ContainerExecInspect, error)
+}
+
+// ProfilingReport is the result of the profiling of a JVM.
+type ProfilingReport struct {
+	// FlameGraphSVG is the flame graph of the sampled stacks.
+	FlameGraphSVG []byte
+	// TopMethods lists the methods found the most often at the top of the
+	// sampled stacks, most frequent first.
+	TopMethods []string
+}
+
+// AttachAsyncProfiler profiles the JVM running in the container identified by
+// id for the given duration with async-profiler, sampling the given event
+// (e.g. "cpu", "alloc" or "lock"). async-profiler is copied from the configured
+// docker_async_profiler_path into the container, run through docker exec,
+// then removed. The JVM is the first process of the container whose command is
+// java. It requires docker_jvm_profiling_enabled.
+func (d *DockerUtil) AttachAsyncProfiler(ctx context.Context, id string, duration time.Duration, event string) (*ProfilingReport, error) {
+	if !d.cfg.JVMProfilingEnabled {
+		return nil, errors.New("JVM profiling is disabled, set docker_jvm_profiling_enabled to enable it")
+	}
+	cgroup, err := containerCgroup(id)
+	if err != nil {
+		return nil, err
+	}
+	pids := make([]int, len(cgroup.Pids))
+	for i, pid := range cgroup.Pids {
+		pids[i] = int(pid)
+	}
+	pid, err := findJVM(config.Datadog.GetString("container_proc_root"), pids)
+	if err != nil {
+		return nil, err
+	}
+	return d.profileJVM(ctx, d.cli, id, pid, duration, event)
+}
+
+func (d *DockerUtil) profileJVM(ctx context.Context, cli containerExecClient, id string, pid int, duration time.Duration, event string) (*ProfilingReport, error) {
+	if event == "" {
+		event = "cpu"
+	}
+	seconds := int(math.Ceil(duration.Seconds()))
+	if seconds < 1 {
+		return nil, fmt.Errorf("invalid profiling duration %s", duration)
+	}
+
+	archive, err := tarDirectory(d.cfg.AsyncProfilerPath, path.Base(asyncProfilerDir))
+	if err != nil {
+		return nil, fmt.Errorf("could not archive async-profiler: %s", err)
+	}
+	if err := cli.CopyToContainer(ctx, id, path.Dir(asyncProfilerDir), archive, types.CopyToContainerOptions{}); err != nil {
+		return nil, fmt.Errorf("could not copy async-profiler to container %s: %s", id, err)
+	}
+	defer func() {
+		cleanup := types.ExecConfig{Cmd: []string{"rm", "-rf", asyncProfilerDir}}
+		if _, err := execInContainer(ctx, cli, id, cleanup); err != nil {
+			log.Debugf("Could not remove async-profiler from container %s: %s", id, err)
+		}
+	}()
+
+	run := types.ExecConfig{Cmd: []string{
+		asyncProfilerDir + "/profiler.sh",
+		"-d", strconv.Itoa(seconds),
+		"-e", event,
+		"-o", "collapsed",
+		"-f", asyncProfilerOutput,
+		strconv.Itoa(pid),
+	}}
+	exitCode, err := execInContainer(ctx, cli, id, run)
+	if err != nil {
+		return nil, fmt.Errorf("could not run async-profiler in container %s: %s", id, err)
+	}
+	if exitCode != 0 {
+		return nil, fmt.Errorf("async-profiler exited with code %d in container %s", exitCode, id)
+	}
+
+	rc, _, err := cli.CopyFromContainer(ctx, id, asyncProfilerOutput)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve the profile from container %s: %s", id, err)
+	}
+	defer rc.Close()
+	tr := tar.NewReader(rc)
+	if _, err := tr.Next(); err != nil {
+		return nil, fmt.Errorf("invalid profile archive: %s", err)
+	}
+	stacks, err := parseCollapsedStacks(tr)
+	if err != nil {
+		return nil, err
+	}
+	return &ProfilingReport{
+		FlameGraphSVG: collapsedFlameGraph(stacks),
+		TopMethods:    topMethods(stacks, topMethodsCount),
+	}, nil
+}
+
+// execInContainer runs the given command in the container and waits for it
+// to exit, returning its exit code.
+func execInContainer(ctx context.Context, cli containerExecClient, id string, cfg types.ExecConfig) (int, error) {
+	cfg.Detach = true
+	exec, err := cli.ContainerExecCreate(ctx, id, cfg)
+	if err != nil {
+		return 0, err
+	}
+	if err := cli.ContainerExecStart(ctx, exec.ID, types.ExecStartCheck{Detach: true}); err != nil {
+		return 0, err
+	}
+	ticker := time.NewTicker(execPollInterval)
+	defer ticker.Stop()
+	for {
+		inspect, err := cli.ContainerExecInspect(ctx, exec.ID)
+		if err != nil {
+			return 0, err
+		}
+		if !inspect.Running {
+			return inspect.ExitCode, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// findJVM returns the pid, in the namespace of the container, of the first of
+// the given processes running java.
+func findJVM(procRoot string, pids []int) (int, error) {
+	for _, pid := range pids {
+		comm, err := ioutil.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "comm"))
+		if err != nil || strings.TrimSpace(string(comm)) != "java" {
+			continue
+		}
+		nspid, err := namespacePID(filepath.Join(procRoot, strconv.Itoa(pid), "status"))
+		if err != nil {
+			return 0, err
+		}
+		return nspid, nil
+	}
+	return 0, errors.New("no JVM found in the container")
+}
+
+// namespacePID returns the pid of a process in its innermost pid namespace,
+// from the NSpid line of its /proc/{pid}/status file.
+func namespacePID(statusPath string) (int, error) {
+	f, err := os.Open(statusPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "NSpid:" {
+			continue
+		}
+		return strconv.Atoi(fields[len(fields)-1])
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no NSpid in %s, the kernel is too old to find the pid of the JVM in its container", statusPath)
+}
+
+// tarDirectory returns a tar archive of the regular files of dir, placed
+// under prefix.
+func tarDirectory(dir, prefix string) (io.Reader, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() && !fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(prefix, filepath.ToSlash(rel))
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// parseCollapsedStacks parses stacks in the collapsed format of async-profiler:
+// one "frame1;frame2;...;frameN count" line per distinct stack, root first.
+func parseCollapsedStacks(r io.Reader) (map[string]int, error) {
+	stacks := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid collapsed stack %q", line)
+		}
+		count, err := strconv.Atoi(line[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid collapsed stack %q", line)
+		}
+		stacks[line[:i]] += count
+	}
+	return stacks, scanner.Err()
+}
+
+// topMethods returns the n methods found the most often at the top of the
+// stacks, most frequent first.
+func topMethods(stacks map[string]int, n int) []string {
+	self := make(map[string]int)
+	for stack, count := range stacks {
+		frames := strings.Split(stack, ";")
+		self[frames[len(frames)-1]] += count
+	}
+	methods := make([]string, 0, len(self))
+	for m := range self {
+		methods = append(methods, m)
+	}
+	sort.Slice(methods, func(i, j int) bool {
+		if self[methods[i]] != self[methods[j]] {
+			return self[methods[i]] > self[methods[j]]
+		}
+		return methods[i] < methods[j]
+	})
+	if len(methods) > n {
+		methods = methods[:n]
+	}
+	return methods
+}
+
+// flameFrame is a frame of a flame graph, with the number of samples it
+// appears in.
+type flameFrame struct {
+	name     string
+	samples  int
+	children map[string]*flameFrame
+}
+
+const (
+	flameGraphWidth       = 1200
+	flameGraphFrameHeight = 16
+)
+
+// collapsedFlameGraph renders the given collapsed stacks as an SVG flame graph,
+// with root frames at the bottom.
+func collapsedFlameGraph(stacks map[string]int) []byte {
+	root := &flameFrame{name: "all", children: make(map[string]*flameFrame)}
+	depth := 0
+	for stack, count := range stacks {
+		frames := strings.Split(stack, ";")
+		if len(frames) > depth {
+			depth = len(frames)
+		}
+		root.samples += count
+		f := root
+		for _, name := range frames {
+			child, ok := f.children[name]
+			if !ok {
+				child = &flameFrame{name: name, children: make(map[string]*flameFrame)}
+				f.children[name] = child
+			}
+			child.samples += count
+			f = child
+		}
+	}
+
+	height := (depth + 1) * flameGraphFrameHeight
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="Verdana" font-size="11">`+"\n", flameGraphWidth, height)
+	if root.samples > 0 {
+		writeFlameFrame(&buf, root, 0, 0, float64(flameGraphWidth)/float64(root.samples), height, root.samples)
+	}
+	buf.WriteString("</svg>\n")
+	return buf.Bytes()
+}
+
+// writeFlameFrame writes the rectangle of f and its children, laid out by name.
+func writeFlameFrame(buf *bytes.Buffer, f *flameFrame, x float64, level int, scale float64, height, total int) {
+	width := float64(f.samples) * scale
+	y := height - (level+1)*flameGraphFrameHeight
+	name := html.EscapeString(f.name)
+	// warm colors, varying with the name so that adjacent frames differ
+	var hash uint32
+	for _, c := range []byte(f.name) {
+		hash = hash*31 + uint32(c)
+	}
+	fmt.Fprintf(buf, `<g><title>%s (%d samples, %.2f%%)</title>`, name, f.samples, 100*float64(f.samples)/float64(total))
+	fmt.Fprintf(buf, `<rect x="%.1f" y="%d" width="%.1f" height="%d" fill="rgb(%d,%d,55)"/>`, x, y, width, flameGraphFrameHeight-1, 205+hash%50, 80+hash/50%150)
+	if chars := int(width / 7); chars > 3 {
+		label := f.name
+		if len(label) > chars {
+			label = label[:chars-2] + ".."
+		}
+		fmt.Fprintf(buf, `<text x="%.1f" y="%d">%s</text>`, x+3, y+flameGraphFrameHeight-4, html.EscapeString(label))
+	}
+	buf.WriteString("</g>\n")
+
+	names := make([]string, 0, len(f.children))
+	for name := range f.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		child := f.children[name]
+		writeFlameFrame(buf, child, x, level+1, scale, height, total)
+		x += float64(child.samples) * scale
+	}
+}
@@ -0,0 +1,194 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCollapsedStacks = `java/lang/Thread.run;com/example/Server.handle;com/example/Json.encode 60
+java/lang/Thread.run;com/example/Server.handle;com/example/Db.query 30
+java/lang/Thread.run;com/example/Server.handle 10
+java/lang/Thread.run;com/example/Gc.collect;com/example/Json.encode 5
+`
+
+// mockJVMContainer is a containerExecClient emulating a container running a
+// JVM, in which async-profiler writes testCollapsedStacks.
+type mockJVMContainer struct {
+	copied   map[string][]byte
+	execs    []types.ExecConfig
+	running  map[string]int
+	exitCode int
+	profile  string
+}
+
+func newMockJVMContainer() *mockJVMContainer {
+	return &mockJVMContainer{
+		copied:  make(map[string][]byte),
+		running: make(map[string]int),
+		profile: testCollapsedStacks,
+	}
+}
+
+func (m *mockJVMContainer) CopyToContainer(ctx context.Context, container, path string, content io.Reader, options types.CopyToContainerOptions) error {
+	tr := tar.NewReader(content)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		m.copied[path+"/"+hdr.Name] = data
+	}
+}
+
+func (m *mockJVMContainer) CopyFromContainer(ctx context.Context, container, srcPath string) (io.ReadCloser, types.ContainerPathStat, error) {
+	if srcPath != asyncProfilerOutput {
+		return nil, types.ContainerPathStat{}, fmt.Errorf("no such file %s", srcPath)
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "profile.collapsed", Mode: 0644, Size: int64(len(m.profile))})
+	tw.Write([]byte(m.profile))
+	tw.Close()
+	return ioutil.NopCloser(&buf), types.ContainerPathStat{Name: "profile.collapsed"}, nil
+}
+
+func (m *mockJVMContainer) ContainerExecCreate(ctx context.Context, container string, config types.ExecConfig) (types.IDResponse, error) {
+	m.execs = append(m.execs, config)
+	return types.IDResponse{ID: fmt.Sprintf("exec-%d", len(m.execs))}, nil
+}
+
+func (m *mockJVMContainer) ContainerExecStart(ctx context.Context, execID string, config types.ExecStartCheck) error {
+	// the profiler reports running for a couple of polls
+	m.running[execID] = 2
+	return nil
+}
+
+func (m *mockJVMContainer) ContainerExecInspect(ctx context.Context, execID string) (types.ContainerExecInspect, error) {
+	if m.running[execID] > 0 {
+		m.running[execID]--
+		return types.ContainerExecInspect{ExecID: execID, Running: true}, nil
+	}
+	if execID == "exec-1" {
+		return types.ContainerExecInspect{ExecID: execID, ExitCode: m.exitCode}, nil
+	}
+	return types.ContainerExecInspect{ExecID: execID}, nil
+}
+
+func TestProfileJVM(t *testing.T) {
+	tempFolder, err := newTempFolder("test-async-profiler")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	require.NoError(t, tempFolder.add("profiler.sh", "#!/bin/sh\n"))
+	require.NoError(t, tempFolder.add("build/libasyncProfiler.so", "ELF"))
+
+	d := &DockerUtil{cfg: &Config{JVMProfilingEnabled: true, AsyncProfilerPath: tempFolder.RootPath}}
+	cli := newMockJVMContainer()
+	report, err := d.profileJVM(context.Background(), cli, "jvm", 7, 30*time.Second, "")
+	require.NoError(t, err)
+
+	assert.Equal(t, []byte("#!/bin/sh\n"), cli.copied["/tmp/datadog-async-profiler/profiler.sh"])
+	assert.Equal(t, []byte("ELF"), cli.copied["/tmp/datadog-async-profiler/build/libasyncProfiler.so"])
+	require.Len(t, cli.execs, 2)
+	assert.Equal(t, []string{
+		"/tmp/datadog-async-profiler/profiler.sh", "-d", "30", "-e", "cpu",
+		"-o", "collapsed", "-f", "/tmp/datadog-async-profiler/profile.collapsed", "7",
+	}, cli.execs[0].Cmd)
+	assert.Equal(t, []string{"rm", "-rf", "/tmp/datadog-async-profiler"}, cli.execs[1].Cmd)
+
+	assert.Equal(t, []string{
+		"com/example/Json.encode",
+		"com/example/Db.query",
+		"com/example/Server.handle",
+	}, report.TopMethods)
+	svg := string(report.FlameGraphSVG)
+	assert.True(t, strings.HasPrefix(svg, "<svg "), svg)
+	assert.Contains(t, svg, "<title>all (105 samples, 100.00%)</title>")
+	assert.Contains(t, svg, "<title>com/example/Server.handle (100 samples, 95.24%)</title>")
+	assert.Contains(t, svg, "<title>com/example/Json.encode (60 samples, 57.14%)</title>")
+}
+
+func TestProfileJVMFailure(t *testing.T) {
+	tempFolder, err := newTempFolder("test-async-profiler-failure")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	require.NoError(t, tempFolder.add("profiler.sh", "#!/bin/sh\n"))
+
+	d := &DockerUtil{cfg: &Config{JVMProfilingEnabled: true, AsyncProfilerPath: tempFolder.RootPath}}
+	cli := newMockJVMContainer()
+	cli.exitCode = 1
+	_, err = d.profileJVM(context.Background(), cli, "jvm", 7, time.Second, "alloc")
+	assert.Error(t, err)
+	// async-profiler is removed even when profiling fails
+	require.Len(t, cli.execs, 2)
+	assert.Equal(t, "alloc", cli.execs[0].Cmd[4])
+	assert.Equal(t, "rm", cli.execs[1].Cmd[0])
+
+	_, err = d.profileJVM(context.Background(), cli, "jvm", 7, 0, "cpu")
+	assert.Error(t, err)
+}
+
+func TestAttachAsyncProfilerDisabled(t *testing.T) {
+	d := &DockerUtil{cfg: &Config{}}
+	_, err := d.AttachAsyncProfiler(context.Background(), "jvm", time.Second, "cpu")
+	assert.Error(t, err)
+}
+
+func TestFindJVM(t *testing.T) {
+	tempFolder, err := newTempFolder("test-find-jvm")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+
+	require.NoError(t, tempFolder.add("100/comm", "sh\n"))
+	require.NoError(t, tempFolder.add("100/status", "Name:\tsh\nNSpid:\t100\t1\n"))
+	require.NoError(t, tempFolder.add("101/comm", "java\n"))
+	require.NoError(t, tempFolder.add("101/status", "Name:\tjava\nPid:\t101\nNSpid:\t101\t7\n"))
+
+	pid, err := findJVM(tempFolder.RootPath, []int{100, 101})
+	require.NoError(t, err)
+	assert.Equal(t, 7, pid)
+
+	_, err = findJVM(tempFolder.RootPath, []int{100})
+	assert.Error(t, err)
+
+	require.NoError(t, tempFolder.add("102/comm", "java\n"))
+	require.NoError(t, tempFolder.add("102/status", "Name:\tjava\nPid:\t102\n"))
+	_, err = findJVM(tempFolder.RootPath, []int{102})
+	assert.Error(t, err)
+}
+
+func TestParseCollapsedStacks(t *testing.T) {
+	stacks, err := parseCollapsedStacks(strings.NewReader("a;b 3\n\na;b 2\na 1\n"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"a;b": 5, "a": 1}, stacks)
+
+	_, err = parseCollapsedStacks(strings.NewReader("a;b\n"))
+	assert.Error(t, err)
+	_, err = parseCollapsedStacks(strings.NewReader("a;b x\n"))
+	assert.Error(t, err)
+
+}
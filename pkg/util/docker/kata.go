@@ -0,0 +1,90 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// kataSandboxDir is the directory of the sandboxes of Kata Containers. It is a
+// variable to be replaced in tests.
+var kataSandboxDir = "/run/vc/sbs"
+
+// KataVMMetrics is the resource usage of the VM of a Kata container.
+type KataVMMetrics struct {
+	VMCPUPercent   float64
+	VMMemoryUsedMB float64
+	// KernelVersion is the version of the guest kernel of the VM.
+	KernelVersion string
+}
+
+// kataMetricsFile is the metrics.json file written by the Kata agent in the
+// directory of the sandbox.
+type kataMetricsFile struct {
+	CPUPercent      float64 `json:"cpu_percent"`
+	MemoryUsedBytes uint64  `json:"memory_used_bytes"`
+	KernelVersion   string  `json:"kernel_version"`
+}
+
+// GetKataVMMetrics returns the resource usage of the VM running the container
+// identified by id, when it runs with a Kata Containers runtime, as reported by
+// the Kata agent in /run/vc/sbs/{id}/metrics.json. The usage is emitted as the
+// datadog.docker.container.kata.vm_cpu_percent and vm_memory_used gauges.
+func (d *DockerUtil) GetKataVMMetrics(ctx context.Context, id string) (*KataVMMetrics, error) {
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return nil, err
+	}
+	return kataVMMetrics(c, kataSandboxDir)
+}
+
+func kataVMMetrics(c types.ContainerJSON, dir string) (*KataVMMetrics, error) {
+	if c.ContainerJSONBase == nil || c.HostConfig == nil {
+		return nil, errors.New("invalid container: no host config")
+	}
+	if !isKataRuntime(c.HostConfig.Runtime) {
+		return nil, fmt.Errorf("container %s does not run in a Kata Containers VM", c.ID)
+	}
+	path := filepath.Join(dir, c.ID, "metrics.json")
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no metrics reported by the Kata agent for container %s", c.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m kataMetricsFile
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %s", path, err)
+	}
+	metrics := &KataVMMetrics{
+		VMCPUPercent:   m.CPUPercent,
+		VMMemoryUsedMB: float64(m.MemoryUsedBytes) / (1 << 20),
+		KernelVersion:  m.KernelVersion,
+	}
+
+	tags := containerTags(c.ID, c.Name)
+	gauge("datadog.docker.container.kata.vm_cpu_percent", metrics.VMCPUPercent, tags)
+	gauge("datadog.docker.container.kata.vm_memory_used", metrics.VMMemoryUsedMB, tags)
+	return metrics, nil
+}
+
+// isKataRuntime returns whether the given OCI runtime, such as kata-runtime,
+// kata-qemu or io.containerd.kata.v2, is a Kata Containers runtime.
+func isKataRuntime(runtime string) bool {
+	return strings.HasPrefix(runtime, "kata") || strings.Contains(runtime, ".kata.")
+}
@@ -0,0 +1,70 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestKataContainer(id, runtime string) types.ContainerJSON {
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:         id,
+			Name:       "/vault",
+			HostConfig: &container.HostConfig{Runtime: runtime},
+		},
+	}
+}
+
+func TestKataVMMetrics(t *testing.T) {
+	tempFolder, err := newTempFolder("test-kata")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	require.NoError(t, tempFolder.add("kata1/metrics.json", `{"cpu_percent": 12.5, "memory_used_bytes": 268435456, "kernel_version": "5.4.32-kata"}`))
+	require.NoError(t, tempFolder.add("kata2/metrics.json", `{"cpu_percent": `))
+
+	withTestStatsClient(func(c *testStatsClient) {
+		metrics, err := kataVMMetrics(newTestKataContainer("kata1", "kata-runtime"), tempFolder.RootPath)
+		require.NoError(t, err)
+		assert.Equal(t, &KataVMMetrics{VMCPUPercent: 12.5, VMMemoryUsedMB: 256, KernelVersion: "5.4.32-kata"}, metrics)
+		tags := []string{"container_id:kata1", "container_name:vault"}
+		assert.Equal(t, []testStatsSample{
+			{Name: "datadog.docker.container.kata.vm_cpu_percent", Value: 12.5, Tags: tags},
+			{Name: "datadog.docker.container.kata.vm_memory_used", Value: 256, Tags: tags},
+		}, c.gauges)
+	})
+
+	_, err = kataVMMetrics(newTestKataContainer("kata2", "io.containerd.kata.v2"), tempFolder.RootPath)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "could not parse")
+	}
+	_, err = kataVMMetrics(newTestKataContainer("kata3", "kata-qemu"), tempFolder.RootPath)
+	assert.EqualError(t, err, "no metrics reported by the Kata agent for container kata3")
+	_, err = kataVMMetrics(newTestKataContainer("kata1", "runc"), tempFolder.RootPath)
+	assert.EqualError(t, err, "container kata1 does not run in a Kata Containers VM")
+	_, err = kataVMMetrics(types.ContainerJSON{}, tempFolder.RootPath)
+	assert.EqualError(t, err, "invalid container: no host config")
+}
+
+func TestIsKataRuntime(t *testing.T) {
+	for runtime, kata := range map[string]bool{
+		"kata-runtime":          true,
+		"kata-fc":               true,
+		"io.containerd.kata.v2": true,
+		"runc":                  false,
+		"runsc":                 false,
+		"":                      false,
+	} {
+		assert.Equal(t, kata, isKataRuntime(runtime), runtime)
+	}
+}
@@ -0,0 +1,122 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+var kernelVersionRe = regexp.MustCompile(`Linux version (\d+)\.(\d+)(?:\.(\d+))?`)
+
+// KernelVersion is the version of a Linux kernel.
+type KernelVersion struct {
+	Major, Minor, Patch int
+}
+
+// String returns the version in major.minor.patch form.
+func (v KernelVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// atLeast returns whether v is the same version as min or a newer one.
+func (v KernelVersion) atLeast(min KernelVersion) bool {
+	if v.Major != min.Major {
+		return v.Major > min.Major
+	}
+	if v.Minor != min.Minor {
+		return v.Minor > min.Minor
+	}
+	return v.Patch >= min.Patch
+}
+
+// KernelFeatureRequirements is the minimal kernel version required by each
+// feature checked by CheckKernelCompatibility. Entries can be added to check
+// other features.
+var KernelFeatureRequirements = map[string]KernelVersion{
+	"cgroup2":                  {4, 5, 0},
+	"cgroup2_cpu_controller":   {4, 15, 0},
+	"cgroup2_psi":              {4, 20, 0},
+	"cgroup2_memory_oom_group": {4, 19, 0},
+	"cgroup2_freezer":          {5, 2, 0},
+	"cgroup2_io_cost":          {5, 4, 0},
+	"cgroup2_memory_low":       {5, 4, 0},
+	"cgroup2_cpu_idle":         {5, 15, 0},
+}
+
+// KernelCompatibility lists which features the kernel of the host supports.
+type KernelCompatibility struct {
+	Available   []string
+	Unavailable []string
+}
+
+// CheckKernelCompatibility returns which of the given features are supported
+// by the kernel of the host, as read in /proc/version, according to
+// KernelFeatureRequirements. Unknown features, and all features when the
+// version of the kernel can't be read, are unavailable.
+func (d *DockerUtil) CheckKernelCompatibility(features []string) *KernelCompatibility {
+	path := filepath.Join(config.Datadog.GetString("container_proc_root"), "version")
+	version, err := readKernelVersion(path)
+	if err != nil {
+		log.Warnf("Could not get the kernel version: %s", err)
+	}
+	return kernelCompatibility(version, features)
+}
+
+// kernelCompatibility checks the given features against version, which is nil
+// when unknown.
+func kernelCompatibility(version *KernelVersion, features []string) *KernelCompatibility {
+	compat := &KernelCompatibility{}
+	for _, f := range features {
+		required, ok := KernelFeatureRequirements[f]
+		switch {
+		case !ok:
+			log.Warnf("Unknown kernel feature %q", f)
+		case version == nil:
+			log.Warnf("Kernel feature %q needs kernel %s, the kernel version is unknown", f, required)
+		case !version.atLeast(required):
+			log.Warnf("Kernel feature %q is unavailable: it needs kernel %s, running %s", f, required, version)
+		default:
+			compat.Available = append(compat.Available, f)
+			continue
+		}
+		compat.Unavailable = append(compat.Unavailable, f)
+	}
+	return compat
+}
+
+// readKernelVersion reads the kernel version in the given /proc/version file.
+func readKernelVersion(path string) (*KernelVersion, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseKernelVersion(string(content))
+}
+
+// parseKernelVersion parses the content of /proc/version, such as:
+// Linux version 5.4.0-42-generic (buildd@lgw01-amd64-038) (gcc version 9.3.0) #46-Ubuntu SMP
+func parseKernelVersion(content string) (*KernelVersion, error) {
+	m := kernelVersionRe.FindStringSubmatch(content)
+	if m == nil {
+		return nil, fmt.Errorf("no kernel version in %q", content)
+	}
+	v := &KernelVersion{}
+	v.Major, _ = strconv.Atoi(m[1])
+	v.Minor, _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		v.Patch, _ = strconv.Atoi(m[3])
+	}
+	return v, nil
+}
@@ -0,0 +1,67 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKernelVersion(t *testing.T) {
+	for _, tc := range []struct {
+		content  string
+		expected *KernelVersion
+	}{
+		{"Linux version 5.4.0-42-generic (buildd@lgw01-amd64-038) (gcc version 9.3.0 (Ubuntu 9.3.0-10ubuntu2)) #46-Ubuntu SMP", &KernelVersion{5, 4, 0}},
+		{"Linux version 4.19.112+ (builder@ed1c5a2f4b7b) #1 SMP Sat Oct 10 13:45:37 PDT 2020\n", &KernelVersion{4, 19, 112}},
+		{"Linux version 3.10.0-1127.el7.x86_64 (mockbuild@kbuilder.bsys.centos.org)", &KernelVersion{3, 10, 0}},
+		{"Linux version 6.1-rc1 (dev@host)", &KernelVersion{6, 1, 0}},
+		{"Darwin Kernel Version 19.6.0", nil},
+	} {
+		v, err := parseKernelVersion(tc.content)
+		if tc.expected == nil {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, v)
+	}
+}
+
+func TestKernelCompatibility(t *testing.T) {
+	features := []string{"cgroup2", "cgroup2_io_cost", "cgroup2_cpu_idle", "unknown"}
+
+	compat := kernelCompatibility(&KernelVersion{5, 4, 0}, features)
+	assert.Equal(t, []string{"cgroup2", "cgroup2_io_cost"}, compat.Available)
+	assert.Equal(t, []string{"cgroup2_cpu_idle", "unknown"}, compat.Unavailable)
+
+	compat = kernelCompatibility(&KernelVersion{4, 19, 112}, features)
+	assert.Equal(t, []string{"cgroup2"}, compat.Available)
+	assert.Equal(t, []string{"cgroup2_io_cost", "cgroup2_cpu_idle", "unknown"}, compat.Unavailable)
+
+	compat = kernelCompatibility(nil, features)
+	assert.Empty(t, compat.Available)
+	assert.Equal(t, features, compat.Unavailable)
+}
+
+func TestReadKernelVersion(t *testing.T) {
+	tempFolder, err := newTempFolder("test-kernel-version")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	require.NoError(t, tempFolder.add("version", "Linux version 5.10.47-linuxkit (root@buildkitsandbox) (gcc (Alpine 10.2.1_pre1) 10.2.1 20201203) #1 SMP Sat Jul 3 21:51:47 UTC 2021\n"))
+
+	v, err := readKernelVersion(filepath.Join(tempFolder.RootPath, "version"))
+	require.NoError(t, err)
+	assert.Equal(t, &KernelVersion{5, 10, 47}, v)
+
+	_, err = readKernelVersion(filepath.Join(tempFolder.RootPath, "missing"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,102 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// fluentdLogDriver is the name of the fluentd log driver.
+const fluentdLogDriver = "fluentd"
+
+// LogDriverMetrics holds the metrics of the log driver of a container. Metrics
+// are only collected for the fluentd driver, they are zero for other drivers.
+type LogDriverMetrics struct {
+	Driver string
+	// QueueDepth is the number of buffer chunks waiting to be flushed by the
+	// output plugins.
+	QueueDepth int64
+	// DroppedMessages is the number of errors reported by the output plugins,
+	// after which messages are retried or dropped.
+	DroppedMessages int64
+}
+
+// fluentdPlugins is the response of the Fluentd monitor_agent plugins API.
+type fluentdPlugins struct {
+	Plugins []struct {
+		OutputPlugin      bool  `json:"output_plugin"`
+		BufferQueueLength int64 `json:"buffer_queue_length"`
+		NumErrors         int64 `json:"num_errors"`
+	} `json:"plugins"`
+}
+
+// GetLogDriverMetrics returns the metrics of the log driver of the container
+// identified by id. For the fluentd driver, they are read from the Fluentd
+// monitoring API and emitted as the datadog.docker.container.log_driver.queue_depth
+// and dropped_messages gauges.
+func (d *DockerUtil) GetLogDriverMetrics(ctx context.Context, id string) (*LogDriverMetrics, error) {
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return nil, err
+	}
+	return d.logDriverMetrics(ctx, c)
+}
+
+func (d *DockerUtil) logDriverMetrics(ctx context.Context, c types.ContainerJSON) (*LogDriverMetrics, error) {
+	if c.ContainerJSONBase == nil || c.HostConfig == nil {
+		return nil, errors.New("invalid container: no host config")
+	}
+	m := &LogDriverMetrics{Driver: c.HostConfig.LogConfig.Type}
+	if m.Driver != fluentdLogDriver {
+		return m, nil
+	}
+	plugins, err := d.fluentdPlugins(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range plugins.Plugins {
+		if !p.OutputPlugin {
+			continue
+		}
+		m.QueueDepth += p.BufferQueueLength
+		m.DroppedMessages += p.NumErrors
+	}
+	tags := append(containerTags(c.ID, c.Name), "log_driver:"+m.Driver)
+	gauge("datadog.docker.container.log_driver.queue_depth", float64(m.QueueDepth), tags)
+	gauge("datadog.docker.container.log_driver.dropped_messages", float64(m.DroppedMessages), tags)
+	return m, nil
+}
+
+// fluentdPlugins queries the Fluentd monitoring API for the metrics of its plugins.
+func (d *DockerUtil) fluentdPlugins(ctx context.Context) (*fluentdPlugins, error) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(d.cfg.FluentdMonitorURL, "/")+"/api/plugins.json", nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: d.queryTimeout}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error querying the Fluentd monitoring API: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected Fluentd monitoring API response: %s", resp.Status)
+	}
+	var plugins fluentdPlugins
+	if err := json.NewDecoder(resp.Body).Decode(&plugins); err != nil {
+		return nil, fmt.Errorf("error decoding Fluentd plugins: %s", err)
+	}
+	return &plugins, nil
+}
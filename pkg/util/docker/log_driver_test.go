@@ -0,0 +1,86 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLogContainer(driver string) types.ContainerJSON {
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:   "abc",
+			Name: "/web",
+			HostConfig: &container.HostConfig{
+				LogConfig: container.LogConfig{Type: driver},
+			},
+		},
+	}
+}
+
+func TestLogDriverMetrics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/plugins.json", r.URL.Path)
+		w.Write([]byte(`{"plugins": [
+			{"plugin_id": "in_forward", "type": "forward", "output_plugin": false},
+			{"plugin_id": "out_es", "type": "elasticsearch", "output_plugin": true, "buffer_queue_length": 12, "num_errors": 3},
+			{"plugin_id": "out_s3", "type": "s3", "output_plugin": true, "buffer_queue_length": 4, "num_errors": 1}
+		]}`))
+	}))
+	defer srv.Close()
+	d := &DockerUtil{cfg: &Config{FluentdMonitorURL: srv.URL + "/"}, queryTimeout: time.Second}
+
+	withTestStatsClient(func(c *testStatsClient) {
+		m, err := d.logDriverMetrics(context.Background(), newTestLogContainer("fluentd"))
+		require.NoError(t, err)
+		assert.Equal(t, &LogDriverMetrics{Driver: "fluentd", QueueDepth: 16, DroppedMessages: 4}, m)
+		tags := []string{"container_id:abc", "container_name:web", "log_driver:fluentd"}
+		assert.Equal(t, []testStatsSample{
+			{Name: "datadog.docker.container.log_driver.queue_depth", Value: 16, Tags: tags},
+			{Name: "datadog.docker.container.log_driver.dropped_messages", Value: 4, Tags: tags},
+		}, c.gauges)
+	})
+
+	withTestStatsClient(func(c *testStatsClient) {
+		m, err := d.logDriverMetrics(context.Background(), newTestLogContainer("json-file"))
+		require.NoError(t, err)
+		assert.Equal(t, &LogDriverMetrics{Driver: "json-file"}, m)
+		assert.Empty(t, c.gauges, "only the fluentd driver reports metrics")
+	})
+
+	_, err := d.logDriverMetrics(context.Background(), types.ContainerJSON{})
+	assert.Error(t, err)
+}
+
+func TestLogDriverMetricsFluentdErrors(t *testing.T) {
+	for name, handler := range map[string]http.HandlerFunc{
+		"status": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		},
+		"decode": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"plugins": {}}`))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(handler)
+			defer srv.Close()
+			d := &DockerUtil{cfg: &Config{FluentdMonitorURL: srv.URL}, queryTimeout: time.Second}
+			_, err := d.logDriverMetrics(context.Background(), newTestLogContainer("fluentd"))
+			assert.Error(t, err)
+		})
+	}
+}
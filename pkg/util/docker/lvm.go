@@ -0,0 +1,129 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// lvsThinVolumes returns the output of lvs listing the thin volumes. It is a
+// variable to be replaced in tests.
+var lvsThinVolumes = func(ctx context.Context) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "lvs", "--thin", "--noheadings", "-o", "name,size,pool_lv,data_percent")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("lvs: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// LVMThinStats holds the usage of the LVM thin volume of a container.
+type LVMThinStats struct {
+	DataPercent float64
+	SizeBytes   int64
+	UsedBytes   int64
+}
+
+// GetLVMThinStats returns the usage of the LVM thin volume of the container
+// identified by id, as listed by lvs. The volume is the device of the container
+// when it uses the devicemapper storage driver, or else the thin volume whose
+// name holds the ID of the container. The data usage is sent as
+// datadog.docker.container.lvm_data_usage_percent, since thin volumes can
+// over-commit the storage of their pool.
+func (d *DockerUtil) GetLVMThinStats(ctx context.Context, id string) (*LVMThinStats, error) {
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return nil, err
+	}
+	out, err := lvsThinVolumes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return lvmThinStats(c, out)
+}
+
+func lvmThinStats(c types.ContainerJSON, out []byte) (*LVMThinStats, error) {
+	if c.ContainerJSONBase == nil {
+		return nil, fmt.Errorf("invalid container")
+	}
+	deviceName := c.GraphDriver.Data["DeviceName"]
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		// docker-253:0-1234-5ee1e5 10.00g docker-pool 25.50
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 4 {
+			// pools and regular volumes have no pool
+			continue
+		}
+		name := fields[0]
+		if name != deviceName && !strings.Contains(name, c.ID) {
+			continue
+		}
+		size, err := parseLVMSize(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid size of volume %s: %v", name, err)
+		}
+		percent, err := strconv.ParseFloat(fields[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data percent of volume %s: %v", name, err)
+		}
+		stats := &LVMThinStats{
+			DataPercent: percent,
+			SizeBytes:   size,
+			UsedBytes:   int64(float64(size) * percent / 100),
+		}
+		tags := append(containerTags(c.ID, c.Name), "lvm_volume:"+name, "lvm_pool:"+fields[2])
+		gauge("datadog.docker.container.lvm_data_usage_percent", percent, tags)
+		return stats, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("no LVM thin volume found for container %s", c.ID)
+}
+
+// lvmUnits are the multipliers of the units of the sizes printed by lvs.
+var lvmUnits = map[byte]float64{
+	'b': 1,
+	's': 512,
+	'k': 1 << 10,
+	'm': 1 << 20,
+	'g': 1 << 30,
+	't': 1 << 40,
+	'p': 1 << 50,
+	'e': 1 << 60,
+}
+
+// parseLVMSize parses a size printed by lvs, such as 10.00g or <1.50t, into
+// bytes.
+func parseLVMSize(s string) (int64, error) {
+	s = strings.TrimLeft(strings.ToLower(s), "<>")
+	if s == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+	unit := 1.0
+	if u, ok := lvmUnits[s[len(s)-1]]; ok {
+		unit = u
+		s = s[:len(s)-1]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(v * unit), nil
+}
@@ -0,0 +1,76 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testLVSThinVolumes = `  docker-pool                      <100.00g                 12.34
+  docker-253:0-1234-5ee1e5           10.00g docker-pool      25.50
+  vol-db9f3a0c2e7b                  <1.50t docker-pool       2.00
+  root                              20.00g
+`
+
+func TestLVMThinStats(t *testing.T) {
+	newContainer := func(id, deviceName string) types.ContainerJSON {
+		c := types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{ID: id, Name: "/" + id}}
+		if deviceName != "" {
+			c.GraphDriver = types.GraphDriverData{Name: "devicemapper", Data: map[string]string{"DeviceName": deviceName}}
+		}
+		return c
+	}
+
+	withTestStatsClient(func(stats *testStatsClient) {
+		s, err := lvmThinStats(newContainer("web", "docker-253:0-1234-5ee1e5"), []byte(testLVSThinVolumes))
+		require.NoError(t, err)
+		assert.Equal(t, &LVMThinStats{DataPercent: 25.5, SizeBytes: 10 << 30, UsedBytes: 2738041651}, s)
+
+		s, err = lvmThinStats(newContainer("db9f3a0c2e7b", ""), []byte(testLVSThinVolumes))
+		require.NoError(t, err)
+		assert.Equal(t, &LVMThinStats{DataPercent: 2, SizeBytes: 1.5 * (1 << 40), UsedBytes: 32985348833}, s)
+
+		assert.Equal(t, []testStatsSample{{
+			Name:  "datadog.docker.container.lvm_data_usage_percent",
+			Value: 25.5,
+			Tags:  []string{"container_id:web", "container_name:web", "lvm_volume:docker-253:0-1234-5ee1e5", "lvm_pool:docker-pool"},
+		}, {
+			Name:  "datadog.docker.container.lvm_data_usage_percent",
+			Value: 2,
+			Tags:  []string{"container_id:db9f3a0c2e7b", "container_name:db9f3a0c2e7b", "lvm_volume:vol-db9f3a0c2e7b", "lvm_pool:docker-pool"},
+		}}, stats.gauges)
+	})
+
+	// pools and regular volumes are not container volumes
+	for _, id := range []string{"docker-pool", "root", "unknown"} {
+		_, err := lvmThinStats(newContainer(id, ""), []byte(testLVSThinVolumes))
+		assert.Error(t, err, id)
+	}
+}
+
+func TestParseLVMSize(t *testing.T) {
+	for in, want := range map[string]int64{
+		"512":     512,
+		"4.00m":   4 << 20,
+		"<10.50g": 10.5 * (1 << 30),
+		"2.00T":   2 << 40,
+		"8s":      4096,
+	} {
+		got, err := parseLVMSize(in)
+		assert.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	for _, in := range []string{"", "g", "ten"} {
+		_, err := parseLVMSize(in)
+		assert.Error(t, err, in)
+	}
+}
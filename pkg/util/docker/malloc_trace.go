@@ -0,0 +1,150 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// maxTrackedAllocations is the maximum number of outstanding allocations
+// tracked while tracing a container, bounding the memory used by the agent.
+const maxTrackedAllocations = 100000
+
+// allocationEvent is a call to malloc or free reported by the eBPF probes.
+type allocationEvent struct {
+	// Free is true for calls to free, false for calls to malloc.
+	Free    bool
+	Address int64
+	// Size and CallStack are only set for calls to malloc.
+	Size      int64
+	CallStack string
+}
+
+// allocationProbe reports the allocations of the traced processes.
+type allocationProbe interface {
+	// Events returns the channel the allocation events are sent to.
+	Events() <-chan allocationEvent
+	// Close detaches the probes.
+	Close() error
+}
+
+// allocationProbeLoader loads the eBPF program tracing memory allocations.
+type allocationProbeLoader interface {
+	// Attach attaches uprobes on malloc and free to the given processes,
+	// reporting the calls to malloc of more than minSize bytes and all calls
+	// to free.
+	Attach(pids []int, minSize int64) (allocationProbe, error)
+}
+
+// allocationProbes loads the allocation tracer. It is nil unless the agent is
+// built with eBPF support.
+var allocationProbes allocationProbeLoader
+
+// Allocation is a block of memory allocated with malloc.
+type Allocation struct {
+	Size      int64
+	Address   int64
+	CallStack string
+}
+
+// AllocationTrace is the result of the tracing of the memory allocations of a
+// container.
+type AllocationTrace struct {
+	// LeakedAllocations lists the allocations which were not freed by the end
+	// of the trace, largest first.
+	LeakedAllocations []Allocation
+}
+
+// TraceMemoryAllocations traces the calls to malloc and free of the processes
+// of the container identified by id for the given duration with eBPF uprobes,
+// and returns the allocations of more than threshold bytes which were not freed
+// by the end of the trace. It requires docker_malloc_tracing_enabled and an
+// agent built with eBPF support.
+func (d *DockerUtil) TraceMemoryAllocations(ctx context.Context, id string, threshold int64, duration time.Duration) (*AllocationTrace, error) {
+	if !d.cfg.MallocTracingEnabled {
+		return nil, errors.New("memory allocation tracing is disabled, set docker_malloc_tracing_enabled to enable it")
+	}
+	if allocationProbes == nil {
+		return nil, errors.New("memory allocation tracing requires eBPF support")
+	}
+	cgroup, err := containerCgroup(id)
+	if err != nil {
+		return nil, err
+	}
+	pids := make([]int, len(cgroup.Pids))
+	for i, pid := range cgroup.Pids {
+		pids[i] = int(pid)
+	}
+	return traceMemoryAllocations(ctx, allocationProbes, pids, threshold, duration)
+}
+
+func traceMemoryAllocations(ctx context.Context, loader allocationProbeLoader, pids []int, threshold int64, duration time.Duration) (*AllocationTrace, error) {
+	if len(pids) == 0 {
+		return nil, errors.New("no pid for this container")
+	}
+	probe, err := loader.Attach(pids, threshold)
+	if err != nil {
+		return nil, fmt.Errorf("could not attach the allocation probes: %s", err)
+	}
+	defer probe.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	outstanding := make(map[int64]Allocation)
+	dropped := 0
+	events := probe.Events()
+loop:
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				break loop
+			}
+			if e.Free {
+				delete(outstanding, e.Address)
+				continue
+			}
+			if e.Size <= threshold {
+				continue
+			}
+			if _, ok := outstanding[e.Address]; !ok && len(outstanding) >= maxTrackedAllocations {
+				dropped++
+				continue
+			}
+			outstanding[e.Address] = Allocation{Size: e.Size, Address: e.Address, CallStack: e.CallStack}
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	if dropped > 0 {
+		log.Debugf("Dropped %d allocations while tracing pids %v, more than %d allocations were outstanding", dropped, pids, maxTrackedAllocations)
+	}
+	if err := ctx.Err(); err != nil && err != context.DeadlineExceeded {
+		return nil, err
+	}
+
+	trace := &AllocationTrace{LeakedAllocations: make([]Allocation, 0, len(outstanding))}
+	for _, a := range outstanding {
+		trace.LeakedAllocations = append(trace.LeakedAllocations, a)
+	}
+	sort.Slice(trace.LeakedAllocations, func(i, j int) bool {
+		a, b := trace.LeakedAllocations[i], trace.LeakedAllocations[j]
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		return a.Address < b.Address
+	})
+	return trace, nil
+}
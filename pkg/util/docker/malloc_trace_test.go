@@ -0,0 +1,114 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockAllocationProbes is an eBPF loader replaying allocation events.
+type mockAllocationProbes struct {
+	events  []allocationEvent
+	pids    []int
+	minSize int64
+	closed  bool
+	// keepOpen keeps the event channel open once the events are sent, like
+	// the probes of a running process.
+	keepOpen bool
+	err      error
+}
+
+func (m *mockAllocationProbes) Attach(pids []int, minSize int64) (allocationProbe, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	m.pids = pids
+	m.minSize = minSize
+	ch := make(chan allocationEvent, len(m.events))
+	for _, e := range m.events {
+		ch <- e
+	}
+	if !m.keepOpen {
+		close(ch)
+	}
+	return &mockAllocationProbe{m, ch}, nil
+}
+
+type mockAllocationProbe struct {
+	loader *mockAllocationProbes
+	events chan allocationEvent
+}
+
+func (p *mockAllocationProbe) Events() <-chan allocationEvent { return p.events }
+
+func (p *mockAllocationProbe) Close() error {
+	p.loader.closed = true
+	return nil
+}
+
+func TestTraceMemoryAllocations(t *testing.T) {
+	loader := &mockAllocationProbes{events: []allocationEvent{
+		{Address: 0x1000, Size: 4096, CallStack: "malloc;cache_insert;main"},
+		{Address: 0x2000, Size: 1 << 20, CallStack: "malloc;read_body;handle"},
+		{Address: 0x3000, Size: 16, CallStack: "malloc;strdup"},
+		{Address: 0x4000, Size: 8192, CallStack: "malloc;buffer_new"},
+		{Free: true, Address: 0x4000},
+		{Free: true, Address: 0x5000},
+		// the address of a freed block is reused
+		{Address: 0x4000, Size: 2048, CallStack: "malloc;buffer_new"},
+	}}
+	trace, err := traceMemoryAllocations(context.Background(), loader, []int{10, 11}, 1024, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, []int{10, 11}, loader.pids)
+	assert.EqualValues(t, 1024, loader.minSize)
+	assert.True(t, loader.closed)
+	assert.Equal(t, []Allocation{
+		{Size: 1 << 20, Address: 0x2000, CallStack: "malloc;read_body;handle"},
+		{Size: 4096, Address: 0x1000, CallStack: "malloc;cache_insert;main"},
+		{Size: 2048, Address: 0x4000, CallStack: "malloc;buffer_new"},
+	}, trace.LeakedAllocations)
+}
+
+func TestTraceMemoryAllocationsDuration(t *testing.T) {
+	loader := &mockAllocationProbes{
+		events:   []allocationEvent{{Address: 0x1000, Size: 4096}},
+		keepOpen: true,
+	}
+	start := time.Now()
+	trace, err := traceMemoryAllocations(context.Background(), loader, []int{10}, 0, 50*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	assert.Len(t, trace.LeakedAllocations, 1)
+	assert.True(t, loader.closed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = traceMemoryAllocations(ctx, loader, []int{10}, 0, time.Minute)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestTraceMemoryAllocationsErrors(t *testing.T) {
+	_, err := traceMemoryAllocations(context.Background(), &mockAllocationProbes{}, nil, 0, time.Second)
+	assert.Error(t, err)
+	_, err = traceMemoryAllocations(context.Background(), &mockAllocationProbes{err: errors.New("permission denied")}, []int{10}, 0, time.Second)
+	assert.Error(t, err)
+
+	d := &DockerUtil{cfg: &Config{}}
+	_, err = d.TraceMemoryAllocations(context.Background(), "leaky", 0, time.Second)
+	assert.Error(t, err)
+
+	d.cfg.MallocTracingEnabled = true
+	_, err = d.TraceMemoryAllocations(context.Background(), "leaky", 0, time.Second)
+	assert.EqualError(t, err, "memory allocation tracing requires eBPF support")
+}
@@ -0,0 +1,221 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+)
+
+// NFSMountMetric holds the I/O statistics of an NFS mount of a container.
+type NFSMountMetric struct {
+	MountPath string
+	// Reads and Writes are the numbers of READ and WRITE RPC calls.
+	Reads  uint64
+	Writes uint64
+	// RTT is the average round trip time of READ and WRITE calls, in
+	// milliseconds. It is 0 when the kernel doesn't report per mount statistics.
+	RTT uint64
+}
+
+// GetNFSMountMetrics returns the I/O statistics of the NFS mounts of the main
+// process of the container identified by id, found in its /proc/{pid}/mountinfo
+// file. Statistics are read per mount from /proc/{pid}/mountstats and, when
+// missing, from the RPC statistics of the network namespace of the container
+// in /proc/{pid}/net/rpc/nfs. They are emitted as the datadog.docker.container.nfs.reads,
+// writes and rtt gauges, tagged by mount path.
+func (d *DockerUtil) GetNFSMountMetrics(ctx context.Context, id string) ([]NFSMountMetric, error) {
+	pid, err := d.containerPID(id)
+	if err != nil {
+		return nil, err
+	}
+	return nfsMountMetrics(id, filepath.Join(config.Datadog.GetString("container_proc_root"), strconv.Itoa(pid)))
+}
+
+func nfsMountMetrics(id, pidDir string) ([]NFSMountMetric, error) {
+	mounts, err := nfsMounts(filepath.Join(pidDir, "mountinfo"))
+	if err != nil || len(mounts) == 0 {
+		return nil, err
+	}
+	stats, err := nfsMountStats(filepath.Join(pidDir, "mountstats"))
+	if err != nil {
+		return nil, err
+	}
+
+	var rpc *NFSMountMetric
+	metrics := make([]NFSMountMetric, 0, len(mounts))
+	for _, mount := range mounts {
+		m, ok := stats[mount]
+		if !ok {
+			// the RPC statistics are shared by all the mounts of the namespace
+			if rpc == nil {
+				rpc = new(NFSMountMetric)
+				rpc.Reads, rpc.Writes, err = nfsRPCCalls(filepath.Join(pidDir, "net", "rpc", "nfs"))
+				if err != nil {
+					return nil, err
+				}
+			}
+			m = NFSMountMetric{MountPath: mount, Reads: rpc.Reads, Writes: rpc.Writes}
+		}
+		tags := []string{"container_id:" + id, "mount_path:" + mount}
+		gauge("datadog.docker.container.nfs.reads", float64(m.Reads), tags)
+		gauge("datadog.docker.container.nfs.writes", float64(m.Writes), tags)
+		gauge("datadog.docker.container.nfs.rtt", float64(m.RTT), tags)
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
+
+// nfsMounts returns the mount points of the NFS file systems listed in the
+// given mountinfo file.
+func nfsMounts(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mounts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 36 35 98:0 /export /data rw,noatime master:1 - nfs4 server:/export rw,vers=4.1
+		fields := strings.Fields(scanner.Text())
+		for i, field := range fields {
+			if field != "-" {
+				continue
+			}
+			if i+1 < len(fields) && len(fields) > 4 && isNFS(fields[i+1]) {
+				mounts = append(mounts, fields[4])
+			}
+			break
+		}
+	}
+	return mounts, scanner.Err()
+}
+
+func isNFS(fstype string) bool {
+	return fstype == "nfs" || fstype == "nfs4"
+}
+
+// nfsMountStats returns the statistics of the NFS mounts listed in the given
+// mountstats file, by mount path. A missing file yields no statistics.
+func nfsMountStats(path string) (map[string]NFSMountMetric, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stats := make(map[string]NFSMountMetric)
+	var (
+		current *NFSMountMetric
+		ops     uint64
+		rtt     uint64
+	)
+	flush := func() {
+		if current == nil {
+			return
+		}
+		if ops > 0 {
+			current.RTT = rtt / ops
+		}
+		stats[current.MountPath] = *current
+		current = nil
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "device" {
+			// device server:/export mounted on /data with fstype nfs4 statvers=1.1
+			flush()
+			if len(fields) >= 8 && fields[3] == "on" && fields[5] == "with" && isNFS(fields[7]) {
+				current = &NFSMountMetric{MountPath: fields[4]}
+				ops, rtt = 0, 0
+			}
+			continue
+		}
+		if current == nil || (fields[0] != "READ:" && fields[0] != "WRITE:") {
+			continue
+		}
+		// READ: ops transmissions timeouts bytes_sent bytes_recv queue rtt execute
+		if len(fields) < 8 {
+			return nil, fmt.Errorf("malformed mountstats line: %q", scanner.Text())
+		}
+		n, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		r, err := strconv.ParseUint(fields[7], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if fields[0] == "READ:" {
+			current.Reads = n
+		} else {
+			current.Writes = n
+		}
+		ops += n
+		rtt += r
+	}
+	flush()
+	return stats, scanner.Err()
+}
+
+// nfsRPCCalls returns the numbers of NFSv3 and NFSv4 READ and WRITE calls
+// found in the given net/rpc/nfs file.
+func nfsRPCCalls(path string) (reads, writes uint64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	// positions of the READ and WRITE procedures, after the number of procedures
+	positions := map[string][2]int{
+		"proc3": {7, 8},
+		"proc4": {2, 3},
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		pos, ok := positions[fields[0]]
+		if !ok {
+			continue
+		}
+		if len(fields) <= pos[1]+1 {
+			return 0, 0, fmt.Errorf("malformed rpc line: %q", scanner.Text())
+		}
+		r, err := strconv.ParseUint(fields[pos[0]+1], 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		w, err := strconv.ParseUint(fields[pos[1]+1], 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		reads += r
+		writes += w
+	}
+	return reads, writes, scanner.Err()
+}
@@ -0,0 +1,101 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMountInfo = `
+17 41 0:17 / /sys rw,nosuid,nodev,noexec,relatime shared:6 - sysfs sysfs rw
+41 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw,errors=remount-ro
+98 41 0:52 / /data rw,relatime shared:50 - nfs4 nas:/export/data rw,vers=4.1,rsize=1048576
+99 41 0:53 / /backups rw,relatime shared:51 - nfs nas:/export/backups rw,vers=3
+100 41 0:54 / /mnt/nfs\040share rw,relatime - nfs4 nas:/export/share rw,vers=4.2
+`
+
+const testMountStats = `device sysfs mounted on /sys with fstype sysfs
+device /dev/sda1 mounted on / with fstype ext4
+device nas:/export/data mounted on /data with fstype nfs4 statvers=1.1
+	opts:	rw,vers=4.1,rsize=1048576,wsize=1048576
+	age:	3600
+	per-op statistics
+	        NULL: 1 1 0 44 24 0 0 0
+	        READ: 120 120 0 17280 491520 5 600 640
+	       WRITE: 30 30 0 127200 4800 2 150 160
+	      COMMIT: 4 4 0 672 416 0 2 2
+
+device nas:/export/backups mounted on /backups with fstype nfs statvers=1.1
+	opts:	rw,vers=3
+	per-op statistics
+	        READ: 0 0 0 0 0 0 0 0
+	       WRITE: 0 0 0 0 0 0 0 0
+`
+
+const testRPCNFS = `net 0 0 0 0
+rpc 1500 0 1500
+proc3 22 0 10 0 12 40 0 7 3 0 0 0 0 0 0 0 0 0 0 1 1 0 0
+proc4 61 1 80 20 4 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0
+`
+
+func TestNFSMountMetrics(t *testing.T) {
+	tempFolder, err := newTempFolder("test-nfs")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	require.NoError(t, tempFolder.add("10/mountinfo", testMountInfo))
+	require.NoError(t, tempFolder.add("10/mountstats", testMountStats))
+	require.NoError(t, tempFolder.add("10/net/rpc/nfs", testRPCNFS))
+
+	withTestStatsClient(func(c *testStatsClient) {
+		metrics, err := nfsMountMetrics("abc", tempFolder.RootPath+"/10")
+		require.NoError(t, err)
+		assert.Equal(t, []NFSMountMetric{
+			{MountPath: "/data", Reads: 120, Writes: 30, RTT: 5},
+			{MountPath: "/backups"},
+			// not in mountstats, falls back to the RPC statistics
+			{MountPath: `/mnt/nfs\040share`, Reads: 87, Writes: 23},
+		}, metrics)
+		require.Len(t, c.gauges, 9)
+		assert.Equal(t, testStatsSample{
+			Name:  "datadog.docker.container.nfs.rtt",
+			Value: 5,
+			Tags:  []string{"container_id:abc", "mount_path:/data"},
+		}, c.gauges[2])
+	})
+
+	t.Run("no mountstats", func(t *testing.T) {
+		require.NoError(t, tempFolder.delete("10/mountstats"))
+		metrics, err := nfsMountMetrics("abc", tempFolder.RootPath+"/10")
+		require.NoError(t, err)
+		for _, m := range metrics {
+			assert.Equal(t, uint64(87), m.Reads)
+			assert.Equal(t, uint64(23), m.Writes)
+			assert.Equal(t, uint64(0), m.RTT)
+		}
+	})
+
+	t.Run("no nfs mount", func(t *testing.T) {
+		require.NoError(t, tempFolder.add("11/mountinfo", "41 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw\n"))
+		withTestStatsClient(func(c *testStatsClient) {
+			metrics, err := nfsMountMetrics("abc", tempFolder.RootPath+"/11")
+			require.NoError(t, err)
+			assert.Empty(t, metrics)
+			assert.Empty(t, c.gauges)
+		})
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		require.NoError(t, tempFolder.add("12/mountinfo", testMountInfo))
+		require.NoError(t, tempFolder.add("12/mountstats", "device nas:/export/data mounted on /data with fstype nfs4\n READ: 1 2\n"))
+		_, err := nfsMountMetrics("abc", tempFolder.RootPath+"/12")
+		assert.Error(t, err)
+	})
+}
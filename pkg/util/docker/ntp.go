@@ -0,0 +1,199 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+const (
+	// chronyTrackingOutput is the file the output of chronyc tracking is
+	// written to in the container.
+	chronyTrackingOutput = "/tmp/datadog-chrony-tracking"
+	// chronyTrackingLog is the tracking log of chronyd, read when chronyc
+	// can't be run.
+	chronyTrackingLog = "/var/run/chrony/tracking.log"
+)
+
+// NTPStatus is the NTP synchronization status of a container, as reported by chrony.
+type NTPStatus struct {
+	// RMSOffset is the long-term average offset of the clock.
+	RMSOffset time.Duration
+	// NTPServer is the server the clock is synchronized with.
+	NTPServer    string
+	Stratum      int
+	Synchronized bool
+}
+
+// GetNTPStatus returns the NTP synchronization status of the container
+// identified by id, from the output of chronyc tracking run in the container
+// or, when it fails, from the last entry of the chrony tracking log. The RMS
+// offset is emitted as the datadog.docker.container.ntp.offset_ms gauge.
+func (d *DockerUtil) GetNTPStatus(ctx context.Context, id string) (*NTPStatus, error) {
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return nil, err
+	}
+	return ntpStatus(ctx, d.cli, c)
+}
+
+func ntpStatus(ctx context.Context, cli containerExecClient, c types.ContainerJSON) (*NTPStatus, error) {
+	if c.ContainerJSONBase == nil {
+		return nil, fmt.Errorf("invalid container")
+	}
+	status, err := chronyTracking(ctx, cli, c.ID)
+	if err != nil {
+		log.Debugf("Could not run chronyc tracking in container %s, reading the tracking log: %s", c.ID, err)
+		var content []byte
+		content, err = readContainerFile(ctx, cli, c.ID, chronyTrackingLog)
+		if err != nil {
+			return nil, fmt.Errorf("could not get the NTP status of container %s: %s", c.ID, err)
+		}
+		status, err = parseChronyTrackingLog(content)
+	}
+	if err != nil {
+		return nil, err
+	}
+	gauge("datadog.docker.container.ntp.offset_ms", float64(status.RMSOffset)/float64(time.Millisecond), containerTags(c.ID, c.Name))
+	return status, nil
+}
+
+// chronyTracking runs chronyc tracking in the container and parses its output.
+func chronyTracking(ctx context.Context, cli containerExecClient, id string) (*NTPStatus, error) {
+	run := types.ExecConfig{Cmd: []string{"sh", "-c", "chronyc tracking > " + chronyTrackingOutput}}
+	exitCode, err := execInContainer(ctx, cli, id, run)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		cleanup := types.ExecConfig{Cmd: []string{"rm", "-f", chronyTrackingOutput}}
+		if _, err := execInContainer(ctx, cli, id, cleanup); err != nil {
+			log.Debugf("Could not remove %s from container %s: %s", chronyTrackingOutput, id, err)
+		}
+	}()
+	if exitCode != 0 {
+		return nil, fmt.Errorf("chronyc tracking exited with code %d", exitCode)
+	}
+	content, err := readContainerFile(ctx, cli, id, chronyTrackingOutput)
+	if err != nil {
+		return nil, err
+	}
+	return parseChronyTracking(content)
+}
+
+// readContainerFile returns the content of the file at path in the container.
+func readContainerFile(ctx context.Context, cli containerExecClient, id, path string) ([]byte, error) {
+	rc, _, err := cli.CopyFromContainer(ctx, id, path)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	tr := tar.NewReader(rc)
+	if _, err := tr.Next(); err != nil {
+		return nil, fmt.Errorf("invalid archive of %s: %s", path, err)
+	}
+	return ioutil.ReadAll(tr)
+}
+
+// parseChronyTracking parses the output of chronyc tracking, such as:
+//   Reference ID    : A9FEA97B (169.254.169.123)
+//   Stratum         : 4
+//   RMS offset      : 0.000023456 seconds
+//   Leap status     : Normal
+func parseChronyTracking(content []byte) (*NTPStatus, error) {
+	status := &NTPStatus{}
+	var found bool
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		switch key {
+		case "Reference ID":
+			status.NTPServer = value
+			if i, j := strings.Index(value, "("), strings.LastIndex(value, ")"); i != -1 && j > i {
+				status.NTPServer = value[i+1 : j]
+			}
+		case "Stratum":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid stratum %q", value)
+			}
+			status.Stratum = n
+		case "RMS offset":
+			seconds, err := strconv.ParseFloat(strings.TrimSuffix(value, " seconds"), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid RMS offset %q", value)
+			}
+			status.RMSOffset = time.Duration(seconds * float64(time.Second))
+			found = true
+		case "Leap status":
+			status.Synchronized = value != "Not synchronised"
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("no RMS offset in the output of chronyc tracking")
+	}
+	// chrony reports stratum 0 until it synchronizes with a server
+	status.Synchronized = status.Synchronized && status.Stratum > 0
+	return status, nil
+}
+
+// parseChronyTrackingLog parses the last entry of a chrony tracking log, whose
+// lines are such as:
+//   2019-10-15 12:00:00 169.254.169.123  4     -3.123      0.012  1.234e-06 N  1  2.345e-05 ...
+// holding the date, time, server, stratum, frequency, skew, offset, leap
+// status, combined sources and offset standard deviation, used as RMS offset.
+func parseChronyTrackingLog(content []byte) (*NTPStatus, error) {
+	var last []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || strings.HasPrefix(fields[0], "=") || fields[0] == "Date" {
+			continue
+		}
+		last = fields
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if last == nil {
+		return nil, fmt.Errorf("no entry in the chrony tracking log")
+	}
+	stratum, err := strconv.Atoi(last[3])
+	if err != nil {
+		return nil, fmt.Errorf("invalid stratum %q", last[3])
+	}
+	sd, err := strconv.ParseFloat(last[9], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid offset standard deviation %q", last[9])
+	}
+	return &NTPStatus{
+		RMSOffset:    time.Duration(sd * float64(time.Second)),
+		NTPServer:    last[2],
+		Stratum:      stratum,
+		Synchronized: last[7] != "?" && stratum > 0,
+	}, nil
+}
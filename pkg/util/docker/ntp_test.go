@@ -0,0 +1,151 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testChronyTracking = `Reference ID    : A9FEA97B (169.254.169.123)
+Stratum         : 4
+Ref time (UTC)  : Tue Oct 15 12:00:00 2019
+System time     : 0.000012345 seconds fast of NTP time
+Last offset     : -0.000001234 seconds
+RMS offset      : 0.002500000 seconds
+Frequency       : 3.123 ppm slow
+Residual freq   : -0.001 ppm
+Skew            : 0.012 ppm
+Root delay      : 0.000345 seconds
+Root dispersion : 0.000123 seconds
+Update interval : 16.1 seconds
+Leap status     : Normal
+`
+
+const testChronyTrackingLog = `===================================================================================================================================
+   Date (UTC) Time     IP Address   St   Freq ppm   Skew ppm     Offset L Co  Offset sd Rem. corr. Root delay Root disp. Max. error
+===================================================================================================================================
+2019-10-15 11:59:00 10.0.0.1         3     -3.123      0.012  1.234e-06 N  1  2.345e-05 -1.200e-07  3.450e-04  1.230e-04  5.600e-04
+2019-10-15 12:00:00 10.0.0.2         2     -3.120      0.010  2.000e-06 N  1  1.500e-03 -1.200e-07  3.450e-04  1.230e-04  5.600e-04
+`
+
+// mockChronyContainer is a containerExecClient emulating a container running
+// chrony, in which chronyc tracking exits with exitCode.
+type mockChronyContainer struct {
+	files    map[string]string
+	execs    [][]string
+	exitCode int
+}
+
+func (m *mockChronyContainer) CopyToContainer(ctx context.Context, container, path string, content io.Reader, options types.CopyToContainerOptions) error {
+	return fmt.Errorf("unexpected copy to %s", path)
+}
+
+func (m *mockChronyContainer) CopyFromContainer(ctx context.Context, container, srcPath string) (io.ReadCloser, types.ContainerPathStat, error) {
+	content, ok := m.files[srcPath]
+	if !ok {
+		return nil, types.ContainerPathStat{}, fmt.Errorf("no such file %s", srcPath)
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: path.Base(srcPath), Mode: 0644, Size: int64(len(content))})
+	tw.Write([]byte(content))
+	tw.Close()
+	return ioutil.NopCloser(&buf), types.ContainerPathStat{Name: path.Base(srcPath)}, nil
+}
+
+func (m *mockChronyContainer) ContainerExecCreate(ctx context.Context, container string, config types.ExecConfig) (types.IDResponse, error) {
+	m.execs = append(m.execs, config.Cmd)
+	return types.IDResponse{ID: fmt.Sprintf("exec-%d", len(m.execs))}, nil
+}
+
+func (m *mockChronyContainer) ContainerExecStart(ctx context.Context, execID string, config types.ExecStartCheck) error {
+	if execID == "exec-1" && m.exitCode == 0 {
+		m.files[chronyTrackingOutput] = testChronyTracking
+	}
+	return nil
+}
+
+func (m *mockChronyContainer) ContainerExecInspect(ctx context.Context, execID string) (types.ContainerExecInspect, error) {
+	if execID == "exec-1" {
+		return types.ContainerExecInspect{ExecID: execID, ExitCode: m.exitCode}, nil
+	}
+	return types.ContainerExecInspect{ExecID: execID}, nil
+}
+
+func TestNTPStatus(t *testing.T) {
+	c := types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{ID: "app", Name: "/app"}}
+
+	// from chronyc tracking
+	cli := &mockChronyContainer{files: make(map[string]string)}
+	withTestStatsClient(func(stats *testStatsClient) {
+		status, err := ntpStatus(context.Background(), cli, c)
+		require.NoError(t, err)
+		assert.Equal(t, &NTPStatus{
+			RMSOffset:    2500 * time.Microsecond,
+			NTPServer:    "169.254.169.123",
+			Stratum:      4,
+			Synchronized: true,
+		}, status)
+		assert.Equal(t, []testStatsSample{
+			{Name: "datadog.docker.container.ntp.offset_ms", Value: 2.5, Tags: []string{"container_id:app", "container_name:app"}},
+		}, stats.gauges)
+	})
+	assert.Equal(t, [][]string{
+		{"sh", "-c", "chronyc tracking > " + chronyTrackingOutput},
+		{"rm", "-f", chronyTrackingOutput},
+	}, cli.execs)
+
+	// from the tracking log when chronyc is missing
+	cli = &mockChronyContainer{
+		files:    map[string]string{chronyTrackingLog: testChronyTrackingLog},
+		exitCode: 127,
+	}
+	status, err := ntpStatus(context.Background(), cli, c)
+	require.NoError(t, err)
+	assert.Equal(t, &NTPStatus{
+		RMSOffset:    1500 * time.Microsecond,
+		NTPServer:    "10.0.0.2",
+		Stratum:      2,
+		Synchronized: true,
+	}, status)
+
+	// without chrony
+	cli = &mockChronyContainer{files: make(map[string]string), exitCode: 127}
+	_, err = ntpStatus(context.Background(), cli, c)
+	assert.Error(t, err)
+}
+
+func TestParseChronyTrackingUnsynchronized(t *testing.T) {
+	status, err := parseChronyTracking([]byte(`Reference ID    : 00000000 ()
+Stratum         : 0
+Ref time (UTC)  : Thu Jan 01 00:00:00 1970
+RMS offset      : 0.000000000 seconds
+Leap status     : Not synchronised
+`))
+	require.NoError(t, err)
+	assert.Equal(t, &NTPStatus{}, status)
+
+	_, err = parseChronyTracking([]byte("506 Cannot talk to daemon\n"))
+	assert.Error(t, err)
+
+	status, err = parseChronyTrackingLog([]byte("2019-10-15 12:00:00 10.0.0.2  0  0.000  0.000  0.000e+00 ?  0  0.000e+00 0 0 0 0\n"))
+	require.NoError(t, err)
+	assert.False(t, status.Synchronized)
+}
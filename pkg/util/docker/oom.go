@@ -0,0 +1,79 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// OOMKillCandidate is a container which could be selected by the kernel OOM killer.
+type OOMKillCandidate struct {
+	ContainerID   string
+	ContainerName string
+	// OOMScore is the value of /proc/{pid}/oom_score for the container's main process.
+	OOMScore int
+}
+
+// SimulateOOMKillOrder returns the running containers in the order in which the
+// OOM killer would select them, the highest oom_score first. The rank of each
+// container is reported as the datadog.docker.container.oom_kill_order gauge.
+func (d *DockerUtil) SimulateOOMKillOrder(ctx context.Context) ([]OOMKillCandidate, error) {
+	procs, err := d.runningContainerProcesses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return oomKillOrder(procs), nil
+}
+
+// oomKillOrder reads the oom_score of the given processes and sorts them by
+// decreasing score. Processes for which it can't be read are skipped.
+func oomKillOrder(procs []containerProcess) []OOMKillCandidate {
+	candidates := make([]OOMKillCandidate, 0, len(procs))
+	for _, p := range procs {
+		score, err := readOOMScore(p.PID)
+		if err != nil {
+			log.Debugf("Cannot get oom_score for container %s: %s", p.ID, err)
+			continue
+		}
+		candidates = append(candidates, OOMKillCandidate{
+			ContainerID:   p.ID,
+			ContainerName: p.Name,
+			OOMScore:      score,
+		})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].OOMScore > candidates[j].OOMScore
+	})
+	for rank, c := range candidates {
+		gauge("datadog.docker.container.oom_kill_order", float64(rank+1), containerTags(c.ContainerID, c.ContainerName))
+	}
+	return candidates
+}
+
+// readOOMScore returns the oom_score of the process identified by pid.
+func readOOMScore(pid int) (int, error) {
+	path := filepath.Join(config.Datadog.GetString("container_proc_root"), strconv.Itoa(pid), "oom_score")
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	score, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid oom_score in %s: %s", path, err)
+	}
+	return score, nil
+}
@@ -0,0 +1,54 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+)
+
+func TestOOMKillOrder(t *testing.T) {
+	dummyProcDir, err := newTempFolder("test-oom-score")
+	require.NoError(t, err)
+	defer dummyProcDir.removeAll()
+	config.Datadog.SetDefault("container_proc_root", dummyProcDir.RootPath)
+	defer config.Datadog.SetDefault("container_proc_root", "/proc")
+
+	require.NoError(t, dummyProcDir.add("10/oom_score", "12\n"))
+	require.NoError(t, dummyProcDir.add("20/oom_score", "850\n"))
+	require.NoError(t, dummyProcDir.add("30/oom_score", "300\n"))
+	require.NoError(t, dummyProcDir.add("40/oom_score", "garbage\n"))
+
+	procs := []containerProcess{
+		{ID: "aaa", Name: "low", PID: 10},
+		{ID: "bbb", Name: "high", PID: 20},
+		{ID: "ccc", Name: "mid", PID: 30},
+		{ID: "ddd", Name: "invalid", PID: 40},
+		{ID: "eee", Name: "gone", PID: 50},
+	}
+
+	withTestStatsClient(func(c *testStatsClient) {
+		candidates := oomKillOrder(procs)
+		assert.Equal(t, []OOMKillCandidate{
+			{ContainerID: "bbb", ContainerName: "high", OOMScore: 850},
+			{ContainerID: "ccc", ContainerName: "mid", OOMScore: 300},
+			{ContainerID: "aaa", ContainerName: "low", OOMScore: 12},
+		}, candidates)
+
+		require.Len(t, c.gauges, 3)
+		for i, g := range c.gauges {
+			assert.Equal(t, "datadog.docker.container.oom_kill_order", g.Name)
+			assert.Equal(t, float64(i+1), g.Value)
+			assert.Equal(t, containerTags(candidates[i].ContainerID, candidates[i].ContainerName), g.Tags)
+		}
+	})
+}
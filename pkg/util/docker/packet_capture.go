@@ -0,0 +1,122 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+var (
+	// tcpdumpPath is the tcpdump binary. It is a variable to be replaced in
+	// tests.
+	tcpdumpPath = "tcpdump"
+	// hostNetClassPath lists the network interfaces of the host.
+	hostNetClassPath = "/sys/class/net"
+)
+
+// CaptureNetworkTraffic captures the packets of the container identified by id
+// matching the given pcap filter, such as "tcp port 80", for the given duration,
+// and returns them in the PCAP format. Packets are captured with tcpdump on the
+// host end of the veth pair of the eth0 interface of the container. Captures
+// are cut short at docker_max_capture_size_bytes. It requires
+// docker_allow_packet_capture.
+func (d *DockerUtil) CaptureNetworkTraffic(ctx context.Context, id string, filter string, duration time.Duration) ([]byte, error) {
+	if !d.cfg.AllowPacketCapture {
+		return nil, errors.New("packet capture is disabled, set docker_allow_packet_capture to enable it")
+	}
+	pid, err := d.containerPID(id)
+	if err != nil {
+		return nil, err
+	}
+	iface, err := hostVethInterface(filepath.Join(config.Datadog.GetString("container_proc_root"), strconv.Itoa(pid)), hostNetClassPath)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Capturing the traffic of container %s on %s for %s, with filter %q", id, iface, duration, filter)
+	return captureTraffic(ctx, iface, filter, duration, d.cfg.MaxCaptureSizeBytes)
+}
+
+// hostVethInterface returns the name of the interface of the host, listed in
+// netClassDir, peered with the eth0 interface of the process found in pidDir.
+func hostVethInterface(pidDir, netClassDir string) (string, error) {
+	iflink, err := ioutil.ReadFile(filepath.Join(pidDir, "root/sys/class/net/eth0/iflink"))
+	if err != nil {
+		return "", fmt.Errorf("could not find the eth0 interface of the container: %s", err)
+	}
+	index := strings.TrimSpace(string(iflink))
+	ifaces, err := ioutil.ReadDir(netClassDir)
+	if err != nil {
+		return "", err
+	}
+	for _, iface := range ifaces {
+		ifindex, err := ioutil.ReadFile(filepath.Join(netClassDir, iface.Name(), "ifindex"))
+		if err == nil && strings.TrimSpace(string(ifindex)) == index {
+			return iface.Name(), nil
+		}
+	}
+	return "", fmt.Errorf("no host interface peered with the eth0 interface of the container, of index %s", index)
+}
+
+// captureTraffic runs tcpdump on iface for the given duration, returning at
+// most maxBytes of its output.
+func captureTraffic(ctx context.Context, iface, filter string, duration time.Duration, maxBytes int64) ([]byte, error) {
+	captureCtx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+	// packets are written as soon as they are captured, rather than when the
+	// buffer is full, so that they are not lost when tcpdump is killed
+	args := []string{"-U", "-w", "-", "-i", iface}
+	if filter != "" {
+		// the filter can't be taken for options, such as -w overwriting a file
+		args = append(args, "--", filter)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(captureCtx, tcpdumpPath, args...)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("tcpdump: %s", err)
+	}
+	out, readErr := ioutil.ReadAll(io.LimitReader(stdout, maxBytes+1))
+	truncated := int64(len(out)) > maxBytes
+	if truncated {
+		out = out[:maxBytes]
+		log.Infof("Packet capture on %s cut short at %d bytes", iface, maxBytes)
+	}
+	// stop tcpdump when cut short
+	cancel()
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+	if err != nil && !truncated && captureCtx.Err() != context.DeadlineExceeded {
+		return nil, fmt.Errorf("tcpdump: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
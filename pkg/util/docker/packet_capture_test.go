@@ -0,0 +1,114 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockTcpdump records its arguments, writes some packets, then waits to be
+// killed as tcpdump would.
+const mockTcpdump = `#!/bin/sh
+echo "$@" > %s/tcpdump.args
+%s
+exec sleep 10
+`
+
+func TestCaptureTraffic(t *testing.T) {
+	tempFolder, err := newTempFolder("test-packet-capture")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	root := tempFolder.RootPath
+
+	defer func(path string) { tcpdumpPath = path }(tcpdumpPath)
+	mock := func(name, packets string) {
+		tcpdumpPath = filepath.Join(root, name)
+		require.NoError(t, ioutil.WriteFile(tcpdumpPath, []byte(fmt.Sprintf(mockTcpdump, root, packets)), 0755))
+	}
+	tcpdumpArgs := func() string {
+		args, _ := ioutil.ReadFile(filepath.Join(root, "tcpdump.args"))
+		return string(args)
+	}
+
+	mock("tcpdump", "printf 'pcap packets'")
+	start := time.Now()
+	out, err := captureTraffic(context.Background(), "veth1a2b3c", "tcp port 80", 200*time.Millisecond, 1024)
+	require.NoError(t, err)
+	assert.Equal(t, "pcap packets", string(out))
+	assert.Equal(t, "-U -w - -i veth1a2b3c -- tcp port 80\n", tcpdumpArgs())
+	assert.True(t, time.Since(start) < 5*time.Second, "tcpdump is stopped after the capture duration")
+
+	_, err = captureTraffic(context.Background(), "veth1a2b3c", "", 100*time.Millisecond, 1024)
+	require.NoError(t, err)
+	assert.Equal(t, "-U -w - -i veth1a2b3c\n", tcpdumpArgs())
+
+	// filters starting with a dash come after the end of the options
+	_, err = captureTraffic(context.Background(), "veth1a2b3c", "-w /etc/passwd", 100*time.Millisecond, 1024)
+	require.NoError(t, err)
+	assert.Equal(t, "-U -w - -i veth1a2b3c -- -w /etc/passwd\n", tcpdumpArgs())
+
+	// the capture is cut short once the size limit is reached
+	mock("tcpdump-large", "head -c 1000 /dev/zero")
+	start = time.Now()
+	out, err = captureTraffic(context.Background(), "veth1a2b3c", "", time.Minute, 100)
+	require.NoError(t, err)
+	assert.Len(t, out, 100)
+	assert.True(t, time.Since(start) < 5*time.Second, "tcpdump is stopped once the size limit is reached")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = captureTraffic(ctx, "veth1a2b3c", "", time.Minute, 1024)
+	assert.Equal(t, context.Canceled, err)
+
+	mock("tcpdump-failing", "echo 'tcpdump: syntax error in filter expression' >&2; exit 1")
+	_, err = captureTraffic(context.Background(), "veth1a2b3c", "tcp port", time.Minute, 1024)
+	assert.EqualError(t, err, "tcpdump: exit status 1: tcpdump: syntax error in filter expression")
+
+	tcpdumpPath = filepath.Join(root, "missing")
+	_, err = captureTraffic(context.Background(), "veth1a2b3c", "", time.Minute, 1024)
+	assert.Error(t, err)
+}
+
+func TestHostVethInterface(t *testing.T) {
+	tempFolder, err := newTempFolder("test-veth")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	root := tempFolder.RootPath
+
+	require.NoError(t, tempFolder.add("proc/42/root/sys/class/net/eth0/iflink", "12\n"))
+	require.NoError(t, tempFolder.add("proc/43/root/sys/class/net/eth0/iflink", "99\n"))
+	require.NoError(t, tempFolder.add("net/lo/ifindex", "1\n"))
+	require.NoError(t, tempFolder.add("net/docker0/ifindex", "3\n"))
+	require.NoError(t, tempFolder.add("net/veth1a2b3c/ifindex", "12\n"))
+	require.NoError(t, tempFolder.add("net/veth4d5e6f/ifindex", "14\n"))
+	netClass := filepath.Join(root, "net")
+
+	iface, err := hostVethInterface(filepath.Join(root, "proc/42"), netClass)
+	require.NoError(t, err)
+	assert.Equal(t, "veth1a2b3c", iface)
+
+	_, err = hostVethInterface(filepath.Join(root, "proc/43"), netClass)
+	assert.EqualError(t, err, "no host interface peered with the eth0 interface of the container, of index 99")
+
+	_, err = hostVethInterface(filepath.Join(root, "proc/44"), netClass)
+	assert.Error(t, err)
+}
+
+func TestCaptureNetworkTrafficDisabled(t *testing.T) {
+	d := &DockerUtil{cfg: &Config{}}
+	_, err := d.CaptureNetworkTraffic(context.Background(), "app", "", time.Second)
+	assert.EqualError(t, err, "packet capture is disabled, set docker_allow_packet_capture to enable it")
+}
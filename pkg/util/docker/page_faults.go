@@ -0,0 +1,153 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// pageFaultExpiry is the time after which the page fault counts of a container
+// which is not collected anymore are forgotten.
+const pageFaultExpiry = 10 * time.Minute
+
+// PageFaultStats holds the page faults of the processes of a container.
+type PageFaultStats struct {
+	// MinorFaults and MajorFaults are the total counts of page faults of the
+	// processes currently running in the container.
+	MinorFaults uint64
+	MajorFaults uint64
+	// MinorPageFaultsPerSec and MajorPageFaultsPerSec are the rates of page
+	// faults since the previous collection. They are 0 on the first collection.
+	MinorPageFaultsPerSec float64
+	MajorPageFaultsPerSec float64
+}
+
+// pageFaultSample is a collection of the page fault counts of a container.
+type pageFaultSample struct {
+	time  time.Time
+	minor uint64
+	major uint64
+}
+
+var pageFaultSamples = struct {
+	sync.Mutex
+	byContainer map[string]pageFaultSample
+}{byContainer: make(map[string]pageFaultSample)}
+
+// GetPageFaultRate returns the minor and major page faults of the processes of
+// the container identified by id, read from the minflt and majflt fields of
+// their /proc/{pid}/stat files, along with their rates since the previous
+// collection. The rates are emitted as the
+// datadog.docker.container.memory.page_faults gauge, tagged by fault_type.
+func (d *DockerUtil) GetPageFaultRate(ctx context.Context, id string) (*PageFaultStats, error) {
+	cgroup, err := containerCgroup(id)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := pageFaultRate(id, config.Datadog.GetString("container_proc_root"), cgroup.Pids, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	d.reportPageFaults(id, stats)
+	return stats, nil
+}
+
+// reportPageFaults emits the page fault rates of a container and warns if it
+// looks to be thrashing.
+func (d *DockerUtil) reportPageFaults(id string, stats *PageFaultStats) {
+	tags := []string{"container_id:" + id}
+	gauge("datadog.docker.container.memory.page_faults", stats.MinorPageFaultsPerSec, append(tags, "fault_type:minor"))
+	gauge("datadog.docker.container.memory.page_faults", stats.MajorPageFaultsPerSec, append(tags, "fault_type:major"))
+	if max := d.cfg.MaxMajorPageFaultsPerSec; max > 0 && stats.MajorPageFaultsPerSec > max {
+		log.Warnf("Container %s has %.1f major page faults per second (max %.1f), it may be thrashing", id, stats.MajorPageFaultsPerSec, max)
+	}
+}
+
+// pageFaultRate sums the page faults of the given processes and computes their
+// rates since the previous collection of the container.
+func pageFaultRate(id, procRoot string, pids []int32, now time.Time) (*PageFaultStats, error) {
+	if len(pids) == 0 {
+		return nil, errors.New("no pid for this container")
+	}
+	stats := &PageFaultStats{}
+	for _, pid := range pids {
+		minor, major, err := processPageFaults(filepath.Join(procRoot, strconv.Itoa(int(pid)), "stat"))
+		if err != nil {
+			// the process may have exited since the cgroup was read
+			log.Debugf("Cannot read page faults of process %d: %s", pid, err)
+			continue
+		}
+		stats.MinorFaults += minor
+		stats.MajorFaults += major
+	}
+
+	pageFaultSamples.Lock()
+	defer pageFaultSamples.Unlock()
+	for cid, s := range pageFaultSamples.byContainer {
+		if now.Sub(s.time) > pageFaultExpiry {
+			delete(pageFaultSamples.byContainer, cid)
+		}
+	}
+	prev, ok := pageFaultSamples.byContainer[id]
+	pageFaultSamples.byContainer[id] = pageFaultSample{time: now, minor: stats.MinorFaults, major: stats.MajorFaults}
+	if !ok {
+		return stats, nil
+	}
+	elapsed := now.Sub(prev.time).Seconds()
+	if elapsed <= 0 {
+		return stats, nil
+	}
+	// the counts of exited processes are lost, consider the counters reset
+	// when they decrease
+	if stats.MinorFaults >= prev.minor {
+		stats.MinorPageFaultsPerSec = float64(stats.MinorFaults-prev.minor) / elapsed
+	}
+	if stats.MajorFaults >= prev.major {
+		stats.MajorPageFaultsPerSec = float64(stats.MajorFaults-prev.major) / elapsed
+	}
+	return stats, nil
+}
+
+// processPageFaults returns the minflt and majflt fields of a /proc/{pid}/stat file.
+func processPageFaults(statPath string) (minor, major uint64, err error) {
+	data, err := ioutil.ReadFile(statPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	// the command name may contain spaces and parentheses, the fields start
+	// after the last parenthesis
+	stat := string(data)
+	i := strings.LastIndexByte(stat, ')')
+	if i < 0 {
+		return 0, 0, fmt.Errorf("invalid stat file %s", statPath)
+	}
+	// fields after the command: state ppid pgrp session tty_nr tpgid flags
+	// minflt cminflt majflt ...
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) < 10 {
+		return 0, 0, fmt.Errorf("invalid stat file %s", statPath)
+	}
+	if minor, err = strconv.ParseUint(fields[7], 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid minflt in %s: %s", statPath, err)
+	}
+	if major, err = strconv.ParseUint(fields[9], 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid majflt in %s: %s", statPath, err)
+	}
+	return minor, major, nil
+}
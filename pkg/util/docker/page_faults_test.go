@@ -0,0 +1,91 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testProcStat(comm string, minflt, majflt uint64) string {
+	return fmt.Sprintf("42 (%s) S 1 42 42 0 -1 4194560 %d 0 %d 0 120 35 0 0 20 0 4 0 1234 123456789 2048 18446744073709551615\n", comm, minflt, majflt)
+}
+
+func TestProcessPageFaults(t *testing.T) {
+	tempFolder, err := newTempFolder("test-page-faults-stat")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+
+	require.NoError(t, tempFolder.add("stat", testProcStat("my (weird) app", 1500, 12)))
+	minor, major, err := processPageFaults(filepath.Join(tempFolder.RootPath, "stat"))
+	require.NoError(t, err)
+	assert.EqualValues(t, 1500, minor)
+	assert.EqualValues(t, 12, major)
+
+	require.NoError(t, tempFolder.add("stat", "42 (app) S 1 42"))
+	_, _, err = processPageFaults(filepath.Join(tempFolder.RootPath, "stat"))
+	assert.Error(t, err)
+}
+
+func TestPageFaultRate(t *testing.T) {
+	tempFolder, err := newTempFolder("test-page-faults")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+
+	now := time.Now()
+	collect := func(pids ...int32) *PageFaultStats {
+		stats, err := pageFaultRate("faulty", tempFolder.RootPath, pids, now)
+		require.NoError(t, err)
+		return stats
+	}
+
+	require.NoError(t, tempFolder.add("10/stat", testProcStat("java", 1000, 10)))
+	require.NoError(t, tempFolder.add("11/stat", testProcStat("sh", 500, 0)))
+	assert.Equal(t, &PageFaultStats{MinorFaults: 1500, MajorFaults: 10}, collect(10, 11, 12), "first collection, pid 12 exited")
+
+	now = now.Add(10 * time.Second)
+	require.NoError(t, tempFolder.add("10/stat", testProcStat("java", 3000, 510)))
+	require.NoError(t, tempFolder.add("11/stat", testProcStat("sh", 1000, 0)))
+	assert.Equal(t, &PageFaultStats{
+		MinorFaults:           4000,
+		MajorFaults:           510,
+		MinorPageFaultsPerSec: 250,
+		MajorPageFaultsPerSec: 50,
+	}, collect(10, 11))
+
+	// process 10 exited, counters decreased
+	now = now.Add(10 * time.Second)
+	assert.Equal(t, &PageFaultStats{MinorFaults: 1000}, collect(11))
+
+	_, err = pageFaultRate("faulty", tempFolder.RootPath, nil, now)
+	assert.Error(t, err)
+
+	// samples are forgotten after a while
+	now = now.Add(pageFaultExpiry + time.Minute)
+	_, err = pageFaultRate("other", tempFolder.RootPath, []int32{11}, now)
+	require.NoError(t, err)
+	pageFaultSamples.Lock()
+	assert.NotContains(t, pageFaultSamples.byContainer, "faulty")
+	pageFaultSamples.Unlock()
+}
+
+func TestReportPageFaults(t *testing.T) {
+	d := &DockerUtil{cfg: &Config{MaxMajorPageFaultsPerSec: 100}}
+	withTestStatsClient(func(c *testStatsClient) {
+		d.reportPageFaults("faulty", &PageFaultStats{MinorPageFaultsPerSec: 250, MajorPageFaultsPerSec: 150})
+		assert.Equal(t, []testStatsSample{
+			{Name: "datadog.docker.container.memory.page_faults", Value: 250, Tags: []string{"container_id:faulty", "fault_type:minor"}},
+			{Name: "datadog.docker.container.memory.page_faults", Value: 150, Tags: []string{"container_id:faulty", "fault_type:major"}},
+		}, c.gauges)
+	})
+}
@@ -0,0 +1,223 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// PDBViolation describes a PodDisruptionBudget which doesn't allow any
+// disruption, because its deployments don't have more available pods than
+// the budget requires.
+type PDBViolation struct {
+	Namespace string
+	PDBName   string
+	// Available is the number of available pods of the deployments matching
+	// the budget.
+	Available int
+	// Required is the minimum number of available pods required by the budget.
+	Required int
+}
+
+// intOrString is a Kubernetes value which is either an integer or a
+// percentage, such as the minAvailable field of PodDisruptionBudgets.
+type intOrString struct {
+	set     bool
+	value   int
+	percent bool
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (v *intOrString) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		if err := json.Unmarshal(b, &v.value); err != nil {
+			return err
+		}
+		v.set = true
+		return nil
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(s, "%"))
+	if err != nil {
+		return fmt.Errorf("invalid int or percentage %q", s)
+	}
+	v.set, v.value, v.percent = true, n, strings.HasSuffix(s, "%")
+	return nil
+}
+
+// scaled returns the value, scaling percentages of total and rounding them up.
+func (v intOrString) scaled(total int) int {
+	if !v.percent {
+		return v.value
+	}
+	return int(math.Ceil(float64(v.value*total) / 100))
+}
+
+// kubernetesLabelSelector is a Kubernetes label selector.
+type kubernetesLabelSelector struct {
+	MatchLabels      map[string]string `json:"matchLabels"`
+	MatchExpressions []struct {
+		Key      string   `json:"key"`
+		Operator string   `json:"operator"`
+		Values   []string `json:"values"`
+	} `json:"matchExpressions"`
+}
+
+// empty returns whether the selector has no requirement.
+func (s *kubernetesLabelSelector) empty() bool {
+	return s == nil || (len(s.MatchLabels) == 0 && len(s.MatchExpressions) == 0)
+}
+
+// matches returns whether the given labels satisfy all the requirements of the selector.
+func (s *kubernetesLabelSelector) matches(labels map[string]string) bool {
+	for k, v := range s.MatchLabels {
+		if value, ok := labels[k]; !ok || value != v {
+			return false
+		}
+	}
+	for _, expr := range s.MatchExpressions {
+		value, ok := labels[expr.Key]
+		in := false
+		for _, v := range expr.Values {
+			in = in || (ok && v == value)
+		}
+		switch expr.Operator {
+		case "In":
+			if !in {
+				return false
+			}
+		case "NotIn":
+			if in {
+				return false
+			}
+		case "Exists":
+			if !ok {
+				return false
+			}
+		case "DoesNotExist":
+			if ok {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// kubernetesPDBList is the subset of the Kubernetes PodDisruptionBudgetList
+// object used to validate budgets.
+type kubernetesPDBList struct {
+	Items []struct {
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		Spec struct {
+			MinAvailable   intOrString              `json:"minAvailable"`
+			MaxUnavailable intOrString              `json:"maxUnavailable"`
+			Selector       *kubernetesLabelSelector `json:"selector"`
+		} `json:"spec"`
+	} `json:"items"`
+}
+
+// kubernetesDeploymentList is the subset of the Kubernetes DeploymentList
+// object used to validate budgets.
+type kubernetesDeploymentList struct {
+	Items []struct {
+		Spec struct {
+			Replicas *int `json:"replicas"`
+			Template struct {
+				Metadata struct {
+					Labels map[string]string `json:"labels"`
+				} `json:"metadata"`
+			} `json:"template"`
+		} `json:"spec"`
+		Status struct {
+			AvailableReplicas int `json:"availableReplicas"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// CheckPodDisruptionBudgets lists the PodDisruptionBudgets of the cluster from
+// the Kubernetes API, and reports the ones whose matching deployments don't
+// have more available pods than required by spec.minAvailable, or by
+// spec.maxUnavailable when minAvailable is not set. Evicting any pod of these
+// deployments would violate their budget, which blocks node maintenance. The
+// number of violations is emitted as the datadog.docker.kubernetes.pdb_violations
+// gauge.
+func (d *DockerUtil) CheckPodDisruptionBudgets(ctx context.Context) ([]PDBViolation, error) {
+	var pdbs kubernetesPDBList
+	if _, err := d.queryKubernetesAPI(ctx, "/apis/policy/v1beta1/poddisruptionbudgets", &pdbs); err != nil {
+		return nil, fmt.Errorf("error listing pod disruption budgets: %s", err)
+	}
+
+	deployments := make(map[string]*kubernetesDeploymentList)
+	violations := []PDBViolation{}
+	for _, pdb := range pdbs.Items {
+		if pdb.Spec.Selector.empty() {
+			// an empty selector matches no pod
+			continue
+		}
+		ns := pdb.Metadata.Namespace
+		if _, ok := deployments[ns]; !ok {
+			list := new(kubernetesDeploymentList)
+			path := fmt.Sprintf("/apis/apps/v1/namespaces/%s/deployments", url.PathEscape(ns))
+			if _, err := d.queryKubernetesAPI(ctx, path, list); err != nil {
+				return nil, fmt.Errorf("error listing the deployments of namespace %s: %s", ns, err)
+			}
+			deployments[ns] = list
+		}
+
+		var matched, replicas, available int
+		for _, deploy := range deployments[ns].Items {
+			if !pdb.Spec.Selector.matches(deploy.Spec.Template.Metadata.Labels) {
+				continue
+			}
+			matched++
+			if deploy.Spec.Replicas != nil {
+				replicas += *deploy.Spec.Replicas
+			} else {
+				replicas++ // default number of replicas
+			}
+			available += deploy.Status.AvailableReplicas
+		}
+		if matched == 0 {
+			continue
+		}
+
+		var required int
+		switch {
+		case pdb.Spec.MinAvailable.set:
+			required = pdb.Spec.MinAvailable.scaled(replicas)
+		case pdb.Spec.MaxUnavailable.set:
+			required = replicas - pdb.Spec.MaxUnavailable.scaled(replicas)
+		default:
+			continue
+		}
+		if available <= required {
+			violations = append(violations, PDBViolation{
+				Namespace: ns,
+				PDBName:   pdb.Metadata.Name,
+				Available: available,
+				Required:  required,
+			})
+		}
+	}
+	gauge("datadog.docker.kubernetes.pdb_violations", float64(len(violations)), nil)
+	return violations, nil
+}
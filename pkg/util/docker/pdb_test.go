@@ -0,0 +1,129 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPDBs = `{"kind": "PodDisruptionBudgetList", "items": [
+	{"metadata": {"name": "web", "namespace": "prod"}, "spec": {"minAvailable": 2, "selector": {"matchLabels": {"app": "web"}}}},
+	{"metadata": {"name": "api", "namespace": "prod"}, "spec": {"minAvailable": 3, "selector": {"matchLabels": {"app": "api"}}}},
+	{"metadata": {"name": "workers", "namespace": "prod"}, "spec": {"minAvailable": "50%", "selector": {"matchExpressions": [{"key": "tier", "operator": "In", "values": ["worker"]}]}}},
+	{"metadata": {"name": "cache", "namespace": "staging"}, "spec": {"maxUnavailable": 1, "selector": {"matchLabels": {"app": "cache"}}}},
+	{"metadata": {"name": "orphan", "namespace": "staging"}, "spec": {"minAvailable": 1, "selector": {"matchLabels": {"app": "gone"}}}},
+	{"metadata": {"name": "everything", "namespace": "staging"}, "spec": {"minAvailable": 1, "selector": {}}}
+]}`
+
+const testProdDeployments = `{"kind": "DeploymentList", "items": [
+	{"spec": {"replicas": 3, "template": {"metadata": {"labels": {"app": "web"}}}}, "status": {"availableReplicas": 3}},
+	{"spec": {"replicas": 3, "template": {"metadata": {"labels": {"app": "api"}}}}, "status": {"availableReplicas": 3}},
+	{"spec": {"replicas": 4, "template": {"metadata": {"labels": {"app": "queue", "tier": "worker"}}}}, "status": {"availableReplicas": 2}},
+	{"spec": {"replicas": 2, "template": {"metadata": {"labels": {"app": "batch", "tier": "worker"}}}}, "status": {"availableReplicas": 2}}
+]}`
+
+const testStagingDeployments = `{"kind": "DeploymentList", "items": [
+	{"spec": {"replicas": 2, "template": {"metadata": {"labels": {"app": "cache"}}}}, "status": {"availableReplicas": 1}}
+]}`
+
+func TestCheckPodDisruptionBudgets(t *testing.T) {
+	tempFolder, err := newTempFolder("test-pdb")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	defer func(token, ca string) {
+		kubernetesTokenPath, kubernetesCAPath = token, ca
+	}(kubernetesTokenPath, kubernetesCAPath)
+	kubernetesTokenPath = filepath.Join(tempFolder.RootPath, "missing-token")
+	kubernetesCAPath = filepath.Join(tempFolder.RootPath, "missing.crt")
+
+	// mock Kubernetes API
+	queries := make(map[string]int)
+	mux := http.NewServeMux()
+	for path, body := range map[string]string{
+		"/apis/policy/v1beta1/poddisruptionbudgets":    testPDBs,
+		"/apis/apps/v1/namespaces/prod/deployments":    testProdDeployments,
+		"/apis/apps/v1/namespaces/staging/deployments": testStagingDeployments,
+	} {
+		path, body := path, body
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			queries[path]++
+			w.Write([]byte(body))
+		})
+	}
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	d := &DockerUtil{queryTimeout: time.Second, cfg: &Config{KubernetesAPIURL: srv.URL}}
+	withTestStatsClient(func(c *testStatsClient) {
+		violations, err := d.CheckPodDisruptionBudgets(context.Background())
+		require.NoError(t, err)
+		// web and workers (4 out of 6 pods available, 3 required) can lose a
+		// pod, orphan matches no deployment and everything matches no pod.
+		assert.Equal(t, []PDBViolation{
+			// no pod of the api deployment can be evicted
+			{Namespace: "prod", PDBName: "api", Available: 3, Required: 3},
+			// the cache has 1 pod unavailable already
+			{Namespace: "staging", PDBName: "cache", Available: 1, Required: 1},
+		}, violations)
+		assert.Equal(t, []testStatsSample{{Name: "datadog.docker.kubernetes.pdb_violations", Value: 2}}, c.gauges)
+	})
+	// deployments are listed once per namespace
+	assert.Equal(t, 1, queries["/apis/apps/v1/namespaces/prod/deployments"])
+	assert.Equal(t, 1, queries["/apis/apps/v1/namespaces/staging/deployments"])
+
+	d.cfg.KubernetesAPIURL = srv.URL + "/unknown"
+	_, err = d.CheckPodDisruptionBudgets(context.Background())
+	assert.Error(t, err)
+}
+
+func TestKubernetesLabelSelector(t *testing.T) {
+	labels := map[string]string{"app": "web", "tier": "frontend"}
+	for selector, matches := range map[string]bool{
+		`{"matchLabels": {"app": "web"}}`:                                                        true,
+		`{"matchLabels": {"app": "web", "tier": "backend"}}`:                                     false,
+		`{"matchExpressions": [{"key": "tier", "operator": "In", "values": ["frontend", "x"]}]}`: true,
+		`{"matchExpressions": [{"key": "tier", "operator": "NotIn", "values": ["frontend"]}]}`:   false,
+		`{"matchExpressions": [{"key": "env", "operator": "NotIn", "values": ["prod"]}]}`:        true,
+		`{"matchExpressions": [{"key": "app", "operator": "Exists"}]}`:                           true,
+		`{"matchExpressions": [{"key": "env", "operator": "Exists"}]}`:                           false,
+		`{"matchExpressions": [{"key": "env", "operator": "DoesNotExist"}]}`:                     true,
+		`{"matchExpressions": [{"key": "app", "operator": "Unknown"}]}`:                          false,
+	} {
+		var s kubernetesLabelSelector
+		require.NoError(t, json.Unmarshal([]byte(selector), &s))
+		assert.Equal(t, matches, s.matches(labels), selector)
+	}
+}
+
+func TestIntOrString(t *testing.T) {
+	for raw, expected := range map[string]intOrString{
+		`2`:     {set: true, value: 2},
+		`"25%"`: {set: true, value: 25, percent: true},
+		`"3"`:   {set: true, value: 3},
+		`null`:  {},
+	} {
+		var v intOrString
+		require.NoError(t, json.Unmarshal([]byte(raw), &v), raw)
+		assert.Equal(t, expected, v, raw)
+	}
+	var v intOrString
+	assert.Error(t, json.Unmarshal([]byte(`"half"`), &v))
+
+	// percentages are rounded up
+	assert.Equal(t, 2, intOrString{set: true, value: 25, percent: true}.scaled(5))
+	assert.Equal(t, 4, intOrString{set: true, value: 4}.scaled(5))
+}
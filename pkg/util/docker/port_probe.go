@@ -0,0 +1,142 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/DataDog/datadog-agent/pkg/util/containers"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// portProbeTimeout is the maximum time spent probing a port when the context
+// has no deadline.
+const portProbeTimeout = 500 * time.Millisecond
+
+// VerifyPortListening reports whether the given port of the container
+// identified by id is listening, by connecting to it on the IP of the container
+// with the given protocol, "tcp" or "udp". A UDP port is considered listening
+// unless the container answers that it is unreachable, like netcat does. It
+// requires docker_verify_port_listening.
+func (d *DockerUtil) VerifyPortListening(ctx context.Context, id string, port int, proto string) (bool, error) {
+	if !d.cfg.VerifyPortListening {
+		return false, errors.New("port verification is disabled, set docker_verify_port_listening to enable it")
+	}
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return false, err
+	}
+	ip, err := containerIP(c)
+	if err != nil {
+		return false, err
+	}
+	return probePort(ctx, ip, port, proto)
+}
+
+// containerIP returns the IP of the container on its first network having one.
+func containerIP(c types.ContainerJSON) (string, error) {
+	if c.NetworkSettings == nil {
+		return "", fmt.Errorf("no network settings for container %s", c.ID)
+	}
+	if ip := c.NetworkSettings.IPAddress; ip != "" {
+		return ip, nil
+	}
+	for _, network := range c.NetworkSettings.Networks {
+		if network.IPAddress != "" {
+			return network.IPAddress, nil
+		}
+	}
+	return "", fmt.Errorf("no IP found for container %s", c.ID)
+}
+
+// probePort reports whether the given port of ip is listening.
+func probePort(ctx context.Context, ip string, port int, proto string) (bool, error) {
+	proto = strings.ToLower(proto)
+	if proto != "tcp" && proto != "udp" {
+		return false, fmt.Errorf("unsupported protocol %q", proto)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, portProbeTimeout)
+		defer cancel()
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, proto, net.JoinHostPort(ip, strconv.Itoa(port)))
+	if err != nil {
+		if ctx.Err() != nil || isConnRefused(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer conn.Close()
+	if proto == "tcp" {
+		return true, nil
+	}
+
+	// UDP is connectionless: send a datagram and wait for an ICMP port
+	// unreachable, reported as a refused connection, until the deadline
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	if _, err := conn.Write([]byte{0}); err != nil {
+		return !isConnRefused(err), nil
+	}
+	if _, err := conn.Read(make([]byte, 1)); err != nil && isConnRefused(err) {
+		return false, nil
+	}
+	return true, nil
+}
+
+// isConnRefused reports whether err is caused by a refused connection.
+func isConnRefused(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	return err == syscall.ECONNREFUSED
+}
+
+// unreachablePorts returns the addresses which are not listening, probing them
+// concurrently for at most portProbeTimeout.
+func unreachablePorts(addrs []containers.NetworkAddress) []containers.NetworkAddress {
+	closed := make([]bool, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(i int, addr containers.NetworkAddress) {
+			defer wg.Done()
+			listening, err := probePort(context.Background(), addr.IP.String(), addr.Port, addr.Protocol)
+			if err != nil {
+				log.Debugf("Cannot verify that %s port %s:%d is listening: %s", addr.Protocol, addr.IP, addr.Port, err)
+				return
+			}
+			closed[i] = !listening
+		}(i, addr)
+	}
+	wg.Wait()
+
+	var unreachable []containers.NetworkAddress
+	for i, addr := range addrs {
+		if closed[i] {
+			unreachable = append(unreachable, addr)
+		}
+	}
+	return unreachable
+}
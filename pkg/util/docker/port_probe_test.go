@@ -0,0 +1,115 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/util/containers"
+)
+
+// closedPort returns a port of the loopback interface nothing listens on.
+func closedPort(t *testing.T, proto string) int {
+	if proto == "udp" {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer conn.Close()
+		return conn.LocalAddr().(*net.UDPAddr).Port
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestProbePort(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer tcp.Close()
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer udp.Close()
+
+	ctx := context.Background()
+	for _, tt := range []struct {
+		name      string
+		port      int
+		proto     string
+		listening bool
+	}{
+		{"tcp listening", tcp.Addr().(*net.TCPAddr).Port, "tcp", true},
+		{"tcp closed", closedPort(t, "tcp"), "tcp", false},
+		{"udp listening", udp.LocalAddr().(*net.UDPAddr).Port, "udp", true},
+		{"udp closed", closedPort(t, "udp"), "UDP", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			listening, err := probePort(ctx, "127.0.0.1", tt.port, tt.proto)
+			require.NoError(t, err)
+			assert.Equal(t, tt.listening, listening)
+		})
+	}
+
+	_, err = probePort(ctx, "127.0.0.1", 80, "sctp")
+	assert.Error(t, err)
+}
+
+func TestUnreachablePorts(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer tcp.Close()
+
+	ip := net.ParseIP("127.0.0.1")
+	closed := containers.NetworkAddress{IP: ip, Port: closedPort(t, "tcp"), Protocol: "tcp"}
+	addrs := []containers.NetworkAddress{
+		{IP: ip, Port: tcp.Addr().(*net.TCPAddr).Port, Protocol: "tcp"},
+		closed,
+		{IP: ip, Port: 80, Protocol: "sctp"},
+	}
+	assert.Equal(t, []containers.NetworkAddress{closed}, unreachablePorts(addrs))
+}
+
+func TestContainerIP(t *testing.T) {
+	c := types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{ID: "web"}}
+	_, err := containerIP(c)
+	assert.Error(t, err)
+
+	c.NetworkSettings = &types.NetworkSettings{Networks: map[string]*network.EndpointSettings{
+		"none":   {},
+		"bridge": {IPAddress: "172.17.0.2"},
+	}}
+	ip, err := containerIP(c)
+	require.NoError(t, err)
+	assert.Equal(t, "172.17.0.2", ip)
+
+	c.NetworkSettings.DefaultNetworkSettings.IPAddress = "172.17.0.3"
+	ip, err = containerIP(c)
+	require.NoError(t, err)
+	assert.Equal(t, "172.17.0.3", ip)
+}
+
+func TestVerifyPortListeningDisabled(t *testing.T) {
+	d := &DockerUtil{cfg: &Config{}}
+	_, err := d.VerifyPortListening(context.Background(), "web", 80, "tcp")
+	assert.Error(t, err)
+}
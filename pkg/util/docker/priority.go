@@ -0,0 +1,103 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// statNiceField is the index of the nice value in the fields of /proc/{pid}/stat
+// following the command name, as documented in proc(5).
+const statNiceField = 16
+
+// ProcessPriority is the scheduling priority of a process.
+type ProcessPriority struct {
+	PID       int
+	Command   string
+	NiceValue int
+}
+
+// GetProcessPriorities returns the nice values of all the processes of the
+// container identified by id, as found in their /proc/{pid}/stat files. The
+// number of processes whose nice value is below the configured threshold is
+// reported as the datadog.docker.container.high_priority_processes gauge.
+func (d *DockerUtil) GetProcessPriorities(ctx context.Context, id string) ([]ProcessPriority, error) {
+	cgroup, err := containerCgroup(id)
+	if err != nil {
+		return nil, err
+	}
+	pids := make([]int, len(cgroup.Pids))
+	for i, pid := range cgroup.Pids {
+		pids[i] = int(pid)
+	}
+	return d.processPriorities(id, pids)
+}
+
+func (d *DockerUtil) processPriorities(id string, pids []int) ([]ProcessPriority, error) {
+	procRoot := config.Datadog.GetString("container_proc_root")
+	priorities := make([]ProcessPriority, 0, len(pids))
+	for _, pid := range pids {
+		p, err := readProcessPriority(filepath.Join(procRoot, strconv.Itoa(pid), "stat"))
+		if os.IsNotExist(err) {
+			// the process exited
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		priorities = append(priorities, *p)
+	}
+	sort.Slice(priorities, func(i, j int) bool { return priorities[i].PID < priorities[j].PID })
+
+	high := 0
+	for _, p := range priorities {
+		if p.NiceValue < d.cfg.HighPriorityNiceThreshold {
+			high++
+			log.Warnf("Process %d (%s) of container %s has a nice value of %d, it may starve other processes", p.PID, p.Command, id, p.NiceValue)
+		}
+	}
+	gauge("datadog.docker.container.high_priority_processes", float64(high), []string{"container_id:" + id})
+	return priorities, nil
+}
+
+// readProcessPriority parses the given /proc/{pid}/stat file.
+func readProcessPriority(path string) (*ProcessPriority, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	stat := strings.TrimSpace(string(content))
+	// the command is enclosed in parentheses and may itself contain spaces and parentheses
+	open, end := strings.IndexByte(stat, '('), strings.LastIndexByte(stat, ')')
+	if open < 0 || end < open {
+		return nil, fmt.Errorf("malformed stat file %s", path)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(stat[:open]))
+	if err != nil {
+		return nil, fmt.Errorf("malformed stat file %s: %s", path, err)
+	}
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) <= statNiceField {
+		return nil, fmt.Errorf("malformed stat file %s: missing fields", path)
+	}
+	nice, err := strconv.Atoi(fields[statNiceField])
+	if err != nil {
+		return nil, fmt.Errorf("malformed stat file %s: %s", path, err)
+	}
+	return &ProcessPriority{PID: pid, Command: stat[open+1 : end], NiceValue: nice}, nil
+}
@@ -0,0 +1,63 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+)
+
+// procStat returns the content of a /proc/{pid}/stat file.
+func procStat(pid int, comm string, nice int) string {
+	return fmt.Sprintf("%d (%s) S 1 %d %d 0 -1 4194560 1014 0 0 0 12 5 0 0 %d %d 1 0 1915 9580544 1127 18446744073709551615 1 1 0 0 0 0 0 4096 0 0 0 0 17 3 0 0 0 0 0\n",
+		pid, comm, pid, pid, 20+nice, nice)
+}
+
+func TestProcessPriorities(t *testing.T) {
+	tempFolder, err := newTempFolder("test-priorities")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	config.Datadog.SetDefault("container_proc_root", tempFolder.RootPath)
+	defer config.Datadog.SetDefault("container_proc_root", "/proc")
+
+	require.NoError(t, tempFolder.add("12/stat", procStat(12, "nginx: worker (1)", 0)))
+	require.NoError(t, tempFolder.add("10/stat", procStat(10, "nginx", -5)))
+	require.NoError(t, tempFolder.add("11/stat", procStat(11, "rt worker", -20)))
+	d := &DockerUtil{cfg: &Config{HighPriorityNiceThreshold: -10}}
+
+	withTestStatsClient(func(c *testStatsClient) {
+		// 13 exited
+		priorities, err := d.processPriorities("abc", []int{12, 10, 11, 13})
+		require.NoError(t, err)
+		assert.Equal(t, []ProcessPriority{
+			{PID: 10, Command: "nginx", NiceValue: -5},
+			{PID: 11, Command: "rt worker", NiceValue: -20},
+			{PID: 12, Command: "nginx: worker (1)", NiceValue: 0},
+		}, priorities)
+		assert.Equal(t, []testStatsSample{
+			{Name: "datadog.docker.container.high_priority_processes", Value: 1, Tags: []string{"container_id:abc"}},
+		}, c.gauges)
+	})
+
+	withTestStatsClient(func(c *testStatsClient) {
+		_, err := d.processPriorities("abc", []int{10, 12})
+		require.NoError(t, err)
+		assert.Equal(t, 0.0, c.gauges[0].Value)
+	})
+
+	for _, stat := range []string{"", "10 nginx S 1", "10 (nginx) S 1 2 3", "x (nginx)" + procStat(10, "nginx", 0)[9:]} {
+		require.NoError(t, tempFolder.add("20/stat", stat))
+		_, err := d.processPriorities("abc", []int{20})
+		assert.Error(t, err, stat)
+	}
+}
@@ -0,0 +1,191 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+const (
+	// probeLogLines is the number of lines of logs read when analyzing a probe
+	// failure.
+	probeLogLines = 10
+	// probeResourceRatio is the share of a resource limit above which the
+	// limit is considered reached.
+	probeResourceRatio = 0.9
+	// probeThrottledRatio is the share of CPU scheduling periods throttled
+	// above which a container is considered starved of CPU.
+	probeThrottledRatio = 0.25
+)
+
+// probeErrorPattern matches the log lines of applications failing.
+var probeErrorPattern = regexp.MustCompile(`(?i)\b(panic|fatal|exception|error|deadlock|traceback|segmentation fault)\b`)
+
+// ProbeFailureAnalysis is the likely root cause of the liveness probe failures
+// of a container.
+type ProbeFailureAnalysis struct {
+	// LikelyCause is one of oom_killed, memory_pressure, pid_exhaustion,
+	// cpu_throttling, application_error, probe_timeout or unknown.
+	LikelyCause string
+	// ResourceBoundary is the resource limit the container reached: memory,
+	// pids or cpu. It is empty when no limit was reached.
+	ResourceBoundary string
+	// LastLogs holds the last lines of the logs of the container.
+	LastLogs []string
+}
+
+// probeResourceUsage is the resource usage of a container read from its
+// cgroups. Limits are 0 when unlimited.
+type probeResourceUsage struct {
+	memoryUsage    uint64
+	memoryLimit    uint64
+	memoryFailures uint64
+	cpuPeriods     uint64
+	cpuThrottled   uint64
+	pids           uint64
+	pidsLimit      uint64
+}
+
+// AnalyzeProbeFailure looks for the reason the liveness probe of the container
+// identified by id is failing. It reads the Docker events of the container over
+// the last docker_probe_failure_lookback seconds, its last 10 lines of logs and
+// the usage of its cgroups. Resource exhaustion is reported first, then errors
+// logged by the application, then probes timing out.
+func (d *DockerUtil) AnalyzeProbeFailure(ctx context.Context, id string) (*ProbeFailureAnalysis, error) {
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	eventCtx, cancel := context.WithTimeout(ctx, d.queryTimeout)
+	defer cancel()
+	msgs, errs := d.openEventChannel(eventCtx, now.Add(-d.cfg.ProbeFailureLookback), now, map[string]string{
+		"type":      "container",
+		"container": c.ID,
+	})
+	evs, err := collectProbeEvents(eventCtx, msgs, errs)
+	if err != nil {
+		return nil, err
+	}
+	logs, err := d.containerLogTail(c.ID, probeLogLines)
+	if err != nil {
+		log.Debugf("Could not read the logs of container %s: %s", c.ID, err)
+	}
+	var usage probeResourceUsage
+	if cgroup, err := containerCgroup(c.ID); err != nil {
+		// the container may be restarting
+		log.Debugf("Could not read the resource usage of container %s: %s", c.ID, err)
+	} else {
+		usage = readProbeResourceUsage(cgroupDir(cgroup, "memory"), cgroupDir(cgroup, "cpu"), cgroupDir(cgroup, "pids"))
+	}
+	return analyzeProbeFailure(evs, logs, usage), nil
+}
+
+// collectProbeEvents returns the events sent on msgs until the stream ends.
+func collectProbeEvents(ctx context.Context, msgs <-chan events.Message, errs <-chan error) ([]events.Message, error) {
+	var evs []events.Message
+	for {
+		select {
+		case msg := <-msgs:
+			evs = append(evs, msg)
+		case err := <-errs:
+			if err == io.EOF {
+				return evs, nil
+			}
+			return nil, err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// readProbeResourceUsage reads the resource usage of the cgroups found in the
+// given directories. Missing files are skipped.
+func readProbeResourceUsage(memDir, cpuDir, pidsDir string) probeResourceUsage {
+	var u probeResourceUsage
+	for path, v := range map[string]*uint64{
+		filepath.Join(memDir, "memory.usage_in_bytes"): &u.memoryUsage,
+		filepath.Join(memDir, "memory.limit_in_bytes"): &u.memoryLimit,
+		filepath.Join(memDir, "memory.failcnt"):        &u.memoryFailures,
+		filepath.Join(pidsDir, "pids.current"):         &u.pids,
+	} {
+		if value, err := readCgroupUint(path); err == nil {
+			*v = value
+		} else if !os.IsNotExist(err) {
+			log.Debugf("Could not read %s: %s", path, err)
+		}
+	}
+	// the memory limit of unlimited cgroups is a huge number
+	if u.memoryLimit > 1<<60 {
+		u.memoryLimit = 0
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(pidsDir, "pids.max")); err == nil && strings.TrimSpace(string(data)) != "max" {
+		u.pidsLimit, _ = readCgroupUint(filepath.Join(pidsDir, "pids.max"))
+	}
+	stat := filepath.Join(cpuDir, "cpu.stat")
+	u.cpuPeriods, _ = readCgroupStat(stat, "nr_periods")
+	u.cpuThrottled, _ = readCgroupStat(stat, "nr_throttled")
+	return u
+}
+
+// analyzeProbeFailure infers the likely cause of a probe failure from the
+// events, logs and resource usage of a container.
+func analyzeProbeFailure(evs []events.Message, logs []string, u probeResourceUsage) *ProbeFailureAnalysis {
+	if logs == nil {
+		logs = []string{}
+	}
+	analysis := &ProbeFailureAnalysis{LikelyCause: "unknown", LastLogs: logs}
+
+	var oomKilled, probeTimeout bool
+	for _, ev := range evs {
+		switch {
+		case ev.Action == "oom":
+			oomKilled = true
+		case ev.Action == "exec_die" && ev.Actor.Attributes["exitCode"] == "137":
+			// the probe command was killed when it timed out
+			probeTimeout = true
+		}
+	}
+
+	switch {
+	case oomKilled:
+		analysis.LikelyCause, analysis.ResourceBoundary = "oom_killed", "memory"
+	case u.memoryFailures > 0 || u.memoryLimit > 0 && float64(u.memoryUsage) >= probeResourceRatio*float64(u.memoryLimit):
+		analysis.LikelyCause, analysis.ResourceBoundary = "memory_pressure", "memory"
+	case u.pidsLimit > 0 && float64(u.pids) >= probeResourceRatio*float64(u.pidsLimit):
+		analysis.LikelyCause, analysis.ResourceBoundary = "pid_exhaustion", "pids"
+	case u.cpuPeriods > 0 && float64(u.cpuThrottled) >= probeThrottledRatio*float64(u.cpuPeriods):
+		analysis.LikelyCause, analysis.ResourceBoundary = "cpu_throttling", "cpu"
+	case hasProbeError(logs):
+		analysis.LikelyCause = "application_error"
+	case probeTimeout:
+		analysis.LikelyCause = "probe_timeout"
+	}
+	return analysis
+}
+
+// hasProbeError reports whether one of the lines looks like an error.
+func hasProbeError(lines []string) bool {
+	for _, line := range lines {
+		if probeErrorPattern.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
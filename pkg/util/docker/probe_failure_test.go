@@ -0,0 +1,191 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testProbeEvents returns a stream of the given events, ended as the Docker
+// client ends the streams of events with an until date.
+func testProbeEvents(evs ...events.Message) (<-chan events.Message, <-chan error) {
+	msgs := make(chan events.Message)
+	errs := make(chan error, 1)
+	go func() {
+		for _, ev := range evs {
+			msgs <- ev
+		}
+		errs <- io.EOF
+	}()
+	return msgs, errs
+}
+
+// testLogStream returns a log stream multiplexing stdout and stderr.
+func testLogStream(t *testing.T, stdout, stderr string) io.Reader {
+	var buf bytes.Buffer
+	_, err := stdcopy.NewStdWriter(&buf, stdcopy.Stdout).Write([]byte(stdout))
+	require.NoError(t, err)
+	_, err = stdcopy.NewStdWriter(&buf, stdcopy.Stderr).Write([]byte(stderr))
+	require.NoError(t, err)
+	return &buf
+}
+
+func TestCollectProbeEvents(t *testing.T) {
+	msgs, errs := testProbeEvents(
+		events.Message{Action: "exec_start: /bin/sh -c curl -f localhost:8080/health"},
+		events.Message{Action: "exec_die", Actor: events.Actor{Attributes: map[string]string{"exitCode": "137"}}},
+		events.Message{Action: "kill"},
+	)
+	evs, err := collectProbeEvents(context.Background(), msgs, errs)
+	require.NoError(t, err)
+	assert.Len(t, evs, 3)
+
+	failing := make(chan error, 1)
+	failing <- errors.New("connection reset")
+	_, err = collectProbeEvents(context.Background(), make(chan events.Message), failing)
+	assert.EqualError(t, err, "connection reset")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = collectProbeEvents(ctx, make(chan events.Message), make(chan error))
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestAnalyzeProbeFailure(t *testing.T) {
+	probeKilled := events.Message{Action: "exec_die", Actor: events.Actor{Attributes: map[string]string{"exitCode": "137"}}}
+	probeFailed := events.Message{Action: "exec_die", Actor: events.Actor{Attributes: map[string]string{"exitCode": "1"}}}
+	restarted := []events.Message{{Action: "kill"}, {Action: "die"}, {Action: "start"}}
+	quietLogs := []string{"GET /health 200", "GET /api/orders 200"}
+
+	for name, tc := range map[string]struct {
+		events   []events.Message
+		logs     []string
+		usage    probeResourceUsage
+		cause    string
+		boundary string
+	}{
+		"oom killed": {
+			events:   append([]events.Message{{Action: "oom"}}, restarted...),
+			logs:     quietLogs,
+			usage:    probeResourceUsage{memoryUsage: 100 << 20, memoryLimit: 512 << 20},
+			cause:    "oom_killed",
+			boundary: "memory",
+		},
+		"memory pressure": {
+			events:   []events.Message{probeKilled},
+			logs:     quietLogs,
+			usage:    probeResourceUsage{memoryUsage: 500 << 20, memoryLimit: 512 << 20},
+			cause:    "memory_pressure",
+			boundary: "memory",
+		},
+		"memory limit reached": {
+			events:   []events.Message{probeFailed},
+			logs:     quietLogs,
+			usage:    probeResourceUsage{memoryUsage: 100 << 20, memoryLimit: 512 << 20, memoryFailures: 12},
+			cause:    "memory_pressure",
+			boundary: "memory",
+		},
+		"pid exhaustion": {
+			events:   []events.Message{probeFailed},
+			logs:     []string{"java.lang.OutOfMemoryError: unable to create new native thread"},
+			usage:    probeResourceUsage{pids: 1024, pidsLimit: 1024},
+			cause:    "pid_exhaustion",
+			boundary: "pids",
+		},
+		"cpu throttling": {
+			events:   []events.Message{probeKilled},
+			logs:     quietLogs,
+			usage:    probeResourceUsage{cpuPeriods: 1000, cpuThrottled: 600},
+			cause:    "cpu_throttling",
+			boundary: "cpu",
+		},
+		"application error": {
+			events: append([]events.Message{probeFailed}, restarted...),
+			logs:   []string{"GET /health 500", "panic: runtime error: invalid memory address or nil pointer dereference"},
+			usage:  probeResourceUsage{memoryUsage: 100 << 20, memoryLimit: 512 << 20, cpuPeriods: 1000, cpuThrottled: 10},
+			cause:  "application_error",
+		},
+		"probe timeout": {
+			events: []events.Message{probeKilled},
+			logs:   quietLogs,
+			cause:  "probe_timeout",
+		},
+		"unknown": {
+			events: restarted,
+			cause:  "unknown",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			msgs, errs := testProbeEvents(tc.events...)
+			evs, err := collectProbeEvents(context.Background(), msgs, errs)
+			require.NoError(t, err)
+			analysis := analyzeProbeFailure(evs, tc.logs, tc.usage)
+			assert.Equal(t, tc.cause, analysis.LikelyCause)
+			assert.Equal(t, tc.boundary, analysis.ResourceBoundary)
+			assert.NotNil(t, analysis.LastLogs)
+		})
+	}
+}
+
+func TestProbeFailureLogs(t *testing.T) {
+	var stdout bytes.Buffer
+	for i := 0; i < 20; i++ {
+		stdout.WriteString("GET /health 200\n")
+	}
+	logs, err := logTail(testLogStream(t, stdout.String(), "Exception in thread \"main\" java.net.SocketTimeoutException\n"), false, probeLogLines)
+	require.NoError(t, err)
+	assert.Len(t, logs, probeLogLines)
+	assert.Equal(t, "Exception in thread \"main\" java.net.SocketTimeoutException", logs[probeLogLines-1])
+	assert.Equal(t, "application_error", analyzeProbeFailure(nil, logs, probeResourceUsage{}).LikelyCause)
+
+	logs, err = logTail(bytes.NewBufferString("ready\n"), true, probeLogLines)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ready"}, logs)
+}
+
+func TestReadProbeResourceUsage(t *testing.T) {
+	tempFolder, err := newTempFolder("test-probe-failure")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	root := tempFolder.RootPath
+
+	require.NoError(t, tempFolder.add("memory/memory.usage_in_bytes", "524288000\n"))
+	require.NoError(t, tempFolder.add("memory/memory.limit_in_bytes", "536870912\n"))
+	require.NoError(t, tempFolder.add("memory/memory.failcnt", "3\n"))
+	require.NoError(t, tempFolder.add("cpu/cpu.stat", "nr_periods 1000\nnr_throttled 600\nthrottled_time 123456789\n"))
+	require.NoError(t, tempFolder.add("pids/pids.current", "42\n"))
+	require.NoError(t, tempFolder.add("pids/pids.max", "max\n"))
+
+	u := readProbeResourceUsage(filepath.Join(root, "memory"), filepath.Join(root, "cpu"), filepath.Join(root, "pids"))
+	assert.Equal(t, probeResourceUsage{
+		memoryUsage:    524288000,
+		memoryLimit:    536870912,
+		memoryFailures: 3,
+		cpuPeriods:     1000,
+		cpuThrottled:   600,
+		pids:           42,
+	}, u)
+
+	require.NoError(t, tempFolder.add("memory/memory.limit_in_bytes", "9223372036854771712\n"))
+	require.NoError(t, tempFolder.add("pids/pids.max", "1024\n"))
+	u = readProbeResourceUsage(filepath.Join(root, "memory"), filepath.Join(root, "cpu"), filepath.Join(root, "pids"))
+	assert.Zero(t, u.memoryLimit, "unlimited")
+	assert.EqualValues(t, 1024, u.pidsLimit)
+
+	assert.Equal(t, probeResourceUsage{}, readProbeResourceUsage(filepath.Join(root, "missing"), filepath.Join(root, "missing"), filepath.Join(root, "missing")))
+}
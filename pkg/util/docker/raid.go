@@ -0,0 +1,127 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+)
+
+// mdstatDevicesRe matches the device counts of an array in /proc/mdstat, such
+// as [3/2] for an array of 3 devices of which 2 are active.
+var mdstatDevicesRe = regexp.MustCompile(`\[(\d+)/(\d+)\]`)
+
+// RAIDStatus is the state of a software RAID array, as listed in /proc/mdstat.
+type RAIDStatus struct {
+	// ArrayName is the name of the array, such as md0.
+	ArrayName string
+	// State is the state of the array, such as active or inactive.
+	State string
+	// ActiveDevices is the number of devices of the array in use.
+	ActiveDevices int
+	// TotalDevices is the number of devices the array is made of.
+	TotalDevices int
+}
+
+// Degraded reports whether the array is missing some of its devices.
+func (s RAIDStatus) Degraded() bool {
+	return s.ActiveDevices < s.TotalDevices
+}
+
+// GetRAIDHealth returns the state of the software RAID arrays seen by the
+// container identified by id, read from the /proc/mdstat file of the mount
+// namespace of its main process. datadog.docker.container.raid_degraded is sent
+// as 1 when any of the arrays is degraded, and 0 otherwise.
+func (d *DockerUtil) GetRAIDHealth(ctx context.Context, id string) ([]RAIDStatus, error) {
+	pid, err := d.containerPID(id)
+	if err != nil {
+		return nil, err
+	}
+	return raidHealth(id, filepath.Join(config.Datadog.GetString("container_proc_root"), strconv.Itoa(pid), "root", "proc", "mdstat"))
+}
+
+func raidHealth(id, path string) ([]RAIDStatus, error) {
+	statuses, err := parseMdstat(path)
+	if err != nil {
+		return nil, err
+	}
+	degraded := 0.0
+	for _, s := range statuses {
+		if s.Degraded() {
+			degraded = 1
+			break
+		}
+	}
+	gauge("datadog.docker.container.raid_degraded", degraded, []string{"container_id:" + id})
+	return statuses, nil
+}
+
+// parseMdstat parses the arrays of the given /proc/mdstat file. Each array is
+// described by a line such as "md1 : active raid5 sdd1[3](F) sdc1[1] sde1[0]",
+// followed by a line holding its device counts such as
+// "2093056 blocks super 1.2 level 5, 512k chunk, algorithm 2 [3/2] [UU_]".
+// Inactive arrays have no device counts, and their devices are all counted as
+// inactive.
+func parseMdstat(path string) ([]RAIDStatus, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		statuses []RAIDStatus
+		current  *RAIDStatus
+	)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && fields[1] == ":" && strings.HasPrefix(fields[0], "md") {
+			s := RAIDStatus{ArrayName: fields[0], State: fields[2]}
+			for _, dev := range fields[3:] {
+				if strings.Contains(dev, "[") {
+					s.TotalDevices++
+				}
+			}
+			statuses = append(statuses, s)
+			current = &statuses[len(statuses)-1]
+			continue
+		}
+		if current == nil {
+			// Personalities line
+			continue
+		}
+		if m := mdstatDevicesRe.FindStringSubmatch(line); m != nil {
+			total, err := strconv.Atoi(m[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid device count of %s: %v", current.ArrayName, err)
+			}
+			active, err := strconv.Atoi(m[2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid device count of %s: %v", current.ArrayName, err)
+			}
+			current.TotalDevices, current.ActiveDevices = total, active
+		}
+		// only the first device counts belong to the array, the next lines
+		// are about its bitmap or recovery
+		current = nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
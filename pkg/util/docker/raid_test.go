@@ -0,0 +1,97 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMdstatHealthy = `Personalities : [raid1] [raid6] [raid5] [raid4]
+md0 : active raid1 sdb1[1] sda1[0]
+      1046528 blocks super 1.2 [2/2] [UU]
+      bitmap: 0/1 pages [0KB], 65536KB chunk
+
+unused devices: <none>
+`
+
+const testMdstatDegraded = `Personalities : [raid1] [raid6] [raid5] [raid4]
+md0 : active raid1 sdb1[1] sda1[0]
+      1046528 blocks super 1.2 [2/2] [UU]
+
+md1 : active raid5 sdd1[3](F) sdc1[1] sde1[0]
+      2093056 blocks super 1.2 level 5, 512k chunk, algorithm 2 [3/2] [UU_]
+      [=>...................]  recovery =  8.5% (89600/1046528) finish=0.3min speed=44800K/sec
+
+md127 : inactive sdf[0](S) sdg[1](S)
+      1953383512 blocks super 1.2
+
+unused devices: <none>
+`
+
+func TestRAIDHealth(t *testing.T) {
+	tempFolder, err := newTempFolder("test-raid")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+
+	for _, tt := range []struct {
+		name     string
+		mdstat   string
+		expected []RAIDStatus
+		degraded float64
+	}{
+		{
+			name:   "healthy",
+			mdstat: testMdstatHealthy,
+			expected: []RAIDStatus{
+				{ArrayName: "md0", State: "active", ActiveDevices: 2, TotalDevices: 2},
+			},
+			degraded: 0,
+		},
+		{
+			name:   "degraded",
+			mdstat: testMdstatDegraded,
+			expected: []RAIDStatus{
+				{ArrayName: "md0", State: "active", ActiveDevices: 2, TotalDevices: 2},
+				{ArrayName: "md1", State: "active", ActiveDevices: 2, TotalDevices: 3},
+				{ArrayName: "md127", State: "inactive", ActiveDevices: 0, TotalDevices: 2},
+			},
+			degraded: 1,
+		},
+		{
+			name:     "no arrays",
+			mdstat:   "Personalities :\nunused devices: <none>\n",
+			expected: nil,
+			degraded: 0,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, tempFolder.add("mdstat", tt.mdstat))
+			withTestStatsClient(func(c *testStatsClient) {
+				statuses, err := raidHealth("raid", filepath.Join(tempFolder.RootPath, "mdstat"))
+				require.NoError(t, err)
+				assert.Equal(t, tt.expected, statuses)
+				assert.Equal(t, []testStatsSample{
+					{Name: "datadog.docker.container.raid_degraded", Value: tt.degraded, Tags: []string{"container_id:raid"}},
+				}, c.gauges)
+			})
+		})
+	}
+
+	_, err = raidHealth("raid", filepath.Join(tempFolder.RootPath, "missing"))
+	assert.Error(t, err)
+}
+
+func TestRAIDStatusDegraded(t *testing.T) {
+	assert.False(t, RAIDStatus{ActiveDevices: 2, TotalDevices: 2}.Degraded())
+	assert.True(t, RAIDStatus{ActiveDevices: 1, TotalDevices: 2}.Degraded())
+	assert.False(t, RAIDStatus{}.Degraded())
+}
@@ -0,0 +1,136 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// RootProcess is a process running as root in a container.
+type RootProcess struct {
+	PID int
+	// Command is the command line of the process.
+	Command string
+	// Executable is the path of the executable of the process, empty if it
+	// can not be read.
+	Executable string
+}
+
+// FindRootProcesses returns the processes of the container identified by id
+// whose effective UID is 0, as found in their /proc/{pid}/status files. Their
+// number is emitted as the datadog.docker.container.root_process_count gauge,
+// and a warning is logged when the container is configured to run as another
+// user, unless docker_alert_root_processes is disabled.
+func (d *DockerUtil) FindRootProcesses(ctx context.Context, id string) ([]RootProcess, error) {
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return nil, err
+	}
+	cgroup, err := containerCgroup(id)
+	if err != nil {
+		return nil, err
+	}
+	pids := make([]int, len(cgroup.Pids))
+	for i, pid := range cgroup.Pids {
+		pids[i] = int(pid)
+	}
+	return d.rootProcesses(c, config.Datadog.GetString("container_proc_root"), pids)
+}
+
+func (d *DockerUtil) rootProcesses(c types.ContainerJSON, procRoot string, pids []int) ([]RootProcess, error) {
+	if c.ContainerJSONBase == nil || c.Config == nil {
+		return nil, errors.New("invalid container: no config")
+	}
+	var procs []RootProcess
+	for _, pid := range pids {
+		dir := filepath.Join(procRoot, strconv.Itoa(pid))
+		name, uid, err := processIdentity(filepath.Join(dir, "status"))
+		if err != nil {
+			// the process may have exited since the cgroup was read
+			log.Debugf("Cannot read status of process %d: %s", pid, err)
+			continue
+		}
+		if uid != 0 {
+			continue
+		}
+		proc := RootProcess{PID: pid, Command: name}
+		if cmdline, err := ioutil.ReadFile(filepath.Join(dir, "cmdline")); err == nil && len(cmdline) > 0 {
+			proc.Command = strings.Join(strings.Split(string(bytes.TrimRight(cmdline, "\x00")), "\x00"), " ")
+		}
+		if exe, err := os.Readlink(filepath.Join(dir, "exe")); err == nil {
+			proc.Executable = exe
+		}
+		procs = append(procs, proc)
+	}
+
+	gauge("datadog.docker.container.root_process_count", float64(len(procs)), containerTags(c.ID, c.Name))
+	if d.cfg.AlertRootProcesses && len(procs) > 0 && !isRootUser(c.Config.User) {
+		log.Warnf("Container %s is configured to run as user %q but has %d processes running as root, the first one being %d (%s)",
+			c.ID, c.Config.User, len(procs), procs[0].PID, procs[0].Command)
+	}
+	return procs, nil
+}
+
+// processIdentity returns the name and effective UID of a process from its
+// /proc/{pid}/status file.
+func processIdentity(statusPath string) (name string, uid int, err error) {
+	f, err := os.Open(statusPath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	uid = -1
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "Name:":
+			name = fields[1]
+		case "Uid:":
+			// real, effective, saved set and filesystem UIDs
+			if len(fields) < 3 {
+				return "", 0, fmt.Errorf("invalid Uid line in %s", statusPath)
+			}
+			if uid, err = strconv.Atoi(fields[2]); err != nil {
+				return "", 0, fmt.Errorf("invalid Uid line in %s: %s", statusPath, err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", 0, err
+	}
+	if uid < 0 {
+		return "", 0, fmt.Errorf("no Uid in %s", statusPath)
+	}
+	return name, uid, nil
+}
+
+// isRootUser reports whether the user of a container configuration, in the
+// user[:group] format, is root. Containers run as root by default.
+func isRootUser(user string) bool {
+	user = strings.SplitN(user, ":", 2)[0]
+	return user == "" || user == "root" || user == "0"
+}
@@ -0,0 +1,103 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testProcStatus(name string, ruid, euid int) string {
+	return fmt.Sprintf("Name:\t%s\nUmask:\t0022\nState:\tS (sleeping)\nUid:\t%d\t%d\t%d\t%d\nGid:\t1000\t1000\t1000\t1000\n", name, ruid, euid, euid, euid)
+}
+
+func TestRootProcesses(t *testing.T) {
+	tempFolder, err := newTempFolder("test-root-processes")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+
+	// the application, running as its user
+	require.NoError(t, tempFolder.add("10/status", testProcStatus("node", 1000, 1000)))
+	// a setuid root binary
+	require.NoError(t, tempFolder.add("11/status", testProcStatus("sudo", 1000, 0)))
+	require.NoError(t, tempFolder.add("11/cmdline", "sudo\x00-u\x00root\x00sh\x00"))
+	require.NoError(t, os.Symlink("/usr/bin/sudo", filepath.Join(tempFolder.RootPath, "11/exe")))
+	// a process injected as root, without readable command line
+	require.NoError(t, tempFolder.add("12/status", testProcStatus("sshd", 0, 0)))
+	// a process which dropped its privileges
+	require.NoError(t, tempFolder.add("13/status", testProcStatus("nginx", 0, 101)))
+
+	d := &DockerUtil{cfg: &Config{AlertRootProcesses: true}}
+	c := types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{ID: "app", Name: "/app"},
+		Config:            &container.Config{User: "1000:1000"},
+	}
+	withTestStatsClient(func(stats *testStatsClient) {
+		procs, err := d.rootProcesses(c, tempFolder.RootPath, []int{10, 11, 12, 13, 14})
+		require.NoError(t, err)
+		assert.Equal(t, []RootProcess{
+			{PID: 11, Command: "sudo -u root sh", Executable: "/usr/bin/sudo"},
+			{PID: 12, Command: "sshd"},
+		}, procs)
+		assert.Equal(t, []testStatsSample{
+			{Name: "datadog.docker.container.root_process_count", Value: 2, Tags: []string{"container_id:app", "container_name:app"}},
+		}, stats.gauges)
+	})
+
+	withTestStatsClient(func(stats *testStatsClient) {
+		procs, err := d.rootProcesses(c, tempFolder.RootPath, []int{10})
+		require.NoError(t, err)
+		assert.Empty(t, procs)
+		assert.Equal(t, 0.0, stats.gauges[0].Value)
+	})
+
+	_, err = d.rootProcesses(types.ContainerJSON{}, tempFolder.RootPath, []int{10})
+	assert.Error(t, err)
+}
+
+func TestProcessIdentity(t *testing.T) {
+	tempFolder, err := newTempFolder("test-process-identity")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+
+	require.NoError(t, tempFolder.add("status", testProcStatus("java", 1000, 0)))
+	name, uid, err := processIdentity(filepath.Join(tempFolder.RootPath, "status"))
+	require.NoError(t, err)
+	assert.Equal(t, "java", name)
+	assert.Equal(t, 0, uid)
+
+	require.NoError(t, tempFolder.add("status", "Name:\tjava\n"))
+	_, _, err = processIdentity(filepath.Join(tempFolder.RootPath, "status"))
+	assert.Error(t, err)
+}
+
+func TestIsRootUser(t *testing.T) {
+	for user, root := range map[string]bool{
+		"":          true,
+		"root":      true,
+		"0":         true,
+		"0:0":       true,
+		"root:www":  true,
+		"1000":      false,
+		"1000:0":    false,
+		"www-data":  false,
+		"rootless":  false,
+		"nobody:0":  false,
+		"10:10":     false,
+		"65534:100": false,
+	} {
+		assert.Equal(t, root, isRootUser(user), user)
+	}
+}
@@ -0,0 +1,78 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// rootlessSecurityOption is the security option reported by daemons running
+// in rootless mode.
+const rootlessSecurityOption = "name=rootless"
+
+// userRuntimeDir is the directory holding the runtime directories of users,
+// where rootless daemons create their socket. It is replaced in tests.
+var userRuntimeDir = "/run/user"
+
+// IsRootlessMode returns whether the Docker daemon runs in rootless mode,
+// according to its security options or, for daemons not reporting it, to the
+// presence of a rootless socket for the current user. When rootless mode is
+// required and the daemon doesn't run in it, the datadog.docker.daemon.not_rootless
+// gauge is set and a warning is logged.
+func (d *DockerUtil) IsRootlessMode() (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.queryTimeout)
+	defer cancel()
+	info, err := d.cli.Info(ctx)
+	if err != nil {
+		err = fmt.Errorf("unable to get Docker info: %s", err)
+		return d.rootlessMode(nil, err)
+	}
+	return d.rootlessMode(&info, nil)
+}
+
+func (d *DockerUtil) rootlessMode(info *types.Info, infoErr error) (bool, error) {
+	rootless := info != nil && hasRootlessSecurityOption(info.SecurityOptions)
+	if !rootless {
+		socket := filepath.Join(userRuntimeDir, strconv.Itoa(os.Getuid()), "docker.sock")
+		_, err := os.Stat(socket)
+		switch {
+		case err == nil:
+			rootless = true
+		case infoErr != nil:
+			// we can't tell
+			return false, infoErr
+		}
+	}
+
+	if d.cfg.RequireRootlessMode {
+		var value float64
+		if !rootless {
+			value = 1
+			log.Warnf("SECURITY WARNING: the Docker daemon is not running in rootless mode, which is required by the configuration")
+		}
+		gauge("datadog.docker.daemon.not_rootless", value, nil)
+	}
+	return rootless, nil
+}
+
+func hasRootlessSecurityOption(opts []string) bool {
+	for _, opt := range opts {
+		if opt == rootlessSecurityOption {
+			return true
+		}
+	}
+	return false
+}
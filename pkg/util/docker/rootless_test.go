@@ -0,0 +1,88 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRootlessMode(t *testing.T) {
+	tempFolder, err := newTempFolder("test-rootless")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	defer func(old string) { userRuntimeDir = old }(userRuntimeDir)
+	userRuntimeDir = tempFolder.RootPath
+	socket := fmt.Sprintf("%d/docker.sock", os.Getuid())
+
+	rootfulInfo := &types.Info{SecurityOptions: []string{"name=seccomp,profile=default"}}
+	rootlessInfo := &types.Info{SecurityOptions: []string{"name=seccomp,profile=default", "name=rootless"}}
+	infoErr := errors.New("unable to get Docker info")
+
+	for name, tt := range map[string]struct {
+		info     *types.Info
+		infoErr  error
+		socket   bool
+		rootless bool
+		err      bool
+	}{
+		"rootless info":             {info: rootlessInfo, rootless: true},
+		"rootful info":              {info: rootfulInfo},
+		"rootful info, socket":      {info: rootfulInfo, socket: true, rootless: true},
+		"no info, socket":           {infoErr: infoErr, socket: true, rootless: true},
+		"no info, no socket":        {infoErr: infoErr, err: true},
+		"old daemon, no socket":     {info: &types.Info{}},
+		"old daemon, rootless sock": {info: &types.Info{}, socket: true, rootless: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if tt.socket {
+				require.NoError(t, tempFolder.add(socket, ""))
+				defer tempFolder.delete(socket)
+			}
+			d := &DockerUtil{cfg: &Config{}}
+			rootless, err := d.rootlessMode(tt.info, tt.infoErr)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.rootless, rootless)
+		})
+	}
+
+	t.Run("required", func(t *testing.T) {
+		d := &DockerUtil{cfg: &Config{RequireRootlessMode: true}}
+		withTestStatsClient(func(c *testStatsClient) {
+			rootless, err := d.rootlessMode(rootfulInfo, nil)
+			require.NoError(t, err)
+			assert.False(t, rootless)
+			rootless, err = d.rootlessMode(rootlessInfo, nil)
+			require.NoError(t, err)
+			assert.True(t, rootless)
+			assert.Equal(t, []testStatsSample{
+				{Name: "datadog.docker.daemon.not_rootless", Value: 1},
+				{Name: "datadog.docker.daemon.not_rootless", Value: 0},
+			}, c.gauges)
+		})
+	})
+
+	t.Run("not required", func(t *testing.T) {
+		d := &DockerUtil{cfg: &Config{}}
+		withTestStatsClient(func(c *testStatsClient) {
+			_, err := d.rootlessMode(rootfulInfo, nil)
+			require.NoError(t, err)
+			assert.Empty(t, c.gauges)
+		})
+	})
+}
@@ -0,0 +1,56 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// GetRuntimeClass returns the OCI runtime the container identified by id runs
+// with, such as runc, kata-runtime or runsc (gVisor). It is validated against
+// docker_allowed_runtimes: a container running with another runtime is counted
+// in datadog.docker.container.unapproved_runtime, and a critical alert is
+// logged. An empty list disables the validation.
+func (d *DockerUtil) GetRuntimeClass(ctx context.Context, id string) (string, error) {
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return "", err
+	}
+	return d.runtimeClass(c)
+}
+
+func (d *DockerUtil) runtimeClass(c types.ContainerJSON) (string, error) {
+	if c.ContainerJSONBase == nil || c.HostConfig == nil {
+		return "", errors.New("invalid container: no host config")
+	}
+	runtime := c.HostConfig.Runtime
+	if runtime == "" {
+		return "", errors.New("invalid container: no runtime")
+	}
+	if len(d.cfg.AllowedRuntimes) > 0 && !isAllowedRuntime(runtime, d.cfg.AllowedRuntimes) {
+		count("datadog.docker.container.unapproved_runtime", 1, append(containerTags(c.ID, c.Name), "runtime:"+runtime))
+		log.Criticalf("SECURITY ALERT: container %s (%s) runs with the unapproved runtime %s, allowed runtimes are: %s", strings.TrimPrefix(c.Name, "/"), c.ID, runtime, strings.Join(d.cfg.AllowedRuntimes, ", "))
+	}
+	return runtime, nil
+}
+
+// isAllowedRuntime reports whether runtime is one of the allowed runtimes.
+func isAllowedRuntime(runtime string, allowed []string) bool {
+	for _, a := range allowed {
+		if runtime == a {
+			return true
+		}
+	}
+	return false
+}
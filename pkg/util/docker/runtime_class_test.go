@@ -0,0 +1,70 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRuntimeContainer(id, runtime string) types.ContainerJSON {
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:         id,
+			Name:       "/" + id,
+			HostConfig: &container.HostConfig{Runtime: runtime},
+		},
+	}
+}
+
+func TestRuntimeClass(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		runtime    string
+		allowed    []string
+		unapproved bool
+	}{
+		{"runc", "runc", []string{"runc"}, false},
+		{"kata", "kata-runtime", []string{"runc"}, true},
+		{"gvisor", "runsc", []string{"runc"}, true},
+		{"kata allowed", "kata-runtime", []string{"runc", "kata-runtime"}, false},
+		{"gvisor allowed", "runsc", []string{"runsc"}, false},
+		{"runc not allowed", "runc", []string{"runsc", "kata-runtime"}, true},
+		{"no validation", "runsc", nil, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &DockerUtil{cfg: &Config{AllowedRuntimes: tt.allowed}}
+			withTestStatsClient(func(stats *testStatsClient) {
+				runtime, err := d.runtimeClass(newTestRuntimeContainer("sandbox", tt.runtime))
+				require.NoError(t, err)
+				assert.Equal(t, tt.runtime, runtime)
+				if !tt.unapproved {
+					assert.Empty(t, stats.counts)
+					return
+				}
+				assert.Equal(t, []testStatsSample{{
+					Name:  "datadog.docker.container.unapproved_runtime",
+					Value: 1,
+					Tags:  []string{"container_id:sandbox", "container_name:sandbox", "runtime:" + tt.runtime},
+				}}, stats.counts)
+			})
+		})
+	}
+}
+
+func TestRuntimeClassErrors(t *testing.T) {
+	d := &DockerUtil{cfg: &Config{AllowedRuntimes: []string{"runc"}}}
+	_, err := d.runtimeClass(types.ContainerJSON{})
+	assert.EqualError(t, err, "invalid container: no host config")
+	_, err = d.runtimeClass(newTestRuntimeContainer("sandbox", ""))
+	assert.EqualError(t, err, "invalid container: no runtime")
+}
@@ -0,0 +1,226 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+const (
+	// seccompActLog is the seccomp action allowing a syscall and logging it.
+	seccompActLog = "SCMP_ACT_LOG"
+	// seccompRetLog is the code of the audit records of the syscalls logged by
+	// the SCMP_ACT_LOG action.
+	seccompRetLog = "0x7ffc0000"
+)
+
+// seccompAuditLogPath is the kernel audit log the syscalls logged by seccomp
+// are read from. It is a variable to be replaced in tests.
+var seccompAuditLogPath = "/var/log/audit/audit.log"
+
+// enforcingSeccompActions are the seccomp actions replaced by SCMP_ACT_LOG in
+// audit mode.
+var enforcingSeccompActions = map[string]bool{
+	"SCMP_ACT_KILL":         true,
+	"SCMP_ACT_KILL_PROCESS": true,
+	"SCMP_ACT_KILL_THREAD":  true,
+	seccompActErrno:         true,
+}
+
+// seccompAudits holds the paths of the profiles in audit mode.
+var seccompAudits = struct {
+	sync.Mutex
+	paths map[string]bool
+}{paths: make(map[string]bool)}
+
+// EnableSeccompAuditMode switches the seccomp profile learned for the image of
+// the container identified by id, in docker_learned_seccomp_dir, to audit mode
+// for the given duration: its SCMP_ACT_KILL and SCMP_ACT_ERRNO actions are
+// replaced by SCMP_ACT_LOG, so that the syscalls it would block are logged
+// instead, and the original profile is restored afterwards. As seccomp filters
+// can't be replaced in running processes, audit mode applies to the containers
+// started from the profile during that time. The syscalls logged by the kernel
+// in the audit log during that time are logged, and their number is emitted as
+// the datadog.docker.container.seccomp.audited_syscalls gauge. It blocks until
+// the original profile is restored, and requires docker_allow_seccomp_audit_mode.
+func (d *DockerUtil) EnableSeccompAuditMode(ctx context.Context, id string, duration time.Duration) error {
+	if !d.cfg.AllowSeccompAuditMode {
+		return errors.New("seccomp audit mode is disabled, set docker_allow_seccomp_audit_mode to enable it")
+	}
+	dir := d.cfg.LearnedSeccompDir
+	if dir == "" {
+		return errors.New("no learned seccomp profile directory configured, set docker_learned_seccomp_dir to enable it")
+	}
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return err
+	}
+	if c.ContainerJSONBase == nil {
+		return errors.New("invalid container")
+	}
+	path := filepath.Join(dir, strings.TrimPrefix(c.Image, "sha256:")+".json")
+	logged, err := seccompAuditMode(ctx, path, seccompAuditLogPath, duration, syscallNames)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(logged))
+	for name, count := range logged {
+		names = append(names, fmt.Sprintf("%s(%d)", name, count))
+	}
+	sort.Strings(names)
+	log.Infof("Syscalls logged while the seccomp profile of container %s was in audit mode: %s", c.ID, strings.Join(names, ", "))
+	gauge("datadog.docker.container.seccomp.audited_syscalls", float64(len(logged)), containerTags(c.ID, c.Name))
+	return nil
+}
+
+// seccompAuditMode switches the profile at path to audit mode for duration, or
+// until ctx is done, and returns the number of times each syscall was logged in
+// auditLog meanwhile.
+func seccompAuditMode(ctx context.Context, path, auditLog string, duration time.Duration, names map[int]string) (logged map[string]int, err error) {
+	seccompAudits.Lock()
+	if seccompAudits.paths[path] {
+		seccompAudits.Unlock()
+		return nil, fmt.Errorf("seccomp profile %s is already in audit mode", path)
+	}
+	seccompAudits.paths[path] = true
+	seccompAudits.Unlock()
+	defer func() {
+		seccompAudits.Lock()
+		delete(seccompAudits.paths, path)
+		seccompAudits.Unlock()
+	}()
+
+	original, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	audit, err := auditSeccompProfile(original)
+	if err != nil {
+		return nil, fmt.Errorf("could not switch %s to audit mode: %s", path, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	var offset int64
+	if fi, err := os.Stat(auditLog); err == nil {
+		offset = fi.Size()
+	}
+	if err := ioutil.WriteFile(path, audit, info.Mode().Perm()); err != nil {
+		return nil, err
+	}
+	log.Infof("Audit: seccomp profile %s switched to audit mode for %s", path, duration)
+	defer func() {
+		if restoreErr := ioutil.WriteFile(path, original, info.Mode().Perm()); restoreErr != nil {
+			log.Errorf("Audit: seccomp profile %s could not be restored: %s", path, restoreErr)
+			err = fmt.Errorf("could not restore %s: %s", path, restoreErr)
+			return
+		}
+		log.Infof("Audit: seccomp profile %s restored", path)
+	}()
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return loggedSyscalls(auditLog, offset, names)
+}
+
+// auditSeccompProfile returns the given profile with its enforcing actions
+// replaced by SCMP_ACT_LOG. The other fields of the profile are kept.
+func auditSeccompProfile(raw []byte) ([]byte, error) {
+	var profile map[string]interface{}
+	if err := json.Unmarshal(raw, &profile); err != nil {
+		return nil, fmt.Errorf("invalid seccomp profile: %s", err)
+	}
+	switched := 0
+	// the errno returned is only valid along with SCMP_ACT_ERRNO
+	if action, _ := profile["defaultAction"].(string); enforcingSeccompActions[action] {
+		profile["defaultAction"] = seccompActLog
+		delete(profile, "defaultErrnoRet")
+		switched++
+	}
+	syscalls, _ := profile["syscalls"].([]interface{})
+	for _, s := range syscalls {
+		rule, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if action, _ := rule["action"].(string); enforcingSeccompActions[action] {
+			rule["action"] = seccompActLog
+			delete(rule, "errnoRet")
+			switched++
+		}
+	}
+	if switched == 0 {
+		return nil, errors.New("the seccomp profile blocks no syscall")
+	}
+	return json.MarshalIndent(profile, "", "  ")
+}
+
+// loggedSyscalls returns the number of times each syscall was logged by the
+// SCMP_ACT_LOG seccomp action in the SECCOMP records of the given audit log,
+// from offset on. The log is read from the start when it was rotated.
+func loggedSyscalls(path string, offset int64, names map[int]string) (map[string]int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the audit log: %s", err)
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err == nil && fi.Size() < offset {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	logged := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "type=SECCOMP ") {
+			continue
+		}
+		var code, syscall string
+		for _, field := range strings.Fields(line) {
+			switch {
+			case strings.HasPrefix(field, "code="):
+				code = strings.TrimPrefix(field, "code=")
+			case strings.HasPrefix(field, "syscall="):
+				syscall = strings.TrimPrefix(field, "syscall=")
+			}
+		}
+		nr, err := strconv.Atoi(syscall)
+		if code != seccompRetLog || err != nil {
+			continue
+		}
+		name, ok := names[nr]
+		if !ok {
+			name = "syscall_" + syscall
+		}
+		logged[name]++
+	}
+	return logged, scanner.Err()
+}
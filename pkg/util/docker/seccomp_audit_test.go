@@ -0,0 +1,143 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testEnforcingSeccompProfile = `{
+  "defaultAction": "SCMP_ACT_ERRNO",
+  "defaultErrnoRet": 1,
+  "architectures": ["SCMP_ARCH_X86_64"],
+  "syscalls": [
+    {"names": ["read", "write", "exit_group"], "action": "SCMP_ACT_ALLOW"},
+    {"names": ["ptrace"], "action": "SCMP_ACT_KILL_PROCESS"},
+    {"names": ["mount"], "action": "SCMP_ACT_ERRNO", "errnoRet": 1}
+  ]
+}`
+
+// testSeccompAuditRecord returns a SECCOMP audit record of the given syscall,
+// logged with the given code.
+func testSeccompAuditRecord(syscall, code string) string {
+	return `type=SECCOMP msg=audit(1553005400.123:42): auid=4294967295 uid=0 gid=0 ses=4294967295 pid=1234 comm="app" exe="/app" sig=0 arch=c000003e syscall=` + syscall + ` compat=0 ip=0x7f0 code=` + code + "\n"
+}
+
+func TestSeccompAuditMode(t *testing.T) {
+	tempFolder, err := newTempFolder("test-seccomp-audit")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	require.NoError(t, tempFolder.add("profiles/app.json", testEnforcingSeccompProfile))
+	require.NoError(t, tempFolder.add("audit.log", testSeccompAuditRecord("101", seccompRetLog)))
+	path := filepath.Join(tempFolder.RootPath, "profiles", "app.json")
+	auditLog := filepath.Join(tempFolder.RootPath, "audit.log")
+	names := map[int]string{101: "ptrace", 165: "mount"}
+
+	audited := make(chan map[string]interface{})
+	go func() {
+		// wait for the profile to be switched, then log syscalls
+		for {
+			raw, _ := ioutil.ReadFile(path)
+			var profile map[string]interface{}
+			if json.Unmarshal(raw, &profile) == nil && profile["defaultAction"] == seccompActLog {
+				f, _ := os.OpenFile(auditLog, os.O_APPEND|os.O_WRONLY, 0644)
+				f.WriteString(testSeccompAuditRecord("165", seccompRetLog))
+				f.WriteString(`type=SYSCALL msg=audit(1553005400.123:43): arch=c000003e syscall=165 success=yes` + "\n")
+				f.WriteString(testSeccompAuditRecord("165", seccompRetLog))
+				f.WriteString(testSeccompAuditRecord("101", "0x80000000"))
+				f.WriteString(testSeccompAuditRecord("999", seccompRetLog))
+				f.Close()
+				audited <- profile
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	logged, err := seccompAuditMode(context.Background(), path, auditLog, 200*time.Millisecond, names)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"mount": 2, "syscall_999": 1}, logged)
+
+	profile := <-audited
+	assert.NotContains(t, profile, "defaultErrnoRet")
+	assert.Equal(t, []interface{}{"SCMP_ARCH_X86_64"}, profile["architectures"], "the other fields are kept")
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"names": []interface{}{"read", "write", "exit_group"}, "action": "SCMP_ACT_ALLOW"},
+		map[string]interface{}{"names": []interface{}{"ptrace"}, "action": "SCMP_ACT_LOG"},
+		map[string]interface{}{"names": []interface{}{"mount"}, "action": "SCMP_ACT_LOG"},
+	}, profile["syscalls"])
+
+	restored, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, testEnforcingSeccompProfile, string(restored), "the original profile is restored")
+}
+
+func TestSeccompAuditModeCanceled(t *testing.T) {
+	tempFolder, err := newTempFolder("test-seccomp-audit-canceled")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	require.NoError(t, tempFolder.add("app.json", testEnforcingSeccompProfile))
+	path := filepath.Join(tempFolder.RootPath, "app.json")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		_, err := seccompAuditMode(ctx, path, filepath.Join(tempFolder.RootPath, "audit.log"), time.Hour, nil)
+		errc <- err
+	}()
+	// a profile can't be audited twice at once
+	for i := 0; ; i++ {
+		seccompAudits.Lock()
+		started := seccompAudits.paths[path]
+		seccompAudits.Unlock()
+		if started || i == 1000 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	_, err = seccompAuditMode(ctx, path, "", time.Hour, nil)
+	assert.EqualError(t, err, "seccomp profile "+path+" is already in audit mode")
+	cancel()
+	assert.Equal(t, context.Canceled, <-errc)
+
+	restored, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, testEnforcingSeccompProfile, string(restored))
+}
+
+func TestSeccompAuditModeErrors(t *testing.T) {
+	tempFolder, err := newTempFolder("test-seccomp-audit-errors")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	require.NoError(t, tempFolder.add("allow.json", `{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["ptrace"], "action": "SCMP_ACT_LOG"}]}`))
+	require.NoError(t, tempFolder.add("invalid.json", `{"defaultAction": `))
+
+	audit := func(name string) error {
+		_, err := seccompAuditMode(context.Background(), filepath.Join(tempFolder.RootPath, name), "", time.Millisecond, nil)
+		return err
+	}
+	assert.EqualError(t, audit("allow.json"), "could not switch "+filepath.Join(tempFolder.RootPath, "allow.json")+" to audit mode: the seccomp profile blocks no syscall")
+	if err := audit("invalid.json"); assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid seccomp profile")
+	}
+	assert.True(t, os.IsNotExist(audit("missing.json")))
+
+	d := &DockerUtil{cfg: &Config{LearnedSeccompDir: tempFolder.RootPath}}
+	assert.EqualError(t, d.EnableSeccompAuditMode(context.Background(), "app", time.Minute), "seccomp audit mode is disabled, set docker_allow_seccomp_audit_mode to enable it")
+	d = &DockerUtil{cfg: &Config{AllowSeccompAuditMode: true}}
+	assert.EqualError(t, d.EnableSeccompAuditMode(context.Background(), "app", time.Minute), "no learned seccomp profile directory configured, set docker_learned_seccomp_dir to enable it")
+}
@@ -0,0 +1,234 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+)
+
+// seccompSampleInterval is the interval at which the syscalls of the processes
+// of a container are sampled.
+const seccompSampleInterval = 10 * time.Millisecond
+
+// seccompActAllow is the seccomp action allowing a syscall.
+const seccompActAllow = "SCMP_ACT_ALLOW"
+
+// SeccompGapReport compares the syscalls allowed by the seccomp profile of a
+// container to the ones it uses.
+type SeccompGapReport struct {
+	// AllowedButUnused lists the syscalls allowed by the profile which were
+	// not observed, sorted by name.
+	AllowedButUnused []string
+	// UsedCount is the number of distinct syscalls observed.
+	UsedCount int
+}
+
+// seccompProfile is the part of a Docker seccomp profile needed to find the
+// allowed syscalls.
+type seccompProfile struct {
+	DefaultAction string `json:"defaultAction"`
+	Syscalls      []struct {
+		Name   string   `json:"name"`
+		Names  []string `json:"names"`
+		Action string   `json:"action"`
+	} `json:"syscalls"`
+}
+
+// AnalyzeSeccompGap observes the syscalls made by the processes of the
+// container identified by id during observationDuration, and reports the
+// syscalls allowed by its seccomp profile which were not used. Syscalls are
+// observed by sampling /proc/{pid}/task/{tid}/syscall, so short syscalls may
+// be missed: the longer the observation, the more accurate the report.
+// Containers without a custom profile are compared to the profile found at
+// docker_seccomp_default_profile. The number of unused allowed syscalls is
+// emitted as the datadog.docker.container.seccomp.unused_syscalls gauge.
+func (d *DockerUtil) AnalyzeSeccompGap(ctx context.Context, id string, observationDuration time.Duration) (*SeccompGapReport, error) {
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return nil, err
+	}
+	if c.ContainerJSONBase == nil {
+		return nil, errors.New("invalid container")
+	}
+	allowed, err := d.seccompAllowedSyscalls(c.HostConfig)
+	if err != nil {
+		return nil, err
+	}
+	cgroup, err := containerCgroup(id)
+	if err != nil {
+		return nil, err
+	}
+	pids := make([]int, len(cgroup.Pids))
+	for i, pid := range cgroup.Pids {
+		pids[i] = int(pid)
+	}
+	procRoot := config.Datadog.GetString("container_proc_root")
+	used := observeSyscalls(ctx, procRoot, pids, observationDuration, seccompSampleInterval, syscallNames)
+	return seccompGapReport(c.ID, c.Name, allowed, used), nil
+}
+
+func seccompGapReport(id, name string, allowed []string, used map[string]struct{}) *SeccompGapReport {
+	report := &SeccompGapReport{
+		AllowedButUnused: []string{},
+		UsedCount:        len(used),
+	}
+	for _, syscall := range allowed {
+		if _, ok := used[syscall]; !ok {
+			report.AllowedButUnused = append(report.AllowedButUnused, syscall)
+		}
+	}
+	sort.Strings(report.AllowedButUnused)
+	gauge("datadog.docker.container.seccomp.unused_syscalls", float64(len(report.AllowedButUnused)), containerTags(id, name))
+	return report
+}
+
+// seccompAllowedSyscalls returns the syscalls allowed by the seccomp profile
+// of a container.
+func (d *DockerUtil) seccompAllowedSyscalls(hostConfig *container.HostConfig) ([]string, error) {
+	if !hasSeccompProfile(hostConfig) {
+		return nil, errors.New("the container has no seccomp profile")
+	}
+	var raw []byte
+	if hostConfig != nil {
+		for _, opt := range hostConfig.SecurityOpt {
+			if !strings.HasPrefix(opt, "seccomp=") && !strings.HasPrefix(opt, "seccomp:") {
+				continue
+			}
+			value := opt[len("seccomp="):]
+			if strings.HasPrefix(strings.TrimSpace(value), "{") {
+				raw = []byte(value)
+				break
+			}
+			content, err := ioutil.ReadFile(value)
+			if err != nil {
+				return nil, fmt.Errorf("could not read the seccomp profile of the container: %s", err)
+			}
+			raw = content
+			break
+		}
+	}
+	if raw == nil {
+		if d.cfg.SeccompDefaultProfile == "" {
+			return nil, errors.New("the container uses the default seccomp profile, docker_seccomp_default_profile must be set to analyze it")
+		}
+		content, err := ioutil.ReadFile(d.cfg.SeccompDefaultProfile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the default seccomp profile: %s", err)
+		}
+		raw = content
+	}
+	return allowedSyscalls(raw, syscallNames)
+}
+
+// allowedSyscalls returns the syscalls allowed by the given seccomp profile.
+// When the profile allows all syscalls by default, the allowed syscalls are
+// the known ones, out of names, not denied by the profile.
+func allowedSyscalls(raw []byte, names map[int]string) ([]string, error) {
+	var profile seccompProfile
+	if err := json.Unmarshal(raw, &profile); err != nil {
+		return nil, fmt.Errorf("invalid seccomp profile: %s", err)
+	}
+	rules := make(map[string]bool) // allowed by syscall name
+	for _, rule := range profile.Syscalls {
+		ruleNames := rule.Names
+		if rule.Name != "" {
+			ruleNames = append(ruleNames, rule.Name)
+		}
+		for _, name := range ruleNames {
+			// a syscall allowed under some conditions is allowed
+			rules[name] = rules[name] || rule.Action == seccompActAllow
+		}
+	}
+
+	var allowed []string
+	if profile.DefaultAction == seccompActAllow {
+		if len(names) == 0 {
+			return nil, errors.New("the syscalls allowed by default are unknown on this platform")
+		}
+		for _, name := range names {
+			if isAllowed, ok := rules[name]; !ok || isAllowed {
+				allowed = append(allowed, name)
+			}
+		}
+	} else {
+		for name, isAllowed := range rules {
+			if isAllowed {
+				allowed = append(allowed, name)
+			}
+		}
+	}
+	sort.Strings(allowed)
+	return allowed, nil
+}
+
+// observeSyscalls samples the syscalls of the threads of the given processes
+// every interval, for the given duration or until ctx is done, and returns the
+// names of the syscalls observed.
+func observeSyscalls(ctx context.Context, procRoot string, pids []int, duration, interval time.Duration, names map[int]string) map[string]struct{} {
+	used := make(map[string]struct{})
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, pid := range pids {
+			sampleSyscalls(filepath.Join(procRoot, strconv.Itoa(pid), "task"), names, used)
+		}
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			return used
+		case <-ctx.Done():
+			return used
+		}
+	}
+}
+
+// sampleSyscalls adds the syscalls currently made by the threads found in the
+// given /proc/{pid}/task directory to used.
+func sampleSyscalls(taskDir string, names map[int]string, used map[string]struct{}) {
+	tasks, err := ioutil.ReadDir(taskDir)
+	if err != nil {
+		// the process exited
+		return
+	}
+	for _, task := range tasks {
+		content, err := ioutil.ReadFile(filepath.Join(taskDir, task.Name(), "syscall"))
+		if err != nil {
+			// the thread exited, or the file is not readable
+			continue
+		}
+		// The file starts with the number of the syscall the thread is
+		// blocked in, -1 when not in a syscall and "running" when running.
+		fields := strings.Fields(string(content))
+		if len(fields) == 0 {
+			continue
+		}
+		nr, err := strconv.Atoi(fields[0])
+		if err != nil || nr < 0 {
+			continue
+		}
+		if name, ok := names[nr]; ok {
+			used[name] = struct{}{}
+		}
+	}
+}
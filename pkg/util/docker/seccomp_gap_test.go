@@ -0,0 +1,177 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSyscallNames returns a syscall table of count syscalls named syscall{nr}.
+func testSyscallNames(count int) map[int]string {
+	names := make(map[int]string, count)
+	for nr := 0; nr < count; nr++ {
+		names[nr] = fmt.Sprintf("syscall%d", nr)
+	}
+	return names
+}
+
+// testSeccompProfile returns a profile denying syscalls by default and
+// allowing syscall0 to syscall{allowed-1}.
+func testSeccompProfile(t *testing.T, allowed int) string {
+	var names []string
+	for nr := 0; nr < allowed; nr++ {
+		names = append(names, fmt.Sprintf("syscall%d", nr))
+	}
+	raw, err := json.Marshal(map[string]interface{}{
+		"defaultAction": "SCMP_ACT_ERRNO",
+		"syscalls": []map[string]interface{}{
+			{"names": names, "action": "SCMP_ACT_ALLOW"},
+			{"name": "syscall350", "action": "SCMP_ACT_KILL"},
+		},
+	})
+	require.NoError(t, err)
+	return string(raw)
+}
+
+func TestAnalyzeSeccompGap(t *testing.T) {
+	tempFolder, err := newTempFolder("test-seccomp-gap")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+
+	// 2 processes with 25 threads each, blocked on the even syscalls below 100
+	for i := 0; i < 50; i++ {
+		pid := 10 + i%2
+		tid := pid + 100*i
+		require.NoError(t, tempFolder.add(fmt.Sprintf("%d/task/%d/syscall", pid, tid), fmt.Sprintf("%d 0x3 0x7ffd 0x400 0x0 0x0 0x0 0x7ffd 0x7f12\n", 2*i)))
+	}
+	require.NoError(t, tempFolder.add("10/task/20/syscall", "running\n"))
+	require.NoError(t, tempFolder.add("11/task/21/syscall", "-1 0x7ffd 0x7f12\n"))
+
+	names := testSyscallNames(400)
+	allowed, err := allowedSyscalls([]byte(testSeccompProfile(t, 300)), names)
+	require.NoError(t, err)
+	require.Len(t, allowed, 300)
+
+	used := observeSyscalls(context.Background(), tempFolder.RootPath, []int{10, 11, 12}, 30*time.Millisecond, 5*time.Millisecond, names)
+	assert.Len(t, used, 50)
+
+	withTestStatsClient(func(c *testStatsClient) {
+		report := seccompGapReport("gap", "/app", allowed, used)
+		assert.Equal(t, 50, report.UsedCount)
+		assert.Len(t, report.AllowedButUnused, 250)
+		assert.Contains(t, report.AllowedButUnused, "syscall1")
+		assert.Contains(t, report.AllowedButUnused, "syscall299")
+		assert.NotContains(t, report.AllowedButUnused, "syscall0")
+		assert.NotContains(t, report.AllowedButUnused, "syscall300")
+		assert.Equal(t, []testStatsSample{{
+			Name:  "datadog.docker.container.seccomp.unused_syscalls",
+			Value: 250,
+			Tags:  []string{"container_id:gap", "container_name:app"},
+		}}, c.gauges)
+	})
+}
+
+func TestObserveSyscallsCanceled(t *testing.T) {
+	tempFolder, err := newTempFolder("test-seccomp-cancel")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	require.NoError(t, tempFolder.add("10/task/10/syscall", "7 0x0\n"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	used := observeSyscalls(ctx, tempFolder.RootPath, []int{10}, time.Hour, time.Millisecond, testSyscallNames(10))
+	assert.True(t, time.Since(start) < time.Minute)
+	assert.Equal(t, map[string]struct{}{"syscall7": {}}, used)
+}
+
+func TestAllowedSyscallsDefaultAllow(t *testing.T) {
+	profile := `{
+		"defaultAction": "SCMP_ACT_ALLOW",
+		"syscalls": [
+			{"names": ["syscall1", "syscall2"], "action": "SCMP_ACT_ERRNO"},
+			{"name": "syscall2", "action": "SCMP_ACT_ALLOW", "args": [{"index": 0, "value": 1, "op": "SCMP_CMP_EQ"}]}
+		]
+	}`
+	allowed, err := allowedSyscalls([]byte(profile), testSyscallNames(4))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"syscall0", "syscall2", "syscall3"}, allowed)
+
+	_, err = allowedSyscalls([]byte(profile), map[int]string{})
+	assert.Error(t, err)
+	_, err = allowedSyscalls([]byte("{"), testSyscallNames(4))
+	assert.Error(t, err)
+}
+
+func TestSeccompAllowedSyscalls(t *testing.T) {
+	tempFolder, err := newTempFolder("test-seccomp-profile")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	require.NoError(t, tempFolder.add("default.json", `{"defaultAction": "SCMP_ACT_ERRNO", "syscalls": [{"names": ["read", "write"], "action": "SCMP_ACT_ALLOW"}]}`))
+	require.NoError(t, tempFolder.add("custom.json", `{"defaultAction": "SCMP_ACT_ERRNO", "syscalls": [{"names": ["read"], "action": "SCMP_ACT_ALLOW"}]}`))
+	defaultProfile := filepath.Join(tempFolder.RootPath, "default.json")
+
+	for _, tt := range []struct {
+		name           string
+		defaultProfile string
+		hostConfig     *container.HostConfig
+		allowed        []string
+	}{
+		{
+			name:           "default",
+			defaultProfile: defaultProfile,
+			hostConfig:     &container.HostConfig{SecurityOpt: []string{"label=disable"}},
+			allowed:        []string{"read", "write"},
+		},
+		{
+			name:           "inline",
+			defaultProfile: defaultProfile,
+			hostConfig:     &container.HostConfig{SecurityOpt: []string{`seccomp={"defaultAction": "SCMP_ACT_ERRNO", "syscalls": [{"name": "write", "action": "SCMP_ACT_ALLOW"}]}`}},
+			allowed:        []string{"write"},
+		},
+		{
+			name:       "file",
+			hostConfig: &container.HostConfig{SecurityOpt: []string{"seccomp=" + filepath.Join(tempFolder.RootPath, "custom.json")}},
+			allowed:    []string{"read"},
+		},
+		{
+			name:       "default not configured",
+			hostConfig: &container.HostConfig{},
+		},
+		{
+			name:           "unconfined",
+			defaultProfile: defaultProfile,
+			hostConfig:     &container.HostConfig{SecurityOpt: []string{"seccomp=unconfined"}},
+		},
+		{
+			name:           "privileged",
+			defaultProfile: defaultProfile,
+			hostConfig:     &container.HostConfig{Privileged: true},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &DockerUtil{cfg: &Config{SeccompDefaultProfile: tt.defaultProfile}}
+			allowed, err := d.seccompAllowedSyscalls(tt.hostConfig)
+			if tt.allowed == nil {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.allowed, allowed)
+		})
+	}
+}
@@ -0,0 +1,181 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// seccompActErrno is the seccomp action failing a syscall with an error.
+const seccompActErrno = "SCMP_ACT_ERRNO"
+
+// criticalSyscalls are the syscalls needed by any process to run and exit
+// cleanly, which are rarely caught while observing a container, and which
+// learned profiles always allow.
+var criticalSyscalls = []string{
+	"exit",
+	"exit_group",
+	"futex",
+	"rt_sigreturn",
+}
+
+// SeccompProfile is a seccomp profile in the OCI format, as accepted by
+// `docker run --security-opt seccomp=profile.json`.
+type SeccompProfile struct {
+	DefaultAction string               `json:"defaultAction"`
+	Syscalls      []SeccompSyscallRule `json:"syscalls"`
+}
+
+// SeccompSyscallRule is the action applied to the syscalls of a seccomp
+// profile.
+type SeccompSyscallRule struct {
+	Names  []string `json:"names"`
+	Action string   `json:"action"`
+}
+
+// syscallTracer reports the syscalls made by the traced processes.
+type syscallTracer interface {
+	// Trace returns the names of the syscalls made by the given processes
+	// for the given duration or until ctx is done.
+	Trace(ctx context.Context, pids []int, duration time.Duration) (map[string]struct{}, error)
+}
+
+// syscallProbes traces syscalls with eBPF. It is nil unless the agent is
+// built with eBPF support.
+var syscallProbes syscallTracer
+
+// samplingSyscallTracer observes syscalls by sampling the threads of the
+// processes in procRoot.
+type samplingSyscallTracer struct {
+	procRoot string
+}
+
+// Trace implements syscallTracer.
+func (t samplingSyscallTracer) Trace(ctx context.Context, pids []int, duration time.Duration) (map[string]struct{}, error) {
+	return observeSyscalls(ctx, t.procRoot, pids, duration, seccompSampleInterval, syscallNames), nil
+}
+
+// LearnSeccompProfile observes the syscalls made by the processes of the
+// container identified by id for the given duration, and generates a seccomp
+// profile only allowing them, and the critical syscalls. Syscalls are traced
+// with eBPF when the agent is built with eBPF support, and sampled from
+// /proc/{pid}/task/{tid}/syscall otherwise, which may miss short syscalls. The
+// profile is written to docker_learned_seccomp_dir, named after the image of
+// the container.
+func (d *DockerUtil) LearnSeccompProfile(ctx context.Context, id string, duration time.Duration) (*SeccompProfile, error) {
+	dir := d.cfg.LearnedSeccompDir
+	if dir == "" {
+		return nil, errors.New("no learned seccomp profile directory configured, set docker_learned_seccomp_dir to enable it")
+	}
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return nil, err
+	}
+	if c.ContainerJSONBase == nil {
+		return nil, errors.New("invalid container")
+	}
+	cgroup, err := containerCgroup(id)
+	if err != nil {
+		return nil, err
+	}
+	pids := make([]int, len(cgroup.Pids))
+	for i, pid := range cgroup.Pids {
+		pids[i] = int(pid)
+	}
+	tracer := syscallProbes
+	if tracer == nil {
+		tracer = samplingSyscallTracer{procRoot: config.Datadog.GetString("container_proc_root")}
+	}
+	return learnSeccompProfile(ctx, tracer, pids, duration, filepath.Join(dir, strings.TrimPrefix(c.Image, "sha256:")+".json"))
+}
+
+func learnSeccompProfile(ctx context.Context, tracer syscallTracer, pids []int, duration time.Duration, path string) (*SeccompProfile, error) {
+	if len(pids) == 0 {
+		return nil, errors.New("no pid for this container")
+	}
+	used, err := tracer.Trace(ctx, pids, duration)
+	if err != nil {
+		return nil, fmt.Errorf("could not trace the syscalls of the container: %s", err)
+	}
+	if err := ctx.Err(); err != nil {
+		// the observation was cut short, the profile would be incomplete
+		return nil, err
+	}
+	profile := newSeccompProfile(used)
+	raw, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSeccompProfile(raw); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, raw, 0644); err != nil {
+		return nil, err
+	}
+	log.Infof("Learned a seccomp profile allowing %d syscalls, written to %s", len(profile.Syscalls[0].Names), path)
+	return profile, nil
+}
+
+// newSeccompProfile returns a profile denying all syscalls but the used and
+// the critical ones.
+func newSeccompProfile(used map[string]struct{}) *SeccompProfile {
+	allowed := make(map[string]struct{}, len(used)+len(criticalSyscalls))
+	for name := range used {
+		allowed[name] = struct{}{}
+	}
+	for _, name := range criticalSyscalls {
+		allowed[name] = struct{}{}
+	}
+	names := make([]string, 0, len(allowed))
+	for name := range allowed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return &SeccompProfile{
+		DefaultAction: seccompActErrno,
+		Syscalls:      []SeccompSyscallRule{{Names: names, Action: seccompActAllow}},
+	}
+}
+
+// validateSeccompProfile returns an error if the given profile blocks any of
+// the critical syscalls.
+func validateSeccompProfile(raw []byte) error {
+	allowed, err := allowedSyscalls(raw, syscallNames)
+	if err != nil {
+		return err
+	}
+	isAllowed := make(map[string]bool, len(allowed))
+	for _, name := range allowed {
+		isAllowed[name] = true
+	}
+	var blocked []string
+	for _, name := range criticalSyscalls {
+		if !isAllowed[name] {
+			blocked = append(blocked, name)
+		}
+	}
+	if len(blocked) > 0 {
+		return fmt.Errorf("the seccomp profile blocks critical syscalls: %s", strings.Join(blocked, ", "))
+	}
+	return nil
+}
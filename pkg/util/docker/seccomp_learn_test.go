@@ -0,0 +1,107 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockSyscallTracer reports the same syscalls for all processes.
+type mockSyscallTracer struct {
+	syscalls []string
+	err      error
+	pids     []int
+}
+
+func (t *mockSyscallTracer) Trace(ctx context.Context, pids []int, duration time.Duration) (map[string]struct{}, error) {
+	t.pids = pids
+	used := make(map[string]struct{})
+	for _, name := range t.syscalls {
+		used[name] = struct{}{}
+	}
+	return used, t.err
+}
+
+func TestLearnSeccompProfile(t *testing.T) {
+	tempFolder, err := newTempFolder("test-seccomp-learn")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	path := filepath.Join(tempFolder.RootPath, "profiles", "abc.json")
+
+	tracer := &mockSyscallTracer{syscalls: []string{"read", "write", "epoll_wait", "futex"}}
+	profile, err := learnSeccompProfile(context.Background(), tracer, []int{10, 11}, time.Second, path)
+	require.NoError(t, err)
+	assert.Equal(t, []int{10, 11}, tracer.pids)
+	expected := &SeccompProfile{
+		DefaultAction: "SCMP_ACT_ERRNO",
+		Syscalls: []SeccompSyscallRule{{
+			Names:  []string{"epoll_wait", "exit", "exit_group", "futex", "read", "rt_sigreturn", "write"},
+			Action: "SCMP_ACT_ALLOW",
+		}},
+	}
+	assert.Equal(t, expected, profile)
+
+	raw, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	var written SeccompProfile
+	require.NoError(t, json.Unmarshal(raw, &written))
+	assert.Equal(t, expected, &written)
+	allowed, err := allowedSyscalls(raw, syscallNames)
+	require.NoError(t, err)
+	assert.Equal(t, expected.Syscalls[0].Names, allowed)
+
+	_, err = learnSeccompProfile(context.Background(), tracer, nil, time.Second, path)
+	assert.EqualError(t, err, "no pid for this container")
+
+	_, err = learnSeccompProfile(context.Background(), &mockSyscallTracer{err: errors.New("permission denied")}, []int{10}, time.Second, path)
+	assert.EqualError(t, err, "could not trace the syscalls of the container: permission denied")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = learnSeccompProfile(ctx, tracer, []int{10}, time.Second, path)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestSamplingSyscallTracer(t *testing.T) {
+	tempFolder, err := newTempFolder("test-seccomp-sampling")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	require.NoError(t, tempFolder.add("10/task/10/syscall", "0 0x3 0x7ffd 0x400 0x0 0x0 0x0 0x7ffd 0x7f12\n"))
+	require.NoError(t, tempFolder.add("10/task/12/syscall", "running\n"))
+
+	used, err := samplingSyscallTracer{procRoot: tempFolder.RootPath}.Trace(context.Background(), []int{10}, 20*time.Millisecond)
+	require.NoError(t, err)
+	if len(syscallNames) > 0 {
+		assert.Equal(t, map[string]struct{}{"read": {}}, used)
+	} else {
+		assert.Empty(t, used)
+	}
+}
+
+func TestValidateSeccompProfile(t *testing.T) {
+	assert.NoError(t, validateSeccompProfile([]byte(`{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["ptrace"], "action": "SCMP_ACT_ERRNO"}]}`)))
+	assert.NoError(t, validateSeccompProfile([]byte(`{"defaultAction": "SCMP_ACT_ERRNO", "syscalls": [{"names": ["exit", "exit_group", "futex", "rt_sigreturn"], "action": "SCMP_ACT_ALLOW"}]}`)))
+	assert.EqualError(t, validateSeccompProfile([]byte(`{"defaultAction": "SCMP_ACT_ERRNO", "syscalls": [{"names": ["read", "exit", "exit_group"], "action": "SCMP_ACT_ALLOW"}]}`)),
+		"the seccomp profile blocks critical syscalls: futex, rt_sigreturn")
+	assert.Error(t, validateSeccompProfile([]byte(`{`)))
+}
+
+func TestLearnSeccompProfileDisabled(t *testing.T) {
+	d := &DockerUtil{cfg: &Config{}}
+	_, err := d.LearnSeccompProfile(context.Background(), "app", time.Second)
+	assert.EqualError(t, err, "no learned seccomp profile directory configured, set docker_learned_seccomp_dir to enable it")
+}
@@ -0,0 +1,151 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	containersapi "github.com/containerd/containerd/api/services/containers/v1"
+	snapshotsapi "github.com/containerd/containerd/api/services/snapshots/v1"
+	containerdnamespaces "github.com/containerd/containerd/namespaces"
+	"google.golang.org/grpc"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// snapshotKinds are the names of the kinds of containerd snapshots.
+var snapshotKinds = map[snapshotsapi.Kind]string{
+	snapshotsapi.KindView:      "View",
+	snapshotsapi.KindActive:    "Active",
+	snapshotsapi.KindCommitted: "Committed",
+}
+
+// SnapshotUsage is the disk usage of a containerd snapshot. A snapshot is in
+// use when it is the root filesystem of a container, or one of its parents.
+type SnapshotUsage struct {
+	Key   string
+	Kind  string
+	Size  int64
+	InUse bool
+}
+
+// GetContainerdSnapshotUsage returns the disk usage of the snapshots of the
+// containerd daemon backing the Docker daemon, as listed by its snapshotter.
+// Their total size is sent as datadog.docker.containerd.snapshot_bytes, and
+// the removal of the snapshots not in use is suggested in the logs.
+func (d *DockerUtil) GetContainerdSnapshotUsage(ctx context.Context) ([]SnapshotUsage, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, d.queryTimeout)
+	defer cancel()
+	dialer := func(socketPath string, timeout time.Duration) (net.Conn, error) {
+		return net.DialTimeout("unix", socketPath, timeout)
+	}
+	conn, err := grpc.DialContext(dialCtx, d.cfg.ContainerdSocketPath, grpc.WithInsecure(), grpc.WithBlock(), grpc.WithDialer(dialer))
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to containerd at %s: %v", d.cfg.ContainerdSocketPath, err)
+	}
+	defer conn.Close()
+
+	ctx = containerdnamespaces.WithNamespace(ctx, d.cfg.ContainerdNamespace)
+	usages, err := containerdSnapshotUsage(ctx, snapshotsapi.NewSnapshotsClient(conn), containersapi.NewContainersClient(conn), d.cfg.ContainerdSnapshotter)
+	if err != nil {
+		return nil, err
+	}
+	if suggestion := snapshotCleanupSuggestion(usages, d.cfg.ContainerdNamespace, d.cfg.ContainerdSnapshotter); suggestion != "" {
+		log.Info(suggestion)
+	}
+	return usages, nil
+}
+
+func containerdSnapshotUsage(ctx context.Context, snapshots snapshotsapi.SnapshotsClient, containers containersapi.ContainersClient, snapshotter string) ([]SnapshotUsage, error) {
+	stream, err := snapshots.List(ctx, &snapshotsapi.ListSnapshotsRequest{Snapshotter: snapshotter})
+	if err != nil {
+		return nil, err
+	}
+	var infos []snapshotsapi.Info
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, resp.Info...)
+	}
+
+	ctrs, err := containers.List(ctx, &containersapi.ListContainersRequest{})
+	if err != nil {
+		return nil, err
+	}
+	parents := make(map[string]string, len(infos))
+	for _, info := range infos {
+		parents[info.Name] = info.Parent
+	}
+	inUse := make(map[string]bool)
+	for _, c := range ctrs.Containers {
+		if c.Snapshotter != snapshotter {
+			continue
+		}
+		for key := c.SnapshotKey; key != "" && !inUse[key]; key = parents[key] {
+			inUse[key] = true
+		}
+	}
+
+	usages := make([]SnapshotUsage, 0, len(infos))
+	var total int64
+	for _, info := range infos {
+		resp, err := snapshots.Usage(ctx, &snapshotsapi.UsageRequest{Snapshotter: snapshotter, Key: info.Name})
+		if err != nil {
+			log.Debugf("Could not get the usage of snapshot %s: %v", info.Name, err)
+			continue
+		}
+		kind, ok := snapshotKinds[info.Kind]
+		if !ok {
+			kind = "Unknown"
+		}
+		usages = append(usages, SnapshotUsage{
+			Key:   info.Name,
+			Kind:  kind,
+			Size:  resp.Size_,
+			InUse: inUse[info.Name],
+		})
+		total += resp.Size_
+	}
+	gauge("datadog.docker.containerd.snapshot_bytes", float64(total), []string{"snapshotter:" + snapshotter})
+	return usages, nil
+}
+
+// snapshotCleanupSuggestion returns the commands removing the active and view
+// snapshots not in use, which are usually left over by removed containers, or
+// an empty string if there is none. Committed snapshots not in use are left
+// out as they may hold the layers of images, which are to be pruned instead.
+func snapshotCleanupSuggestion(usages []SnapshotUsage, namespace, snapshotter string) string {
+	var (
+		keys  []string
+		total int64
+	)
+	for _, u := range usages {
+		if u.InUse || u.Kind == "Committed" {
+			continue
+		}
+		keys = append(keys, u.Key)
+		total += u.Size
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	sort.Strings(keys)
+	return fmt.Sprintf("%d containerd snapshots are not used by any container, using %d bytes. They can be removed with: ctr -n %s snapshots --snapshotter %s rm %s",
+		len(keys), total, namespace, snapshotter, strings.Join(keys, " "))
+}
@@ -0,0 +1,115 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	containersapi "github.com/containerd/containerd/api/services/containers/v1"
+	snapshotsapi "github.com/containerd/containerd/api/services/snapshots/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// fakeSnapshotsClient lists the given snapshots, in two responses.
+type fakeSnapshotsClient struct {
+	snapshotsapi.SnapshotsClient
+	infos []snapshotsapi.Info
+	sizes map[string]int64
+}
+
+func (c *fakeSnapshotsClient) List(ctx context.Context, in *snapshotsapi.ListSnapshotsRequest, opts ...grpc.CallOption) (snapshotsapi.Snapshots_ListClient, error) {
+	half := len(c.infos) / 2
+	return &fakeSnapshotsListClient{resps: []*snapshotsapi.ListSnapshotsResponse{
+		{Info: c.infos[:half]},
+		{Info: c.infos[half:]},
+	}}, nil
+}
+
+func (c *fakeSnapshotsClient) Usage(ctx context.Context, in *snapshotsapi.UsageRequest, opts ...grpc.CallOption) (*snapshotsapi.UsageResponse, error) {
+	size, ok := c.sizes[in.Key]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return &snapshotsapi.UsageResponse{Size_: size}, nil
+}
+
+type fakeSnapshotsListClient struct {
+	grpc.ClientStream
+	resps []*snapshotsapi.ListSnapshotsResponse
+}
+
+func (c *fakeSnapshotsListClient) Recv() (*snapshotsapi.ListSnapshotsResponse, error) {
+	if len(c.resps) == 0 {
+		return nil, io.EOF
+	}
+	resp := c.resps[0]
+	c.resps = c.resps[1:]
+	return resp, nil
+}
+
+type fakeContainersClient struct {
+	containersapi.ContainersClient
+	containers []containersapi.Container
+}
+
+func (c *fakeContainersClient) List(ctx context.Context, in *containersapi.ListContainersRequest, opts ...grpc.CallOption) (*containersapi.ListContainersResponse, error) {
+	return &containersapi.ListContainersResponse{Containers: c.containers}, nil
+}
+
+func TestContainerdSnapshotUsage(t *testing.T) {
+	snapshots := &fakeSnapshotsClient{
+		infos: []snapshotsapi.Info{
+			{Name: "sha256:base", Kind: snapshotsapi.KindCommitted},
+			{Name: "sha256:app", Parent: "sha256:base", Kind: snapshotsapi.KindCommitted},
+			{Name: "web", Parent: "sha256:app", Kind: snapshotsapi.KindActive},
+			{Name: "sha256:old", Kind: snapshotsapi.KindCommitted},
+			{Name: "removed", Parent: "sha256:old", Kind: snapshotsapi.KindActive},
+			{Name: "debug", Parent: "sha256:base", Kind: snapshotsapi.KindView},
+			{Name: "gone", Kind: snapshotsapi.KindActive},
+		},
+		sizes: map[string]int64{
+			"sha256:base": 50 << 20,
+			"sha256:app":  10 << 20,
+			"web":         1 << 20,
+			"sha256:old":  20 << 20,
+			"removed":     2 << 20,
+			"debug":       4096,
+		},
+	}
+	containers := &fakeContainersClient{containers: []containersapi.Container{
+		{ID: "web", Snapshotter: "overlayfs", SnapshotKey: "web"},
+		{ID: "other", Snapshotter: "native", SnapshotKey: "sha256:old"},
+	}}
+
+	withTestStatsClient(func(stats *testStatsClient) {
+		usages, err := containerdSnapshotUsage(context.Background(), snapshots, containers, "overlayfs")
+		require.NoError(t, err)
+		assert.Equal(t, []SnapshotUsage{
+			{Key: "sha256:base", Kind: "Committed", Size: 50 << 20, InUse: true},
+			{Key: "sha256:app", Kind: "Committed", Size: 10 << 20, InUse: true},
+			{Key: "web", Kind: "Active", Size: 1 << 20, InUse: true},
+			{Key: "sha256:old", Kind: "Committed", Size: 20 << 20},
+			{Key: "removed", Kind: "Active", Size: 2 << 20},
+			{Key: "debug", Kind: "View", Size: 4096},
+		}, usages)
+		assert.Equal(t, []testStatsSample{{
+			Name:  "datadog.docker.containerd.snapshot_bytes",
+			Value: 83<<20 + 4096,
+			Tags:  []string{"snapshotter:overlayfs"},
+		}}, stats.gauges)
+
+		assert.Equal(t, "2 containerd snapshots are not used by any container, using 2101248 bytes. They can be removed with: ctr -n moby snapshots --snapshotter overlayfs rm debug removed",
+			snapshotCleanupSuggestion(usages, "moby", "overlayfs"))
+		assert.Empty(t, snapshotCleanupSuggestion(usages[:4], "moby", "overlayfs"))
+	})
+}
@@ -0,0 +1,11 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker,!linux docker,!amd64
+
+package docker
+
+// syscallNames is only available on linux/amd64.
+var syscallNames = map[int]string{}
@@ -0,0 +1,105 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// tcpStates maps the hexadecimal connection states found in /proc/net/tcp to
+// their names, as defined in include/net/tcp_states.h.
+var tcpStates = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSE",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+}
+
+// GetTCPStateDistribution returns the number of TCP connections of the container
+// identified by id, by connection state (ESTABLISHED, TIME_WAIT, CLOSE_WAIT...).
+// Counts are reported as the datadog.docker.container.tcp.connections gauge,
+// tagged by state.
+func (d *DockerUtil) GetTCPStateDistribution(ctx context.Context, id string) (map[string]int, error) {
+	pid, err := d.containerPID(id)
+	if err != nil {
+		return nil, err
+	}
+	return d.tcpStateDistribution(id, pid)
+}
+
+// tcpStateDistribution computes the TCP state distribution of the network
+// namespace of the given process, from its net/tcp and net/tcp6 files.
+func (d *DockerUtil) tcpStateDistribution(id string, pid int) (map[string]int, error) {
+	netDir := filepath.Join(config.Datadog.GetString("container_proc_root"), strconv.Itoa(pid), "net")
+	states := make(map[string]int)
+	found := false
+	for _, name := range []string{"tcp", "tcp6"} {
+		err := countTCPStates(filepath.Join(netDir, name), states)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = true
+	}
+	if !found {
+		return nil, fmt.Errorf("no TCP connection table found in %s", netDir)
+	}
+
+	tags := []string{"container_id:" + id}
+	for state, n := range states {
+		gauge("datadog.docker.container.tcp.connections", float64(n), append(tags, "state:"+strings.ToLower(state)))
+	}
+	if max := d.cfg.MaxCloseWaitConnections; max > 0 && states["CLOSE_WAIT"] > max {
+		log.Warnf("Container %s has %d connections in CLOSE_WAIT state (max %d), it may be leaking connections", id, states["CLOSE_WAIT"], max)
+	}
+	return states, nil
+}
+
+// countTCPStates adds the number of connections by state found in the given
+// /proc/net/tcp formatted file to states.
+func countTCPStates(path string, states map[string]int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // skip the header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		state, ok := tcpStates[strings.ToUpper(fields[3])]
+		if !ok {
+			log.Debugf("Unknown TCP state %q in %s", fields[3], path)
+			continue
+		}
+		states[state]++
+	}
+	return scanner.Err()
+}
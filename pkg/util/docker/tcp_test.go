@@ -0,0 +1,70 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+)
+
+func TestTCPStateDistribution(t *testing.T) {
+	dummyProcDir, err := newTempFolder("test-tcp-states")
+	require.NoError(t, err)
+	defer dummyProcDir.removeAll()
+	config.Datadog.SetDefault("container_proc_root", dummyProcDir.RootPath)
+	defer config.Datadog.SetDefault("container_proc_root", "/proc")
+
+	require.NoError(t, dummyProcDir.add("10/net/tcp", detab(`
+		sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+		0: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 21178 1 0000000000000000 100 0 0 10 0
+		1: 0100007F:1F90 0100007F:A2C4 01 00000000:00000000 00:00000000 00000000     0        0 21179 1 0000000000000000 20 4 30 10 -1
+		2: 0100007F:1F90 0100007F:A2C6 01 00000000:00000000 00:00000000 00000000     0        0 21180 1 0000000000000000 20 4 30 10 -1
+		3: 0100007F:1F90 0100007F:A2C8 08 00000000:00000000 00:00000000 00000000     0        0 21181 1 0000000000000000 20 4 30 10 -1
+		4: 0100007F:1F90 0100007F:A2CA 08 00000000:00000000 00:00000000 00000000     0        0 21182 1 0000000000000000 20 4 30 10 -1
+		5: 0100007F:1F90 0100007F:A2CC 06 00000000:00000000 00:00000000 00000000     0        0 0 1 0000000000000000 20 4 30 10 -1
+	`)))
+	require.NoError(t, dummyProcDir.add("10/net/tcp6", detab(`
+		sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+		0: 00000000000000000000000000000000:0016 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 16537 1 0000000000000000 100 0 0 10 0
+		1: 0000000000000000FFFF00000100007F:0016 0000000000000000FFFF00000100007F:D2B4 08 00000000:00000000 00:00000000 00000000     0        0 16538 1 0000000000000000 20 4 30 10 -1
+	`)))
+	require.NoError(t, dummyProcDir.add("20/net/tcp", "  sl  local_address rem_address   st tx_queue rx_queue\n"))
+
+	d := &DockerUtil{cfg: &Config{MaxCloseWaitConnections: 2}}
+
+	withTestStatsClient(func(c *testStatsClient) {
+		states, err := d.tcpStateDistribution("abc", 10)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int{
+			"LISTEN":      2,
+			"ESTABLISHED": 2,
+			"CLOSE_WAIT":  3,
+			"TIME_WAIT":   1,
+		}, states)
+
+		assert.Len(t, c.gauges, 4)
+		for _, g := range c.gauges {
+			assert.Equal(t, "datadog.docker.container.tcp.connections", g.Name)
+			assert.Contains(t, g.Tags, "container_id:abc")
+			if g.Tags[1] == "state:close_wait" {
+				assert.Equal(t, 3.0, g.Value)
+			}
+		}
+	})
+
+	states, err := d.tcpStateDistribution("def", 20)
+	require.NoError(t, err)
+	assert.Empty(t, states)
+
+	_, err = d.tcpStateDistribution("ghi", 30)
+	assert.Error(t, err)
+}
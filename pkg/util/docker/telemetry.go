@@ -0,0 +1,77 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/DataDog/datadog-go/statsd"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+)
+
+// StatsClient represents a client capable of sending stats to some stat endpoint.
+type StatsClient interface {
+	Gauge(name string, value float64, tags []string, rate float64) error
+	Count(name string, value int64, tags []string, rate float64) error
+	Histogram(name string, value float64, tags []string, rate float64) error
+}
+
+// Statsd is the client used by DockerUtil to report the metrics it computes.
+// It is set to a client of the DogStatsD server of the agent when DockerUtil
+// is initialized. When unset, no metrics are sent.
+var Statsd StatsClient
+
+// configureStatsd sets Statsd to a client of the DogStatsD server of the agent,
+// unless a client is already set or DogStatsD is disabled.
+func configureStatsd() error {
+	if Statsd != nil {
+		return nil
+	}
+	port := config.Datadog.GetInt("dogstatsd_port")
+	if port == 0 {
+		return nil
+	}
+	client, err := statsd.New(fmt.Sprintf("%s:%d", config.Datadog.GetString("bind_host"), port))
+	if err != nil {
+		return err
+	}
+	Statsd = client
+	return nil
+}
+
+// gauge calls Gauge on the Statsd client, if set.
+func gauge(name string, value float64, tags []string) {
+	if Statsd == nil {
+		return // no-op
+	}
+	Statsd.Gauge(name, value, tags, 1)
+}
+
+// count calls Count on the Statsd client, if set.
+func count(name string, value int64, tags []string) {
+	if Statsd == nil {
+		return // no-op
+	}
+	Statsd.Count(name, value, tags, 1)
+}
+
+// histogram calls Histogram on the Statsd client, if set.
+func histogram(name string, value float64, tags []string) {
+	if Statsd == nil {
+		return // no-op
+	}
+	Statsd.Histogram(name, value, tags, 1)
+}
+
+// containerTags returns the tags used when reporting metrics about a container.
+// The leading slash of names returned by the inspect API is removed.
+func containerTags(id, name string) []string {
+	return []string{"container_id:" + id, "container_name:" + strings.TrimPrefix(name, "/")}
+}
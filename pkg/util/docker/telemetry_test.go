@@ -0,0 +1,94 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+)
+
+// testStatsSample holds a metric reported to a testStatsClient.
+type testStatsSample struct {
+	Name  string
+	Value float64
+	Tags  []string
+}
+
+// testStatsClient is a StatsClient recording all reported metrics.
+type testStatsClient struct {
+	mu         sync.Mutex
+	gauges     []testStatsSample
+	counts     []testStatsSample
+	histograms []testStatsSample
+}
+
+func (c *testStatsClient) Gauge(name string, value float64, tags []string, rate float64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gauges = append(c.gauges, testStatsSample{Name: name, Value: value, Tags: tags})
+	return nil
+}
+
+func (c *testStatsClient) Count(name string, value int64, tags []string, rate float64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts = append(c.counts, testStatsSample{Name: name, Value: float64(value), Tags: tags})
+	return nil
+}
+
+func (c *testStatsClient) Histogram(name string, value float64, tags []string, rate float64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.histograms = append(c.histograms, testStatsSample{Name: name, Value: value, Tags: tags})
+	return nil
+}
+
+// withTestStatsClient replaces Statsd with a testStatsClient for the duration of fn.
+func withTestStatsClient(fn func(c *testStatsClient)) {
+	old := Statsd
+	defer func() { Statsd = old }()
+	c := &testStatsClient{}
+	Statsd = c
+	fn(c)
+}
+
+func TestConfigureStatsd(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	mockConfig := config.Mock()
+	mockConfig.Set("bind_host", "127.0.0.1")
+	mockConfig.Set("dogstatsd_port", conn.LocalAddr().(*net.UDPAddr).Port)
+
+	old := Statsd
+	defer func() { Statsd = old }()
+	Statsd = nil
+	require.NoError(t, configureStatsd())
+	require.NotNil(t, Statsd)
+
+	gauge("datadog.docker.container.test", 2, containerTags("abc", "/web"))
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "datadog.docker.container.test:2|g|#container_id:abc,container_name:web", string(buf[:n]))
+
+	// DogStatsD disabled
+	Statsd = nil
+	mockConfig.Set("dogstatsd_port", 0)
+	require.NoError(t, configureStatsd())
+	assert.Nil(t, Statsd)
+}
@@ -0,0 +1,170 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// serviceAccountLabel is the container label holding its pod's service account.
+	serviceAccountLabel = "io.kubernetes.pod.serviceaccount"
+	// podNamespaceLabel is the container label holding its pod's namespace.
+	podNamespaceLabel = "io.kubernetes.pod.namespace"
+)
+
+// in-cluster credentials of the agent, replaced in tests.
+var (
+	kubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	kubernetesCAPath    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// WorkloadIdentityInfo describes the service account a container runs as.
+type WorkloadIdentityInfo struct {
+	ServiceAccount string
+	Namespace      string
+	// HasExpectedAnnotations is true when the service account has all the
+	// configured expected annotations.
+	HasExpectedAnnotations bool
+}
+
+// kubernetesServiceAccount is the subset of the Kubernetes ServiceAccount object
+// used to validate workload identities.
+type kubernetesServiceAccount struct {
+	Metadata struct {
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+}
+
+// ValidateWorkloadIdentity checks that the service account of the container
+// identified by id, as found in its io.kubernetes.pod.serviceaccount label, exists
+// in the Kubernetes API and has the expected annotations.
+func (d *DockerUtil) ValidateWorkloadIdentity(ctx context.Context, id string) (*WorkloadIdentityInfo, error) {
+	c, err := d.Inspect(id, false)
+	if err != nil {
+		return nil, err
+	}
+	if c.Config == nil {
+		return nil, fmt.Errorf("no configuration found for container %s", id)
+	}
+	return d.validateWorkloadIdentity(ctx, id, c.Config.Labels)
+}
+
+// validateWorkloadIdentity validates the workload identity of a container, given its labels.
+func (d *DockerUtil) validateWorkloadIdentity(ctx context.Context, id string, labels map[string]string) (*WorkloadIdentityInfo, error) {
+	info := &WorkloadIdentityInfo{
+		ServiceAccount: labels[serviceAccountLabel],
+		Namespace:      labels[podNamespaceLabel],
+	}
+	if info.ServiceAccount == "" || info.Namespace == "" {
+		return nil, fmt.Errorf("container %s has no service account label", id)
+	}
+	sa, err := d.getServiceAccount(ctx, info.Namespace, info.ServiceAccount)
+	if err != nil {
+		return nil, err
+	}
+	info.HasExpectedAnnotations = true
+	for k, v := range d.cfg.ExpectedServiceAccountAnnotations {
+		if sa.Metadata.Annotations[k] != v {
+			info.HasExpectedAnnotations = false
+			break
+		}
+	}
+	return info, nil
+}
+
+// getServiceAccount fetches a service account from the Kubernetes API.
+func (d *DockerUtil) getServiceAccount(ctx context.Context, namespace, name string) (*kubernetesServiceAccount, error) {
+	var sa kubernetesServiceAccount
+	path := fmt.Sprintf("/api/v1/namespaces/%s/serviceaccounts/%s", url.PathEscape(namespace), url.PathEscape(name))
+	status, err := d.queryKubernetesAPI(ctx, path, &sa)
+	switch {
+	case status == http.StatusNotFound:
+		return nil, fmt.Errorf("service account %s/%s does not exist", namespace, name)
+	case err != nil:
+		return nil, fmt.Errorf("error getting service account %s/%s: %s", namespace, name, err)
+	}
+	return &sa, nil
+}
+
+// queryKubernetesAPI gets the object at the given path of the Kubernetes API
+// and decodes it into v. It returns the status code of the response, if any.
+func (d *DockerUtil) queryKubernetesAPI(ctx context.Context, path string, v interface{}) (int, error) {
+	resp, err := d.kubernetesAPIRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("unexpected Kubernetes API response: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return resp.StatusCode, fmt.Errorf("error decoding Kubernetes API response: %s", err)
+	}
+	return resp.StatusCode, nil
+}
+
+// kubernetesAPIRequest sends a request to the given path of the Kubernetes API,
+// authenticated with the in-cluster token of the agent, if any.
+func (d *DockerUtil) kubernetesAPIRequest(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	client, err := d.kubernetesAPIClient()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(d.cfg.KubernetesAPIURL, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if token, err := ioutil.ReadFile(kubernetesTokenPath); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error querying the Kubernetes API: %s", err)
+	}
+	return resp, nil
+}
+
+// kubernetesAPIClient returns the HTTP client of the Kubernetes API, built on
+// the first call so that its connections are reused.
+func (d *DockerUtil) kubernetesAPIClient() (*http.Client, error) {
+	d.kubernetesClientOnce.Do(func() {
+		d.kubernetesClient, d.kubernetesClientErr = newKubernetesAPIClient(d.queryTimeout)
+	})
+	return d.kubernetesClient, d.kubernetesClientErr
+}
+
+// newKubernetesAPIClient returns an HTTP client trusting the in-cluster CA, if
+// any.
+func newKubernetesAPIClient(timeout time.Duration) (*http.Client, error) {
+	client := &http.Client{Timeout: timeout}
+	ca, err := ioutil.ReadFile(kubernetesCAPath)
+	if err != nil {
+		// not running in a cluster, rely on the system CAs
+		return client, nil
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("invalid Kubernetes CA certificate in %s", kubernetesCAPath)
+	}
+	client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	return client, nil
+}
@@ -0,0 +1,97 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2019 Datadog, Inc.
+
+// +build docker
+
+package docker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateWorkloadIdentity(t *testing.T) {
+	tempFolder, err := newTempFolder("test-workload-identity")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	require.NoError(t, tempFolder.add("token", "secret-token\n"))
+	defer func(token, ca string) {
+		kubernetesTokenPath, kubernetesCAPath = token, ca
+	}(kubernetesTokenPath, kubernetesCAPath)
+	kubernetesTokenPath = filepath.Join(tempFolder.RootPath, "token")
+	kubernetesCAPath = filepath.Join(tempFolder.RootPath, "missing.crt")
+
+	// mock Kubernetes API
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/namespaces/prod/serviceaccounts/", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret-token", r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/api/v1/namespaces/prod/serviceaccounts/billing":
+			w.Write([]byte(`{"kind":"ServiceAccount","metadata":{"name":"billing","namespace":"prod","annotations":{"iam.gke.io/gcp-service-account":"billing@project.iam","team":"payments"}}}`))
+		case "/api/v1/namespaces/prod/serviceaccounts/default":
+			w.Write([]byte(`{"kind":"ServiceAccount","metadata":{"name":"default","namespace":"prod"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	d := &DockerUtil{
+		queryTimeout: time.Second,
+		cfg: &Config{
+			KubernetesAPIURL: srv.URL,
+			ExpectedServiceAccountAnnotations: map[string]string{
+				"iam.gke.io/gcp-service-account": "billing@project.iam",
+			},
+		},
+	}
+	labels := func(sa string) map[string]string {
+		return map[string]string{serviceAccountLabel: sa, podNamespaceLabel: "prod"}
+	}
+
+	info, err := d.validateWorkloadIdentity(context.Background(), "abc", labels("billing"))
+	require.NoError(t, err)
+	assert.Equal(t, &WorkloadIdentityInfo{ServiceAccount: "billing", Namespace: "prod", HasExpectedAnnotations: true}, info)
+
+	info, err = d.validateWorkloadIdentity(context.Background(), "abc", labels("default"))
+	require.NoError(t, err)
+	assert.False(t, info.HasExpectedAnnotations)
+
+	_, err = d.validateWorkloadIdentity(context.Background(), "abc", labels("unknown"))
+	assert.Error(t, err)
+
+	_, err = d.validateWorkloadIdentity(context.Background(), "abc", map[string]string{})
+	assert.Error(t, err)
+}
+
+func TestKubernetesAPIClient(t *testing.T) {
+	tempFolder, err := newTempFolder("test-kubernetes-client")
+	require.NoError(t, err)
+	defer tempFolder.removeAll()
+	require.NoError(t, tempFolder.add("invalid.crt", "not a certificate"))
+	defer func(ca string) { kubernetesCAPath = ca }(kubernetesCAPath)
+	kubernetesCAPath = filepath.Join(tempFolder.RootPath, "missing.crt")
+
+	d := &DockerUtil{queryTimeout: time.Second}
+	client, err := d.kubernetesAPIClient()
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, client.Timeout)
+	// the client is built once, and reused
+	kubernetesCAPath = filepath.Join(tempFolder.RootPath, "invalid.crt")
+	reused, err := d.kubernetesAPIClient()
+	require.NoError(t, err)
+	assert.True(t, client == reused)
+
+	_, err = (&DockerUtil{}).kubernetesAPIClient()
+	assert.Error(t, err)
+}
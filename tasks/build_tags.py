@@ -22,6 +22,8 @@ ALL_TAGS = set([
     "kubelet",
     "log",
     "netcgo", # Force the use of the CGO resolver. This will also have the effect of making the binary non-static
+    "nvml", # Read the GPUs of containers through NVML, loaded at runtime when the NVIDIA driver is installed
+    "onnx", # Score the importance of spans with the ONNX runtime, loaded at runtime when a model is configured
     "process",
     "systemd",
     "zk",
@@ -41,6 +43,8 @@ LINUX_ONLY_TAGS = [
     "cri",
     "containerd",
     "netcgo",
+    "nvml",
+    "onnx",
 ]
 
 REDHAT_AND_DEBIAN_ONLY_TAGS = [